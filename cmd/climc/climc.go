@@ -20,8 +20,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/c-bata/go-prompt"
@@ -68,7 +70,8 @@ type BaseOptions struct {
 	OsZoneName     string `default:"$OS_ZONE_NAME" help:"Defaults to env[OS_ZONE_NAME]"`
 	OsEndpointType string `default:"$OS_ENDPOINT_TYPE|internalURL" help:"Defaults to env[OS_ENDPOINT_TYPE] or internalURL" choices:"publicURL|internalURL|adminURL"`
 	ApiVersion     string `default:"$API_VERSION" help:"override default modules service api version"`
-	OutputFormat   string `default:"$CLIMC_OUTPUT_FORMAT|table" choices:"table|kv|json|flatten-table|flatten-kv" help:"output format"`
+	OutputFormat   string `default:"$CLIMC_OUTPUT_FORMAT|table" choices:"table|kv|json|yaml|csv|flatten-table|flatten-kv" help:"output format"`
+	Field          string `default:"$CLIMC_OUTPUT_FIELD" help:"Comma separated fields to output, defaults to the columns of the command"`
 	SUBCOMMAND     string `help:"climc subcommand" subcommand:"true"`
 }
 
@@ -127,6 +130,22 @@ func showErrorAndExit(e error) {
 	os.Exit(1)
 }
 
+// signalContext returns a context cancelled on the first SIGINT or
+// SIGTERM, so Ctrl-C aborts in-flight requests instead of leaving them
+// hanging until the client timeout. After the first signal the handler
+// is removed, so a second Ctrl-C terminates the process as usual.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+		signal.Stop(sigCh)
+	}()
+	return ctx
+}
+
 func newClientSession(options *BaseOptions) (*mcclient.ClientSession, error) {
 	if len(options.OsAuthURL) == 0 {
 		return nil, fmt.Errorf("Missing OS_AUTH_URL")
@@ -218,7 +237,7 @@ func newClientSession(options *BaseOptions) (*mcclient.ClientSession, error) {
 		mcclient.DisableApiVersionByModule()
 	}
 	session := client.NewSession(
-		context.Background(),
+		signalContext(),
 		options.OsRegionName,
 		options.OsZoneName,
 		options.OsEndpointType,
@@ -288,6 +307,7 @@ func main() {
 	}
 
 	shell.OutputFormat(options.OutputFormat)
+	shell.OutputFields(options.Field)
 	ensureSessionFactory := func() *mcclient.ClientSession {
 		session, err := newClientSession(options)
 		if err != nil {
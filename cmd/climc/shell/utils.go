@@ -15,6 +15,7 @@
 package shell
 
 import (
+	"encoding/csv"
 	"fmt"
 	"os"
 	"sort"
@@ -31,6 +32,8 @@ const (
 	OUTPUT_FORMAT_TABLE         = "table"         // pretty table
 	OUTPUT_FORMAT_FLATTEN_TABLE = "flatten-table" // pretty table with flattened keys
 	OUTPUT_FORMAT_JSON          = "json"          // json string
+	OUTPUT_FORMAT_YAML          = "yaml"          // yaml string
+	OUTPUT_FORMAT_CSV           = "csv"           // csv with header row
 	OUTPUT_FORMAT_KV            = "kv"            // "key: value" as separate line
 	OUTPUT_FORMAT_FLATTEN_KV    = "flatten-kv"    // kv with flattened keys
 )
@@ -41,19 +44,149 @@ func OutputFormat(s string) {
 	outputFormat = s
 }
 
+// outputFields is the --field selection; empty means the default
+// columns of the command
+var outputFields []string
+
+func OutputFields(s string) {
+	outputFields = parseOutputFields(s)
+}
+
+// parseOutputFields splits a comma separated --field value, dropping
+// empty segments
+func parseOutputFields(s string) []string {
+	fields := make([]string, 0)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if len(f) > 0 {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// selectFields returns the --field selection when present, otherwise
+// the default columns of the command
+func selectFields(columns []string) []string {
+	if len(outputFields) > 0 {
+		return outputFields
+	}
+	return columns
+}
+
+// filterObjectFields reduces obj to the given top-level fields,
+// skipping fields the object does not carry. An empty selection
+// returns obj unchanged.
+func filterObjectFields(obj jsonutils.JSONObject, fields []string) jsonutils.JSONObject {
+	if len(fields) == 0 {
+		return obj
+	}
+	filtered := jsonutils.NewDict()
+	for _, f := range fields {
+		if v, err := obj.GetIgnoreCases(f); err == nil {
+			filtered.Add(v, f)
+		}
+	}
+	return filtered
+}
+
+// csvFieldValue renders a single cell: plain strings are emitted as
+// is, everything else (numbers, booleans, nested objects) as its JSON
+// representation; missing fields become empty cells
+func csvFieldValue(obj jsonutils.JSONObject, field string) string {
+	v, err := obj.GetIgnoreCases(field)
+	if err != nil {
+		return ""
+	}
+	if s, err := v.GetString(); err == nil {
+		return s
+	}
+	return v.String()
+}
+
+// listToCSV renders data as a csv document with a header row of the
+// given fields
+func listToCSV(data []jsonutils.JSONObject, fields []string) string {
+	buf := &strings.Builder{}
+	w := csv.NewWriter(buf)
+	w.Write(fields)
+	for _, obj := range data {
+		row := make([]string, len(fields))
+		for i, f := range fields {
+			row[i] = csvFieldValue(obj, f)
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// listFieldsOrAllKeys falls back to the union of top-level keys when
+// neither --field nor default columns are available, so csv/json output
+// is never empty
+func listFieldsOrAllKeys(data []jsonutils.JSONObject, columns []string) []string {
+	fields := selectFields(columns)
+	if len(fields) > 0 {
+		return fields
+	}
+	keyMap := make(map[string]bool)
+	for _, obj := range data {
+		m, _ := obj.GetMap()
+		for k := range m {
+			keyMap[k] = true
+		}
+	}
+	keys := make([]string, 0, len(keyMap))
+	for k := range keyMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func printList(list *modulebase.ListResult, columns []string) {
-	printutils.PrintJSONList(list, columns)
+	switch outputFormat {
+	case OUTPUT_FORMAT_JSON:
+		arr := jsonutils.NewArray()
+		for _, obj := range list.Data {
+			arr.Add(filterObjectFields(obj, selectFields(nil)))
+		}
+		fmt.Println(arr.String())
+	case OUTPUT_FORMAT_YAML:
+		arr := jsonutils.NewArray()
+		for _, obj := range list.Data {
+			arr.Add(filterObjectFields(obj, selectFields(nil)))
+		}
+		fmt.Print(arr.YAMLString())
+	case OUTPUT_FORMAT_CSV:
+		fields := listFieldsOrAllKeys(list.Data, columns)
+		fmt.Print(listToCSV(list.Data, fields))
+	default:
+		printutils.PrintJSONList(list, selectFields(columns))
+	}
 }
 
 func printObject(obj jsonutils.JSONObject) {
 	switch outputFormat {
 	case OUTPUT_FORMAT_TABLE:
-		printutils.PrintJSONObject(obj)
+		printutils.PrintJSONObject(filterObjectFields(obj, selectFields(nil)))
 	case OUTPUT_FORMAT_KV:
-		printObjectFmtKv(obj)
+		printObjectFmtKv(filterObjectFields(obj, selectFields(nil)))
 	case OUTPUT_FORMAT_JSON:
-		fmt.Print(obj.String())
+		fmt.Print(filterObjectFields(obj, selectFields(nil)).String())
 		fmt.Print("\n")
+	case OUTPUT_FORMAT_YAML:
+		fmt.Print(filterObjectFields(obj, selectFields(nil)).YAMLString())
+	case OUTPUT_FORMAT_CSV:
+		fields := selectFields(nil)
+		if len(fields) == 0 {
+			m, _ := obj.GetMap()
+			for k := range m {
+				fields = append(fields, k)
+			}
+			sort.Strings(fields)
+		}
+		fmt.Print(listToCSV([]jsonutils.JSONObject{obj}, fields))
 	case OUTPUT_FORMAT_FLATTEN_TABLE:
 		printObjectRecursive(obj)
 	case OUTPUT_FORMAT_FLATTEN_KV:
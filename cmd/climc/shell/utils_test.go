@@ -0,0 +1,77 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shell
+
+import (
+	"reflect"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestParseOutputFields(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"name,status", []string{"name", "status"}},
+		{" name , ,status ", []string{"name", "status"}},
+	}
+	for _, c := range cases {
+		if got := parseOutputFields(c.in); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseOutputFields(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestFilterObjectFields(t *testing.T) {
+	obj, err := jsonutils.ParseString(`{"name": "vm1", "status": "running", "metadata": {"os": "linux"}}`)
+	if err != nil {
+		t.Fatalf("parse object: %v", err)
+	}
+
+	// empty selection keeps the object unchanged
+	if got := filterObjectFields(obj, nil); got != obj {
+		t.Errorf("filterObjectFields() with no fields should return the object unchanged")
+	}
+
+	// nested values are kept intact, missing fields are skipped
+	filtered := filterObjectFields(obj, []string{"name", "metadata", "no_such_field"})
+	if name, _ := filtered.GetString("name"); name != "vm1" {
+		t.Errorf("filterObjectFields() name = %q, want vm1", name)
+	}
+	if os, _ := filtered.GetString("metadata", "os"); os != "linux" {
+		t.Errorf("filterObjectFields() metadata.os = %q, want linux", os)
+	}
+	if filtered.Contains("no_such_field") {
+		t.Errorf("filterObjectFields() should skip missing fields")
+	}
+	if filtered.Contains("status") {
+		t.Errorf("filterObjectFields() should drop unselected fields")
+	}
+}
+
+func TestListToCSV(t *testing.T) {
+	obj1, _ := jsonutils.ParseString(`{"name": "vm1", "vcpu": 2, "metadata": {"os": "linux"}}`)
+	obj2, _ := jsonutils.ParseString(`{"name": "vm,2"}`)
+	got := listToCSV([]jsonutils.JSONObject{obj1, obj2}, []string{"name", "vcpu", "metadata"})
+	want := "name,vcpu,metadata\n" +
+		"vm1,2,\"{\"\"os\"\":\"\"linux\"\"}\"\n" +
+		"\"vm,2\",,\n"
+	if got != want {
+		t.Errorf("listToCSV() = %q, want %q", got, want)
+	}
+}
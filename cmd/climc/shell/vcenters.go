@@ -18,6 +18,7 @@ import (
 	"yunion.io/x/jsonutils"
 
 	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/mcclient/modulebase"
 	"yunion.io/x/onecloud/pkg/mcclient/modules"
 	"yunion.io/x/onecloud/pkg/mcclient/options"
 )
@@ -36,11 +37,21 @@ func init() {
 
 			}
 		}
-		result, err := modules.VCenters.List(s, params)
+		var result *modulebase.ListResult
+		var err error
+		if options.BoolV(args.All) {
+			result, err = modules.VCenters.ListAll(s, params)
+		} else {
+			result, err = modules.VCenters.List(s, params)
+		}
 		if err != nil {
 			return err
 		}
-		printList(result, modules.VCenters.GetColumns(s))
+		columns := modules.VCenters.GetColumns(s)
+		if options.BoolV(args.Details) {
+			columns = append(columns, "last_sync", "last_sync_status", "last_sync_error", "host_count", "guest_count")
+		}
+		printList(result, columns)
 		return nil
 	})
 
@@ -115,6 +126,38 @@ func init() {
 		return nil
 	})
 
+	R(&VCenterDetailOptions{}, "vcenter-host-list", "List ESXi hosts discovered on a vcenter", func(s *mcclient.ClientSession, args *VCenterDetailOptions) error {
+		result, err := modules.VCenters.GetSpecific(s, args.ID, "hosts", nil)
+		if err != nil {
+			return err
+		}
+		hosts, err := result.GetArray("hosts")
+		if err != nil {
+			return err
+		}
+		printList(&modulebase.ListResult{Data: hosts}, []string{"name", "ip", "cluster", "imported", "host_id"})
+		return nil
+	})
+
+	type VCenterHostImportOptions struct {
+		ID      string `help:"ID or name of vcenter"`
+		HOSTIP  string `help:"Management IP of the ESXi host to import"`
+		Project string `help:"Project owning the imported virtual machines"`
+	}
+	R(&VCenterHostImportOptions{}, "vcenter-host-import", "Import a single ESXi host from a vcenter", func(s *mcclient.ClientSession, args *VCenterHostImportOptions) error {
+		params := jsonutils.NewDict()
+		params.Add(jsonutils.NewString(args.HOSTIP), "host_ip")
+		if len(args.Project) > 0 {
+			params.Add(jsonutils.NewString(args.Project), "project")
+		}
+		result, err := modules.VCenters.PerformAction(s, args.ID, "import-host", params)
+		if err != nil {
+			return err
+		}
+		printObject(result)
+		return nil
+	})
+
 	type VCenterUpdateCredentialOptions struct {
 		ID       string `help:"ID or name of vcenter"`
 		User     string `help:"New Account"`
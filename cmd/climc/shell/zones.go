@@ -30,6 +30,8 @@ func init() {
 		City      string `help:"Filter zone by city of cloudregions"`
 		Usable    *bool  `help:"List all zones where networks are usable"`
 		UsableVpc *bool  `help:"List all zones where vpc are usable"`
+
+		Hypervisor string `help:"List zones with enabled online hosts of the hypervisor" choices:"kvm|esxi|baremetal|container|aliyun|azure|aws|qcloud|huawei|openstack|ucloud|zstack|google|ctyun"`
 	}
 	R(&ZoneListOptions{}, "zone-list", "List zones", func(s *mcclient.ClientSession, args *ZoneListOptions) error {
 		params, err := options.ListStructToParams(args)
@@ -39,6 +41,8 @@ func init() {
 		var result *modulebase.ListResult
 		if len(args.Region) > 0 {
 			result, err = modules.Zones.ListInContext(s, params, &modules.Cloudregions, args.Region)
+		} else if options.BoolV(args.All) {
+			result, err = modules.Zones.ListAll(s, params)
 		} else {
 			result, err = modules.Zones.List(s, params)
 		}
@@ -93,7 +97,19 @@ func init() {
 		return nil
 	})
 
-	R(&ZoneShowOptions{}, "zone-delete", "Delete zone", func(s *mcclient.ClientSession, args *ZoneShowOptions) error {
+	type ZoneDeleteOptions struct {
+		ID    string `help:"ID or Name of the zone to delete"`
+		Check bool   `help:"Show dependent resources instead of deleting" json:"-"`
+	}
+	R(&ZoneDeleteOptions{}, "zone-delete", "Delete zone", func(s *mcclient.ClientSession, args *ZoneDeleteOptions) error {
+		if args.Check {
+			result, err := modules.Zones.GetSpecific(s, args.ID, "dependencies", nil)
+			if err != nil {
+				return err
+			}
+			printObject(result)
+			return nil
+		}
 		result, err := modules.Zones.Delete(s, args.ID, nil)
 		if err != nil {
 			return err
@@ -102,6 +118,15 @@ func init() {
 		return nil
 	})
 
+	R(&ZoneShowOptions{}, "zone-dependency", "Show resources still referencing a zone", func(s *mcclient.ClientSession, args *ZoneShowOptions) error {
+		result, err := modules.Zones.GetSpecific(s, args.ID, "dependencies", nil)
+		if err != nil {
+			return err
+		}
+		printObject(result)
+		return nil
+	})
+
 	type ZoneCapabilityOptions struct {
 		ID     string `help:"Zone ID or Name" json:"-"`
 		Domain string `help:"domain Id or name"`
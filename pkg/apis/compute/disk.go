@@ -108,6 +108,11 @@ type DiskListInput struct {
 	// filter disk by whether it is being used
 	Unused *bool `json:"unused"`
 
+	// 按用户元数据键值过滤, 仅指定键时匹配任意值
+	Metadata map[string]string `json:"metadata"`
+	// 过滤掉带有指定用户元数据键的磁盘
+	WithoutMetadataKeys []string `json:"without_metadata_keys"`
+
 	// swagger:ignore
 	// Deprecated
 	// filter by disk type
@@ -127,6 +132,9 @@ type DiskListInput struct {
 
 	DiskSize int `json:"disk_size"`
 
+	// 过滤所在存储超分比(已分配磁盘容量/物理容量)大于指定值的磁盘
+	OverProvisionRatioGt *float64 `json:"over_provision_ratio_gt"`
+
 	AutoDelete *bool `json:"auto_delete"`
 
 	FsFormat string `json:"fs_format"`
@@ -142,6 +150,12 @@ type DiskListInput struct {
 	// swagger:ignore
 	// Deprecated
 	SnapshotId string `json:"snapshot_id" deprecated-by:"snapshot"`
+
+	// 过滤本地存储在指定宿主机(ID或Name)上的磁盘
+	Host string `json:"host"`
+
+	// 模糊搜索: 匹配磁盘名称或ID前缀
+	Search string `json:"search"`
 }
 
 type DiskFilterListInputBase struct {
@@ -201,6 +215,12 @@ type DiskDetails struct {
 	ManualSnapshotCount int `json:"manual_snapshot_count"`
 	// 最多可创建手动快照数量
 	MaxManualSnapshotCount int `json:"max_manual_snapshot_count"`
+
+	// 磁盘所在存储的超分比(已分配磁盘容量/物理容量)
+	OverProvisionRatio float64 `json:"over_provision_ratio"`
+
+	// 本地存储磁盘数据所在的宿主机列表, 共享存储为空
+	Hosts []StorageHostInfo `json:"hosts"`
 }
 
 type DiskResourceInfoBase struct {
@@ -100,6 +100,9 @@ type ZoneListInput struct {
 	// 过滤提供特定服务的可用区
 	Service string `json:"service"`
 
+	// 过滤有指定hypervisor的启用在线宿主机的可用区, 如kvm, esxi
+	Hypervisor string `json:"hypervisor"`
+
 	Location []string `json:"location"`
 	Contacts []string `json:"contacts"`
 }
@@ -26,6 +26,48 @@ type GlobalVpcDetails struct {
 	apis.EnabledStatusStandaloneResourceDetails
 
 	SGlobalVpc
+
+	// 资源使用情况, 单个查询时返回
+	Usage *GlobalVpcUsage `json:"usage"`
+
+	// 子VPC的按区域分布, details=true时返回
+	RegionStats []GlobalVpcRegionStat `json:"region_stats,omitempty"`
+}
+
+// GlobalVpcRegionStat 全局VPC的子VPC在单个区域内的分布
+type GlobalVpcRegionStat struct {
+	// 区域Id
+	CloudregionId string `json:"cloudregion_id"`
+	// 区域名称
+	Cloudregion string `json:"cloudregion"`
+	// 该区域内的子VPC数量
+	VpcCount int `json:"vpc_count"`
+	// 该区域内的IP子网数量
+	NetworkCount int `json:"network_count"`
+}
+
+type GlobalVpcUsage struct {
+	apis.Meta
+
+	// 子VPC数量
+	VpcCount int `json:"vpc_count"`
+	// 二层网络数量
+	WireCount int `json:"wire_count"`
+	// IP子网数量
+	NetworkCount int `json:"network_count"`
+	// IP地址总数
+	TotalIpCount int64 `json:"total_ip_count"`
+	// 已分配给虚拟机网卡的IP数量
+	UsedIpCount int64 `json:"used_ip_count"`
+	// 虚拟机网卡数量
+	GuestNicCount int `json:"guest_nic_count"`
+}
+
+type GlobalVpcDetachVpcInput struct {
+	apis.Meta
+
+	// 待移除的子VPC的ID或名称
+	VpcId string `json:"vpc_id"`
 }
 
 type GlobalVpcResourceInfo struct {
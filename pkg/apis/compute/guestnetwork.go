@@ -34,6 +34,12 @@ type GuestnetworkShortDesc struct {
 	Mac string `json:"mac"`
 	// Bonding的配对网卡MAC
 	TeamWith string `json:"team_with"`
+	// 网卡驱动, 如virtio, e1000
+	Driver string `json:"driver"`
+	// 网卡带宽限制, 单位Mbps, 0表示不限制
+	BwLimit int `json:"bw_limit"`
+	// 所在IP子网的VLAN
+	Vlan int `json:"vlan"`
 }
 
 type GuestnetworkListInput struct {
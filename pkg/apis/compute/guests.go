@@ -64,6 +64,14 @@ type ServerListInput struct {
 	// 列出Hypervisor为指定值的主机
 	// enum: kvm,esxi,baremetal,aliyun,azure,aws,huawei,ucloud,zstack,openstack,google,ctyun"`
 	Hypervisor []string `json:"hypervisor"`
+	// 返回结果中携带监控采集的资源使用率
+	WithMetrics *bool `json:"with_metrics"`
+	// 按用户元数据键值过滤, 仅指定键时匹配任意值
+	Metadata map[string]string `json:"metadata"`
+	// 过滤掉带有指定用户元数据键的主机
+	WithoutMetadataKeys []string `json:"without_metadata_keys"`
+	// 模糊搜索: 匹配主机名称、ID前缀、IP地址或绑定的弹性公网IP地址
+	Search string `json:"search"`
 	// 列出绑定了弹性IP（EIP）的主机
 	WithEip *bool `json:"with_eip"`
 	// 列出未绑定弹性IP（EIO）的主机
@@ -79,33 +87,49 @@ type ServerListInput struct {
 	// 列出可以挂载指定EIP的主机
 	UsableServerForEip string `json:"usable_server_for_eip"`
 
+	// 列出与指定主机（ID或Name）在同一宿主机上的主机
+	SameHostAs string `json:"same_host_as"`
+	// 列出与指定主机（ID或Name）不在同一宿主机上的主机
+	DifferentHostFrom string `json:"different_host_from"`
+
 	// 按主机资源类型进行排序
 	// enum: shared,prepaid,dedicated
 	ResourceType string `json:"resource_type"`
 	// 返回开启主备机功能的主机
 	GetBackupGuestsOnHost *bool `json:"get_backup_guests_on_host"`
 
-	// 根据宿主机 SN 过滤
-	// HostSn string `json:"host_sn"`
-
+	// 按CPU核数过滤
 	VcpuCount []int `json:"vcpu_count"`
 
+	// 按内存大小(MB)过滤
 	VmemSize []int `json:"vmem_size"`
 
+	// 按启动顺序过滤, 取值为c(光驱)、d(磁盘)、n(网络)的组合
 	BootOrder []string `json:"boot_order"`
 
+	// 按VGA型号过滤
+	// enum: std,cirrus,vmware,qxl
 	Vga []string `json:"vga"`
 
+	// 按远程桌面协议过滤
+	// enum: vnc,spice
 	Vdi []string `json:"vdi"`
 
+	// 按machine型号过滤
+	// enum: pc,q35
 	Machine []string `json:"machine"`
 
+	// 按BIOS类型过滤
+	// enum: BIOS,UEFI
 	Bios []string `json:"bios"`
 
+	// 按是否开启源IP检查过滤
 	SrcIpCheck *bool `json:"src_ip_check"`
 
+	// 按是否开启源MAC检查过滤
 	SrcMacCheck *bool `json:"src_mac_check"`
 
+	// 按套餐名称过滤
 	InstanceType []string `json:"instance_type"`
 }
 
@@ -187,6 +211,9 @@ type ServerDetails struct {
 	// common
 	IsPrepaidRecycle bool `json:"is_prepaid_recycle"`
 
+	// 宿主机元数据标签, 仅在请求携带with_host_meta时返回
+	HostMetadata map[string]string `json:"host_metadata"`
+
 	// 备份主机所在宿主机名称
 	BackupHostName string `json:"backup_host_name"`
 	// 备份主机所在宿主机状态
@@ -217,9 +244,11 @@ type ServerDetails struct {
 	VpcId string `json:"vpc_id"`
 
 	// 关联安全组列表
-	Secgroups []apis.StandaloneShortDesc `json:"secgroups"`
+	Secgroups []SecgroupShortDesc `json:"secgroups"`
 	// 关联主安全组
 	Secgroup string `json:"secgroup"`
+	// 管理安全组, 仅系统管理员可见
+	AdminSecgroupDesc *SecgroupShortDesc `json:"admin_secgroup,omitempty"`
 
 	// 浮动IP
 	Eip string `json:"eip"`
@@ -236,6 +265,13 @@ type ServerDetails struct {
 
 	// Cdrom信息
 	Cdrom string `json:"cdrom,allowempty"`
+
+	// CPU使用率(百分比), 仅当with_metrics=true时返回, 无数据时为空
+	CpuUsagePercent *float64 `json:"cpu_usage_percent"`
+	// 内存使用率(百分比), 仅当with_metrics=true时返回, 无数据时为空
+	MemUsagePercent *float64 `json:"mem_usage_percent"`
+	// 磁盘使用率(百分比), 仅当with_metrics=true时返回, 无数据时为空
+	DiskUsagePercent *float64 `json:"disk_usage_percent"`
 }
 
 type GuestJointResourceDetails struct {
@@ -285,3 +321,45 @@ type GuestFilterListInput struct {
 	// pattern:asc|desc
 	OrderByServer string `json:"order_by_server"`
 }
+
+type ServerSecurityRulesInput struct {
+	// 评估流量的方向: in|out
+	Direction string `json:"direction"`
+	// 评估流量的协议
+	Protocol string `json:"protocol"`
+	// 评估流量的端口
+	Port int `json:"port"`
+	// 评估流量的对端IP
+	RemoteIp string `json:"remote_ip"`
+}
+
+type ServerEffectiveSecurityRule struct {
+	// 规则内容
+	Rule string `json:"rule"`
+	// 方向
+	Direction string `json:"direction"`
+	// 优先级
+	Priority int `json:"priority"`
+	// 动作
+	Action string `json:"action"`
+	// 来源安全组ID
+	SecgroupId string `json:"secgroup_id"`
+	// 来源安全组名称
+	Secgroup string `json:"secgroup"`
+	// 是否来自管理安全组
+	IsAdmin bool `json:"is_admin"`
+}
+
+type ServerSecurityRuleVerdict struct {
+	// 是否放行
+	Allow bool `json:"allow"`
+	// 命中的规则, 为空表示未命中任何规则
+	MatchedRule *ServerEffectiveSecurityRule `json:"matched_rule"`
+}
+
+type ServerSecurityRulesOutput struct {
+	// 合并后按评估顺序排列的生效规则
+	Rules []ServerEffectiveSecurityRule `json:"rules"`
+	// 流量评估结果, 仅在传入评估参数时返回
+	Verdict *ServerSecurityRuleVerdict `json:"verdict"`
+}
@@ -108,6 +108,9 @@ type HostListInput struct {
 	Uuid []string `json:"uuid"`
 	// 主机启动模式, 可能值位PXE和ISO
 	BootMode []string `json:"boot_mode"`
+
+	// 模糊搜索: 匹配宿主机名称、ID前缀或管理口IP地址
+	Search string `json:"search"`
 }
 
 type HostDetails struct {
@@ -201,8 +204,14 @@ type HostFilterListInput struct {
 type HostFilterListInputBase struct {
 	HostResourceInput
 
-	// 以宿主机序列号过滤
-	HostSN string `json:"host_sn"`
+	// 以宿主机序列号过滤, 多个值之间为或关系, 大小写不敏感
+	HostSN []string `json:"host_sn"`
+
+	// 以宿主机IPMI地址过滤, 多个值之间为或关系
+	HostIpmiIp []string `json:"host_ipmi_ip"`
+
+	// 按宿主机元数据标签过滤, 多个键值对之间为与关系, 键可省略user:前缀
+	HostMetadata map[string]string `json:"host_metadata"`
 
 	// 以宿主机名称排序
 	OrderByHost string `json:"order_by_host"`
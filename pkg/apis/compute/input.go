@@ -79,6 +79,19 @@ type RouteTableListInput struct {
 
 	// filter by type
 	Type []string `json:"type"`
+
+	// 过滤有路由匹配指定cidr的路由表
+	Cidr string `json:"cidr"`
+	// cidr匹配方式, exact为精确匹配, contains要求路由网段覆盖指定cidr, 默认contains
+	// enum: exact,contains
+	CidrMatch string `json:"cidr_match"`
+
+	// 路由统计是否计入系统路由, 默认不计入
+	IncludeSystem bool `json:"include_system"`
+	// 仅返回没有非系统路由的路由表
+	IsEmpty *bool `json:"is_empty"`
+	// 按是否存在黑洞路由过滤
+	HasBlackholeRoute *bool `json:"has_blackhole_route"`
 }
 
 type SnapshotPolicyCacheListInput struct {
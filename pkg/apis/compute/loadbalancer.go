@@ -31,6 +31,15 @@ const (
 	DeployMethodCopy = "copy"
 )
 
+type LoadbalancerChangeClusterInput struct {
+	apis.Meta
+
+	// 目标集群名称或Id
+	Cluster string `json:"cluster"`
+	// 允许跨二层网络迁移
+	Force bool `json:"force"`
+}
+
 type LoadbalancerListenerListInput struct {
 	apis.VirtualResourceListInput
 	apis.ExternalizedResourceBaseListInput
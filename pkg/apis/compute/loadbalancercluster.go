@@ -52,4 +52,10 @@ type LoadbalancerClusterFilterListInput struct {
 
 	// 以负载均衡集群排序
 	OrderByCluster string `json:"order_by_cluster"`
+
+	// 仅返回还有空闲转发能力的集群下的资源, 即集群内负载均衡实例数低于节点数与单节点容量之积
+	ClusterHasFreeAgent *bool `json:"cluster_has_free_agent"`
+
+	// 以集群负载(每节点负载均衡实例数)排序
+	OrderByClusterLoad string `json:"order_by_cluster_load"`
 }
@@ -16,9 +16,88 @@ package compute
 
 import "yunion.io/x/onecloud/pkg/apis"
 
+type RouteTablePurgeInput struct {
+	apis.Meta
+
+	// 待清理路由表的云订阅Id, 与cloudaccount_id二选一
+	ManagerId string `json:"manager_id"`
+	// 待清理路由表的云账号Id, 清理该账号下所有已禁用订阅的路由表
+	CloudaccountId string `json:"cloudaccount_id"`
+}
+
+type RouteTablePurgeOutput struct {
+	apis.Meta
+
+	// 已清理的路由表数量
+	Purged int `json:"purged"`
+	// 跳过清理的原因列表
+	Skipped []string `json:"skipped"`
+}
+
+// 路由条目, 导入导出使用的形式
+type RouteTableRouteEntry struct {
+	// 目标网段
+	Cidr string `json:"cidr"`
+	// 下一跳类型
+	NextHopType string `json:"next_hop_type"`
+	// 下一跳资源Id
+	NextHopId string `json:"next_hop_id"`
+	// 路由优先级, 0表示使用云平台默认值
+	Priority int `json:"priority"`
+	// 本地备注
+	Description string `json:"description"`
+}
+
+type RouteTableExportOutput struct {
+	apis.Meta
+
+	// 导出格式, json或csv
+	Format string `json:"format"`
+	// json格式的路由列表
+	Routes []RouteTableRouteEntry `json:"routes,omitempty"`
+	// csv格式的路由内容, 表头为cidr,next_hop_type,next_hop_id,priority,description
+	Csv string `json:"csv,omitempty"`
+}
+
+type RouteTableImportRowError struct {
+	// 出错的行号, csv计入表头行, json数组从1开始
+	Row int `json:"row"`
+	// 出错原因
+	Reason string `json:"reason"`
+}
+
+type RouteTableImportRoutesInput struct {
+	apis.Meta
+
+	// 导入模式, append追加到现有路由, replace替换全部自定义路由
+	Mode string `json:"mode"`
+	// json数组形式的路由条目, 与csv二选一
+	Routes []RouteTableRouteEntry `json:"routes"`
+	// csv文件内容, 表头为cidr,next_hop_type,next_hop_id,priority,description
+	Csv string `json:"csv"`
+}
+
+type RouteTableImportRoutesOutput struct {
+	apis.Meta
+
+	// 成功导入的路由条数, 校验失败时为0
+	Imported int `json:"imported"`
+	// 导入后的路由总数, 不含系统路由
+	Total int `json:"total"`
+	// 校验失败的行, 任何一行失败则整个导入不生效
+	RowErrors []RouteTableImportRowError `json:"row_errors,omitempty"`
+}
+
 type RouteTableDetails struct {
 	apis.VirtualResourceDetails
 	VpcResourceInfo
 
 	SRouteTable
+
+	// 路由条数, 默认不含系统路由, include_system=true时计入
+	RouteCount int `json:"route_count"`
+	// 按下一跳类型统计的路由条数
+	NextHopTypeCount map[string]int `json:"next_hop_type_count,allowempty"`
+	// 黑洞路由条数
+	BlackholeRouteCount int `json:"blackhole_route_count"`
 }
@@ -19,11 +19,35 @@ const (
 	ROUTE_TABLE_TYPE_VBR = "VBR" // 边界路由器
 )
 
+const (
+	ROUTE_TABLE_AVAILABLE    = "available"    // 可用
+	ROUTE_TABLE_SYNCING      = "syncing"      // 同步中
+	ROUTE_TABLE_APPLYING     = "applying"     // 下发云上中
+	ROUTE_TABLE_APPLY_FAILED = "apply_failed" // 下发云上失败
+	ROUTE_TABLE_UNKNOWN      = "unknown"      // 未知
+)
+
+const (
+	ROUTE_TABLE_IMPORT_MODE_APPEND  = "append"  // 追加到现有路由
+	ROUTE_TABLE_IMPORT_MODE_REPLACE = "replace" // 替换全部自定义路由
+)
+
 const (
 	ROUTE_ENTRY_TYPE_CUSTOM = "Custom" // 自定义路由
 	ROUTE_ENTRY_TYPE_SYSTEM = "System" // 系统路由
 )
 
+const (
+	ROUTE_STATUS_AVAILABLE = "available" // 可用
+	ROUTE_STATUS_BLACKHOLE = "blackhole" // 黑洞路由, 下一跳资源已不存在
+	ROUTE_STATUS_UNKNOWN   = "unknown"   // 未知
+)
+
+const (
+	ROUTE_TABLE_CIDR_MATCH_EXACT    = "exact"    // 精确匹配
+	ROUTE_TABLE_CIDR_MATCH_CONTAINS = "contains" // 路由网段覆盖指定cidr
+)
+
 const (
 	Next_HOP_TYPE_INSTANCE        = "Instance"              // ECS实例。
 	Next_HOP_TYPE_HAVIP           = "HaVip"                 // 高可用虚拟IP。
@@ -24,6 +24,18 @@ import (
 	"yunion.io/x/onecloud/pkg/apis"
 )
 
+// SecgroupShortDesc 安全组摘要信息, 附带规则统计
+type SecgroupShortDesc struct {
+	apis.StandaloneShortDesc
+
+	// 入方向规则数量
+	InRuleCount int `json:"in_rule_count"`
+	// 出方向规则数量
+	OutRuleCount int `json:"out_rule_count"`
+	// 是否存在对任意地址任意协议放行的规则
+	HasAllowAny bool `json:"has_allow_any"`
+}
+
 type SSecgroupRuleCreateInput struct {
 	apis.ResourceBaseCreateInput
 
@@ -200,6 +212,43 @@ type SecgroupDetails struct {
 	OutRules string `json:"out_rules"`
 }
 
+const (
+	// 本地规则未下发到云端
+	SECGROUP_DIFF_REASON_NOT_ON_CLOUD = "not-on-cloud"
+	// 云端规则不在本地定义中
+	SECGROUP_DIFF_REASON_NOT_IN_LOCAL = "not-in-local"
+	// 优先级超出云平台范围, 下发时被压缩
+	SECGROUP_DIFF_REASON_PRIORITY_SQUEEZE = "priority-squeeze"
+	// 云平台仅支持允许规则, 本地规则被转换为等价的允许列表
+	SECGROUP_DIFF_REASON_ALLOW_ONLY = "allow-only-conversion"
+	// 云平台默认规则, 同步时自动注入
+	SECGROUP_DIFF_REASON_DEFAULT_RULE = "default-rule-injection"
+)
+
+// SecgroupRuleChange 同步预览中的一条规则及其变更原因
+type SecgroupRuleChange struct {
+	// 规则内容
+	Rule string `json:"rule"`
+	// 下发到云端后的优先级
+	Priority int `json:"priority"`
+	// 变更原因
+	Reason string `json:"reason,omitempty"`
+}
+
+// SecgroupRulesDiff 本地安全组规则与云端规则的比对结果
+type SecgroupRulesDiff struct {
+	// 本地与云端一致的规则
+	Common []SecgroupRuleChange `json:"common"`
+	// 待新增的入方向规则
+	InAdds []SecgroupRuleChange `json:"in_adds"`
+	// 待新增的出方向规则
+	OutAdds []SecgroupRuleChange `json:"out_adds"`
+	// 待删除的入方向规则
+	InDels []SecgroupRuleChange `json:"in_dels"`
+	// 待删除的出方向规则
+	OutDels []SecgroupRuleChange `json:"out_dels"`
+}
+
 type SecurityGroupResourceInfo struct {
 	// 安全组名称
 	Secgroup string `json:"secgroup"`
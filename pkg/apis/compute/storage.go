@@ -138,6 +138,16 @@ type StorageDetails struct {
 	CommitBound float32 `json:"commit_bound"`
 }
 
+// StorageHostInfo 存储所在宿主机摘要信息
+type StorageHostInfo struct {
+	// 宿主机ID
+	Id string `json:"id"`
+	// 宿主机名称
+	Name string `json:"name"`
+	// 宿主机状态
+	Status string `json:"status"`
+}
+
 type StorageResourceInfo struct {
 	// 归属云订阅ID
 	ManagerId string `json:"manager_id"`
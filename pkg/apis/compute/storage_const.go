@@ -149,6 +149,14 @@ type StorageFilterListInputBase struct {
 	// 以存储名称排序
 	// pattern:asc|desc
 	OrderByStorage string `json:"order_by_storage"`
+
+	// 以存储剩余容量排序
+	// pattern:asc|desc
+	OrderByStorageFreeCapacity string `json:"order_by_storage_free_capacity"`
+
+	// 以存储使用率排序
+	// pattern:asc|desc
+	OrderByStorageUsedPercent string `json:"order_by_storage_used_percent"`
 }
 
 type StorageFilterListInput struct {
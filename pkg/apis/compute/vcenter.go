@@ -0,0 +1,72 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compute
+
+import (
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+const (
+	VCENTER_SYNC_STATUS_SUCCESS = "success" // 最近一次同步成功
+	VCENTER_SYNC_STATUS_FAILED  = "failed"  // 最近一次同步失败
+	VCENTER_SYNC_STATUS_SYNCING = "syncing" // 同步中
+)
+
+type VCenterDetails struct {
+	apis.EnabledStatusStandaloneResourceDetails
+
+	// 最近一次同步时间
+	LastSync time.Time `json:"last_sync"`
+	// 最近一次同步状态, success/failed/syncing
+	LastSyncStatus string `json:"last_sync_status"`
+	// 同步失败时的简短错误信息
+	LastSyncError string `json:"last_sync_error,omitempty"`
+	// 纳管的宿主机数量
+	HostCount int `json:"host_count"`
+	// 纳管的虚拟机数量
+	GuestCount int `json:"guest_count"`
+}
+
+// VCenterDiscoveredHost describes one ESXi host found on a vcenter
+type VCenterDiscoveredHost struct {
+	// 主机名称
+	Name string `json:"name"`
+	// 管理IP
+	Ip string `json:"ip"`
+	// 所属集群
+	Cluster string `json:"cluster,omitempty"`
+	// 是否已导入为本地宿主机
+	Imported bool `json:"imported"`
+	// 已导入的本地宿主机ID
+	HostId string `json:"host_id,omitempty"`
+}
+
+type VCenterGetHostsOutput struct {
+	apis.Meta
+
+	// vcenter上发现的ESXi主机列表
+	Hosts []VCenterDiscoveredHost `json:"hosts"`
+}
+
+type VCenterImportHostInput struct {
+	apis.Meta
+
+	// 待导入主机的管理IP
+	HostIp string `json:"host_ip"`
+	// 导入的虚拟机归属的项目, 为空时使用订阅的默认项目
+	Project string `json:"project"`
+}
@@ -83,6 +83,12 @@ type WireListInput struct {
 
 type GlobalVpcListInput struct {
 	apis.EnabledStatusStandaloneResourceListInput
+
+	// 过滤归属于指定云平台的全局VPC
+	Providers []string `json:"providers"`
+
+	// 过滤在指定区域内有子VPC的全局VPC, 接受区域Id或名称
+	Region string `json:"region"`
 }
 
 const (
@@ -76,6 +76,30 @@ func (usage *ZoneGeneralUsage) IsEmpty() bool {
 	return true
 }
 
+// ZoneDependencies 可用区下仍然引用它的资源数量, 删除可用区前需要清空
+type ZoneDependencies struct {
+	apis.Meta
+
+	// 宿主机数量
+	Hosts int `json:"hosts"`
+	// 块存储数量
+	Storages int `json:"storages"`
+	// 二层网络数量
+	Wires int `json:"wires"`
+	// 子网数量
+	Networks int `json:"networks"`
+	// 虚拟机数量
+	Guests int `json:"guests"`
+}
+
+func (deps *ZoneDependencies) IsEmpty() bool {
+	return deps.Hosts == 0 &&
+		deps.Storages == 0 &&
+		deps.Wires == 0 &&
+		deps.Networks == 0 &&
+		deps.Guests == 0
+}
+
 type ZoneDetails struct {
 	apis.StatusStandaloneResourceDetails
 	CloudregionResourceInfo
@@ -86,6 +110,24 @@ type ZoneDetails struct {
 	SZone
 }
 
+// ZoneCapability 可用区真实可用的资源能力, 供部署工具在创建资源前探测
+type ZoneCapability struct {
+	apis.Meta
+
+	// 启用的宿主机类型
+	HostTypes []string `json:"host_types,allowempty"`
+	// 启用的虚拟化技术
+	Hypervisors []string `json:"hypervisors,allowempty"`
+	// 仍有剩余容量的存储类型
+	AvailableStorageTypes []string `json:"available_storage_types,allowempty"`
+	// 自动分配网络中是否还有空闲IP
+	HasFreeNetworkIps bool `json:"has_free_network_ips"`
+	// 自动分配网络的空闲IP总数
+	FreeNetworkIpCount int `json:"free_network_ip_count"`
+	// 可用的GPU设备型号
+	GpuModels []string `json:"gpu_models,allowempty"`
+}
+
 type ZoneResourceInfoBase struct {
 	// 可用区名称
 	// example: zone1
@@ -711,6 +711,9 @@ type SExternalProject struct {
 // SGlobalVpc is an autogenerated struct via yunion.io/x/onecloud/pkg/compute/models.SGlobalVpc.
 type SGlobalVpc struct {
 	apis.SEnabledStatusStandaloneResourceBase
+
+	// 云平台, 由第一个挂载的子VPC决定, 最后一个子VPC移除后清空
+	Provider string `json:"provider"`
 }
 
 // SGlobalVpcResourceBase is an autogenerated struct via yunion.io/x/onecloud/pkg/compute/models.SGlobalVpcResourceBase.
@@ -1586,6 +1589,7 @@ type SRoute struct {
 	Cidr        string `json:"cidr"`
 	NextHopType string `json:"next_hop_type"`
 	NextHopId   string `json:"next_hop_id"`
+	Priority    int    `json:"priority"`
 }
 
 // SRouteTable is an autogenerated struct via yunion.io/x/onecloud/pkg/compute/models.SRouteTable.
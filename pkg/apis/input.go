@@ -69,6 +69,14 @@ type SharableVirtualResourceCreateInput struct {
 	// description: indicate the shared scope for a public resource, which can be domain or system or none
 	// required: false
 	PublicScope string `json:"public_scope"`
+
+	// description: share the resource to these projects at create time, all targets must live in the owner domain
+	// required: false
+	SharedProjects []string `json:"shared_projects"`
+
+	// description: share the resource to these domains at create time, only the owner domain is supported
+	// required: false
+	SharedDomains []string `json:"shared_domains"`
 }
 
 type VirtualResourceCreateInput struct {
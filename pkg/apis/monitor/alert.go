@@ -137,3 +137,44 @@ type AlertListInput struct {
 type AlertDetails struct {
 	apis.VirtualResourceDetails
 }
+
+type AlertTestRunInput struct {
+	apis.Meta
+
+	// 同时发送该报警配置的通知, 通知会标记为测试
+	Notify bool `json:"notify"`
+}
+
+// AlertTestNotificationResult is the delivery outcome of one
+// notification channel during a test fire
+type AlertTestNotificationResult struct {
+	// 通知渠道ID
+	Id string `json:"id"`
+	// 通知渠道名称
+	Name string `json:"name"`
+	// 通知渠道类型
+	Type string `json:"type"`
+	// 是否发送成功
+	Sent bool `json:"sent"`
+	// 发送失败原因
+	Error string `json:"error,omitempty"`
+}
+
+type AlertTestRunOutput struct {
+	apis.Meta
+
+	// 是否满足报警条件
+	Firing bool `json:"firing"`
+	// 是否未查询到数据
+	NoDataFound bool `json:"no_data_found"`
+	// 条件求值过程描述
+	ConditionEvals string `json:"condition_evals"`
+	// 求值的序列及其数值
+	EvalMatches []EvalMatch `json:"eval_matches"`
+	// 求值日志
+	Logs []string `json:"logs,omitempty"`
+	// 求值错误
+	Error string `json:"error,omitempty"`
+	// 通知发送结果
+	Notifications []AlertTestNotificationResult `json:"notifications,omitempty"`
+}
@@ -0,0 +1,328 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+type DataSourceDetails struct {
+	apis.StandaloneResourceDetails
+
+	Type     string `json:"type"`
+	Url      string `json:"url"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	// Password is always masked, never the stored secret
+	Password string `json:"password"`
+	// 各个后端地址的健康状态
+	EndpointStatus []DataSourceEndpointStatus `json:"endpoint_status"`
+	// 最近一次写入探测时间
+	LastProbeAt time.Time `json:"last_probe_at"`
+	// 最近一次写入探测状态
+	LastProbeStatus string `json:"last_probe_status"`
+	// 最近若干次写入探测结果
+	ProbeResults []DataSourceProbeResult `json:"probe_results"`
+}
+
+// DataSourceProbeResult is one round trip of the influxdb write path
+// probe
+type DataSourceProbeResult struct {
+	// 是否成功
+	Success bool `json:"success"`
+	// 往返延迟, 单位毫秒
+	LatencyMs int64 `json:"latency_ms"`
+	// 失败原因
+	Error string `json:"error,omitempty"`
+	// 探测时间
+	ProbedAt time.Time `json:"probed_at"`
+}
+
+type DataSourceEndpointStatus struct {
+	// 后端地址
+	Url string `json:"url"`
+	// 是否健康
+	Healthy bool `json:"healthy"`
+	// 最近一次探测时间
+	LastCheck time.Time `json:"last_check"`
+	// 最近一次探测错误
+	LastError string `json:"last_error"`
+}
+
+type DataSourceMeasurementsInput struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// 按名称过滤measurement, 值为正则表达式
+	NameLike string `json:"name_like"`
+	// 分页大小, 默认和上限均为200
+	Limit int `json:"limit"`
+	// 分页偏移
+	Offset int `json:"offset"`
+	// 是否统计每个measurement的数据点数量
+	WithCounts bool `json:"with_counts"`
+	// 数据点统计的起始时间范围, 如24h, 为空不限制
+	From string `json:"from"`
+	// 数据点统计的截止时间范围, 如1h, 为空到当前时间
+	To string `json:"to"`
+	// 排序字段, name或count, 默认name
+	OrderBy string `json:"order_by"`
+}
+
+// MeasurementEntry describes one measurement with its datapoint
+// statistics within the requested time range
+type MeasurementEntry struct {
+	// measurement名称
+	Name string `json:"name"`
+	// from/to范围内的数据点总数
+	Count int64 `json:"count"`
+	// 最近10分钟内是否有数据写入
+	Active bool `json:"active"`
+}
+
+type DataSourceMeasurementsOutput struct {
+	apis.Meta
+
+	// 匹配的measurement总数
+	Total int `json:"total"`
+	// 当前页的measurement名称
+	Measurements []string `json:"measurements"`
+	// 当前页measurement的数据点统计, 仅with_counts=true时返回
+	Entries []MeasurementEntry `json:"entries,omitempty"`
+}
+
+type DataSourceMeasurementInput struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 是否统计序列基数, 统计查询开销较大, 结果缓存一小时
+	WithCardinality bool `json:"with_cardinality"`
+	// 是否返回字段数据类型
+	WithFieldTypes bool `json:"with_field_types"`
+	// 是否返回最近一个数据点的时间
+	WithLastDatapoint bool `json:"with_last_datapoint"`
+	// 查询最近数据点和tag取值的时间范围, 如24h, 为空不限制
+	From string `json:"from"`
+	// 查询该tag键的取值列表, 受调用者scope限制
+	TagKey string `json:"tag_key"`
+}
+
+// MeasurementCardinality 描述一个measurement的序列基数
+type MeasurementCardinality struct {
+	// 序列总数
+	SeriesCount int64 `json:"series_count"`
+	// 每个tag的不同取值数
+	TagCardinality map[string]int64 `json:"tag_cardinality"`
+	// 是否有基数超过告警阈值
+	Warning bool `json:"warning"`
+	// 统计时间, 结果可能来自缓存
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+type DataSourceMeasurementOutput struct {
+	apis.Meta
+
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// tag键列表
+	TagKeys []string `json:"tag_keys"`
+	// tag取值列表, 仅指定tag_key时返回
+	TagValues []string `json:"tag_values,omitempty"`
+	// 序列基数信息, 仅with_cardinality=true时返回
+	Cardinality *MeasurementCardinality `json:"cardinality,omitempty"`
+	// 字段数据类型, 仅with_field_types=true时返回
+	FieldTypes map[string]string `json:"field_types,omitempty"`
+	// 最近一个数据点的时间, 仅with_last_datapoint=true时返回
+	LastDatapointAt *time.Time `json:"last_datapoint_at,omitempty"`
+}
+
+type MeasurementSeriesCount struct {
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 序列总数
+	SeriesCount int64 `json:"series_count"`
+}
+
+type DataSourceCardinalitiesInput struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// 返回序列基数最高的前几个measurement, 默认10
+	Top int `json:"top"`
+}
+
+type DataSourceCardinalitiesOutput struct {
+	apis.Meta
+
+	// 按序列基数降序排列的measurement列表
+	Measurements []MeasurementSeriesCount `json:"measurements"`
+}
+
+type DataSourceSchemaInput struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// 仅包含指定前缀的measurement
+	MeasurementPrefix string `json:"measurement_prefix"`
+	// 输出格式, json或yaml, 默认json
+	Format string `json:"format"`
+	// 分页大小, 默认和上限由服务端配置决定
+	Limit int `json:"limit"`
+	// 分页偏移
+	Offset int `json:"offset"`
+	// 客户端缓存的etag, 与服务端一致时仅返回etag而不重复下发目录
+	Etag string `json:"etag"`
+}
+
+// MeasurementFieldSchema 描述measurement的一个字段
+type MeasurementFieldSchema struct {
+	// 字段名称
+	Name string `json:"name"`
+	// 字段类型, 如float, integer
+	Type string `json:"type"`
+	// 单位, 由字段命名约定推断, 可能为空
+	Unit string `json:"unit,omitempty"`
+}
+
+// MeasurementSchema 描述一个measurement的结构
+type MeasurementSchema struct {
+	// 字段列表
+	Fields []MeasurementFieldSchema `json:"fields"`
+	// tag键列表
+	Tags []string `json:"tags"`
+	// measurement用途说明, 仅常见measurement有
+	Description string `json:"description,omitempty"`
+}
+
+type DataSourceSchemaOutput struct {
+	apis.Meta
+
+	// 匹配的measurement总数
+	Total int `json:"total"`
+	// 当前页目录内容的指纹, 客户端可携带etag轮询
+	Etag string `json:"etag"`
+	// 客户端etag仍然有效, 此时不重复下发目录内容
+	NotModified bool `json:"not_modified,omitempty"`
+	// measurement目录, 按名称索引
+	Measurements map[string]MeasurementSchema `json:"measurements,omitempty"`
+	// format=yaml时返回目录的yaml文本
+	Yaml string `json:"yaml,omitempty"`
+}
+
+type DataSourceSuggestThresholdInput struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 字段名称
+	Field string `json:"field"`
+	// 统计的历史时间窗口, 如7d或72h, 默认7d, 超过上限会被截断
+	Window string `json:"window"`
+	// 额外的tag过滤, 格式key=value, 可指定多个
+	Tags []string `json:"tags"`
+}
+
+// FieldPercentiles 一个字段在统计窗口内的取值分布
+type FieldPercentiles struct {
+	// 样本点数
+	Count int64 `json:"count"`
+	// 中位数
+	P50 float64 `json:"p50"`
+	// 90分位
+	P90 float64 `json:"p90"`
+	// 99分位
+	P99 float64 `json:"p99"`
+	// 最大值
+	Max float64 `json:"max"`
+}
+
+type DataSourceSuggestThresholdOutput struct {
+	apis.Meta
+
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 字段名称
+	Field string `json:"field"`
+	// 实际统计的时间窗口
+	Window string `json:"window"`
+	// 样本点数不足, 不给出建议
+	InsufficientData bool `json:"insufficient_data"`
+	// 窗口内的取值分布
+	Percentiles *FieldPercentiles `json:"percentiles,omitempty"`
+	// 建议的警告阈值
+	Warning float64 `json:"warning,omitempty"`
+	// 建议的严重阈值
+	Critical float64 `json:"critical,omitempty"`
+	// 建议依据
+	Rationale string `json:"rationale"`
+}
+
+type DataSourceChangePasswordInput struct {
+	apis.Meta
+
+	// 数据源用户名, 为空保持不变
+	User string `json:"user"`
+	// 数据源新密码
+	Password string `json:"password"`
+}
+
+type DataSourceQueryInput struct {
+	apis.Meta
+
+	// 查询的数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// influxql查询语句, 仅允许SELECT和SHOW
+	Ql string `json:"ql"`
+	// 返回时间戳的精度, 如s, ms
+	Epoch string `json:"epoch"`
+}
+
+type AlertQueryValidateInput struct {
+	apis.Meta
+
+	// 查询模型, 与报警条件使用的查询模型一致
+	Model MetricQuery `json:"model"`
+	// 查询起始时间, 如5m, 1h, 超过最大范围会被截断
+	From string `json:"from"`
+	// 查询结束时间, 默认now
+	To string `json:"to"`
+}
+
+type AlertQueryValidateOutput struct {
+	apis.Meta
+
+	// 渲染后的influxql语句
+	Ql string `json:"ql"`
+	// 实际使用的查询起始时间
+	From string `json:"from"`
+	// 实际使用的查询结束时间
+	To string `json:"to"`
+	// 返回的数据点总数
+	RowCount int `json:"row_count"`
+	// 前几个数据点采样
+	Datapoints []jsonutils.JSONObject `json:"datapoints"`
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+const (
+	// 对应的continuous query与规则一致
+	DOWNSAMPLING_STATUS_IN_SYNC = "in-sync"
+	// influxdb中缺少对应的continuous query
+	DOWNSAMPLING_STATUS_MISSING = "missing"
+	// influxdb中的continuous query与规则定义不一致
+	DOWNSAMPLING_STATUS_DIVERGENT = "divergent"
+)
+
+type DownsamplingRuleCreateInput struct {
+	apis.StandaloneResourceCreateInput
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// 源measurement名称, 以/包围时按正则匹配
+	Measurement string `json:"measurement"`
+	// 聚合函数, 逗号分隔, 如mean,max
+	Aggregates string `json:"aggregates"`
+	// 降采样聚合周期, 如5m, 1h
+	Interval string `json:"interval"`
+	// 目标retention policy名称
+	RetentionPolicy string `json:"retention_policy"`
+}
+
+type DownsamplingRuleDetails struct {
+	apis.StandaloneResourceDetails
+
+	Database        string `json:"database"`
+	Measurement     string `json:"measurement"`
+	Aggregates      string `json:"aggregates"`
+	Interval        string `json:"interval"`
+	RetentionPolicy string `json:"retention_policy"`
+
+	// 与influxdb实际continuous query的同步状态
+	SyncStatus string `json:"sync_status"`
+	// 最近一次同步检查时间
+	LastSyncAt time.Time `json:"last_sync_at"`
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+type MetricStreamSpec struct {
+	apis.Meta
+
+	// 数据库名称, 默认telegraf
+	Database string `json:"database"`
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 查询的字段列表, 为空时查询所有字段
+	Fields []string `json:"fields"`
+	// tag过滤条件
+	Tags map[string]string `json:"tags"`
+	// 按tag分组的键列表
+	GroupBy []string `json:"group_by"`
+	// 增量查询周期(秒), 默认使用服务端配置
+	IntervalSeconds int `json:"interval_seconds"`
+}
+
+// MetricStreamEvent 推送给客户端的一批新数据点
+type MetricStreamEvent struct {
+	// measurement名称
+	Measurement string `json:"measurement"`
+	// 序列的tag组合
+	Tags map[string]string `json:"tags,omitempty"`
+	// 列名, 第一列为毫秒时间戳
+	Columns []string `json:"columns"`
+	// 数据点, 每行与columns对齐
+	Points [][]jsonutils.JSONObject `json:"points"`
+}
@@ -0,0 +1,169 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+const (
+	SUGGEST_ALERT_READY        = "ready"
+	SUGGEST_ALERT_START_DELETE = "start_delete"
+	SUGGEST_ALERT_DELETE_FAIL  = "delete_fail"
+	SUGGEST_ALERT_DELETING     = "deleting"
+	SUGGEST_ALERT_RESOLVING    = "resolving"
+	SUGGEST_ALERT_RESOLVED     = "resolved"
+	SUGGEST_ALERT_RESOLVE_FAIL = "resolve_fail"
+	// 关联资源已不存在
+	SUGGEST_ALERT_STALE = "stale"
+
+	// 自动解决: 规则运行发现问题已不存在
+	SUGGEST_ALERT_RESOLVED_REASON_AUTO = "auto"
+	// 执行建议动作后解决
+	SUGGEST_ALERT_RESOLVED_REASON_ACTION = "action"
+
+	DRIVER_ACTION = "DELETE_DRIVER"
+)
+
+type SuggestSysAlertCreateInput struct {
+	apis.VirtualResourceCreateInput
+
+	// 建议规则类型
+	Type string `json:"type"`
+	// 关联资源Id
+	ResId string `json:"res_id"`
+	// 监控数据
+	MonitorConfig jsonutils.JSONObject `json:"monitor_config"`
+	// 问题描述
+	Problem jsonutils.JSONObject `json:"problem"`
+	// 处理建议
+	Suggest string `json:"suggest"`
+	// 处理动作
+	Action string `json:"action"`
+	// 关联资源详情
+	ResMeta jsonutils.JSONObject `json:"res_meta"`
+	// 预计每月可节省的费用
+	Amount float64 `json:"amount"`
+
+	// swagger:ignore
+	ResName string `json:"res_name"`
+	// swagger:ignore
+	RegionId string `json:"region_id"`
+}
+
+type SuggestSysAlertListInput struct {
+	apis.VirtualResourceListInput
+
+	// 以建议规则类型过滤列表
+	Type string `json:"type"`
+	// 以关联资源Id过滤列表
+	ResId string `json:"res_id"`
+	// 以关联资源所属项目过滤列表
+	ProjectId string `json:"project_id"`
+	// 以关联资源所在区域过滤列表
+	RegionId string `json:"region_id"`
+	// 显示已忽略的建议
+	ShowIgnored bool `json:"show_ignored"`
+	// 显示已解决的建议, 未显式按状态过滤时已解决的建议默认隐藏
+	ShowResolved bool `json:"show_resolved"`
+	// 过滤预计每月可节省费用大于该值的建议
+	AmountGt *float64 `json:"amount_gt"`
+
+	// 按预计每月可节省的费用排序, 未能估价的建议排在最后
+	// pattern:asc|desc
+	OrderByAmount string `json:"order_by_amount"`
+	// 按问题首次被发现的时间排序
+	// pattern:asc|desc
+	OrderByFirstDetectedAt string `json:"order_by_first_detected_at"`
+}
+
+type SuggestSysAlertDetails struct {
+	apis.VirtualResourceDetails
+
+	// 是否已忽略
+	IsIgnored bool `json:"is_ignored"`
+	// 忽略原因
+	IgnoreReason string `json:"ignore_reason"`
+	// 忽略过期时间
+	IgnoreExpireAt time.Time `json:"ignore_expire_at"`
+
+	// 关联资源名称
+	ResName string `json:"res_name"`
+	// 关联资源所在区域
+	RegionId string `json:"region_id"`
+	// 标记为stale的时间
+	StaleAt time.Time `json:"stale_at"`
+	// 建议被解决的时间
+	ResolvedAt time.Time `json:"resolved_at"`
+	// 解决方式, auto表示问题自行消失, action表示执行了建议动作
+	ResolvedReason string `json:"resolved_reason"`
+}
+
+type SuggestAlertResolveInput struct {
+	apis.Meta
+}
+
+type SuggestAlertBatchResolveInput struct {
+	apis.Meta
+
+	// 待执行建议动作的报警Id列表
+	Ids []string `json:"ids"`
+}
+
+type SuggestAlertIgnoreInput struct {
+	apis.Meta
+
+	// 忽略原因
+	Reason string `json:"reason"`
+	// 忽略时长, 为空表示永久忽略
+	Duration string `json:"duration"`
+}
+
+type SuggestAlertTypeStatistics struct {
+	// 该类型的建议数
+	Count int `json:"count"`
+	// 该类型预计每月可节省的费用
+	Saving float64 `json:"saving"`
+}
+
+type SuggestAlertProjectStatistics struct {
+	// 项目Id
+	ProjectId string `json:"project_id"`
+	// 项目名称
+	Project string `json:"project"`
+	// 项目归属域Id
+	DomainId string `json:"domain_id"`
+	// 该项目的建议数
+	Count int `json:"count"`
+	// 该项目预计每月可节省的费用
+	Saving float64 `json:"saving"`
+}
+
+type SuggestSysAlertStatistics struct {
+	apis.Meta
+
+	// 按建议规则类型统计
+	ByType map[string]SuggestAlertTypeStatistics `json:"by_type"`
+	// 按项目统计
+	ByProject []SuggestAlertProjectStatistics `json:"by_project"`
+	// 建议总数
+	TotalCount int `json:"total_count"`
+	// 预计每月可节省的总费用
+	TotalSaving float64 `json:"total_saving"`
+}
@@ -0,0 +1,287 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis"
+)
+
+const (
+	SUGGEST_RULE_TYPE_EIP_UNUSED        = "EIP_UNUSED"
+	SUGGEST_RULE_TYPE_WEBHOOK           = "WEBHOOK"
+	SUGGEST_RULE_TYPE_SNAPSHOT_ORPHANED = "SNAPSHOT_ORPHANED"
+	SUGGEST_RULE_TYPE_IMAGE_UNUSED      = "IMAGE_UNUSED"
+)
+
+const (
+	// SUGGEST_RULE_EXPORT_VERSION is the version of the rule config
+	// export document format
+	SUGGEST_RULE_EXPORT_VERSION = "v1"
+
+	SUGGEST_RULE_IMPORT_MODE_MERGE   = "merge"
+	SUGGEST_RULE_IMPORT_MODE_REPLACE = "replace"
+
+	SUGGEST_RULE_IMPORT_ACTION_CREATED = "created"
+	SUGGEST_RULE_IMPORT_ACTION_UPDATED = "updated"
+	SUGGEST_RULE_IMPORT_ACTION_DELETED = "deleted"
+	SUGGEST_RULE_IMPORT_ACTION_SKIPPED = "skipped"
+)
+
+// SuggestSysRuleConfig is the portable configuration of one suggest
+// rule as carried by export/import documents
+type SuggestSysRuleConfig struct {
+	// 建议规则类型
+	Type string `json:"type"`
+	// 规则名称
+	Name string `json:"name"`
+	// 规则执行周期
+	Period string `json:"period"`
+	// 规则执行时间表, 标准5段cron表达式, 与period互斥
+	CronExpr string `json:"cron_expr"`
+	// 是否启用
+	Enabled bool `json:"enabled"`
+	// 规则配置
+	Setting *SSuggestSysAlertSetting `json:"setting"`
+}
+
+type SuggestSysRuleExportOutput struct {
+	apis.Meta
+
+	// 导出文档版本
+	Version string `json:"version"`
+	// 规则配置列表, 按类型排序
+	Rules []SuggestSysRuleConfig `json:"rules"`
+}
+
+type SuggestSysRuleImportInput struct {
+	apis.Meta
+
+	// 导出文档版本
+	Version string `json:"version"`
+	// 导入模式: merge按类型更新或新建, replace同时删除文档之外的规则, 默认merge
+	Mode string `json:"mode"`
+	// 跳过未知规则类型而不是整体报错
+	SkipUnknown bool `json:"skip_unknown"`
+	// 规则配置列表
+	Rules []SuggestSysRuleConfig `json:"rules"`
+}
+
+type SuggestSysRuleImportResult struct {
+	// 建议规则类型
+	Type string `json:"type"`
+	// 执行动作: created/updated/deleted/skipped
+	Action string `json:"action"`
+}
+
+type SuggestSysRuleImportOutput struct {
+	apis.Meta
+
+	// 逐规则导入结果
+	Results []SuggestSysRuleImportResult `json:"results"`
+}
+
+type SuggestSysRuleCreateInput struct {
+	apis.StandaloneResourceCreateInput
+
+	// 建议规则类型
+	Type string `json:"type"`
+	// 规则执行周期
+	Period string `json:"period"`
+	// 规则执行时间表, 标准5段cron表达式, 与period互斥
+	CronExpr string `json:"cron_expr"`
+	// 启用规则
+	Enabled *bool `json:"enabled"`
+	// 规则配置
+	Setting *SSuggestSysAlertSetting `json:"setting"`
+}
+
+type SuggestSysRuleUpdateInput struct {
+	apis.Meta
+
+	// 规则执行周期
+	Period *string `json:"period"`
+	// 规则执行时间表, 标准5段cron表达式, 与period互斥
+	CronExpr *string `json:"cron_expr"`
+	// 启用规则
+	Enabled *bool `json:"enabled"`
+	// 规则配置
+	Setting *SSuggestSysAlertSetting `json:"setting"`
+}
+
+type SuggestSysRuleListInput struct {
+	apis.StandaloneResourceListInput
+	apis.EnabledResourceBaseListInput
+
+	// 以建议规则类型过滤列表
+	Type string `json:"type"`
+}
+
+type SuggestSysRuleRunInput struct {
+	apis.Meta
+
+	// 预演模式, 只评估并返回将产生的建议告警, 不写入数据库
+	DryRun bool `json:"dry_run"`
+	// 预演结果中返回的候选资源数量上限, 默认10
+	Limit int `json:"limit"`
+}
+
+// PreviewAlert is one alert a rule would create, reported by a dry run
+type PreviewAlert struct {
+	// 资源ID
+	ResId string `json:"res_id"`
+	// 资源名称
+	Name string `json:"name"`
+	// 资源所属项目ID
+	ProjectId string `json:"project_id,omitempty"`
+	// 建议内容
+	Suggest string `json:"suggest"`
+	// 处理动作
+	Action string `json:"action"`
+}
+
+type SuggestSysRuleRunOutput struct {
+	apis.Meta
+
+	// 是否为预演
+	DryRun bool `json:"dry_run"`
+	// 将产生的建议告警总数
+	Total int `json:"total"`
+	// 按项目统计的告警数量
+	ProjectCounts map[string]int `json:"project_counts,omitempty"`
+	// 前若干个候选资源
+	Candidates []PreviewAlert `json:"candidates,omitempty"`
+}
+
+type SuggestSysRuleDetails struct {
+	apis.StandaloneResourceDetails
+
+	Type     string `json:"type"`
+	Period   string `json:"period"`
+	CronExpr string `json:"cron_expr"`
+	// 下一次计划执行时间
+	NextRunAt time.Time                `json:"next_run_at"`
+	Setting   *SSuggestSysAlertSetting `json:"setting"`
+	// 最近若干次执行记录, 最新的在最后
+	ExecHistory []SuggestRuleExecResult `json:"exec_history,omitempty"`
+}
+
+// SSuggestSysAlertSetting is the per-type configuration of a suggest
+// rule, only the field matching the rule type is used
+type SSuggestSysAlertSetting struct {
+	EIPUnused        *EIPUnused          `json:"eip_unused"`
+	Webhook          *SuggestRuleWebhook `json:"webhook"`
+	SnapshotOrphaned *SnapshotOrphaned   `json:"snapshot_orphaned"`
+	ImageUnused      *ImageUnused        `json:"image_unused"`
+
+	// 范围限制, 被排除的项目或域不会产生建议告警
+	Scope *SSuggestAlertScope `json:"scope"`
+}
+
+// SSuggestAlertScope restricts which projects a suggest rule applies
+// to, an empty scope keeps the rule cluster-wide
+type SSuggestAlertScope struct {
+	// 排除的项目ID列表
+	ExcludeProjectIds []string `json:"exclude_project_ids"`
+	// 排除的域ID列表
+	ExcludeDomainIds []string `json:"exclude_domain_ids"`
+	// 仅包含的项目ID列表, 为空表示不限制
+	IncludeOnlyProjectIds []string `json:"include_only_project_ids"`
+}
+
+// ShouldInclude reports whether a resource of the given project and
+// domain is in scope. A nil scope includes everything.
+func (s *SSuggestAlertScope) ShouldInclude(projectId string, domainId string) bool {
+	if s == nil {
+		return true
+	}
+	if len(s.IncludeOnlyProjectIds) > 0 {
+		found := false
+		for _, id := range s.IncludeOnlyProjectIds {
+			if id == projectId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, id := range s.ExcludeProjectIds {
+		if id == projectId {
+			return false
+		}
+	}
+	for _, id := range s.ExcludeDomainIds {
+		if id == domainId {
+			return false
+		}
+	}
+	return true
+}
+
+type EIPUnused struct {
+	// 只检查指定状态的EIP
+	Status string `json:"status"`
+}
+
+// SnapshotOrphaned 配置孤立快照检查
+type SnapshotOrphaned struct {
+	// 手动快照的保留天数, 磁盘仍在但快照超过该天数且非快照策略自动创建时也产生建议, 0表示只检查磁盘已删除的快照
+	KeepDays int `json:"keep_days"`
+}
+
+// ImageUnused 配置长期未使用镜像检查
+type ImageUnused struct {
+	// 未使用天数阈值, 没有磁盘引用且超过该天数未更新的镜像产生建议, 默认90
+	UnusedDays int `json:"unused_days"`
+}
+
+// SuggestRuleWebhook configures an externally evaluated suggest rule:
+// the rule运行时向该地址POST一次请求, 由外部服务返回建议列表.
+// 执行计划沿用规则本身的period/cron_expr字段
+type SuggestRuleWebhook struct {
+	// webhook地址, 必须是http或https
+	Url string `json:"url"`
+	// 随请求发送的Authorization头, 可为空
+	AuthHeader string `json:"auth_header"`
+}
+
+// SuggestWebhookEntry is one suggestion reported by a webhook, each
+// entry carries its own resource type
+type SuggestWebhookEntry struct {
+	// 资源ID
+	ResId string `json:"res_id"`
+	// 资源类型
+	ResType string `json:"res_type"`
+	// 建议内容
+	Suggest string `json:"suggest"`
+	// 处理动作
+	Action string `json:"action"`
+	// 预计每月可节省的费用
+	Amount float64 `json:"amount"`
+}
+
+// SuggestRuleExecResult is one entry of a rule's bounded execution
+// history
+type SuggestRuleExecResult struct {
+	// 执行时间
+	Time time.Time `json:"time"`
+	// 是否成功
+	Success bool `json:"success"`
+	// 执行结果描述, 失败时为失败原因
+	Message string `json:"message,omitempty"`
+}
@@ -14,6 +14,8 @@
 
 package apis
 
+import "time"
+
 type ModelBaseDetails struct {
 	Meta
 
@@ -39,6 +41,27 @@ type ModelBaseShortDescDetail struct {
 type SharedProject struct {
 	Id   string `json:"id"`
 	Name string `json:"name"`
+	// 共享的目标项目是否已被删除
+	Deleted bool `json:"deleted"`
+	// 发起共享的用户Id
+	CreatedBy string `json:"created_by"`
+	// 共享时间
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	SHARABLE_ACCESS_REASON_OWNER          = "owner"
+	SHARABLE_ACCESS_REASON_PUBLIC_SYSTEM  = "public-system"
+	SHARABLE_ACCESS_REASON_PUBLIC_DOMAIN  = "public-domain"
+	SHARABLE_ACCESS_REASON_SHARED_PROJECT = "shared-project"
+	SHARABLE_ACCESS_REASON_DENIED         = "denied"
+)
+
+type SharableAccessOutput struct {
+	// 查询的目标项目是否可以使用该资源
+	Access bool `json:"access"`
+	// 判定依据: owner|public-system|public-domain|shared-project|denied
+	Reason string `json:"reason"`
 }
 
 type SharableVirtualResourceDetails struct {
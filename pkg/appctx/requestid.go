@@ -0,0 +1,62 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appctx
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"yunion.io/x/pkg/util/stringutils"
+)
+
+// GenerateRequestId creates a fresh request id for a new call chain
+func GenerateRequestId() string {
+	return stringutils.UUID4()
+}
+
+// EnsureRequestId returns ctx unchanged when it already carries a
+// request id, otherwise a derived context carrying a freshly generated
+// one, so every entry point can guarantee cross-service log
+// correlation
+func EnsureRequestId(ctx context.Context) context.Context {
+	if len(AppContextRequestId(ctx)) > 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, APP_CONTEXT_KEY_REQUEST_ID, GenerateRequestId())
+}
+
+// the hop marker is '#', which can appear neither in a generated UUID
+// nor in a caller-supplied id ending in "-<digits>", so a base id is
+// never mistaken for an already-forwarded one
+var requestIdHopPattern = regexp.MustCompile(`^(.+)#(\d+)$`)
+
+// ForwardRequestId derives the id a service should send downstream
+// when forwarding a request it received: the first hop appends "#1"
+// and subsequent hops increment the counter (id#1, id#2, ...), keeping
+// the shared prefix so the whole call chain greps together. An empty
+// id starts a new chain.
+func ForwardRequestId(id string) string {
+	if len(id) == 0 {
+		return GenerateRequestId()
+	}
+	if m := requestIdHopPattern.FindStringSubmatch(id); m != nil {
+		if hop, err := strconv.Atoi(m[2]); err == nil {
+			return fmt.Sprintf("%s#%d", m[1], hop+1)
+		}
+	}
+	return id + "#1"
+}
@@ -58,6 +58,8 @@ type Application struct {
 	processTimeout    time.Duration
 	defHandlerInfo    SHandlerInfo
 	cors              *Cors
+	corsLock          sync.RWMutex
+	prefixCors        []sPrefixCors
 	middlewares       []MiddlewareFunc
 	hostId            string
 
@@ -239,14 +241,15 @@ func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *Application) handleCORS(w http.ResponseWriter, r *http.Request) bool {
-	if app.cors == nil {
+	cors := app.corsForPath(r.URL.Path)
+	if cors == nil {
 		return false
 	}
 	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
-		app.cors.handlePreflight(w, r)
+		cors.handlePreflight(w, r)
 		return true
 	} else {
-		app.cors.handleActualRequest(w, r)
+		cors.handleActualRequest(w, r)
 		return false
 	}
 }
@@ -490,9 +490,8 @@ func (app *Application) CORSAllowAll() {
 	app.CORSAllowHosts([]string{"*"})
 }
 
-func (app *Application) CORSAllowHosts(hosts []string) {
-	log.Println("Allow hosts", hosts)
-	options := CorsOptions{
+func corsOptionsForHosts(hosts []string) CorsOptions {
+	return CorsOptions{
 		AllowedOrigins:   hosts,
 		AllowedMethods:   []string{"HEAD", "GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowedHeaders:   []string{"*"},
@@ -500,9 +499,88 @@ func (app *Application) CORSAllowHosts(hosts []string) {
 		AllowCredentials: true,
 		// Debug: true,
 	}
-	app.EnableCORS(options)
+}
+
+func (app *Application) CORSAllowHosts(hosts []string) {
+	log.Println("Allow hosts", hosts)
+	app.EnableCORS(corsOptionsForHosts(hosts))
 }
 
 func (app *Application) EnableCORS(options CorsOptions) {
-	app.cors = NewCors(options)
+	cors := NewCors(options)
+	app.corsLock.Lock()
+	defer app.corsLock.Unlock()
+	app.cors = cors
+}
+
+// sPrefixCors binds a CORS policy to a path prefix
+type sPrefixCors struct {
+	prefix string
+	cors   *Cors
+}
+
+func normalizeCorsPrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// EnableCORSForPrefix installs or replaces the CORS policy applied to
+// requests whose path falls under prefix, e.g. "/api/v2". The longest
+// matching prefix wins over the application-wide policy. The policy may
+// be swapped at runtime, e.g. when service options are reloaded.
+func (app *Application) EnableCORSForPrefix(prefix string, options CorsOptions) {
+	prefix = normalizeCorsPrefix(prefix)
+	cors := NewCors(options)
+	app.corsLock.Lock()
+	defer app.corsLock.Unlock()
+	for i := range app.prefixCors {
+		if app.prefixCors[i].prefix == prefix {
+			app.prefixCors[i].cors = cors
+			return
+		}
+	}
+	app.prefixCors = append(app.prefixCors, sPrefixCors{prefix: prefix, cors: cors})
+}
+
+// CORSAllowHostsForPrefix is the per-prefix counterpart of
+// CORSAllowHosts
+func (app *Application) CORSAllowHostsForPrefix(prefix string, hosts []string) {
+	app.EnableCORSForPrefix(prefix, corsOptionsForHosts(hosts))
+}
+
+// DisableCORSForPrefix removes a previously installed per-prefix
+// policy, requests under the prefix fall back to the application-wide
+// policy
+func (app *Application) DisableCORSForPrefix(prefix string) {
+	prefix = normalizeCorsPrefix(prefix)
+	app.corsLock.Lock()
+	defer app.corsLock.Unlock()
+	for i := range app.prefixCors {
+		if app.prefixCors[i].prefix == prefix {
+			app.prefixCors = append(app.prefixCors[:i], app.prefixCors[i+1:]...)
+			return
+		}
+	}
+}
+
+// corsForPath picks the policy of the longest matching prefix, falling
+// back to the application-wide policy, nil when CORS is not enabled
+func (app *Application) corsForPath(path string) *Cors {
+	app.corsLock.RLock()
+	defer app.corsLock.RUnlock()
+	var best *Cors
+	bestLen := -1
+	for i := range app.prefixCors {
+		p := app.prefixCors[i].prefix
+		if (path == p || strings.HasPrefix(path, p+"/")) && len(p) > bestLen {
+			best = app.prefixCors[i].cors
+			bestLen = len(p)
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return app.cors
 }
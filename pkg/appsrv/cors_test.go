@@ -0,0 +1,114 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appsrv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newPreflightRequest(path string, origin string, method string) *http.Request {
+	req := httptest.NewRequest("OPTIONS", path, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	return req
+}
+
+func TestCORSPreflightAllowed(t *testing.T) {
+	app := &Application{}
+	app.CORSAllowHosts([]string{"dashboard.example.com"})
+
+	w := httptest.NewRecorder()
+	handled := app.handleCORS(w, newPreflightRequest("/databases", "https://dashboard.example.com", "GET"))
+	if !handled {
+		t.Fatalf("preflight request must be handled by CORS")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET" {
+		t.Errorf("Access-Control-Allow-Methods = %q, want GET", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+}
+
+func TestCORSPreflightRejected(t *testing.T) {
+	app := &Application{}
+	app.CORSAllowHosts([]string{"dashboard.example.com"})
+
+	w := httptest.NewRecorder()
+	handled := app.handleCORS(w, newPreflightRequest("/databases", "https://evil.example.org", "GET"))
+	if !handled {
+		t.Fatalf("preflight request must still terminate at the CORS layer")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("rejected preflight must not carry Access-Control-Allow-Origin, got %q", got)
+	}
+}
+
+func TestCORSSimpleRequestHeaderInjection(t *testing.T) {
+	app := &Application{}
+	app.CORSAllowHosts([]string{"*.example.com"})
+
+	req := httptest.NewRequest("GET", "/databases", nil)
+	req.Header.Set("Origin", "https://grafana.example.com")
+	w := httptest.NewRecorder()
+	handled := app.handleCORS(w, req)
+	if handled {
+		t.Fatalf("simple request must continue down the handler chain")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://grafana.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the wildcard-matched origin", got)
+	}
+}
+
+func TestCORSPerPrefixPolicy(t *testing.T) {
+	app := &Application{}
+	app.CORSAllowHostsForPrefix("/monitor", []string{"dashboard.example.com"})
+
+	// under the prefix, the per-prefix policy answers
+	w := httptest.NewRecorder()
+	handled := app.handleCORS(w, newPreflightRequest("/monitor/databases", "https://dashboard.example.com", "GET"))
+	if !handled {
+		t.Fatalf("preflight under prefix must be handled")
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://dashboard.example.com" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want the request origin", got)
+	}
+
+	// outside the prefix there is no policy at all
+	w = httptest.NewRecorder()
+	if handled := app.handleCORS(w, newPreflightRequest("/servers", "https://dashboard.example.com", "GET")); handled {
+		t.Errorf("request outside the prefix must not be handled by CORS")
+	}
+
+	// hot swap: replacing the policy takes effect immediately
+	app.CORSAllowHostsForPrefix("/monitor", []string{"other.example.com"})
+	w = httptest.NewRecorder()
+	app.handleCORS(w, newPreflightRequest("/monitor/databases", "https://dashboard.example.com", "GET"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("old origin must be rejected after policy swap, got %q", got)
+	}
+
+	// removing the policy restores the default
+	app.DisableCORSForPrefix("/monitor")
+	w = httptest.NewRecorder()
+	if handled := app.handleCORS(w, newPreflightRequest("/monitor/databases", "https://dashboard.example.com", "GET")); handled {
+		t.Errorf("request must not be handled after the prefix policy is removed")
+	}
+}
@@ -30,6 +30,7 @@ func InitApp(options *common_options.BaseOptions, dbAccess bool) *appsrv.Applica
 	// cache := appsrv.NewCache(options.AuthTokenCacheSize)
 	app := appsrv.NewApplication(options.ApplicationID, options.RequestWorkerCount, dbAccess)
 	app.CORSAllowHosts(options.CorsHosts)
+	common_options.SetCorsHostsReloadFunc(app.CORSAllowHosts)
 
 	// app.SetContext(appsrv.APP_CONTEXT_KEY_CACHE, cache)
 	// if dbConn != nil {
@@ -601,6 +601,55 @@ func IsMetadataKeyVisiable(key string) bool {
 	return !(IsMetadataKeySysTag(key) || IsMetadataKeySystemAdmin(key))
 }
 
+// NormalizeMetadataQueryKey maps a user supplied filter key to the key
+// stored in the metadata table. Plain keys are treated as user tags;
+// system and cloud tag keys may only be queried when allowSystem is set,
+// so ordinary users cannot probe system metadata.
+func NormalizeMetadataQueryKey(key string, allowSystem bool) (string, error) {
+	if len(key) == 0 {
+		return "", httperrors.NewInputParameterError("empty metadata key")
+	}
+	if strings.HasPrefix(key, SYS_TAG_PREFIX) || strings.HasPrefix(key, CLOUD_TAG_PREFIX) {
+		if !allowSystem {
+			return "", httperrors.NewForbiddenError("not allowed to query metadata key %s", key)
+		}
+		return key, nil
+	}
+	if strings.HasPrefix(key, USER_TAG_PREFIX) {
+		return key, nil
+	}
+	return USER_TAG_PREFIX + key, nil
+}
+
+func metadataObjIdSubQuery(objType string, key string, value string) *sqlchemy.SSubQuery {
+	q := Metadata.Query("obj_id").Equals("obj_type", objType).Equals("key", key)
+	if len(value) > 0 {
+		q = q.Equals("value", value)
+	}
+	return q.Distinct().SubQuery()
+}
+
+// ApplyMetadataKeyValueFilters narrows q to objects of objType matching
+// every metadata key/value pair (empty value matches any) and carrying
+// none of the withoutKeys, with AND semantics across pairs.
+func ApplyMetadataKeyValueFilters(q *sqlchemy.SQuery, objType string, metadata map[string]string, withoutKeys []string, allowSystem bool) (*sqlchemy.SQuery, error) {
+	for key, value := range metadata {
+		nkey, err := NormalizeMetadataQueryKey(key, allowSystem)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Filter(sqlchemy.In(q.Field("id"), metadataObjIdSubQuery(objType, nkey, value)))
+	}
+	for _, key := range withoutKeys {
+		nkey, err := NormalizeMetadataQueryKey(key, allowSystem)
+		if err != nil {
+			return nil, err
+		}
+		q = q.Filter(sqlchemy.NotIn(q.Field("id"), metadataObjIdSubQuery(objType, nkey, "")))
+	}
+	return q, nil
+}
+
 func GetVisiableMetadata(model IStandaloneModel, userCred mcclient.TokenCredential) (map[string]string, error) {
 	metaData, err := model.GetAllMetadata(userCred)
 	if err != nil {
@@ -171,3 +171,57 @@ func TestGetVisiableMetadata(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizeMetadataQueryKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		allowSystem bool
+		want        string
+		wantErr     bool
+	}{
+		{
+			name: "plain key becomes user tag",
+			key:  "owner",
+			want: "user:owner",
+		},
+		{
+			name: "explicit user tag kept as is",
+			key:  "user:cost-center",
+			want: "user:cost-center",
+		},
+		{
+			name:    "sys tag forbidden for ordinary user",
+			key:     "__os_arch",
+			wantErr: true,
+		},
+		{
+			name:    "cloud tag forbidden for ordinary user",
+			key:     "ext:project",
+			wantErr: true,
+		},
+		{
+			name:        "sys tag allowed for admin",
+			key:         "__os_arch",
+			allowSystem: true,
+			want:        "__os_arch",
+		},
+		{
+			name:    "empty key rejected",
+			key:     "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeMetadataQueryKey(tt.key, tt.allowSystem)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NormalizeMetadataQueryKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeMetadataQueryKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
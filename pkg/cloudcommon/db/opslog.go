@@ -151,6 +151,8 @@ const (
 	ACT_EIP_ATTACH = "eip_attach"
 	ACT_EIP_DETACH = "eip_detach"
 
+	ACT_ROUTE_BLACKHOLE = "route_blackhole"
+
 	ACT_SET_METADATA = "set_meta"
 	ACT_DEL_METADATA = "del_meta"
 
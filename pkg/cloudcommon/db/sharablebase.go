@@ -22,6 +22,7 @@ import (
 
 	"yunion.io/x/onecloud/pkg/apis"
 	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
@@ -86,14 +87,52 @@ func SharablePerformPublic(model ISharableBase, ctx context.Context, userCred mc
 		return nil, httperrors.NewForbiddenError("not owner")
 	}*/
 
-	diff, err := Update(model, func() error {
-		model.SetIsPublic(true)
+	err := sharableChangeState(ctx, model,
+		func() error { return Fetch(model) },
+		func() bool { return !model.GetIsPublic() },
+		func() error {
+			diff, err := Update(model, func() error {
+				model.SetIsPublic(true)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			OpsLog.LogEvent(model, ACT_UPDATE, sharingAuditNotes(diff, userCred, false, true), userCred)
+			return nil
+		})
+	return nil, err
+}
+
+// sharableChangeState serializes a sharing state transition on the
+// per-object lock. The state is re-read inside the lock and re-checked
+// before applying, so two concurrent public/private calls take turns
+// instead of interleaving between their read and their update.
+func sharableChangeState(ctx context.Context, model lockman.ILockedObject, refresh func() error, needChange func() bool, apply func() error) error {
+	lockman.LockObject(ctx, model)
+	defer lockman.ReleaseObject(ctx, model)
+
+	if err := refresh(); err != nil {
+		return err
+	}
+	if !needChange() {
+		// the other caller won the race and the state is already final
 		return nil
-	})
-	if err == nil {
-		OpsLog.LogEvent(model, ACT_UPDATE, diff, userCred)
 	}
-	return nil, err
+	return apply()
+}
+
+// sharingAuditNotes wraps an update diff with the acting user and the
+// visibility transition, so the ops log answers who shared a resource
+// and when, not just what changed
+func sharingAuditNotes(diff sqlchemy.UpdateDiffs, userCred mcclient.TokenCredential, wasPublic bool, isPublic bool) *jsonutils.JSONDict {
+	notes := jsonutils.NewDict()
+	notes.Add(jsonutils.NewString(diff.String()), "diff")
+	notes.Add(jsonutils.NewString(userCred.GetUserId()), "user_id")
+	notes.Add(jsonutils.NewString(userCred.GetUserName()), "user")
+	notes.Add(jsonutils.NewBool(wasPublic), "was_public")
+	notes.Add(jsonutils.NewBool(isPublic), "is_public")
+	return notes
 }
 
 func SharableAllowPerformPrivate(model ISharableBase, userCred mcclient.TokenCredential) bool {
@@ -115,13 +154,20 @@ func SharablePerformPrivate(model ISharableBase, ctx context.Context, userCred m
 		return nil, httperrors.NewForbiddenError("not owner")
 	}*/
 
-	diff, err := Update(model, func() error {
-		model.SetIsPublic(false)
-		return nil
-	})
-	if err == nil {
-		OpsLog.LogEvent(model, ACT_UPDATE, diff, userCred)
-	}
+	err := sharableChangeState(ctx, model,
+		func() error { return Fetch(model) },
+		func() bool { return model.GetIsPublic() },
+		func() error {
+			diff, err := Update(model, func() error {
+				model.SetIsPublic(false)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			OpsLog.LogEvent(model, ACT_UPDATE, sharingAuditNotes(diff, userCred, true, false), userCred)
+			return nil
+		})
 	return nil, err
 }
 
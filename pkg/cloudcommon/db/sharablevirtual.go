@@ -25,6 +25,7 @@ import (
 
 	"yunion.io/x/onecloud/pkg/apis"
 	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
@@ -112,11 +113,84 @@ func (model *SSharableVirtualResourceBase) IsSharable(reqUsrId mcclient.IIdentit
 	return false
 }
 
+// evaluateSharableAccess decides whether the target project may use a
+// resource, mirroring the visibility rules FilterByOwner applies at
+// list time, and names the rule that granted or denied access
+func evaluateSharableAccess(ownerProjectId string, ownerDomainId string, isPublic bool, publicScope string, targetProjectId string, targetDomainId string, sharedToTarget bool) (bool, string) {
+	if targetProjectId == ownerProjectId {
+		return true, apis.SHARABLE_ACCESS_REASON_OWNER
+	}
+	if isPublic {
+		switch rbacutils.String2Scope(publicScope) {
+		case rbacutils.ScopeSystem:
+			return true, apis.SHARABLE_ACCESS_REASON_PUBLIC_SYSTEM
+		case rbacutils.ScopeDomain:
+			if targetDomainId == ownerDomainId {
+				return true, apis.SHARABLE_ACCESS_REASON_PUBLIC_DOMAIN
+			}
+		}
+	}
+	if sharedToTarget {
+		return true, apis.SHARABLE_ACCESS_REASON_SHARED_PROJECT
+	}
+	return false, apis.SHARABLE_ACCESS_REASON_DENIED
+}
+
+// sharableAccessQueryAllowed reports whether a caller may evaluate the
+// access of targetProject: everyone may check their own project, any
+// other target needs admin privilege, probed lazily
+func sharableAccessQueryAllowed(callerProjectId string, targetProject string, isAdmin func() bool) bool {
+	if len(targetProject) == 0 || targetProject == callerProjectId {
+		return true
+	}
+	return isAdmin()
+}
+
+func (model *SSharableVirtualResourceBase) AllowGetDetailsAccess(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	// everyone may check their own project, querying an arbitrary
+	// project is guarded inside GetDetailsAccess
+	return true
+}
+
+// GetDetailsAccess answers "can project P use this resource" together
+// with the reason. Without a project_id the caller's own project is
+// checked; naming another project requires admin privilege.
+func (model *SSharableVirtualResourceBase) GetDetailsAccess(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	targetProjectId := userCred.GetProjectId()
+	targetDomainId := userCred.GetProjectDomainId()
+	projectStr := jsonutils.GetAnyString(query, []string{"project_id", "project"})
+	if !sharableAccessQueryAllowed(targetProjectId, projectStr, func() bool {
+		return IsAdminAllowGetSpec(userCred, model, "access")
+	}) {
+		return nil, httperrors.NewForbiddenError("not allowed to query access of other projects")
+	}
+	if len(projectStr) > 0 && projectStr != targetProjectId {
+		tenant, err := TenantCacheManager.FetchTenantByIdOrName(ctx, projectStr)
+		if err != nil {
+			return nil, httperrors.NewBadRequestError("fetch tenant %s error %s", projectStr, err)
+		}
+		targetProjectId = tenant.GetId()
+		targetDomainId = tenant.DomainId
+	}
+	shared := utils.IsInStringArray(targetProjectId, model.GetSharedProjects())
+	access, reason := evaluateSharableAccess(model.ProjectId, model.DomainId, model.IsPublic, model.PublicScope, targetProjectId, targetDomainId, shared)
+	return jsonutils.Marshal(apis.SharableAccessOutput{Access: access, Reason: reason}), nil
+}
+
 func (model *SSharableVirtualResourceBase) AllowPerformPublic(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformProjectPublicInput) bool {
 	return IsAllowPerform(rbacutils.ScopeSystem, userCred, model, "public")
 }
 
 func (model *SSharableVirtualResourceBase) PerformPublic(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformProjectPublicInput) (jsonutils.JSONObject, error) {
+	// shared_resources rows and public_scope must change together;
+	// hold the object lock for the whole operation and work on fresh
+	// state so a concurrent private call cannot interleave
+	lockman.LockObject(ctx, model)
+	defer lockman.ReleaseObject(ctx, model)
+	if err := Fetch(model); err != nil {
+		return nil, httperrors.NewInternalServerError("refresh model error %s", err)
+	}
+
 	targetScope := rbacutils.String2ScopeDefault(input.Scope, rbacutils.ScopeSystem)
 	if targetScope == rbacutils.ScopeProject {
 		if len(input.SharedProjects) > 0 {
@@ -160,21 +234,30 @@ func (model *SSharableVirtualResourceBase) PerformPublic(ctx context.Context, us
 				}
 			}
 
-			for i := 0; i < len(addProjects); i++ {
+			insertedRows := make(map[string]*SSharedResource, len(addProjects))
+			insertShare := func(targetProjectId string) error {
 				sharedResource := new(SSharedResource)
 				sharedResource.ResourceType = model.GetModelManager().Keyword()
 				sharedResource.ResourceId = model.Id
 				sharedResource.OwnerProjectId = model.ProjectId
-				sharedResource.TargetProjectId = addProjects[i]
+				sharedResource.TargetProjectId = targetProjectId
+				sharedResource.CreatedBy = userCred.GetUserId()
 				if insetErr := SharedResourceManager.TableSpec().Insert(sharedResource); insetErr != nil {
-					return nil, httperrors.NewInternalServerError("Insert shared resource failed %s", insetErr)
+					return httperrors.NewInternalServerError("Insert shared resource failed %s", insetErr)
 				}
+				insertedRows[targetProjectId] = sharedResource
+				return nil
 			}
-			for i := 0; i < len(delProjects); i++ {
-				delProjects[i].SetModelManager(SharedResourceManager, delProjects[i])
-				if err := delProjects[i].Delete(ctx, userCred); err != nil {
-					return nil, httperrors.NewInternalServerError("Unshare project failed %s", err)
+			removeShare := func(targetProjectId string) error {
+				sr := insertedRows[targetProjectId]
+				if sr == nil {
+					return nil
 				}
+				sr.SetModelManager(SharedResourceManager, sr)
+				return sr.Delete(ctx, userCred)
+			}
+			if err := grantSharesToTargets(ctx, addProjects, model.DomainId, model.GetModelManager().Keyword(), insertShare, removeShare); err != nil {
+				return nil, err
 			}
 
 			if (len(addProjects) + len(srs) - len(delProjects)) > 0 {
@@ -183,11 +266,24 @@ func (model *SSharableVirtualResourceBase) PerformPublic(ctx context.Context, us
 					model.PublicScope = string(targetScope)
 					return nil
 				})
-				if err == nil {
-					OpsLog.LogEvent(model, ACT_UPDATE, diff, userCred)
-				} else {
+				if err != nil {
+					// freshly inserted grants must not outlive a
+					// failed scope update
+					for i := range addProjects {
+						if rbErr := removeShare(addProjects[i]); rbErr != nil {
+							log.Errorf("rollback share of %s to %s error: %s", model.GetId(), addProjects[i], rbErr)
+						}
+					}
 					return nil, err
 				}
+				OpsLog.LogEvent(model, ACT_UPDATE, diff, userCred)
+			}
+			// stale grants go away only once the new state is persisted
+			for i := 0; i < len(delProjects); i++ {
+				delProjects[i].SetModelManager(SharedResourceManager, delProjects[i])
+				if err := delProjects[i].Delete(ctx, userCred); err != nil {
+					return nil, httperrors.NewInternalServerError("Unshare project failed %s", err)
+				}
 			}
 		} else {
 			return nil, httperrors.NewMissingParameterError("shared_projects")
@@ -220,6 +316,12 @@ func (model *SSharableVirtualResourceBase) AllowPerformPrivate(ctx context.Conte
 }
 
 func (model *SSharableVirtualResourceBase) PerformPrivate(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformProjectPrivateInput) (jsonutils.JSONObject, error) {
+	lockman.LockObject(ctx, model)
+	defer lockman.ReleaseObject(ctx, model)
+	if err := Fetch(model); err != nil {
+		return nil, httperrors.NewInternalServerError("refresh model error %s", err)
+	}
+
 	if model.IsPublic {
 		allowScope := policy.PolicyManager.AllowScope(userCred, consts.GetServiceType(), model.GetModelManager().KeywordPlural(), policy.PolicyActionPerform, "private")
 		requireScope := rbacutils.String2ScopeDefault(model.PublicScope, rbacutils.ScopeSystem)
@@ -283,9 +385,139 @@ func (manager *SSharableVirtualResourceBaseManager) ValidateCreateData(ctx conte
 	if err != nil {
 		return input, errors.Wrap(err, "manager.VirtualResourceBaseManager.ValidateCreateData")
 	}
+	input, err = SharableManagerValidateCreateData(manager.GetISharableVirtualModelManager(), ctx, userCred, ownerId, input)
+	if err != nil {
+		return input, err
+	}
+	return input, nil
+}
+
+// validateSharedProjectTargets resolves each create-time share target
+// through fetchTenant and checks that it lives in the owner domain and
+// is not the owner project itself, returning the deduplicated ids
+func validateSharedProjectTargets(ownerDomainId string, ownerProjectId string, sharedProjects []string, fetchTenant func(idOrName string) (string, string, error)) ([]string, error) {
+	ids := make([]string, 0, len(sharedProjects))
+	for _, target := range sharedProjects {
+		id, domainId, err := fetchTenant(target)
+		if err != nil {
+			return nil, httperrors.NewBadRequestError("fetch tenant %s error %s", target, err)
+		}
+		if domainId != ownerDomainId {
+			return nil, httperrors.NewBadRequestError("can't share project to other domain")
+		}
+		if id == ownerProjectId {
+			return nil, httperrors.NewBadRequestError("can't share project to yourself")
+		}
+		if !utils.IsInStringArray(id, ids) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// validateSharedDomainTargets checks create-time domain share targets;
+// the shared resource table only models project grants, so domain
+// sharing is limited to publishing within the owner domain itself
+func validateSharedDomainTargets(ownerDomainId string, sharedDomains []string, fetchDomain func(idOrName string) (string, error)) error {
+	for _, target := range sharedDomains {
+		id, err := fetchDomain(target)
+		if err != nil {
+			return httperrors.NewBadRequestError("fetch domain %s error %s", target, err)
+		}
+		if id != ownerDomainId {
+			return httperrors.NewNotSupportedError("sharing to other domains at create time is not supported")
+		}
+	}
+	return nil
+}
+
+// SharableManagerValidateCreateData validates the create-time share
+// targets of input so an invalid target fails the whole create before
+// the resource row exists. Project names are normalized to ids; domain
+// targets turn into a domain-scope public resource.
+func SharableManagerValidateCreateData(manager ISharableVirtualModelManager, ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, input apis.SharableVirtualResourceCreateInput) (apis.SharableVirtualResourceCreateInput, error) {
+	if len(input.SharedProjects) == 0 && len(input.SharedDomains) == 0 {
+		return input, nil
+	}
+	if len(input.SharedDomains) > 0 {
+		allowScope := policy.PolicyManager.AllowScope(userCred, consts.GetServiceType(), manager.KeywordPlural(), policy.PolicyActionPerform, "public")
+		if rbacutils.ScopeDomain.HigherThan(allowScope) {
+			return input, httperrors.NewForbiddenError("not enough privilege to share to domain")
+		}
+		err := validateSharedDomainTargets(ownerId.GetProjectDomainId(), input.SharedDomains, func(idOrName string) (string, error) {
+			domain, err := TenantCacheManager.FetchDomainByIdOrName(ctx, idOrName)
+			if err != nil {
+				return "", err
+			}
+			return domain.GetId(), nil
+		})
+		if err != nil {
+			return input, err
+		}
+		isPublic := true
+		input.IsPublic = &isPublic
+		input.PublicScope = string(rbacutils.ScopeDomain)
+	}
+	if len(input.SharedProjects) > 0 {
+		ids, err := validateSharedProjectTargets(ownerId.GetProjectDomainId(), ownerId.GetProjectId(), input.SharedProjects, func(idOrName string) (string, string, error) {
+			tenant, err := TenantCacheManager.FetchTenantByIdOrName(ctx, idOrName)
+			if err != nil {
+				return "", "", err
+			}
+			return tenant.GetId(), tenant.DomainId, nil
+		})
+		if err != nil {
+			return input, err
+		}
+		input.SharedProjects = ids
+	}
 	return input, nil
 }
 
+func (model *SSharableVirtualResourceBase) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	model.SVirtualResourceBase.PostCreate(ctx, userCred, ownerId, query, data)
+	sharedProjects := make([]string, 0)
+	if data != nil {
+		data.Unmarshal(&sharedProjects, "shared_projects")
+	}
+	if len(sharedProjects) > 0 {
+		if err := SharableModelPostCreate(model, ctx, userCred, sharedProjects); err != nil {
+			log.Errorf("%s %s apply create-time shares: %v", model.GetModelManager().Keyword(), model.GetId(), err)
+		}
+	}
+}
+
+// SharableModelPostCreate applies the create-time share grants, to be
+// called from PostCreate of managers embedding the sharable base. The
+// registered quota checker is consulted per target and a rejection or
+// failed insert rolls back the grants made so far, so a botched create
+// leaves no orphan rows behind.
+func SharableModelPostCreate(model *SSharableVirtualResourceBase, ctx context.Context, userCred mcclient.TokenCredential, sharedProjects []string) error {
+	insertedRows := make(map[string]*SSharedResource, len(sharedProjects))
+	insertShare := func(targetProjectId string) error {
+		sharedResource := new(SSharedResource)
+		sharedResource.ResourceType = model.GetModelManager().Keyword()
+		sharedResource.ResourceId = model.Id
+		sharedResource.OwnerProjectId = model.ProjectId
+		sharedResource.TargetProjectId = targetProjectId
+		sharedResource.CreatedBy = userCred.GetUserId()
+		if err := SharedResourceManager.TableSpec().Insert(sharedResource); err != nil {
+			return err
+		}
+		insertedRows[targetProjectId] = sharedResource
+		return nil
+	}
+	removeShare := func(targetProjectId string) error {
+		sr := insertedRows[targetProjectId]
+		if sr == nil {
+			return nil
+		}
+		sr.SetModelManager(SharedResourceManager, sr)
+		return sr.Delete(ctx, userCred)
+	}
+	return grantSharesToTargets(ctx, sharedProjects, model.DomainId, model.GetModelManager().Keyword(), insertShare, removeShare)
+}
+
 func (manager *SSharableVirtualResourceBaseManager) ListItemFilter(
 	ctx context.Context,
 	q *sqlchemy.SQuery,
@@ -359,6 +591,15 @@ func (manager *SSharableVirtualResourceBaseManager) FetchCustomizeColumns(
 		return rows
 	}
 
+	// shares whose target project misses from the tenant join point to
+	// a deleted project, flag them instead of dropping them silently
+	shares := make([]SSharedResource, 0)
+	shareQ := SharedResourceManager.Query().Equals("resource_type", manager.Keyword()).In("resource_id", resIds)
+	if err := shareQ.All(&shares); err != nil {
+		log.Errorf("query shared resources fail %s", err)
+	}
+	mergeSharedProjectAudit(projList, shares)
+
 	for i := range rows {
 		if projs, ok := projList[resIds[i]]; ok {
 			rows[i].SharedProjects = projs
@@ -367,3 +608,29 @@ func (manager *SSharableVirtualResourceBaseManager) FetchCustomizeColumns(
 
 	return rows
 }
+
+// mergeSharedProjectAudit fills the audit fields of the shared project
+// entries from the share rows and appends entries for shares whose
+// target project no longer exists
+func mergeSharedProjectAudit(projList map[string][]apis.SharedProject, shares []SSharedResource) {
+	for i := range shares {
+		found := false
+		entries := projList[shares[i].ResourceId]
+		for j := range entries {
+			if entries[j].Id == shares[i].TargetProjectId {
+				entries[j].CreatedBy = shares[i].CreatedBy
+				entries[j].CreatedAt = shares[i].CreatedAt
+				found = true
+				break
+			}
+		}
+		if !found {
+			projList[shares[i].ResourceId] = append(projList[shares[i].ResourceId], apis.SharedProject{
+				Id:        shares[i].TargetProjectId,
+				Deleted:   true,
+				CreatedBy: shares[i].CreatedBy,
+				CreatedAt: shares[i].CreatedAt,
+			})
+		}
+	}
+}
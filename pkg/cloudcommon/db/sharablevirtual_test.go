@@ -0,0 +1,248 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"yunion.io/x/sqlchemy"
+
+	"yunion.io/x/onecloud/pkg/apis"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+func TestValidateSharedProjectTargets(t *testing.T) {
+	projects := map[string]struct {
+		id       string
+		domainId string
+	}{
+		"proj-a":     {"pid-a", "domain-1"},
+		"proj-b":     {"pid-b", "domain-1"},
+		"proj-other": {"pid-other", "domain-2"},
+	}
+	fetchTenant := func(idOrName string) (string, string, error) {
+		if p, ok := projects[idOrName]; ok {
+			return p.id, p.domainId, nil
+		}
+		return "", "", fmt.Errorf("no such project %s", idOrName)
+	}
+
+	ids, err := validateSharedProjectTargets("domain-1", "pid-owner", []string{"proj-a", "proj-b", "proj-a"}, fetchTenant)
+	if err != nil {
+		t.Fatalf("share to projects in owner domain: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"pid-a", "pid-b"}) {
+		t.Errorf("expect deduplicated ids [pid-a pid-b], got %v", ids)
+	}
+
+	if _, err := validateSharedProjectTargets("domain-1", "pid-owner", []string{"proj-other"}, fetchTenant); err == nil {
+		t.Errorf("expect error sharing to project of another domain")
+	}
+	if _, err := validateSharedProjectTargets("domain-1", "pid-a", []string{"proj-a"}, fetchTenant); err == nil {
+		t.Errorf("expect error sharing to the owner project itself")
+	}
+	if _, err := validateSharedProjectTargets("domain-1", "pid-owner", []string{"missing"}, fetchTenant); err == nil {
+		t.Errorf("expect error for nonexistent project")
+	}
+}
+
+func TestValidateSharedDomainTargets(t *testing.T) {
+	domains := map[string]string{
+		"domain-1": "domain-1",
+		"Default":  "default",
+	}
+	fetchDomain := func(idOrName string) (string, error) {
+		if id, ok := domains[idOrName]; ok {
+			return id, nil
+		}
+		return "", fmt.Errorf("no such domain %s", idOrName)
+	}
+
+	if err := validateSharedDomainTargets("domain-1", []string{"domain-1"}, fetchDomain); err != nil {
+		t.Errorf("share to owner domain: %v", err)
+	}
+	if err := validateSharedDomainTargets("domain-1", []string{"Default"}, fetchDomain); err == nil {
+		t.Errorf("expect error sharing to another domain")
+	}
+	if err := validateSharedDomainTargets("domain-1", []string{"missing"}, fetchDomain); err == nil {
+		t.Errorf("expect error for nonexistent domain")
+	}
+}
+
+func TestMergeSharedProjectAudit(t *testing.T) {
+	sharedAt := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	projList := map[string][]apis.SharedProject{
+		"res-1": {
+			{Id: "pid-a", Name: "proj-a"},
+			{Id: "pid-b", Name: "proj-b"},
+		},
+	}
+	shares := []SSharedResource{
+		{ResourceId: "res-1", TargetProjectId: "pid-a", CreatedBy: "admin-1"},
+		{ResourceId: "res-1", TargetProjectId: "pid-gone", CreatedBy: "admin-2"},
+	}
+	shares[0].CreatedAt = sharedAt
+	shares[1].CreatedAt = sharedAt
+
+	mergeSharedProjectAudit(projList, shares)
+
+	entries := projList["res-1"]
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 entries, got %d", len(entries))
+	}
+	if entries[0].CreatedBy != "admin-1" || !entries[0].CreatedAt.Equal(sharedAt) {
+		t.Errorf("pid-a audit fields = %+v", entries[0])
+	}
+	if entries[1].CreatedBy != "" {
+		t.Errorf("pid-b has no share row, expect empty CreatedBy, got %q", entries[1].CreatedBy)
+	}
+	gone := entries[2]
+	if gone.Id != "pid-gone" || !gone.Deleted || gone.CreatedBy != "admin-2" || !gone.CreatedAt.Equal(sharedAt) {
+		t.Errorf("deleted project entry = %+v", gone)
+	}
+}
+
+type fakeLockedSharable struct {
+	isPublic bool
+	grants   int
+}
+
+func (f *fakeLockedSharable) Keyword() string { return "fakesharable" }
+func (f *fakeLockedSharable) GetId() string   { return "obj-1" }
+
+func TestSharableChangeStateConcurrent(t *testing.T) {
+	lockman.Init(lockman.NewInMemoryLockManager())
+
+	for round := 0; round < 20; round++ {
+		obj := &fakeLockedSharable{}
+		// the lock is held per request context, so each caller gets
+		// its own; both transitions touch two pieces of state, and
+		// the pause between the writes exposes interleaving when the
+		// object lock is not held for the whole transition
+		publicCtx, publicCancel := context.WithCancel(context.Background())
+		defer publicCancel()
+		privateCtx, privateCancel := context.WithCancel(context.Background())
+		defer privateCancel()
+		makePublic := func() {
+			sharableChangeState(publicCtx, obj,
+				func() error { return nil },
+				func() bool { return !obj.isPublic },
+				func() error {
+					obj.grants = 1
+					time.Sleep(time.Millisecond)
+					obj.isPublic = true
+					return nil
+				})
+		}
+		makePrivate := func() {
+			sharableChangeState(privateCtx, obj,
+				func() error { return nil },
+				func() bool { return obj.isPublic || obj.grants > 0 },
+				func() error {
+					obj.isPublic = false
+					time.Sleep(time.Millisecond)
+					obj.grants = 0
+					return nil
+				})
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); makePublic() }()
+		go func() { defer wg.Done(); makePrivate() }()
+		wg.Wait()
+
+		if obj.isPublic != (obj.grants == 1) {
+			t.Fatalf("round %d: inconsistent end state isPublic=%v grants=%d", round, obj.isPublic, obj.grants)
+		}
+	}
+}
+
+func TestSharingAuditNotes(t *testing.T) {
+	userCred := &mcclient.SSimpleToken{User: "sysadmin", UserId: "uid-1"}
+	notes := sharingAuditNotes(sqlchemy.UpdateDiffs{}, userCred, false, true)
+
+	if userId, _ := notes.GetString("user_id"); userId != "uid-1" {
+		t.Errorf("user_id = %q, want uid-1", userId)
+	}
+	if user, _ := notes.GetString("user"); user != "sysadmin" {
+		t.Errorf("user = %q, want sysadmin", user)
+	}
+	if wasPublic, _ := notes.Bool("was_public"); wasPublic {
+		t.Errorf("was_public should be false")
+	}
+	if isPublic, _ := notes.Bool("is_public"); !isPublic {
+		t.Errorf("is_public should be true")
+	}
+}
+
+func TestEvaluateSharableAccess(t *testing.T) {
+	cases := []struct {
+		name         string
+		isPublic     bool
+		publicScope  string
+		targetProj   string
+		targetDomain string
+		shared       bool
+		wantAccess   bool
+		wantReason   string
+	}{
+		{"owner project", false, "none", "pid-owner", "domain-1", false, true, apis.SHARABLE_ACCESS_REASON_OWNER},
+		{"public system", true, "system", "pid-other", "domain-2", false, true, apis.SHARABLE_ACCESS_REASON_PUBLIC_SYSTEM},
+		{"public domain same domain", true, "domain", "pid-other", "domain-1", false, true, apis.SHARABLE_ACCESS_REASON_PUBLIC_DOMAIN},
+		{"public domain other domain", true, "domain", "pid-other", "domain-2", false, false, apis.SHARABLE_ACCESS_REASON_DENIED},
+		{"shared project", false, "none", "pid-other", "domain-1", true, true, apis.SHARABLE_ACCESS_REASON_SHARED_PROJECT},
+		{"private unshared", false, "none", "pid-other", "domain-1", false, false, apis.SHARABLE_ACCESS_REASON_DENIED},
+	}
+	for _, c := range cases {
+		access, reason := evaluateSharableAccess("pid-owner", "domain-1", c.isPublic, c.publicScope, c.targetProj, c.targetDomain, c.shared)
+		if access != c.wantAccess || reason != c.wantReason {
+			t.Errorf("%s: got (%v, %s), want (%v, %s)", c.name, access, reason, c.wantAccess, c.wantReason)
+		}
+	}
+}
+
+func TestSharableAccessQueryAllowed(t *testing.T) {
+	adminProbed := false
+	admin := func() bool {
+		adminProbed = true
+		return true
+	}
+	notAdmin := func() bool { return false }
+
+	// checking oneself never consults the policy
+	if !sharableAccessQueryAllowed("pid-self", "", admin) {
+		t.Errorf("empty target must be allowed")
+	}
+	if !sharableAccessQueryAllowed("pid-self", "pid-self", admin) {
+		t.Errorf("own project must be allowed")
+	}
+	if adminProbed {
+		t.Errorf("self checks must not probe admin privilege")
+	}
+
+	if sharableAccessQueryAllowed("pid-self", "pid-other", notAdmin) {
+		t.Errorf("other project must be denied without admin privilege")
+	}
+	if !sharableAccessQueryAllowed("pid-self", "pid-other", admin) {
+		t.Errorf("other project must be allowed for admin")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/httperrors"
+)
+
+// SharedGrantQuotaChecker decides whether the target project may
+// receive delta more inbound shares of resourceType. A non-nil error
+// rejects the grant.
+type SharedGrantQuotaChecker func(ctx context.Context, targetProjectId string, targetDomainId string, resourceType string, delta int) error
+
+// sharedGrantQuotaChecker defaults to accepting everything, keeping
+// behavior unchanged until a deployment registers a real checker
+var sharedGrantQuotaChecker SharedGrantQuotaChecker
+
+// RegisterSharedGrantQuotaChecker installs the checker consulted
+// before shared resource rows are persisted, services call it at init
+// time
+func RegisterSharedGrantQuotaChecker(checker SharedGrantQuotaChecker) {
+	sharedGrantQuotaChecker = checker
+}
+
+// checkSharedGrantQuota consults the registered checker, translating
+// a rejection into a quota exceeded error for the API caller
+func checkSharedGrantQuota(ctx context.Context, targetProjectId string, targetDomainId string, resourceType string, delta int) error {
+	if sharedGrantQuotaChecker == nil {
+		return nil
+	}
+	if err := sharedGrantQuotaChecker(ctx, targetProjectId, targetDomainId, resourceType, delta); err != nil {
+		return httperrors.NewOutOfQuotaError("share %s to project %s: %v", resourceType, targetProjectId, err)
+	}
+	return nil
+}
+
+// grantSharesToTargets persists one shared resource row per target
+// project via insert, consulting the registered quota checker before
+// each row. A rejection or a failed insert rolls back the rows already
+// persisted via remove so a partial grant never sticks.
+func grantSharesToTargets(ctx context.Context, targetProjectIds []string, targetDomainId string, resourceType string, insert func(targetProjectId string) error, remove func(targetProjectId string) error) error {
+	granted := make([]string, 0, len(targetProjectIds))
+	rollback := func() {
+		for _, projectId := range granted {
+			if err := remove(projectId); err != nil {
+				log.Errorf("roll back share grant to project %s: %v", projectId, err)
+			}
+		}
+	}
+	for _, projectId := range targetProjectIds {
+		if err := checkSharedGrantQuota(ctx, projectId, targetDomainId, resourceType, 1); err != nil {
+			rollback()
+			return err
+		}
+		if err := insert(projectId); err != nil {
+			rollback()
+			return errors.Wrap(err, "insert shared resource")
+		}
+		granted = append(granted, projectId)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestGrantSharesToTargetsQuota(t *testing.T) {
+	// fake checker rejecting once a project holds threshold grants
+	const threshold = 1
+	granted := map[string]int{}
+	checker := func(ctx context.Context, targetProjectId string, targetDomainId string, resourceType string, delta int) error {
+		if granted[targetProjectId]+delta > threshold {
+			return fmt.Errorf("over threshold %d", threshold)
+		}
+		return nil
+	}
+	RegisterSharedGrantQuotaChecker(checker)
+	defer RegisterSharedGrantQuotaChecker(nil)
+
+	inserted := []string{}
+	insert := func(targetProjectId string) error {
+		granted[targetProjectId]++
+		inserted = append(inserted, targetProjectId)
+		return nil
+	}
+	remove := func(targetProjectId string) error {
+		granted[targetProjectId]--
+		for i := range inserted {
+			if inserted[i] == targetProjectId {
+				inserted = append(inserted[:i], inserted[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+
+	// both targets are under the threshold
+	if err := grantSharesToTargets(context.Background(), []string{"pid-a", "pid-b"}, "domain-1", "servertemplate", insert, remove); err != nil {
+		t.Fatalf("grant under threshold: %v", err)
+	}
+	if len(inserted) != 2 {
+		t.Fatalf("expect 2 grants, got %v", inserted)
+	}
+
+	// pid-a is at the threshold now; the second target of this batch is
+	// rejected and the grant to pid-c made before it must be rolled back
+	err := grantSharesToTargets(context.Background(), []string{"pid-c", "pid-a"}, "domain-1", "servertemplate", insert, remove)
+	if err == nil {
+		t.Fatalf("expect quota rejection for pid-a at threshold")
+	}
+	if granted["pid-c"] != 0 {
+		t.Errorf("partial grant to pid-c must be rolled back, got %d", granted["pid-c"])
+	}
+	if granted["pid-a"] != 1 {
+		t.Errorf("rejected grant must not change pid-a, got %d", granted["pid-a"])
+	}
+}
+
+func TestGrantSharesToTargetsInsertFailureRollsBack(t *testing.T) {
+	RegisterSharedGrantQuotaChecker(nil)
+
+	inserted := []string{}
+	removed := []string{}
+	insert := func(targetProjectId string) error {
+		if targetProjectId == "pid-bad" {
+			return fmt.Errorf("insert failed")
+		}
+		inserted = append(inserted, targetProjectId)
+		return nil
+	}
+	remove := func(targetProjectId string) error {
+		removed = append(removed, targetProjectId)
+		return nil
+	}
+
+	err := grantSharesToTargets(context.Background(), []string{"pid-a", "pid-b", "pid-bad"}, "domain-1", "secgroup", insert, remove)
+	if err == nil {
+		t.Fatalf("expect insert failure to propagate")
+	}
+	if len(removed) != 2 || removed[0] != "pid-a" || removed[1] != "pid-b" {
+		t.Errorf("expect rows before the failure rolled back, removed %v", removed)
+	}
+}
+
+func TestCheckSharedGrantQuotaDefaultNoop(t *testing.T) {
+	RegisterSharedGrantQuotaChecker(nil)
+	if err := checkSharedGrantQuota(context.Background(), "pid-a", "domain-1", "servertemplate", 1); err != nil {
+		t.Errorf("nil checker must accept everything, got %v", err)
+	}
+}
@@ -16,7 +16,10 @@ package db
 
 import (
 	"context"
+	"time"
 
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/utils"
 	"yunion.io/x/sqlchemy"
 
 	"yunion.io/x/onecloud/pkg/httperrors"
@@ -33,6 +36,9 @@ type SSharedResource struct {
 	ResourceId      string `width:"128" charset:"ascii" nullable:"false" index:"true" list:"user" json:"resource_id"`
 	OwnerProjectId  string `width:"128" charset:"ascii" nullable:"false" index:"true" list:"user" json:"owner_project_id"`
 	TargetProjectId string `width:"128" charset:"ascii" nullable:"false" index:"true" list:"user" json:"target_project_id"`
+
+	// 发起共享的用户Id, 旧数据为空
+	CreatedBy string `width:"128" charset:"ascii" nullable:"true" list:"user" json:"created_by"`
 }
 
 type SSharedResourceManager struct {
@@ -52,6 +58,31 @@ func init() {
 	}
 }
 
+// InitializeData backfills created_at of legacy share rows from their
+// updated_at so the share audit timestamp is populated where possible
+func (manager *SSharedResourceManager) InitializeData() error {
+	srs := make([]SSharedResource, 0)
+	q := manager.Query().IsNull("created_at")
+	if err := q.All(&srs); err != nil {
+		return err
+	}
+	for i := range srs {
+		sr := &srs[i]
+		sr.SetModelManager(manager, sr)
+		if _, err := Update(sr, func() error {
+			if !sr.UpdatedAt.IsZero() {
+				sr.CreatedAt = sr.UpdatedAt
+			} else {
+				sr.CreatedAt = time.Now().UTC()
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (manager *SSharedResourceManager) CleanModelSharedProjects(ctx context.Context, userCred mcclient.TokenCredential, model *SVirtualResourceBase) error {
 	srs := make([]SSharedResource, 0)
 	q := manager.Query()
@@ -71,3 +102,57 @@ func (manager *SSharedResourceManager) CleanModelSharedProjects(ctx context.Cont
 	}
 	return nil
 }
+
+// filterOrphanedProjectIds returns the subset of projectIds that the
+// given existence check rejects
+func filterOrphanedProjectIds(projectIds []string, projectExists func(id string) bool) []string {
+	orphans := make([]string, 0)
+	for _, projId := range projectIds {
+		if !projectExists(projId) {
+			orphans = append(orphans, projId)
+		}
+	}
+	return orphans
+}
+
+// CleanOrphanedShares removes shared_resources rows whose target
+// project has been deleted from keystone. It runs as a periodic cron
+// job, the signature matches cronman.TCronJobFunction.
+func (manager *SSharedResourceManager) CleanOrphanedShares(ctx context.Context, userCred mcclient.TokenCredential, isStart bool) {
+	err := manager.cleanOrphanedShares(ctx, userCred, func(projId string) bool {
+		_, err := TenantCacheManager.FetchTenantById(ctx, projId)
+		return err == nil
+	})
+	if err != nil {
+		log.Errorf("CleanOrphanedShares fail: %s", err)
+	}
+}
+
+func (manager *SSharedResourceManager) cleanOrphanedShares(ctx context.Context, userCred mcclient.TokenCredential, projectExists func(id string) bool) error {
+	srs := make([]SSharedResource, 0)
+	q := manager.Query()
+	if err := q.All(&srs); err != nil {
+		return err
+	}
+	targetIds := make([]string, 0)
+	for i := 0; i < len(srs); i++ {
+		if !utils.IsInStringArray(srs[i].TargetProjectId, targetIds) {
+			targetIds = append(targetIds, srs[i].TargetProjectId)
+		}
+	}
+	orphanIds := filterOrphanedProjectIds(targetIds, projectExists)
+	if len(orphanIds) == 0 {
+		return nil
+	}
+	for i := 0; i < len(srs); i++ {
+		if !utils.IsInStringArray(srs[i].TargetProjectId, orphanIds) {
+			continue
+		}
+		srs[i].SetModelManager(manager, &srs[i])
+		if err := srs[i].Delete(ctx, userCred); err != nil {
+			return err
+		}
+		log.Infof("removed share of %s %s to deleted project %s", srs[i].ResourceType, srs[i].ResourceId, srs[i].TargetProjectId)
+	}
+	return nil
+}
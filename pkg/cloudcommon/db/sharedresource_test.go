@@ -0,0 +1,50 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"testing"
+
+	"yunion.io/x/pkg/utils"
+)
+
+func TestFilterOrphanedProjectIds(t *testing.T) {
+	existing := []string{"proj1", "proj2"}
+	fakeFetcher := func(id string) bool {
+		return utils.IsInStringArray(id, existing)
+	}
+	cases := []struct {
+		name  string
+		input []string
+		want  []string
+	}{
+		{"all exist", []string{"proj1", "proj2"}, []string{}},
+		{"partial", []string{"proj1", "gone1", "proj2", "gone2"}, []string{"gone1", "gone2"}},
+		{"empty", []string{}, []string{}},
+	}
+	for _, c := range cases {
+		got := filterOrphanedProjectIds(c.input, fakeFetcher)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
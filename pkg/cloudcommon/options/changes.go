@@ -18,6 +18,27 @@ import (
 	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
 )
 
+var corsHostsReloadFunc func(hosts []string)
+
+// SetCorsHostsReloadFunc registers the callback that re-applies a
+// changed cors_hosts option on the running application, so the setting
+// takes effect without a service restart
+func SetCorsHostsReloadFunc(f func(hosts []string)) {
+	corsHostsReloadFunc = f
+}
+
+func stringSliceEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func OnBaseOptionsChange(oOpts, nOpts interface{}) bool {
 	oldOpts := oOpts.(*BaseOptions)
 	newOpts := nOpts.(*BaseOptions)
@@ -25,6 +46,9 @@ func OnBaseOptionsChange(oOpts, nOpts interface{}) bool {
 	if oldOpts.RequestWorkerCount != newOpts.RequestWorkerCount {
 		return true
 	}
+	if !stringSliceEqual(oldOpts.CorsHosts, newOpts.CorsHosts) && corsHostsReloadFunc != nil {
+		corsHostsReloadFunc(newOpts.CorsHosts)
+	}
 	if oldOpts.TimeZone != newOpts.TimeZone {
 		return true
 	}
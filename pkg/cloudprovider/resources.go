@@ -360,6 +360,12 @@ type ICloudRoute interface {
 	GetCidr() string
 	GetNextHopType() string
 	GetNextHop() string
+	// GetStatus returns the route status as reported by the
+	// provider, empty for providers without route status support
+	GetStatus() string
+	// GetPriority returns the route priority, 0 for providers
+	// without route priority support
+	GetPriority() int
 }
 
 type ICloudDisk interface {
@@ -19,6 +19,7 @@ import (
 	"database/sql"
 	"fmt"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -163,6 +164,13 @@ func (manager *SDiskManager) ListItemFilter(
 		return nil, errors.Wrap(err, "SVirtualResourceBaseManager.ListItemFilter")
 	}
 
+	if search := normalizeSearchText(query.Search); len(search) > 0 {
+		q = q.Filter(sqlchemy.OR(
+			sqlchemy.Contains(q.Field("name"), search),
+			sqlchemy.Startswith(q.Field("id"), search),
+		))
+	}
+
 	if query.Unused != nil {
 		guestdisks := GuestdiskManager.Query().SubQuery()
 		sq := guestdisks.Query(guestdisks.Field("disk_id"))
@@ -173,6 +181,13 @@ func (manager *SDiskManager) ListItemFilter(
 		}
 	}
 
+	if len(query.Metadata) > 0 || len(query.WithoutMetadataKeys) > 0 {
+		q, err = db.ApplyMetadataKeyValueFilters(q, manager.Keyword(), query.Metadata, query.WithoutMetadataKeys, userCred.HasSystemAdminPrivilege())
+		if err != nil {
+			return nil, errors.Wrap(err, "db.ApplyMetadataKeyValueFilters")
+		}
+	}
+
 	guestId := query.Server
 	if len(guestId) > 0 {
 		iGuest, err := GuestManager.FetchByIdOrName(userCred, guestId)
@@ -205,6 +220,20 @@ func (manager *SDiskManager) ListItemFilter(
 		q = q.In("id", sq)
 	}
 
+	if len(query.Host) > 0 {
+		iHost, err := HostManager.FetchByIdOrName(userCred, query.Host)
+		if err == sql.ErrNoRows {
+			return nil, httperrors.NewResourceNotFoundError("host %q not found", query.Host)
+		} else if err != nil {
+			return nil, err
+		}
+		hostStorages := HoststorageManager.Query("storage_id").Equals("host_id", iHost.GetId())
+		storages := StorageManager.Query("id").
+			In("storage_type", api.HOST_STORAGE_LOCAL_TYPES).
+			In("id", hostStorages.SubQuery())
+		q = q.In("storage_id", storages.SubQuery())
+	}
+
 	if len(query.DiskFormat) > 0 {
 		q = q.Equals("disk_format", query.DiskFormat)
 	}
@@ -213,6 +242,18 @@ func (manager *SDiskManager) ListItemFilter(
 		q = q.Equals("disk_size", query.DiskSize)
 	}
 
+	if query.OverProvisionRatioGt != nil {
+		provisioned, err := fetchStorageProvisionedSizes(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetchStorageProvisionedSizes")
+		}
+		capacities, err := fetchStorageCapacities(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetchStorageCapacities")
+		}
+		q = q.In("storage_id", overProvisionedStorageIds(provisioned, capacities, *query.OverProvisionRatioGt))
+	}
+
 	if query.AutoDelete != nil {
 		if *query.AutoDelete {
 			q = q.IsTrue("auto_delete")
@@ -787,22 +828,49 @@ func (self *SDisk) PerformDiskReset(ctx context.Context, userCred mcclient.Token
 		return nil, httperrors.NewNotFoundError("failed to find host for storage %s with disk %s", storage.Name, self.Name)
 	}
 
-	snapshotV := validators.NewModelIdOrNameValidator("snapshot", "snapshot", userCred)
-	err := snapshotV.Validate(data.(*jsonutils.JSONDict))
-	if err != nil {
-		return nil, err
-	}
-	snapshot := snapshotV.Model.(*SSnapshot)
-	if snapshot.Status != api.SNAPSHOT_READY {
-		return nil, httperrors.NewBadRequestError("Cannot reset disk with snapshot in status %s", snapshot.Status)
-	}
+	var snapshot *SSnapshot
+	rebuildSnapshotId, _ := data.GetString("rebuild_snapshot_id")
+	if len(rebuildSnapshotId) > 0 {
+		// rebuild from a snapshot of another disk, subject to the
+		// cross-storage restore matrix of the storage drivers
+		iSnapshot, err := SnapshotManager.FetchByIdOrName(userCred, rebuildSnapshotId)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, httperrors.NewNotFoundError("Snapshot %s not found", rebuildSnapshotId)
+			}
+			return nil, err
+		}
+		snapshot = iSnapshot.(*SSnapshot)
+		if snapshot.Status != api.SNAPSHOT_READY {
+			return nil, httperrors.NewBadRequestError("Cannot reset disk with snapshot in status %s", snapshot.Status)
+		}
+		snapStorage := StorageManager.FetchStorageById(snapshot.StorageId)
+		if snapStorage == nil {
+			return nil, httperrors.NewNotFoundError("failed to find storage of snapshot %s", snapshot.Name)
+		}
+		allowed := allowedSnapshotRestoreBackends(snapStorage.StorageType)
+		if err := validateSnapshotRestoreTarget(snapStorage.StorageType, allowed, storage.StorageType, snapshot.Size, self.DiskSize); err != nil {
+			return nil, err
+		}
+		data.(*jsonutils.JSONDict).Set("snapshot_id", jsonutils.NewString(snapshot.Id))
+	} else {
+		snapshotV := validators.NewModelIdOrNameValidator("snapshot", "snapshot", userCred)
+		err := snapshotV.Validate(data.(*jsonutils.JSONDict))
+		if err != nil {
+			return nil, err
+		}
+		snapshot = snapshotV.Model.(*SSnapshot)
+		if snapshot.Status != api.SNAPSHOT_READY {
+			return nil, httperrors.NewBadRequestError("Cannot reset disk with snapshot in status %s", snapshot.Status)
+		}
 
-	if snapshot.DiskId != self.Id {
-		return nil, httperrors.NewBadRequestError("Cannot reset disk %s(%s),Snapshot is belong to disk %s", self.Name, self.Id, snapshot.DiskId)
+		if snapshot.DiskId != self.Id {
+			return nil, httperrors.NewBadRequestError("Cannot reset disk %s(%s),Snapshot is belong to disk %s", self.Name, self.Id, snapshot.DiskId)
+		}
 	}
 
 	guests := self.GetGuests()
-	data, err = host.GetHostDriver().ValidateResetDisk(ctx, userCred, self, snapshot, guests, data.(*jsonutils.JSONDict))
+	data, err := host.GetHostDriver().ValidateResetDisk(ctx, userCred, self, snapshot, guests, data.(*jsonutils.JSONDict))
 	if err != nil {
 		return nil, err
 	}
@@ -1610,6 +1678,10 @@ func fillDiskConfigBySnapshot(userCred mcclient.TokenCredential, diskConfig *api
 		return httperrors.NewBadRequestError("Snapshot %s storage %s not found, is public cloud?",
 			snapshotId, snapshot.StorageId)
 	} else {
+		allowed := allowedSnapshotRestoreBackends(storage.StorageType)
+		if err := validateSnapshotRestoreTarget(storage.StorageType, allowed, diskConfig.Backend, snapshot.Size, diskConfig.SizeMb); err != nil {
+			return err
+		}
 		if disk := DiskManager.FetchDiskById(snapshot.DiskId); disk != nil {
 			diskConfig.Fs = disk.FsFormat
 			if len(diskConfig.Format) == 0 {
@@ -1618,8 +1690,12 @@ func fillDiskConfigBySnapshot(userCred mcclient.TokenCredential, diskConfig *api
 		}
 		diskConfig.SnapshotId = snapshot.Id
 		diskConfig.DiskType = snapshot.DiskType
-		diskConfig.SizeMb = snapshot.Size
-		diskConfig.Backend = storage.StorageType
+		if diskConfig.SizeMb < snapshot.Size {
+			diskConfig.SizeMb = snapshot.Size
+		}
+		if len(diskConfig.Backend) == 0 {
+			diskConfig.Backend = storage.StorageType
+		}
 		diskConfig.Fs = ""
 		diskConfig.Mountpoint = ""
 	}
@@ -1878,6 +1954,126 @@ func (self *SDisk) GetExtraDetails(ctx context.Context, userCred mcclient.TokenC
 	return api.DiskDetails{}, nil
 }
 
+type sStorageProvision struct {
+	StorageId       string
+	ProvisionedSize int64
+}
+
+// fetchStorageProvisionedSizes sums the provisioned disk sizes per
+// storage with a single grouped query. Empty storageIds covers all
+// storages
+func fetchStorageProvisionedSizes(storageIds []string) (map[string]int64, error) {
+	disks := DiskManager.Query().SubQuery()
+	q := disks.Query(disks.Field("storage_id"), sqlchemy.SUM("provisioned_size", disks.Field("disk_size")))
+	if len(storageIds) > 0 {
+		q = q.Filter(sqlchemy.In(disks.Field("storage_id"), storageIds))
+	}
+	q = q.GroupBy(disks.Field("storage_id"))
+	provisions := make([]sStorageProvision, 0)
+	if err := q.All(&provisions); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "query provisioned sizes")
+	}
+	ret := make(map[string]int64, len(provisions))
+	for i := range provisions {
+		ret[provisions[i].StorageId] = provisions[i].ProvisionedSize
+	}
+	return ret, nil
+}
+
+// fetchStorageCapacities returns the physical capacity in MB per
+// storage. Empty storageIds covers all storages
+func fetchStorageCapacities(storageIds []string) (map[string]int64, error) {
+	q := StorageManager.Query()
+	if len(storageIds) > 0 {
+		q = q.In("id", storageIds)
+	}
+	storages := make([]SStorage, 0)
+	if err := db.FetchModelObjects(StorageManager, q, &storages); err != nil {
+		return nil, errors.Wrap(err, "fetch storages")
+	}
+	ret := make(map[string]int64, len(storages))
+	for i := range storages {
+		ret[storages[i].Id] = storages[i].Capacity
+	}
+	return ret, nil
+}
+
+// filterLocalStorageIds picks the storages whose type keeps disk data
+// on specific hosts; only those have a meaningful backing host list
+func filterLocalStorageIds(storageIds []string, storageTypes map[string]string) []string {
+	locals := make([]string, 0)
+	for _, storageId := range storageIds {
+		if utils.IsInStringArray(storageTypes[storageId], api.HOST_STORAGE_LOCAL_TYPES) {
+			locals = append(locals, storageId)
+		}
+	}
+	return locals
+}
+
+type sStorageHostRow struct {
+	StorageId string
+	Id        string
+	Name      string
+	Status    string
+}
+
+// groupStorageHosts buckets joined hoststorage rows by storage
+func groupStorageHosts(hostRows []sStorageHostRow) map[string][]api.StorageHostInfo {
+	ret := make(map[string][]api.StorageHostInfo)
+	for i := range hostRows {
+		ret[hostRows[i].StorageId] = append(ret[hostRows[i].StorageId], api.StorageHostInfo{
+			Id:     hostRows[i].Id,
+			Name:   hostRows[i].Name,
+			Status: hostRows[i].Status,
+		})
+	}
+	return ret
+}
+
+// fetchStorageHosts returns the hosts every given storage is attached
+// to with one batched query over hoststorages
+func fetchStorageHosts(storageIds []string) (map[string][]api.StorageHostInfo, error) {
+	if len(storageIds) == 0 {
+		return map[string][]api.StorageHostInfo{}, nil
+	}
+	hoststorages := HoststorageManager.Query().SubQuery()
+	hosts := HostManager.Query().SubQuery()
+	q := hoststorages.Query(
+		hoststorages.Field("storage_id"),
+		hosts.Field("id"),
+		hosts.Field("name"),
+		hosts.Field("status"),
+	).Join(hosts, sqlchemy.Equals(hosts.Field("id"), hoststorages.Field("host_id"))).
+		Filter(sqlchemy.In(hoststorages.Field("storage_id"), storageIds))
+	hostRows := make([]sStorageHostRow, 0)
+	if err := q.All(&hostRows); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "query storage hosts")
+	}
+	return groupStorageHosts(hostRows), nil
+}
+
+// computeOverProvisionRatio is the provisioned virtual size over the
+// physical capacity, 0 when the capacity is unknown
+func computeOverProvisionRatio(provisionedMb int64, capacityMb int64) float64 {
+	if capacityMb <= 0 {
+		return 0
+	}
+	return float64(provisionedMb) / float64(capacityMb)
+}
+
+// overProvisionedStorageIds selects the storages whose provisioned
+// ratio exceeds the given threshold
+func overProvisionedStorageIds(provisioned map[string]int64, capacities map[string]int64, ratio float64) []string {
+	ids := make([]string, 0)
+	for storageId, size := range provisioned {
+		if computeOverProvisionRatio(size, capacities[storageId]) > ratio {
+			ids = append(ids, storageId)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 func (manager *SDiskManager) FetchCustomizeColumns(
 	ctx context.Context,
 	userCred mcclient.TokenCredential,
@@ -1889,12 +2085,44 @@ func (manager *SDiskManager) FetchCustomizeColumns(
 	rows := make([]api.DiskDetails, len(objs))
 	virtRows := manager.SVirtualResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
 	storeRows := manager.SStorageResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	storageIds := make([]string, 0, len(objs))
+	for i := range objs {
+		storageId := objs[i].(*SDisk).StorageId
+		if len(storageId) > 0 && !utils.IsInStringArray(storageId, storageIds) {
+			storageIds = append(storageIds, storageId)
+		}
+	}
+	provisioned, err := fetchStorageProvisionedSizes(storageIds)
+	if err != nil {
+		log.Errorf("fetchStorageProvisionedSizes: %v", err)
+	}
+	capacities, err := fetchStorageCapacities(storageIds)
+	if err != nil {
+		log.Errorf("fetchStorageCapacities: %v", err)
+	}
+	storageTypes := make(map[string]string, len(objs))
+	for i := range objs {
+		storageTypes[objs[i].(*SDisk).StorageId] = storeRows[i].StorageType
+	}
+	storageHosts := map[string][]api.StorageHostInfo{}
+	if localIds := filterLocalStorageIds(storageIds, storageTypes); len(localIds) > 0 {
+		storageHosts, err = fetchStorageHosts(localIds)
+		if err != nil {
+			log.Errorf("fetchStorageHosts: %v", err)
+		}
+	}
 	for i := range rows {
 		rows[i] = api.DiskDetails{
 			VirtualResourceDetails: virtRows[i],
 			StorageResourceInfo:    storeRows[i],
 		}
-		rows[i] = objs[i].(*SDisk).getMoreDetails(ctx, userCred, rows[i])
+		disk := objs[i].(*SDisk)
+		rows[i].OverProvisionRatio = computeOverProvisionRatio(provisioned[disk.StorageId], capacities[disk.StorageId])
+		rows[i].Hosts = []api.StorageHostInfo{}
+		if hosts, ok := storageHosts[disk.StorageId]; ok {
+			rows[i].Hosts = hosts
+		}
+		rows[i] = disk.getMoreDetails(ctx, userCred, rows[i])
 	}
 	return rows
 }
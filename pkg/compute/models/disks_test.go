@@ -0,0 +1,121 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComputeOverProvisionRatio(t *testing.T) {
+	cases := []struct {
+		provisioned int64
+		capacity    int64
+		want        float64
+	}{
+		{51200, 102400, 0.5},
+		{307200, 102400, 3},
+		{1024, 0, 0},
+	}
+	for _, c := range cases {
+		if got := computeOverProvisionRatio(c.provisioned, c.capacity); got != c.want {
+			t.Errorf("computeOverProvisionRatio(%d, %d) = %v, want %v", c.provisioned, c.capacity, got, c.want)
+		}
+	}
+}
+
+func TestOverProvisionedStorageIds(t *testing.T) {
+	provisioned := map[string]int64{
+		"half":   51200,  // 0.5x
+		"triple": 307200, // 3x
+	}
+	capacities := map[string]int64{
+		"half":   102400,
+		"triple": 102400,
+	}
+	ids := overProvisionedStorageIds(provisioned, capacities, 1.0)
+	if len(ids) != 1 || ids[0] != "triple" {
+		t.Errorf("expect only the 3x storage above 1.0, got %v", ids)
+	}
+	ids = overProvisionedStorageIds(provisioned, capacities, 0.4)
+	if len(ids) != 2 || ids[0] != "half" || ids[1] != "triple" {
+		t.Errorf("expect both storages above 0.4, got %v", ids)
+	}
+	if ids := overProvisionedStorageIds(provisioned, capacities, 5); len(ids) != 0 {
+		t.Errorf("expect no storage above 5, got %v", ids)
+	}
+}
+
+func TestFilterLocalStorageIds(t *testing.T) {
+	storageTypes := map[string]string{
+		"s-local": "local",
+		"s-rbd":   "rbd",
+		"s-nfs":   "nfs",
+		"s-bm":    "baremetal",
+	}
+	locals := filterLocalStorageIds([]string{"s-local", "s-rbd", "s-nfs", "s-bm"}, storageTypes)
+	if len(locals) != 2 || locals[0] != "s-local" || locals[1] != "s-bm" {
+		t.Errorf("expect only host-bound storages, got %v", locals)
+	}
+	if locals := filterLocalStorageIds([]string{"s-rbd"}, storageTypes); len(locals) != 0 {
+		t.Errorf("shared storage must yield no backing hosts, got %v", locals)
+	}
+}
+
+func TestGroupStorageHosts(t *testing.T) {
+	grouped := groupStorageHosts([]sStorageHostRow{
+		{StorageId: "s1", Id: "h1", Name: "host1", Status: "running"},
+		{StorageId: "s1", Id: "h2", Name: "host2", Status: "offline"},
+		{StorageId: "s2", Id: "h1", Name: "host1", Status: "running"},
+	})
+	if len(grouped["s1"]) != 2 || len(grouped["s2"]) != 1 {
+		t.Fatalf("grouping wrong: %+v", grouped)
+	}
+	if grouped["s1"][1].Name != "host2" || grouped["s1"][1].Status != "offline" {
+		t.Errorf("host fields wrong: %+v", grouped["s1"][1])
+	}
+	if _, ok := grouped["s3"]; ok {
+		t.Errorf("unexpected bucket for unknown storage")
+	}
+}
+
+func TestValidateSnapshotRestoreTarget(t *testing.T) {
+	allowed := []string{"local", "nfs", "gpfs"}
+
+	// same type always passes
+	if err := validateSnapshotRestoreTarget("local", allowed, "local", 10240, 10240); err != nil {
+		t.Errorf("same-type restore rejected: %v", err)
+	}
+	// declared compatible cross type passes
+	if err := validateSnapshotRestoreTarget("local", allowed, "nfs", 10240, 0); err != nil {
+		t.Errorf("compatible cross-type restore rejected: %v", err)
+	}
+	// unlisted backend is rejected with the allowed list in the error
+	err := validateSnapshotRestoreTarget("local", allowed, "rbd", 10240, 0)
+	if err == nil {
+		t.Fatalf("incompatible cross-type restore accepted")
+	}
+	if !strings.Contains(err.Error(), "local,nfs,gpfs") {
+		t.Errorf("error %q does not list allowed storage types", err.Error())
+	}
+	// empty backend means no preference and passes
+	if err := validateSnapshotRestoreTarget("rbd", []string{"rbd"}, "", 10240, 0); err != nil {
+		t.Errorf("restore without backend preference rejected: %v", err)
+	}
+	// a disk smaller than the snapshot is rejected
+	if err := validateSnapshotRestoreTarget("local", allowed, "local", 10240, 8192); err == nil {
+		t.Errorf("undersized disk accepted")
+	}
+}
@@ -32,7 +32,7 @@ import (
 )
 
 type SGlobalVpcResourceBase struct {
-	GlobalvpcId string `width:"36" charset:"ascii" nullable:"true" list:"user" create:"optional" json:"globalvpc_id"`
+	GlobalvpcId string `width:"36" charset:"ascii" nullable:"true" list:"user" create:"optional" update:"admin" json:"globalvpc_id"`
 }
 
 type SGlobalVpcResourceBaseManager struct{}
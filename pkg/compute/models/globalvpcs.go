@@ -16,16 +16,22 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
 
 	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
 	"yunion.io/x/sqlchemy"
 
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/rbacutils"
 	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
 
@@ -49,6 +55,9 @@ func init() {
 
 type SGlobalVpc struct {
 	db.SEnabledStatusStandaloneResourceBase
+
+	// 云平台, 由第一个挂载的子VPC决定, 最后一个子VPC移除后清空
+	Provider string `width:"64" charset:"ascii" nullable:"true" list:"user" json:"provider"`
 }
 
 func (manager *SGlobalVpcManager) AllowCreateItem(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
@@ -76,8 +85,334 @@ func (self *SGlobalVpc) GetVpcs() ([]SVpc, error) {
 	return vpcs, nil
 }
 
+// validateGlobalVpcProvider rejects attaching a child vpc whose cloud
+// provider differs from the one the global vpc is already bound to
+func validateGlobalVpcProvider(current string, incoming string) error {
+	if len(current) > 0 && len(incoming) > 0 && current != incoming {
+		return httperrors.NewConflictError("global vpc is bound to provider %s, cannot attach a %s vpc", current, incoming)
+	}
+	return nil
+}
+
+func (self *SGlobalVpc) ValidateAttachVpc(provider string) error {
+	return validateGlobalVpcProvider(self.Provider, provider)
+}
+
+// setProviderOnAttach denormalizes the provider of the first attached
+// child vpc onto the global vpc
+func (self *SGlobalVpc) setProviderOnAttach(ctx context.Context, provider string) error {
+	if self.Provider == provider || len(provider) == 0 {
+		return nil
+	}
+	if err := self.ValidateAttachVpc(provider); err != nil {
+		return err
+	}
+	_, err := db.Update(self, func() error {
+		self.Provider = provider
+		return nil
+	})
+	return err
+}
+
+// clearProviderIfEmpty resets the denormalized provider once the last
+// child vpc has been detached
+func (self *SGlobalVpc) clearProviderIfEmpty(ctx context.Context) error {
+	if len(self.Provider) == 0 {
+		return nil
+	}
+	cnt, err := VpcManager.Query().Equals("globalvpc_id", self.Id).CountWithError()
+	if err != nil {
+		return errors.Wrap(err, "count child vpcs")
+	}
+	if cnt > 0 {
+		return nil
+	}
+	_, err = db.Update(self, func() error {
+		self.Provider = ""
+		return nil
+	})
+	return err
+}
+
+func (self *SGlobalVpc) AllowPerformDetachVpc(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, self, "detach-vpc")
+}
+
+// PerformDetachVpc 将子VPC从全局VPC中移除
+func (self *SGlobalVpc) PerformDetachVpc(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := api.GlobalVpcDetachVpcInput{}
+	if err := data.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if len(input.VpcId) == 0 {
+		return nil, httperrors.NewMissingParameterError("vpc_id")
+	}
+	vpcObj, err := VpcManager.FetchByIdOrName(userCred, input.VpcId)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, httperrors.NewResourceNotFoundError2(VpcManager.Keyword(), input.VpcId)
+		}
+		return nil, httperrors.NewGeneralError(err)
+	}
+	vpc := vpcObj.(*SVpc)
+	if vpc.GlobalvpcId != self.Id {
+		return nil, httperrors.NewInvalidStatusError("vpc %s does not belong to globalvpc %s", vpc.Name, self.Name)
+	}
+	_, err = db.Update(vpc, func() error {
+		vpc.GlobalvpcId = ""
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "clear globalvpc_id")
+	}
+	db.OpsLog.LogEvent(self, db.ACT_DETACH, vpc.GetShortDesc(ctx), userCred)
+	if err := self.clearProviderIfEmpty(ctx); err != nil {
+		log.Errorf("clear provider of globalvpc %s: %v", self.Name, err)
+	}
+	return nil, nil
+}
+
+func (self *SGlobalVpc) AllowPerformPurge(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, self, "purge")
+}
+
+// PerformPurge deletes the global vpc together with all of its child
+// VPCs in one go, provided every child is already empty. Deletion rolls
+// forward on per-child errors and reports partial results; non-empty
+// children block the whole operation with the list of blockers.
+func (self *SGlobalVpc) PerformPurge(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	vpcs, err := self.GetVpcs()
+	if err != nil {
+		return nil, errors.Wrap(err, "self.GetVpcs")
+	}
+	blockers := make([]string, 0)
+	for i := range vpcs {
+		if !db.IsAdminAllowPerform(userCred, &vpcs[i], "purge") {
+			blockers = append(blockers, fmt.Sprintf("vpc %s(%s): permission denied", vpcs[i].Name, vpcs[i].Id))
+			continue
+		}
+		if err := vpcs[i].ValidateDeleteCondition(ctx); err != nil {
+			blockers = append(blockers, fmt.Sprintf("vpc %s(%s): %v", vpcs[i].Name, vpcs[i].Id, err))
+		}
+	}
+	if len(blockers) > 0 {
+		return nil, httperrors.NewNotEmptyError("cannot purge globalvpc %s: %s", self.Name, strings.Join(blockers, "; "))
+	}
+	deleted := jsonutils.NewArray()
+	failed := jsonutils.NewArray()
+	for i := range vpcs {
+		if err := vpcs[i].RealDelete(ctx, userCred); err != nil {
+			log.Errorf("purge globalvpc %s: delete vpc %s: %v", self.Name, vpcs[i].Id, err)
+			failedEntry := jsonutils.NewDict()
+			failedEntry.Add(jsonutils.NewString(vpcs[i].Id), "id")
+			failedEntry.Add(jsonutils.NewString(err.Error()), "reason")
+			failed.Add(failedEntry)
+			continue
+		}
+		deleted.Add(jsonutils.NewString(vpcs[i].Id))
+	}
+	result := jsonutils.NewDict()
+	result.Add(deleted, "deleted_vpcs")
+	result.Add(failed, "failed_vpcs")
+	if failed.Length() > 0 {
+		// keep the global vpc so a retry can pick up the leftovers
+		return result, nil
+	}
+	if err := self.SEnabledStatusStandaloneResourceBase.Delete(ctx, userCred); err != nil {
+		return result, errors.Wrap(err, "delete globalvpc")
+	}
+	db.OpsLog.LogEvent(self, db.ACT_DELETE, self.GetShortDesc(ctx), userCred)
+	return result, nil
+}
+
+// sumNetworkIpCounts adds up the address counts of the ip ranges of
+// the given networks
+func sumNetworkIpCounts(networks []SNetwork) int64 {
+	total := int64(0)
+	for i := range networks {
+		total += int64(networks[i].getIPRange().AddressCount())
+	}
+	return total
+}
+
+func (self *SGlobalVpc) getUsage(ownerId mcclient.IIdentityProvider, scope rbacutils.TRbacScope) (api.GlobalVpcUsage, error) {
+	usage := api.GlobalVpcUsage{}
+
+	vpcQ := VpcManager.Query().Equals("globalvpc_id", self.Id)
+	vpcQ = VpcManager.FilterByOwner(vpcQ, ownerId, scope)
+	var err error
+	usage.VpcCount, err = vpcQ.CountWithError()
+	if err != nil {
+		return usage, errors.Wrap(err, "count vpcs")
+	}
+
+	vpcIdQ := VpcManager.Query("id").Equals("globalvpc_id", self.Id)
+	vpcIdQ = VpcManager.FilterByOwner(vpcIdQ, ownerId, scope)
+	wireQ := WireManager.Query().In("vpc_id", vpcIdQ.SubQuery())
+	usage.WireCount, err = wireQ.CountWithError()
+	if err != nil {
+		return usage, errors.Wrap(err, "count wires")
+	}
+
+	wireIdQ := WireManager.Query("id").In("vpc_id", vpcIdQ.SubQuery())
+	networks := []SNetwork{}
+	netQ := NetworkManager.Query().In("wire_id", wireIdQ.SubQuery())
+	err = db.FetchModelObjects(NetworkManager, netQ, &networks)
+	if err != nil {
+		return usage, errors.Wrap(err, "fetch networks")
+	}
+	usage.NetworkCount = len(networks)
+	usage.TotalIpCount = sumNetworkIpCounts(networks)
+
+	netIdQ := NetworkManager.Query("id").In("wire_id", wireIdQ.SubQuery())
+	usage.GuestNicCount, err = GuestnetworkManager.Query().In("network_id", netIdQ.SubQuery()).CountWithError()
+	if err != nil {
+		return usage, errors.Wrap(err, "count guest nics")
+	}
+	reservedCnt, err := ReservedipManager.Query().In("network_id", netIdQ.SubQuery()).CountWithError()
+	if err != nil {
+		return usage, errors.Wrap(err, "count reserved ips")
+	}
+	usage.UsedIpCount = int64(usage.GuestNicCount + reservedCnt)
+
+	return usage, nil
+}
+
+func (self *SGlobalVpc) AllowGetDetailsUsage(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return db.IsDomainAllowGetSpec(userCred, self, "usage")
+}
+
+// GetDetailsUsage 获取全局VPC的资源使用情况
+func (self *SGlobalVpc) GetDetailsUsage(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	ownerId, scope, err := db.FetchCheckQueryOwnerScope(ctx, userCred, query, VpcManager, policy.PolicyActionList, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "FetchCheckQueryOwnerScope")
+	}
+	usage, err := self.getUsage(ownerId, scope)
+	if err != nil {
+		return nil, errors.Wrap(err, "getUsage")
+	}
+	return jsonutils.Marshal(usage), nil
+}
+
 func (self *SGlobalVpc) GetExtraDetails(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, isList bool) (api.GlobalVpcDetails, error) {
-	return api.GlobalVpcDetails{}, nil
+	details := api.GlobalVpcDetails{}
+	if !isList {
+		ownerId, scope, err := db.FetchCheckQueryOwnerScope(ctx, userCred, query, VpcManager, policy.PolicyActionGet, true)
+		if err != nil {
+			return details, errors.Wrap(err, "FetchCheckQueryOwnerScope")
+		}
+		usage, err := self.getUsage(ownerId, scope)
+		if err != nil {
+			return details, errors.Wrap(err, "getUsage")
+		}
+		details.Usage = &usage
+	}
+	return details, nil
+}
+
+// sGlobalVpcVpcRegionRow is one child vpc with its region, the raw
+// material of the per-region distribution stats
+type sGlobalVpcVpcRegionRow struct {
+	VpcId         string
+	GlobalvpcId   string
+	CloudregionId string
+	Cloudregion   string
+}
+
+// buildGlobalVpcRegionStats aggregates child vpcs into per-region
+// distribution entries keyed by global vpc id, sorted by region id for
+// a stable response. networkCounts maps child vpc ids to their IP
+// subnet counts.
+func buildGlobalVpcRegionStats(vpcRows []sGlobalVpcVpcRegionRow, networkCounts map[string]int) map[string][]api.GlobalVpcRegionStat {
+	type regionKey struct {
+		globalvpcId string
+		regionId    string
+	}
+	byRegion := make(map[regionKey]*api.GlobalVpcRegionStat)
+	for _, row := range vpcRows {
+		key := regionKey{globalvpcId: row.GlobalvpcId, regionId: row.CloudregionId}
+		stat, ok := byRegion[key]
+		if !ok {
+			stat = &api.GlobalVpcRegionStat{
+				CloudregionId: row.CloudregionId,
+				Cloudregion:   row.Cloudregion,
+			}
+			byRegion[key] = stat
+		}
+		stat.VpcCount += 1
+		stat.NetworkCount += networkCounts[row.VpcId]
+	}
+	stats := make(map[string][]api.GlobalVpcRegionStat)
+	for key, stat := range byRegion {
+		stats[key.globalvpcId] = append(stats[key.globalvpcId], *stat)
+	}
+	for _, regionStats := range stats {
+		sort.Slice(regionStats, func(i, j int) bool {
+			return regionStats[i].CloudregionId < regionStats[j].CloudregionId
+		})
+	}
+	return stats
+}
+
+type sVpcNetworkCount struct {
+	VpcId        string
+	NetworkCount int
+}
+
+// fetchVpcNetworkCounts counts the networks of each given vpc with one
+// grouped query over wires and networks
+func fetchVpcNetworkCounts(vpcIds []string) (map[string]int, error) {
+	counts := make(map[string]int, len(vpcIds))
+	if len(vpcIds) == 0 {
+		return counts, nil
+	}
+	wires := WireManager.Query().SubQuery()
+	networks := NetworkManager.Query().SubQuery()
+	q := networks.Query(wires.Field("vpc_id"), sqlchemy.COUNT("network_count"))
+	q = q.Join(wires, sqlchemy.Equals(networks.Field("wire_id"), wires.Field("id")))
+	q = q.Filter(sqlchemy.In(wires.Field("vpc_id"), vpcIds))
+	q = q.GroupBy(wires.Field("vpc_id"))
+	rows := make([]sVpcNetworkCount, 0)
+	if err := q.All(&rows); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.VpcId] = row.NetworkCount
+	}
+	return counts, nil
+}
+
+// fetchGlobalVpcRegionStats computes the per-region child vpc
+// distribution of the given global vpcs with one vpc→cloudregion join
+// for the whole page
+func fetchGlobalVpcRegionStats(globalvpcIds []string) (map[string][]api.GlobalVpcRegionStat, error) {
+	if len(globalvpcIds) == 0 {
+		return map[string][]api.GlobalVpcRegionStat{}, nil
+	}
+	vpcs := VpcManager.Query().SubQuery()
+	regions := CloudregionManager.Query().SubQuery()
+	q := vpcs.Query(
+		vpcs.Field("id", "vpc_id"),
+		vpcs.Field("globalvpc_id"),
+		vpcs.Field("cloudregion_id"),
+		regions.Field("name", "cloudregion"),
+	)
+	q = q.Join(regions, sqlchemy.Equals(vpcs.Field("cloudregion_id"), regions.Field("id")))
+	q = q.Filter(sqlchemy.In(vpcs.Field("globalvpc_id"), globalvpcIds))
+	vpcRows := make([]sGlobalVpcVpcRegionRow, 0)
+	if err := q.All(&vpcRows); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	vpcIds := make([]string, len(vpcRows))
+	for i := range vpcRows {
+		vpcIds[i] = vpcRows[i].VpcId
+	}
+	networkCounts, err := fetchVpcNetworkCounts(vpcIds)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetchVpcNetworkCounts")
+	}
+	return buildGlobalVpcRegionStats(vpcRows, networkCounts), nil
 }
 
 func (manager *SGlobalVpcManager) FetchCustomizeColumns(
@@ -95,6 +430,22 @@ func (manager *SGlobalVpcManager) FetchCustomizeColumns(
 			EnabledStatusStandaloneResourceDetails: stdRows[i],
 		}
 	}
+	// the grouped stats query is only worth it when the caller asked
+	// for details
+	if jsonutils.QueryBoolean(query, "details", false) {
+		globalvpcIds := make([]string, len(objs))
+		for i := range objs {
+			globalvpcIds[i] = objs[i].(*SGlobalVpc).Id
+		}
+		stats, err := fetchGlobalVpcRegionStats(globalvpcIds)
+		if err != nil {
+			log.Errorf("fetchGlobalVpcRegionStats: %v", err)
+		} else {
+			for i := range rows {
+				rows[i].RegionStats = stats[globalvpcIds[i]]
+			}
+		}
+	}
 	return rows
 }
 
@@ -123,6 +474,22 @@ func (manager *SGlobalVpcManager) ListItemFilter(
 	if err != nil {
 		return nil, errors.Wrap(err, "SEnabledStatusStandaloneResourceBaseManager.ListItemFilter")
 	}
+	if len(query.Providers) > 0 {
+		q = q.In("provider", query.Providers)
+	}
+	if len(query.Region) > 0 {
+		regionObj, err := CloudregionManager.FetchByIdOrName(userCred, query.Region)
+		if err != nil {
+			if errors.Cause(err) == sql.ErrNoRows {
+				return nil, httperrors.NewResourceNotFoundError2(CloudregionManager.Keyword(), query.Region)
+			}
+			return nil, httperrors.NewGeneralError(err)
+		}
+		vpcQ := VpcManager.Query("globalvpc_id").
+			Equals("cloudregion_id", regionObj.GetId()).
+			IsNotEmpty("globalvpc_id")
+		q = q.In("id", vpcQ.SubQuery())
+	}
 	return q, nil
 }
 
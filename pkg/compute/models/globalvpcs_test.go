@@ -0,0 +1,97 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestSumNetworkIpCounts(t *testing.T) {
+	// networks of one global vpc spanning two regions
+	networks := []SNetwork{
+		{GuestIpStart: "192.168.1.10", GuestIpEnd: "192.168.1.19"},
+		{GuestIpStart: "10.10.0.1", GuestIpEnd: "10.10.0.254"},
+	}
+	if got := sumNetworkIpCounts(networks); got != 264 {
+		t.Errorf("sumNetworkIpCounts = %d, want 264", got)
+	}
+	if got := sumNetworkIpCounts(nil); got != 0 {
+		t.Errorf("sumNetworkIpCounts(nil) = %d, want 0", got)
+	}
+}
+
+func TestValidateGlobalVpcProvider(t *testing.T) {
+	cases := []struct {
+		current  string
+		incoming string
+		wantErr  bool
+	}{
+		{"", "Google", false},
+		{"Google", "Google", false},
+		{"Google", "Aws", true},
+		{"Aws", "", false},
+	}
+	for _, c := range cases {
+		err := validateGlobalVpcProvider(c.current, c.incoming)
+		if (err != nil) != c.wantErr {
+			t.Errorf("validateGlobalVpcProvider(%q, %q) = %v, wantErr %v", c.current, c.incoming, err, c.wantErr)
+		}
+	}
+}
+
+func TestBuildGlobalVpcRegionStats(t *testing.T) {
+	vpcRows := []sGlobalVpcVpcRegionRow{
+		{VpcId: "vpc-1", GlobalvpcId: "gvpc-1", CloudregionId: "us-central1", Cloudregion: "US Central"},
+		{VpcId: "vpc-2", GlobalvpcId: "gvpc-1", CloudregionId: "us-central1", Cloudregion: "US Central"},
+		{VpcId: "vpc-3", GlobalvpcId: "gvpc-1", CloudregionId: "europe-west1", Cloudregion: "EU West"},
+		{VpcId: "vpc-4", GlobalvpcId: "gvpc-2", CloudregionId: "us-central1", Cloudregion: "US Central"},
+	}
+	networkCounts := map[string]int{
+		"vpc-1": 2,
+		"vpc-2": 1,
+		"vpc-3": 2,
+	}
+
+	stats := buildGlobalVpcRegionStats(vpcRows, networkCounts)
+	if len(stats) != 2 {
+		t.Fatalf("stats for %d global vpcs, want 2", len(stats))
+	}
+	// regions are sorted by id for a stable response
+	spanning := stats["gvpc-1"]
+	if len(spanning) != 2 || spanning[0].CloudregionId != "europe-west1" || spanning[1].CloudregionId != "us-central1" {
+		t.Fatalf("gvpc-1 stats = %+v, want europe-west1 then us-central1", spanning)
+	}
+	if spanning[1].VpcCount != 2 || spanning[1].NetworkCount != 3 {
+		t.Errorf("us-central1 = %+v, want 2 vpcs with 3 networks", spanning[1])
+	}
+	if spanning[0].VpcCount != 1 || spanning[0].NetworkCount != 2 {
+		t.Errorf("europe-west1 = %+v, want 1 vpc with 2 networks", spanning[0])
+	}
+	if spanning[1].Cloudregion != "US Central" {
+		t.Errorf("region name = %q, want US Central", spanning[1].Cloudregion)
+	}
+
+	// a child vpc without networks still counts
+	single := stats["gvpc-2"]
+	if len(single) != 1 || single[0].VpcCount != 1 || single[0].NetworkCount != 0 {
+		t.Errorf("gvpc-2 stats = %+v, want 1 vpc with 0 networks", single)
+	}
+
+	// a global vpc without children has no entry at all
+	if _, ok := stats["gvpc-empty"]; ok {
+		t.Errorf("empty global vpc must not appear in the stats")
+	}
+	if got := buildGlobalVpcRegionStats(nil, nil); len(got) != 0 {
+		t.Errorf("no rows must yield no stats, got %v", got)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+
+	"yunion.io/x/onecloud/pkg/compute/options"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+// SGuestUsageMetrics holds the latest usage datapoints of one guest,
+// nil fields mean no data was collected
+type SGuestUsageMetrics struct {
+	CpuUsagePercent  *float64
+	MemUsagePercent  *float64
+	DiskUsagePercent *float64
+}
+
+// IGuestMetricFetcher batch-fetches the latest usage datapoints of the
+// given guests keyed by guest id. Implementations must tolerate guests
+// without data and just omit them from the result.
+type IGuestMetricFetcher interface {
+	FetchGuestUsages(guestIds []string) (map[string]SGuestUsageMetrics, error)
+}
+
+type guestMetricsCacheEntry struct {
+	metrics   SGuestUsageMetrics
+	fetchedAt time.Time
+}
+
+// SGuestMetricsHelper serves guest usage metrics for list details,
+// caching fetch results briefly so UI refreshes do not hammer influxdb
+type SGuestMetricsHelper struct {
+	lock    sync.Mutex
+	fetcher IGuestMetricFetcher
+	cache   map[string]guestMetricsCacheEntry
+}
+
+func newGuestMetricsHelper(fetcher IGuestMetricFetcher) *SGuestMetricsHelper {
+	if fetcher == nil {
+		fetcher = &influxdbGuestMetricFetcher{}
+	}
+	return &SGuestMetricsHelper{
+		fetcher: fetcher,
+		cache:   make(map[string]guestMetricsCacheEntry),
+	}
+}
+
+var guestMetricsHelper = newGuestMetricsHelper(nil)
+
+func (h *SGuestMetricsHelper) cacheTTL() time.Duration {
+	seconds := options.Options.GuestMetricsCacheSeconds
+	if seconds <= 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// GetGuestUsages returns usage metrics for the guests, fetching cache
+// misses in one batch. Fetch failures degrade to an empty result so
+// list requests never fail on monitoring issues.
+func (h *SGuestMetricsHelper) GetGuestUsages(guestIds []string) map[string]SGuestUsageMetrics {
+	ret := make(map[string]SGuestUsageMetrics)
+	missed := make([]string, 0)
+	now := time.Now()
+	ttl := h.cacheTTL()
+	h.lock.Lock()
+	for _, id := range guestIds {
+		if entry, ok := h.cache[id]; ok && now.Sub(entry.fetchedAt) < ttl {
+			ret[id] = entry.metrics
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	h.lock.Unlock()
+	if len(missed) == 0 {
+		return ret
+	}
+	fetched, err := h.fetcher.FetchGuestUsages(missed)
+	if err != nil {
+		log.Errorf("fetch guest usage metrics: %v", err)
+		return ret
+	}
+	h.lock.Lock()
+	for _, id := range missed {
+		// cache misses as well, a guest without data stays empty for
+		// one TTL instead of being re-queried on every refresh
+		metrics := fetched[id]
+		h.cache[id] = guestMetricsCacheEntry{metrics: metrics, fetchedAt: now}
+		ret[id] = metrics
+	}
+	h.lock.Unlock()
+	return ret
+}
+
+// influxdbGuestMetricFetcher queries the telegraf database for the
+// latest datapoint of each usage metric, one query per metric for the
+// whole batch filtered on the vm_id tag
+type influxdbGuestMetricFetcher struct{}
+
+func (f *influxdbGuestMetricFetcher) FetchGuestUsages(guestIds []string) (map[string]SGuestUsageMetrics, error) {
+	urls, err := auth.GetServiceURLs("influxdb", options.Options.Region, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no influxdb endpoint")
+	}
+	dbinst := influxdb.NewInfluxdb(urls[0])
+	ret := make(map[string]SGuestUsageMetrics)
+	idFilter := strings.Join(guestIds, "|")
+	queries := []struct {
+		sql   string
+		apply func(m *SGuestUsageMetrics, val float64)
+	}{
+		{
+			fmt.Sprintf("SELECT last(usage_active) FROM telegraf..cpu WHERE vm_id =~ /^(%s)$/ GROUP BY vm_id", idFilter),
+			func(m *SGuestUsageMetrics, val float64) { m.CpuUsagePercent = &val },
+		},
+		{
+			fmt.Sprintf("SELECT last(used_percent) FROM telegraf..mem WHERE vm_id =~ /^(%s)$/ GROUP BY vm_id", idFilter),
+			func(m *SGuestUsageMetrics, val float64) { m.MemUsagePercent = &val },
+		},
+		{
+			fmt.Sprintf("SELECT last(used_percent) FROM telegraf..disk WHERE vm_id =~ /^(%s)$/ GROUP BY vm_id", idFilter),
+			func(m *SGuestUsageMetrics, val float64) { m.DiskUsagePercent = &val },
+		},
+	}
+	for _, query := range queries {
+		queryRes, err := dbinst.Query(query.sql)
+		if err != nil {
+			return nil, err
+		}
+		for _, resSeries := range queryRes {
+			for _, series := range resSeries {
+				if series.Tags == nil || len(series.Values) == 0 || len(series.Values[0]) < 2 || series.Values[0][1] == nil {
+					continue
+				}
+				vmId, err := series.Tags.GetString("vm_id")
+				if err != nil {
+					continue
+				}
+				val, err := series.Values[0][1].Float()
+				if err != nil {
+					continue
+				}
+				metrics := ret[vmId]
+				query.apply(&metrics, val)
+				ret[vmId] = metrics
+			}
+		}
+	}
+	return ret, nil
+}
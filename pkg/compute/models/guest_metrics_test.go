@@ -0,0 +1,88 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+type mockGuestMetricFetcher struct {
+	calls   int
+	fetched [][]string
+	data    map[string]SGuestUsageMetrics
+	err     error
+}
+
+func (f *mockGuestMetricFetcher) FetchGuestUsages(guestIds []string) (map[string]SGuestUsageMetrics, error) {
+	f.calls++
+	f.fetched = append(f.fetched, guestIds)
+	if f.err != nil {
+		return nil, f.err
+	}
+	ret := make(map[string]SGuestUsageMetrics)
+	for _, id := range guestIds {
+		if m, ok := f.data[id]; ok {
+			ret[id] = m
+		}
+	}
+	return ret, nil
+}
+
+func TestGuestMetricsHelperCache(t *testing.T) {
+	cpu := 42.5
+	fetcher := &mockGuestMetricFetcher{
+		data: map[string]SGuestUsageMetrics{
+			"guest1": {CpuUsagePercent: &cpu},
+		},
+	}
+	helper := newGuestMetricsHelper(fetcher)
+
+	usages := helper.GetGuestUsages([]string{"guest1", "guest2"})
+	if fetcher.calls != 1 {
+		t.Fatalf("expect 1 fetch, got %d", fetcher.calls)
+	}
+	if usage, ok := usages["guest1"]; !ok || usage.CpuUsagePercent == nil || *usage.CpuUsagePercent != cpu {
+		t.Fatalf("unexpected guest1 usage %+v", usages["guest1"])
+	}
+	if usage := usages["guest2"]; usage.CpuUsagePercent != nil {
+		t.Fatalf("guest2 without data should have nil fields, got %+v", usage)
+	}
+
+	// second call inside the TTL must be served from cache, including
+	// the guest without data
+	helper.GetGuestUsages([]string{"guest1", "guest2"})
+	if fetcher.calls != 1 {
+		t.Fatalf("expect cached result, got %d fetches", fetcher.calls)
+	}
+
+	// a new guest triggers a fetch for the miss only
+	helper.GetGuestUsages([]string{"guest1", "guest3"})
+	if fetcher.calls != 2 {
+		t.Fatalf("expect 2 fetches, got %d", fetcher.calls)
+	}
+	if len(fetcher.fetched[1]) != 1 || fetcher.fetched[1][0] != "guest3" {
+		t.Fatalf("expect fetch of guest3 only, got %v", fetcher.fetched[1])
+	}
+}
+
+func TestGuestMetricsHelperFetchError(t *testing.T) {
+	fetcher := &mockGuestMetricFetcher{err: fmt.Errorf("influxdb down")}
+	helper := newGuestMetricsHelper(fetcher)
+	usages := helper.GetGuestUsages([]string{"guest1"})
+	if len(usages) != 0 {
+		t.Fatalf("fetch error must degrade to empty result, got %+v", usages)
+	}
+}
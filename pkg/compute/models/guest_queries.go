@@ -22,10 +22,11 @@ import (
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/tristate"
+	"yunion.io/x/pkg/utils"
 	"yunion.io/x/sqlchemy"
 
-	"yunion.io/x/onecloud/pkg/apis"
 	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/mcclient"
 	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
@@ -51,6 +52,33 @@ func (manager *SGuestManager) FetchCustomizeColumns(
 		guestIds[i] = objs[i].(*SGuest).GetId()
 	}
 
+	if query != nil && jsonutils.QueryBoolean(query, "with_metrics", false) {
+		usages := guestMetricsHelper.GetGuestUsages(guestIds)
+		for i := range rows {
+			if usage, ok := usages[guestIds[i]]; ok {
+				rows[i].CpuUsagePercent = usage.CpuUsagePercent
+				rows[i].MemUsagePercent = usage.MemUsagePercent
+				rows[i].DiskUsagePercent = usage.DiskUsagePercent
+			}
+		}
+	}
+
+	if query != nil {
+		withHostMeta, _ := query.GetString("with_host_meta")
+		if keys := parseWithHostMeta(withHostMeta); len(keys) > 0 {
+			hostIds := make([]string, len(objs))
+			for i := range objs {
+				hostIds[i] = objs[i].(*SGuest).HostId
+			}
+			hostMeta := fetchHostMetadata(hostIds, keys)
+			for i := range rows {
+				if meta, ok := hostMeta[hostIds[i]]; ok {
+					rows[i].HostMetadata = meta
+				}
+			}
+		}
+	}
+
 	if len(fields) == 0 || fields.Contains("disk") {
 		gds := fetchGuestDiskSizes(guestIds)
 		if gds != nil {
@@ -99,18 +127,44 @@ func (manager *SGuestManager) FetchCustomizeColumns(
 	}
 	if len(fields) == 0 || fields.Contains("secgroups") || fields.Contains("secgroup") {
 		gsgs := fetchSecgroups(guestIds)
-		if gsgs != nil {
-			for i := range rows {
-				if gsg, ok := gsgs[guestIds[i]]; ok {
-					if len(fields) == 0 || fields.Contains("secgroups") {
-						rows[i].Secgroups = gsg
-					}
-					if len(fields) == 0 || fields.Contains("secgroup") {
-						rows[i].Secgroup = gsg[0].Name
-					}
+		var gadmins map[string]api.SecgroupShortDesc
+		if userCred.HasSystemAdminPrivilege() {
+			gadmins = fetchAdminSecgroups(guestIds)
+		}
+		secgroupIds := make([]string, 0)
+		seenSecgroups := make(map[string]bool)
+		for _, gsg := range gsgs {
+			for i := range gsg {
+				if !seenSecgroups[gsg[i].Id] {
+					seenSecgroups[gsg[i].Id] = true
+					secgroupIds = append(secgroupIds, gsg[i].Id)
 				}
 			}
 		}
+		for _, desc := range gadmins {
+			if !seenSecgroups[desc.Id] {
+				seenSecgroups[desc.Id] = true
+				secgroupIds = append(secgroupIds, desc.Id)
+			}
+		}
+		stats := fetchSecgroupRuleStats(secgroupIds)
+		for i := range rows {
+			if gsg, ok := gsgs[guestIds[i]]; ok {
+				for j := range gsg {
+					applySecgroupRuleStats(&gsg[j], stats)
+				}
+				if len(fields) == 0 || fields.Contains("secgroups") {
+					rows[i].Secgroups = gsg
+				}
+				if len(fields) == 0 || fields.Contains("secgroup") {
+					rows[i].Secgroup = gsg[0].Name
+				}
+			}
+			if desc, ok := gadmins[guestIds[i]]; ok {
+				applySecgroupRuleStats(&desc, stats)
+				rows[i].AdminSecgroupDesc = &desc
+			}
+		}
 	}
 	if len(fields) == 0 || fields.Contains("eip") || fields.Contains("eip_mode") {
 		geips := fetchGuestEips(guestIds)
@@ -221,18 +275,22 @@ func (guest *SGuest) getDiskSize() int {
 
 func fetchGuestIPs(guestIds []string, virtual tristate.TriState) map[string][]string {
 	guestnetworks := GuestnetworkManager.Query().SubQuery()
-	q := guestnetworks.Query(guestnetworks.Field("guest_id"), guestnetworks.Field("ip_addr"))
+	q := guestnetworks.Query(guestnetworks.Field("guest_id"), guestnetworks.Field("ip_addr"), guestnetworks.Field("ip6_addr"))
 	q = q.In("guest_id", guestIds)
 	if virtual.IsTrue() {
 		q = q.IsTrue("virtual")
 	} else if virtual.IsFalse() {
 		q = q.IsFalse("virtual")
 	}
-	q = q.IsNotEmpty("ip_addr")
+	q = q.Filter(sqlchemy.OR(
+		sqlchemy.IsNotEmpty(q.Field("ip_addr")),
+		sqlchemy.IsNotEmpty(q.Field("ip6_addr")),
+	))
 	q = q.Asc("ip_addr")
 	type sGuestIdIpAddr struct {
 		GuestId string
 		IpAddr  string
+		Ip6Addr string
 	}
 	gias := make([]sGuestIdIpAddr, 0)
 	err := q.All(&gias)
@@ -244,7 +302,12 @@ func fetchGuestIPs(guestIds []string, virtual tristate.TriState) map[string][]st
 		if _, ok := ret[gias[i].GuestId]; !ok {
 			ret[gias[i].GuestId] = make([]string, 0)
 		}
-		ret[gias[i].GuestId] = append(ret[gias[i].GuestId], gias[i].IpAddr)
+		if len(gias[i].IpAddr) > 0 {
+			ret[gias[i].GuestId] = append(ret[gias[i].GuestId], gias[i].IpAddr)
+		}
+		if len(gias[i].Ip6Addr) > 0 {
+			ret[gias[i].GuestId] = append(ret[gias[i].GuestId], gias[i].Ip6Addr)
+		}
 	}
 	return ret
 }
@@ -255,11 +318,17 @@ func fetchGuestNICs(ctx context.Context, guestIds []string, virtual tristate.Tri
 	if err := q.All(&nics); err != nil {
 		return nil
 	}
+	netIds := make([]string, 0)
+	for i := range nics {
+		if !utils.IsInStringArray(nics[i].NetworkId, netIds) {
+			netIds = append(netIds, nics[i].NetworkId)
+		}
+	}
+	// one network fetch for the whole page instead of one per nic
+	nets := fetchNetworksByIds(netIds)
 	ret := make(map[string][]api.GuestnetworkShortDesc)
 	for i := range nics {
-		desc := api.GuestnetworkShortDesc{}
-		jsonDesc := nics[i].GetShortDesc(ctx)
-		jsonDesc.Unmarshal(&desc)
+		desc := guestnetworkShortDesc(&nics[i], nets[nics[i].NetworkId])
 		if _, ok := ret[nics[i].GuestId]; !ok {
 			ret[nics[i].GuestId] = []api.GuestnetworkShortDesc{desc}
 		} else {
@@ -269,6 +338,20 @@ func fetchGuestNICs(ctx context.Context, guestIds []string, virtual tristate.Tri
 	return ret
 }
 
+func fetchNetworksByIds(netIds []string) map[string]*SNetwork {
+	nets := make([]SNetwork, 0)
+	q := NetworkManager.Query().In("id", netIds)
+	if err := q.All(&nets); err != nil && err != sql.ErrNoRows {
+		return nil
+	}
+	ret := make(map[string]*SNetwork, len(nets))
+	for i := range nets {
+		nets[i].SetModelManager(NetworkManager, &nets[i])
+		ret[nets[i].Id] = &nets[i]
+	}
+	return ret
+}
+
 func (self *SGuest) GetRealIPs() []string {
 	result := fetchGuestIPs([]string{self.Id}, tristate.False)
 	if result == nil {
@@ -328,7 +411,7 @@ func fetchGuestVpcs(guestIds []string) map[string]sGuestVpcsInfo {
 	return ret
 }
 
-func fetchSecgroups(guestIds []string) map[string][]apis.StandaloneShortDesc {
+func fetchSecgroups(guestIds []string) map[string][]api.SecgroupShortDesc {
 	secgroups := SecurityGroupManager.Query().SubQuery()
 	guestsecgroups := GuestsecgroupManager.Query().SubQuery()
 	guests := GuestManager.Query().SubQuery()
@@ -354,22 +437,131 @@ func fetchSecgroups(guestIds []string) map[string][]apis.StandaloneShortDesc {
 		return nil
 	}
 
-	ret := make(map[string][]apis.StandaloneShortDesc)
+	ret := make(map[string][]api.SecgroupShortDesc)
 	for i := range gsgs {
 		gsg, ok := ret[gsgs[i].GuestId]
 		if !ok {
-			gsg = make([]apis.StandaloneShortDesc, 0)
+			gsg = make([]api.SecgroupShortDesc, 0)
 		}
-		gsg = append(gsg, apis.StandaloneShortDesc{
-			Id:   gsgs[i].SecgroupId,
-			Name: gsgs[i].SecgroupName,
-		})
+		desc := api.SecgroupShortDesc{}
+		desc.Id = gsgs[i].SecgroupId
+		desc.Name = gsgs[i].SecgroupName
+		gsg = append(gsg, desc)
 		ret[gsgs[i].GuestId] = gsg
 	}
 
 	return ret
 }
 
+// fetchAdminSecgroups returns the admin secgroup of each guest that
+// has one, indexed by guest id
+func fetchAdminSecgroups(guestIds []string) map[string]api.SecgroupShortDesc {
+	secgroups := SecurityGroupManager.Query().SubQuery()
+	guests := GuestManager.Query().SubQuery()
+
+	q := guests.Query(guests.Field("id").Label("guest_id"),
+		guests.Field("admin_secgrp_id").Label("secgroup_id"),
+		secgroups.Field("name").Label("secgroup_name"))
+	q = q.Join(secgroups, sqlchemy.Equals(guests.Field("admin_secgrp_id"), secgroups.Field("id")))
+	q = q.Filter(sqlchemy.In(guests.Field("id"), guestIds))
+
+	type sGuestSecgroupInfo struct {
+		SecgroupId   string
+		SecgroupName string
+		GuestId      string
+	}
+
+	gsgs := make([]sGuestSecgroupInfo, 0)
+	if err := q.All(&gsgs); err != nil {
+		return nil
+	}
+
+	ret := make(map[string]api.SecgroupShortDesc, len(gsgs))
+	for i := range gsgs {
+		desc := api.SecgroupShortDesc{}
+		desc.Id = gsgs[i].SecgroupId
+		desc.Name = gsgs[i].SecgroupName
+		ret[gsgs[i].GuestId] = desc
+	}
+	return ret
+}
+
+// sSecgroupRuleRow is the minimal rule projection the per-secgroup
+// statistics need
+type sSecgroupRuleRow struct {
+	SecgroupId string
+	Direction  string
+	Action     string
+	Protocol   string
+	Cidr       string
+}
+
+// sSecgroupRuleStat summarizes the rules of one secgroup
+type sSecgroupRuleStat struct {
+	InRuleCount  int
+	OutRuleCount int
+	HasAllowAny  bool
+}
+
+// isAllowAnyRule reports whether a rule lets any protocol through from
+// any source, the kind worth flagging in overviews
+func isAllowAnyRule(action string, protocol string, cidr string) bool {
+	if action != "allow" || protocol != "any" {
+		return false
+	}
+	return cidr == "" || cidr == "0.0.0.0/0" || cidr == "::/0"
+}
+
+// foldSecgroupRuleStats groups rule rows into per-secgroup counts per
+// direction and the allow-any flag
+func foldSecgroupRuleStats(rows []sSecgroupRuleRow) map[string]sSecgroupRuleStat {
+	stats := make(map[string]sSecgroupRuleStat)
+	for i := range rows {
+		stat := stats[rows[i].SecgroupId]
+		switch rows[i].Direction {
+		case "in":
+			stat.InRuleCount++
+		case "out":
+			stat.OutRuleCount++
+		}
+		if isAllowAnyRule(rows[i].Action, rows[i].Protocol, rows[i].Cidr) {
+			stat.HasAllowAny = true
+		}
+		stats[rows[i].SecgroupId] = stat
+	}
+	return stats
+}
+
+// fetchSecgroupRuleStats loads the rule statistics of all given
+// secgroups with a single query over the rules table
+func fetchSecgroupRuleStats(secgroupIds []string) map[string]sSecgroupRuleStat {
+	if len(secgroupIds) == 0 {
+		return nil
+	}
+	rules := SecurityGroupRuleManager.Query().SubQuery()
+	q := rules.Query(rules.Field("secgroup_id"),
+		rules.Field("direction"),
+		rules.Field("action"),
+		rules.Field("protocol"),
+		rules.Field("cidr"))
+	q = q.Filter(sqlchemy.In(rules.Field("secgroup_id"), secgroupIds))
+	rows := make([]sSecgroupRuleRow, 0)
+	if err := q.All(&rows); err != nil {
+		return nil
+	}
+	return foldSecgroupRuleStats(rows)
+}
+
+// applySecgroupRuleStats fills the rule statistics into a secgroup
+// short desc
+func applySecgroupRuleStats(desc *api.SecgroupShortDesc, stats map[string]sSecgroupRuleStat) {
+	if stat, ok := stats[desc.Id]; ok {
+		desc.InRuleCount = stat.InRuleCount
+		desc.OutRuleCount = stat.OutRuleCount
+		desc.HasAllowAny = stat.HasAllowAny
+	}
+}
+
 type sEipInfo struct {
 	IpAddr  string
 	Mode    string
@@ -461,3 +653,51 @@ func fetchGuestCdroms(guestIds []string) map[string]SGuestcdrom {
 	}
 	return ret
 }
+
+// parseWithHostMeta splits the comma separated with_host_meta query
+// parameter into the requested host metadata keys
+func parseWithHostMeta(value string) []string {
+	keys := make([]string, 0)
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// displayHostMetaKey strips the user: storage prefix so tags come back
+// under the key the caller asked for
+func displayHostMetaKey(key string) string {
+	return strings.TrimPrefix(key, db.USER_TAG_PREFIX)
+}
+
+// buildHostMetaMap groups metadata rows by host id, keyed by the
+// display form of each tag key
+func buildHostMetaMap(metas []db.SMetadata) map[string]map[string]string {
+	result := make(map[string]map[string]string)
+	for i := range metas {
+		if _, ok := result[metas[i].ObjId]; !ok {
+			result[metas[i].ObjId] = make(map[string]string)
+		}
+		result[metas[i].ObjId][displayHostMetaKey(metas[i].Key)] = metas[i].Value
+	}
+	return result
+}
+
+func fetchHostMetadata(hostIds []string, keys []string) map[string]map[string]string {
+	allKeys := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		allKeys = append(allKeys, hostMetadataKeyVariants(key)...)
+	}
+	metas := make([]db.SMetadata, 0)
+	q := db.Metadata.Query().Equals("obj_type", HostManager.Keyword()).In("obj_id", hostIds).In("key", allKeys)
+	if err := db.FetchModelObjects(db.Metadata, q, &metas); err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("fetch host metadata: %v", err)
+		}
+		return nil
+	}
+	return buildHostMetaMap(metas)
+}
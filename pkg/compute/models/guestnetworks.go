@@ -809,19 +809,47 @@ func (manager *SGuestnetworkManager) FetchByIdsAndIpMac(guestId string, netId st
 }
 
 func (self *SGuestnetwork) GetShortDesc(ctx context.Context) *jsonutils.JSONDict {
-	desc := api.GuestnetworkShortDesc{}
-	if len(self.IpAddr) > 0 {
-		desc.IpAddr = self.IpAddr
-		desc.IsExit = self.IsExit()
+	desc := guestnetworkShortDesc(self, self.GetNetwork())
+	return jsonutils.Marshal(desc).(*jsonutils.JSONDict)
+}
+
+// guestnetworkShortDesc builds the nic short description shown in
+// server details. The nic's network may be nil, callers with many nics
+// batch the network lookups instead of fetching per nic.
+func guestnetworkShortDesc(nic *SGuestnetwork, net *SNetwork) api.GuestnetworkShortDesc {
+	desc := api.GuestnetworkShortDesc{
+		Mac:     nic.MacAddr,
+		Driver:  nic.Driver,
+		BwLimit: nic.BwLimit,
 	}
-	if len(self.Ip6Addr) > 0 {
-		desc.Ip6Addr = self.Ip6Addr
+	if len(nic.IpAddr) > 0 {
+		desc.IpAddr = nic.IpAddr
+		desc.IsExit = guestnetworkIsExit(nic.IpAddr, net)
 	}
-	desc.Mac = self.MacAddr
-	if len(self.TeamWith) > 0 {
-		desc.TeamWith = self.TeamWith
+	if len(nic.Ip6Addr) > 0 {
+		desc.Ip6Addr = nic.Ip6Addr
 	}
-	return jsonutils.Marshal(desc).(*jsonutils.JSONDict)
+	if len(nic.TeamWith) > 0 {
+		desc.TeamWith = nic.TeamWith
+	}
+	if net != nil {
+		desc.Vlan = net.VlanId
+	}
+	return desc
+}
+
+// guestnetworkIsExit mirrors SGuestnetwork.IsExit but takes the network
+// as a parameter so it works on batched lookups
+func guestnetworkIsExit(ipAddr string, net *SNetwork) bool {
+	if ipAddr != "" {
+		if addr, err := netutils.NewIPV4Addr(ipAddr); err == nil {
+			return netutils.IsExitAddress(addr)
+		}
+	}
+	if net != nil {
+		return net.IsExitNetwork()
+	}
+	return false
 }
 
 func (self *SGuestnetwork) ToNetworkConfig() *api.NetworkConfig {
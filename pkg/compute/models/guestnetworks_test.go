@@ -0,0 +1,68 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestGuestnetworkShortDescTeamedPair(t *testing.T) {
+	nic0 := &SGuestnetwork{
+		MacAddr:  "00:22:33:44:55:66",
+		IpAddr:   "10.0.0.4",
+		Driver:   "virtio",
+		BwLimit:  100,
+		TeamWith: "00:22:33:44:55:67",
+	}
+	nic1 := &SGuestnetwork{
+		MacAddr:  "00:22:33:44:55:67",
+		Driver:   "virtio",
+		BwLimit:  100,
+		TeamWith: "00:22:33:44:55:66",
+	}
+
+	desc0 := guestnetworkShortDesc(nic0, nil)
+	if desc0.TeamWith != nic1.MacAddr || desc0.Driver != "virtio" || desc0.BwLimit != 100 {
+		t.Errorf("teamed nic desc = %+v", desc0)
+	}
+	if desc0.IpAddr != "10.0.0.4" || desc0.IsExit {
+		t.Errorf("10.0.0.4 is an internal address, desc = %+v", desc0)
+	}
+
+	// the slave of the pair carries no address of its own
+	desc1 := guestnetworkShortDesc(nic1, nil)
+	if desc1.IpAddr != "" || desc1.TeamWith != nic0.MacAddr {
+		t.Errorf("team slave desc = %+v", desc1)
+	}
+}
+
+func TestGuestnetworkShortDescIpv6(t *testing.T) {
+	net := &SNetwork{VlanId: 100}
+	nic := &SGuestnetwork{
+		MacAddr: "00:22:33:44:55:68",
+		IpAddr:  "192.168.1.5",
+		Ip6Addr: "fd00::5",
+		Driver:  "e1000",
+	}
+
+	desc := guestnetworkShortDesc(nic, net)
+	if desc.Ip6Addr != "fd00::5" {
+		t.Errorf("Ip6Addr = %q, want fd00::5", desc.Ip6Addr)
+	}
+	if desc.Vlan != 100 {
+		t.Errorf("Vlan = %d, want 100", desc.Vlan)
+	}
+	if desc.Driver != "e1000" {
+		t.Errorf("Driver = %q, want e1000", desc.Driver)
+	}
+}
@@ -157,6 +157,93 @@ func (manager *SGuestManager) AllowListItems(ctx context.Context, userCred mccli
 }
 
 // 云主机实例列表
+// resolveGuestHostId resolves a server id or name to the host the
+// guest currently runs on; an empty result means the guest has no host
+// yet, e.g. pending deploy, and host affinity filters become no-ops
+func (manager *SGuestManager) resolveGuestHostId(userCred mcclient.TokenCredential, serverFilter string) (string, error) {
+	guestObj, err := manager.FetchByIdOrName(userCred, serverFilter)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return "", httperrors.NewResourceNotFoundError("server %s not found", serverFilter)
+		}
+		return "", errors.Wrap(err, "GuestManager.FetchByIdOrName")
+	}
+	return guestObj.(*SGuest).HostId, nil
+}
+
+// matchHostAffinity mirrors the host_id condition the same_host_as and
+// different_host_from filters translate to, so the semantics can be
+// pinned by tests: an empty reference host matches everything
+func matchHostAffinity(guestHostId string, refHostId string, same bool) bool {
+	if len(refHostId) == 0 {
+		return true
+	}
+	if same {
+		return guestHostId == refHostId
+	}
+	return guestHostId != refHostId
+}
+
+// the value sets the kvm driver accepts, mirrored from getVga and
+// friends, so list filters reject typos instead of silently matching
+// nothing
+var (
+	guestVgaChoices     = []string{"std", "cirrus", "vmware", "qxl"}
+	guestVdiChoices     = []string{"vnc", "spice"}
+	guestMachineChoices = []string{"pc", "q35"}
+	guestBiosChoices    = []string{"BIOS", "UEFI"}
+)
+
+// isValidBootOrder accepts a non-empty sequence of the boot targets
+// c(drom), d(isk) and n(etwork) without repeats, e.g. "cdn" or "dc"
+func isValidBootOrder(order string) bool {
+	if len(order) == 0 || len(order) > 3 {
+		return false
+	}
+	seen := make(map[rune]bool)
+	for _, ch := range order {
+		if ch != 'c' && ch != 'd' && ch != 'n' {
+			return false
+		}
+		if seen[ch] {
+			return false
+		}
+		seen[ch] = true
+	}
+	return true
+}
+
+// validateGuestListEnumFilters rejects filter values outside the sets
+// the hypervisor drivers recognize
+func validateGuestListEnumFilters(query api.ServerListInput) error {
+	check := func(name string, values []string, choices []string) error {
+		for _, value := range values {
+			if !utils.IsInStringArray(value, choices) {
+				return httperrors.NewInputParameterError("invalid %s %q, want one of %s", name, value, strings.Join(choices, "|"))
+			}
+		}
+		return nil
+	}
+	if err := check("vga", query.Vga, guestVgaChoices); err != nil {
+		return err
+	}
+	if err := check("vdi", query.Vdi, guestVdiChoices); err != nil {
+		return err
+	}
+	if err := check("machine", query.Machine, guestMachineChoices); err != nil {
+		return err
+	}
+	if err := check("bios", query.Bios, guestBiosChoices); err != nil {
+		return err
+	}
+	for _, order := range query.BootOrder {
+		if !isValidBootOrder(order) {
+			return httperrors.NewInputParameterError("invalid boot_order %q, want a combination of c, d, n", order)
+		}
+	}
+	return nil
+}
+
 func (manager *SGuestManager) ListItemFilter(
 	ctx context.Context,
 	q *sqlchemy.SQuery,
@@ -165,6 +252,10 @@ func (manager *SGuestManager) ListItemFilter(
 ) (*sqlchemy.SQuery, error) {
 	var err error
 
+	if err = validateGuestListEnumFilters(query); err != nil {
+		return nil, err
+	}
+
 	q, err = manager.SHostResourceBaseManager.ListItemFilter(ctx, q, userCred, query.HostFilterListInput)
 	if err != nil {
 		return nil, errors.Wrap(err, "SHostResourceBaseManager.ListItemFilter")
@@ -190,6 +281,10 @@ func (manager *SGuestManager) ListItemFilter(
 		return nil, errors.Wrap(err, "SVirtualResourceBaseManager.ListItemFilter")
 	}
 
+	if search := normalizeSearchText(query.Search); len(search) > 0 {
+		q = applyGuestSearchFilter(q, search)
+	}
+
 	netQ := GuestnetworkManager.Query("guest_id").Snapshot()
 	netQ, err = manager.SNetworkResourceBaseManager.ListItemFilter(ctx, netQ, userCred, query.NetworkFilterListInput)
 	if err != nil {
@@ -208,6 +303,13 @@ func (manager *SGuestManager) ListItemFilter(
 		q = q.In("id", diskQ.SubQuery())
 	}
 
+	if len(query.Metadata) > 0 || len(query.WithoutMetadataKeys) > 0 {
+		q, err = db.ApplyMetadataKeyValueFilters(q, manager.Keyword(), query.Metadata, query.WithoutMetadataKeys, userCred.HasSystemAdminPrivilege())
+		if err != nil {
+			return nil, errors.Wrap(err, "db.ApplyMetadataKeyValueFilters")
+		}
+	}
+
 	hypervisorList := query.Hypervisor
 	if len(hypervisorList) > 0 {
 		q = q.In("hypervisor", hypervisorList)
@@ -246,6 +348,25 @@ func (manager *SGuestManager) ListItemFilter(
 		}
 	}
 
+	if len(query.SameHostAs) > 0 {
+		hostId, err := manager.resolveGuestHostId(userCred, query.SameHostAs)
+		if err != nil {
+			return nil, err
+		}
+		if len(hostId) > 0 {
+			q = q.Equals("host_id", hostId)
+		}
+	}
+	if len(query.DifferentHostFrom) > 0 {
+		hostId, err := manager.resolveGuestHostId(userCred, query.DifferentHostFrom)
+		if err != nil {
+			return nil, err
+		}
+		if len(hostId) > 0 {
+			q = q.NotEquals("host_id", hostId)
+		}
+	}
+
 	secgrpFilter := query.Secgroup
 	if len(secgrpFilter) > 0 {
 		var notIn = false
@@ -300,6 +421,18 @@ func (manager *SGuestManager) ListItemFilter(
 		}
 	}
 
+	adminSecgrpFilter := query.AdminSecgroup
+	if len(adminSecgrpFilter) > 0 {
+		secgrpObj, err := SecurityGroupManager.FetchByIdOrName(userCred, adminSecgrpFilter)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, httperrors.NewResourceNotFoundError("secgroup %s not found", adminSecgrpFilter)
+			}
+			return nil, httperrors.NewGeneralError(err)
+		}
+		q = q.Equals("admin_secgrp_id", secgrpObj.GetId())
+	}
+
 	usableServerForEipFilter := query.UsableServerForEip
 	if len(usableServerForEipFilter) > 0 {
 		eipObj, err := ElasticipManager.FetchByIdOrName(userCred, usableServerForEipFilter)
@@ -500,6 +633,11 @@ func (manager *SGuestManager) OrderByExtraFields(ctx context.Context, q *sqlchem
 		}
 	}
 
+	if search := normalizeSearchText(query.Search); len(search) > 0 && len(query.OrderBy) == 0 {
+		// without an explicit order the exact-name matches come first
+		q = q.Asc(sqlchemy.NewFunctionField("search_rank", searchRankExpr(search), q.Field("name")))
+	}
+
 	return q, nil
 }
 
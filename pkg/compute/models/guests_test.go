@@ -0,0 +1,225 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+)
+
+func TestMatchHostAffinity(t *testing.T) {
+	// three guests across two hosts
+	guests := []struct {
+		name   string
+		hostId string
+	}{
+		{"web-1", "host-a"},
+		{"web-2", "host-a"},
+		{"db-1", "host-b"},
+	}
+
+	// same_host_as web-1 keeps both guests on host-a
+	sameCount := 0
+	for _, g := range guests {
+		if matchHostAffinity(g.hostId, "host-a", true) {
+			sameCount++
+			if g.hostId != "host-a" {
+				t.Errorf("guest %s on %s must not match same_host_as host-a", g.name, g.hostId)
+			}
+		}
+	}
+	if sameCount != 2 {
+		t.Errorf("same_host_as host-a matched %d guests, want 2", sameCount)
+	}
+
+	// different_host_from web-1 keeps only the guest on host-b
+	diffCount := 0
+	for _, g := range guests {
+		if matchHostAffinity(g.hostId, "host-a", false) {
+			diffCount++
+			if g.name != "db-1" {
+				t.Errorf("guest %s must not match different_host_from host-a", g.name)
+			}
+		}
+	}
+	if diffCount != 1 {
+		t.Errorf("different_host_from host-a matched %d guests, want 1", diffCount)
+	}
+
+	// a reference guest without a host makes the filter a no-op
+	for _, g := range guests {
+		if !matchHostAffinity(g.hostId, "", true) || !matchHostAffinity(g.hostId, "", false) {
+			t.Errorf("guest %s must match when the reference host is empty", g.name)
+		}
+	}
+}
+
+func TestParseWithHostMeta(t *testing.T) {
+	keys := parseWithHostMeta(" rack, maintenance_window ,,")
+	if len(keys) != 2 || keys[0] != "rack" || keys[1] != "maintenance_window" {
+		t.Errorf("parseWithHostMeta = %v, want [rack maintenance_window]", keys)
+	}
+	if keys := parseWithHostMeta(""); len(keys) != 0 {
+		t.Errorf("parseWithHostMeta(\"\") = %v, want empty", keys)
+	}
+}
+
+func TestBuildHostMetaMap(t *testing.T) {
+	// host-a is tagged, host-b has no rows at all
+	metas := []db.SMetadata{
+		{ObjId: "host-a", Key: "user:rack", Value: "R12"},
+		{ObjId: "host-a", Key: "maintenance", Value: "sunday"},
+	}
+	got := buildHostMetaMap(metas)
+	if len(got) != 1 {
+		t.Fatalf("buildHostMetaMap covers %d hosts, want 1", len(got))
+	}
+	// the user: storage prefix is stripped for display
+	if got["host-a"]["rack"] != "R12" {
+		t.Errorf("rack = %q, want R12", got["host-a"]["rack"])
+	}
+	if got["host-a"]["maintenance"] != "sunday" {
+		t.Errorf("maintenance = %q, want sunday", got["host-a"]["maintenance"])
+	}
+	if _, ok := got["host-b"]; ok {
+		t.Errorf("untagged host must not appear in the map")
+	}
+}
+
+func TestHostMetadataKeyVariants(t *testing.T) {
+	got := hostMetadataKeyVariants("rack")
+	if len(got) != 2 || got[0] != "rack" || got[1] != "user:rack" {
+		t.Errorf("hostMetadataKeyVariants(rack) = %v, want [rack user:rack]", got)
+	}
+	if got := hostMetadataKeyVariants("user:rack"); len(got) != 1 || got[0] != "user:rack" {
+		t.Errorf("hostMetadataKeyVariants(user:rack) = %v, want [user:rack]", got)
+	}
+	if got := hostMetadataKeyVariants("__sys"); len(got) != 1 {
+		t.Errorf("hostMetadataKeyVariants(__sys) = %v, want the key verbatim", got)
+	}
+}
+
+func TestHostSnFilterValues(t *testing.T) {
+	// two hosts with distinct serial numbers, copied with inconsistent
+	// casing and stray whitespace
+	got := hostSnFilterValues([]string{"ABC123", " def456 "})
+	if len(got) != 2 || got[0] != "abc123" || got[1] != "def456" {
+		t.Errorf("hostSnFilterValues = %v, want [abc123 def456]", got)
+	}
+	if got := hostSnFilterValues([]string{"", "  "}); len(got) != 0 {
+		t.Errorf("blank serial numbers must be dropped, got %v", got)
+	}
+}
+
+func TestFoldSecgroupRuleStats(t *testing.T) {
+	rows := []sSecgroupRuleRow{
+		{SecgroupId: "sg-shared", Direction: "in", Action: "allow", Protocol: "tcp", Cidr: "10.0.0.0/8"},
+		{SecgroupId: "sg-shared", Direction: "in", Action: "deny", Protocol: "any", Cidr: "0.0.0.0/0"},
+		{SecgroupId: "sg-shared", Direction: "out", Action: "allow", Protocol: "any", Cidr: "0.0.0.0/0"},
+		{SecgroupId: "sg-other", Direction: "in", Action: "allow", Protocol: "udp", Cidr: "192.168.0.0/16"},
+	}
+	stats := foldSecgroupRuleStats(rows)
+	shared := stats["sg-shared"]
+	if shared.InRuleCount != 2 || shared.OutRuleCount != 1 || !shared.HasAllowAny {
+		t.Errorf("sg-shared stats = %+v, want 2 in, 1 out, allow-any", shared)
+	}
+	other := stats["sg-other"]
+	if other.InRuleCount != 1 || other.OutRuleCount != 0 || other.HasAllowAny {
+		t.Errorf("sg-other stats = %+v, want 1 in, 0 out, no allow-any", other)
+	}
+
+	// two servers sharing sg-shared both see the same counts
+	gsgs := map[string][]api.SecgroupShortDesc{
+		"vm-1": {{}},
+		"vm-2": {{}},
+	}
+	for _, guestId := range []string{"vm-1", "vm-2"} {
+		gsg := gsgs[guestId]
+		gsg[0].Id = "sg-shared"
+		gsg[0].Name = "shared"
+		applySecgroupRuleStats(&gsg[0], stats)
+		if gsg[0].InRuleCount != 2 || gsg[0].OutRuleCount != 1 || !gsg[0].HasAllowAny {
+			t.Errorf("%s secgroup desc = %+v, want shared stats filled", guestId, gsg[0])
+		}
+	}
+}
+
+func TestIsAllowAnyRule(t *testing.T) {
+	cases := []struct {
+		action   string
+		protocol string
+		cidr     string
+		want     bool
+	}{
+		{"allow", "any", "0.0.0.0/0", true},
+		{"allow", "any", "", true},
+		{"allow", "any", "::/0", true},
+		{"allow", "any", "10.0.0.0/8", false},
+		{"allow", "tcp", "0.0.0.0/0", false},
+		{"deny", "any", "0.0.0.0/0", false},
+	}
+	for _, c := range cases {
+		if got := isAllowAnyRule(c.action, c.protocol, c.cidr); got != c.want {
+			t.Errorf("isAllowAnyRule(%s, %s, %s) = %v, want %v", c.action, c.protocol, c.cidr, got, c.want)
+		}
+	}
+}
+
+func TestIsValidBootOrder(t *testing.T) {
+	for _, order := range []string{"c", "d", "n", "cdn", "dc", "nd"} {
+		if !isValidBootOrder(order) {
+			t.Errorf("%q must be a valid boot order", order)
+		}
+	}
+	for _, order := range []string{"", "x", "cc", "cdnc", "cdx"} {
+		if isValidBootOrder(order) {
+			t.Errorf("%q must be rejected", order)
+		}
+	}
+}
+
+func TestValidateGuestListEnumFilters(t *testing.T) {
+	valid := api.ServerListInput{
+		Vga:       []string{"std", "qxl"},
+		Vdi:       []string{"vnc"},
+		Machine:   []string{"q35"},
+		Bios:      []string{"UEFI"},
+		BootOrder: []string{"cdn"},
+	}
+	if err := validateGuestListEnumFilters(valid); err != nil {
+		t.Fatalf("valid filters rejected: %v", err)
+	}
+	if err := validateGuestListEnumFilters(api.ServerListInput{}); err != nil {
+		t.Fatalf("empty filters rejected: %v", err)
+	}
+
+	cases := []struct {
+		name  string
+		input api.ServerListInput
+	}{
+		{"bad vga", api.ServerListInput{Vga: []string{"hdmi"}}},
+		{"bad vdi", api.ServerListInput{Vdi: []string{"rdp"}}},
+		{"bad machine", api.ServerListInput{Machine: []string{"i440"}}},
+		{"bad bios lower case", api.ServerListInput{Bios: []string{"uefi"}}},
+		{"bad boot order", api.ServerListInput{BootOrder: []string{"abc"}}},
+	}
+	for _, c := range cases {
+		if err := validateGuestListEnumFilters(c.input); err == nil {
+			t.Errorf("%s: expect input parameter error", c.name)
+		}
+	}
+}
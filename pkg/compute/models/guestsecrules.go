@@ -0,0 +1,199 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"net"
+	"sort"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/util/secrules"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/compute/options"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// sSecRuleSource is the rule set of one secgroup together with its
+// identity, used to annotate merged rules with their origin
+type sSecRuleSource struct {
+	SecgroupId   string
+	SecgroupName string
+	IsAdmin      bool
+	Rules        []secrules.SecurityRule
+}
+
+// sEffectiveSecRule is a security rule annotated with its source
+type sEffectiveSecRule struct {
+	secrules.SecurityRule
+	SecgroupId   string
+	SecgroupName string
+	IsAdmin      bool
+}
+
+// mergeEffectiveSecRules flattens the rule sets into the order the
+// host evaluates them: admin secgroup rules first, then normal
+// secgroup rules, both by descending priority, with the default rule
+// appended last as the fallback
+func mergeEffectiveSecRules(sources []sSecRuleSource, defaultRule *secrules.SecurityRule) []sEffectiveSecRule {
+	merged := make([]sEffectiveSecRule, 0)
+	for _, source := range sources {
+		for i := range source.Rules {
+			merged = append(merged, sEffectiveSecRule{
+				SecurityRule: source.Rules[i],
+				SecgroupId:   source.SecgroupId,
+				SecgroupName: source.SecgroupName,
+				IsAdmin:      source.IsAdmin,
+			})
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].IsAdmin != merged[j].IsAdmin {
+			return merged[i].IsAdmin
+		}
+		return merged[i].Priority > merged[j].Priority
+	})
+	if defaultRule != nil {
+		merged = append(merged, sEffectiveSecRule{SecurityRule: *defaultRule})
+	}
+	return merged
+}
+
+// secRuleMatchesFlow reports whether rule applies to the given flow.
+// A zero port or empty remote ip leaves that dimension unconstrained
+func secRuleMatchesFlow(rule *secrules.SecurityRule, direction string, protocol string, port int, remoteIp net.IP) bool {
+	if len(direction) > 0 && string(rule.Direction) != direction {
+		return false
+	}
+	if len(protocol) > 0 && rule.Protocol != secrules.PROTO_ANY && rule.Protocol != protocol {
+		return false
+	}
+	if port > 0 && !secRuleMatchesPort(rule, port) {
+		return false
+	}
+	if remoteIp != nil && rule.IPNet != nil && !rule.IPNet.Contains(remoteIp) {
+		return false
+	}
+	return true
+}
+
+func secRuleMatchesPort(rule *secrules.SecurityRule, port int) bool {
+	if len(rule.Ports) > 0 {
+		for _, p := range rule.Ports {
+			if p == port {
+				return true
+			}
+		}
+		return false
+	}
+	if rule.PortStart > 0 && rule.PortEnd > 0 {
+		return port >= rule.PortStart && port <= rule.PortEnd
+	}
+	return true
+}
+
+// evaluateSecRuleFlow returns the first rule matching the flow in
+// evaluation order, nil when no rule matches (implicit deny)
+func evaluateSecRuleFlow(rules []sEffectiveSecRule, direction string, protocol string, port int, remoteIp net.IP) *sEffectiveSecRule {
+	for i := range rules {
+		if secRuleMatchesFlow(&rules[i].SecurityRule, direction, protocol, port, remoteIp) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func (rule *sEffectiveSecRule) toApi() api.ServerEffectiveSecurityRule {
+	return api.ServerEffectiveSecurityRule{
+		Rule:       rule.SecurityRule.String(),
+		Direction:  string(rule.Direction),
+		Priority:   rule.Priority,
+		Action:     string(rule.Action),
+		SecgroupId: rule.SecgroupId,
+		Secgroup:   rule.SecgroupName,
+		IsAdmin:    rule.IsAdmin,
+	}
+}
+
+// getEffectiveSecRules assembles the merged rule set of the guest from
+// its admin secgroup, attached secgroups and the default rule.
+// Secgroups apply to every nic of the guest
+func (self *SGuest) getEffectiveSecRules() []sEffectiveSecRule {
+	sources := make([]sSecRuleSource, 0)
+	if adminSecgrp := self.getAdminSecgroup(); adminSecgrp != nil {
+		sources = append(sources, sSecRuleSource{
+			SecgroupId:   adminSecgrp.Id,
+			SecgroupName: adminSecgrp.Name,
+			IsAdmin:      true,
+			Rules:        adminSecgrp.GetSecRules(""),
+		})
+	}
+	for _, secgrp := range self.GetSecgroups() {
+		sources = append(sources, sSecRuleSource{
+			SecgroupId:   secgrp.Id,
+			SecgroupName: secgrp.Name,
+			Rules:        secgrp.GetSecRules(""),
+		})
+	}
+	var defaultRule *secrules.SecurityRule
+	if rule, err := secrules.ParseSecurityRule(options.Options.DefaultSecurityRules); err == nil {
+		defaultRule = rule
+	} else {
+		log.Errorf("parse default security rules: %v", err)
+	}
+	return mergeEffectiveSecRules(sources, defaultRule)
+}
+
+func (self *SGuest) AllowGetDetailsSecurityRules(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return self.IsOwner(userCred) || db.IsAdminAllowGetSpec(userCred, self, "security-rules")
+}
+
+// GetDetailsSecurityRules 获取虚拟机合并后的生效安全组规则, 可选地评估
+// 一条虚拟流量会命中哪条规则
+func (self *SGuest) GetDetailsSecurityRules(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := api.ServerSecurityRulesInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if len(input.Direction) > 0 && input.Direction != secrules.DIR_IN && input.Direction != secrules.DIR_OUT {
+		return nil, httperrors.NewInputParameterError("invalid direction %q", input.Direction)
+	}
+	var remoteIp net.IP
+	if len(input.RemoteIp) > 0 {
+		remoteIp = net.ParseIP(input.RemoteIp)
+		if remoteIp == nil {
+			return nil, httperrors.NewInputParameterError("invalid remote_ip %s", input.RemoteIp)
+		}
+	}
+	rules := self.getEffectiveSecRules()
+	output := api.ServerSecurityRulesOutput{}
+	for i := range rules {
+		output.Rules = append(output.Rules, rules[i].toApi())
+	}
+	if len(input.Direction) > 0 || len(input.Protocol) > 0 || input.Port > 0 || remoteIp != nil {
+		verdict := api.ServerSecurityRuleVerdict{}
+		if matched := evaluateSecRuleFlow(rules, input.Direction, input.Protocol, input.Port, remoteIp); matched != nil {
+			verdict.Allow = matched.Action == secrules.SecurityRuleAllow
+			matchedRule := matched.toApi()
+			verdict.MatchedRule = &matchedRule
+		}
+		output.Verdict = &verdict
+	}
+	return jsonutils.Marshal(output), nil
+}
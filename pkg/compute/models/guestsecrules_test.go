@@ -0,0 +1,129 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"net"
+	"testing"
+
+	"yunion.io/x/pkg/util/secrules"
+)
+
+func mustRule(t *testing.T, s string, priority int) secrules.SecurityRule {
+	t.Helper()
+	rule, err := secrules.ParseSecurityRule(s)
+	if err != nil {
+		t.Fatalf("parse rule %q: %v", s, err)
+	}
+	rule.Priority = priority
+	return *rule
+}
+
+func TestMergeEffectiveSecRules(t *testing.T) {
+	sources := []sSecRuleSource{
+		{
+			SecgroupId:   "sg-1",
+			SecgroupName: "web",
+			Rules: []secrules.SecurityRule{
+				mustRule(t, "in:allow tcp 80", 50),
+				mustRule(t, "in:allow tcp 22", 90),
+			},
+		},
+		{
+			SecgroupId:   "sg-admin",
+			SecgroupName: "admin",
+			IsAdmin:      true,
+			Rules: []secrules.SecurityRule{
+				mustRule(t, "in:deny tcp 22", 10),
+			},
+		},
+	}
+	defaultRule := mustRule(t, "in:deny any", 1)
+	merged := mergeEffectiveSecRules(sources, &defaultRule)
+	if len(merged) != 4 {
+		t.Fatalf("expect 4 merged rules, got %d", len(merged))
+	}
+	// admin rules evaluate first regardless of priority
+	if !merged[0].IsAdmin || merged[0].SecgroupId != "sg-admin" {
+		t.Errorf("expect admin rule first, got %+v", merged[0])
+	}
+	// normal rules by descending priority
+	if merged[1].Priority != 90 || merged[2].Priority != 50 {
+		t.Errorf("expect normal rules by priority, got %d, %d", merged[1].Priority, merged[2].Priority)
+	}
+	// default rule last without a source
+	last := merged[len(merged)-1]
+	if last.SecgroupId != "" || last.Action != secrules.SecurityRuleDeny {
+		t.Errorf("expect default deny last, got %+v", last)
+	}
+}
+
+func TestEvaluateSecRuleFlow(t *testing.T) {
+	sources := []sSecRuleSource{
+		{
+			SecgroupId:   "sg-admin",
+			SecgroupName: "admin",
+			IsAdmin:      true,
+			Rules: []secrules.SecurityRule{
+				mustRule(t, "in:deny tcp 22", 10),
+			},
+		},
+		{
+			SecgroupId:   "sg-1",
+			SecgroupName: "web",
+			Rules: []secrules.SecurityRule{
+				mustRule(t, "in:allow tcp 22", 90),
+				mustRule(t, "in:allow tcp 80,443", 50),
+				mustRule(t, "in:allow udp 1000-2000", 40),
+			},
+		},
+	}
+	defaultRule := mustRule(t, "in:deny any", 1)
+	merged := mergeEffectiveSecRules(sources, &defaultRule)
+
+	// admin deny wins over the higher-priority normal allow
+	matched := evaluateSecRuleFlow(merged, "in", "tcp", 22, nil)
+	if matched == nil || matched.SecgroupId != "sg-admin" || matched.Action != secrules.SecurityRuleDeny {
+		t.Errorf("expect admin deny for tcp 22, got %+v", matched)
+	}
+	// port list match
+	matched = evaluateSecRuleFlow(merged, "in", "tcp", 443, nil)
+	if matched == nil || matched.SecgroupId != "sg-1" || matched.Action != secrules.SecurityRuleAllow {
+		t.Errorf("expect allow for tcp 443, got %+v", matched)
+	}
+	// port range match
+	matched = evaluateSecRuleFlow(merged, "in", "udp", 1500, nil)
+	if matched == nil || matched.Action != secrules.SecurityRuleAllow {
+		t.Errorf("expect allow for udp 1500, got %+v", matched)
+	}
+	// unmatched port falls through to default deny
+	matched = evaluateSecRuleFlow(merged, "in", "tcp", 8080, nil)
+	if matched == nil || matched.SecgroupId != "" || matched.Action != secrules.SecurityRuleDeny {
+		t.Errorf("expect default deny for tcp 8080, got %+v", matched)
+	}
+}
+
+func TestSecRuleMatchesFlowRemoteIp(t *testing.T) {
+	rule := mustRule(t, "in:allow 10.0.0.0/8 tcp 22", 50)
+	if !secRuleMatchesFlow(&rule, "in", "tcp", 22, net.ParseIP("10.1.2.3")) {
+		t.Errorf("expect match for ip inside the rule network")
+	}
+	if secRuleMatchesFlow(&rule, "in", "tcp", 22, net.ParseIP("192.168.1.1")) {
+		t.Errorf("expect no match for ip outside the rule network")
+	}
+	if secRuleMatchesFlow(&rule, "out", "tcp", 22, nil) {
+		t.Errorf("expect no match for the wrong direction")
+	}
+}
@@ -17,6 +17,7 @@ package models
 import (
 	"context"
 	"database/sql"
+	"strings"
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
@@ -124,9 +125,27 @@ func (manager *SHostResourceBaseManager) ListItemFilter(
 		q = q.Equals(manager.getHostIdFieldName(), hostObj.GetId())
 	}
 	if len(query.HostSN) > 0 {
-		sq := HostManager.Query("id").Equals("sn", query.HostSN).SubQuery()
+		// serial numbers are matched case-insensitively: field engineers
+		// copy them from chassis labels with inconsistent casing
+		hosts := HostManager.Query().SubQuery()
+		sq := hosts.Query(hosts.Field("id")).
+			Filter(sqlchemy.In(sqlchemy.NewFunctionField("sn_lower", "LOWER(%s)", hosts.Field("sn")), hostSnFilterValues(query.HostSN))).
+			SubQuery()
 		q = q.In(manager.getHostIdFieldName(), sq)
 	}
+	if len(query.HostIpmiIp) > 0 {
+		sq := HostManager.Query("id").In("ipmi_ip", query.HostIpmiIp).SubQuery()
+		q = q.In(manager.getHostIdFieldName(), sq)
+	}
+	// every metadata pair narrows the result on its own, yielding AND
+	// semantics across pairs
+	for key, value := range query.HostMetadata {
+		metaQ := db.Metadata.Query("obj_id").Equals("obj_type", HostManager.Keyword()).In("key", hostMetadataKeyVariants(key))
+		if len(value) > 0 {
+			metaQ = metaQ.Equals("value", value)
+		}
+		q = q.In(manager.getHostIdFieldName(), metaQ.Distinct().SubQuery())
+	}
 	subq := HostManager.Query("id").Snapshot()
 	subq, err := manager.SZoneResourceBaseManager.ListItemFilter(ctx, subq, userCred, query.ZonalFilterListInput)
 	if err != nil {
@@ -142,6 +161,33 @@ func (manager *SHostResourceBaseManager) ListItemFilter(
 	return q, nil
 }
 
+// hostSnFilterValues canonicalizes user-supplied serial numbers for the
+// case-insensitive SN match, dropping surrounding whitespace and empty
+// entries
+func hostSnFilterValues(sns []string) []string {
+	values := make([]string, 0, len(sns))
+	for _, sn := range sns {
+		sn = strings.ToLower(strings.TrimSpace(sn))
+		if len(sn) > 0 {
+			values = append(values, sn)
+		}
+	}
+	return values
+}
+
+// hostMetadataKeyVariants maps a user-visible tag key to the storage
+// keys it may be persisted under: a plain key matches both its raw
+// form and the user: prefixed one, while already prefixed keys are
+// taken verbatim
+func hostMetadataKeyVariants(key string) []string {
+	for _, prefix := range []string{db.USER_TAG_PREFIX, db.SYS_TAG_PREFIX, db.CLOUD_TAG_PREFIX} {
+		if strings.HasPrefix(key, prefix) {
+			return []string{key}
+		}
+	}
+	return []string{key, db.USER_TAG_PREFIX + key}
+}
+
 func (manager *SHostResourceBaseManager) QueryDistinctExtraField(q *sqlchemy.SQuery, field string) (*sqlchemy.SQuery, error) {
 	switch field {
 	case "host":
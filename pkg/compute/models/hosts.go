@@ -371,6 +371,13 @@ func (manager *SHostManager) ListItemFilter(
 	if len(query.AccessIp) > 0 {
 		q = q.In("access_ip", query.AccessIp)
 	}
+	if search := normalizeSearchText(query.Search); len(search) > 0 {
+		q = q.Filter(sqlchemy.OR(
+			sqlchemy.Contains(q.Field("name"), search),
+			sqlchemy.Startswith(q.Field("id"), search),
+			sqlchemy.Contains(q.Field("access_ip"), search),
+		))
+	}
 	if len(query.SN) > 0 {
 		q = q.In("sn", query.SN)
 	}
@@ -50,6 +50,20 @@ func (self *SLoadbalancerClusterResourceBase) GetLoadbalancerCluster() *SLoadbal
 	return cluster.(*SLoadbalancerCluster)
 }
 
+// validateChangeCluster checks that a cluster-bound resource in
+// (zoneId, wireId) may be moved onto the target cluster: the move must
+// stay within the zone, and crossing wires is only allowed when the
+// caller forces it
+func validateChangeCluster(zoneId string, wireId string, targetZoneId string, targetWireId string, force bool) error {
+	if zoneId != "" && targetZoneId != zoneId {
+		return httperrors.NewInputParameterError("target cluster is in zone %s, not %s", targetZoneId, zoneId)
+	}
+	if wireId != "" && targetWireId != "" && targetWireId != wireId && !force {
+		return httperrors.NewInputParameterError("target cluster is on another wire %s, use force to override", targetWireId)
+	}
+	return nil
+}
+
 func (self *SLoadbalancerClusterResourceBase) GetExtraDetails(
 	ctx context.Context,
 	userCred mcclient.TokenCredential,
@@ -140,6 +154,17 @@ func (manager *SLoadbalancerClusterResourceBaseManager) ListItemFilter(
 	if err != nil {
 		return nil, errors.Wrap(err, "SWireResourceBaseManager.ListItemFilter")
 	}
+	if query.ClusterHasFreeAgent != nil && *query.ClusterHasFreeAgent {
+		freeIds, err := LoadbalancerClusterManager.clusterIdsWithFreeAgent()
+		if err != nil {
+			return nil, errors.Wrap(err, "clusterIdsWithFreeAgent")
+		}
+		if len(freeIds) > 0 {
+			subq = subq.Filter(sqlchemy.In(subq.Field("id"), freeIds))
+		} else {
+			subq = subq.Filter(sqlchemy.IsNull(subq.Field("id")))
+		}
+	}
 	if subq.IsAltered() {
 		q = q.Filter(sqlchemy.In(q.Field("cluster_id"), subq.SubQuery()))
 	}
@@ -206,6 +231,16 @@ func (manager *SLoadbalancerClusterResourceBaseManager) GetOrderBySubQuery(
 			orders = append(orders, query.OrderByCluster)
 			fields = append(fields, subq.Field("name"))
 		}
+		if db.NeedOrderQuery([]string{query.OrderByClusterLoad}) {
+			agents := LoadbalancerAgentManager.Query().IsNotEmpty("cluster_id").SubQuery()
+			agentCntQ := agents.Query(agents.Field("cluster_id"), sqlchemy.COUNT("agent_count")).GroupBy(agents.Field("cluster_id")).SubQuery()
+			lbs := LoadbalancerManager.Query().IsNotEmpty("cluster_id").SubQuery()
+			lbCntQ := lbs.Query(lbs.Field("cluster_id"), sqlchemy.COUNT("lb_count")).GroupBy(lbs.Field("cluster_id")).SubQuery()
+			q = q.LeftJoin(agentCntQ, sqlchemy.Equals(q.Field("cluster_id"), agentCntQ.Field("cluster_id")))
+			q = q.LeftJoin(lbCntQ, sqlchemy.Equals(q.Field("cluster_id"), lbCntQ.Field("cluster_id")))
+			orders = append(orders, query.OrderByClusterLoad)
+			fields = append(fields, sqlchemy.NewFunctionField("cluster_load", "%s/%s", lbCntQ.Field("lb_count"), agentCntQ.Field("agent_count")))
+		}
 	}
 	return q, orders, fields
 }
@@ -215,5 +250,6 @@ func (manager *SLoadbalancerClusterResourceBaseManager) GetOrderByFields(query a
 	zoneFields := manager.SZoneResourceBaseManager.GetOrderByFields(query.ZonalFilterListInput)
 	fields = append(fields, zoneFields...)
 	fields = append(fields, query.OrderByCluster)
+	fields = append(fields, query.OrderByClusterLoad)
 	return fields
 }
@@ -26,6 +26,7 @@ import (
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/cloudcommon/validators"
+	"yunion.io/x/onecloud/pkg/compute/options"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
 	"yunion.io/x/onecloud/pkg/util/stringutils2"
@@ -304,6 +305,55 @@ func (man *SLoadbalancerClusterManager) findByVrrpRouterIdInZone(zoneId string,
 	return r, nil
 }
 
+type sClusterResourceCount struct {
+	ClusterId string
+	Count     int
+}
+
+func countByClusterId(manager db.IModelManager) (map[string]int, error) {
+	resources := manager.Query().IsNotEmpty("cluster_id").SubQuery()
+	q := resources.Query(resources.Field("cluster_id"), sqlchemy.COUNT("count"))
+	q = q.GroupBy(resources.Field("cluster_id"))
+	counts := []sClusterResourceCount{}
+	err := q.All(&counts)
+	if err != nil {
+		return nil, errors.Wrap(err, "query counts by cluster_id")
+	}
+	ret := make(map[string]int, len(counts))
+	for i := range counts {
+		ret[counts[i].ClusterId] = counts[i].Count
+	}
+	return ret, nil
+}
+
+// clusterIdsWithFreeAgent returns ids of clusters whose loadbalancer
+// count is still below agent count times maxPerAgent. Clusters without
+// any agent have no forwarding capacity and never qualify.
+func clusterIdsWithFreeAgent(agentCounts map[string]int, lbCounts map[string]int, maxPerAgent int) []string {
+	ids := make([]string, 0)
+	for clusterId, agentCount := range agentCounts {
+		if agentCount <= 0 {
+			continue
+		}
+		if lbCounts[clusterId] < agentCount*maxPerAgent {
+			ids = append(ids, clusterId)
+		}
+	}
+	return ids
+}
+
+func (man *SLoadbalancerClusterManager) clusterIdsWithFreeAgent() ([]string, error) {
+	agentCounts, err := countByClusterId(LoadbalancerAgentManager)
+	if err != nil {
+		return nil, errors.Wrap(err, "count agents by cluster")
+	}
+	lbCounts, err := countByClusterId(LoadbalancerManager)
+	if err != nil {
+		return nil, errors.Wrap(err, "count loadbalancers by cluster")
+	}
+	return clusterIdsWithFreeAgent(agentCounts, lbCounts, options.Options.LoadbalancerMaxCountPerAgent), nil
+}
+
 func (man *SLoadbalancerClusterManager) getLoadbalancerAgents(clusterId string) ([]SLoadbalancerAgent, error) {
 	r := []SLoadbalancerAgent{}
 	q := LoadbalancerAgentManager.Query().Equals("cluster_id", clusterId)
@@ -0,0 +1,126 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"sort"
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+func TestClusterIdsWithFreeAgent(t *testing.T) {
+	agentCounts := map[string]int{
+		"cluster-idle":    2,
+		"cluster-busy":    2,
+		"cluster-noagent": 0,
+	}
+	lbCounts := map[string]int{
+		"cluster-idle":   3,
+		"cluster-busy":   40,
+		"cluster-orphan": 5,
+	}
+	got := clusterIdsWithFreeAgent(agentCounts, lbCounts, 20)
+	sort.Strings(got)
+	if len(got) != 1 || got[0] != "cluster-idle" {
+		t.Errorf("clusterIdsWithFreeAgent = %v, want [cluster-idle]", got)
+	}
+
+	// lowering per-agent capacity fills the idle cluster too
+	got = clusterIdsWithFreeAgent(agentCounts, lbCounts, 1)
+	if len(got) != 0 {
+		t.Errorf("clusterIdsWithFreeAgent with maxPerAgent=1 = %v, want empty", got)
+	}
+}
+
+func TestClusterOrderByFields(t *testing.T) {
+	man := &SLoadbalancerClusterResourceBaseManager{}
+	query := api.LoadbalancerClusterFilterListInput{
+		OrderByClusterLoad: "asc",
+	}
+	fields := man.GetOrderByFields(query)
+	found := false
+	for _, f := range fields {
+		if f == "asc" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("GetOrderByFields does not include order_by_cluster_load value: %v", fields)
+	}
+}
+
+func TestValidateChangeCluster(t *testing.T) {
+	cases := []struct {
+		name      string
+		zoneId    string
+		wireId    string
+		targetZid string
+		targetWid string
+		force     bool
+		wantErr   bool
+	}{
+		{
+			name:      "same zone same wire",
+			zoneId:    "z1",
+			wireId:    "w1",
+			targetZid: "z1",
+			targetWid: "w1",
+		},
+		{
+			name:      "cross zone rejected",
+			zoneId:    "z1",
+			wireId:    "w1",
+			targetZid: "z2",
+			targetWid: "w1",
+			wantErr:   true,
+		},
+		{
+			name:      "cross zone rejected even with force",
+			zoneId:    "z1",
+			targetZid: "z2",
+			force:     true,
+			wantErr:   true,
+		},
+		{
+			name:      "cross wire rejected without force",
+			zoneId:    "z1",
+			wireId:    "w1",
+			targetZid: "z1",
+			targetWid: "w2",
+			wantErr:   true,
+		},
+		{
+			name:      "cross wire allowed with force",
+			zoneId:    "z1",
+			wireId:    "w1",
+			targetZid: "z1",
+			targetWid: "w2",
+			force:     true,
+		},
+		{
+			name:      "no current wire moves freely",
+			zoneId:    "z1",
+			targetZid: "z1",
+			targetWid: "w2",
+		},
+	}
+	for _, c := range cases {
+		err := validateChangeCluster(c.zoneId, c.wireId, c.targetZid, c.targetWid, c.force)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: validateChangeCluster error = %v, wantErr %v", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -16,6 +16,7 @@ package models
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"net"
 	"strings"
@@ -335,6 +336,69 @@ func (lb *SLoadbalancer) StartLoadBalancerSyncstatusTask(ctx context.Context, us
 	return nil
 }
 
+func (lb *SLoadbalancer) AllowPerformChangeCluster(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, lb, "change-cluster")
+}
+
+// PerformChangeCluster moves the loadbalancer onto another cluster, so
+// a cluster being drained can hand its instances over. The move must
+// stay within the zone; crossing wires requires force. The config is
+// re-deployed to the agents of the new cluster afterwards.
+func (lb *SLoadbalancer) PerformChangeCluster(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input api.LoadbalancerChangeClusterInput) (jsonutils.JSONObject, error) {
+	if len(input.Cluster) == 0 {
+		return nil, httperrors.NewMissingParameterError("cluster")
+	}
+	clusterObj, err := LoadbalancerClusterManager.FetchByIdOrName(userCred, input.Cluster)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, httperrors.NewResourceNotFoundError2(LoadbalancerClusterManager.Keyword(), input.Cluster)
+		}
+		return nil, errors.Wrap(err, "LoadbalancerClusterManager.FetchByIdOrName")
+	}
+	cluster := clusterObj.(*SLoadbalancerCluster)
+
+	lockman.LockObject(ctx, lb)
+	defer lockman.ReleaseObject(ctx, lb)
+
+	if lb.ClusterId == cluster.Id {
+		return nil, nil
+	}
+	zoneId := lb.ZoneId
+	wireId := ""
+	if len(lb.ClusterId) > 0 {
+		if current := lb.GetLoadbalancerCluster(); current != nil {
+			if zoneId == "" {
+				zoneId = current.ZoneId
+			}
+			wireId = current.WireId
+		}
+	}
+	if err := validateChangeCluster(zoneId, wireId, cluster.ZoneId, cluster.WireId, input.Force); err != nil {
+		return nil, err
+	}
+	diff, err := db.Update(lb, func() error {
+		lb.ClusterId = cluster.Id
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "update cluster_id")
+	}
+	db.OpsLog.LogEvent(lb, db.ACT_UPDATE, diff, userCred)
+	return nil, lb.StartLoadBalancerSyncstatusTask(ctx, userCred, "")
+}
+
+func (lb *SLoadbalancer) GetLoadbalancerCluster() *SLoadbalancerCluster {
+	if len(lb.ClusterId) == 0 {
+		return nil
+	}
+	cluster, err := LoadbalancerClusterManager.FetchById(lb.ClusterId)
+	if err != nil {
+		log.Errorf("failed to find LoadbalancerCluster %s error: %v", lb.ClusterId, err)
+		return nil
+	}
+	return cluster.(*SLoadbalancerCluster)
+}
+
 func (lb *SLoadbalancer) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
 	lb.SVirtualResourceBase.PostCreate(ctx, userCred, ownerId, query, data)
 	// NOTE lb.Id will only be available after BeforeInsert happens
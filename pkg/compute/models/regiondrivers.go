@@ -20,6 +20,7 @@ import (
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
+	"yunion.io/x/pkg/util/secrules"
 
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
@@ -86,6 +87,15 @@ type IRegionDriver interface {
 	RequestDeleteLoadbalancerListenerRule(ctx context.Context, userCred mcclient.TokenCredential, lbr *SLoadbalancerListenerRule, task taskman.ITask) error
 
 	ValidateCreateVpcData(ctx context.Context, userCred mcclient.TokenCredential, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error)
+	// IsSupportRoutePriority returns whether the provider honors
+	// priorities on static routes
+	IsSupportRoutePriority() bool
+	// GetRoutePriorityRange returns the valid priority range of
+	// static routes on the provider
+	GetRoutePriorityRange() (int, int)
+	// RequestApplyRouteTable pushes the local route plan of a managed
+	// route table to the cloud
+	RequestApplyRouteTable(ctx context.Context, userCred mcclient.TokenCredential, rt *SRouteTable, task taskman.ITask) error
 	ValidateCreateEipData(ctx context.Context, userCred mcclient.TokenCredential, input *api.SElasticipCreateInput) error
 	RequestCreateVpc(ctx context.Context, userCred mcclient.TokenCredential, region *SCloudregion, vpc *SVpc, task taskman.ITask) error
 	RequestDeleteVpc(ctx context.Context, userCred mcclient.TokenCredential, region *SCloudregion, vpc *SVpc, task taskman.ITask) error
@@ -116,6 +126,9 @@ type IRegionDriver interface {
 	RequestUnBindIPFromNatgateway(ctx context.Context, task taskman.ITask, nat INatHelper, natgateway *SNatGateway) error
 	BindIPToNatgatewayRollback(ctx context.Context, eipId string) error
 
+	// DiffSecgroupRules explains what a sync of localRules to the
+	// provider would change against remoteRules
+	DiffSecgroupRules(localRules []secrules.SecurityRule, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff
 	RequestCacheSecurityGroup(ctx context.Context, userCred mcclient.TokenCredential, region *SCloudregion, vpc *SVpc, secgroup *SSecurityGroup, classic bool, task taskman.ITask) error
 	RequestSyncSecurityGroup(ctx context.Context, userCred mcclient.TokenCredential, vpcId string, vpc *SVpc, secgroup *SSecurityGroup) (string, error)
 	IsSupportClassicSecurityGroup() bool
@@ -16,9 +16,14 @@ package models
 
 import (
 	"context"
+	"encoding/csv"
+	"fmt"
 	"net"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
@@ -31,10 +36,12 @@ import (
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
 	"yunion.io/x/onecloud/pkg/cloudcommon/validators"
 	"yunion.io/x/onecloud/pkg/cloudprovider"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/logclient"
 	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
 
@@ -43,6 +50,16 @@ type SRoute struct {
 	Cidr        string
 	NextHopType string
 	NextHopId   string
+	// Priority orders routes when multiple routes match, 0 means the
+	// provider default. Only meaningful on providers with route
+	// priority support
+	Priority int
+	// Description is a local annotation the user may attach to a
+	// route; it is never pushed to the cloud and survives sync
+	Description string
+	// Status is available, blackhole or unknown; refreshed from the
+	// cloud on sync, or from next hop existence for local tables
+	Status string
 }
 
 func (route *SRoute) Validate(data *jsonutils.JSONDict) error {
@@ -85,12 +102,52 @@ func (routes *SRoutes) Validate(data *jsonutils.JSONDict) error {
 		if err := route.Validate(data); err != nil {
 			return err
 		}
-		if _, ok := found[route.Cidr]; ok {
+		key := fmt.Sprintf("%s:%d", route.Cidr, route.Priority)
+		if _, ok := found[key]; ok {
 			// error so that the user has a chance to deal with comments
 			return httperrors.NewInputParameterError("duplicate route cidr %s", route.Cidr)
 		}
 		// TODO aliyun: check overlap with System type route
-		found[route.Cidr] = struct{}{}
+		found[key] = struct{}{}
+	}
+	routes.Sort()
+	return nil
+}
+
+// Sort orders routes by priority then cidr so the serialized routes
+// column is deterministic for diffing
+func (routes SRoutes) Sort() {
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Priority != routes[j].Priority {
+			return routes[i].Priority < routes[j].Priority
+		}
+		return routes[i].Cidr < routes[j].Cidr
+	})
+}
+
+// validateRoutesPriority checks route priorities against the provider
+// capability reported by the region driver. Providers without priority
+// support require zero priorities and unique cidrs; providers with
+// support require priorities within [minPri, maxPri], 0 keeping the
+// provider default
+func validateRoutesPriority(routes SRoutes, supportPriority bool, minPri, maxPri int) error {
+	if !supportPriority {
+		cidrs := map[string]struct{}{}
+		for _, route := range routes {
+			if route.Priority != 0 {
+				return httperrors.NewInputParameterError("route priority is not supported by the provider")
+			}
+			if _, ok := cidrs[route.Cidr]; ok {
+				return httperrors.NewInputParameterError("duplicate route cidr %s", route.Cidr)
+			}
+			cidrs[route.Cidr] = struct{}{}
+		}
+		return nil
+	}
+	for _, route := range routes {
+		if route.Priority != 0 && (route.Priority < minPri || route.Priority > maxPri) {
+			return httperrors.NewInputParameterError("route priority %d out of range [%d, %d]", route.Priority, minPri, maxPri)
+		}
 	}
 	return nil
 }
@@ -125,6 +182,8 @@ type SRouteTable struct {
 
 	Type   string   `width:"16" charset:"ascii" nullable:"false" list:"user"`
 	Routes *SRoutes `list:"user" update:"user" create:"required"`
+	// 最近一次从云上同步的时间
+	LastSync time.Time `nullable:"true" list:"user" json:"last_sync"`
 }
 
 // VPC虚拟路由表列表
@@ -155,9 +214,208 @@ func (man *SRouteTableManager) ListItemFilter(
 		q = q.In("type", query.Type)
 	}
 
+	if len(query.Cidr) > 0 {
+		if _, _, err := parseRouteTableCidrFilter(query.Cidr, query.CidrMatch); err != nil {
+			return nil, err
+		}
+		if query.CidrMatch == api.ROUTE_TABLE_CIDR_MATCH_EXACT {
+			// cheap pre-filter on the serialized routes column; the
+			// exact containment check runs in CustomizeFilterList
+			q = q.Contains("routes", query.Cidr)
+		}
+	}
+
+	if query.HasBlackholeRoute != nil && *query.HasBlackholeRoute {
+		// cheap pre-filter on the serialized routes column; the
+		// per-route check runs in CustomizeFilterList
+		q = q.Contains("routes", api.ROUTE_STATUS_BLACKHOLE)
+	}
+
 	return q, nil
 }
 
+// parseRouteTableCidrFilter validates the cidr/cidr_match query pair.
+// A bare IP is treated as a host route.
+func parseRouteTableCidrFilter(cidr string, matchMode string) (*net.IPNet, bool, error) {
+	switch matchMode {
+	case "", api.ROUTE_TABLE_CIDR_MATCH_CONTAINS, api.ROUTE_TABLE_CIDR_MATCH_EXACT:
+	default:
+		return nil, false, httperrors.NewInputParameterError("invalid cidr_match %q", matchMode)
+	}
+	if strings.Index(cidr, "/") < 0 {
+		cidr = cidr + "/32"
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, false, httperrors.NewInputParameterError("invalid cidr %s", cidr)
+	}
+	return ipNet, matchMode == api.ROUTE_TABLE_CIDR_MATCH_EXACT, nil
+}
+
+// routeCidrCovers reports whether routeCidr is a network containing
+// the whole of queryNet
+func routeCidrCovers(routeCidr string, queryNet *net.IPNet) bool {
+	if strings.Index(routeCidr, "/") < 0 {
+		routeCidr = routeCidr + "/32"
+	}
+	_, routeNet, err := net.ParseCIDR(routeCidr)
+	if err != nil {
+		return false
+	}
+	routeOnes, _ := routeNet.Mask.Size()
+	queryOnes, _ := queryNet.Mask.Size()
+	return routeOnes <= queryOnes && routeNet.Contains(queryNet.IP)
+}
+
+// matchRoutesCidr reports whether any route matches queryNet, either
+// exactly or by covering it
+func matchRoutesCidr(routes SRoutes, queryNet *net.IPNet, exact bool) bool {
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		if exact {
+			if route.Cidr == queryNet.String() {
+				return true
+			}
+		} else if routeCidrCovers(route.Cidr, queryNet) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRouteStatus maps a provider reported route status onto the
+// model status set. Providers without status support report empty,
+// which counts as available.
+func normalizeRouteStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "", api.ROUTE_STATUS_AVAILABLE:
+		return api.ROUTE_STATUS_AVAILABLE
+	case api.ROUTE_STATUS_BLACKHOLE:
+		return api.ROUTE_STATUS_BLACKHOLE
+	default:
+		return api.ROUTE_STATUS_UNKNOWN
+	}
+}
+
+// routesHaveBlackhole reports whether any route is a blackhole
+func routesHaveBlackhole(routes SRoutes) bool {
+	for _, route := range routes {
+		if route != nil && route.Status == api.ROUTE_STATUS_BLACKHOLE {
+			return true
+		}
+	}
+	return false
+}
+
+// countBlackholeRoutes tallies routes in blackhole status
+func countBlackholeRoutes(routes SRoutes) int {
+	count := 0
+	for _, route := range routes {
+		if route != nil && route.Status == api.ROUTE_STATUS_BLACKHOLE {
+			count += 1
+		}
+	}
+	return count
+}
+
+// newlyBlackholedRoutes returns the cidrs of routes of next that are
+// blackhole while the matching route of prev, on (cidr, next_hop_id),
+// was not. Routes new to the table count as transitions too.
+func newlyBlackholedRoutes(prev SRoutes, next SRoutes) []string {
+	prevStatus := make(map[string]string, len(prev))
+	for _, route := range prev {
+		if route == nil {
+			continue
+		}
+		prevStatus[route.Cidr+"|"+route.NextHopId] = route.Status
+	}
+	cidrs := make([]string, 0)
+	for _, route := range next {
+		if route == nil || route.Status != api.ROUTE_STATUS_BLACKHOLE {
+			continue
+		}
+		if prevStatus[route.Cidr+"|"+route.NextHopId] != api.ROUTE_STATUS_BLACKHOLE {
+			cidrs = append(cidrs, route.Cidr)
+		}
+	}
+	return cidrs
+}
+
+// countRoutes tallies routes overall and by next hop type, skipping
+// system routes unless includeSystem
+func countRoutes(routes SRoutes, includeSystem bool) (int, map[string]int) {
+	total := 0
+	byType := make(map[string]int)
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		if !includeSystem && route.Type == "system" {
+			continue
+		}
+		total += 1
+		byType[route.NextHopType] += 1
+	}
+	return total, byType
+}
+
+func (man *SRouteTableManager) CustomizeFilterList(ctx context.Context, q *sqlchemy.SQuery, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (*db.CustomizeListFilters, error) {
+	filters, err := man.SVirtualResourceBaseManager.CustomizeFilterList(ctx, q, userCred, query)
+	if err != nil {
+		return nil, err
+	}
+	if query.Contains("is_empty") {
+		isEmpty, _ := query.Bool("is_empty")
+		emptyF := func(obj jsonutils.JSONObject) (bool, error) {
+			routes := SRoutes{}
+			if routesObj, err := obj.Get("routes"); err == nil {
+				if err := routesObj.Unmarshal(&routes); err != nil {
+					return false, errors.Wrap(err, "unmarshal routes")
+				}
+			}
+			count, _ := countRoutes(routes, false)
+			return (count == 0) == isEmpty, nil
+		}
+		filters.Append(emptyF)
+	}
+	if query.Contains("has_blackhole_route") {
+		hasBlackhole, _ := query.Bool("has_blackhole_route")
+		blackholeF := func(obj jsonutils.JSONObject) (bool, error) {
+			routes := SRoutes{}
+			if routesObj, err := obj.Get("routes"); err == nil {
+				if err := routesObj.Unmarshal(&routes); err != nil {
+					return false, errors.Wrap(err, "unmarshal routes")
+				}
+			}
+			return routesHaveBlackhole(routes) == hasBlackhole, nil
+		}
+		filters.Append(blackholeF)
+	}
+	cidr, _ := query.GetString("cidr")
+	if len(cidr) > 0 {
+		matchMode, _ := query.GetString("cidr_match")
+		queryNet, exact, err := parseRouteTableCidrFilter(cidr, matchMode)
+		if err != nil {
+			return nil, err
+		}
+		cidrF := func(obj jsonutils.JSONObject) (bool, error) {
+			routes := SRoutes{}
+			routesObj, err := obj.Get("routes")
+			if err != nil {
+				return false, nil
+			}
+			if err := routesObj.Unmarshal(&routes); err != nil {
+				return false, errors.Wrap(err, "unmarshal routes")
+			}
+			return matchRoutesCidr(routes, queryNet, exact), nil
+		}
+		filters.Append(cidrF)
+	}
+	return filters, nil
+}
+
 func (man *SRouteTableManager) OrderByExtraFields(
 	ctx context.Context,
 	q *sqlchemy.SQuery,
@@ -194,7 +452,7 @@ func (man *SRouteTableManager) QueryDistinctExtraField(q *sqlchemy.SQuery, field
 	return q, httperrors.ErrNotFound
 }
 
-func (man *SRouteTableManager) validateRoutes(data *jsonutils.JSONDict, update bool) (*jsonutils.JSONDict, error) {
+func (man *SRouteTableManager) validateRoutes(data *jsonutils.JSONDict, update bool, driver IRegionDriver) (*jsonutils.JSONDict, error) {
 	routes := SRoutes{}
 	routesV := validators.NewStructValidator("routes", &routes)
 	if update {
@@ -204,14 +462,16 @@ func (man *SRouteTableManager) validateRoutes(data *jsonutils.JSONDict, update b
 	if err != nil {
 		return nil, err
 	}
+	if driver != nil {
+		minPri, maxPri := driver.GetRoutePriorityRange()
+		if err := validateRoutesPriority(routes, driver.IsSupportRoutePriority(), minPri, maxPri); err != nil {
+			return nil, err
+		}
+	}
 	return data, nil
 }
 
 func (man *SRouteTableManager) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
-	data, err := man.validateRoutes(data, false)
-	if err != nil {
-		return nil, err
-	}
 	vpcV := validators.NewModelIdOrNameValidator("vpc", "vpc", ownerId)
 	if err := vpcV.Validate(data); err != nil {
 		return nil, err
@@ -221,6 +481,10 @@ func (man *SRouteTableManager) ValidateCreateData(ctx context.Context, userCred
 	if err != nil {
 		return nil, httperrors.NewConflictError("failed getting region of vpc %s(%s)", vpc.Name, vpc.Id)
 	}
+	data, err = man.validateRoutes(data, false, cloudregion.GetDriver())
+	if err != nil {
+		return nil, err
+	}
 	data.Set("cloudregion_id", jsonutils.NewString(cloudregion.Id))
 
 	input := apis.VirtualResourceCreateInput{}
@@ -260,30 +524,168 @@ func (rt *SRouteTable) AllowPerformPurge(ctx context.Context, userCred mcclient.
 	return db.IsAdminAllowPerform(userCred, rt, "purge")
 }
 
+// validateRouteTablePurgeProvider rejects purging route tables that
+// still belong to an enabled cloud provider
+func validateRouteTablePurgeProvider(provider *SCloudprovider) error {
+	if provider != nil && provider.GetEnabled() {
+		return httperrors.NewInvalidStatusError("Cannot purge route_table on enabled cloud provider")
+	}
+	return nil
+}
+
 func (rt *SRouteTable) PerformPurge(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
 	err := rt.ValidateDeleteCondition(ctx)
 	if err != nil {
 		return nil, err
 	}
-	provider := rt.GetCloudprovider()
-	if provider != nil {
-		if provider.GetEnabled() {
-			return nil, httperrors.NewInvalidStatusError("Cannot purge route_table on enabled cloud provider")
-		}
+	if err := validateRouteTablePurgeProvider(rt.GetCloudprovider()); err != nil {
+		return nil, err
 	}
 	err = rt.RealDelete(ctx, userCred)
 	return nil, err
 }
 
+// purge removes one route table under a per-object lock, with the same
+// delete validation as the single object purge
+func (rt *SRouteTable) purge(ctx context.Context, userCred mcclient.TokenCredential) error {
+	lockman.LockObject(ctx, rt)
+	defer lockman.ReleaseObject(ctx, rt)
+
+	if err := rt.ValidateDeleteCondition(ctx); err != nil {
+		return err
+	}
+	if err := rt.RealDelete(ctx, userCred); err != nil {
+		return err
+	}
+	db.OpsLog.LogEvent(rt, db.ACT_DELETE, rt.GetShortDesc(ctx), userCred)
+	return nil
+}
+
+// sRouteTablePurgeProvider is the subset of cloud provider state the
+// batch purge decision needs
+type sRouteTablePurgeProvider struct {
+	Id      string
+	Name    string
+	Enabled bool
+}
+
+// partitionRouteTablePurgeProviders splits providers into those whose
+// route tables may be purged and skip reasons for the enabled ones
+func partitionRouteTablePurgeProviders(providers []sRouteTablePurgeProvider) ([]string, []string) {
+	managerIds := make([]string, 0, len(providers))
+	skipped := make([]string, 0)
+	for _, provider := range providers {
+		if provider.Enabled {
+			skipped = append(skipped, fmt.Sprintf("cloud provider %s is enabled", provider.Name))
+			continue
+		}
+		managerIds = append(managerIds, provider.Id)
+	}
+	return managerIds, skipped
+}
+
+func (man *SRouteTableManager) fetchPurgeProviders(input api.RouteTablePurgeInput) ([]SCloudprovider, error) {
+	if len(input.ManagerId) == 0 && len(input.CloudaccountId) == 0 {
+		return nil, httperrors.NewMissingParameterError("manager_id")
+	}
+	q := CloudproviderManager.Query()
+	if len(input.ManagerId) > 0 {
+		q = q.Equals("id", input.ManagerId)
+	} else {
+		q = q.Equals("cloudaccount_id", input.CloudaccountId)
+	}
+	providers := make([]SCloudprovider, 0)
+	if err := db.FetchModelObjects(CloudproviderManager, q, &providers); err != nil {
+		return nil, errors.Wrap(err, "fetch cloud providers")
+	}
+	if len(providers) == 0 {
+		return nil, httperrors.NewResourceNotFoundError("cloud provider not found")
+	}
+	return providers, nil
+}
+
+func (man *SRouteTableManager) fetchRouteTablesByManager(managerId string) ([]SRouteTable, error) {
+	routeTables := make([]SRouteTable, 0)
+	vpcs := VpcManager.Query().SubQuery()
+	q := man.Query()
+	q = q.Join(vpcs, sqlchemy.Equals(q.Field("vpc_id"), vpcs.Field("id"))).
+		Filter(sqlchemy.Equals(vpcs.Field("manager_id"), managerId))
+	if err := db.FetchModelObjects(man, q, &routeTables); err != nil {
+		return nil, errors.Wrap(err, "fetch route tables")
+	}
+	return routeTables, nil
+}
+
+func (man *SRouteTableManager) AllowPerformPurge(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowClassPerform(userCred, man, "purge")
+}
+
+// PerformPurge batch purges route tables of decommissioned cloud
+// providers, either one provider via manager_id or every disabled
+// provider of an account via cloudaccount_id
+func (man *SRouteTableManager) PerformPurge(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := api.RouteTablePurgeInput{}
+	if err := data.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal purge input: %s", err)
+	}
+	providers, err := man.fetchPurgeProviders(input)
+	if err != nil {
+		return nil, err
+	}
+	providerStates := make([]sRouteTablePurgeProvider, len(providers))
+	providerById := make(map[string]*SCloudprovider, len(providers))
+	for i := range providers {
+		providerStates[i] = sRouteTablePurgeProvider{
+			Id:      providers[i].Id,
+			Name:    providers[i].Name,
+			Enabled: providers[i].GetEnabled(),
+		}
+		providerById[providers[i].Id] = &providers[i]
+	}
+	managerIds, skipped := partitionRouteTablePurgeProviders(providerStates)
+	output := api.RouteTablePurgeOutput{Skipped: skipped}
+	for _, managerId := range managerIds {
+		routeTables, err := man.fetchRouteTablesByManager(managerId)
+		if err != nil {
+			return nil, err
+		}
+		purged := 0
+		for i := range routeTables {
+			rt := &routeTables[i]
+			if err := rt.purge(ctx, userCred); err != nil {
+				output.Skipped = append(output.Skipped, fmt.Sprintf("route table %s: %v", rt.Name, err))
+				continue
+			}
+			purged += 1
+		}
+		output.Purged += purged
+		logclient.AddActionLogWithContext(ctx, providerById[managerId], logclient.ACT_DELETE,
+			fmt.Sprintf("purged %d route tables", purged), userCred, true)
+	}
+	return jsonutils.Marshal(output).(*jsonutils.JSONDict), nil
+}
+
 func (rt *SRouteTable) RealDelete(ctx context.Context, userCred mcclient.TokenCredential) error {
 	return rt.SVirtualResourceBase.Delete(ctx, userCred)
 }
 
 func (rt *SRouteTable) ValidateUpdateData(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
-	data, err := RouteTableManager.validateRoutes(data, true)
+	cloudregion, err := rt.getRegion()
+	if err != nil {
+		return nil, errors.Wrap(err, "getRegion")
+	}
+	data, err = RouteTableManager.validateRoutes(data, true, cloudregion.GetDriver())
 	if err != nil {
 		return nil, err
 	}
+	if routesObj, _ := data.Get("routes"); routesObj != nil && rt.Routes != nil {
+		routes := SRoutes{}
+		if err := routesObj.Unmarshal(&routes); err != nil {
+			return nil, httperrors.NewInputParameterError("unmarshal routes: %s", err)
+		}
+		merged := mergeRouteDescriptions(*rt.Routes, routes)
+		data.Set("routes", jsonutils.Marshal(merged))
+	}
 	return rt.SVirtualResourceBase.ValidateUpdateData(ctx, userCred, query, data)
 }
 
@@ -314,7 +716,7 @@ func (rt *SRouteTable) PerformAddRoutes(ctx context.Context, userCred mcclient.T
 		for _, add := range adds {
 			found := false
 			for _, route := range routes {
-				if route.Cidr == add.Cidr {
+				if route.Cidr == add.Cidr && route.Priority == add.Priority {
 					found = true
 					break
 				}
@@ -324,6 +726,14 @@ func (rt *SRouteTable) PerformAddRoutes(ctx context.Context, userCred mcclient.T
 			}
 		}
 	}
+	if cloudregion, err := rt.getRegion(); err == nil {
+		driver := cloudregion.GetDriver()
+		minPri, maxPri := driver.GetRoutePriorityRange()
+		if err := validateRoutesPriority(routes, driver.IsSupportRoutePriority(), minPri, maxPri); err != nil {
+			return nil, err
+		}
+	}
+	routes.Sort()
 	_, err := db.Update(rt, func() error {
 		rt.Routes = &routes
 		return nil
@@ -386,11 +796,19 @@ func (manager *SRouteTableManager) FetchCustomizeColumns(
 	virtRows := manager.SVirtualResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
 	vpcRows := manager.SVpcResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
 
+	includeSystem := jsonutils.QueryBoolean(query, "include_system", false)
 	for i := range rows {
 		rows[i] = api.RouteTableDetails{
 			VirtualResourceDetails: virtRows[i],
 			VpcResourceInfo:        vpcRows[i],
 		}
+		rt := objs[i].(*SRouteTable)
+		routes := SRoutes{}
+		if rt.Routes != nil {
+			routes = *rt.Routes
+		}
+		rows[i].RouteCount, rows[i].NextHopTypeCount = countRoutes(routes, includeSystem)
+		rows[i].BlackholeRouteCount = countBlackholeRoutes(routes)
 	}
 
 	return rows
@@ -453,22 +871,57 @@ func (man *SRouteTableManager) SyncRouteTables(ctx context.Context, userCred mcc
 	return localRouteTables, remoteRouteTables, syncResult
 }
 
-func (man *SRouteTableManager) newRouteTableFromCloud(userCred mcclient.TokenCredential, vpc *SVpc, cloudRouteTable cloudprovider.ICloudRouteTable) (*SRouteTable, error) {
-	routes := SRoutes{}
-	{
-		cloudRoutes, err := cloudRouteTable.GetIRoutes()
-		if err != nil {
-			return nil, err
+// mergeRouteDescriptions carries the local descriptions of prev onto
+// next, matching routes on (cidr, next_hop_id), so a refresh from the
+// cloud does not drop user annotations. Routes of next that already
+// carry a description keep their own.
+func mergeRouteDescriptions(prev SRoutes, next SRoutes) SRoutes {
+	if len(prev) == 0 {
+		return next
+	}
+	descs := make(map[string]string, len(prev))
+	for _, route := range prev {
+		if route == nil || route.Description == "" {
+			continue
 		}
-		for _, cloudRoute := range cloudRoutes {
-			route := &SRoute{
-				Type:        cloudRoute.GetType(),
-				Cidr:        cloudRoute.GetCidr(),
-				NextHopType: cloudRoute.GetNextHopType(),
-				NextHopId:   cloudRoute.GetNextHop(),
-			}
-			routes = append(routes, route)
+		descs[route.Cidr+"|"+route.NextHopId] = route.Description
+	}
+	for _, route := range next {
+		if route == nil || route.Description != "" {
+			continue
+		}
+		if desc, ok := descs[route.Cidr+"|"+route.NextHopId]; ok {
+			route.Description = desc
+		}
+	}
+	return next
+}
+
+func routesFromCloud(cloudRouteTable cloudprovider.ICloudRouteTable) (SRoutes, error) {
+	routes := SRoutes{}
+	cloudRoutes, err := cloudRouteTable.GetIRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, cloudRoute := range cloudRoutes {
+		route := &SRoute{
+			Type:        cloudRoute.GetType(),
+			Cidr:        cloudRoute.GetCidr(),
+			NextHopType: cloudRoute.GetNextHopType(),
+			NextHopId:   cloudRoute.GetNextHop(),
+			Priority:    cloudRoute.GetPriority(),
+			Status:      normalizeRouteStatus(cloudRoute.GetStatus()),
 		}
+		routes = append(routes, route)
+	}
+	routes.Sort()
+	return routes, nil
+}
+
+func (man *SRouteTableManager) newRouteTableFromCloud(userCred mcclient.TokenCredential, vpc *SVpc, cloudRouteTable cloudprovider.ICloudRouteTable) (*SRouteTable, error) {
+	routes, err := routesFromCloud(cloudRouteTable)
+	if err != nil {
+		return nil, err
 	}
 	routeTable := &SRouteTable{
 		Type:   cloudRouteTable.GetType(),
@@ -532,6 +985,18 @@ func (self *SRouteTable) SyncWithCloudRouteTable(ctx context.Context, userCred m
 	if err != nil {
 		return err
 	}
+	var prevRoutes SRoutes
+	if self.Routes != nil {
+		prevRoutes = *self.Routes
+	}
+	if self.Routes != nil && routeTable.Routes != nil {
+		merged := mergeRouteDescriptions(*self.Routes, *routeTable.Routes)
+		routeTable.Routes = &merged
+	}
+	var blackholed []string
+	if routeTable.Routes != nil {
+		blackholed = newlyBlackholedRoutes(prevRoutes, *routeTable.Routes)
+	}
 	diff, err := db.UpdateWithLock(ctx, self, func() error {
 		// self.CloudregionId = routeTable.CloudregionId
 		self.VpcId = vpc.Id
@@ -543,9 +1008,459 @@ func (self *SRouteTable) SyncWithCloudRouteTable(ctx context.Context, userCred m
 		return err
 	}
 	db.OpsLog.LogSyncUpdate(self, diff, userCred)
+	if len(blackholed) > 0 {
+		db.OpsLog.LogEvent(self, db.ACT_ROUTE_BLACKHOLE,
+			fmt.Sprintf("routes to %s turned blackhole", strings.Join(blackholed, ", ")), userCred)
+	}
 	return nil
 }
 
+func (rt *SRouteTable) AllowPerformSyncstatus(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return rt.IsOwner(userCred) || db.IsAdminAllowPerform(userCred, rt, "syncstatus")
+}
+
+// PerformSyncstatus refreshes a single route table from the cloud
+// provider without requiring a full VPC sync
+func (rt *SRouteTable) PerformSyncstatus(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	if len(rt.ExternalId) == 0 {
+		// local tables have no cloud side; recompute route status
+		// from next hop resource existence instead
+		return nil, rt.refreshLocalRouteStatus(ctx, userCred)
+	}
+	vpc, err := rt.getVpc()
+	if err != nil {
+		return nil, errors.Wrap(err, "getVpc")
+	}
+	prevStatus := rt.Status
+	rt.SetStatus(userCred, api.ROUTE_TABLE_SYNCING, "perform_syncstatus")
+	err = rt.syncWithCloud(ctx, userCred, vpc)
+	if err != nil {
+		rt.SetStatus(userCred, prevStatus, "sync fail")
+		return nil, err
+	}
+	rt.SetStatus(userCred, api.ROUTE_TABLE_AVAILABLE, "sync complete")
+	return nil, nil
+}
+
+func (rt *SRouteTable) syncWithCloud(ctx context.Context, userCred mcclient.TokenCredential, vpc *SVpc) error {
+	ivpc, err := vpc.GetIVpc()
+	if err != nil {
+		return errors.Wrap(err, "GetIVpc")
+	}
+	iRouteTables, err := ivpc.GetIRouteTables()
+	if err != nil {
+		return errors.Wrap(err, "GetIRouteTables")
+	}
+	iRouteTable := findICloudRouteTable(iRouteTables, rt.ExternalId)
+	if iRouteTable == nil {
+		return httperrors.NewResourceNotFoundError("route table %s not found on cloud", rt.ExternalId)
+	}
+	err = rt.SyncWithCloudRouteTable(ctx, userCred, vpc, iRouteTable)
+	if err != nil {
+		return errors.Wrap(err, "SyncWithCloudRouteTable")
+	}
+	_, err = db.Update(rt, func() error {
+		rt.LastSync = time.Now().UTC()
+		return nil
+	})
+	return err
+}
+
+func findICloudRouteTable(tables []cloudprovider.ICloudRouteTable, externalId string) cloudprovider.ICloudRouteTable {
+	for i := range tables {
+		if tables[i].GetGlobalId() == externalId {
+			return tables[i]
+		}
+	}
+	return nil
+}
+
+// computeLocalRouteStatus recomputes the status of routes of a locally
+// managed table. lookup reports whether the next hop resource exists
+// and whether existence could be verified at all; unverifiable next
+// hops stay available. The returned cidrs are the routes that turned
+// blackhole.
+func computeLocalRouteStatus(routes SRoutes, lookup func(nextHopType, nextHopId string) (exists bool, known bool)) (bool, []string) {
+	changed := false
+	blackholed := make([]string, 0)
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		status := api.ROUTE_STATUS_AVAILABLE
+		if exists, known := lookup(route.NextHopType, route.NextHopId); known && !exists {
+			status = api.ROUTE_STATUS_BLACKHOLE
+		}
+		if route.Status == status {
+			continue
+		}
+		if status == api.ROUTE_STATUS_BLACKHOLE {
+			blackholed = append(blackholed, route.Cidr)
+		}
+		route.Status = status
+		changed = true
+	}
+	return changed, blackholed
+}
+
+// localNextHopLookup checks whether the next hop resource of a local
+// route still exists. Next hop types without a local model cannot be
+// verified.
+func localNextHopLookup(nextHopType, nextHopId string) (bool, bool) {
+	if len(nextHopId) == 0 {
+		return false, false
+	}
+	var err error
+	switch nextHopType {
+	case api.Next_HOP_TYPE_INSTANCE:
+		_, err = GuestManager.FetchById(nextHopId)
+	case api.Next_HOP_TYPE_NAT:
+		_, err = NatGatewayManager.FetchById(nextHopId)
+	default:
+		return false, false
+	}
+	return err == nil, true
+}
+
+// refreshLocalRouteStatus recomputes the status of every route of a
+// locally managed table by checking next hop resource existence
+func (rt *SRouteTable) refreshLocalRouteStatus(ctx context.Context, userCred mcclient.TokenCredential) error {
+	if rt.Routes == nil {
+		return nil
+	}
+	routes := *rt.Routes
+	changed, blackholed := computeLocalRouteStatus(routes, localNextHopLookup)
+	if !changed {
+		return nil
+	}
+	if _, err := db.Update(rt, func() error {
+		rt.Routes = &routes
+		return nil
+	}); err != nil {
+		return err
+	}
+	if len(blackholed) > 0 {
+		db.OpsLog.LogEvent(rt, db.ACT_ROUTE_BLACKHOLE,
+			fmt.Sprintf("routes to %s turned blackhole", strings.Join(blackholed, ", ")), userCred)
+	}
+	return nil
+}
+
+const (
+	// maxRouteImportBytes caps the size of one uploaded route plan
+	maxRouteImportBytes = 1 << 20
+	// maxRouteImportRows caps the number of routes of one import
+	maxRouteImportRows = 1000
+)
+
+// routeCsvHeader is the column order of exported and imported csv
+// documents
+var routeCsvHeader = []string{"cidr", "next_hop_type", "next_hop_id", "priority", "description"}
+
+// routesToEntries converts stored routes into the import/export wire
+// form, dropping provider-managed system routes which cannot be
+// imported back
+func routesToEntries(routes SRoutes) []api.RouteTableRouteEntry {
+	entries := make([]api.RouteTableRouteEntry, 0, len(routes))
+	for _, route := range routes {
+		if route == nil || route.Type == api.ROUTE_ENTRY_TYPE_SYSTEM {
+			continue
+		}
+		entries = append(entries, api.RouteTableRouteEntry{
+			Cidr:        route.Cidr,
+			NextHopType: route.NextHopType,
+			NextHopId:   route.NextHopId,
+			Priority:    route.Priority,
+			Description: route.Description,
+		})
+	}
+	return entries
+}
+
+// routesToCsv renders route entries as a csv document with a fixed
+// header row
+func routesToCsv(entries []api.RouteTableRouteEntry) string {
+	sb := &strings.Builder{}
+	w := csv.NewWriter(sb)
+	w.Write(routeCsvHeader)
+	for _, entry := range entries {
+		w.Write([]string{
+			entry.Cidr,
+			entry.NextHopType,
+			entry.NextHopId,
+			strconv.Itoa(entry.Priority),
+			entry.Description,
+		})
+	}
+	w.Flush()
+	return sb.String()
+}
+
+// parseRoutesCsv parses an uploaded csv document into route entries.
+// The first row must be a header naming at least the cidr column; rows
+// with problems are reported individually by their line in the file,
+// the header being line 1. Malformed documents fail as a whole.
+func parseRoutesCsv(content string) ([]api.RouteTableRouteEntry, []int, []api.RouteTableImportRowError, error) {
+	r := csv.NewReader(strings.NewReader(content))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, nil, nil, httperrors.NewInputParameterError("malformed csv: %s", err)
+	}
+	if len(records) == 0 {
+		return nil, nil, nil, httperrors.NewInputParameterError("empty csv")
+	}
+	colIdx := make(map[string]int, len(records[0]))
+	for i, name := range records[0] {
+		colIdx[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := colIdx["cidr"]; !ok {
+		return nil, nil, nil, httperrors.NewInputParameterError("csv header must name a cidr column")
+	}
+	field := func(record []string, name string) string {
+		idx, ok := colIdx[name]
+		if !ok || idx >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[idx])
+	}
+	entries := make([]api.RouteTableRouteEntry, 0, len(records)-1)
+	rows := make([]int, 0, len(records)-1)
+	rowErrs := make([]api.RouteTableImportRowError, 0)
+	for i, record := range records[1:] {
+		row := i + 2
+		entry := api.RouteTableRouteEntry{
+			Cidr:        field(record, "cidr"),
+			NextHopType: field(record, "next_hop_type"),
+			NextHopId:   field(record, "next_hop_id"),
+			Description: field(record, "description"),
+		}
+		if priStr := field(record, "priority"); priStr != "" {
+			pri, err := strconv.Atoi(priStr)
+			if err != nil {
+				rowErrs = append(rowErrs, api.RouteTableImportRowError{Row: row, Reason: fmt.Sprintf("invalid priority %q", priStr)})
+				continue
+			}
+			entry.Priority = pri
+		}
+		entries = append(entries, entry)
+		rows = append(rows, row)
+	}
+	return entries, rows, rowErrs, nil
+}
+
+// validateImportEntries turns uploaded entries into routes, reporting
+// one error per bad row: invalid or missing cidr, negative priority,
+// or a (cidr, priority) pair duplicated within the upload or against
+// the kept routes
+func validateImportEntries(kept SRoutes, entries []api.RouteTableRouteEntry, rows []int) (SRoutes, []api.RouteTableImportRowError) {
+	seen := make(map[string]struct{}, len(kept)+len(entries))
+	for _, route := range kept {
+		if route == nil {
+			continue
+		}
+		seen[fmt.Sprintf("%s:%d", route.Cidr, route.Priority)] = struct{}{}
+	}
+	routes := make(SRoutes, 0, len(entries))
+	rowErrs := make([]api.RouteTableImportRowError, 0)
+	for i, entry := range entries {
+		row := rows[i]
+		if entry.Cidr == "" {
+			rowErrs = append(rowErrs, api.RouteTableImportRowError{Row: row, Reason: "cidr is empty"})
+			continue
+		}
+		if entry.Priority < 0 {
+			rowErrs = append(rowErrs, api.RouteTableImportRowError{Row: row, Reason: fmt.Sprintf("negative priority %d", entry.Priority)})
+			continue
+		}
+		route := &SRoute{
+			Type:        api.ROUTE_ENTRY_TYPE_CUSTOM,
+			Cidr:        entry.Cidr,
+			NextHopType: entry.NextHopType,
+			NextHopId:   entry.NextHopId,
+			Priority:    entry.Priority,
+			Description: entry.Description,
+		}
+		if err := route.Validate(nil); err != nil {
+			rowErrs = append(rowErrs, api.RouteTableImportRowError{Row: row, Reason: fmt.Sprintf("invalid cidr %s", entry.Cidr)})
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", route.Cidr, route.Priority)
+		if _, ok := seen[key]; ok {
+			rowErrs = append(rowErrs, api.RouteTableImportRowError{Row: row, Reason: fmt.Sprintf("duplicate route %s priority %d", route.Cidr, route.Priority)})
+			continue
+		}
+		seen[key] = struct{}{}
+		routes = append(routes, route)
+	}
+	return routes, rowErrs
+}
+
+// splitRoutesByType partitions stored routes into system routes, which
+// survive every import, and custom routes
+func splitRoutesByType(routes SRoutes) (SRoutes, SRoutes) {
+	system := SRoutes{}
+	custom := SRoutes{}
+	for _, route := range routes {
+		if route == nil {
+			continue
+		}
+		if route.Type == api.ROUTE_ENTRY_TYPE_SYSTEM {
+			system = append(system, route)
+		} else {
+			custom = append(custom, route)
+		}
+	}
+	return system, custom
+}
+
+func (rt *SRouteTable) AllowGetDetailsRoutes(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return rt.IsOwner(userCred) || db.IsAdminAllowGetSpec(userCred, rt, "routes")
+}
+
+// GetDetailsRoutes exports the route plan of the table as json or csv
+// (format=csv), system routes excluded
+func (rt *SRouteTable) GetDetailsRoutes(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	format, _ := query.GetString("format")
+	if format == "" {
+		format = "json"
+	}
+	routes := SRoutes{}
+	if rt.Routes != nil {
+		routes = *rt.Routes
+	}
+	entries := routesToEntries(routes)
+	output := api.RouteTableExportOutput{Format: format}
+	switch format {
+	case "json":
+		output.Routes = entries
+	case "csv":
+		output.Csv = routesToCsv(entries)
+	default:
+		return nil, httperrors.NewInputParameterError("invalid format %q, expect json or csv", format)
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (rt *SRouteTable) AllowPerformImportRoutes(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data *jsonutils.JSONDict) bool {
+	return rt.AllowPerformAddRoutes(ctx, userCred, query, data)
+}
+
+// PerformImportRoutes bulk-loads a route plan, either a json array or
+// the content of a csv file, appending to or replacing the custom
+// routes. The whole upload is validated first and applied in one
+// update: any bad row fails the import and is reported with its row
+// number so the user can fix the file.
+func (rt *SRouteTable) PerformImportRoutes(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data *jsonutils.JSONDict) (jsonutils.JSONObject, error) {
+	input := api.RouteTableImportRoutesInput{}
+	if err := data.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal import input: %s", err)
+	}
+	if input.Mode == "" {
+		input.Mode = api.ROUTE_TABLE_IMPORT_MODE_APPEND
+	}
+	if input.Mode != api.ROUTE_TABLE_IMPORT_MODE_APPEND && input.Mode != api.ROUTE_TABLE_IMPORT_MODE_REPLACE {
+		return nil, httperrors.NewInputParameterError("invalid mode %q, expect append or replace", input.Mode)
+	}
+
+	var entries []api.RouteTableRouteEntry
+	var rows []int
+	rowErrs := make([]api.RouteTableImportRowError, 0)
+	if len(input.Csv) > 0 {
+		if len(input.Csv) > maxRouteImportBytes {
+			return nil, httperrors.NewInputParameterError("csv exceeds %d bytes", maxRouteImportBytes)
+		}
+		var err error
+		entries, rows, rowErrs, err = parseRoutesCsv(input.Csv)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(input.Routes) > 0 {
+		entries = input.Routes
+		rows = make([]int, len(entries))
+		for i := range rows {
+			rows[i] = i + 1
+		}
+	} else {
+		return nil, httperrors.NewMissingParameterError("routes")
+	}
+	if len(entries) > maxRouteImportRows {
+		return nil, httperrors.NewInputParameterError("at most %d routes per import", maxRouteImportRows)
+	}
+
+	var existing SRoutes
+	if rt.Routes != nil {
+		existing_ := gotypes.DeepCopy(rt.Routes).(*SRoutes)
+		existing = *existing_
+	}
+	system, custom := splitRoutesByType(existing)
+	kept := system
+	if input.Mode == api.ROUTE_TABLE_IMPORT_MODE_APPEND {
+		kept = append(kept, custom...)
+	}
+
+	imported, moreErrs := validateImportEntries(kept, entries, rows)
+	rowErrs = append(rowErrs, moreErrs...)
+	output := api.RouteTableImportRoutesOutput{}
+	if len(rowErrs) > 0 {
+		// nothing applied; hand the row errors back for fixing
+		output.RowErrors = rowErrs
+		return jsonutils.Marshal(output), nil
+	}
+
+	merged := append(kept, imported...)
+	if cloudregion, err := rt.getRegion(); err == nil {
+		driver := cloudregion.GetDriver()
+		minPri, maxPri := driver.GetRoutePriorityRange()
+		if err := validateRoutesPriority(merged, driver.IsSupportRoutePriority(), minPri, maxPri); err != nil {
+			return nil, err
+		}
+	}
+	merged.Sort()
+	if _, err := db.Update(rt, func() error {
+		rt.Routes = &merged
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	logclient.AddActionLogWithContext(ctx, rt, logclient.ACT_UPDATE,
+		fmt.Sprintf("imported %d routes, mode %s", len(imported), input.Mode), userCred, true)
+
+	output.Imported = len(imported)
+	count, _ := countRoutes(merged, false)
+	output.Total = count
+
+	if input.Mode == api.ROUTE_TABLE_IMPORT_MODE_REPLACE && len(rt.ExternalId) > 0 {
+		// a replaced plan on a managed table must reach the cloud
+		if err := rt.StartRouteTableApplyTask(ctx, userCred, ""); err != nil {
+			log.Errorf("start route table apply task for %s: %v", rt.Id, err)
+		}
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+// StartRouteTableApplyTask pushes the local route plan of a managed
+// table to the cloud provider asynchronously
+func (rt *SRouteTable) StartRouteTableApplyTask(ctx context.Context, userCred mcclient.TokenCredential, parentTaskId string) error {
+	rt.SetStatus(userCred, api.ROUTE_TABLE_APPLYING, "import_routes")
+	task, err := taskman.TaskManager.NewTask(ctx, "RouteTableApplyTask", rt, userCred, nil, parentTaskId, "", nil)
+	if err != nil {
+		return err
+	}
+	task.ScheduleRun(nil)
+	return nil
+}
+
+// GetRegionDriver returns the region driver of the route table's vpc
+func (rt *SRouteTable) GetRegionDriver() (IRegionDriver, error) {
+	region, err := rt.getRegion()
+	if err != nil {
+		return nil, errors.Wrap(err, "getRegion")
+	}
+	return region.GetDriver(), nil
+}
+
 func (self *SRouteTable) getVpc() (*SVpc, error) {
 	val, err := VpcManager.FetchById(self.VpcId)
 	if err != nil {
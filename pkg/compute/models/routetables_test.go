@@ -0,0 +1,538 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudprovider"
+)
+
+type fakeCloudRoute struct {
+	routeType   string
+	cidr        string
+	nextHopType string
+	nextHop     string
+	priority    int
+	status      string
+}
+
+func (r *fakeCloudRoute) GetType() string        { return r.routeType }
+func (r *fakeCloudRoute) GetCidr() string        { return r.cidr }
+func (r *fakeCloudRoute) GetNextHopType() string { return r.nextHopType }
+func (r *fakeCloudRoute) GetNextHop() string     { return r.nextHop }
+func (r *fakeCloudRoute) GetPriority() int       { return r.priority }
+func (r *fakeCloudRoute) GetStatus() string      { return r.status }
+
+type fakeCloudRouteTable struct {
+	globalId string
+	routes   []cloudprovider.ICloudRoute
+}
+
+func (t *fakeCloudRouteTable) GetId() string       { return t.globalId }
+func (t *fakeCloudRouteTable) GetName() string     { return t.globalId }
+func (t *fakeCloudRouteTable) GetGlobalId() string { return t.globalId }
+func (t *fakeCloudRouteTable) GetStatus() string   { return "available" }
+func (t *fakeCloudRouteTable) Refresh() error      { return nil }
+func (t *fakeCloudRouteTable) IsEmulated() bool    { return false }
+func (t *fakeCloudRouteTable) GetMetadata() *jsonutils.JSONDict {
+	return nil
+}
+func (t *fakeCloudRouteTable) GetDescription() string { return "" }
+func (t *fakeCloudRouteTable) GetRegionId() string    { return "" }
+func (t *fakeCloudRouteTable) GetVpcId() string       { return "" }
+func (t *fakeCloudRouteTable) GetType() string        { return "VPC" }
+func (t *fakeCloudRouteTable) GetIRoutes() ([]cloudprovider.ICloudRoute, error) {
+	return t.routes, nil
+}
+
+func TestRoutesFromCloud(t *testing.T) {
+	table := &fakeCloudRouteTable{
+		globalId: "rtbl-1",
+		routes: []cloudprovider.ICloudRoute{
+			&fakeCloudRoute{"System", "192.168.0.0/16", "Instance", "i-abc", 20, ""},
+			&fakeCloudRoute{"Custom", "10.0.0.0/8", "NatGateway", "nat-def", 10, "Blackhole"},
+		},
+	}
+	routes, err := routesFromCloud(table)
+	if err != nil {
+		t.Fatalf("routesFromCloud: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expect 2 routes, got %d", len(routes))
+	}
+	// ordered by priority for deterministic serialization
+	if routes[0].Cidr != "10.0.0.0/8" || routes[0].NextHopId != "nat-def" || routes[0].Priority != 10 {
+		t.Fatalf("unexpected route %+v", routes[0])
+	}
+	if routes[1].Priority != 20 {
+		t.Fatalf("unexpected route %+v", routes[1])
+	}
+	if routes[0].Status != api.ROUTE_STATUS_BLACKHOLE {
+		t.Errorf("provider reported blackhole, got %q", routes[0].Status)
+	}
+	// empty provider status defaults to available
+	if routes[1].Status != api.ROUTE_STATUS_AVAILABLE {
+		t.Errorf("empty status must default to available, got %q", routes[1].Status)
+	}
+}
+
+func TestRoutesSort(t *testing.T) {
+	routes := SRoutes{
+		{Cidr: "192.168.0.0/16", Priority: 10},
+		{Cidr: "10.0.0.0/8", Priority: 10},
+		{Cidr: "172.16.0.0/12"},
+	}
+	routes.Sort()
+	wantCidrs := []string{"172.16.0.0/12", "10.0.0.0/8", "192.168.0.0/16"}
+	for i := range wantCidrs {
+		if routes[i].Cidr != wantCidrs[i] {
+			t.Errorf("routes[%d].Cidr = %s, want %s", i, routes[i].Cidr, wantCidrs[i])
+		}
+	}
+}
+
+func TestValidateRoutesPriority(t *testing.T) {
+	dup := SRoutes{
+		{Cidr: "10.0.0.0/8", Priority: 1},
+		{Cidr: "10.0.0.0/8", Priority: 2},
+	}
+	if err := validateRoutesPriority(dup, true, 1, 255); err != nil {
+		t.Errorf("same cidr with distinct priorities should pass: %v", err)
+	}
+	if err := validateRoutesPriority(dup, false, 0, 0); err == nil {
+		t.Errorf("expect error without priority support")
+	}
+	outOfRange := SRoutes{{Cidr: "10.0.0.0/8", Priority: 256}}
+	if err := validateRoutesPriority(outOfRange, true, 1, 255); err == nil {
+		t.Errorf("expect error for out of range priority")
+	}
+	defaultPri := SRoutes{
+		{Cidr: "10.0.0.0/8"},
+		{Cidr: "192.168.0.0/16"},
+	}
+	if err := validateRoutesPriority(defaultPri, false, 0, 0); err != nil {
+		t.Errorf("zero priorities should pass without support: %v", err)
+	}
+	if err := validateRoutesPriority(defaultPri, true, 1, 255); err != nil {
+		t.Errorf("zero priority keeps provider default: %v", err)
+	}
+}
+
+func TestRoutesValidateDuplicate(t *testing.T) {
+	routes := SRoutes{
+		{Cidr: "10.0.0.0/8", Priority: 1},
+		{Cidr: "10.0.0.0/8", Priority: 1},
+	}
+	if err := routes.Validate(nil); err == nil {
+		t.Errorf("expect error for duplicate cidr with same priority")
+	}
+	routes = SRoutes{
+		{Cidr: "10.0.0.0/8", Priority: 2},
+		{Cidr: "10.0.0.0/8", Priority: 1},
+	}
+	if err := routes.Validate(nil); err != nil {
+		t.Fatalf("distinct priorities should pass: %v", err)
+	}
+	if routes[0].Priority != 1 {
+		t.Errorf("expect routes sorted by priority, got %+v", routes)
+	}
+}
+
+func TestFindICloudRouteTable(t *testing.T) {
+	tables := []cloudprovider.ICloudRouteTable{
+		&fakeCloudRouteTable{globalId: "rtbl-1"},
+		&fakeCloudRouteTable{globalId: "rtbl-2"},
+	}
+	if found := findICloudRouteTable(tables, "rtbl-2"); found == nil || found.GetGlobalId() != "rtbl-2" {
+		t.Fatalf("expect rtbl-2, got %v", found)
+	}
+	if found := findICloudRouteTable(tables, "rtbl-3"); found != nil {
+		t.Fatalf("expect nil for missing external id, got %v", found)
+	}
+}
+
+func TestMatchRoutesCidr(t *testing.T) {
+	routes := SRoutes{
+		{Type: "Custom", Cidr: "10.0.0.0/8"},
+		{Type: "Custom", Cidr: "10.3.0.0/16"},
+		{Type: "Custom", Cidr: "192.168.1.0/24"},
+	}
+	cases := []struct {
+		name      string
+		cidr      string
+		matchMode string
+		want      bool
+	}{
+		{"contains nested cidr", "10.3.4.0/24", "contains", true},
+		{"contains exact network", "10.3.0.0/16", "contains", true},
+		{"contains single host", "192.168.1.5", "contains", true},
+		{"overlapping but not covered", "10.0.0.0/4", "contains", false},
+		{"disjoint cidr", "172.16.0.0/12", "contains", false},
+		{"exact match", "10.3.0.0/16", "exact", true},
+		{"exact does not match nested", "10.3.4.0/24", "exact", false},
+		{"exact does not match wider", "10.0.0.0/7", "exact", false},
+	}
+	for _, c := range cases {
+		queryNet, exact, err := parseRouteTableCidrFilter(c.cidr, c.matchMode)
+		if err != nil {
+			t.Fatalf("%s: parseRouteTableCidrFilter(%q, %q): %v", c.name, c.cidr, c.matchMode, err)
+		}
+		if got := matchRoutesCidr(routes, queryNet, exact); got != c.want {
+			t.Errorf("%s: matchRoutesCidr(%q, %q) = %v, want %v", c.name, c.cidr, c.matchMode, got, c.want)
+		}
+	}
+}
+
+func TestParseRouteTableCidrFilterErrors(t *testing.T) {
+	if _, _, err := parseRouteTableCidrFilter("not-a-cidr", ""); err == nil {
+		t.Errorf("expect error for invalid cidr")
+	}
+	if _, _, err := parseRouteTableCidrFilter("10.0.0.0/8", "overlaps"); err == nil {
+		t.Errorf("expect error for invalid cidr_match")
+	}
+}
+
+func TestPartitionRouteTablePurgeProviders(t *testing.T) {
+	providers := []sRouteTablePurgeProvider{
+		{Id: "prov-1", Name: "aws-disabled", Enabled: false},
+		{Id: "prov-2", Name: "aliyun-enabled", Enabled: true},
+		{Id: "prov-3", Name: "qcloud-disabled", Enabled: false},
+	}
+	managerIds, skipped := partitionRouteTablePurgeProviders(providers)
+	if len(managerIds) != 2 || managerIds[0] != "prov-1" || managerIds[1] != "prov-3" {
+		t.Errorf("managerIds = %v, want [prov-1 prov-3]", managerIds)
+	}
+	if len(skipped) != 1 || skipped[0] != "cloud provider aliyun-enabled is enabled" {
+		t.Errorf("skipped = %v", skipped)
+	}
+
+	managerIds, skipped = partitionRouteTablePurgeProviders(nil)
+	if len(managerIds) != 0 || len(skipped) != 0 {
+		t.Errorf("empty input should purge and skip nothing, got %v %v", managerIds, skipped)
+	}
+}
+
+func TestMergeRouteDescriptions(t *testing.T) {
+	prev := SRoutes{
+		{Cidr: "10.0.0.0/8", NextHopId: "nat-1", Description: "to on-prem"},
+		{Cidr: "172.16.0.0/12", NextHopId: "i-gone", Description: "legacy proxy"},
+		{Cidr: "192.168.0.0/16", NextHopId: "i-abc", Description: "office vpn"},
+		{Cidr: "100.64.0.0/10", NextHopId: "nat-1"},
+	}
+	cases := []struct {
+		name     string
+		next     *SRoute
+		wantDesc string
+	}{
+		{
+			name:     "unchanged route keeps its description",
+			next:     &SRoute{Cidr: "10.0.0.0/8", NextHopId: "nat-1"},
+			wantDesc: "to on-prem",
+		},
+		{
+			name:     "changed nexthop drops the description",
+			next:     &SRoute{Cidr: "192.168.0.0/16", NextHopId: "i-xyz"},
+			wantDesc: "",
+		},
+		{
+			name:     "added route has no description to inherit",
+			next:     &SRoute{Cidr: "10.10.0.0/16", NextHopId: "vpn-2"},
+			wantDesc: "",
+		},
+		{
+			name:     "incoming description wins over the stored one",
+			next:     &SRoute{Cidr: "192.168.0.0/16", NextHopId: "i-abc", Description: "renamed"},
+			wantDesc: "renamed",
+		},
+	}
+	for _, c := range cases {
+		merged := mergeRouteDescriptions(prev, SRoutes{c.next})
+		if len(merged) != 1 {
+			t.Fatalf("%s: expect 1 route, got %d", c.name, len(merged))
+		}
+		if merged[0].Description != c.wantDesc {
+			t.Errorf("%s: description = %q, want %q", c.name, merged[0].Description, c.wantDesc)
+		}
+	}
+
+	// the removed route's annotation simply disappears with it
+	merged := mergeRouteDescriptions(prev, SRoutes{
+		{Cidr: "10.0.0.0/8", NextHopId: "nat-1"},
+		{Cidr: "100.64.0.0/10", NextHopId: "nat-1"},
+	})
+	if len(merged) != 2 {
+		t.Fatalf("expect 2 routes, got %d", len(merged))
+	}
+	for _, route := range merged {
+		if route.Cidr == "172.16.0.0/12" {
+			t.Errorf("removed route must not reappear")
+		}
+	}
+	if merged[1].Description != "" {
+		t.Errorf("route without annotation stays clean, got %q", merged[1].Description)
+	}
+
+	if got := mergeRouteDescriptions(nil, SRoutes{{Cidr: "10.0.0.0/8"}}); len(got) != 1 {
+		t.Errorf("empty prev must pass next through, got %v", got)
+	}
+}
+
+func TestCountRoutes(t *testing.T) {
+	routes := SRoutes{
+		{Type: "system", Cidr: "10.0.0.0/8", NextHopType: "local"},
+		{Cidr: "0.0.0.0/0", NextHopType: "natgateway", NextHopId: "nat-1"},
+		{Cidr: "192.168.1.0/24", NextHopType: "instance", NextHopId: "vm-1"},
+		{Cidr: "192.168.2.0/24", NextHopType: "instance", NextHopId: "vm-2"},
+	}
+
+	// system routes are excluded by default
+	count, byType := countRoutes(routes, false)
+	if count != 3 {
+		t.Errorf("count = %d, want 3 without system routes", count)
+	}
+	if byType["instance"] != 2 || byType["natgateway"] != 1 {
+		t.Errorf("byType = %v, want 2 instance and 1 natgateway", byType)
+	}
+	if _, ok := byType["local"]; ok {
+		t.Errorf("system route next hop must not be counted by default")
+	}
+
+	// include_system counts everything
+	count, byType = countRoutes(routes, true)
+	if count != 4 || byType["local"] != 1 {
+		t.Errorf("count = %d byType = %v, want 4 with 1 local", count, byType)
+	}
+
+	// a table holding only system routes is empty by default
+	systemOnly := SRoutes{
+		{Type: "system", Cidr: "10.0.0.0/8", NextHopType: "local"},
+	}
+	if count, _ := countRoutes(systemOnly, false); count != 0 {
+		t.Errorf("count = %d, want 0 for only-system table", count)
+	}
+
+	if count, byType := countRoutes(nil, false); count != 0 || len(byType) != 0 {
+		t.Errorf("count of nil routes = %d %v, want empty", count, byType)
+	}
+}
+
+func TestParseRoutesCsv(t *testing.T) {
+	csvDoc := "cidr,next_hop_type,next_hop_id,priority,description\n" +
+		"10.0.0.0/8,NatGateway,nat-1,10,to on-prem\n" +
+		"192.168.0.0/16,Instance,i-abc,,office vpn\n"
+	entries, rows, rowErrs, err := parseRoutesCsv(csvDoc)
+	if err != nil {
+		t.Fatalf("parseRoutesCsv: %v", err)
+	}
+	if len(rowErrs) != 0 {
+		t.Fatalf("valid file must have no row errors, got %v", rowErrs)
+	}
+	if len(entries) != 2 || len(rows) != 2 {
+		t.Fatalf("expect 2 entries, got %d entries %d rows", len(entries), len(rows))
+	}
+	if entries[0].Cidr != "10.0.0.0/8" || entries[0].NextHopId != "nat-1" || entries[0].Priority != 10 {
+		t.Errorf("unexpected entry %+v", entries[0])
+	}
+	// the header is line 1, data rows follow
+	if rows[0] != 2 || rows[1] != 3 {
+		t.Errorf("rows = %v, want [2 3]", rows)
+	}
+	if entries[1].Priority != 0 {
+		t.Errorf("empty priority keeps provider default, got %d", entries[1].Priority)
+	}
+
+	// one bad row is reported by its line in the file
+	csvBad := "cidr,priority\n" +
+		"10.0.0.0/8,10\n" +
+		"192.168.0.0/16,high\n"
+	entries, _, rowErrs, err = parseRoutesCsv(csvBad)
+	if err != nil {
+		t.Fatalf("parseRoutesCsv: %v", err)
+	}
+	if len(rowErrs) != 1 || rowErrs[0].Row != 3 {
+		t.Fatalf("expect one error on row 3, got %v", rowErrs)
+	}
+	if len(entries) != 1 {
+		t.Errorf("good rows still parse, got %d entries", len(entries))
+	}
+
+	if _, _, _, err := parseRoutesCsv("next_hop_type,priority\nNatGateway,10\n"); err == nil {
+		t.Errorf("expect error for header without cidr column")
+	}
+}
+
+func TestValidateImportEntries(t *testing.T) {
+	kept := SRoutes{
+		{Type: "Custom", Cidr: "10.0.0.0/8", Priority: 1},
+	}
+	entries := []api.RouteTableRouteEntry{
+		{Cidr: "192.168.0.0/16", NextHopType: "Instance", NextHopId: "i-abc"},
+		{Cidr: "not-a-cidr"},
+		{Cidr: "10.0.0.0/8", Priority: 1},
+		{Cidr: "172.16.0.0/12", Priority: -1},
+	}
+	rows := []int{2, 3, 4, 5}
+	imported, rowErrs := validateImportEntries(kept, entries, rows)
+	if len(imported) != 1 || imported[0].Cidr != "192.168.0.0/16" {
+		t.Fatalf("imported = %v, want only the valid route", imported)
+	}
+	if imported[0].Type != "Custom" {
+		t.Errorf("imported routes must be custom, got %q", imported[0].Type)
+	}
+	if len(rowErrs) != 3 {
+		t.Fatalf("expect 3 row errors, got %v", rowErrs)
+	}
+	wantRows := []int{3, 4, 5}
+	for i, re := range rowErrs {
+		if re.Row != wantRows[i] {
+			t.Errorf("rowErrs[%d].Row = %d, want %d", i, re.Row, wantRows[i])
+		}
+	}
+
+	// a duplicate inside the upload itself is also rejected
+	dup := []api.RouteTableRouteEntry{
+		{Cidr: "192.168.0.0/16"},
+		{Cidr: "192.168.0.0/16"},
+	}
+	if imported, rowErrs := validateImportEntries(nil, dup, []int{1, 2}); len(imported) != 1 || len(rowErrs) != 1 || rowErrs[0].Row != 2 {
+		t.Errorf("duplicate in upload: imported %v errors %v", imported, rowErrs)
+	}
+}
+
+func TestSplitRoutesByType(t *testing.T) {
+	system, custom := splitRoutesByType(SRoutes{
+		{Type: "System", Cidr: "10.0.0.0/8"},
+		{Type: "Custom", Cidr: "192.168.0.0/16"},
+		nil,
+	})
+	if len(system) != 1 || system[0].Cidr != "10.0.0.0/8" {
+		t.Errorf("system = %v", system)
+	}
+	if len(custom) != 1 || custom[0].Cidr != "192.168.0.0/16" {
+		t.Errorf("custom = %v", custom)
+	}
+}
+
+func TestRoutesCsvRoundTrip(t *testing.T) {
+	routes := SRoutes{
+		{Type: "System", Cidr: "100.64.0.0/10", NextHopType: "local"},
+		{Type: "Custom", Cidr: "10.0.0.0/8", NextHopType: "NatGateway", NextHopId: "nat-1", Priority: 10, Description: "to on-prem"},
+	}
+	entries := routesToEntries(routes)
+	if len(entries) != 1 {
+		t.Fatalf("system routes must not export, got %d entries", len(entries))
+	}
+	parsed, _, rowErrs, err := parseRoutesCsv(routesToCsv(entries))
+	if err != nil || len(rowErrs) != 0 {
+		t.Fatalf("round trip: err %v rowErrs %v", err, rowErrs)
+	}
+	if len(parsed) != 1 || parsed[0] != entries[0] {
+		t.Fatalf("round trip mismatch: %+v vs %+v", parsed, entries)
+	}
+}
+
+func TestNormalizeRouteStatus(t *testing.T) {
+	cases := map[string]string{
+		"":          api.ROUTE_STATUS_AVAILABLE,
+		"Available": api.ROUTE_STATUS_AVAILABLE,
+		"available": api.ROUTE_STATUS_AVAILABLE,
+		"Blackhole": api.ROUTE_STATUS_BLACKHOLE,
+		"Pending":   api.ROUTE_STATUS_UNKNOWN,
+	}
+	for in, want := range cases {
+		if got := normalizeRouteStatus(in); got != want {
+			t.Errorf("normalizeRouteStatus(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestNewlyBlackholedRoutes(t *testing.T) {
+	prev := SRoutes{
+		{Cidr: "10.0.0.0/8", NextHopId: "i-abc", Status: api.ROUTE_STATUS_AVAILABLE},
+		{Cidr: "172.16.0.0/12", NextHopId: "i-old", Status: api.ROUTE_STATUS_BLACKHOLE},
+	}
+	next := SRoutes{
+		// transition available -> blackhole
+		{Cidr: "10.0.0.0/8", NextHopId: "i-abc", Status: api.ROUTE_STATUS_BLACKHOLE},
+		// already blackhole, no new event
+		{Cidr: "172.16.0.0/12", NextHopId: "i-old", Status: api.ROUTE_STATUS_BLACKHOLE},
+		// a route arriving already broken counts too
+		{Cidr: "192.168.0.0/16", NextHopId: "i-new", Status: api.ROUTE_STATUS_BLACKHOLE},
+		{Cidr: "100.64.0.0/10", NextHopId: "nat-1", Status: api.ROUTE_STATUS_AVAILABLE},
+	}
+	got := newlyBlackholedRoutes(prev, next)
+	if len(got) != 2 || got[0] != "10.0.0.0/8" || got[1] != "192.168.0.0/16" {
+		t.Errorf("newlyBlackholedRoutes = %v, want [10.0.0.0/8 192.168.0.0/16]", got)
+	}
+	if got := newlyBlackholedRoutes(nil, SRoutes{{Cidr: "10.0.0.0/8", Status: api.ROUTE_STATUS_AVAILABLE}}); len(got) != 0 {
+		t.Errorf("available routes must not report transitions, got %v", got)
+	}
+}
+
+func TestCountBlackholeRoutes(t *testing.T) {
+	routes := SRoutes{
+		{Cidr: "10.0.0.0/8", Status: api.ROUTE_STATUS_BLACKHOLE},
+		{Cidr: "192.168.0.0/16", Status: api.ROUTE_STATUS_AVAILABLE},
+		nil,
+	}
+	if got := countBlackholeRoutes(routes); got != 1 {
+		t.Errorf("countBlackholeRoutes = %d, want 1", got)
+	}
+	if !routesHaveBlackhole(routes) {
+		t.Errorf("expect blackhole detected")
+	}
+	if routesHaveBlackhole(nil) {
+		t.Errorf("empty table has no blackhole routes")
+	}
+}
+
+func TestComputeLocalRouteStatus(t *testing.T) {
+	routes := SRoutes{
+		{Cidr: "10.0.0.0/8", NextHopType: api.Next_HOP_TYPE_INSTANCE, NextHopId: "vm-gone"},
+		{Cidr: "192.168.0.0/16", NextHopType: api.Next_HOP_TYPE_INSTANCE, NextHopId: "vm-live"},
+		{Cidr: "172.16.0.0/12", NextHopType: api.Next_HOP_TYPE_VPN, NextHopId: "vpn-1"},
+		nil,
+	}
+	lookup := func(nextHopType, nextHopId string) (bool, bool) {
+		if nextHopType != api.Next_HOP_TYPE_INSTANCE {
+			// unverifiable next hop types stay available
+			return false, false
+		}
+		return nextHopId == "vm-live", true
+	}
+	changed, blackholed := computeLocalRouteStatus(routes, lookup)
+	if !changed {
+		t.Fatalf("expect status changes")
+	}
+	if len(blackholed) != 1 || blackholed[0] != "10.0.0.0/8" {
+		t.Errorf("blackholed = %v, want [10.0.0.0/8]", blackholed)
+	}
+	wantStatus := []string{api.ROUTE_STATUS_BLACKHOLE, api.ROUTE_STATUS_AVAILABLE, api.ROUTE_STATUS_AVAILABLE}
+	for i, want := range wantStatus {
+		if routes[i].Status != want {
+			t.Errorf("routes[%d].Status = %q, want %q", i, routes[i].Status, want)
+		}
+	}
+
+	// a second pass with the same answers is a no-op
+	changed, blackholed = computeLocalRouteStatus(routes, lookup)
+	if changed || len(blackholed) != 0 {
+		t.Errorf("second pass must be stable, changed %v blackholed %v", changed, blackholed)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"yunion.io/x/sqlchemy"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+// maxSearchTextLen caps the free-text search input so an oversized
+// pattern cannot turn into a pathological LIKE scan
+const maxSearchTextLen = 64
+
+// normalizeSearchText prepares a free-text search input for LIKE
+// matching: trims surrounding spaces, drops the '%' wildcard so the
+// input is matched literally, and caps the length
+func normalizeSearchText(search string) string {
+	search = strings.TrimSpace(search)
+	search = strings.Replace(search, "%", "", -1)
+	if len(search) > maxSearchTextLen {
+		search = search[:maxSearchTextLen]
+	}
+	return search
+}
+
+// searchRankExpr builds the SQL expression ranking exact-name matches
+// of a free-text search before the other results, with the search
+// text quoted as a SQL string literal
+func searchRankExpr(search string) string {
+	escaped := strings.Replace(search, `\`, `\\`, -1)
+	escaped = strings.Replace(escaped, "'", "''", -1)
+	return fmt.Sprintf("CASE WHEN %%s = '%s' THEN 0 ELSE 1 END", escaped)
+}
+
+// applyGuestSearchFilter restricts a guest query to rows matching the
+// free-text search over name, id prefix, nic addresses and associated
+// eip addresses. The address matches go through IN subqueries so a
+// guest with several matching nics still yields one row
+func applyGuestSearchFilter(q *sqlchemy.SQuery, search string) *sqlchemy.SQuery {
+	nicQ := GuestnetworkManager.Query("guest_id").Contains("ip_addr", search).Distinct()
+	eipQ := ElasticipManager.Query("associate_id").
+		Equals("associate_type", api.EIP_ASSOCIATE_TYPE_SERVER).
+		Contains("ip_addr", search).Distinct()
+	return q.Filter(sqlchemy.OR(
+		sqlchemy.Contains(q.Field("name"), search),
+		sqlchemy.Startswith(q.Field("id"), search),
+		sqlchemy.In(q.Field("id"), nicQ.SubQuery()),
+		sqlchemy.In(q.Field("id"), eipQ.SubQuery()),
+	))
+}
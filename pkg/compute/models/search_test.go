@@ -0,0 +1,49 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeSearchText(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"  web-01  ", "web-01"},
+		{"%admin%", "admin"},
+		{"10.0.0.3", "10.0.0.3"},
+		{strings.Repeat("a", maxSearchTextLen+10), strings.Repeat("a", maxSearchTextLen)},
+	}
+	for _, c := range cases {
+		if got := normalizeSearchText(c.in); got != c.want {
+			t.Errorf("normalizeSearchText(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestSearchRankExpr(t *testing.T) {
+	expr := searchRankExpr("web-01")
+	if !strings.Contains(expr, "'web-01'") || !strings.Contains(expr, "%s") {
+		t.Errorf("expr %q misses the quoted literal or the field placeholder", expr)
+	}
+	// quotes and backslashes are escaped inside the SQL literal
+	expr = searchRankExpr(`o'neil\`)
+	if !strings.Contains(expr, `'o''neil\\'`) {
+		t.Errorf("expr %q does not escape the literal", expr)
+	}
+}
@@ -608,6 +608,34 @@ func (self *SSecurityGroup) PerformMerge(ctx context.Context, userCred mcclient.
 	return nil, nil
 }
 
+func (self *SSecurityGroup) AllowPerformPreviewSync(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return self.IsOwner(userCred) || db.IsAdminAllowPerform(userCred, self, "preview-sync")
+}
+
+// PerformPreviewSync 预览安全组规则同步到指定云平台会产生的变更, 不实际执行
+// remote_rules为云端当前规则, 为空代表首次下发
+func (self *SSecurityGroup) PerformPreviewSync(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	provider, _ := data.GetString("provider")
+	if len(provider) == 0 {
+		return nil, httperrors.NewMissingParameterError("provider")
+	}
+	driver, ok := regionDrivers[provider]
+	if !ok {
+		return nil, httperrors.NewInputParameterError("unsupported provider %s", provider)
+	}
+	remoteRules := []secrules.SecurityRule{}
+	ruleStrs := jsonutils.GetQueryStringArray(data, "remote_rules")
+	for _, ruleStr := range ruleStrs {
+		rule, err := secrules.ParseSecurityRule(ruleStr)
+		if err != nil {
+			return nil, httperrors.NewInputParameterError("invalid rule %s: %v", ruleStr, err)
+		}
+		remoteRules = append(remoteRules, *rule)
+	}
+	diff := driver.DiffSecgroupRules(self.GetSecRules(""), remoteRules)
+	return jsonutils.Marshal(diff), nil
+}
+
 func (self *SSecurityGroup) GetOutAllowList() secrules.SecurityRuleSet {
 	rules := self.GetSecRules("out")
 	ruleSet := secrules.SecurityRuleSet(rules)
@@ -0,0 +1,64 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/compute/options"
+)
+
+// sharedImageResourceTypes are the image-like sharable resource types
+// whose inbound grants count against the target project's share quota
+var sharedImageResourceTypes = []string{
+	"servertemplate",
+}
+
+func init() {
+	db.RegisterSharedGrantQuotaChecker(checkSharedImageGrantQuota)
+}
+
+// checkSharedImageGrantQuota caps how many image-type resources may be
+// shared into one project, so a project cannot accumulate unbounded
+// templates that count against nothing. Non-image resource types and a
+// non-positive cap are unrestricted.
+func checkSharedImageGrantQuota(ctx context.Context, targetProjectId string, targetDomainId string, resourceType string, delta int) error {
+	max := options.Options.MaxSharedImageGrantsPerProject
+	if max <= 0 || !utils.IsInStringArray(resourceType, sharedImageResourceTypes) {
+		return nil
+	}
+	q := db.SharedResourceManager.Query().
+		Equals("target_project_id", targetProjectId).
+		In("resource_type", sharedImageResourceTypes)
+	current, err := q.CountWithError()
+	if err != nil {
+		return errors.Wrap(err, "count inbound shares")
+	}
+	return validateSharedGrantCount(current, delta, max)
+}
+
+// validateSharedGrantCount rejects a grant that would push the target
+// project beyond max inbound image shares
+func validateSharedGrantCount(current int, delta int, max int) error {
+	if current+delta > max {
+		return fmt.Errorf("project already holds %d of at most %d shared image resources", current, max)
+	}
+	return nil
+}
@@ -16,11 +16,14 @@ package models
 
 import (
 	"context"
+	"strings"
 
 	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/utils"
 
 	api "yunion.io/x/onecloud/pkg/apis/compute"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
+	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
 )
 
@@ -34,6 +37,10 @@ type IStorageDriver interface {
 
 	PostCreate(ctx context.Context, userCred mcclient.TokenCredential, storage *SStorage, data jsonutils.JSONObject)
 
+	// GetCrossRestoreStorageTypes lists the storage types, besides its
+	// own, a snapshot kept on this storage may be restored onto
+	GetCrossRestoreStorageTypes() []string
+
 	ValidateSnapshotDelete(ctx context.Context, snapshot *SSnapshot) error
 	ValidateCreateSnapshotData(ctx context.Context, userCred mcclient.TokenCredential, disk *SDisk, input *api.SnapshotCreateInput) error
 	RequestCreateSnapshot(ctx context.Context, snapshot *SSnapshot, task taskman.ITask) error
@@ -59,3 +66,32 @@ func GetStorageDriver(storageType string) IStorageDriver {
 	}
 	return nil
 }
+
+// allowedSnapshotRestoreBackends lists the storage types a snapshot
+// kept on snapshotStorageType may be restored onto: the type itself
+// plus whatever its driver declares as cross-restore compatible
+func allowedSnapshotRestoreBackends(snapshotStorageType string) []string {
+	allowed := []string{snapshotStorageType}
+	if driver := GetStorageDriver(snapshotStorageType); driver != nil {
+		for _, storageType := range driver.GetCrossRestoreStorageTypes() {
+			if !utils.IsInStringArray(storageType, allowed) {
+				allowed = append(allowed, storageType)
+			}
+		}
+	}
+	return allowed
+}
+
+// validateSnapshotRestoreTarget rejects restoring a snapshot onto an
+// incompatible storage backend or into a disk smaller than the
+// snapshot, naming the allowed storage types in the error
+func validateSnapshotRestoreTarget(snapshotStorageType string, allowed []string, backend string, snapshotSizeMb int, requestSizeMb int) error {
+	if len(backend) > 0 && !utils.IsInStringArray(backend, allowed) {
+		return httperrors.NewInputParameterError("cannot restore snapshot on %s storage onto %s storage, allowed storage types: %s",
+			snapshotStorageType, backend, strings.Join(allowed, ","))
+	}
+	if requestSizeMb > 0 && requestSizeMb < snapshotSizeMb {
+		return httperrors.NewInputParameterError("disk size %dMB is smaller than snapshot size %dMB", requestSizeMb, snapshotSizeMb)
+	}
+	return nil
+}
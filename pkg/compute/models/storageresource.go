@@ -211,10 +211,47 @@ func (manager *SStorageResourceBaseManager) GetOrderBySubQuery(
 			orders = append(orders, query.OrderByStorage)
 			fields = append(fields, subq.Field("name"))
 		}
+		if db.NeedOrderQuery([]string{query.OrderByStorageFreeCapacity, query.OrderByStorageUsedPercent}) {
+			capQ := StorageManager.Query("id", "capacity", "reserved", "cmtbound").SubQuery()
+			disks := DiskManager.Query().SubQuery()
+			usedQ := disks.Query(
+				disks.Field("storage_id"),
+				sqlchemy.SUM("used_capacity", disks.Field("disk_size")),
+			).GroupBy(disks.Field("storage_id")).SubQuery()
+			q = q.LeftJoin(capQ, sqlchemy.Equals(q.Field("storage_id"), capQ.Field("id")))
+			q = q.LeftJoin(usedQ, sqlchemy.Equals(q.Field("storage_id"), usedQ.Field("storage_id")))
+			if db.NeedOrderQuery([]string{query.OrderByStorageFreeCapacity}) {
+				orders = append(orders, query.OrderByStorageFreeCapacity)
+				fields = append(fields, sqlchemy.NewFunctionField("storage_free_capacity",
+					"(%s-%s)*%s-COALESCE(%s,0)",
+					capQ.Field("capacity"), capQ.Field("reserved"), capQ.Field("cmtbound"), usedQ.Field("used_capacity")))
+			}
+			if db.NeedOrderQuery([]string{query.OrderByStorageUsedPercent}) {
+				orders = append(orders, query.OrderByStorageUsedPercent)
+				fields = append(fields, sqlchemy.NewFunctionField("storage_used_percent",
+					"COALESCE(%s,0)/((%s-%s)*%s)",
+					usedQ.Field("used_capacity"), capQ.Field("capacity"), capQ.Field("reserved"), capQ.Field("cmtbound")))
+			}
+		}
 	}
 	return q, orders, fields
 }
 
+// storageFreeCapacity and storageUsedPercent mirror the SQL expressions
+// GetOrderBySubQuery registers for order_by_storage_free_capacity and
+// order_by_storage_used_percent, so their semantics can be pinned by tests
+func storageFreeCapacity(capacityMb int64, reservedMb int64, cmtbound float32, usedMb int64) float64 {
+	return float64(capacityMb-reservedMb)*float64(cmtbound) - float64(usedMb)
+}
+
+func storageUsedPercent(capacityMb int64, reservedMb int64, cmtbound float32, usedMb int64) float64 {
+	virtual := float64(capacityMb-reservedMb) * float64(cmtbound)
+	if virtual <= 0 {
+		return 0
+	}
+	return float64(usedMb) / virtual
+}
+
 func (manager *SStorageResourceBaseManager) GetOrderByFields(query api.StorageFilterListInput) []string {
 	fields := make([]string, 0)
 	zoneFields := manager.SZoneResourceBaseManager.GetOrderByFields(query.ZonalFilterListInput)
@@ -222,5 +259,7 @@ func (manager *SStorageResourceBaseManager) GetOrderByFields(query api.StorageFi
 	managerFields := manager.SManagedResourceBaseManager.GetOrderByFields(query.ManagedResourceListInput)
 	fields = append(fields, managerFields...)
 	fields = append(fields, query.OrderByStorage)
+	fields = append(fields, query.OrderByStorageFreeCapacity)
+	fields = append(fields, query.OrderByStorageUsedPercent)
 	return fields
 }
@@ -0,0 +1,93 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"sort"
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+func TestStorageCapacityOrdering(t *testing.T) {
+	// three storages with distinct utilization: nearly empty, half
+	// used within an over-commit bound, and nearly full
+	storages := []struct {
+		name     string
+		capacity int64
+		reserved int64
+		cmtbound float32
+		used     int64
+	}{
+		{"hdd-empty", 102400, 0, 1.0, 1024},
+		{"ssd-half", 102400, 2048, 2.0, 51200},
+		{"hdd-full", 102400, 0, 1.0, 98304},
+	}
+
+	byFree := append(storages[:0:0], storages...)
+	sort.Slice(byFree, func(i, j int) bool {
+		a, b := byFree[i], byFree[j]
+		return storageFreeCapacity(a.capacity, a.reserved, a.cmtbound, a.used) >
+			storageFreeCapacity(b.capacity, b.reserved, b.cmtbound, b.used)
+	})
+	wantFree := []string{"ssd-half", "hdd-empty", "hdd-full"}
+	for i := range wantFree {
+		if byFree[i].name != wantFree[i] {
+			t.Fatalf("order by free capacity desc: got %s at %d, want %v", byFree[i].name, i, wantFree)
+		}
+	}
+
+	byUsed := append(storages[:0:0], storages...)
+	sort.Slice(byUsed, func(i, j int) bool {
+		a, b := byUsed[i], byUsed[j]
+		return storageUsedPercent(a.capacity, a.reserved, a.cmtbound, a.used) <
+			storageUsedPercent(b.capacity, b.reserved, b.cmtbound, b.used)
+	})
+	wantUsed := []string{"hdd-empty", "ssd-half", "hdd-full"}
+	for i := range wantUsed {
+		if byUsed[i].name != wantUsed[i] {
+			t.Fatalf("order by used percent asc: got %s at %d, want %v", byUsed[i].name, i, wantUsed)
+		}
+	}
+}
+
+func TestStorageUsedPercentDegenerate(t *testing.T) {
+	if got := storageUsedPercent(0, 0, 1.0, 1024); got != 0 {
+		t.Errorf("storageUsedPercent with zero capacity = %v, want 0", got)
+	}
+	if got := storageUsedPercent(1024, 1024, 2.0, 512); got != 0 {
+		t.Errorf("storageUsedPercent with fully reserved capacity = %v, want 0", got)
+	}
+}
+
+func TestStorageOrderByFields(t *testing.T) {
+	man := &SStorageResourceBaseManager{}
+	query := api.StorageFilterListInput{}
+	query.OrderByStorageFreeCapacity = "desc"
+	query.OrderByStorageUsedPercent = "asc"
+	fields := man.GetOrderByFields(query)
+	foundDesc, foundAsc := false, false
+	for _, f := range fields {
+		switch f {
+		case "desc":
+			foundDesc = true
+		case "asc":
+			foundAsc = true
+		}
+	}
+	if !foundDesc || !foundAsc {
+		t.Errorf("GetOrderByFields misses new capacity order fields: %v", fields)
+	}
+}
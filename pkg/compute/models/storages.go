@@ -553,6 +553,34 @@ func (self *SStorage) GetFreeCapacity() int64 {
 	return int64(float32(self.GetCapacity())*self.GetOvercommitBound()) - self.GetUsedCapacity(tristate.None)
 }
 
+// storageHasFreeCapacity reports whether used capacity stays under the
+// over-commit bound, the same headroom condition the scheduler applies
+func storageHasFreeCapacity(capacityMb int64, cmtbound float32, usedMb int64) bool {
+	return float64(usedMb) < float64(capacityMb)*float64(cmtbound)
+}
+
+// storageIdsWithFreeCapacity returns the ids of enabled online
+// storages that still have capacity headroom within the over-commit
+// bound, the same condition the scheduler applies before placing a
+// disk; the host-side usability conditions stay in SQL at the caller
+func storageIdsWithFreeCapacity() ([]string, error) {
+	q := StorageManager.Query()
+	q = q.Filter(sqlchemy.IsTrue(q.Field("enabled")))
+	q = q.Filter(sqlchemy.In(q.Field("status"), []string{api.STORAGE_ENABLED, api.STORAGE_ONLINE}))
+	storages := make([]SStorage, 0)
+	if err := db.FetchModelObjects(StorageManager, q, &storages); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(storages))
+	for i := range storages {
+		storage := &storages[i]
+		if storageHasFreeCapacity(storage.GetCapacity(), storage.GetOvercommitBound(), storage.GetUsedCapacity(tristate.None)) {
+			ids = append(ids, storage.Id)
+		}
+	}
+	return ids, nil
+}
+
 func (self *SStorage) GetAttachedHosts() []SHost {
 	hosts := HostManager.Query().SubQuery()
 	hoststorages := HoststorageManager.Query().SubQuery()
@@ -1212,6 +1240,18 @@ func (manager *SStorageManager) ListItemFilter(
 			)).
 			Filter(sqlchemy.In(q.Field("status"), []string{api.STORAGE_ENABLED, api.STORAGE_ONLINE})).
 			Filter(sqlchemy.IsTrue(q.Field("enabled")))
+
+		// the scheduler additionally requires capacity headroom within
+		// the over-commit bound (cmtbound) before placing a disk
+		headroomIds, err := storageIdsWithFreeCapacity()
+		if err != nil {
+			return nil, errors.Wrap(err, "storageIdsWithFreeCapacity")
+		}
+		if len(headroomIds) > 0 {
+			q = q.Filter(sqlchemy.In(q.Field("id"), headroomIds))
+		} else {
+			q = q.Filter(sqlchemy.IsNull(q.Field("id")))
+		}
 	}
 
 	return q, err
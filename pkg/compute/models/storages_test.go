@@ -0,0 +1,40 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import "testing"
+
+func TestStorageHasFreeCapacity(t *testing.T) {
+	cases := []struct {
+		name     string
+		capacity int64
+		cmtbound float32
+		used     int64
+		want     bool
+	}{
+		{"empty storage", 102400, 1.0, 0, true},
+		{"partially used", 102400, 1.0, 51200, true},
+		{"exactly full", 102400, 1.0, 102400, false},
+		{"over used", 102400, 1.0, 204800, false},
+		{"overcommit allows more", 102400, 2.0, 153600, true},
+		{"overcommit bound reached", 102400, 2.0, 204800, false},
+	}
+	for _, c := range cases {
+		if got := storageHasFreeCapacity(c.capacity, c.cmtbound, c.used); got != c.want {
+			t.Errorf("%s: storageHasFreeCapacity(%d, %v, %d) = %v, want %v",
+				c.name, c.capacity, c.cmtbound, c.used, got, c.want)
+		}
+	}
+}
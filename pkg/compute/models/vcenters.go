@@ -16,12 +16,24 @@ package models
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+	"yunion.io/x/sqlchemy"
 
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
+	"yunion.io/x/onecloud/pkg/cloudprovider"
+	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/rbacutils"
+	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
 
 type SVCenterManager struct {
@@ -60,3 +72,296 @@ type SVCenter struct {
 func (manager *SVCenterManager) AllowCreateItem(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
 	return false
 }
+
+// maxVCenterSyncErrorLen caps the error message column of the vcenter
+// list so one long provider error does not break the table layout
+const maxVCenterSyncErrorLen = 128
+
+// vcenterSyncStatus condenses the paired cloudprovider state into the
+// coarse success/failed/syncing the list shows, with a short error
+// message for the failed case
+func vcenterSyncStatus(syncStatus string, healthStatus string) (string, string) {
+	switch syncStatus {
+	case api.CLOUD_PROVIDER_SYNC_STATUS_QUEUING, api.CLOUD_PROVIDER_SYNC_STATUS_QUEUED, api.CLOUD_PROVIDER_SYNC_STATUS_SYNCING:
+		return api.VCENTER_SYNC_STATUS_SYNCING, ""
+	case api.CLOUD_PROVIDER_SYNC_STATUS_ERROR:
+		return api.VCENTER_SYNC_STATUS_FAILED, truncateSyncError(fmt.Sprintf("sync error, health status %s", healthStatus))
+	}
+	if len(healthStatus) > 0 && healthStatus != api.CLOUD_PROVIDER_HEALTH_NORMAL {
+		return api.VCENTER_SYNC_STATUS_FAILED, truncateSyncError(fmt.Sprintf("health status %s", healthStatus))
+	}
+	return api.VCENTER_SYNC_STATUS_SUCCESS, ""
+}
+
+// truncateSyncError shortens an error message to the listable length
+func truncateSyncError(msg string) string {
+	if len(msg) > maxVCenterSyncErrorLen {
+		return msg[:maxVCenterSyncErrorLen-3] + "..."
+	}
+	return msg
+}
+
+// sVCenterResourceCount is one row of the grouped host/guest count
+// queries, keyed by the managing vcenter
+type sVCenterResourceCount struct {
+	ManagerId string
+	Count     int
+}
+
+// vcenterCountMap folds grouped count rows into a per-vcenter lookup
+func vcenterCountMap(rows []sVCenterResourceCount) map[string]int {
+	counts := make(map[string]int, len(rows))
+	for _, row := range rows {
+		if len(row.ManagerId) == 0 {
+			continue
+		}
+		counts[row.ManagerId] = row.Count
+	}
+	return counts
+}
+
+// vcenterResourceCounts tallies hosts and guests per vcenter with two
+// grouped queries instead of one pair of queries per row. Guests are
+// counted within the caller's scope.
+func (manager *SVCenterManager) vcenterResourceCounts(vcenterIds []string, userCred mcclient.TokenCredential) (map[string]int, map[string]int) {
+	hosts := HostManager.Query().SubQuery()
+	hq := hosts.Query(hosts.Field("manager_id"), sqlchemy.COUNT("count"))
+	hq = hq.Filter(sqlchemy.In(hosts.Field("manager_id"), vcenterIds))
+	hq = hq.GroupBy(hosts.Field("manager_id"))
+	hostRows := make([]sVCenterResourceCount, 0)
+	if err := hq.All(&hostRows); err != nil {
+		log.Errorf("query vcenter host counts: %v", err)
+	}
+
+	guests := GuestManager.Query().SubQuery()
+	gq := guests.Query(hosts.Field("manager_id"), sqlchemy.COUNT("count"))
+	gq = gq.Join(hosts, sqlchemy.Equals(guests.Field("host_id"), hosts.Field("id")))
+	gq = gq.Filter(sqlchemy.In(hosts.Field("manager_id"), vcenterIds))
+	if consts.IsRbacEnabled() {
+		allowScope := policy.PolicyManager.AllowScope(userCred, consts.GetServiceType(), GuestManager.KeywordPlural(), policy.PolicyActionList)
+		if allowScope != rbacutils.ScopeSystem && allowScope != rbacutils.ScopeDomain {
+			gq = gq.Filter(sqlchemy.Equals(guests.Field("tenant_id"), userCred.GetProjectId()))
+		}
+	}
+	gq = gq.GroupBy(hosts.Field("manager_id"))
+	guestRows := make([]sVCenterResourceCount, 0)
+	if err := gq.All(&guestRows); err != nil {
+		log.Errorf("query vcenter guest counts: %v", err)
+	}
+
+	return vcenterCountMap(hostRows), vcenterCountMap(guestRows)
+}
+
+func (manager *SVCenterManager) FetchCustomizeColumns(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	objs []interface{},
+	fields stringutils2.SSortedStrings,
+	isList bool,
+) []api.VCenterDetails {
+	rows := make([]api.VCenterDetails, len(objs))
+
+	stdRows := manager.SEnabledStatusStandaloneResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	vcIds := make([]string, len(objs))
+	for i := range rows {
+		vc := objs[i].(*SVCenter)
+		rows[i] = api.VCenterDetails{
+			EnabledStatusStandaloneResourceDetails: stdRows[i],
+			LastSync:                               vc.LastSync,
+		}
+		vcIds[i] = vc.Id
+	}
+
+	hostCounts, guestCounts := manager.vcenterResourceCounts(vcIds, userCred)
+
+	// the vmware cloudprovider shares the vcenter id and carries the
+	// live sync state
+	providers := make(map[string]SCloudprovider)
+	if err := db.FetchStandaloneObjectsByIds(CloudproviderManager, vcIds, &providers); err != nil {
+		log.Errorf("fetch cloudproviders of vcenters: %v", err)
+	}
+
+	for i := range rows {
+		rows[i].HostCount = hostCounts[vcIds[i]]
+		rows[i].GuestCount = guestCounts[vcIds[i]]
+		if provider, ok := providers[vcIds[i]]; ok {
+			rows[i].LastSyncStatus, rows[i].LastSyncError = vcenterSyncStatus(provider.SyncStatus, provider.HealthStatus)
+			if !provider.LastSync.IsZero() {
+				rows[i].LastSync = provider.LastSync
+			}
+		} else {
+			rows[i].LastSyncStatus, rows[i].LastSyncError = vcenterSyncStatus("", "")
+		}
+	}
+
+	return rows
+}
+
+func (self *SVCenter) getPassword() (string, error) {
+	return utils.DescryptAESBase64(self.Id, self.Password)
+}
+
+// GetProvider connects to the vcenter with the stored credentials
+func (self *SVCenter) GetProvider() (cloudprovider.ICloudProvider, error) {
+	if self.Status == api.CLOUD_PROVIDER_DISCONNECTED {
+		return nil, httperrors.NewInvalidStatusError("vcenter %s is disconnected", self.Name)
+	}
+	passwd, err := self.getPassword()
+	if err != nil {
+		return nil, errors.Wrap(err, "getPassword")
+	}
+	accessUrl := fmt.Sprintf("https://%s:%d", self.Hostname, self.Port)
+	return cloudprovider.GetProvider(self.Id, self.Name, accessUrl, self.Account, passwd, api.CLOUD_PROVIDER_VMWARE)
+}
+
+func (self *SVCenter) getIHosts(driver cloudprovider.ICloudProvider) ([]cloudprovider.ICloudHost, error) {
+	iregion, err := driver.GetOnPremiseIRegion()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetOnPremiseIRegion")
+	}
+	return iregion.GetIHosts()
+}
+
+// findLocalHost matches a discovered host against hosts already
+// imported from this vcenter, nil when not yet imported
+func (self *SVCenter) findLocalHost(ihost cloudprovider.ICloudHost) *SHost {
+	host := SHost{}
+	host.SetModelManager(HostManager, &host)
+	q := HostManager.Query().Equals("manager_id", self.Id).Equals("external_id", ihost.GetGlobalId())
+	err := q.First(&host)
+	if err != nil {
+		return nil
+	}
+	return &host
+}
+
+func (self *SVCenter) AllowGetDetailsHosts(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return db.IsAdminAllowGetSpec(userCred, self, "hosts")
+}
+
+// GetDetailsHosts lists the ESXi hosts discovered on the vcenter along
+// with whether each has already been imported as a local host
+func (self *SVCenter) GetDetailsHosts(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	driver, err := self.GetProvider()
+	if err != nil {
+		return nil, err
+	}
+	ihosts, err := self.getIHosts(driver)
+	if err != nil {
+		return nil, errors.Wrap(err, "getIHosts")
+	}
+	output := api.VCenterGetHostsOutput{
+		Hosts: make([]api.VCenterDiscoveredHost, 0, len(ihosts)),
+	}
+	for i := range ihosts {
+		host := api.VCenterDiscoveredHost{
+			Name: ihosts[i].GetName(),
+			Ip:   ihosts[i].GetAccessIp(),
+		}
+		if clusterHost, ok := ihosts[i].(interface{ GetClusterName() string }); ok {
+			host.Cluster = clusterHost.GetClusterName()
+		}
+		if local := self.findLocalHost(ihosts[i]); local != nil {
+			host.Imported = true
+			host.HostId = local.Id
+		}
+		output.Hosts = append(output.Hosts, host)
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (self *SVCenter) AllowPerformImportHost(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, self, "import-host")
+}
+
+// PerformImportHost imports a single discovered ESXi host, reusing the
+// host sync code path restricted to that host. The imported VMs may be
+// assigned to an explicit project.
+func (self *SVCenter) PerformImportHost(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := api.VCenterImportHostInput{}
+	if err := data.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if len(input.HostIp) == 0 {
+		return nil, httperrors.NewMissingParameterError("host_ip")
+	}
+	provider := CloudproviderManager.FetchCloudproviderById(self.Id)
+	if provider == nil {
+		return nil, httperrors.NewInvalidStatusError("vcenter %s has no cloudprovider", self.Name)
+	}
+	driver, err := self.GetProvider()
+	if err != nil {
+		return nil, err
+	}
+	ihosts, err := self.getIHosts(driver)
+	if err != nil {
+		return nil, errors.Wrap(err, "getIHosts")
+	}
+	var target cloudprovider.ICloudHost
+	for i := range ihosts {
+		if ihosts[i].GetAccessIp() == input.HostIp {
+			target = ihosts[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, httperrors.NewResourceNotFoundError("host %s not found on vcenter %s", input.HostIp, self.Name)
+	}
+	if local := self.findLocalHost(target); local != nil {
+		return nil, httperrors.NewDuplicateResourceError("host %s already imported as %s", input.HostIp, local.Name)
+	}
+
+	var syncOwnerId mcclient.IIdentityProvider = provider.GetOwnerId()
+	if len(input.Project) > 0 {
+		tenant, err := db.TenantCacheManager.FetchTenantByIdOrName(ctx, input.Project)
+		if err != nil {
+			return nil, httperrors.NewResourceNotFoundError("project %s not found", input.Project)
+		}
+		syncOwnerId = &db.SOwnerId{DomainId: tenant.DomainId, ProjectId: tenant.Id}
+	}
+
+	localHost, err := self.importOneHost(ctx, userCred, provider, driver, target, syncOwnerId)
+	if err != nil {
+		return nil, err
+	}
+	return jsonutils.Marshal(map[string]string{"host_id": localHost.Id}), nil
+}
+
+// importOneHost runs the host sync pipeline for exactly one remote
+// host: the host record, its storages, nics and virtual machines
+func (self *SVCenter) importOneHost(ctx context.Context, userCred mcclient.TokenCredential, provider *SCloudprovider, driver cloudprovider.ICloudProvider, ihost cloudprovider.ICloudHost, syncOwnerId mcclient.IIdentityProvider) (*SHost, error) {
+	syncResults := SSyncResultSet{}
+	localHosts, remoteHosts, result := HostManager.SyncHosts(ctx, userCred, provider, nil, []cloudprovider.ICloudHost{ihost})
+	if result.IsError() {
+		return nil, httperrors.NewInternalServerError("sync host: %s", result.Result())
+	}
+	if len(localHosts) == 0 {
+		return nil, httperrors.NewInternalServerError("sync host %s yields no local host", ihost.GetName())
+	}
+	localHost := &localHosts[0]
+	remoteHost := remoteHosts[0]
+
+	storageCachePairs := syncHostStorages(ctx, userCred, syncResults, provider, localHost, remoteHost, nil)
+	syncHostNics(ctx, userCred, provider, localHost, remoteHost)
+
+	vms, err := remoteHost.GetIVMs()
+	if err != nil {
+		return nil, errors.Wrap(err, "GetIVMs")
+	}
+	syncVMPairs, vmResult := localHost.SyncHostVMs(ctx, userCred, driver, vms, syncOwnerId)
+	if vmResult.IsError() {
+		return nil, httperrors.NewInternalServerError("sync host vms: %s", vmResult.Result())
+	}
+	for i := range syncVMPairs {
+		if !syncVMPairs[i].IsNew {
+			continue
+		}
+		syncVMPeripherals(ctx, userCred, syncVMPairs[i].Local, syncVMPairs[i].Remote, localHost, provider, driver)
+	}
+	for i := range storageCachePairs {
+		result := storageCachePairs[i].syncCloudImages(ctx, userCred)
+		syncResults.Add(StoragecachedimageManager, result)
+	}
+	return localHost, nil
+}
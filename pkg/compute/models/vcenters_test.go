@@ -0,0 +1,82 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+func TestVCenterCountMap(t *testing.T) {
+	rows := []sVCenterResourceCount{
+		{ManagerId: "vc-1", Count: 3},
+		{ManagerId: "vc-2", Count: 12},
+		{ManagerId: "", Count: 5},
+	}
+	counts := vcenterCountMap(rows)
+	if counts["vc-1"] != 3 || counts["vc-2"] != 12 {
+		t.Errorf("counts = %v, want vc-1:3 vc-2:12", counts)
+	}
+	if _, ok := counts[""]; ok {
+		t.Errorf("rows without a manager must be dropped")
+	}
+	// a vcenter missing from the grouped result simply has zero
+	if counts["vc-3"] != 0 {
+		t.Errorf("missing vcenter must count 0, got %d", counts["vc-3"])
+	}
+}
+
+func TestVCenterSyncStatus(t *testing.T) {
+	cases := []struct {
+		name         string
+		syncStatus   string
+		healthStatus string
+		wantStatus   string
+		wantError    bool
+	}{
+		{"idle healthy", api.CLOUD_PROVIDER_SYNC_STATUS_IDLE, api.CLOUD_PROVIDER_HEALTH_NORMAL, api.VCENTER_SYNC_STATUS_SUCCESS, false},
+		{"queued", api.CLOUD_PROVIDER_SYNC_STATUS_QUEUED, api.CLOUD_PROVIDER_HEALTH_NORMAL, api.VCENTER_SYNC_STATUS_SYNCING, false},
+		{"syncing", api.CLOUD_PROVIDER_SYNC_STATUS_SYNCING, api.CLOUD_PROVIDER_HEALTH_NORMAL, api.VCENTER_SYNC_STATUS_SYNCING, false},
+		{"sync error", api.CLOUD_PROVIDER_SYNC_STATUS_ERROR, api.CLOUD_PROVIDER_HEALTH_UNKNOWN, api.VCENTER_SYNC_STATUS_FAILED, true},
+		{"idle unhealthy", api.CLOUD_PROVIDER_SYNC_STATUS_IDLE, api.CLOUD_PROVIDER_HEALTH_NO_PERMISSION, api.VCENTER_SYNC_STATUS_FAILED, true},
+		{"no provider state", "", "", api.VCENTER_SYNC_STATUS_SUCCESS, false},
+	}
+	for _, c := range cases {
+		status, msg := vcenterSyncStatus(c.syncStatus, c.healthStatus)
+		if status != c.wantStatus {
+			t.Errorf("%s: status = %s, want %s", c.name, status, c.wantStatus)
+		}
+		if (len(msg) > 0) != c.wantError {
+			t.Errorf("%s: error message = %q, want error %v", c.name, msg, c.wantError)
+		}
+	}
+}
+
+func TestTruncateSyncError(t *testing.T) {
+	short := "sync error"
+	if got := truncateSyncError(short); got != short {
+		t.Errorf("short message must pass through, got %q", got)
+	}
+	long := strings.Repeat("x", 2*maxVCenterSyncErrorLen)
+	got := truncateSyncError(long)
+	if len(got) != maxVCenterSyncErrorLen {
+		t.Errorf("len = %d, want %d", len(got), maxVCenterSyncErrorLen)
+	}
+	if !strings.HasSuffix(got, "...") {
+		t.Errorf("truncated message must end with ellipsis, got %q", got[len(got)-8:])
+	}
+}
@@ -466,6 +466,7 @@ func (self *SVpc) SyncGlobalVpc(ctx context.Context, userCred mcclient.TokenCred
 			}
 			gv.SetEnabled(true)
 			gv.Status = api.GLOBAL_VPC_STATUS_AVAILABLE
+			gv.Provider = self.GetProviderName()
 			gv.SetModelManager(GlobalVpcManager, gv)
 			err = GlobalVpcManager.TableSpec().Insert(gv)
 			if err != nil {
@@ -477,7 +478,17 @@ func (self *SVpc) SyncGlobalVpc(ctx context.Context, userCred mcclient.TokenCred
 			self.GlobalvpcId = globalvpcId
 			return nil
 		})
-		return err
+		if err != nil {
+			return err
+		}
+		if gv, _ := self.GetGlobalVpc(); gv != nil {
+			// backfill the provider of global vpcs created before the
+			// column existed
+			if err := gv.setProviderOnAttach(ctx, self.GetProviderName()); err != nil {
+				log.Errorf("set provider of globalvpc %s: %v", gv.Name, err)
+			}
+		}
+		return nil
 	}
 	return nil
 }
@@ -593,6 +604,7 @@ func (manager *SVpcManager) ValidateCreateData(ctx context.Context, userCred mcc
 	}
 	region := regionObj.(*SCloudregion)
 	data.Add(jsonutils.NewString(region.GetId()), "cloudregion_id")
+	provider := api.CLOUD_PROVIDER_ONECLOUD
 	if region.isManaged() {
 		managerStr := jsonutils.GetAnyString(data, []string{"manager_id", "manager"})
 		if len(managerStr) == 0 {
@@ -607,10 +619,28 @@ func (manager *SVpcManager) ValidateCreateData(ctx context.Context, userCred mcc
 			}
 		}
 		data.Add(jsonutils.NewString(managerObj.GetId()), "manager_id")
+		provider = managerObj.(*SCloudprovider).Provider
 	} else {
 		data.Set("status", jsonutils.NewString(api.VPC_STATUS_AVAILABLE))
 	}
 
+	globalVpcStr := jsonutils.GetAnyString(data, []string{"globalvpc", "globalvpc_id"})
+	if len(globalVpcStr) > 0 {
+		globalVpcObj, err := GlobalVpcManager.FetchByIdOrName(userCred, globalVpcStr)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, httperrors.NewResourceNotFoundError2(GlobalVpcManager.Keyword(), globalVpcStr)
+			} else {
+				return nil, httperrors.NewGeneralError(err)
+			}
+		}
+		globalVpc := globalVpcObj.(*SGlobalVpc)
+		if err := globalVpc.ValidateAttachVpc(provider); err != nil {
+			return nil, err
+		}
+		data.Set("globalvpc_id", jsonutils.NewString(globalVpc.GetId()))
+	}
+
 	cidrBlock, _ := data.GetString("cidr_block")
 	if len(cidrBlock) > 0 {
 		blocks := strings.Split(cidrBlock, ",")
@@ -636,7 +666,45 @@ func (manager *SVpcManager) ValidateCreateData(ctx context.Context, userCred mcc
 	return region.GetDriver().ValidateCreateVpcData(ctx, userCred, data)
 }
 
+func (self *SVpc) ValidateUpdateData(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
+	globalVpcStr := jsonutils.GetAnyString(data, []string{"globalvpc", "globalvpc_id"})
+	if len(globalVpcStr) > 0 {
+		globalVpcObj, err := GlobalVpcManager.FetchByIdOrName(userCred, globalVpcStr)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, httperrors.NewResourceNotFoundError2(GlobalVpcManager.Keyword(), globalVpcStr)
+			} else {
+				return nil, httperrors.NewGeneralError(err)
+			}
+		}
+		globalVpc := globalVpcObj.(*SGlobalVpc)
+		if err := globalVpc.ValidateAttachVpc(self.GetProviderName()); err != nil {
+			return nil, err
+		}
+		data.Set("globalvpc_id", jsonutils.NewString(globalVpc.GetId()))
+	}
+	return self.SEnabledStatusStandaloneResourceBase.ValidateUpdateData(ctx, userCred, query, data)
+}
+
+func (self *SVpc) PostUpdate(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	self.SEnabledStatusStandaloneResourceBase.PostUpdate(ctx, userCred, query, data)
+	if data.Contains("globalvpc_id") && len(self.GlobalvpcId) > 0 {
+		if gv, _ := self.GetGlobalVpc(); gv != nil {
+			if err := gv.setProviderOnAttach(ctx, self.GetProviderName()); err != nil {
+				log.Errorf("set provider of globalvpc %s: %v", gv.Name, err)
+			}
+		}
+	}
+}
+
 func (self *SVpc) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	if len(self.GlobalvpcId) > 0 {
+		if gv, _ := self.GetGlobalVpc(); gv != nil {
+			if err := gv.setProviderOnAttach(ctx, self.GetProviderName()); err != nil {
+				log.Errorf("set provider of globalvpc %s: %v", gv.Name, err)
+			}
+		}
+	}
 	if len(self.ManagerId) == 0 {
 		return
 	}
@@ -724,7 +792,18 @@ func (self *SVpc) RealDelete(ctx context.Context, userCred mcclient.TokenCredent
 		}
 	}
 
-	return self.SEnabledStatusStandaloneResourceBase.Delete(ctx, userCred)
+	err = self.SEnabledStatusStandaloneResourceBase.Delete(ctx, userCred)
+	if err != nil {
+		return err
+	}
+	if len(self.GlobalvpcId) > 0 {
+		if gv, _ := self.GetGlobalVpc(); gv != nil {
+			if err := gv.clearProviderIfEmpty(ctx); err != nil {
+				log.Errorf("clear provider of globalvpc %s: %v", gv.Name, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (self *SVpc) StartDeleteVpcTask(ctx context.Context, userCred mcclient.TokenCredential) error {
@@ -0,0 +1,136 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/tristate"
+	"yunion.io/x/pkg/utils"
+	"yunion.io/x/sqlchemy"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// getZoneHostTypes returns the distinct host types of enabled hosts in
+// the zone
+func getZoneHostTypes(zone *SZone) []string {
+	q := HostManager.Query("host_type").Equals("zone_id", zone.Id)
+	q = q.IsNotEmpty("host_type").IsNotNull("host_type")
+	q = q.IsTrue("enabled")
+	q = q.Distinct()
+	rows, err := q.Rows()
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	hostTypes := make([]string, 0)
+	for rows.Next() {
+		var hostType string
+		rows.Scan(&hostType)
+		if len(hostType) > 0 && !utils.IsInStringArray(hostType, hostTypes) {
+			hostTypes = append(hostTypes, hostType)
+		}
+	}
+	return hostTypes
+}
+
+// getZoneAvailableStorageTypes returns the distinct storage types in
+// the zone that still have capacity headroom within the over-commit
+// bound, the same condition the scheduler applies
+func getZoneAvailableStorageTypes(zone *SZone) ([]string, error) {
+	q := StorageManager.Query().Equals("zone_id", zone.Id)
+	q = q.Filter(sqlchemy.IsTrue(q.Field("enabled")))
+	q = q.Filter(sqlchemy.In(q.Field("status"), []string{api.STORAGE_ENABLED, api.STORAGE_ONLINE}))
+	storages := make([]SStorage, 0)
+	if err := db.FetchModelObjects(StorageManager, q, &storages); err != nil {
+		return nil, err
+	}
+	storageTypes := make([]string, 0)
+	for i := range storages {
+		storage := &storages[i]
+		if !storageHasFreeCapacity(storage.GetCapacity(), storage.GetOvercommitBound(), storage.GetUsedCapacity(tristate.None)) {
+			continue
+		}
+		if !utils.IsInStringArray(storage.StorageType, storageTypes) {
+			storageTypes = append(storageTypes, storage.StorageType)
+		}
+	}
+	return storageTypes, nil
+}
+
+// filterVisibleNetworks keeps the networks the caller may allocate
+// from: owned by the caller's project or shared into its visibility
+func filterVisibleNetworks(networks []SNetwork, userCred mcclient.TokenCredential) []SNetwork {
+	visible := make([]SNetwork, 0, len(networks))
+	for i := range networks {
+		if networks[i].IsOwner(userCred) || networks[i].IsSharable(userCred) {
+			visible = append(visible, networks[i])
+		}
+	}
+	return visible
+}
+
+// getZoneFreeNetworkIpCount sums the free addresses of available
+// auto-alloc guest networks in the zone visible to the caller
+func getZoneFreeNetworkIpCount(zone *SZone, userCred mcclient.TokenCredential) (int, error) {
+	wires := WireManager.Query().SubQuery()
+	q := NetworkManager.Query()
+	q = q.Join(wires, sqlchemy.Equals(q.Field("wire_id"), wires.Field("id")))
+	q = q.Filter(sqlchemy.Equals(wires.Field("zone_id"), zone.Id))
+	q = q.Equals("server_type", api.NETWORK_TYPE_GUEST)
+	q = q.Equals("status", api.NETWORK_STATUS_AVAILABLE)
+	networks := make([]SNetwork, 0)
+	if err := db.FetchModelObjects(NetworkManager, q, &networks); err != nil {
+		return -1, err
+	}
+	freeCount := 0
+	for _, network := range filterVisibleNetworks(networks, userCred) {
+		cnt, err := network.getFreeAddressCount()
+		if err != nil {
+			log.Errorf("getFreeAddressCount for network %s: %v", network.Id, err)
+			continue
+		}
+		if cnt > 0 {
+			freeCount += cnt
+		}
+	}
+	return freeCount, nil
+}
+
+// getZoneCapability aggregates what is actually usable in the zone:
+// enabled host types and hypervisors, storage types with free
+// capacity, free IPs in auto-alloc networks and GPU device models
+func (self *SZone) getZoneCapability(ctx context.Context, userCred mcclient.TokenCredential) (api.ZoneCapability, error) {
+	capa := api.ZoneCapability{}
+	domainId := userCred.GetProjectDomainId()
+	capa.HostTypes = getZoneHostTypes(self)
+	capa.Hypervisors = getHypervisors(nil, self, domainId)
+	capa.GpuModels = getGPUs(nil, self, domainId)
+	var err error
+	capa.AvailableStorageTypes, err = getZoneAvailableStorageTypes(self)
+	if err != nil {
+		return capa, err
+	}
+	capa.FreeNetworkIpCount, err = getZoneFreeNetworkIpCount(self, userCred)
+	if err != nil {
+		return capa, err
+	}
+	capa.HasFreeNetworkIps = capa.FreeNetworkIpCount > 0
+	return capa, nil
+}
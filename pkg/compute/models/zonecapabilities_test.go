@@ -0,0 +1,62 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+func testVisibleNetwork(name, projectId, domainId string, isPublic bool, publicScope string) SNetwork {
+	net := SNetwork{}
+	net.Name = name
+	net.ProjectId = projectId
+	net.DomainId = domainId
+	net.IsPublic = isPublic
+	net.PublicScope = publicScope
+	return net
+}
+
+func TestFilterVisibleNetworks(t *testing.T) {
+	userCred := &mcclient.SSimpleToken{
+		Project:         "p1",
+		ProjectId:       "p1",
+		ProjectDomain:   "d1",
+		ProjectDomainId: "d1",
+	}
+	networks := []SNetwork{
+		testVisibleNetwork("owned", "p1", "d1", false, ""),
+		testVisibleNetwork("other-private", "p2", "d1", false, ""),
+		testVisibleNetwork("system-shared", "p2", "d2", true, "system"),
+		testVisibleNetwork("same-domain-shared", "p2", "d1", true, "domain"),
+		testVisibleNetwork("other-domain-shared", "p2", "d2", true, "domain"),
+	}
+	visible := filterVisibleNetworks(networks, userCred)
+	names := make([]string, 0, len(visible))
+	for i := range visible {
+		names = append(names, visible[i].Name)
+	}
+	expect := []string{"owned", "system-shared", "same-domain-shared"}
+	if len(names) != len(expect) {
+		t.Fatalf("expect %v, got %v", expect, names)
+	}
+	for i := range expect {
+		if names[i] != expect[i] {
+			t.Errorf("expect %v, got %v", expect, names)
+			break
+		}
+	}
+}
@@ -17,6 +17,8 @@ package models
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 
 	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
@@ -81,13 +83,52 @@ func (manager *SZoneManager) AllowListItems(ctx context.Context, userCred mcclie
 }
 
 func (zone *SZone) ValidateDeleteCondition(ctx context.Context) error {
-	usage := zone.GeneralUsage()
-	if !usage.IsEmpty() {
-		return httperrors.NewNotEmptyError("not empty zone")
+	deps := zone.GetDependencies()
+	if !deps.IsEmpty() {
+		return httperrors.NewNotEmptyError("zone not empty: %s", formatZoneDependencies(deps))
 	}
 	return zone.SStandaloneResourceBase.ValidateDeleteCondition(ctx)
 }
 
+// formatZoneDependencies enumerates the non-zero dependent resource
+// counts for the delete-blocked error message
+func formatZoneDependencies(deps api.ZoneDependencies) string {
+	parts := make([]string, 0, 5)
+	add := func(count int, kind string) {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", count, kind))
+		}
+	}
+	add(deps.Hosts, "hosts")
+	add(deps.Storages, "storages")
+	add(deps.Wires, "wires")
+	add(deps.Networks, "networks")
+	add(deps.Guests, "guests")
+	return strings.Join(parts, ", ")
+}
+
+// GetDependencies counts the resources still referencing the zone,
+// which block its deletion
+func (zone *SZone) GetDependencies() api.ZoneDependencies {
+	deps := api.ZoneDependencies{}
+	deps.Hosts, _ = zone.HostCount("", "", tristate.None, "", tristate.None)
+	deps.Storages, _ = zone.getStorageCount()
+	deps.Wires, _ = zone.getWireCount()
+	deps.Networks, _ = zone.getNetworkCount()
+	deps.Guests, _ = zone.getGuestCount()
+	return deps
+}
+
+func (zone *SZone) AllowGetDetailsDependencies(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return db.IsAdminAllowGetSpec(userCred, zone, "dependencies")
+}
+
+// GetDetailsDependencies reports the dependent resource counts of the
+// zone so operators can locate what blocks a deletion
+func (zone *SZone) GetDetailsDependencies(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return jsonutils.Marshal(zone.GetDependencies()), nil
+}
+
 func (manager *SZoneManager) Count() (int, error) {
 	return manager.Query().CountWithError()
 }
@@ -142,6 +183,14 @@ func (zone *SZone) getNetworkCount() (int, error) {
 	return getNetworkCount(nil, zone, "")
 }
 
+func (zone *SZone) getGuestCount() (int, error) {
+	hosts := HostManager.Query().SubQuery()
+	q := GuestManager.Query()
+	q = q.Join(hosts, sqlchemy.Equals(q.Field("host_id"), hosts.Field("id"))).
+		Filter(sqlchemy.Equals(hosts.Field("zone_id"), zone.Id))
+	return q.CountWithError()
+}
+
 func (manager *SZoneManager) FetchCustomizeColumns(
 	ctx context.Context,
 	userCred mcclient.TokenCredential,
@@ -455,6 +504,38 @@ func usableZoneQ4(vpcs, wires, networks, zones *sqlchemy.SSubQuery, usableNet, u
 	return sq.SubQuery()
 }
 
+// zoneHostFilterSpec resolves the hypervisor list filter into the host
+// subquery parameters: the host type backing the hypervisor, and
+// whether usable additionally demands attached enabled storage on the
+// hosts
+func zoneHostFilterSpec(hypervisor string, usable bool) (string, bool, error) {
+	hostType, ok := api.HYPERVISOR_HOSTTYPE[hypervisor]
+	if !ok {
+		return "", false, httperrors.NewInputParameterError("unknown hypervisor %s", hypervisor)
+	}
+	return hostType, usable, nil
+}
+
+// usableZoneHostQ returns ids of zones having an enabled online host
+// of the given type; requireStorage additionally demands an attached
+// enabled storage so the host can actually hold disks
+func usableZoneHostQ(hostType string, requireStorage bool) *sqlchemy.SSubQuery {
+	hosts := HostManager.Query().SubQuery()
+	sq := hosts.Query(sqlchemy.DISTINCT("zone_id", hosts.Field("zone_id")))
+	sq = sq.Filter(sqlchemy.Equals(hosts.Field("host_type"), hostType))
+	sq = sq.Filter(sqlchemy.IsTrue(hosts.Field("enabled")))
+	sq = sq.Filter(sqlchemy.Equals(hosts.Field("host_status"), api.HOST_ONLINE))
+	if requireStorage {
+		hoststorages := HoststorageManager.Query().SubQuery()
+		storages := StorageManager.Query().SubQuery()
+		sq = sq.Join(hoststorages, sqlchemy.Equals(hosts.Field("id"), hoststorages.Field("host_id")))
+		sq = sq.Join(storages, sqlchemy.Equals(hoststorages.Field("storage_id"), storages.Field("id")))
+		sq = sq.Filter(sqlchemy.IsTrue(storages.Field("enabled")))
+		sq = sq.Filter(sqlchemy.In(storages.Field("status"), []string{api.STORAGE_ENABLED, api.STORAGE_ONLINE}))
+	}
+	return sq.SubQuery()
+}
+
 func networkUsableZoneQueries(usableNet, usableVpc bool) []*sqlchemy.SSubQuery {
 	queries := make([]*sqlchemy.SSubQuery, 4)
 	queries[0] = usableZoneQ1(CloudproviderManager.Query().SubQuery(),
@@ -567,6 +648,16 @@ func (manager *SZoneManager) ListItemFilter(
 		}
 	}
 
+	if len(query.Hypervisor) > 0 {
+		hostType, requireStorage, err := zoneHostFilterSpec(query.Hypervisor, usableNet)
+		if err != nil {
+			return nil, err
+		}
+		// only zones with enabled online hosts of the requested type;
+		// with usable the hosts must also carry enabled storage
+		q = q.In("id", usableZoneHostQ(hostType, requireStorage))
+	}
+
 	managerStr := query.Cloudprovider
 	if len(managerStr) > 0 {
 		subq := CloudproviderRegionManager.QueryRelatedRegionIds("", managerStr)
@@ -651,7 +742,13 @@ func (self *SZone) GetDetailsCapability(ctx context.Context, userCred mcclient.T
 	if err != nil {
 		return nil, err
 	}
-	return jsonutils.Marshal(&capa), nil
+	zoneCapa, err := self.getZoneCapability(ctx, userCred)
+	if err != nil {
+		return nil, err
+	}
+	ret := jsonutils.Marshal(&capa).(*jsonutils.JSONDict)
+	ret.Update(jsonutils.Marshal(&zoneCapa))
+	return ret, nil
 }
 
 func (self *SZone) isManaged() bool {
@@ -0,0 +1,71 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+func TestFormatZoneDependencies(t *testing.T) {
+	// a zone with dependents enumerates each non-zero count
+	deps := api.ZoneDependencies{
+		Hosts:    3,
+		Storages: 2,
+		Guests:   7,
+	}
+	if deps.IsEmpty() {
+		t.Errorf("zone with dependents must not be empty")
+	}
+	if got := formatZoneDependencies(deps); got != "3 hosts, 2 storages, 7 guests" {
+		t.Errorf("formatZoneDependencies = %q, want %q", got, "3 hosts, 2 storages, 7 guests")
+	}
+
+	// a zone without dependents is deletable
+	empty := api.ZoneDependencies{}
+	if !empty.IsEmpty() {
+		t.Errorf("zone without dependents must be empty")
+	}
+	if got := formatZoneDependencies(empty); got != "" {
+		t.Errorf("formatZoneDependencies(empty) = %q, want empty string", got)
+	}
+}
+
+func TestZoneHostFilterSpec(t *testing.T) {
+	hostType, requireStorage, err := zoneHostFilterSpec(api.HYPERVISOR_KVM, false)
+	if err != nil {
+		t.Fatalf("kvm: %v", err)
+	}
+	if hostType != api.HOST_TYPE_HYPERVISOR {
+		t.Errorf("kvm host type = %s, want %s", hostType, api.HOST_TYPE_HYPERVISOR)
+	}
+	if requireStorage {
+		t.Errorf("without usable the hosts need no storage check")
+	}
+
+	// usable additionally demands attached enabled storage on the hosts
+	hostType, requireStorage, err = zoneHostFilterSpec(api.HYPERVISOR_ESXI, true)
+	if err != nil {
+		t.Fatalf("esxi: %v", err)
+	}
+	if hostType != api.HOST_TYPE_ESXI || !requireStorage {
+		t.Errorf("esxi usable spec = %s/%v", hostType, requireStorage)
+	}
+
+	if _, _, err := zoneHostFilterSpec("xen", false); err == nil {
+		t.Errorf("expect error for unknown hypervisor")
+	}
+}
@@ -43,10 +43,15 @@ type ComputeOptions struct {
 	ExpiredPrepaidMaxCleanBatchSize int  `default:"50" help:"How many expired prepaid servers can be deleted in a batch"`
 
 	LoadbalancerPendingDeleteCheckInterval int `default:"3600" help:"Interval between checks of pending deleted loadbalancer objects, defaults to 1h"`
+	LoadbalancerMaxCountPerAgent           int `default:"20" help:"Loadbalancer instances a single agent can carry before its cluster is considered full"`
 
 	ImageCacheStoragePolicy string `default:"least_used" choices:"best_fit|least_used" help:"Policy to choose storage for image cache, best_fit or least_used"`
 	MetricsRetentionDays    int32  `default:"30" help:"Retention days for monitoring metrics in influxdb"`
 
+	GuestMetricsCacheSeconds int `default:"30" help:"Seconds to cache guest usage metrics fetched from influxdb for list details"`
+
+	MaxSharedImageGrantsPerProject int `default:"-1" help:"Max image-type resources that may be shared into one project, non-positive means unlimited"`
+
 	DefaultBandwidth int `default:"1000" help:"Default bandwidth"`
 	DefaultMtu       int `default:"1500" help:"Default network mtu"`
 
@@ -33,6 +33,18 @@ import (
 type SBaseRegionDriver struct {
 }
 
+func (self *SBaseRegionDriver) IsSupportRoutePriority() bool {
+	return false
+}
+
+func (self *SBaseRegionDriver) GetRoutePriorityRange() (int, int) {
+	return 0, 0
+}
+
+func (self *SBaseRegionDriver) RequestApplyRouteTable(ctx context.Context, userCred mcclient.TokenCredential, rt *models.SRouteTable, task taskman.ITask) error {
+	return fmt.Errorf("Not Implement RequestApplyRouteTable")
+}
+
 func (self *SBaseRegionDriver) RequestCreateLoadbalancer(ctx context.Context, userCred mcclient.TokenCredential, lb *models.SLoadbalancer, task taskman.ITask) error {
 	return fmt.Errorf("Not Implement RequestCreateLoadbalancer")
 }
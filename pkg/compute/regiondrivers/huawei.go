@@ -56,6 +56,14 @@ func (self *SHuaWeiRegionDriver) GetProvider() string {
 	return api.CLOUD_PROVIDER_HUAWEI
 }
 
+func (self *SHuaWeiRegionDriver) IsSupportRoutePriority() bool {
+	return true
+}
+
+func (self *SHuaWeiRegionDriver) GetRoutePriorityRange() (int, int) {
+	return 1, 255
+}
+
 func (self *SHuaWeiRegionDriver) ValidateCreateLoadbalancerData(ctx context.Context, userCred mcclient.TokenCredential, data *jsonutils.JSONDict) (*jsonutils.JSONDict, error) {
 	ownerId := ctx.Value("ownerId").(mcclient.IIdentityProvider)
 	zoneV := validators.NewModelIdOrNameValidator("zone", "zone", ownerId)
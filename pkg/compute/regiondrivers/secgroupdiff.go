@@ -0,0 +1,192 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regiondrivers
+
+import (
+	"sort"
+
+	"yunion.io/x/pkg/util/secrules"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+// sSecgroupRuleConstraint captures the limits a provider imposes on
+// security group rules, which a cloud sync must respect when pushing
+// the local definition
+type sSecgroupRuleConstraint struct {
+	// valid priority range on the provider; zero values mean the
+	// provider ignores priorities
+	minPriority int
+	maxPriority int
+	// onlyAllow means the provider has no deny rules: the local rule
+	// set is converted to its equivalent allow list before pushing
+	onlyAllow bool
+	// defaultRules are always present on a cloud group; sync injects
+	// them when the local definition misses them
+	defaultRules []string
+}
+
+var providerSecgroupRuleConstraints = map[string]sSecgroupRuleConstraint{
+	api.CLOUD_PROVIDER_ALIYUN: {minPriority: 1, maxPriority: 100, defaultRules: []string{"out:allow any"}},
+	api.CLOUD_PROVIDER_HUAWEI: {minPriority: 1, maxPriority: 100},
+	api.CLOUD_PROVIDER_QCLOUD: {minPriority: 1, maxPriority: 100},
+	api.CLOUD_PROVIDER_AWS:    {onlyAllow: true},
+	api.CLOUD_PROVIDER_UCLOUD: {minPriority: 1, maxPriority: 3, onlyAllow: true},
+}
+
+func filterRulesByDirection(rules []secrules.SecurityRule, direction secrules.TSecurityRuleDirection) secrules.SecurityRuleSet {
+	result := secrules.SecurityRuleSet{}
+	for i := range rules {
+		if rules[i].Direction == direction {
+			result = append(result, rules[i])
+		}
+	}
+	return result
+}
+
+// normalizeLocalRules transforms one direction of the local rule set
+// into what the provider would actually hold after a sync, recording
+// for every transformed rule why it differs from the local definition
+func normalizeLocalRules(rules secrules.SecurityRuleSet, constraint sSecgroupRuleConstraint) (secrules.SecurityRuleSet, map[string]string) {
+	reasons := map[string]string{}
+	normalized := make(secrules.SecurityRuleSet, len(rules))
+	copy(normalized, rules)
+	sort.Sort(normalized)
+	if constraint.onlyAllow {
+		before := map[string]bool{}
+		for i := range normalized {
+			before[normalized[i].String()] = true
+		}
+		normalized = normalized.AllowList()
+		for i := range normalized {
+			if s := normalized[i].String(); !before[s] {
+				reasons[s] = api.SECGROUP_DIFF_REASON_ALLOW_ONLY
+			}
+		}
+	}
+	if constraint.maxPriority > 0 {
+		for i := range normalized {
+			if normalized[i].Priority > constraint.maxPriority {
+				normalized[i].Priority = constraint.maxPriority
+			} else if normalized[i].Priority < constraint.minPriority {
+				normalized[i].Priority = constraint.minPriority
+			} else {
+				continue
+			}
+			if s := normalized[i].String(); len(reasons[s]) == 0 {
+				reasons[s] = api.SECGROUP_DIFF_REASON_PRIORITY_SQUEEZE
+			}
+		}
+	}
+	for _, pattern := range constraint.defaultRules {
+		rule, err := secrules.ParseSecurityRule(pattern)
+		if err != nil {
+			continue
+		}
+		present := false
+		for i := range normalized {
+			if normalized[i].String() == rule.String() {
+				present = true
+				break
+			}
+		}
+		if !present {
+			if constraint.minPriority > 0 {
+				rule.Priority = constraint.minPriority
+			}
+			normalized = append(normalized, *rule)
+			reasons[rule.String()] = api.SECGROUP_DIFF_REASON_DEFAULT_RULE
+		}
+	}
+	return normalized, reasons
+}
+
+func diffSecgroupRules(localRules, remoteRules []secrules.SecurityRule, constraint sSecgroupRuleConstraint) api.SecgroupRulesDiff {
+	diff := api.SecgroupRulesDiff{
+		Common:  []api.SecgroupRuleChange{},
+		InAdds:  []api.SecgroupRuleChange{},
+		OutAdds: []api.SecgroupRuleChange{},
+		InDels:  []api.SecgroupRuleChange{},
+		OutDels: []api.SecgroupRuleChange{},
+	}
+	for _, direction := range []secrules.TSecurityRuleDirection{secrules.SecurityRuleIngress, secrules.SecurityRuleEgress} {
+		local := filterRulesByDirection(localRules, direction)
+		remote := filterRulesByDirection(remoteRules, direction)
+		normalized, reasons := normalizeLocalRules(local, constraint)
+		remoteSet := map[string]bool{}
+		for i := range remote {
+			remoteSet[remote[i].String()] = true
+		}
+		localSet := map[string]bool{}
+		adds := []api.SecgroupRuleChange{}
+		for i := range normalized {
+			s := normalized[i].String()
+			localSet[s] = true
+			change := api.SecgroupRuleChange{Rule: s, Priority: normalized[i].Priority, Reason: reasons[s]}
+			if remoteSet[s] {
+				diff.Common = append(diff.Common, change)
+				continue
+			}
+			if len(change.Reason) == 0 {
+				change.Reason = api.SECGROUP_DIFF_REASON_NOT_ON_CLOUD
+			}
+			adds = append(adds, change)
+		}
+		dels := []api.SecgroupRuleChange{}
+		for i := range remote {
+			if s := remote[i].String(); !localSet[s] {
+				dels = append(dels, api.SecgroupRuleChange{Rule: s, Priority: remote[i].Priority, Reason: api.SECGROUP_DIFF_REASON_NOT_IN_LOCAL})
+			}
+		}
+		if direction == secrules.SecurityRuleIngress {
+			diff.InAdds, diff.InDels = adds, dels
+		} else {
+			diff.OutAdds, diff.OutDels = adds, dels
+		}
+	}
+	return diff
+}
+
+// DiffSecgroupRules explains what syncing localRules to provider would
+// change against remoteRules, honoring the provider's priority range,
+// allow-only and default rule constraints. Providers without recorded
+// constraints are diffed verbatim.
+func DiffSecgroupRules(provider string, localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return diffSecgroupRules(localRules, remoteRules, providerSecgroupRuleConstraints[provider])
+}
+
+func (self *SBaseRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return diffSecgroupRules(localRules, remoteRules, sSecgroupRuleConstraint{})
+}
+
+func (self *SAliyunRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return DiffSecgroupRules(self.GetProvider(), localRules, remoteRules)
+}
+
+func (self *SHuaWeiRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return DiffSecgroupRules(self.GetProvider(), localRules, remoteRules)
+}
+
+func (self *SQcloudRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return DiffSecgroupRules(self.GetProvider(), localRules, remoteRules)
+}
+
+func (self *SAwsRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return DiffSecgroupRules(self.GetProvider(), localRules, remoteRules)
+}
+
+func (self *SUcloudRegionDriver) DiffSecgroupRules(localRules, remoteRules []secrules.SecurityRule) api.SecgroupRulesDiff {
+	return DiffSecgroupRules(self.GetProvider(), localRules, remoteRules)
+}
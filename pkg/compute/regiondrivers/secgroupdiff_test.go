@@ -0,0 +1,128 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package regiondrivers
+
+import (
+	"strings"
+	"testing"
+
+	"yunion.io/x/pkg/util/secrules"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+)
+
+func mustRules(priorities []int, patterns ...string) []secrules.SecurityRule {
+	rules := make([]secrules.SecurityRule, len(patterns))
+	for i, pattern := range patterns {
+		rule := secrules.MustParseSecurityRule(pattern)
+		if i < len(priorities) {
+			rule.Priority = priorities[i]
+		}
+		rules[i] = *rule
+	}
+	return rules
+}
+
+func changeStrings(changes []api.SecgroupRuleChange) []string {
+	s := make([]string, len(changes))
+	for i := range changes {
+		s[i] = changes[i].Rule
+	}
+	return s
+}
+
+func TestDiffSecgroupRulesPlain(t *testing.T) {
+	local := mustRules([]int{10, 20}, "in:allow tcp 22", "out:allow any")
+	remote := mustRules([]int{10, 10}, "in:allow tcp 22", "in:allow udp 53")
+	diff := DiffSecgroupRules("Unknown", local, remote)
+	if len(diff.Common) != 1 || diff.Common[0].Rule != "in:allow tcp 22" {
+		t.Errorf("common = %v, want [in:allow tcp 22]", changeStrings(diff.Common))
+	}
+	if len(diff.OutAdds) != 1 || diff.OutAdds[0].Reason != api.SECGROUP_DIFF_REASON_NOT_ON_CLOUD {
+		t.Errorf("out adds = %+v, want one not-on-cloud entry", diff.OutAdds)
+	}
+	if len(diff.InDels) != 1 || diff.InDels[0].Rule != "in:allow udp 53" || diff.InDels[0].Reason != api.SECGROUP_DIFF_REASON_NOT_IN_LOCAL {
+		t.Errorf("in dels = %+v, want not-in-local udp 53", diff.InDels)
+	}
+	if len(diff.InAdds) != 0 || len(diff.OutDels) != 0 {
+		t.Errorf("unexpected changes: in adds %v out dels %v", diff.InAdds, diff.OutDels)
+	}
+}
+
+func TestDiffSecgroupRulesDefaultRuleInjection(t *testing.T) {
+	local := mustRules([]int{10}, "in:allow tcp 22")
+	diff := DiffSecgroupRules(api.CLOUD_PROVIDER_ALIYUN, local, nil)
+	found := false
+	for _, change := range diff.OutAdds {
+		if change.Rule == "out:allow any" {
+			found = true
+			if change.Reason != api.SECGROUP_DIFF_REASON_DEFAULT_RULE {
+				t.Errorf("default rule reason = %s, want %s", change.Reason, api.SECGROUP_DIFF_REASON_DEFAULT_RULE)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("out adds = %v, want injected out:allow any", changeStrings(diff.OutAdds))
+	}
+	// a local definition already containing the default rule gets no
+	// injection annotation
+	local = mustRules([]int{10, 1}, "in:allow tcp 22", "out:allow any")
+	diff = DiffSecgroupRules(api.CLOUD_PROVIDER_ALIYUN, local, nil)
+	for _, change := range diff.OutAdds {
+		if change.Rule == "out:allow any" && change.Reason != api.SECGROUP_DIFF_REASON_NOT_ON_CLOUD {
+			t.Errorf("explicit default rule reason = %s, want %s", change.Reason, api.SECGROUP_DIFF_REASON_NOT_ON_CLOUD)
+		}
+	}
+}
+
+func TestDiffSecgroupRulesPrioritySqueeze(t *testing.T) {
+	local := mustRules([]int{50}, "in:allow tcp 22")
+	diff := DiffSecgroupRules(api.CLOUD_PROVIDER_UCLOUD, local, nil)
+	if len(diff.InAdds) != 1 {
+		t.Fatalf("in adds = %v, want 1 entry", changeStrings(diff.InAdds))
+	}
+	if diff.InAdds[0].Priority != 3 || diff.InAdds[0].Reason != api.SECGROUP_DIFF_REASON_PRIORITY_SQUEEZE {
+		t.Errorf("in adds = %+v, want priority squeezed to 3", diff.InAdds[0])
+	}
+}
+
+func TestDiffSecgroupRulesAllowOnlyConversion(t *testing.T) {
+	// deny rules cannot be pushed to an allow-only provider: the set is
+	// converted to its equivalent allow list
+	local := mustRules([]int{90, 1}, "in:deny tcp 22", "in:allow any")
+	diff := DiffSecgroupRules(api.CLOUD_PROVIDER_AWS, local, nil)
+	if len(diff.InAdds) == 0 {
+		t.Fatalf("expect converted allow rules")
+	}
+	converted := false
+	for _, change := range diff.InAdds {
+		if strings.Contains(change.Rule, "deny") {
+			t.Errorf("deny rule leaked to allow-only provider: %s", change.Rule)
+		}
+		if change.Reason == api.SECGROUP_DIFF_REASON_ALLOW_ONLY {
+			converted = true
+		}
+	}
+	if !converted {
+		t.Errorf("no allow-only conversion annotation in %+v", diff.InAdds)
+	}
+
+	// a deny-only definition converts to an empty allow list
+	local = mustRules([]int{90}, "in:deny tcp 22")
+	diff = DiffSecgroupRules(api.CLOUD_PROVIDER_AWS, local, nil)
+	if len(diff.InAdds) != 0 {
+		t.Errorf("deny-only definition must yield no adds, got %v", changeStrings(diff.InAdds))
+	}
+}
@@ -97,6 +97,7 @@ func StartService() {
 		cron.AddJobEveryFewDays("SyncDBInstanceSkus", opts.SyncSkusDay, opts.SyncSkusHour, 0, 0, models.SyncDBInstanceSkus, true)
 		cron.AddJobEveryFewDays("SyncElasticCacheSkus", opts.SyncSkusDay, opts.SyncSkusHour, 0, 0, models.SyncElasticCacheSkus, true)
 		cron.AddJobEveryFewDays("StorageSnapshotsRecycle", 1, 2, 0, 0, models.StorageManager.StorageSnapshotsRecycle, false)
+		cron.AddJobEveryFewDays("CleanOrphanedShares", 1, 3, 0, 0, db.SharedResourceManager.CleanOrphanedShares, false)
 
 		cron.Start()
 		defer cron.Stop()
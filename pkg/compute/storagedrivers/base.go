@@ -52,6 +52,29 @@ func (self *SBaseStorageDriver) DoStorageUpdateTask(ctx context.Context, userCre
 	return nil
 }
 
+// fileBackedStorageTypes are the storage backends whose disks and
+// snapshots live as plain files, so a snapshot taken on one of them
+// can be restored onto any other
+var fileBackedStorageTypes = []string{api.STORAGE_LOCAL, api.STORAGE_NFS, api.STORAGE_GPFS}
+
+// crossRestoreWithin returns the members of family other than self,
+// the usual shape of a driver cross-restore declaration
+func crossRestoreWithin(family []string, self string) []string {
+	others := make([]string, 0, len(family))
+	for _, storageType := range family {
+		if storageType != self {
+			others = append(others, storageType)
+		}
+	}
+	return others
+}
+
+// GetCrossRestoreStorageTypes by default permits no cross-storage
+// restore; drivers override it to declare their compatibility
+func (self *SBaseStorageDriver) GetCrossRestoreStorageTypes() []string {
+	return nil
+}
+
 func (self *SBaseStorageDriver) ValidateSnapshotDelete(ctx context.Context, snapshot *models.SSnapshot) error {
 	if snapshot.RefCount > 0 {
 		return httperrors.NewBadRequestError("Snapshot reference(by disk) count > 0, can not delete")
@@ -42,6 +42,10 @@ func (self *SGpfsStorageDriver) GetStorageType() string {
 	return api.STORAGE_GPFS
 }
 
+func (self *SGpfsStorageDriver) GetCrossRestoreStorageTypes() []string {
+	return crossRestoreWithin(fileBackedStorageTypes, api.STORAGE_GPFS)
+}
+
 func (self *SGpfsStorageDriver) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, input *api.StorageCreateInput) error {
 	return nil
 }
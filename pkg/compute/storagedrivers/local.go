@@ -37,6 +37,10 @@ func (self *SLocalStorageDriver) GetStorageType() string {
 	return api.STORAGE_LOCAL
 }
 
+func (self *SLocalStorageDriver) GetCrossRestoreStorageTypes() []string {
+	return crossRestoreWithin(fileBackedStorageTypes, api.STORAGE_LOCAL)
+}
+
 func (self *SLocalStorageDriver) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, input *api.StorageCreateInput) error {
 	return nil
 }
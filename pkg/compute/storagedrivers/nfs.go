@@ -42,6 +42,10 @@ func (self *SNfsStorageDriver) GetStorageType() string {
 	return api.STORAGE_NFS
 }
 
+func (self *SNfsStorageDriver) GetCrossRestoreStorageTypes() []string {
+	return crossRestoreWithin(fileBackedStorageTypes, api.STORAGE_NFS)
+}
+
 func (self *SNfsStorageDriver) ValidateCreateData(ctx context.Context, userCred mcclient.TokenCredential, input *api.StorageCreateInput) error {
 	input.StorageConf = jsonutils.NewDict()
 	if len(input.NfsHost) == 0 {
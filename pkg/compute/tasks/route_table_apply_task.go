@@ -0,0 +1,59 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tasks
+
+import (
+	"context"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/compute"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db/taskman"
+	"yunion.io/x/onecloud/pkg/compute/models"
+	"yunion.io/x/onecloud/pkg/util/logclient"
+)
+
+type RouteTableApplyTask struct {
+	taskman.STask
+}
+
+func init() {
+	taskman.RegisterTask(RouteTableApplyTask{})
+}
+
+func (self *RouteTableApplyTask) taskFailed(ctx context.Context, rt *models.SRouteTable, err error) {
+	rt.SetStatus(self.UserCred, api.ROUTE_TABLE_APPLY_FAILED, err.Error())
+	db.OpsLog.LogEvent(rt, db.ACT_SYNC_CONF, err.Error(), self.GetUserCred())
+	logclient.AddActionLogWithStartable(self, rt, logclient.ACT_SYNC_CONF, err.Error(), self.UserCred, false)
+	self.SetStageFailed(ctx, err.Error())
+}
+
+func (self *RouteTableApplyTask) OnInit(ctx context.Context, obj db.IStandaloneModel, data jsonutils.JSONObject) {
+	rt := obj.(*models.SRouteTable)
+	driver, err := rt.GetRegionDriver()
+	if err != nil {
+		self.taskFailed(ctx, rt, errors.Wrap(err, "GetRegionDriver"))
+		return
+	}
+	if err := driver.RequestApplyRouteTable(ctx, self.UserCred, rt, self); err != nil {
+		self.taskFailed(ctx, rt, errors.Wrap(err, "RequestApplyRouteTable"))
+		return
+	}
+	rt.SetStatus(self.UserCred, api.ROUTE_TABLE_AVAILABLE, "apply complete")
+	logclient.AddActionLogWithStartable(self, rt, logclient.ACT_SYNC_CONF, nil, self.UserCred, true)
+	self.SetStageComplete(ctx, nil)
+}
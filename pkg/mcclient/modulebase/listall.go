@@ -0,0 +1,112 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulebase
+
+import (
+	"context"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+const (
+	// DefaultListAllBatchSize is the page size ListAll requests from
+	// the server when the caller does not pass a limit
+	DefaultListAllBatchSize = 1024
+	// DefaultListAllMaxCount caps the number of entries ListAll
+	// fetches to protect against runaway collections
+	DefaultListAllMaxCount = 100000
+)
+
+// listAllPages drives lister page by page, by offset or by paging
+// marker when the server provides one, concatenating Data until the
+// server-reported Total is reached or maxCount entries were fetched.
+// Offsets advance by the number of entries actually received so a
+// collection shrinking while paging does not make entries be skipped
+func listAllPages(ctx context.Context, lister func(params *jsonutils.JSONDict) (*ListResult, error), params jsonutils.JSONObject, batchSize int, maxCount int) (*ListResult, error) {
+	base := jsonutils.NewDict()
+	if params != nil {
+		base.Update(params)
+	}
+	ret := &ListResult{Data: []jsonutils.JSONObject{}}
+	offset := 0
+	marker := ""
+	for {
+		if ctx != nil {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			default:
+			}
+		}
+		page := jsonutils.NewDict()
+		page.Update(base)
+		page.Set("limit", jsonutils.NewInt(int64(batchSize)))
+		if len(marker) > 0 {
+			page.Set("paging_marker", jsonutils.NewString(marker))
+		} else {
+			page.Set("offset", jsonutils.NewInt(int64(offset)))
+		}
+		result, err := lister(page)
+		if err != nil {
+			return nil, err
+		}
+		ret.Total = result.Total
+		if len(result.Data) == 0 {
+			break
+		}
+		ret.Data = append(ret.Data, result.Data...)
+		if maxCount > 0 && len(ret.Data) >= maxCount {
+			ret.Data = ret.Data[:maxCount]
+			break
+		}
+		if len(result.MarkerField) > 0 {
+			if len(result.NextMarker) == 0 {
+				break
+			}
+			marker = result.NextMarker
+			continue
+		}
+		offset += len(result.Data)
+		if result.Total > 0 && offset >= result.Total {
+			break
+		}
+	}
+	if ret.Total < len(ret.Data) {
+		ret.Total = len(ret.Data)
+	}
+	return ret, nil
+}
+
+// ListAll lists the whole collection, transparently paging through it
+// with the default batch size and safety cap
+func (this *ResourceManager) ListAll(session *mcclient.ClientSession, params jsonutils.JSONObject) (*ListResult, error) {
+	return this.ListAllWithContext(context.Background(), session, params, DefaultListAllMaxCount)
+}
+
+// ListAllWithContext is ListAll with caller-provided cancellation and
+// entry cap. A maxCount of 0 means no cap
+func (this *ResourceManager) ListAllWithContext(ctx context.Context, session *mcclient.ClientSession, params jsonutils.JSONObject, maxCount int) (*ListResult, error) {
+	batchSize := DefaultListAllBatchSize
+	if params != nil {
+		if limit, _ := params.Int("limit"); limit > 0 {
+			batchSize = int(limit)
+		}
+	}
+	return listAllPages(ctx, func(page *jsonutils.JSONDict) (*ListResult, error) {
+		return this.List(session, page)
+	}, params, batchSize, maxCount)
+}
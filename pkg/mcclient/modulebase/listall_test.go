@@ -0,0 +1,148 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulebase
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+)
+
+func fakeEntries(start, count int) []jsonutils.JSONObject {
+	data := make([]jsonutils.JSONObject, count)
+	for i := 0; i < count; i++ {
+		entry := jsonutils.NewDict()
+		entry.Set("id", jsonutils.NewString(fmt.Sprintf("obj-%d", start+i)))
+		data[i] = entry
+	}
+	return data
+}
+
+// fakeCollection serves pages of a collection the way a list endpoint
+// would, honoring limit/offset from the page params
+func fakeCollection(total int, pages *int) func(params *jsonutils.JSONDict) (*ListResult, error) {
+	return func(params *jsonutils.JSONDict) (*ListResult, error) {
+		*pages += 1
+		limit, _ := params.Int("limit")
+		offset, _ := params.Int("offset")
+		count := int(limit)
+		if int(offset)+count > total {
+			count = total - int(offset)
+		}
+		if count < 0 {
+			count = 0
+		}
+		return &ListResult{
+			Data:   fakeEntries(int(offset), count),
+			Total:  total,
+			Limit:  int(limit),
+			Offset: int(offset),
+		}, nil
+	}
+}
+
+func TestListAllPagesThreePages(t *testing.T) {
+	pages := 0
+	result, err := listAllPages(context.Background(), fakeCollection(25, &pages), nil, 10, 0)
+	if err != nil {
+		t.Fatalf("listAllPages: %v", err)
+	}
+	if pages != 3 {
+		t.Errorf("expect 3 pages fetched, got %d", pages)
+	}
+	if result.Total != 25 || len(result.Data) != 25 {
+		t.Fatalf("expect 25 entries with total 25, got %d/%d", len(result.Data), result.Total)
+	}
+	if id, _ := result.Data[24].GetString("id"); id != "obj-24" {
+		t.Errorf("unexpected last entry %s", id)
+	}
+}
+
+func TestListAllPagesCap(t *testing.T) {
+	pages := 0
+	result, err := listAllPages(context.Background(), fakeCollection(100, &pages), nil, 10, 15)
+	if err != nil {
+		t.Fatalf("listAllPages: %v", err)
+	}
+	if len(result.Data) != 15 {
+		t.Errorf("expect cap at 15 entries, got %d", len(result.Data))
+	}
+	if result.Total != 100 {
+		t.Errorf("expect server-reported total 100, got %d", result.Total)
+	}
+}
+
+func TestListAllPagesShrink(t *testing.T) {
+	// the collection shrinks from 25 to 12 after the first page
+	pages := 0
+	shrunk := fakeCollection(12, &pages)
+	lister := func(params *jsonutils.JSONDict) (*ListResult, error) {
+		if pages == 0 {
+			pages += 1
+			result := &ListResult{Data: fakeEntries(0, 10), Total: 25}
+			return result, nil
+		}
+		return shrunk(params)
+	}
+	result, err := listAllPages(context.Background(), lister, nil, 10, 0)
+	if err != nil {
+		t.Fatalf("listAllPages: %v", err)
+	}
+	if len(result.Data) != 12 {
+		t.Errorf("expect 12 entries after shrink, got %d", len(result.Data))
+	}
+	if result.Total != 12 {
+		t.Errorf("expect final total 12, got %d", result.Total)
+	}
+}
+
+func TestListAllPagesMarker(t *testing.T) {
+	markers := map[string]int{"": 0, "m1": 10, "m2": 20}
+	next := map[string]string{"": "m1", "m1": "m2", "m2": ""}
+	lister := func(params *jsonutils.JSONDict) (*ListResult, error) {
+		marker, _ := params.GetString("paging_marker")
+		start := markers[marker]
+		count := 10
+		if start+count > 25 {
+			count = 25 - start
+		}
+		return &ListResult{
+			Data:        fakeEntries(start, count),
+			MarkerField: "id",
+			NextMarker:  next[marker],
+		}, nil
+	}
+	result, err := listAllPages(context.Background(), lister, nil, 10, 0)
+	if err != nil {
+		t.Fatalf("listAllPages: %v", err)
+	}
+	if len(result.Data) != 25 || result.Total != 25 {
+		t.Errorf("expect 25 entries, got %d/%d", len(result.Data), result.Total)
+	}
+}
+
+func TestListAllPagesCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pages := 0
+	if _, err := listAllPages(ctx, fakeCollection(25, &pages), nil, 10, 0); err == nil {
+		t.Errorf("expect context error")
+	}
+	if pages != 0 {
+		t.Errorf("expect no pages fetched after cancel, got %d", pages)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulebase
+
+import (
+	"context"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// The Ctx variants below bound a single module call with ctx by
+// threading it into the underlying HTTP request, so callers can cancel
+// an in-flight request or put a deadline on fan-out calls. The
+// context-free methods keep working against the context the session
+// was created with.
+
+// ListCtx is the context-aware variant of List
+func (this *ResourceManager) ListCtx(ctx context.Context, session *mcclient.ClientSession, params jsonutils.JSONObject) (*ListResult, error) {
+	return this.List(session.WithContext(ctx), params)
+}
+
+// GetCtx is the context-aware variant of Get
+func (this *ResourceManager) GetCtx(ctx context.Context, session *mcclient.ClientSession, id string, params jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return this.Get(session.WithContext(ctx), id, params)
+}
+
+// CreateCtx is the context-aware variant of Create
+func (this *ResourceManager) CreateCtx(ctx context.Context, session *mcclient.ClientSession, params jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return this.Create(session.WithContext(ctx), params)
+}
+
+// UpdateCtx is the context-aware variant of Update
+func (this *ResourceManager) UpdateCtx(ctx context.Context, session *mcclient.ClientSession, id string, params jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return this.Update(session.WithContext(ctx), id, params)
+}
+
+// PerformActionCtx is the context-aware variant of PerformAction
+func (this *ResourceManager) PerformActionCtx(ctx context.Context, session *mcclient.ClientSession, id string, action string, params jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return this.PerformAction(session.WithContext(ctx), id, action, params)
+}
+
+// DeleteCtx is the context-aware variant of Delete
+func (this *ResourceManager) DeleteCtx(ctx context.Context, session *mcclient.ClientSession, id string, body jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	return this.Delete(session.WithContext(ctx), id, body)
+}
@@ -0,0 +1,94 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modulebase
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// slowTestManager builds a ResourceManager whose requests go straight
+// to srvUrl: getBaseUrl treats an http(s) serviceType as a literal base
+// URL, so no service catalog is needed
+func slowTestManager(srvUrl string) *ResourceManager {
+	return &ResourceManager{
+		BaseManager:   *NewBaseManager(srvUrl, "", "", nil, nil),
+		Keyword:       "test",
+		KeywordPlural: "tests",
+	}
+}
+
+func TestListCtxCancelAbortsRequest(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	client := mcclient.NewClient(srv.URL, 30, false, true, "", "")
+	session := client.NewSession(context.Background(), "region", "", "internalURL", &mcclient.SSimpleToken{}, "")
+	man := slowTestManager(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := man.ListCtx(ctx, session, nil)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expect error from cancelled request")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cancelled request did not return promptly")
+	}
+}
+
+func TestListWithoutCtxUnaffectedByCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"tests": []}`))
+	}))
+	defer srv.Close()
+
+	client := mcclient.NewClient(srv.URL, 30, false, true, "", "")
+	session := client.NewSession(context.Background(), "region", "", "internalURL", &mcclient.SSimpleToken{}, "")
+	man := slowTestManager(srv.URL)
+
+	// cancelling a derived context must not disturb the original session
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := man.ListCtx(ctx, session, nil); err == nil {
+		t.Fatalf("expect error from cancelled request")
+	}
+	if _, err := man.List(session, nil); err != nil {
+		t.Fatalf("plain List after cancel: %v", err)
+	}
+}
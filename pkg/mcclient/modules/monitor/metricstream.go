@@ -0,0 +1,70 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+// TailMetrics opens the monitor live metric stream described by spec
+// (a marshalled monitor.MetricStreamSpec) and invokes onEvent for
+// every pushed batch of datapoints. It blocks until the server closes
+// the stream or ctx is cancelled.
+func TailMetrics(ctx context.Context, s *mcclient.ClientSession, spec jsonutils.JSONObject, onEvent func(event jsonutils.JSONObject)) error {
+	path := fmt.Sprintf("/metric-streams?%s", spec.QueryString())
+	resp, err := s.RawRequest("monitor", "", "GET", path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("stream request failed: %s", resp.Status)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblock the reader when the caller gives up
+		select {
+		case <-ctx.Done():
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "data:") {
+			payload := strings.TrimSpace(strings.TrimPrefix(trimmed, "data:"))
+			if event, perr := jsonutils.ParseString(payload); perr == nil {
+				onEvent(event)
+			}
+		}
+		if err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -193,6 +193,7 @@ const (
 type BaseListOptions struct {
 	Limit       *int     `default:"20" help:"Page limit"`
 	Offset      *int     `default:"0" help:"Page offset"`
+	All         *bool    `help:"Fetch all entries, transparently paging through the whole collection" json:"-"`
 	OrderBy     []string `help:"Name of the field to be ordered by"`
 	Order       string   `help:"List order" choices:"desc|asc"`
 	Details     *bool    `help:"Show more details" default:"false"`
@@ -249,6 +249,19 @@ func (this *ClientSession) JSONRequest(service, endpointType string, method http
 	return this.JSONVersionRequest(service, endpointType, method, url, headers, body, "")
 }
 
+// WithContext returns a copy of the session whose requests are bound
+// to ctx: cancelling it aborts every in-flight request issued through
+// the returned session. The receiver is left untouched, so one session
+// can serve both bounded and unbounded calls.
+func (this *ClientSession) WithContext(ctx context.Context) *ClientSession {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	session := *this
+	session.ctx = ctx
+	return &session
+}
+
 func (this *ClientSession) ParseJSONResponse(resp *http.Response, err error) (http.Header, jsonutils.JSONObject, error) {
 	return httputils.ParseJSONResponse(resp, err, this.client.debug)
 }
@@ -107,7 +107,12 @@ func (c *EvalContext) GetRuleTitle() string {
 
 // GetNotificationTitle returns the title of the alert rule including alert state.
 func (c *EvalContext) GetNotificationTitle() string {
-	return "[" + c.GetStateModel().Text + "] " + c.GetRuleTitle()
+	title := "[" + c.GetStateModel().Text + "] " + c.GetRuleTitle()
+	if c.IsTestRun {
+		// recipients must be able to tell a drill from a real alert
+		title = "[TEST] " + title
+	}
+	return title
 }
 
 // GetNewState returns the new state from the alert rule evaluation.
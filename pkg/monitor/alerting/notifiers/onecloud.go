@@ -104,6 +104,10 @@ func GetNotifyTemplateConfig(ctx *alerting.EvalContext) monitor.NotificationTemp
 	} else {
 		topic = fmt.Sprintf("%s %s 发生告警", topic, ctx.GetRuleTitle())
 	}
+	if ctx.IsTestRun {
+		// recipients must be able to tell a drill from a real alert
+		topic = "[TEST]" + topic
+	}
 	config := ctx.GetNotificationTemplateConfig()
 	config.Title = topic
 	config.Level = level
@@ -0,0 +1,112 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"context"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+)
+
+func init() {
+	models.RegisterAlertTestRunner(TestRunAlert)
+}
+
+// TestRunAlert fires one immediate evaluation of a stored alert rule:
+// the query runs against the datasource, the conditions are evaluated
+// on the returned series and, when notify is set, the configured
+// notifications go out marked as TEST. The alert state machine is
+// never touched.
+func TestRunAlert(ctx context.Context, userCred mcclient.TokenCredential, alert *models.SAlert, notify bool) (*monitor.AlertTestRunOutput, error) {
+	rule, err := NewRuleFromDBAlert(alert)
+	if err != nil {
+		return nil, errors.Wrap(err, "build rule from alert")
+	}
+	evalCtx := EvalTestRun(ctx, userCred, rule)
+	output := testRunOutput(evalCtx)
+	if notify && evalCtx.Error == nil {
+		output.Notifications = sendTestNotifications(evalCtx)
+	}
+	return output, nil
+}
+
+// EvalTestRun evaluates an in-memory rule once in test mode, keeping
+// the per-condition logs the conditions only record for test runs
+func EvalTestRun(ctx context.Context, userCred mcclient.TokenCredential, rule *Rule) *EvalContext {
+	evalCtx := NewEvalContext(ctx, userCred, rule)
+	evalCtx.IsTestRun = true
+	evalCtx.IsDebug = true
+	NewEvalHandler().Eval(evalCtx)
+	return evalCtx
+}
+
+// testRunOutput folds an evaluated context into the test-run response
+// payload
+func testRunOutput(evalCtx *EvalContext) *monitor.AlertTestRunOutput {
+	output := &monitor.AlertTestRunOutput{
+		Firing:         evalCtx.Firing,
+		NoDataFound:    evalCtx.NoDataFound,
+		ConditionEvals: evalCtx.ConditionEvals,
+		EvalMatches:    evalCtx.GetEvalMatches(),
+	}
+	if evalCtx.Error != nil {
+		output.Error = evalCtx.Error.Error()
+	}
+	for _, entry := range evalCtx.Logs {
+		output.Logs = append(output.Logs, entry.Message)
+	}
+	return output
+}
+
+// sendTestNotifications delivers the test fire to every configured
+// notification channel, bypassing the reminder state machinery, and
+// reports the per-channel outcome
+func sendTestNotifications(evalCtx *EvalContext) []monitor.AlertTestNotificationResult {
+	results := make([]monitor.AlertTestNotificationResult, 0)
+	notis, err := models.NotificationManager.GetNotificationsWithDefault(evalCtx.Rule.Notifications)
+	if err != nil {
+		return append(results, monitor.AlertTestNotificationResult{Error: err.Error()})
+	}
+	for _, obj := range notis {
+		result := monitor.AlertTestNotificationResult{
+			Id:   obj.GetId(),
+			Name: obj.GetName(),
+			Type: obj.Type,
+		}
+		not, err := InitNotifier(NotificationConfig{
+			Id:                    obj.GetId(),
+			Name:                  obj.GetName(),
+			Type:                  obj.Type,
+			Frequency:             time.Duration(obj.Frequency),
+			SendReminder:          obj.SendReminder,
+			DisableResolveMessage: obj.DisableResolveMessage,
+			Settings:              obj.Settings,
+		})
+		if err != nil {
+			result.Error = err.Error()
+		} else if err := not.Notify(evalCtx); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Sent = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,166 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+// fakeTsdbCondition stands in for a query condition backed by a fake
+// tsdb: it averages the canned series and fires when the average
+// crosses the threshold
+type fakeTsdbCondition struct {
+	series    map[string][]float64
+	threshold float64
+	err       error
+}
+
+func (c *fakeTsdbCondition) Eval(evalCtx *EvalContext) (*ConditionResult, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	matches := make([]*EvalMatch, 0)
+	for name, points := range c.series {
+		sum := 0.0
+		for _, p := range points {
+			sum += p
+		}
+		avg := sum / float64(len(points))
+		if avg > c.threshold {
+			value := avg
+			matches = append(matches, &EvalMatch{
+				Condition: fmt.Sprintf("avg() > %v", c.threshold),
+				Value:     &value,
+				Metric:    name,
+			})
+		}
+		if evalCtx.IsTestRun {
+			evalCtx.Logs = append(evalCtx.Logs, &ResultLogEntry{
+				Message: fmt.Sprintf("series %s avg %v", name, avg),
+			})
+		}
+	}
+	return &ConditionResult{
+		Firing:      len(matches) > 0,
+		NoDataFound: len(c.series) == 0,
+		EvalMatches: matches,
+	}, nil
+}
+
+func testRunRule(cond Condition) *Rule {
+	return &Rule{
+		Id:    "alert-1",
+		Name:  "cpu high",
+		State: monitor.AlertStateOK,
+		Conditions: []Condition{
+			cond,
+		},
+	}
+}
+
+func TestEvalTestRunFiring(t *testing.T) {
+	cond := &fakeTsdbCondition{
+		series: map[string][]float64{
+			"vm1.cpu": {95, 97, 99},
+		},
+		threshold: 90,
+	}
+	evalCtx := EvalTestRun(context.Background(), nil, testRunRule(cond))
+	if !evalCtx.Firing {
+		t.Fatalf("series above threshold should fire")
+	}
+	output := testRunOutput(evalCtx)
+	if !output.Firing {
+		t.Errorf("output.Firing = false, want true")
+	}
+	if len(output.EvalMatches) != 1 {
+		t.Fatalf("EvalMatches = %d, want 1", len(output.EvalMatches))
+	}
+	match := output.EvalMatches[0]
+	if match.Metric != "vm1.cpu" {
+		t.Errorf("match metric = %q, want vm1.cpu", match.Metric)
+	}
+	if match.Value == nil || *match.Value != 97 {
+		t.Errorf("match value = %v, want 97", match.Value)
+	}
+	if output.ConditionEvals != "true = true" {
+		t.Errorf("ConditionEvals = %q, want 'true = true'", output.ConditionEvals)
+	}
+	if len(output.Logs) == 0 || !strings.Contains(output.Logs[0], "vm1.cpu") {
+		t.Errorf("test run should keep the condition logs, got %v", output.Logs)
+	}
+}
+
+func TestEvalTestRunNotFiring(t *testing.T) {
+	cond := &fakeTsdbCondition{
+		series: map[string][]float64{
+			"vm1.cpu": {10, 20, 30},
+		},
+		threshold: 90,
+	}
+	evalCtx := EvalTestRun(context.Background(), nil, testRunRule(cond))
+	if evalCtx.Firing {
+		t.Fatalf("series below threshold should not fire")
+	}
+	output := testRunOutput(evalCtx)
+	if output.Firing || len(output.EvalMatches) != 0 {
+		t.Errorf("firing = %v matches = %d, want quiet output", output.Firing, len(output.EvalMatches))
+	}
+	if output.NoDataFound {
+		t.Errorf("NoDataFound = true with data present")
+	}
+}
+
+func TestEvalTestRunNoData(t *testing.T) {
+	cond := &fakeTsdbCondition{threshold: 90}
+	evalCtx := EvalTestRun(context.Background(), nil, testRunRule(cond))
+	output := testRunOutput(evalCtx)
+	if !output.NoDataFound {
+		t.Errorf("NoDataFound = false with empty series")
+	}
+	if output.Firing {
+		t.Errorf("empty series should not fire")
+	}
+}
+
+func TestEvalTestRunError(t *testing.T) {
+	cond := &fakeTsdbCondition{err: fmt.Errorf("datasource unreachable")}
+	evalCtx := EvalTestRun(context.Background(), nil, testRunRule(cond))
+	if evalCtx.Error == nil {
+		t.Fatalf("condition error not propagated")
+	}
+	output := testRunOutput(evalCtx)
+	if !strings.Contains(output.Error, "datasource unreachable") {
+		t.Errorf("output error = %q, want datasource unreachable", output.Error)
+	}
+}
+
+func TestTestRunNotificationTitle(t *testing.T) {
+	evalCtx := NewEvalContext(context.Background(), nil, testRunRule(&fakeTsdbCondition{}))
+	plain := evalCtx.GetNotificationTitle()
+	evalCtx.IsTestRun = true
+	tagged := evalCtx.GetNotificationTitle()
+	if !strings.HasPrefix(tagged, "[TEST] ") {
+		t.Errorf("test run title = %q, want [TEST] prefix", tagged)
+	}
+	if strings.HasPrefix(plain, "[TEST]") {
+		t.Errorf("regular title = %q, must not carry the TEST tag", plain)
+	}
+}
@@ -30,6 +30,8 @@ import (
 	"yunion.io/x/onecloud/pkg/cloudcommon/db/lockman"
 	"yunion.io/x/onecloud/pkg/httperrors"
 	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
 	"yunion.io/x/onecloud/pkg/monitor/validators"
 	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
@@ -79,7 +81,7 @@ type SAlert struct {
 
 	Frequency int64                `nullable:"false" list:"user" create:"required" update:"user"`
 	Settings  jsonutils.JSONObject `nullable:"false" list:"user" create:"required" update:"user"`
-	Level     string               `charset:"ascii" width:"36"nullable:"false" default:"normal" list:"user" update:"user"`
+	Level     string               `charset:"ascii" width:"36" nullable:"false" default:"normal" list:"user" update:"user"`
 	Message   string               `charset:"utf8" list:"user" update:"user"`
 	UsedBy    string               `charset:"ascii" list:"user"`
 
@@ -197,6 +199,15 @@ func (man *SAlertManager) ValidateCreateData(ctx context.Context, userCred mccli
 	if err := validators.ValidateAlertCreateInput(data); err != nil {
 		return data, err
 	}
+	for _, cond := range data.Settings.Conditions {
+		condDs, err := DataSourceManager.GetSource(cond.Query.DataSourceId)
+		if err != nil {
+			return data, errors.Wrapf(err, "get condition data source %s", cond.Query.DataSourceId)
+		}
+		if err := tsdb.ValidateQueryModel(condDs.Type, cond.Query.Model); err != nil {
+			return data, httperrors.NewInputParameterError("query not supported by data source %s: %v", condDs.Type, err)
+		}
+	}
 	return data, nil
 }
 
@@ -341,6 +352,40 @@ func (alert *SAlert) PerformDisable(ctx context.Context, userCred mcclient.Token
 	return nil, nil
 }
 
+// AlertTestRunnerFunc evaluates an alert rule once against its
+// datasource and optionally fires the configured notifications as a
+// test. The alerting engine registers the implementation at init, the
+// same inversion the suggest rule drivers use, because this package
+// cannot import the engine.
+type AlertTestRunnerFunc func(ctx context.Context, userCred mcclient.TokenCredential, alert *SAlert, notify bool) (*monitor.AlertTestRunOutput, error)
+
+var alertTestRunner AlertTestRunnerFunc
+
+func RegisterAlertTestRunner(runner AlertTestRunnerFunc) {
+	alertTestRunner = runner
+}
+
+// alertTestRunLimiter throttles test fires per alert rule
+var alertTestRunLimiter = newUserRateLimiter(time.Minute)
+
+func (alert *SAlert) AllowPerformTestRun(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.AlertTestRunInput) bool {
+	return db.IsProjectAllowPerform(userCred, alert, "test-run")
+}
+
+func (alert *SAlert) PerformTestRun(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.AlertTestRunInput) (jsonutils.JSONObject, error) {
+	if alertTestRunner == nil {
+		return nil, httperrors.NewNotSupportedError("alert test runner not initialized")
+	}
+	if !alertTestRunLimiter.Allow(alert.Id, options.Options.AlertTestRunRatePerMinute) {
+		return nil, httperrors.NewOutOfLimitError("at most %d test fires per rule per minute", options.Options.AlertTestRunRatePerMinute)
+	}
+	output, err := alertTestRunner(ctx, userCred, alert, input.Notify)
+	if err != nil {
+		return nil, errors.Wrap(err, "test run alert")
+	}
+	return jsonutils.Marshal(output), nil
+}
+
 const (
 	ErrAlertChannotChangeStateOnPaused = errors.Error("Cannot change state on pause alert")
 )
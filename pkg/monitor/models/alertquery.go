@@ -0,0 +1,186 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	monitor "yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+	tsdbinfluxdb "yunion.io/x/onecloud/pkg/monitor/tsdb/driver/influxdb"
+	"yunion.io/x/onecloud/pkg/monitor/validators"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+const (
+	// validateQueryMaxRange caps the time range of a validation run so a
+	// dry-run can never scan weeks of data
+	validateQueryMaxRange = time.Hour
+	// validateQueryMaxRangeFrom is the from value used once the
+	// requested range exceeds validateQueryMaxRange
+	validateQueryMaxRangeFrom = "1h"
+	// validateQueryDefaultFrom is used when the caller does not supply a
+	// from value
+	validateQueryDefaultFrom = "15m"
+	// validateQueryMaxPoints is the LIMIT injected into the rendered ql
+	validateQueryMaxPoints = 1000
+	// validateQuerySamplePoints is how many datapoints are echoed back
+	validateQuerySamplePoints = 5
+)
+
+// clampValidateTimeRange fills in default from/to values and clamps the
+// range to validateQueryMaxRange, so the validation query is always
+// cheap regardless of what the alert rule itself would use
+func clampValidateTimeRange(from string, to string) (string, string) {
+	if to == "" {
+		to = "now"
+	}
+	if from == "" {
+		from = validateQueryDefaultFrom
+	}
+	tr := tsdb.NewTimeRange(from, to)
+	fromTime, err1 := tr.ParseFrom()
+	toTime, err2 := tr.ParseTo()
+	if err1 != nil || err2 != nil {
+		return validateQueryDefaultFrom, "now"
+	}
+	if toTime.Sub(fromTime) > validateQueryMaxRange {
+		return validateQueryMaxRangeFrom, "now"
+	}
+	return from, to
+}
+
+// renderAlertQueryQL renders an alert query model to influxql through
+// the same tsdb translation the alert engine uses when evaluating
+// conditions
+func renderAlertQueryQL(model monitor.MetricQuery, from string, to string) (string, error) {
+	parser := &tsdbinfluxdb.InfluxdbQueryParser{}
+	query, err := parser.Parse(&tsdb.Query{RefId: "A", MetricQuery: model}, &tsdb.DataSource{})
+	if err != nil {
+		return "", err
+	}
+	return query.Build(&tsdb.TsdbQuery{TimeRange: tsdb.NewTimeRange(from, to)})
+}
+
+// summarizeValidateSeries counts the datapoints across the returned
+// series and collects the first few rows as a sample
+func summarizeValidateSeries(series []jsonutils.JSONObject, sample int) (int, []jsonutils.JSONObject) {
+	count := 0
+	points := make([]jsonutils.JSONObject, 0)
+	for _, s := range series {
+		values, _ := s.GetArray("values")
+		count += len(values)
+		for _, v := range values {
+			if len(points) < sample {
+				points = append(points, v)
+			}
+		}
+	}
+	return count, points
+}
+
+// validateQueryRunner executes the rendered ql against the datasource;
+// a variable so tests can substitute a stub without a live influxdb
+var validateQueryRunner = runValidateQueryOnInfluxdb
+
+func runValidateQueryOnInfluxdb(ctx context.Context, ds *SDataSource, database string, ql string) ([]jsonutils.JSONObject, error) {
+	dbinst := influxdb.NewInfluxdb(ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", ql)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return []jsonutils.JSONObject{}, nil
+	}
+	series, err := jsonutils.Marshal(results[0]).GetArray()
+	if err != nil {
+		return []jsonutils.JSONObject{}, nil
+	}
+	return series, nil
+}
+
+func (man *SDataSourceManager) AllowPerformValidateQuery(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return true
+}
+
+// PerformValidateQuery dry-runs an alert query model: it renders the
+// model to influxql through the tsdb translation, executes it against
+// the default datasource with a clamped time range and an injected
+// LIMIT, and returns the rendered ql together with the row count and a
+// sample of the matched datapoints. Non-admin callers are scoped to
+// their own project via the tenant_id tag, the same way ad-hoc queries
+// are.
+func (man *SDataSourceManager) PerformValidateQuery(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.AlertQueryValidateInput{}
+	if err := data.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if input.Model.Measurement == "" {
+		return nil, httperrors.NewMissingParameterError("model.measurement")
+	}
+	if len(input.Model.Selects) == 0 {
+		return nil, httperrors.NewMissingParameterError("model.select")
+	}
+	if input.From != "" {
+		if err := validators.ValidateFromValue(input.From); err != nil {
+			return nil, httperrors.NewInputParameterError("invalid from value %q: %v", input.From, err)
+		}
+	}
+	if input.To != "" {
+		if err := validators.ValidateToValue(input.To); err != nil {
+			return nil, httperrors.NewInputParameterError("invalid to value %q: %v", input.To, err)
+		}
+	}
+	if !adhocQueryLimiter.Allow(userCred.GetUserId(), options.Options.AdhocQueryRatePerMinute) {
+		return nil, httperrors.NewOutOfLimitError("too many queries, at most %d per minute", options.Options.AdhocQueryRatePerMinute)
+	}
+	from, to := clampValidateTimeRange(input.From, input.To)
+	ql, err := renderAlertQueryQL(input.Model, from, to)
+	if err != nil {
+		return nil, httperrors.NewInputParameterError("render query: %v", err)
+	}
+	if !userCred.HasSystemAdminPrivilege() {
+		ql = influxdb.InjectTagCondition(ql, "tenant_id", userCred.GetProjectId())
+	}
+	ql = influxdb.EnsureLimit(ql, validateQueryMaxPoints)
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	database := input.Model.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	series, err := validateQueryRunner(ctx, ds, database, ql)
+	if err != nil {
+		return nil, httperrors.NewBadRequestError("execute query: %v", err)
+	}
+	rowCount, points := summarizeValidateSeries(series, validateQuerySamplePoints)
+	output := monitor.AlertQueryValidateOutput{
+		Ql:         ql,
+		From:       from,
+		To:         to,
+		RowCount:   rowCount,
+		Datapoints: points,
+	}
+	return jsonutils.Marshal(output), nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	monitor "yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func TestRenderAlertQueryQL(t *testing.T) {
+	model := monitor.MetricQuery{
+		Measurement: "vm_cpu",
+		Selects: []monitor.MetricQuerySelect{
+			monitor.NewMetricQuerySelect(
+				monitor.MetricQueryPart{Type: "field", Params: []string{"usage_active"}},
+				monitor.MetricQueryPart{Type: "mean", Params: []string{}},
+			),
+		},
+		Tags: []monitor.MetricQueryTag{
+			{Key: "vm_id", Operator: "=", Value: "vm1"},
+		},
+		GroupBy: []monitor.MetricQueryPart{
+			{Type: "time", Params: []string{"$interval"}},
+		},
+	}
+	ql, err := renderAlertQueryQL(model, "5m", "now")
+	if err != nil {
+		t.Fatalf("renderAlertQueryQL() error: %v", err)
+	}
+	for _, want := range []string{
+		`SELECT mean("usage_active")`,
+		`FROM "vm_cpu"`,
+		`"vm_id" = 'vm1'`,
+		"time > now() - 5m",
+		"GROUP BY time(",
+	} {
+		if !strings.Contains(ql, want) {
+			t.Errorf("renderAlertQueryQL() = %q, missing %q", ql, want)
+		}
+	}
+}
+
+func TestRenderAlertQueryQLSyntaxError(t *testing.T) {
+	model := monitor.MetricQuery{
+		Measurement: "vm_cpu",
+		Selects: []monitor.MetricQuerySelect{
+			monitor.NewMetricQuerySelect(
+				monitor.MetricQueryPart{Type: "no_such_function", Params: []string{"value"}},
+			),
+		},
+	}
+	if _, err := renderAlertQueryQL(model, "5m", "now"); err == nil {
+		t.Errorf("renderAlertQueryQL() expected error for unknown query part")
+	}
+}
+
+func TestSummarizeValidateSeries(t *testing.T) {
+	series, err := jsonutils.ParseString(`[
+		{"name": "vm_cpu", "columns": ["time", "mean"], "values": [[1, 0.5], [2, 0.6], [3, 0.7]]},
+		{"name": "vm_cpu", "columns": ["time", "mean"], "values": [[4, 0.8]]}
+	]`)
+	if err != nil {
+		t.Fatalf("parse series: %v", err)
+	}
+	arr, _ := series.GetArray()
+	count, points := summarizeValidateSeries(arr, 2)
+	if count != 4 {
+		t.Errorf("summarizeValidateSeries() count = %d, want 4", count)
+	}
+	if len(points) != 2 {
+		t.Errorf("summarizeValidateSeries() sample = %d points, want 2", len(points))
+	}
+
+	// a query on a nonexistent measurement returns no series at all
+	count, points = summarizeValidateSeries([]jsonutils.JSONObject{}, 2)
+	if count != 0 || len(points) != 0 {
+		t.Errorf("summarizeValidateSeries(empty) = (%d, %d), want (0, 0)", count, len(points))
+	}
+}
+
+func TestClampValidateTimeRange(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     string
+		to       string
+		wantFrom string
+		wantTo   string
+	}{
+		{"defaults", "", "", validateQueryDefaultFrom, "now"},
+		{"within range", "30m", "now", "30m", "now"},
+		{"clamped", "24h", "now", validateQueryMaxRangeFrom, "now"},
+		{"unparsable", "bogus", "now", validateQueryDefaultFrom, "now"},
+	}
+	for _, c := range cases {
+		from, to := clampValidateTimeRange(c.from, c.to)
+		if from != c.wantFrom || to != c.wantTo {
+			t.Errorf("%s: clampValidateTimeRange(%q, %q) = (%q, %q), want (%q, %q)",
+				c.name, c.from, c.to, from, to, c.wantFrom, c.wantTo)
+		}
+	}
+}
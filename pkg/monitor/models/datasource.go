@@ -17,21 +17,36 @@ package models
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
+	"yunion.io/x/jsonutils"
 	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
 	"yunion.io/x/pkg/tristate"
 	"yunion.io/x/pkg/util/wait"
+	"yunion.io/x/pkg/utils"
 
 	"yunion.io/x/onecloud/pkg/apis/monitor"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
 	"yunion.io/x/onecloud/pkg/mcclient/auth"
 	"yunion.io/x/onecloud/pkg/monitor/options"
 	"yunion.io/x/onecloud/pkg/monitor/registry"
 	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+	"yunion.io/x/pkg/util/stringutils"
+
+	"yunion.io/x/onecloud/pkg/util/httputils"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+	"yunion.io/x/onecloud/pkg/util/stringutils2"
 )
 
 var (
@@ -40,6 +55,9 @@ var (
 
 const (
 	DefaultDataSource = "default"
+
+	// PasswordMask is what API output shows in place of the secret
+	PasswordMask = "***"
 )
 
 const (
@@ -67,16 +85,40 @@ func (_ *SDataSourceManager) IsDisabled() bool {
 	return false
 }
 
-func (_ *SDataSourceManager) Init() error {
-	return nil
+func (man *SDataSourceManager) Init() error {
+	return man.reencryptPasswords()
 }
 
+// dsHealthTracker keeps the runtime health of every datasource
+// endpoint, refreshed by the checker started from Run
+var dsHealthTracker = tsdb.NewEndpointHealthTracker(nil)
+
 func (man *SDataSourceManager) Run(ctx context.Context) error {
 	errgrp, ctx := errgroup.WithContext(ctx)
 	errgrp.Go(func() error { return man.initDefaultDataSource(ctx) })
+	errgrp.Go(func() error { return man.startEndpointHealthChecker(ctx) })
+	errgrp.Go(func() error { return man.startWriteProbe(ctx) })
 	return errgrp.Wait()
 }
 
+func (man *SDataSourceManager) startEndpointHealthChecker(ctx context.Context) error {
+	checkF := func() {
+		sources := make([]SDataSource, 0)
+		q := man.Query().IsNotEmpty("url")
+		if err := db.FetchModelObjects(man, q, &sources); err != nil {
+			if err != sql.ErrNoRows {
+				log.Errorf("fetch datasources for health check: %v", err)
+			}
+			return
+		}
+		for i := range sources {
+			dsHealthTracker.CheckEndpoints(ctx, sources[i].GetUrls())
+		}
+	}
+	wait.Forever(checkF, 30*time.Second)
+	return nil
+}
+
 func (man *SDataSourceManager) initDefaultDataSource(ctx context.Context) error {
 	region := options.Options.Region
 	initF := func() {
@@ -132,6 +174,13 @@ type SDataSource struct {
 	Password  string            `width:"64" charset:"utf8" nullable:"true"`
 	Database  string            `width:"64" charset:"utf8" nullable:"true"`
 	IsDefault tristate.TriState `nullable:"false" default:"false" create:"optional"`
+
+	// 最近一次写入探测时间
+	LastProbeAt time.Time `nullable:"true" list:"user" json:"last_probe_at"`
+	// 最近一次写入探测状态
+	LastProbeStatus string `width:"16" charset:"ascii" nullable:"true" list:"user" json:"last_probe_status"`
+	// 最近若干次写入探测结果
+	ProbeResults jsonutils.JSONObject `nullable:"true" list:"user"`
 	/*
 		TimeInterval string
 		BasicAuth bool
@@ -148,6 +197,657 @@ func (m *SDataSourceManager) GetSource(id string) (*SDataSource, error) {
 	return ret.(*SDataSource), nil
 }
 
+// GetUrls returns the endpoints of the datasource, the url column may
+// hold several comma-separated for HA setups
+func (ds *SDataSource) GetUrls() []string {
+	return tsdb.SplitUrls(ds.Url)
+}
+
+// getActiveUrl returns the currently healthy endpoint, failing over
+// when the preferred one is down
+func (ds *SDataSource) getActiveUrl() string {
+	return dsHealthTracker.PickHealthyUrl(ds.GetUrls())
+}
+
+func (ds *SDataSource) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	ds.SStandaloneResourceBase.PostCreate(ctx, userCred, ownerId, query, data)
+	if ds.Password != "" {
+		if err := ds.savePassword(ds.Password); err != nil {
+			log.Errorf("datasource %s encrypt password: %v", ds.GetName(), err)
+		}
+	}
+}
+
+func (ds *SDataSource) savePassword(password string) error {
+	sec, err := utils.EncryptAESBase64(ds.Id, password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Update(ds, func() error {
+		ds.Password = sec
+		return nil
+	})
+	return err
+}
+
+func (ds *SDataSource) getPassword() string {
+	if ds.Password == "" {
+		return ""
+	}
+	password, err := utils.DescryptAESBase64(ds.Id, ds.Password)
+	if err != nil {
+		// legacy rows may still hold plaintext, reencryptPasswords
+		// migrates them at startup
+		return ds.Password
+	}
+	return password
+}
+
+// reencryptPasswords migrates plaintext password rows left by older
+// versions to the encrypted format
+func (man *SDataSourceManager) reencryptPasswords() error {
+	sources := make([]SDataSource, 0)
+	q := man.Query().IsNotEmpty("password")
+	if err := db.FetchModelObjects(man, q, &sources); err != nil && err != sql.ErrNoRows {
+		return errors.Wrap(err, "db.FetchModelObjects")
+	}
+	for i := range sources {
+		ds := &sources[i]
+		if _, err := utils.DescryptAESBase64(ds.Id, ds.Password); err == nil {
+			continue
+		}
+		if err := ds.savePassword(ds.Password); err != nil {
+			return errors.Wrapf(err, "reencrypt datasource %s", ds.GetName())
+		}
+	}
+	return nil
+}
+
+func (man *SDataSourceManager) FetchCustomizeColumns(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	objs []interface{},
+	fields stringutils2.SSortedStrings,
+	isList bool,
+) []monitor.DataSourceDetails {
+	rows := make([]monitor.DataSourceDetails, len(objs))
+	stdRows := man.SStandaloneResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	for i := range rows {
+		ds := objs[i].(*SDataSource)
+		rows[i] = monitor.DataSourceDetails{
+			StandaloneResourceDetails: stdRows[i],
+			Type:                      ds.Type,
+			Url:                       ds.Url,
+			Database:                  ds.Database,
+			User:                      ds.User,
+		}
+		// never expose the secret, not even in encrypted form
+		if ds.Password != "" {
+			rows[i].Password = PasswordMask
+		}
+		for _, st := range dsHealthTracker.GetStatus(ds.GetUrls()) {
+			rows[i].EndpointStatus = append(rows[i].EndpointStatus, monitor.DataSourceEndpointStatus{
+				Url:       st.Url,
+				Healthy:   st.Healthy,
+				LastCheck: st.LastCheck,
+				LastError: st.LastError,
+			})
+		}
+		rows[i].LastProbeAt = ds.LastProbeAt
+		rows[i].LastProbeStatus = ds.LastProbeStatus
+		rows[i].ProbeResults = ds.getProbeResults()
+	}
+	return rows
+}
+
+func (ds *SDataSource) GetExtraDetails(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	isList bool,
+) (monitor.DataSourceDetails, error) {
+	return monitor.DataSourceDetails{}, nil
+}
+
+func (ds *SDataSource) AllowPerformChangePassword(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.DataSourceChangePasswordInput) bool {
+	return db.IsAdminAllowPerform(userCred, ds, "change-password")
+}
+
+// PerformChangePassword validates the new credential against the
+// backing tsdb before saving it encrypted
+func (ds *SDataSource) PerformChangePassword(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.DataSourceChangePasswordInput) (jsonutils.JSONObject, error) {
+	user := input.User
+	if user == "" {
+		user = ds.User
+	}
+	if err := ds.testCredential(ctx, user, input.Password); err != nil {
+		return nil, httperrors.NewInputParameterError("credential validation failed: %v", err)
+	}
+	if user != ds.User {
+		if _, err := db.Update(ds, func() error {
+			ds.User = user
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := ds.savePassword(input.Password); err != nil {
+		return nil, errors.Wrap(err, "save password")
+	}
+	return nil, nil
+}
+
+func (ds *SDataSource) testCredential(ctx context.Context, user string, password string) error {
+	pingUrl := fmt.Sprintf("%s/ping", strings.TrimRight(ds.getActiveUrl(), "/"))
+	req, err := http.NewRequest(http.MethodGet, pingUrl, nil)
+	if err != nil {
+		return err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	client := httputils.GetDefaultClient()
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer httputils.CloseResponse(resp)
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrapf(httperrors.ErrInvalidCredential, "status: %s", resp.Status)
+	}
+	return nil
+}
+
+const (
+	// defaultMeasurementPageSize caps the measurement page so a
+	// telegraf database with thousands of measurements does not
+	// produce multi-megabyte responses
+	defaultMeasurementPageSize = 200
+)
+
+func (man *SDataSourceManager) AllowGetPropertyMeasurements(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return true
+}
+
+// GetPropertyMeasurements lists the measurements of the default
+// datasource database with server-side name filtering and pagination.
+// Project-scoped callers only see measurements carrying series of
+// their own project.
+func (man *SDataSourceManager) GetPropertyMeasurements(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.DataSourceMeasurementsInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	scope, err := fetchMeasurementScope(userCred, query)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout())
+	defer cancel()
+	database := input.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	sql := fmt.Sprintf("SHOW MEASUREMENTS ON %q", database)
+	if input.NameLike != "" {
+		if err := validateMeasurementNameLike(input.NameLike); err != nil {
+			return nil, err
+		}
+		sql = fmt.Sprintf("%s WITH MEASUREMENT =~ /%s/", sql, input.NameLike)
+	}
+	if !scope.IsSystem() {
+		sql = fmt.Sprintf("%s WHERE tenant_id = '%s'", sql, escapeTagValue(scope.TenantId))
+	}
+	names, err := man.queryMeasurementNames(ctx, ds, sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "query measurements")
+	}
+	if input.WithCounts || input.OrderBy == "count" {
+		entries, err := buildMeasurementEntries(ctx, sInfluxStatsQuerier{ds: ds}, database, names, input.From, input.To, scope, metaQueryConcurrency())
+		if err != nil {
+			return nil, errors.Wrap(err, "count datapoints")
+		}
+		if err := sortMeasurementEntries(entries, input.OrderBy); err != nil {
+			return nil, err
+		}
+		page, total := pageMeasurementEntries(entries, input.Limit, input.Offset)
+		output := monitor.DataSourceMeasurementsOutput{
+			Total:        total,
+			Measurements: measurementEntryNames(page),
+			Entries:      page,
+		}
+		return jsonutils.Marshal(output), nil
+	}
+	if err := validateMeasurementOrderBy(input.OrderBy); err != nil {
+		return nil, err
+	}
+	page, total := pageMeasurementNames(names, input.Limit, input.Offset)
+	output := monitor.DataSourceMeasurementsOutput{
+		Total:        total,
+		Measurements: page,
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (man *SDataSourceManager) queryMeasurementNames(ctx context.Context, ds *SDataSource, sql string) ([]string, error) {
+	dbinst := influxdb.NewInfluxdb(ds.getActiveUrl())
+	results, err := dbinst.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, value := range series.Values {
+				if len(value) == 0 || value[0] == nil {
+					continue
+				}
+				name, err := value[0].GetString()
+				if err != nil {
+					continue
+				}
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// pageMeasurementNames applies limit/offset to the full name list,
+// returning the page and the total count. A missing or oversized limit
+// falls back to defaultMeasurementPageSize.
+func pageMeasurementNames(names []string, limit int, offset int) ([]string, int) {
+	total := len(names)
+	if limit <= 0 || limit > defaultMeasurementPageSize {
+		limit = defaultMeasurementPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return []string{}, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return names[offset:end], total
+}
+
+const (
+	// measurementActiveWindow is how far back a measurement must have
+	// received data to be reported as active
+	measurementActiveWindow = "10m"
+)
+
+// measurementCountQuerier answers the datapoint count queries the
+// measurement listing needs, replaceable in tests
+type measurementCountQuerier interface {
+	DatapointCount(ctx context.Context, database string, measurement string, from string, to string, scope sMeasurementScope) (int64, error)
+}
+
+// rangeWithinActiveWindow reports whether a from bound is at most the
+// active window, in which case any counted datapoint is by definition
+// recent and the activity probe can be skipped
+func rangeWithinActiveWindow(from string) bool {
+	if from == "" {
+		return false
+	}
+	d, err := time.ParseDuration(from)
+	if err != nil {
+		return false
+	}
+	window, _ := time.ParseDuration(measurementActiveWindow)
+	return d <= window
+}
+
+// buildMeasurementEntries counts the datapoints of every measurement
+// within the from/to bounds and flags recent activity, running at most
+// concurrency count queries in parallel. When the requested range
+// itself is within the active window the flag is derived from the
+// count, saving the second query per measurement.
+func buildMeasurementEntries(ctx context.Context, querier measurementCountQuerier, database string, names []string, from string, to string, scope sMeasurementScope, concurrency int) ([]monitor.MeasurementEntry, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	deriveActive := to == "" && rangeWithinActiveWindow(from)
+	entries := make([]monitor.MeasurementEntry, len(names))
+	errgrp, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, concurrency)
+	for i := range names {
+		i := i
+		name := names[i]
+		errgrp.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			count, err := querier.DatapointCount(ctx, database, name, from, to, scope)
+			if err != nil {
+				return errors.Wrapf(err, "count measurement %s", name)
+			}
+			entry := monitor.MeasurementEntry{
+				Name:  name,
+				Count: count,
+			}
+			if deriveActive {
+				entry.Active = count > 0
+			} else {
+				recent, err := querier.DatapointCount(ctx, database, name, measurementActiveWindow, "", scope)
+				if err != nil {
+					return errors.Wrapf(err, "probe measurement %s activity", name)
+				}
+				entry.Active = recent > 0
+			}
+			entries[i] = entry
+			return nil
+		})
+	}
+	if err := errgrp.Wait(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+var measurementNameLikePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// validateMeasurementNameLike restricts the name filter to a safe
+// identifier charset: the value is rendered into an influxql regex
+// literal, where a '/' would terminate the literal and let the
+// remainder run as raw QL
+func validateMeasurementNameLike(nameLike string) error {
+	if nameLike == "" || measurementNameLikePattern.MatchString(nameLike) {
+		return nil
+	}
+	return httperrors.NewInputParameterError("invalid name_like %q, expect letters, digits, '_', '.' or '-'", nameLike)
+}
+
+func validateMeasurementOrderBy(orderBy string) error {
+	switch orderBy {
+	case "", "name", "count":
+		return nil
+	}
+	return httperrors.NewInputParameterError("unsupported order_by %q, expect name or count", orderBy)
+}
+
+// sortMeasurementEntries orders entries by name ascending or count
+// descending, ties broken by name
+func sortMeasurementEntries(entries []monitor.MeasurementEntry, orderBy string) error {
+	if err := validateMeasurementOrderBy(orderBy); err != nil {
+		return err
+	}
+	if orderBy == "count" {
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Count != entries[j].Count {
+				return entries[i].Count > entries[j].Count
+			}
+			return entries[i].Name < entries[j].Name
+		})
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return nil
+}
+
+// pageMeasurementEntries applies the same limit/offset semantics as
+// pageMeasurementNames to entry lists
+func pageMeasurementEntries(entries []monitor.MeasurementEntry, limit int, offset int) ([]monitor.MeasurementEntry, int) {
+	total := len(entries)
+	if limit <= 0 || limit > defaultMeasurementPageSize {
+		limit = defaultMeasurementPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return []monitor.MeasurementEntry{}, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return entries[offset:end], total
+}
+
+func measurementEntryNames(entries []monitor.MeasurementEntry) []string {
+	names := make([]string, len(entries))
+	for i := range entries {
+		names[i] = entries[i].Name
+	}
+	return names
+}
+
+// sUserRateLimiter is a fixed-window per-user counter guarding the
+// ad-hoc query endpoint against runaway dashboard loops.
+type sUserRateLimiter struct {
+	lock   sync.Mutex
+	window time.Duration
+	starts map[string]time.Time
+	counts map[string]int
+}
+
+func newUserRateLimiter(window time.Duration) *sUserRateLimiter {
+	return &sUserRateLimiter{
+		window: window,
+		starts: make(map[string]time.Time),
+		counts: make(map[string]int),
+	}
+}
+
+func (rl *sUserRateLimiter) Allow(userId string, max int) bool {
+	if max <= 0 {
+		return true
+	}
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+	now := time.Now()
+	if start, ok := rl.starts[userId]; !ok || now.Sub(start) >= rl.window {
+		rl.starts[userId] = now
+		rl.counts[userId] = 0
+	}
+	if rl.counts[userId] >= max {
+		return false
+	}
+	rl.counts[userId]++
+	return true
+}
+
+var adhocQueryLimiter = newUserRateLimiter(time.Minute)
+
+func (ds *SDataSource) AllowPerformQuery(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.DataSourceQueryInput) bool {
+	return db.IsProjectAllowPerform(userCred, ds, "query")
+}
+
+// PerformQuery executes an ad-hoc influxql statement against the
+// datasource with guard rails: only single SELECT/SHOW statements are
+// accepted, a LIMIT is injected when absent, non-admin callers are
+// scoped to their own project via the tenant_id tag and each user is
+// rate limited.
+func (ds *SDataSource) PerformQuery(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.DataSourceQueryInput) (jsonutils.JSONObject, error) {
+	ql := strings.TrimSpace(input.Ql)
+	if len(ql) == 0 {
+		return nil, httperrors.NewMissingParameterError("ql")
+	}
+	if !adhocQueryLimiter.Allow(userCred.GetUserId(), options.Options.AdhocQueryRatePerMinute) {
+		return nil, httperrors.NewOutOfLimitError("too many queries, at most %d per minute", options.Options.AdhocQueryRatePerMinute)
+	}
+	if err := influxdb.ValidateReadOnlyQL(ql); err != nil {
+		return nil, httperrors.NewInputParameterError("invalid ql: %v", err)
+	}
+	if !userCred.HasSystemAdminPrivilege() {
+		ql = influxdb.InjectTagCondition(ql, "tenant_id", userCred.GetProjectId())
+	}
+	ql = influxdb.EnsureLimit(ql, options.Options.AdhocQueryMaxPoints)
+	database := input.Database
+	if len(database) == 0 {
+		database = "telegraf"
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout())
+	defer cancel()
+	dbinst := influxdb.NewInfluxdb(ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, input.Epoch, ql)
+	if err != nil {
+		return nil, errors.Wrap(err, "query influxdb")
+	}
+	output := jsonutils.NewDict()
+	if len(results) > 0 {
+		output.Add(jsonutils.Marshal(results[0]), "series")
+	} else {
+		output.Add(jsonutils.NewArray(), "series")
+	}
+	return output, nil
+}
+
+const (
+	// probeMeasurement is the dedicated measurement the write probe
+	// stores its synthetic points in
+	probeMeasurement = "onecloud_probe"
+
+	ProbeStatusSuccess = "success"
+	ProbeStatusFail    = "fail"
+)
+
+// runWriteProbe writes a synthetic point into the probe measurement
+// and immediately queries it back, measuring the round-trip latency of
+// the whole write path
+func (ds *SDataSource) runWriteProbe(ctx context.Context) monitor.DataSourceProbeResult {
+	start := time.Now()
+	result := monitor.DataSourceProbeResult{ProbedAt: start.UTC()}
+	fail := func(err error) monitor.DataSourceProbeResult {
+		result.Error = err.Error()
+		result.LatencyMs = time.Since(start).Milliseconds()
+		return result
+	}
+	database := ds.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	dbinst := influxdb.NewInfluxdb(ds.getActiveUrl())
+	if err := dbinst.SetDatabase(database); err != nil {
+		return fail(errors.Wrap(err, "set database"))
+	}
+	probeId := stringutils.UUID4()
+	line := fmt.Sprintf("%s,probe_id=%s value=1i %d", probeMeasurement, probeId, start.UnixNano())
+	if err := dbinst.Write(line, "ns"); err != nil {
+		return fail(errors.Wrap(err, "write probe point"))
+	}
+	sql := fmt.Sprintf("SELECT value FROM %s WHERE probe_id = '%s'", probeMeasurement, probeId)
+	results, err := dbinst.QueryContextOn(ctx, database, "", sql)
+	if err != nil {
+		return fail(errors.Wrap(err, "read back probe point"))
+	}
+	if len(results) == 0 || len(results[0]) == 0 || len(results[0][0].Values) == 0 {
+		return fail(errors.Error("written probe point not readable"))
+	}
+	result.Success = true
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result
+}
+
+func (ds *SDataSource) getProbeResults() []monitor.DataSourceProbeResult {
+	history := make([]monitor.DataSourceProbeResult, 0)
+	if ds.ProbeResults != nil {
+		if err := ds.ProbeResults.Unmarshal(&history); err != nil {
+			log.Errorf("datasource %s unmarshal probe results: %v", ds.GetName(), err)
+		}
+	}
+	return history
+}
+
+// appendProbeResult appends result to history keeping at most max
+// entries, dropping the oldest
+func appendProbeResult(history []monitor.DataSourceProbeResult, result monitor.DataSourceProbeResult, max int) []monitor.DataSourceProbeResult {
+	history = append(history, result)
+	if max > 0 && len(history) > max {
+		history = history[len(history)-max:]
+	}
+	return history
+}
+
+// consecutiveProbeFailures counts the failures at the tail of history
+func consecutiveProbeFailures(history []monitor.DataSourceProbeResult) int {
+	count := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Success {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+func probeStatus(result monitor.DataSourceProbeResult) string {
+	if result.Success {
+		return ProbeStatusSuccess
+	}
+	return ProbeStatusFail
+}
+
+func (ds *SDataSource) recordProbeResult(result monitor.DataSourceProbeResult) error {
+	history := appendProbeResult(ds.getProbeResults(), result, options.Options.DatasourceProbeHistory)
+	_, err := db.Update(ds, func() error {
+		ds.LastProbeAt = result.ProbedAt
+		ds.LastProbeStatus = probeStatus(result)
+		ds.ProbeResults = jsonutils.Marshal(history)
+		return nil
+	})
+	return err
+}
+
+func (ds *SDataSource) AllowPerformWriteTest(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, ds, "write-test")
+}
+
+// PerformWriteTest probes the influxdb write path once and returns the
+// result, recording it in the probe history
+func (ds *SDataSource) PerformWriteTest(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	result := ds.runWriteProbe(ctx)
+	if err := ds.recordProbeResult(result); err != nil {
+		log.Errorf("datasource %s record probe result: %v", ds.GetName(), err)
+	}
+	return jsonutils.Marshal(result), nil
+}
+
+// startWriteProbe periodically probes the write path of every
+// datasource, raising an event once consecutive failures exceed the
+// configured threshold
+func (man *SDataSourceManager) startWriteProbe(ctx context.Context) error {
+	if options.Options.DisableDatasourceWriteProbe {
+		return nil
+	}
+	interval := time.Duration(options.Options.DatasourceProbeIntervalSeconds) * time.Second
+	probeF := func() {
+		sources := make([]SDataSource, 0)
+		q := man.Query().IsNotEmpty("url")
+		if err := db.FetchModelObjects(man, q, &sources); err != nil {
+			if err != sql.ErrNoRows {
+				log.Errorf("fetch datasources for write probe: %v", err)
+			}
+			return
+		}
+		for i := range sources {
+			ds := &sources[i]
+			result := ds.runWriteProbe(ctx)
+			if err := ds.recordProbeResult(result); err != nil {
+				log.Errorf("datasource %s record probe result: %v", ds.GetName(), err)
+				continue
+			}
+			failures := consecutiveProbeFailures(ds.getProbeResults())
+			if failures >= options.Options.DatasourceProbeFailureThreshold {
+				log.Errorf("datasource %s write probe failed %d times in a row: %s", ds.GetName(), failures, result.Error)
+				db.OpsLog.LogEvent(ds, db.ACT_PROBE_FAIL, fmt.Sprintf("write probe failed %d times in a row: %s", failures, result.Error), auth.AdminCredential())
+			}
+		}
+	}
+	wait.Forever(probeF, interval)
+	return nil
+}
+
 func (ds *SDataSource) ToTSDBDataSource(db string) *tsdb.DataSource {
 	if db == "" {
 		db = ds.Database
@@ -156,9 +856,9 @@ func (ds *SDataSource) ToTSDBDataSource(db string) *tsdb.DataSource {
 		Id:       ds.GetId(),
 		Name:     ds.GetName(),
 		Type:     ds.Type,
-		Url:      ds.Url,
+		Url:      ds.getActiveUrl(),
 		User:     ds.User,
-		Password: ds.Password,
+		Password: ds.getPassword(),
 		Database: db,
 		Updated:  ds.UpdatedAt,
 		/*BasicAuth: ds.BasicAuth,
@@ -0,0 +1,460 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+const (
+	// cardinalityCacheTTL is how long a computed cardinality result is
+	// reused, the underlying SHOW ... CARDINALITY queries are expensive
+	cardinalityCacheTTL = time.Hour
+
+	// defaultTopCardinality caps the measurement ranking when the
+	// caller does not specify top
+	defaultTopCardinality = 10
+)
+
+// measurementStatsQuerier answers the raw influxql statements the
+// cardinality computation needs, replaceable in tests
+type measurementStatsQuerier interface {
+	MeasurementNames(ctx context.Context, database string) ([]string, error)
+	TagKeys(ctx context.Context, database string, measurement string) ([]string, error)
+	SeriesCardinality(ctx context.Context, database string, measurement string) (int64, error)
+	TagValueCardinality(ctx context.Context, database string, measurement string, tagKey string) (int64, error)
+}
+
+// sInfluxStatsQuerier implements measurementStatsQuerier against the
+// influxdb backing a datasource
+type sInfluxStatsQuerier struct {
+	ds *SDataSource
+}
+
+func (q sInfluxStatsQuerier) queryStrings(ctx context.Context, database string, sql string) ([]string, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", sql)
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, 0)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, value := range series.Values {
+				if len(value) == 0 || value[0] == nil {
+					continue
+				}
+				str, err := value[0].GetString()
+				if err != nil {
+					continue
+				}
+				strs = append(strs, str)
+			}
+		}
+	}
+	return strs, nil
+}
+
+func (q sInfluxStatsQuerier) queryCount(ctx context.Context, database string, sql string) (int64, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", sql)
+	if err != nil {
+		return 0, err
+	}
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, value := range series.Values {
+				if len(value) == 0 || value[0] == nil {
+					continue
+				}
+				count, err := value[0].Int()
+				if err != nil {
+					continue
+				}
+				return count, nil
+			}
+		}
+	}
+	return 0, nil
+}
+
+// datapointCountQL renders the datapoint count query of a measurement
+// within the from/to bounds, restricted by tenant_id unless the scope
+// is system-wide
+func datapointCountQL(measurement string, from string, to string, scope sMeasurementScope) string {
+	sql := fmt.Sprintf("SELECT count(*) FROM %q", measurement)
+	conds := make([]string, 0, 3)
+	if from != "" {
+		conds = append(conds, fmt.Sprintf("time > now() - %s", from))
+	}
+	if to != "" {
+		conds = append(conds, fmt.Sprintf("time <= now() - %s", to))
+	}
+	if !scope.IsSystem() {
+		conds = append(conds, fmt.Sprintf("tenant_id = '%s'", escapeTagValue(scope.TenantId)))
+	}
+	if len(conds) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(conds, " AND "))
+	}
+	return sql
+}
+
+// DatapointCount returns the number of datapoints of a measurement
+// within the from/to bounds. count(*) yields one count per field; the
+// largest one is reported.
+func (q sInfluxStatsQuerier) DatapointCount(ctx context.Context, database string, measurement string, from string, to string, scope sMeasurementScope) (int64, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", datapointCountQL(measurement, from, to, scope))
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, row := range series.Values {
+				// first column is the timestamp, the rest are
+				// per-field counts
+				for i := 1; i < len(row); i++ {
+					if row[i] == nil {
+						continue
+					}
+					fieldCount, err := row[i].Int()
+					if err != nil {
+						continue
+					}
+					if fieldCount > count {
+						count = fieldCount
+					}
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// lastDatapointQL renders the cheap query probing when a measurement
+// last received data, bounded by from (e.g. 24h) when given and
+// restricted by tenant_id unless the scope is system-wide
+func lastDatapointQL(measurement string, from string, scope sMeasurementScope) string {
+	sql := fmt.Sprintf("SELECT last(*) FROM %q", measurement)
+	conds := make([]string, 0, 2)
+	if from != "" {
+		conds = append(conds, fmt.Sprintf("time > now() - %s", from))
+	}
+	if !scope.IsSystem() {
+		conds = append(conds, fmt.Sprintf("tenant_id = '%s'", escapeTagValue(scope.TenantId)))
+	}
+	if len(conds) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(conds, " AND "))
+	}
+	return sql
+}
+
+// LastDatapoint returns the timestamp of the newest point of a
+// measurement, the zero time when none exists within the bound
+func (q sInfluxStatsQuerier) LastDatapoint(ctx context.Context, database string, measurement string, from string, scope sMeasurementScope) (time.Time, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "s", lastDatapointQL(measurement, from, scope))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, value := range series.Values {
+				if len(value) == 0 || value[0] == nil {
+					continue
+				}
+				sec, err := value[0].Int()
+				if err != nil {
+					continue
+				}
+				return time.Unix(sec, 0).UTC(), nil
+			}
+		}
+	}
+	return time.Time{}, nil
+}
+
+// fieldTypesFromSchemas flattens field schemas into a field name to
+// influx data type map
+func fieldTypesFromSchemas(fields []monitor.MeasurementFieldSchema) map[string]string {
+	types := make(map[string]string, len(fields))
+	for _, field := range fields {
+		types[field.Name] = field.Type
+	}
+	return types
+}
+
+func (q sInfluxStatsQuerier) MeasurementNames(ctx context.Context, database string) ([]string, error) {
+	return q.queryStrings(ctx, database, "SHOW MEASUREMENTS")
+}
+
+func (q sInfluxStatsQuerier) TagKeys(ctx context.Context, database string, measurement string) ([]string, error) {
+	return q.queryStrings(ctx, database, fmt.Sprintf("SHOW TAG KEYS FROM %q", measurement))
+}
+
+func (q sInfluxStatsQuerier) SeriesCardinality(ctx context.Context, database string, measurement string) (int64, error) {
+	return q.queryCount(ctx, database, fmt.Sprintf("SHOW SERIES CARDINALITY FROM %q", measurement))
+}
+
+func (q sInfluxStatsQuerier) TagValueCardinality(ctx context.Context, database string, measurement string, tagKey string) (int64, error) {
+	return q.queryCount(ctx, database, fmt.Sprintf("SHOW TAG VALUES CARDINALITY FROM %q WITH KEY = %q", measurement, tagKey))
+}
+
+// computeMeasurementCardinality gathers the series count and per-tag
+// distinct value counts of a measurement, flagging a warning when any
+// number exceeds threshold
+func computeMeasurementCardinality(ctx context.Context, querier measurementStatsQuerier, database string, measurement string, threshold int64) (*monitor.MeasurementCardinality, error) {
+	seriesCount, err := querier.SeriesCardinality(ctx, database, measurement)
+	if err != nil {
+		return nil, errors.Wrap(err, "series cardinality")
+	}
+	tagKeys, err := querier.TagKeys(ctx, database, measurement)
+	if err != nil {
+		return nil, errors.Wrap(err, "tag keys")
+	}
+	card := &monitor.MeasurementCardinality{
+		SeriesCount:    seriesCount,
+		TagCardinality: make(map[string]int64, len(tagKeys)),
+		ComputedAt:     time.Now().UTC(),
+	}
+	if threshold > 0 && seriesCount > threshold {
+		card.Warning = true
+	}
+	for _, key := range tagKeys {
+		count, err := querier.TagValueCardinality(ctx, database, measurement, key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag %s cardinality", key)
+		}
+		card.TagCardinality[key] = count
+		if threshold > 0 && count > threshold {
+			card.Warning = true
+		}
+	}
+	return card, nil
+}
+
+// topMeasurementsByCardinality ranks the measurements of a database by
+// series count descending, returning at most topN entries
+func topMeasurementsByCardinality(ctx context.Context, querier measurementStatsQuerier, database string, topN int) ([]monitor.MeasurementSeriesCount, error) {
+	names, err := querier.MeasurementNames(ctx, database)
+	if err != nil {
+		return nil, errors.Wrap(err, "measurement names")
+	}
+	counts := make([]monitor.MeasurementSeriesCount, 0, len(names))
+	for _, name := range names {
+		count, err := querier.SeriesCardinality(ctx, database, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "measurement %s cardinality", name)
+		}
+		counts = append(counts, monitor.MeasurementSeriesCount{
+			Measurement: name,
+			SeriesCount: count,
+		})
+	}
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].SeriesCount > counts[j].SeriesCount
+	})
+	if topN <= 0 {
+		topN = defaultTopCardinality
+	}
+	if len(counts) > topN {
+		counts = counts[:topN]
+	}
+	return counts, nil
+}
+
+// sCardinalityCache memoizes expensive cardinality results for a
+// fixed ttl
+type sCardinalityCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	entries map[string]sCardinalityCacheEntry
+}
+
+type sCardinalityCacheEntry struct {
+	value    interface{}
+	storedAt time.Time
+}
+
+func newCardinalityCache(ttl time.Duration) *sCardinalityCache {
+	return &sCardinalityCache{
+		ttl:     ttl,
+		entries: make(map[string]sCardinalityCacheEntry),
+	}
+}
+
+func (c *sCardinalityCache) Get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.storedAt) >= c.ttl {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *sCardinalityCache) Put(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[key] = sCardinalityCacheEntry{value: value, storedAt: time.Now()}
+}
+
+var cardinalityCache = newCardinalityCache(cardinalityCacheTTL)
+
+func (man *SDataSourceManager) AllowGetPropertyMeasurement(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return true
+}
+
+// GetPropertyMeasurement describes one measurement of the default
+// datasource database: its tag keys and, on request, the values of one
+// tag key and its series cardinality. Tag value discovery honors the
+// caller's scope, so project users only see values of their own series.
+// Cardinality queries are expensive, so they are gated behind
+// with_cardinality=true and cached for an hour.
+func (man *SDataSourceManager) GetPropertyMeasurement(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.DataSourceMeasurementInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if input.Measurement == "" {
+		return nil, httperrors.NewMissingParameterError("measurement")
+	}
+	scope, err := fetchMeasurementScope(userCred, query)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	database := input.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout())
+	defer cancel()
+	querier := sInfluxStatsQuerier{ds: ds}
+	tagKeys, err := querier.TagKeys(ctx, database, input.Measurement)
+	if err != nil {
+		return nil, errors.Wrap(err, "query tag keys")
+	}
+	output := monitor.DataSourceMeasurementOutput{
+		Measurement: input.Measurement,
+		TagKeys:     tagKeys,
+	}
+	if input.TagKey != "" {
+		from := input.From
+		if from != "" && !supportsTimeBoundedTagValues(ctx, ds.Id, querier) {
+			// influxdb before 1.2 rejects time conditions in SHOW
+			// TAG VALUES; fall back to the unbounded scan
+			from = ""
+		}
+		tagCtx, tagCancel := context.WithTimeout(ctx, tagValueQueryTimeout())
+		values, err := browseTagValues(tagCtx, querier, database, input.Measurement, input.TagKey, from, scope)
+		tagCancel()
+		if err != nil {
+			return nil, errors.Wrap(err, "query tag values")
+		}
+		output.TagValues = values
+	}
+	if input.WithFieldTypes {
+		fields, err := querier.FieldKeys(ctx, database, input.Measurement)
+		if err != nil {
+			return nil, errors.Wrap(err, "query field keys")
+		}
+		output.FieldTypes = fieldTypesFromSchemas(fields)
+	}
+	if input.WithLastDatapoint {
+		last, err := querier.LastDatapoint(ctx, database, input.Measurement, input.From, scope)
+		if err != nil {
+			return nil, errors.Wrap(err, "query last datapoint")
+		}
+		if !last.IsZero() {
+			output.LastDatapointAt = &last
+		}
+	}
+	if input.WithCardinality {
+		if !scope.IsSystem() {
+			return nil, httperrors.NewForbiddenError("cardinality statistics require system scope")
+		}
+		card, err := man.getMeasurementCardinality(ctx, querier, database, input.Measurement)
+		if err != nil {
+			return nil, err
+		}
+		output.Cardinality = card
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (man *SDataSourceManager) getMeasurementCardinality(ctx context.Context, querier measurementStatsQuerier, database string, measurement string) (*monitor.MeasurementCardinality, error) {
+	key := fmt.Sprintf("measurement/%s/%s", database, measurement)
+	if cached, ok := cardinalityCache.Get(key); ok {
+		return cached.(*monitor.MeasurementCardinality), nil
+	}
+	card, err := computeMeasurementCardinality(ctx, querier, database, measurement,
+		int64(options.Options.MeasurementCardinalityWarnThreshold))
+	if err != nil {
+		return nil, errors.Wrap(err, "compute cardinality")
+	}
+	cardinalityCache.Put(key, card)
+	return card, nil
+}
+
+func (man *SDataSourceManager) AllowGetPropertyCardinalities(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return userCred.HasSystemAdminPrivilege()
+}
+
+// GetPropertyCardinalities lists the top-N measurements of a database
+// by series cardinality so operators can spot tag explosion offenders
+func (man *SDataSourceManager) GetPropertyCardinalities(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.DataSourceCardinalitiesInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	database := input.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	key := fmt.Sprintf("cardinalities/%s/%d", database, input.Top)
+	if cached, ok := cardinalityCache.Get(key); ok {
+		return jsonutils.Marshal(cached.(*monitor.DataSourceCardinalitiesOutput)), nil
+	}
+	counts, err := topMeasurementsByCardinality(ctx, sInfluxStatsQuerier{ds: ds}, database, input.Top)
+	if err != nil {
+		return nil, err
+	}
+	output := &monitor.DataSourceCardinalitiesOutput{Measurements: counts}
+	cardinalityCache.Put(key, output)
+	return jsonutils.Marshal(output), nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+// stubStatsQuerier serves synthetic cardinality numbers in place of
+// influxdb
+type stubStatsQuerier struct {
+	measurements []string
+	tagKeys      map[string][]string
+	seriesCounts map[string]int64
+	tagCounts    map[string]int64
+}
+
+func (q stubStatsQuerier) MeasurementNames(ctx context.Context, database string) ([]string, error) {
+	return q.measurements, nil
+}
+
+func (q stubStatsQuerier) TagKeys(ctx context.Context, database string, measurement string) ([]string, error) {
+	return q.tagKeys[measurement], nil
+}
+
+func (q stubStatsQuerier) SeriesCardinality(ctx context.Context, database string, measurement string) (int64, error) {
+	return q.seriesCounts[measurement], nil
+}
+
+func (q stubStatsQuerier) TagValueCardinality(ctx context.Context, database string, measurement string, tagKey string) (int64, error) {
+	return q.tagCounts[measurement+"/"+tagKey], nil
+}
+
+func TestComputeMeasurementCardinality(t *testing.T) {
+	querier := stubStatsQuerier{
+		tagKeys:      map[string][]string{"vm_diskio": {"host_id", "disk_serial"}},
+		seriesCounts: map[string]int64{"vm_diskio": 5000},
+		tagCounts: map[string]int64{
+			"vm_diskio/host_id":     200,
+			"vm_diskio/disk_serial": 4800,
+		},
+	}
+
+	card, err := computeMeasurementCardinality(context.Background(), querier, "telegraf", "vm_diskio", 1000)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if card.SeriesCount != 5000 {
+		t.Errorf("SeriesCount = %d, want 5000", card.SeriesCount)
+	}
+	if card.TagCardinality["disk_serial"] != 4800 || card.TagCardinality["host_id"] != 200 {
+		t.Errorf("TagCardinality = %v", card.TagCardinality)
+	}
+	if !card.Warning {
+		t.Errorf("expect warning when cardinality exceeds threshold")
+	}
+
+	card, err = computeMeasurementCardinality(context.Background(), querier, "telegraf", "vm_diskio", 10000)
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if card.Warning {
+		t.Errorf("no number exceeds 10000, expect no warning")
+	}
+}
+
+func TestTopMeasurementsByCardinality(t *testing.T) {
+	querier := stubStatsQuerier{
+		measurements: []string{"cpu", "vm_diskio", "mem"},
+		seriesCounts: map[string]int64{"cpu": 100, "vm_diskio": 5000, "mem": 300},
+	}
+
+	counts, err := topMeasurementsByCardinality(context.Background(), querier, "telegraf", 2)
+	if err != nil {
+		t.Fatalf("top: %v", err)
+	}
+	if len(counts) != 2 {
+		t.Fatalf("expect 2 entries, got %d", len(counts))
+	}
+	if counts[0].Measurement != "vm_diskio" || counts[0].SeriesCount != 5000 {
+		t.Errorf("counts[0] = %+v, want vm_diskio 5000", counts[0])
+	}
+	if counts[1].Measurement != "mem" {
+		t.Errorf("counts[1] = %+v, want mem", counts[1])
+	}
+
+	// default top keeps all three
+	counts, err = topMeasurementsByCardinality(context.Background(), querier, "telegraf", 0)
+	if err != nil {
+		t.Fatalf("top: %v", err)
+	}
+	if len(counts) != 3 {
+		t.Errorf("expect all 3 measurements with default top, got %d", len(counts))
+	}
+}
+
+func TestCardinalityCacheExpiry(t *testing.T) {
+	cache := newCardinalityCache(50 * time.Millisecond)
+	cache.Put("key", 42)
+	if v, ok := cache.Get("key"); !ok || v.(int) != 42 {
+		t.Fatalf("expect cache hit with 42, got %v %v", v, ok)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("expect cache entry expired")
+	}
+	if _, ok := cache.Get("missing"); ok {
+		t.Errorf("expect miss for unknown key")
+	}
+}
+
+func TestLastDatapointQL(t *testing.T) {
+	cases := []struct {
+		measurement string
+		from        string
+		scope       sMeasurementScope
+		want        string
+	}{
+		{"vm_cpu", "", sMeasurementScope{}, `SELECT last(*) FROM "vm_cpu"`},
+		{"vm_cpu", "24h", sMeasurementScope{}, `SELECT last(*) FROM "vm_cpu" WHERE time > now() - 24h`},
+		{"disk", "30m", sMeasurementScope{}, `SELECT last(*) FROM "disk" WHERE time > now() - 30m`},
+		{"vm_cpu", "", sMeasurementScope{TenantId: "p1"}, `SELECT last(*) FROM "vm_cpu" WHERE tenant_id = 'p1'`},
+		{"vm_cpu", "24h", sMeasurementScope{TenantId: "p1"}, `SELECT last(*) FROM "vm_cpu" WHERE time > now() - 24h AND tenant_id = 'p1'`},
+	}
+	for _, c := range cases {
+		if got := lastDatapointQL(c.measurement, c.from, c.scope); got != c.want {
+			t.Errorf("lastDatapointQL(%s, %s, %+v) = %q, want %q", c.measurement, c.from, c.scope, got, c.want)
+		}
+	}
+}
+
+func TestFieldTypesFromSchemas(t *testing.T) {
+	fields := []monitor.MeasurementFieldSchema{
+		{Name: "usage_active", Type: "float"},
+		{Name: "read_count", Type: "integer"},
+		{Name: "host", Type: "string"},
+	}
+	types := fieldTypesFromSchemas(fields)
+	if len(types) != 3 {
+		t.Fatalf("expect 3 field types, got %d", len(types))
+	}
+	if types["usage_active"] != "float" || types["read_count"] != "integer" || types["host"] != "string" {
+		t.Errorf("unexpected field types %v", types)
+	}
+	if len(fieldTypesFromSchemas(nil)) != 0 {
+		t.Errorf("expect empty map for no fields")
+	}
+}
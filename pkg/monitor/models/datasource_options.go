@@ -0,0 +1,80 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+// defaults the query tuning falls back to when the configured option
+// is zero or negative
+const (
+	defaultQueryTimeout         = 30 * time.Second
+	defaultTagValueQueryTimeout = 10 * time.Second
+	defaultMetaQueryConcurrency = 8
+)
+
+// invalidOptionWarned remembers which misconfigured options were
+// already reported, so a bad value is logged once instead of on every
+// query
+var invalidOptionWarned sync.Map
+
+func warnInvalidOptionOnce(name string, value int) {
+	if _, loaded := invalidOptionWarned.LoadOrStore(name, struct{}{}); !loaded {
+		log.Warningf("invalid option %s=%d, falling back to default", name, value)
+	}
+}
+
+// durationOption converts a seconds option into a duration, falling
+// back to def for zero or negative values. Options are read at call
+// time so the service config sync retunes running queries without a
+// restart.
+func durationOption(name string, seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	warnInvalidOptionOnce(name, seconds)
+	return def
+}
+
+func concurrencyOption(name string, value int, def int) int {
+	if value > 0 {
+		return value
+	}
+	warnInvalidOptionOnce(name, value)
+	return def
+}
+
+// queryTimeout bounds ad-hoc and measurement discovery queries
+func queryTimeout() time.Duration {
+	return durationOption("query_timeout_seconds", options.Options.QueryTimeoutSeconds, defaultQueryTimeout)
+}
+
+// tagValueQueryTimeout bounds the tag value discovery queries, which
+// are cheaper and serve interactive pickers
+func tagValueQueryTimeout() time.Duration {
+	return durationOption("tag_value_query_timeout_seconds", options.Options.TagValueQueryTimeoutSeconds, defaultTagValueQueryTimeout)
+}
+
+// metaQueryConcurrency bounds the parallel per-measurement metadata
+// queries of the measurement listing
+func metaQueryConcurrency() int {
+	return concurrencyOption("meta_query_concurrency", options.Options.MetaQueryConcurrency, defaultMetaQueryConcurrency)
+}
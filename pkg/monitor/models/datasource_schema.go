@@ -0,0 +1,292 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+const (
+	// schemaCatalogCacheTTL is how long an assembled catalog page is
+	// reused, so etag polling does not walk the measurements every time
+	schemaCatalogCacheTTL = 5 * time.Minute
+
+	SchemaFormatJson = "json"
+	SchemaFormatYaml = "yaml"
+)
+
+// measurementSchemaQuerier answers the metadata statements the schema
+// catalog needs, replaceable in tests
+type measurementSchemaQuerier interface {
+	MeasurementNames(ctx context.Context, database string) ([]string, error)
+	TagKeys(ctx context.Context, database string, measurement string) ([]string, error)
+	FieldKeys(ctx context.Context, database string, measurement string) ([]monitor.MeasurementFieldSchema, error)
+}
+
+func (q sInfluxStatsQuerier) FieldKeys(ctx context.Context, database string, measurement string) ([]monitor.MeasurementFieldSchema, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", fmt.Sprintf("SHOW FIELD KEYS FROM %q", measurement))
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]monitor.MeasurementFieldSchema, 0)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, value := range series.Values {
+				if len(value) < 2 || value[0] == nil || value[1] == nil {
+					continue
+				}
+				name, err := value[0].GetString()
+				if err != nil {
+					continue
+				}
+				ftype, err := value[1].GetString()
+				if err != nil {
+					continue
+				}
+				fields = append(fields, monitor.MeasurementFieldSchema{
+					Name: name,
+					Type: ftype,
+					Unit: fieldUnitByConvention(name),
+				})
+			}
+		}
+	}
+	return fields, nil
+}
+
+// fieldUnitByConvention infers the unit of a field from the naming
+// conventions telegraf and the onecloud agents follow; an empty string
+// means unknown
+func fieldUnitByConvention(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_percent") || strings.HasSuffix(name, "percent"):
+		return "%"
+	case strings.HasSuffix(name, "_ms"):
+		return "ms"
+	case strings.HasSuffix(name, "_bytes") || strings.HasSuffix(name, "_size"):
+		return "byte"
+	case strings.HasSuffix(name, "_bps"):
+		return "bps"
+	case strings.HasSuffix(name, "_count"):
+		return "count"
+	}
+	return ""
+}
+
+// wellKnownMeasurementDescriptions documents the common telegraf and
+// onecloud agent measurements, best effort only
+var wellKnownMeasurementDescriptions = map[string]string{
+	"cpu":        "host cpu usage",
+	"mem":        "host memory usage",
+	"disk":       "host filesystem usage",
+	"diskio":     "host disk io throughput",
+	"net":        "host network interface traffic",
+	"netstat":    "host tcp connection states",
+	"vm_cpu":     "guest cpu usage",
+	"vm_mem":     "guest memory usage",
+	"vm_disk":    "guest disk usage",
+	"vm_diskio":  "guest disk io throughput",
+	"vm_netio":   "guest network traffic",
+	"usage":      "platform resource usage statistics",
+	"swap":       "host swap usage",
+	"kernel":     "host kernel statistics",
+	"processes":  "host process states",
+	"system":     "host load and uptime",
+	"ntp":        "host clock offset",
+	"smart_attr": "disk smart attributes",
+}
+
+// filterMeasurementPrefix keeps the names starting with prefix, an
+// empty prefix keeps everything
+func filterMeasurementPrefix(names []string, prefix string) []string {
+	if prefix == "" {
+		return names
+	}
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, prefix) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+// pageSchemaNames applies limit/offset with max as both the default
+// and the upper bound of the page size, so a huge database never turns
+// into one giant response
+func pageSchemaNames(names []string, limit int, offset int, max int) ([]string, int) {
+	total := len(names)
+	if limit <= 0 || (max > 0 && limit > max) {
+		limit = max
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total {
+		return []string{}, total
+	}
+	end := offset + limit
+	if end > total || limit <= 0 {
+		end = total
+	}
+	return names[offset:end], total
+}
+
+// buildSchemaCatalog queries the tag and field keys of every
+// measurement with at most concurrency in-flight queries and assembles
+// the catalog document
+func buildSchemaCatalog(ctx context.Context, querier measurementSchemaQuerier, database string, names []string, concurrency int) (map[string]monitor.MeasurementSchema, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	catalog := make(map[string]monitor.MeasurementSchema, len(names))
+	var lock sync.Mutex
+	errgrp, ctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, concurrency)
+	for i := range names {
+		name := names[i]
+		errgrp.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			fields, err := querier.FieldKeys(ctx, database, name)
+			if err != nil {
+				return errors.Wrapf(err, "measurement %s field keys", name)
+			}
+			tags, err := querier.TagKeys(ctx, database, name)
+			if err != nil {
+				return errors.Wrapf(err, "measurement %s tag keys", name)
+			}
+			schema := monitor.MeasurementSchema{
+				Fields:      fields,
+				Tags:        tags,
+				Description: wellKnownMeasurementDescriptions[name],
+			}
+			lock.Lock()
+			catalog[name] = schema
+			lock.Unlock()
+			return nil
+		})
+	}
+	if err := errgrp.Wait(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// catalogEtag fingerprints a catalog page so clients can poll with the
+// etag instead of re-downloading an unchanged document
+func catalogEtag(catalog map[string]monitor.MeasurementSchema, total int) string {
+	names := make([]string, 0, len(catalog))
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := md5.New()
+	fmt.Fprintf(hash, "total=%d\n", total)
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s:%s\n", name, jsonutils.Marshal(catalog[name]).String())
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil))
+}
+
+var schemaCatalogCache = newCardinalityCache(schemaCatalogCacheTTL)
+
+func (man *SDataSourceManager) AllowGetPropertySchema(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return true
+}
+
+// GetPropertySchema exports the measurement catalog of a database as a
+// machine-readable document: per measurement its fields with types and
+// inferred units, tag keys and a best-effort description. The catalog
+// is paginated with a configurable page cap, cached briefly, carries
+// an etag for cheap polling and can be rendered as yaml via
+// format=yaml.
+func (man *SDataSourceManager) GetPropertySchema(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.DataSourceSchemaInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if input.Format != "" && input.Format != SchemaFormatJson && input.Format != SchemaFormatYaml {
+		return nil, httperrors.NewInputParameterError("unsupported format %s", input.Format)
+	}
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	database := input.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	output, err := man.getSchemaCatalogPage(ctx, sInfluxStatsQuerier{ds: ds}, database, input)
+	if err != nil {
+		return nil, err
+	}
+	if input.Etag != "" && input.Etag == output.Etag {
+		notModified := monitor.DataSourceSchemaOutput{
+			Total:       output.Total,
+			Etag:        output.Etag,
+			NotModified: true,
+		}
+		return jsonutils.Marshal(notModified), nil
+	}
+	if input.Format == SchemaFormatYaml {
+		output.Yaml = jsonutils.Marshal(output.Measurements).YAMLString()
+		output.Measurements = nil
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (man *SDataSourceManager) getSchemaCatalogPage(ctx context.Context, querier measurementSchemaQuerier, database string, input monitor.DataSourceSchemaInput) (*monitor.DataSourceSchemaOutput, error) {
+	key := fmt.Sprintf("schema/%s/%s/%d/%d", database, input.MeasurementPrefix, input.Limit, input.Offset)
+	if cached, ok := schemaCatalogCache.Get(key); ok {
+		return cached.(*monitor.DataSourceSchemaOutput), nil
+	}
+	names, err := querier.MeasurementNames(ctx, database)
+	if err != nil {
+		return nil, errors.Wrap(err, "measurement names")
+	}
+	names = filterMeasurementPrefix(names, input.MeasurementPrefix)
+	sort.Strings(names)
+	page, total := pageSchemaNames(names, input.Limit, input.Offset, options.Options.SchemaCatalogMaxMeasurements)
+	catalog, err := buildSchemaCatalog(ctx, querier, database, page, options.Options.SchemaCatalogConcurrency)
+	if err != nil {
+		return nil, errors.Wrap(err, "build schema catalog")
+	}
+	output := &monitor.DataSourceSchemaOutput{
+		Total:        total,
+		Etag:         catalogEtag(catalog, total),
+		Measurements: catalog,
+	}
+	schemaCatalogCache.Put(key, output)
+	return output, nil
+}
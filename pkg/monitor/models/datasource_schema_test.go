@@ -0,0 +1,145 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+// stubSchemaQuerier serves synthetic measurement metadata in place of
+// influxdb
+type stubSchemaQuerier struct {
+	measurements []string
+	tagKeys      map[string][]string
+	fieldKeys    map[string][]monitor.MeasurementFieldSchema
+}
+
+func (q stubSchemaQuerier) MeasurementNames(ctx context.Context, database string) ([]string, error) {
+	return q.measurements, nil
+}
+
+func (q stubSchemaQuerier) TagKeys(ctx context.Context, database string, measurement string) ([]string, error) {
+	return q.tagKeys[measurement], nil
+}
+
+func (q stubSchemaQuerier) FieldKeys(ctx context.Context, database string, measurement string) ([]monitor.MeasurementFieldSchema, error) {
+	return q.fieldKeys[measurement], nil
+}
+
+func TestBuildSchemaCatalog(t *testing.T) {
+	querier := stubSchemaQuerier{
+		measurements: []string{"cpu", "vm_cpu", "vm_mem"},
+		tagKeys: map[string][]string{
+			"cpu":    {"host"},
+			"vm_cpu": {"vm_id", "host_id"},
+			"vm_mem": {"vm_id"},
+		},
+		fieldKeys: map[string][]monitor.MeasurementFieldSchema{
+			"cpu":    {{Name: "usage_percent", Type: "float", Unit: "%"}},
+			"vm_cpu": {{Name: "usage_active", Type: "float"}},
+			"vm_mem": {{Name: "used_percent", Type: "float", Unit: "%"}},
+		},
+	}
+
+	catalog, err := buildSchemaCatalog(context.Background(), querier, "telegraf", querier.measurements, 2)
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if len(catalog) != 3 {
+		t.Fatalf("expect 3 measurements, got %d", len(catalog))
+	}
+	vmCpu := catalog["vm_cpu"]
+	if len(vmCpu.Tags) != 2 || vmCpu.Tags[0] != "vm_id" {
+		t.Errorf("vm_cpu tags = %v", vmCpu.Tags)
+	}
+	if vmCpu.Description == "" {
+		t.Errorf("expect description for well-known measurement vm_cpu")
+	}
+	if len(catalog["cpu"].Fields) != 1 || catalog["cpu"].Fields[0].Unit != "%" {
+		t.Errorf("cpu fields = %v", catalog["cpu"].Fields)
+	}
+}
+
+func TestSchemaCatalogPrefixAndPaging(t *testing.T) {
+	names := []string{"cpu", "vm_cpu", "vm_disk", "vm_mem", "vm_netio"}
+
+	filtered := filterMeasurementPrefix(names, "vm_")
+	if len(filtered) != 4 {
+		t.Fatalf("prefix filter = %v, want 4 vm_ measurements", filtered)
+	}
+	if got := filterMeasurementPrefix(names, ""); len(got) != len(names) {
+		t.Errorf("empty prefix should keep everything, got %v", got)
+	}
+
+	page, total := pageSchemaNames(filtered, 2, 1, 500)
+	if total != 4 {
+		t.Errorf("total = %d, want 4", total)
+	}
+	if len(page) != 2 || page[0] != "vm_disk" || page[1] != "vm_mem" {
+		t.Errorf("page = %v, want [vm_disk vm_mem]", page)
+	}
+
+	// the configured cap bounds both the default and oversized limits
+	page, _ = pageSchemaNames(filtered, 0, 0, 3)
+	if len(page) != 3 {
+		t.Errorf("default limit page = %v, want 3 entries", page)
+	}
+	page, _ = pageSchemaNames(filtered, 100, 0, 3)
+	if len(page) != 3 {
+		t.Errorf("oversized limit page = %v, want 3 entries", page)
+	}
+
+	page, total = pageSchemaNames(filtered, 2, 10, 500)
+	if len(page) != 0 || total != 4 {
+		t.Errorf("out of range offset: page = %v total = %d", page, total)
+	}
+}
+
+func TestCatalogEtag(t *testing.T) {
+	catalog := map[string]monitor.MeasurementSchema{
+		"cpu": {Tags: []string{"host"}},
+		"mem": {Tags: []string{"host"}},
+	}
+	etag := catalogEtag(catalog, 2)
+	if etag == "" {
+		t.Fatalf("expect non-empty etag")
+	}
+	if catalogEtag(catalog, 2) != etag {
+		t.Errorf("etag must be stable for identical catalogs")
+	}
+	catalog["mem"] = monitor.MeasurementSchema{Tags: []string{"host", "numa_node"}}
+	if catalogEtag(catalog, 2) == etag {
+		t.Errorf("etag must change when a measurement schema changes")
+	}
+}
+
+func TestFieldUnitByConvention(t *testing.T) {
+	cases := map[string]string{
+		"used_percent": "%",
+		"read_bps":     "bps",
+		"latency_ms":   "ms",
+		"used_bytes":   "byte",
+		"conn_count":   "count",
+		"usage_active": "",
+	}
+	for name, want := range cases {
+		if got := fieldUnitByConvention(name); got != want {
+			t.Errorf("fieldUnitByConvention(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+	"yunion.io/x/onecloud/pkg/util/rbacutils"
+)
+
+// sMeasurementScope captures the visibility a caller has when browsing
+// measurements and tag values: an empty TenantId means cluster-wide
+// visibility, otherwise every discovery query is restricted to the
+// series carrying that tenant_id tag.
+type sMeasurementScope struct {
+	TenantId string
+}
+
+// IsSystem reports whether the scope grants unrestricted visibility
+func (s sMeasurementScope) IsSystem() bool {
+	return s.TenantId == ""
+}
+
+// fetchMeasurementScope resolves the standard scope query parameter
+// into a measurement browsing scope. System admins default to system
+// scope and may narrow to their own project; everyone else is pinned
+// to project scope and requesting a wider one is rejected.
+func fetchMeasurementScope(userCred mcclient.TokenCredential, query jsonutils.JSONObject) (sMeasurementScope, error) {
+	var scope rbacutils.TRbacScope
+	scopeStr, _ := query.GetString("scope")
+	if scopeStr == "" {
+		if userCred.HasSystemAdminPrivilege() {
+			scope = rbacutils.ScopeSystem
+		} else {
+			scope = rbacutils.ScopeProject
+		}
+	} else {
+		scope = rbacutils.String2Scope(scopeStr)
+	}
+	switch scope {
+	case rbacutils.ScopeSystem, rbacutils.ScopeDomain:
+		if !userCred.HasSystemAdminPrivilege() {
+			return sMeasurementScope{}, httperrors.NewForbiddenError("scope %s not allowed", scope)
+		}
+		return sMeasurementScope{}, nil
+	default:
+		return sMeasurementScope{TenantId: userCred.GetProjectId()}, nil
+	}
+}
+
+// escapeTagValue neutralizes backslashes and single quotes so a tag
+// value can be embedded into an influxql string literal; backslashes
+// go first so a trailing '\' cannot escape the closing quote
+func escapeTagValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, "'", `\'`)
+}
+
+// tagValuesQL renders the tag value discovery statement, restricted by
+// tenant_id unless the scope is system-wide. A non-empty from bounds
+// the scan to the shards of the recent time window, which is
+// dramatically cheaper on large databases; callers must only pass it
+// when the server supports time-bounded SHOW TAG VALUES.
+func tagValuesQL(measurement string, tagKey string, from string, scope sMeasurementScope) string {
+	sql := fmt.Sprintf("SHOW TAG VALUES FROM %q WITH KEY = %q", measurement, tagKey)
+	conds := make([]string, 0, 2)
+	if from != "" {
+		conds = append(conds, fmt.Sprintf("time > now() - %s", from))
+	}
+	if !scope.IsSystem() {
+		conds = append(conds, fmt.Sprintf("tenant_id = '%s'", escapeTagValue(scope.TenantId)))
+	}
+	if len(conds) > 0 {
+		sql = fmt.Sprintf("%s WHERE %s", sql, strings.Join(conds, " AND "))
+	}
+	return sql
+}
+
+// measurementTagValuesQuerier answers a scoped tag value discovery
+// query, replaceable in tests
+type measurementTagValuesQuerier interface {
+	TagValues(ctx context.Context, database string, measurement string, tagKey string, from string, scope sMeasurementScope) ([]string, error)
+}
+
+func (q sInfluxStatsQuerier) TagValues(ctx context.Context, database string, measurement string, tagKey string, from string, scope sMeasurementScope) ([]string, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", tagValuesQL(measurement, tagKey, from, scope))
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			// SHOW TAG VALUES rows are (key, value) pairs, the tag
+			// value is the last column
+			for _, row := range series.Values {
+				if len(row) == 0 || row[len(row)-1] == nil {
+					continue
+				}
+				str, err := row[len(row)-1].GetString()
+				if err != nil {
+					continue
+				}
+				values = append(values, str)
+			}
+		}
+	}
+	return values, nil
+}
+
+// browseTagValues lists the distinct values of a tag key visible to
+// the given scope, sorted for a stable response. from bounds the
+// discovery to the recent time window when non-empty.
+func browseTagValues(ctx context.Context, querier measurementTagValuesQuerier, database string, measurement string, tagKey string, from string, scope sMeasurementScope) ([]string, error) {
+	values, err := querier.TagValues(ctx, database, measurement, tagKey, from, scope)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// serverVersionQuerier reports the influxdb build version, replaceable
+// in tests
+type serverVersionQuerier interface {
+	ServerVersion(ctx context.Context) (string, error)
+}
+
+func (q sInfluxStatsQuerier) ServerVersion(ctx context.Context) (string, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, "", "", "SHOW DIAGNOSTICS")
+	if err != nil {
+		return "", err
+	}
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			if series.Name != "build" {
+				continue
+			}
+			if version := versionFromDiagnostics(series.Columns, series.Values); version != "" {
+				return version, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// versionFromDiagnostics extracts the server version from the columns
+// and rows of the build series of a SHOW DIAGNOSTICS result
+func versionFromDiagnostics(columns []string, values [][]jsonutils.JSONObject) string {
+	idx := -1
+	for i, column := range columns {
+		if strings.EqualFold(column, "version") {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return ""
+	}
+	for _, row := range values {
+		if idx >= len(row) || row[idx] == nil {
+			continue
+		}
+		if version, err := row[idx].GetString(); err == nil && version != "" {
+			return version
+		}
+	}
+	return ""
+}
+
+// influxVersionAtLeast reports whether a version string like 1.8.10 is
+// at least major.minor. Unparseable versions count as older.
+func influxVersionAtLeast(version string, major int, minor int) bool {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	gotMinor, err := strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+	if err != nil {
+		return false
+	}
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// tagValueTimeBoundSupport caches per datasource whether the server
+// accepts time conditions in SHOW TAG VALUES, probed once via SHOW
+// DIAGNOSTICS
+var tagValueTimeBoundSupport sync.Map
+
+// supportsTimeBoundedTagValues reports whether the datasource server
+// supports time-bounded SHOW TAG VALUES (influxdb >= 1.2). A failed
+// probe is not cached, so the next request tries again.
+func supportsTimeBoundedTagValues(ctx context.Context, dsId string, querier serverVersionQuerier) bool {
+	if cached, ok := tagValueTimeBoundSupport.Load(dsId); ok {
+		return cached.(bool)
+	}
+	version, err := querier.ServerVersion(ctx)
+	if err != nil {
+		return false
+	}
+	supported := influxVersionAtLeast(version, 1, 2)
+	tagValueTimeBoundSupport.Store(dsId, supported)
+	return supported
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+func newTestAdminToken() mcclient.TokenCredential {
+	return &mcclient.SSimpleToken{
+		User:      "sysadmin",
+		UserId:    "sysadmin-id",
+		Project:   "system",
+		ProjectId: "system-id",
+		Roles:     "admin",
+	}
+}
+
+func newTestProjectToken(project string, projectId string) mcclient.TokenCredential {
+	return &mcclient.SSimpleToken{
+		User:      "member",
+		UserId:    "member-id",
+		Project:   project,
+		ProjectId: projectId,
+		Roles:     "member",
+	}
+}
+
+func scopeQuery(scope string) jsonutils.JSONObject {
+	query := jsonutils.NewDict()
+	if scope != "" {
+		query.Set("scope", jsonutils.NewString(scope))
+	}
+	return query
+}
+
+func TestFetchMeasurementScope(t *testing.T) {
+	admin := newTestAdminToken()
+	user := newTestProjectToken("demo", "demo-id")
+
+	// admin defaults to system-wide visibility
+	scope, err := fetchMeasurementScope(admin, scopeQuery(""))
+	if err != nil {
+		t.Fatalf("admin default scope: %v", err)
+	}
+	if !scope.IsSystem() {
+		t.Errorf("admin default scope = %+v, want system", scope)
+	}
+
+	// admin may narrow to the own project
+	scope, err = fetchMeasurementScope(admin, scopeQuery("project"))
+	if err != nil {
+		t.Fatalf("admin project scope: %v", err)
+	}
+	if scope.IsSystem() || scope.TenantId != "system-id" {
+		t.Errorf("admin project scope = %+v, want tenant system-id", scope)
+	}
+
+	// project users default to their own project
+	scope, err = fetchMeasurementScope(user, scopeQuery(""))
+	if err != nil {
+		t.Fatalf("user default scope: %v", err)
+	}
+	if scope.IsSystem() || scope.TenantId != "demo-id" {
+		t.Errorf("user default scope = %+v, want tenant demo-id", scope)
+	}
+
+	// project users cannot request wider scopes
+	for _, wide := range []string{"system", "domain"} {
+		if _, err := fetchMeasurementScope(user, scopeQuery(wide)); err == nil {
+			t.Errorf("expect scope %s rejected for project user", wide)
+		}
+	}
+}
+
+func TestTagValuesQL(t *testing.T) {
+	cases := []struct {
+		measurement string
+		tagKey      string
+		from        string
+		scope       sMeasurementScope
+		want        string
+	}{
+		{"vm_cpu", "vm_name", "", sMeasurementScope{}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_name"`},
+		{"vm_cpu", "vm_name", "", sMeasurementScope{TenantId: "demo-id"}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_name" WHERE tenant_id = 'demo-id'`},
+		{"vm_cpu", "vm_ip", "", sMeasurementScope{TenantId: "o'brien"}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_ip" WHERE tenant_id = 'o\'brien'`},
+		{"vm_cpu", "vm_ip", "", sMeasurementScope{TenantId: `C:\Users\`}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_ip" WHERE tenant_id = 'C:\\Users\\'`},
+		{"vm_cpu", "vm_name", "24h", sMeasurementScope{}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_name" WHERE time > now() - 24h`},
+		{"vm_cpu", "vm_name", "24h", sMeasurementScope{TenantId: "demo-id"}, `SHOW TAG VALUES FROM "vm_cpu" WITH KEY = "vm_name" WHERE time > now() - 24h AND tenant_id = 'demo-id'`},
+	}
+	for _, c := range cases {
+		if got := tagValuesQL(c.measurement, c.tagKey, c.from, c.scope); got != c.want {
+			t.Errorf("tagValuesQL(%s, %s, %q, %+v) = %q, want %q", c.measurement, c.tagKey, c.from, c.scope, got, c.want)
+		}
+	}
+}
+
+// stubTagValuesQuerier serves tag values from a fixed per-tenant data
+// set, interpreting the scope the way influxdb would interpret the
+// rendered tenant_id condition
+type stubTagValuesQuerier struct {
+	byTenant map[string][]string
+}
+
+func (q stubTagValuesQuerier) TagValues(ctx context.Context, database string, measurement string, tagKey string, from string, scope sMeasurementScope) ([]string, error) {
+	if scope.IsSystem() {
+		all := make([]string, 0)
+		for _, values := range q.byTenant {
+			all = append(all, values...)
+		}
+		return all, nil
+	}
+	return q.byTenant[scope.TenantId], nil
+}
+
+func TestBrowseTagValuesScoping(t *testing.T) {
+	querier := stubTagValuesQuerier{
+		byTenant: map[string][]string{
+			"demo-id":  {"vm-demo-2", "vm-demo-1"},
+			"other-id": {"vm-other-1"},
+		},
+	}
+	ctx := context.Background()
+
+	adminScope, err := fetchMeasurementScope(newTestAdminToken(), scopeQuery(""))
+	if err != nil {
+		t.Fatalf("admin scope: %v", err)
+	}
+	values, err := browseTagValues(ctx, querier, "telegraf", "vm_cpu", "vm_name", "", adminScope)
+	if err != nil {
+		t.Fatalf("admin browse: %v", err)
+	}
+	if want := []string{"vm-demo-1", "vm-demo-2", "vm-other-1"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("admin tag values = %v, want %v", values, want)
+	}
+
+	userScope, err := fetchMeasurementScope(newTestProjectToken("demo", "demo-id"), scopeQuery(""))
+	if err != nil {
+		t.Fatalf("user scope: %v", err)
+	}
+	values, err = browseTagValues(ctx, querier, "telegraf", "vm_cpu", "vm_name", "", userScope)
+	if err != nil {
+		t.Fatalf("user browse: %v", err)
+	}
+	if want := []string{"vm-demo-1", "vm-demo-2"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("project user tag values = %v, want %v", values, want)
+	}
+}
+
+func TestInfluxVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version string
+		want    bool
+	}{
+		{"1.2.0", true},
+		{"1.8.10", true},
+		{"v1.8.10", true},
+		{"2.0.4", true},
+		{"1.1.5", false},
+		{"1.2-rc1", true},
+		{"0.13.0", false},
+		{"", false},
+		{"devel", false},
+	}
+	for _, c := range cases {
+		if got := influxVersionAtLeast(c.version, 1, 2); got != c.want {
+			t.Errorf("influxVersionAtLeast(%q, 1, 2) = %v, want %v", c.version, got, c.want)
+		}
+	}
+}
+
+func TestVersionFromDiagnostics(t *testing.T) {
+	columns := []string{"Branch", "Build Time", "Commit", "Version"}
+	values := [][]jsonutils.JSONObject{
+		{
+			jsonutils.NewString("1.8"),
+			jsonutils.NewString(""),
+			jsonutils.NewString("abcdef"),
+			jsonutils.NewString("1.8.10"),
+		},
+	}
+	if got := versionFromDiagnostics(columns, values); got != "1.8.10" {
+		t.Errorf("version = %q, want 1.8.10", got)
+	}
+	if got := versionFromDiagnostics([]string{"Branch"}, values); got != "" {
+		t.Errorf("missing version column must yield empty, got %q", got)
+	}
+	if got := versionFromDiagnostics(columns, nil); got != "" {
+		t.Errorf("no rows must yield empty, got %q", got)
+	}
+}
+
+// stubVersionQuerier answers a fixed server version and counts probes
+type stubVersionQuerier struct {
+	version string
+	err     error
+	probes  int
+}
+
+func (q *stubVersionQuerier) ServerVersion(ctx context.Context) (string, error) {
+	q.probes++
+	return q.version, q.err
+}
+
+func TestSupportsTimeBoundedTagValues(t *testing.T) {
+	ctx := context.Background()
+
+	newServer := &stubVersionQuerier{version: "1.8.10"}
+	if !supportsTimeBoundedTagValues(ctx, "ds-new", newServer) {
+		t.Errorf("1.8.10 supports time-bounded tag values")
+	}
+	// the probe result is cached per datasource
+	supportsTimeBoundedTagValues(ctx, "ds-new", newServer)
+	if newServer.probes != 1 {
+		t.Errorf("probes = %d, want 1 (cached)", newServer.probes)
+	}
+
+	oldServer := &stubVersionQuerier{version: "1.1.5"}
+	if supportsTimeBoundedTagValues(ctx, "ds-old", oldServer) {
+		t.Errorf("1.1.5 predates time-bounded tag values")
+	}
+
+	// a failed probe is not cached so the feature can recover
+	flaky := &stubVersionQuerier{err: context.DeadlineExceeded}
+	if supportsTimeBoundedTagValues(ctx, "ds-flaky", flaky) {
+		t.Errorf("failed probe must fall back to unbounded")
+	}
+	flaky.err = nil
+	flaky.version = "1.8.10"
+	if !supportsTimeBoundedTagValues(ctx, "ds-flaky", flaky) {
+		t.Errorf("recovered probe must detect support")
+	}
+	if flaky.probes != 2 {
+		t.Errorf("probes = %d, want 2 (failure not cached)", flaky.probes)
+	}
+}
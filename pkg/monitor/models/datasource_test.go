@@ -0,0 +1,252 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+func TestAppendProbeResult(t *testing.T) {
+	history := []monitor.DataSourceProbeResult{}
+	for i := 0; i < 5; i++ {
+		history = appendProbeResult(history, monitor.DataSourceProbeResult{LatencyMs: int64(i)}, 3)
+	}
+	if len(history) != 3 {
+		t.Fatalf("expect history trimmed to 3, got %d", len(history))
+	}
+	if history[0].LatencyMs != 2 || history[2].LatencyMs != 4 {
+		t.Errorf("expect oldest entries dropped, got %+v", history)
+	}
+}
+
+func TestConsecutiveProbeFailures(t *testing.T) {
+	history := []monitor.DataSourceProbeResult{
+		{Success: false},
+		{Success: true},
+		{Success: false},
+		{Success: false},
+	}
+	if got := consecutiveProbeFailures(history); got != 2 {
+		t.Errorf("consecutiveProbeFailures = %d, want 2", got)
+	}
+	if got := consecutiveProbeFailures(nil); got != 0 {
+		t.Errorf("consecutiveProbeFailures(nil) = %d, want 0", got)
+	}
+	if got := consecutiveProbeFailures([]monitor.DataSourceProbeResult{{Success: true}}); got != 0 {
+		t.Errorf("expect 0 after success, got %d", got)
+	}
+}
+
+// stubCountQuerier serves fake datapoint counts: totals for the
+// caller's range, recents for the activity probe window
+type stubCountQuerier struct {
+	lock    sync.Mutex
+	totals  map[string]int64
+	recents map[string]int64
+	calls   int
+}
+
+func (q *stubCountQuerier) DatapointCount(ctx context.Context, database string, measurement string, from string, to string, scope sMeasurementScope) (int64, error) {
+	q.lock.Lock()
+	q.calls++
+	q.lock.Unlock()
+	if from == measurementActiveWindow && to == "" {
+		return q.recents[measurement], nil
+	}
+	return q.totals[measurement], nil
+}
+
+func TestDatapointCountQL(t *testing.T) {
+	cases := []struct {
+		from  string
+		to    string
+		scope sMeasurementScope
+		want  string
+	}{
+		{"", "", sMeasurementScope{}, `SELECT count(*) FROM "cpu"`},
+		{"24h", "", sMeasurementScope{}, `SELECT count(*) FROM "cpu" WHERE time > now() - 24h`},
+		{"24h", "1h", sMeasurementScope{}, `SELECT count(*) FROM "cpu" WHERE time > now() - 24h AND time <= now() - 1h`},
+		{"24h", "", sMeasurementScope{TenantId: "p1"}, `SELECT count(*) FROM "cpu" WHERE time > now() - 24h AND tenant_id = 'p1'`},
+	}
+	for _, c := range cases {
+		if got := datapointCountQL("cpu", c.from, c.to, c.scope); got != c.want {
+			t.Errorf("datapointCountQL(cpu, %q, %q) = %q, want %q", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestBuildMeasurementEntries(t *testing.T) {
+	querier := &stubCountQuerier{
+		totals:  map[string]int64{"cpu": 100, "mem": 50, "idle": 0},
+		recents: map[string]int64{"cpu": 10, "mem": 0, "idle": 0},
+	}
+	entries, err := buildMeasurementEntries(context.Background(), querier, "telegraf", []string{"cpu", "mem", "idle"}, "24h", "", sMeasurementScope{}, 2)
+	if err != nil {
+		t.Fatalf("buildMeasurementEntries: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expect 3 entries, got %d", len(entries))
+	}
+	if entries[0].Count != 100 || !entries[0].Active {
+		t.Errorf("cpu entry = %+v, want count 100 active", entries[0])
+	}
+	if entries[1].Count != 50 || entries[1].Active {
+		t.Errorf("mem entry = %+v, want count 50 inactive", entries[1])
+	}
+	// two queries per measurement: the count plus the activity probe
+	if querier.calls != 6 {
+		t.Errorf("expect 6 queries, got %d", querier.calls)
+	}
+}
+
+func TestBuildMeasurementEntriesDerivedActive(t *testing.T) {
+	querier := &stubCountQuerier{
+		totals: map[string]int64{"cpu": 7, "idle": 0},
+	}
+	// a from within the active window derives activity from the count
+	// itself, no second query per measurement
+	entries, err := buildMeasurementEntries(context.Background(), querier, "telegraf", []string{"cpu", "idle"}, "5m", "", sMeasurementScope{}, 1)
+	if err != nil {
+		t.Fatalf("buildMeasurementEntries: %v", err)
+	}
+	if !entries[0].Active || entries[1].Active {
+		t.Errorf("derived activity wrong: %+v", entries)
+	}
+	if querier.calls != 2 {
+		t.Errorf("expect 2 queries, got %d", querier.calls)
+	}
+}
+
+func TestValidateMeasurementNameLike(t *testing.T) {
+	for _, ok := range []string{"", "vm_cpu", "agent.cpu-total", "Mem0"} {
+		if err := validateMeasurementNameLike(ok); err != nil {
+			t.Errorf("validateMeasurementNameLike(%q) = %v, want nil", ok, err)
+		}
+	}
+	// a '/' would terminate the regex literal and smuggle extra
+	// statements into the query
+	for _, bad := range []string{"cpu/; DROP DATABASE telegraf; SHOW MEASUREMENTS", "cpu/", "cpu cpu", "cpu'", `cpu\`} {
+		if err := validateMeasurementNameLike(bad); err == nil {
+			t.Errorf("validateMeasurementNameLike(%q) must be rejected", bad)
+		}
+	}
+}
+
+func TestSortMeasurementEntries(t *testing.T) {
+	entries := []monitor.MeasurementEntry{
+		{Name: "mem", Count: 50},
+		{Name: "cpu", Count: 100},
+		{Name: "disk", Count: 100},
+	}
+	if err := sortMeasurementEntries(entries, "count"); err != nil {
+		t.Fatalf("sort by count: %v", err)
+	}
+	if entries[0].Name != "cpu" || entries[1].Name != "disk" || entries[2].Name != "mem" {
+		t.Errorf("count order wrong: %+v", entries)
+	}
+	if err := sortMeasurementEntries(entries, ""); err != nil {
+		t.Fatalf("sort by name: %v", err)
+	}
+	if entries[0].Name != "cpu" || entries[2].Name != "mem" {
+		t.Errorf("name order wrong: %+v", entries)
+	}
+	if err := sortMeasurementEntries(entries, "bogus"); err == nil {
+		t.Errorf("expect error for unsupported order_by")
+	}
+}
+
+func TestPageMeasurementEntries(t *testing.T) {
+	entries := []monitor.MeasurementEntry{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	page, total := pageMeasurementEntries(entries, 2, 1)
+	if total != 3 || len(page) != 2 || page[0].Name != "b" {
+		t.Errorf("page = %+v total = %d, want [b c] 3", page, total)
+	}
+	if page, _ := pageMeasurementEntries(entries, 10, 5); len(page) != 0 {
+		t.Errorf("out of range offset must yield empty page, got %+v", page)
+	}
+}
+
+func TestQueryTuningOptionsReadAtCallTime(t *testing.T) {
+	savedTimeout := options.Options.QueryTimeoutSeconds
+	savedConcurrency := options.Options.MetaQueryConcurrency
+	defer func() {
+		options.Options.QueryTimeoutSeconds = savedTimeout
+		options.Options.MetaQueryConcurrency = savedConcurrency
+	}()
+
+	// a config sync changing the option retunes the next query
+	options.Options.QueryTimeoutSeconds = 7
+	if got := queryTimeout(); got != 7*time.Second {
+		t.Errorf("queryTimeout = %s, want 7s", got)
+	}
+	options.Options.QueryTimeoutSeconds = 42
+	if got := queryTimeout(); got != 42*time.Second {
+		t.Errorf("queryTimeout = %s, want 42s", got)
+	}
+
+	// invalid values fall back to the compiled-in defaults
+	options.Options.QueryTimeoutSeconds = 0
+	if got := queryTimeout(); got != defaultQueryTimeout {
+		t.Errorf("queryTimeout = %s, want default %s", got, defaultQueryTimeout)
+	}
+	options.Options.MetaQueryConcurrency = -1
+	if got := metaQueryConcurrency(); got != defaultMetaQueryConcurrency {
+		t.Errorf("metaQueryConcurrency = %d, want default %d", got, defaultMetaQueryConcurrency)
+	}
+	options.Options.MetaQueryConcurrency = 3
+	if got := metaQueryConcurrency(); got != 3 {
+		t.Errorf("metaQueryConcurrency = %d, want 3", got)
+	}
+}
+
+// blockingCountQuerier reports the ctx deadline it observed, so tests
+// can assert the timeout option reached the query layer
+type deadlineCountQuerier struct {
+	deadline time.Time
+}
+
+func (q *deadlineCountQuerier) DatapointCount(ctx context.Context, database string, measurement string, from string, to string, scope sMeasurementScope) (int64, error) {
+	if dl, ok := ctx.Deadline(); ok {
+		q.deadline = dl
+	}
+	return 1, nil
+}
+
+func TestQueryTimeoutPropagatesToQuerier(t *testing.T) {
+	saved := options.Options.QueryTimeoutSeconds
+	defer func() { options.Options.QueryTimeoutSeconds = saved }()
+	options.Options.QueryTimeoutSeconds = 9
+
+	querier := &deadlineCountQuerier{}
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout())
+	defer cancel()
+	before := time.Now()
+	if _, err := buildMeasurementEntries(ctx, querier, "telegraf", []string{"cpu"}, "5m", "", sMeasurementScope{}, 1); err != nil {
+		t.Fatalf("buildMeasurementEntries: %v", err)
+	}
+	if querier.deadline.IsZero() {
+		t.Fatalf("querier saw no deadline")
+	}
+	remaining := querier.deadline.Sub(before)
+	if remaining <= 8*time.Second || remaining > 9*time.Second {
+		t.Errorf("observed deadline %s away, want about 9s", remaining)
+	}
+}
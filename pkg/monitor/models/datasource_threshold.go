@@ -0,0 +1,240 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+const (
+	// suggestThresholdDefaultWindow is the history the threshold
+	// suggestion looks at when the caller does not say otherwise
+	suggestThresholdDefaultWindow = 7 * 24 * time.Hour
+	// suggestThresholdMaxWindow caps the window so one request cannot
+	// scan months of data
+	suggestThresholdMaxWindow = 30 * 24 * time.Hour
+	// suggestThresholdMinPoints is how many samples the window must
+	// hold before percentiles mean anything
+	suggestThresholdMinPoints = 30
+)
+
+// parseThresholdWindow parses windows like 7d or 72h, clamping to the
+// maximum instead of failing so a generous caller still gets an answer
+func parseThresholdWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return suggestThresholdDefaultWindow, nil
+	}
+	var d time.Duration
+	if strings.HasSuffix(window, "d") {
+		days, err := time.ParseDuration(strings.TrimSuffix(window, "d") + "h")
+		if err != nil {
+			return 0, httperrors.NewInputParameterError("invalid window %q", window)
+		}
+		d = days * 24
+	} else {
+		var err error
+		d, err = time.ParseDuration(window)
+		if err != nil {
+			return 0, httperrors.NewInputParameterError("invalid window %q", window)
+		}
+	}
+	if d <= 0 {
+		return 0, httperrors.NewInputParameterError("invalid window %q", window)
+	}
+	if d > suggestThresholdMaxWindow {
+		d = suggestThresholdMaxWindow
+	}
+	return d, nil
+}
+
+// influxWindow renders a duration as an influxql literal
+func influxWindow(d time.Duration) string {
+	return fmt.Sprintf("%dm", int64(d/time.Minute))
+}
+
+// parseTagFilters parses key=value pairs into a filter map
+func parseTagFilters(tags []string) (map[string]string, error) {
+	filters := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		parts := strings.SplitN(tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, httperrors.NewInputParameterError("invalid tag filter %q, expect key=value", tag)
+		}
+		filters[parts[0]] = parts[1]
+	}
+	return filters, nil
+}
+
+// suggestThresholdQL renders the single aggregate statement computing
+// the sample count, percentiles and maximum of a field over the
+// window. The statement always carries the time bound and a LIMIT so
+// it cannot degenerate into a full scan.
+func suggestThresholdQL(measurement string, field string, window string, tags map[string]string, scope sMeasurementScope) string {
+	conds := []string{fmt.Sprintf("time > now() - %s", window)}
+	if !scope.IsSystem() {
+		conds = append(conds, fmt.Sprintf("tenant_id = '%s'", escapeTagValue(scope.TenantId)))
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		conds = append(conds, fmt.Sprintf("%q = '%s'", key, escapeTagValue(tags[key])))
+	}
+	return fmt.Sprintf(
+		"SELECT COUNT(%q), PERCENTILE(%q, 50), PERCENTILE(%q, 90), PERCENTILE(%q, 99), MAX(%q) FROM %q WHERE %s LIMIT 1",
+		field, field, field, field, field, measurement, strings.Join(conds, " AND "))
+}
+
+// fieldPercentileQuerier answers the percentile aggregate the
+// threshold suggestion needs, replaceable in tests
+type fieldPercentileQuerier interface {
+	FieldPercentiles(ctx context.Context, database string, measurement string, field string, window string, tags map[string]string, scope sMeasurementScope) (*monitor.FieldPercentiles, error)
+}
+
+func (q sInfluxStatsQuerier) FieldPercentiles(ctx context.Context, database string, measurement string, field string, window string, tags map[string]string, scope sMeasurementScope) (*monitor.FieldPercentiles, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "", suggestThresholdQL(measurement, field, window, tags, scope))
+	if err != nil {
+		return nil, err
+	}
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			for _, row := range series.Values {
+				// columns are time, count, p50, p90, p99, max
+				if len(row) < 6 || row[1] == nil {
+					continue
+				}
+				p := &monitor.FieldPercentiles{}
+				p.Count, _ = row[1].Int()
+				if p.Count == 0 {
+					continue
+				}
+				if row[2] != nil {
+					p.P50, _ = row[2].Float()
+				}
+				if row[3] != nil {
+					p.P90, _ = row[3].Float()
+				}
+				if row[4] != nil {
+					p.P99, _ = row[4].Float()
+				}
+				if row[5] != nil {
+					p.Max, _ = row[5].Float()
+				}
+				return p, nil
+			}
+		}
+	}
+	return &monitor.FieldPercentiles{}, nil
+}
+
+// suggestThresholds turns a sample distribution into threshold advice:
+// warning at the p90, critical at the p99. Too few samples yield an
+// insufficient-data answer instead of percentiles of noise.
+func suggestThresholds(p *monitor.FieldPercentiles, minPoints int64) monitor.DataSourceSuggestThresholdOutput {
+	output := monitor.DataSourceSuggestThresholdOutput{}
+	if p == nil || p.Count < minPoints {
+		count := int64(0)
+		if p != nil {
+			count = p.Count
+		}
+		output.InsufficientData = true
+		output.Rationale = fmt.Sprintf("only %d samples in the window, at least %d needed for a meaningful suggestion", count, minPoints)
+		return output
+	}
+	output.Percentiles = p
+	output.Warning = p.P90
+	output.Critical = p.P99
+	output.Rationale = fmt.Sprintf(
+		"based on %d samples: warning at the 90th percentile (%.2f), critical at the 99th percentile (%.2f); median %.2f, observed maximum %.2f",
+		p.Count, p.P90, p.P99, p.P50, p.Max)
+	return output
+}
+
+// computeSuggestedThresholds runs the percentile aggregate through the
+// querier and turns the distribution into threshold advice
+func computeSuggestedThresholds(ctx context.Context, querier fieldPercentileQuerier, database string, measurement string, field string, window string, tags map[string]string, scope sMeasurementScope) (monitor.DataSourceSuggestThresholdOutput, error) {
+	percentiles, err := querier.FieldPercentiles(ctx, database, measurement, field, window, tags, scope)
+	if err != nil {
+		return monitor.DataSourceSuggestThresholdOutput{}, errors.Wrap(err, "query field percentiles")
+	}
+	output := suggestThresholds(percentiles, suggestThresholdMinPoints)
+	output.Measurement = measurement
+	output.Field = field
+	output.Window = window
+	return output, nil
+}
+
+func (man *SDataSourceManager) AllowGetPropertySuggestThreshold(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return true
+}
+
+// GetPropertySuggestThreshold recommends warning/critical alert
+// thresholds for a measurement field from its historical distribution,
+// computed with one percentile aggregate over the requested window.
+// Non-admin callers only see their own tenant's series.
+func (man *SDataSourceManager) GetPropertySuggestThreshold(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.DataSourceSuggestThresholdInput{}
+	if err := query.Unmarshal(&input); err != nil {
+		return nil, httperrors.NewInputParameterError("unmarshal input: %v", err)
+	}
+	if input.Measurement == "" {
+		return nil, httperrors.NewMissingParameterError("measurement")
+	}
+	if input.Field == "" {
+		return nil, httperrors.NewMissingParameterError("field")
+	}
+	scope, err := fetchMeasurementScope(userCred, query)
+	if err != nil {
+		return nil, err
+	}
+	window, err := parseThresholdWindow(input.Window)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := parseTagFilters(input.Tags)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := man.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	database := input.Database
+	if database == "" {
+		database = "telegraf"
+	}
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout())
+	defer cancel()
+	output, err := computeSuggestedThresholds(ctx, sInfluxStatsQuerier{ds: ds}, database, input.Measurement, input.Field, influxWindow(window), tags, scope)
+	if err != nil {
+		return nil, err
+	}
+	return jsonutils.Marshal(output), nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+// stubPercentileQuerier serves a synthetic distribution in place of
+// influxdb
+type stubPercentileQuerier struct {
+	percentiles *monitor.FieldPercentiles
+}
+
+func (q stubPercentileQuerier) FieldPercentiles(ctx context.Context, database string, measurement string, field string, window string, tags map[string]string, scope sMeasurementScope) (*monitor.FieldPercentiles, error) {
+	return q.percentiles, nil
+}
+
+func TestComputeSuggestedThresholds(t *testing.T) {
+	querier := stubPercentileQuerier{
+		percentiles: &monitor.FieldPercentiles{
+			Count: 10000,
+			P50:   35.2,
+			P90:   71.8,
+			P99:   93.4,
+			Max:   99.1,
+		},
+	}
+	output, err := computeSuggestedThresholds(context.Background(), querier, "telegraf", "vm_cpu", "usage_active", "10080m", nil, sMeasurementScope{})
+	if err != nil {
+		t.Fatalf("compute: %v", err)
+	}
+	if output.InsufficientData {
+		t.Fatalf("10000 samples must be sufficient")
+	}
+	if output.Warning != 71.8 || output.Critical != 93.4 {
+		t.Errorf("warning/critical = %v/%v, want p90/p99", output.Warning, output.Critical)
+	}
+	if output.Percentiles == nil || output.Percentiles.Max != 99.1 {
+		t.Errorf("percentiles must carry the distribution, got %+v", output.Percentiles)
+	}
+	if !strings.Contains(output.Rationale, "90th percentile") || !strings.Contains(output.Rationale, "10000 samples") {
+		t.Errorf("rationale = %q", output.Rationale)
+	}
+
+	// a sparse window yields insufficient data, not percentiles of noise
+	sparse := stubPercentileQuerier{percentiles: &monitor.FieldPercentiles{Count: 5, P90: 3.0, P99: 4.0}}
+	output, err = computeSuggestedThresholds(context.Background(), sparse, "telegraf", "vm_cpu", "usage_active", "10080m", nil, sMeasurementScope{})
+	if err != nil {
+		t.Fatalf("compute sparse: %v", err)
+	}
+	if !output.InsufficientData {
+		t.Fatalf("5 samples must report insufficient data")
+	}
+	if output.Warning != 0 || output.Critical != 0 || output.Percentiles != nil {
+		t.Errorf("insufficient data must not suggest thresholds, got %+v", output)
+	}
+	if !strings.Contains(output.Rationale, "only 5 samples") {
+		t.Errorf("rationale = %q", output.Rationale)
+	}
+}
+
+func TestSuggestThresholdQL(t *testing.T) {
+	ql := suggestThresholdQL("vm_cpu", "usage_active", "10080m", map[string]string{"host_id": "h-1"}, sMeasurementScope{TenantId: "proj-1"})
+	for _, want := range []string{
+		`PERCENTILE("usage_active", 50)`,
+		`PERCENTILE("usage_active", 90)`,
+		`PERCENTILE("usage_active", 99)`,
+		`MAX("usage_active")`,
+		`time > now() - 10080m`,
+		`tenant_id = 'proj-1'`,
+		`"host_id" = 'h-1'`,
+		`LIMIT 1`,
+	} {
+		if !strings.Contains(ql, want) {
+			t.Errorf("ql %q misses %q", ql, want)
+		}
+	}
+
+	// system scope drops the tenant restriction
+	ql = suggestThresholdQL("vm_cpu", "usage_active", "10080m", nil, sMeasurementScope{})
+	if strings.Contains(ql, "tenant_id") {
+		t.Errorf("system scope must not filter by tenant, got %q", ql)
+	}
+}
+
+func TestParseThresholdWindow(t *testing.T) {
+	cases := []struct {
+		window  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", suggestThresholdDefaultWindow, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"72h", 72 * time.Hour, false},
+		{"90d", suggestThresholdMaxWindow, false},
+		{"-1h", 0, true},
+		{"fortnight", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseThresholdWindow(c.window)
+		if (err != nil) != c.wantErr {
+			t.Errorf("window %q: err = %v, wantErr %v", c.window, err, c.wantErr)
+			continue
+		}
+		if err == nil && got != c.want {
+			t.Errorf("window %q = %v, want %v", c.window, got, c.want)
+		}
+	}
+}
+
+func TestParseTagFilters(t *testing.T) {
+	filters, err := parseTagFilters([]string{"host_id=h-1", "vm_id=v-2"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if filters["host_id"] != "h-1" || filters["vm_id"] != "v-2" {
+		t.Errorf("filters = %v", filters)
+	}
+	if _, err := parseTagFilters([]string{"no-equals"}); err == nil {
+		t.Errorf("expect error for filter without =")
+	}
+	if _, err := parseTagFilters([]string{"=value"}); err == nil {
+		t.Errorf("expect error for empty key")
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/util/wait"
+	"yunion.io/x/pkg/utils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/registry"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+	"yunion.io/x/onecloud/pkg/util/stringutils2"
+)
+
+var DownsamplingRuleManager *SDownsamplingRuleManager
+
+func init() {
+	DownsamplingRuleManager = &SDownsamplingRuleManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SDownsamplingRule{},
+			"downsamplingrules_tbl",
+			"downsamplingrule",
+			"downsamplingrules",
+		),
+	}
+	DownsamplingRuleManager.SetVirtualObject(DownsamplingRuleManager)
+	registry.RegisterService(DownsamplingRuleManager)
+}
+
+type SDownsamplingRuleManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+// SDownsamplingRule declares one influxdb continuous query that
+// downsamples a measurement (or a regex of measurements) into a
+// coarser retention policy. The rule is the source of truth; the
+// reconcile loop converges the continuous queries actually present in
+// influxdb to the declared set.
+type SDownsamplingRule struct {
+	db.SStandaloneResourceBase
+
+	// 数据库名称
+	Database string `width:"64" charset:"utf8" nullable:"false" list:"user" create:"optional"`
+	// 源measurement名称, 以/包围时按正则匹配
+	Measurement string `width:"128" charset:"utf8" nullable:"false" list:"user" create:"required"`
+	// 聚合函数, 逗号分隔
+	Aggregates string `width:"256" charset:"ascii" nullable:"false" list:"user" create:"required"`
+	// 降采样聚合周期
+	Interval string `width:"16" charset:"ascii" nullable:"false" list:"user" create:"required"`
+	// 目标retention policy名称
+	RetentionPolicy string `width:"64" charset:"utf8" nullable:"false" list:"user" create:"required" json:"retention_policy"`
+
+	// 与influxdb实际continuous query的同步状态
+	SyncStatus string `width:"16" charset:"ascii" nullable:"true" list:"user" json:"sync_status"`
+	// 最近一次同步检查时间
+	LastSyncAt time.Time `nullable:"true" list:"user" json:"last_sync_at"`
+}
+
+func (_ *SDownsamplingRuleManager) IsDisabled() bool {
+	return false
+}
+
+func (man *SDownsamplingRuleManager) Init() error {
+	return nil
+}
+
+// reconcileInterval is how often the declared rules are compared with
+// the continuous queries actually present in influxdb
+const reconcileInterval = 30 * time.Minute
+
+func (man *SDownsamplingRuleManager) Run(ctx context.Context) error {
+	wait.Forever(func() {
+		man.reconcile(ctx)
+	}, reconcileInterval)
+	return nil
+}
+
+// cqAggregates is the set of aggregation functions a rule may use
+var cqAggregates = []string{"mean", "max", "min", "sum", "count", "first", "last", "median"}
+
+// cqIntervalPattern matches influxql duration literals
+var cqIntervalPattern = regexp.MustCompile(`^[1-9][0-9]*(ms|s|m|h|d|w)$`)
+
+func (man *SDownsamplingRuleManager) AllowCreateItem(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowCreate(userCred, man)
+}
+
+func (man *SDownsamplingRuleManager) ValidateCreateData(
+	ctx context.Context, userCred mcclient.TokenCredential,
+	ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject,
+	data monitor.DownsamplingRuleCreateInput) (monitor.DownsamplingRuleCreateInput, error) {
+	if data.Database == "" {
+		data.Database = "telegraf"
+	}
+	if data.Measurement == "" {
+		return data, httperrors.NewMissingParameterError("measurement")
+	}
+	if isCQRegexMeasurement(data.Measurement) {
+		if _, err := regexp.Compile(strings.Trim(data.Measurement, "/")); err != nil {
+			return data, httperrors.NewInputParameterError("invalid measurement regex %s: %v", data.Measurement, err)
+		}
+	}
+	aggregates := splitCQAggregates(data.Aggregates)
+	if len(aggregates) == 0 {
+		return data, httperrors.NewMissingParameterError("aggregates")
+	}
+	for _, agg := range aggregates {
+		if !utils.IsInStringArray(agg, cqAggregates) {
+			return data, httperrors.NewInputParameterError("unsupported aggregate %s, expect one of %s", agg, strings.Join(cqAggregates, ","))
+		}
+	}
+	data.Aggregates = strings.Join(aggregates, ",")
+	if !cqIntervalPattern.MatchString(data.Interval) {
+		return data, httperrors.NewInputParameterError("invalid interval %s", data.Interval)
+	}
+	if data.RetentionPolicy == "" {
+		return data, httperrors.NewMissingParameterError("retention_policy")
+	}
+	return data, nil
+}
+
+func (man *SDownsamplingRuleManager) FetchCustomizeColumns(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	objs []interface{},
+	fields stringutils2.SSortedStrings,
+	isList bool,
+) []monitor.DownsamplingRuleDetails {
+	rows := make([]monitor.DownsamplingRuleDetails, len(objs))
+	stdRows := man.SStandaloneResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	for i := range rows {
+		rule := objs[i].(*SDownsamplingRule)
+		rows[i] = monitor.DownsamplingRuleDetails{
+			StandaloneResourceDetails: stdRows[i],
+			Database:                  rule.Database,
+			Measurement:               rule.Measurement,
+			Aggregates:                rule.Aggregates,
+			Interval:                  rule.Interval,
+			RetentionPolicy:           rule.RetentionPolicy,
+			SyncStatus:                rule.SyncStatus,
+			LastSyncAt:                rule.LastSyncAt,
+		}
+	}
+	return rows
+}
+
+func (rule *SDownsamplingRule) GetExtraDetails(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	isList bool,
+) (monitor.DownsamplingRuleDetails, error) {
+	return monitor.DownsamplingRuleDetails{}, nil
+}
+
+// cqName is the name of the continuous query backing the rule; the
+// prefix marks the query as managed so reconciliation never touches
+// hand-made ones
+func (rule *SDownsamplingRule) cqName() string {
+	return fmt.Sprintf("onecloud_ds_%s", rule.Name)
+}
+
+// isCQRegexMeasurement reports whether the measurement is written in
+// regex form (/.../) instead of a plain name
+func isCQRegexMeasurement(measurement string) bool {
+	return len(measurement) > 2 && strings.HasPrefix(measurement, "/") && strings.HasSuffix(measurement, "/")
+}
+
+// splitCQAggregates splits the comma separated aggregate list,
+// dropping empty entries and normalizing case
+func splitCQAggregates(aggregates string) []string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(aggregates, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// renderCQSelect renders the select clause of the continuous query,
+// one wildcard aggregation per function
+func renderCQSelect(aggregates []string) string {
+	clauses := make([]string, 0, len(aggregates))
+	for _, agg := range aggregates {
+		clauses = append(clauses, fmt.Sprintf("%s(*)", agg))
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// renderCQFrom renders the from clause, keeping regex measurements
+// verbatim and quoting plain names
+func renderCQFrom(measurement string) string {
+	if isCQRegexMeasurement(measurement) {
+		return measurement
+	}
+	return fmt.Sprintf("%q", measurement)
+}
+
+// renderCreateContinuousQuery renders the CREATE CONTINUOUS QUERY
+// statement for one rule. Downsampled points keep the source
+// measurement name (:MEASUREMENT) inside the target retention policy,
+// grouped by every tag so no dimension is lost.
+func renderCreateContinuousQuery(name string, database string, measurement string, aggregates []string, interval string, retentionPolicy string) string {
+	return fmt.Sprintf("CREATE CONTINUOUS QUERY %q ON %q BEGIN SELECT %s INTO %q.%q.:MEASUREMENT FROM %s GROUP BY time(%s), * END",
+		name, database, renderCQSelect(aggregates), database, retentionPolicy, renderCQFrom(measurement), interval)
+}
+
+// renderDropContinuousQuery renders the DROP CONTINUOUS QUERY
+// statement for one rule
+func renderDropContinuousQuery(name string, database string) string {
+	return fmt.Sprintf("DROP CONTINUOUS QUERY %q ON %q", name, database)
+}
+
+func (rule *SDownsamplingRule) renderCreate() string {
+	return renderCreateContinuousQuery(rule.cqName(), rule.Database, rule.Measurement, splitCQAggregates(rule.Aggregates), rule.Interval, rule.RetentionPolicy)
+}
+
+func (rule *SDownsamplingRule) renderDrop() string {
+	return renderDropContinuousQuery(rule.cqName(), rule.Database)
+}
+
+// normalizeCQ flattens whitespace and case so a statement read back
+// from SHOW CONTINUOUS QUERIES compares equal to our rendering despite
+// influxdb reformatting it
+func normalizeCQ(ql string) string {
+	return strings.ToLower(strings.Join(strings.Fields(ql), " "))
+}
+
+// continuousQueryExecutor is the influxdb surface the reconciliation
+// needs, replaceable in tests
+type continuousQueryExecutor interface {
+	ShowContinuousQueries(ctx context.Context, database string) (map[string]string, error)
+	Exec(ctx context.Context, ql string) error
+}
+
+// sInfluxCQExecutor implements continuousQueryExecutor against the
+// influxdb backing a datasource
+type sInfluxCQExecutor struct {
+	ds *SDataSource
+}
+
+func (e sInfluxCQExecutor) Exec(ctx context.Context, ql string) error {
+	dbinst := influxdb.NewInfluxdb(e.ds.getActiveUrl())
+	_, err := dbinst.QueryContext(ctx, ql)
+	return err
+}
+
+func (e sInfluxCQExecutor) ShowContinuousQueries(ctx context.Context, database string) (map[string]string, error) {
+	dbinst := influxdb.NewInfluxdb(e.ds.getActiveUrl())
+	results, err := dbinst.QueryContext(ctx, "SHOW CONTINUOUS QUERIES")
+	if err != nil {
+		return nil, err
+	}
+	queries := make(map[string]string)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			// one series per database, named after it
+			if series.Name != database {
+				continue
+			}
+			for _, value := range series.Values {
+				if len(value) < 2 || value[0] == nil || value[1] == nil {
+					continue
+				}
+				name, err := value[0].GetString()
+				if err != nil {
+					continue
+				}
+				query, err := value[1].GetString()
+				if err != nil {
+					continue
+				}
+				queries[name] = query
+			}
+		}
+	}
+	return queries, nil
+}
+
+// reconcileContinuousQueries converges the continuous queries in
+// influxdb to the declared rules, returning the status each rule was
+// found in before convergence, keyed by rule id
+func reconcileContinuousQueries(ctx context.Context, exec continuousQueryExecutor, rules []SDownsamplingRule) map[string]string {
+	statuses := make(map[string]string)
+	byDatabase := make(map[string][]*SDownsamplingRule)
+	for i := range rules {
+		byDatabase[rules[i].Database] = append(byDatabase[rules[i].Database], &rules[i])
+	}
+	for database, dbRules := range byDatabase {
+		existing, err := exec.ShowContinuousQueries(ctx, database)
+		if err != nil {
+			log.Errorf("show continuous queries on %s: %v", database, err)
+			continue
+		}
+		for _, rule := range dbRules {
+			desired := rule.renderCreate()
+			current, ok := existing[rule.cqName()]
+			if !ok {
+				statuses[rule.Id] = monitor.DOWNSAMPLING_STATUS_MISSING
+				if err := exec.Exec(ctx, desired); err != nil {
+					log.Errorf("create continuous query %s: %v", rule.cqName(), err)
+				}
+				continue
+			}
+			if normalizeCQ(current) != normalizeCQ(desired) {
+				statuses[rule.Id] = monitor.DOWNSAMPLING_STATUS_DIVERGENT
+				// continuous queries cannot be altered in place
+				if err := exec.Exec(ctx, rule.renderDrop()); err != nil {
+					log.Errorf("drop divergent continuous query %s: %v", rule.cqName(), err)
+					continue
+				}
+				if err := exec.Exec(ctx, desired); err != nil {
+					log.Errorf("recreate continuous query %s: %v", rule.cqName(), err)
+				}
+				continue
+			}
+			statuses[rule.Id] = monitor.DOWNSAMPLING_STATUS_IN_SYNC
+		}
+	}
+	return statuses
+}
+
+// reconcile runs one convergence pass and records the observed status
+// on every rule
+func (man *SDownsamplingRuleManager) reconcile(ctx context.Context) {
+	ds, err := man.getExecutorSource()
+	if err != nil {
+		log.Errorf("downsampling reconcile: %v", err)
+		return
+	}
+	rules := make([]SDownsamplingRule, 0)
+	if err := db.FetchModelObjects(man, man.Query(), &rules); err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("fetch downsampling rules: %v", err)
+		}
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+	statuses := reconcileContinuousQueries(ctx, sInfluxCQExecutor{ds: ds}, rules)
+	now := time.Now().UTC()
+	for i := range rules {
+		rule := &rules[i]
+		status, ok := statuses[rule.Id]
+		if !ok {
+			continue
+		}
+		if _, err := db.Update(rule, func() error {
+			rule.SyncStatus = status
+			rule.LastSyncAt = now
+			return nil
+		}); err != nil {
+			log.Errorf("update sync status of rule %s: %v", rule.GetName(), err)
+		}
+	}
+}
+
+func (man *SDownsamplingRuleManager) getExecutorSource() (*SDataSource, error) {
+	ds, err := DataSourceManager.GetDefaultSource()
+	if err != nil {
+		return nil, errors.Wrap(err, "get default datasource")
+	}
+	return ds, nil
+}
+
+func (rule *SDownsamplingRule) PostCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) {
+	rule.SStandaloneResourceBase.PostCreate(ctx, userCred, ownerId, query, data)
+	status := monitor.DOWNSAMPLING_STATUS_IN_SYNC
+	if err := rule.applyCreate(ctx); err != nil {
+		log.Errorf("create continuous query for rule %s: %v", rule.GetName(), err)
+		status = monitor.DOWNSAMPLING_STATUS_MISSING
+	}
+	if _, err := db.Update(rule, func() error {
+		rule.SyncStatus = status
+		rule.LastSyncAt = time.Now().UTC()
+		return nil
+	}); err != nil {
+		log.Errorf("update sync status of rule %s: %v", rule.GetName(), err)
+	}
+}
+
+func (rule *SDownsamplingRule) applyCreate(ctx context.Context) error {
+	ds, err := DownsamplingRuleManager.getExecutorSource()
+	if err != nil {
+		return err
+	}
+	return sInfluxCQExecutor{ds: ds}.Exec(ctx, rule.renderCreate())
+}
+
+func (rule *SDownsamplingRule) PostDelete(ctx context.Context, userCred mcclient.TokenCredential) {
+	rule.SStandaloneResourceBase.PostDelete(ctx, userCred)
+	ds, err := DownsamplingRuleManager.getExecutorSource()
+	if err != nil {
+		log.Errorf("drop continuous query for rule %s: %v", rule.GetName(), err)
+		return
+	}
+	if err := (sInfluxCQExecutor{ds: ds}).Exec(ctx, rule.renderDrop()); err != nil {
+		log.Errorf("drop continuous query for rule %s: %v", rule.GetName(), err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func TestRenderCreateContinuousQuery(t *testing.T) {
+	got := renderCreateContinuousQuery("onecloud_ds_cpu5m", "telegraf", "cpu", []string{"mean", "max"}, "5m", "rp_30d")
+	want := `CREATE CONTINUOUS QUERY "onecloud_ds_cpu5m" ON "telegraf" BEGIN SELECT mean(*), max(*) INTO "telegraf"."rp_30d".:MEASUREMENT FROM "cpu" GROUP BY time(5m), * END`
+	if got != want {
+		t.Errorf("renderCreateContinuousQuery =\n%s\nwant\n%s", got, want)
+	}
+
+	// regex measurements are kept verbatim
+	got = renderCreateContinuousQuery("onecloud_ds_agent", "telegraf", "/^agent_/", []string{"mean"}, "1h", "rp_1y")
+	want = `CREATE CONTINUOUS QUERY "onecloud_ds_agent" ON "telegraf" BEGIN SELECT mean(*) INTO "telegraf"."rp_1y".:MEASUREMENT FROM /^agent_/ GROUP BY time(1h), * END`
+	if got != want {
+		t.Errorf("renderCreateContinuousQuery regex =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderDropContinuousQuery(t *testing.T) {
+	got := renderDropContinuousQuery("onecloud_ds_cpu5m", "telegraf")
+	want := `DROP CONTINUOUS QUERY "onecloud_ds_cpu5m" ON "telegraf"`
+	if got != want {
+		t.Errorf("renderDropContinuousQuery = %s, want %s", got, want)
+	}
+}
+
+func TestSplitCQAggregates(t *testing.T) {
+	got := splitCQAggregates(" Mean, max ,,sum ")
+	if len(got) != 3 || got[0] != "mean" || got[1] != "max" || got[2] != "sum" {
+		t.Errorf("splitCQAggregates = %v, want [mean max sum]", got)
+	}
+	if got := splitCQAggregates(""); len(got) != 0 {
+		t.Errorf("splitCQAggregates(\"\") = %v, want empty", got)
+	}
+}
+
+// stubCQExecutor is a continuousQueryExecutor recording every executed
+// statement against a fixed set of pre-existing continuous queries
+type stubCQExecutor struct {
+	existing map[string]string
+	executed []string
+}
+
+func (s *stubCQExecutor) ShowContinuousQueries(ctx context.Context, database string) (map[string]string, error) {
+	return s.existing, nil
+}
+
+func (s *stubCQExecutor) Exec(ctx context.Context, ql string) error {
+	s.executed = append(s.executed, ql)
+	return nil
+}
+
+func newTestCQRule(id string, name string, measurement string) SDownsamplingRule {
+	rule := SDownsamplingRule{
+		Database:        "telegraf",
+		Measurement:     measurement,
+		Aggregates:      "mean",
+		Interval:        "5m",
+		RetentionPolicy: "rp_30d",
+	}
+	rule.Id = id
+	rule.Name = name
+	return rule
+}
+
+func TestReconcileContinuousQueries(t *testing.T) {
+	inSync := newTestCQRule("id1", "cpu5m", "cpu")
+	divergent := newTestCQRule("id2", "mem5m", "mem")
+	missing := newTestCQRule("id3", "disk5m", "disk")
+
+	exec := &stubCQExecutor{
+		existing: map[string]string{
+			// influxdb reformats statements, only normalized
+			// equality counts
+			inSync.cqName():    "create continuous query \"onecloud_ds_cpu5m\" on \"telegraf\" begin select mean(*) into \"telegraf\".\"rp_30d\".:measurement from \"cpu\" group by time(5m), * end",
+			divergent.cqName(): `CREATE CONTINUOUS QUERY "onecloud_ds_mem5m" ON "telegraf" BEGIN SELECT mean(*) INTO "telegraf"."rp_7d".:MEASUREMENT FROM "mem" GROUP BY time(1h), * END`,
+		},
+	}
+
+	statuses := reconcileContinuousQueries(context.Background(), exec, []SDownsamplingRule{inSync, divergent, missing})
+
+	if statuses["id1"] != monitor.DOWNSAMPLING_STATUS_IN_SYNC {
+		t.Errorf("in-sync rule status = %s", statuses["id1"])
+	}
+	if statuses["id2"] != monitor.DOWNSAMPLING_STATUS_DIVERGENT {
+		t.Errorf("divergent rule status = %s", statuses["id2"])
+	}
+	if statuses["id3"] != monitor.DOWNSAMPLING_STATUS_MISSING {
+		t.Errorf("missing rule status = %s", statuses["id3"])
+	}
+
+	// the divergent rule is dropped and recreated, the missing one
+	// created, the in-sync one untouched
+	wantExecuted := []string{
+		divergent.renderDrop(),
+		divergent.renderCreate(),
+		missing.renderCreate(),
+	}
+	if len(exec.executed) != len(wantExecuted) {
+		t.Fatalf("executed %d statements %v, want %d", len(exec.executed), exec.executed, len(wantExecuted))
+	}
+	for i := range wantExecuted {
+		if exec.executed[i] != wantExecuted[i] {
+			t.Errorf("executed[%d] = %s, want %s", i, exec.executed[i], wantExecuted[i])
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/util/influxdb"
+)
+
+// metricStreamIdentRegexp accepts the identifiers a tail spec may
+// reference: measurement, field and tag names as telegraf emits them
+var metricStreamIdentRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`)
+
+// validateMetricStreamSpec checks a tail spec and fills in defaults.
+// Every identifier is validated so the spec cannot smuggle arbitrary
+// influxql into the rendered query.
+func validateMetricStreamSpec(spec *monitor.MetricStreamSpec) error {
+	if spec.Measurement == "" {
+		return httperrors.NewMissingParameterError("measurement")
+	}
+	idents := append([]string{spec.Measurement}, spec.Fields...)
+	idents = append(idents, spec.GroupBy...)
+	for key := range spec.Tags {
+		idents = append(idents, key)
+	}
+	for _, ident := range idents {
+		if !metricStreamIdentRegexp.MatchString(ident) {
+			return httperrors.NewInputParameterError("invalid identifier %q", ident)
+		}
+	}
+	if spec.Database == "" {
+		spec.Database = "telegraf"
+	}
+	return nil
+}
+
+// buildTailQL renders the incremental query fetching datapoints newer
+// than the cursor, restricted by tenant_id unless the scope is
+// system-wide
+func buildTailQL(spec *monitor.MetricStreamSpec, cursor time.Time, scope sMeasurementScope) string {
+	fields := "*"
+	if len(spec.Fields) > 0 {
+		quoted := make([]string, len(spec.Fields))
+		for i := range spec.Fields {
+			quoted[i] = fmt.Sprintf("%q", spec.Fields[i])
+		}
+		fields = strings.Join(quoted, ", ")
+	}
+	conds := []string{fmt.Sprintf("time > %d", cursor.UnixNano())}
+	tagKeys := make([]string, 0, len(spec.Tags))
+	for key := range spec.Tags {
+		tagKeys = append(tagKeys, key)
+	}
+	sort.Strings(tagKeys)
+	for _, key := range tagKeys {
+		conds = append(conds, fmt.Sprintf("%q = '%s'", key, escapeTagValue(spec.Tags[key])))
+	}
+	if !scope.IsSystem() {
+		conds = append(conds, fmt.Sprintf("tenant_id = '%s'", escapeTagValue(scope.TenantId)))
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %q WHERE %s", fields, spec.Measurement, strings.Join(conds, " AND "))
+	if len(spec.GroupBy) > 0 {
+		quoted := make([]string, len(spec.GroupBy))
+		for i := range spec.GroupBy {
+			quoted[i] = fmt.Sprintf("%q", spec.GroupBy[i])
+		}
+		sql = fmt.Sprintf("%s GROUP BY %s", sql, strings.Join(quoted, ", "))
+	}
+	return sql
+}
+
+// metricTailQuerier fetches the datapoints an incremental tail query
+// matches, replaceable in tests
+type metricTailQuerier interface {
+	TailDatapoints(ctx context.Context, database string, ql string) ([]monitor.MetricStreamEvent, error)
+}
+
+// sInfluxTailQuerier implements metricTailQuerier against the influxdb
+// backing a datasource, requesting millisecond timestamps so the
+// cursor arithmetic stays integral
+type sInfluxTailQuerier struct {
+	ds *SDataSource
+}
+
+func (q sInfluxTailQuerier) TailDatapoints(ctx context.Context, database string, ql string) ([]monitor.MetricStreamEvent, error) {
+	dbinst := influxdb.NewInfluxdb(q.ds.getActiveUrl())
+	results, err := dbinst.QueryContextOn(ctx, database, "ms", ql)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]monitor.MetricStreamEvent, 0)
+	for _, resSeries := range results {
+		for _, series := range resSeries {
+			if len(series.Values) == 0 {
+				continue
+			}
+			event := monitor.MetricStreamEvent{
+				Measurement: series.Name,
+				Columns:     series.Columns,
+				Points:      series.Values,
+			}
+			if series.Tags != nil {
+				tags := make(map[string]string)
+				if err := series.Tags.Unmarshal(&tags); err == nil {
+					event.Tags = tags
+				}
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// advanceMetricStreamCursor moves the cursor to the newest timestamp
+// seen in the pushed events so the next round only fetches later
+// datapoints. The first column of every point is the millisecond
+// timestamp.
+func advanceMetricStreamCursor(events []monitor.MetricStreamEvent, cursor time.Time) time.Time {
+	for i := range events {
+		for _, point := range events[i].Points {
+			if len(point) == 0 || point[0] == nil {
+				continue
+			}
+			ms, err := point[0].Int()
+			if err != nil {
+				continue
+			}
+			ts := time.Unix(0, ms*int64(time.Millisecond))
+			if ts.After(cursor) {
+				cursor = ts
+			}
+		}
+	}
+	return cursor
+}
+
+// sStreamLimiter counts the concurrent tail streams each user holds
+// open
+type sStreamLimiter struct {
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newStreamLimiter() *sStreamLimiter {
+	return &sStreamLimiter{counts: make(map[string]int)}
+}
+
+func (l *sStreamLimiter) Acquire(userId string, max int) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if max > 0 && l.counts[userId] >= max {
+		return false
+	}
+	l.counts[userId]++
+	return true
+}
+
+func (l *sStreamLimiter) Release(userId string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.counts[userId] > 0 {
+		l.counts[userId]--
+	}
+}
+
+var metricStreamLimiter = newStreamLimiter()
+
+// metricStreamSink receives the pushed events, the SSE implementation
+// flushes every write so datapoints reach the client immediately
+type metricStreamSink interface {
+	SendEvent(event monitor.MetricStreamEvent) error
+	SendHeartbeat() error
+}
+
+// sMetricStreamParams bundles the loop timing so tests can shrink the
+// intervals
+type sMetricStreamParams struct {
+	Interval    time.Duration
+	Heartbeat   time.Duration
+	IdleTimeout time.Duration
+	// Start is the initial cursor, zero means tail from now
+	Start time.Time
+	// MaxRounds bounds the loop, 0 means run until the context is
+	// cancelled or the stream goes idle
+	MaxRounds int
+}
+
+// runMetricStream drives the incremental tail loop: every interval it
+// queries the datapoints newer than the moving cursor and pushes them
+// into the sink. Quiet streams receive periodic heartbeats and are
+// closed once no datapoint arrived within the idle timeout. A sink
+// write failure means the client went away and ends the stream
+// silently.
+func runMetricStream(ctx context.Context, querier metricTailQuerier, sink metricStreamSink, spec *monitor.MetricStreamSpec, scope sMeasurementScope, params sMetricStreamParams) error {
+	ticker := time.NewTicker(params.Interval)
+	defer ticker.Stop()
+	cursor := params.Start
+	if cursor.IsZero() {
+		cursor = time.Now()
+	}
+	lastData := time.Now()
+	lastWrite := time.Now()
+	rounds := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		events, err := querier.TailDatapoints(ctx, spec.Database, buildTailQL(spec, cursor, scope))
+		if err != nil {
+			return errors.Wrap(err, "tail datapoints")
+		}
+		cursor = advanceMetricStreamCursor(events, cursor)
+		if len(events) > 0 {
+			for i := range events {
+				if err := sink.SendEvent(events[i]); err != nil {
+					return nil
+				}
+			}
+			lastData = time.Now()
+			lastWrite = time.Now()
+		} else {
+			if params.IdleTimeout > 0 && time.Since(lastData) >= params.IdleTimeout {
+				return nil
+			}
+			if params.Heartbeat > 0 && time.Since(lastWrite) >= params.Heartbeat {
+				if err := sink.SendHeartbeat(); err != nil {
+					return nil
+				}
+				lastWrite = time.Now()
+			}
+		}
+		rounds++
+		if params.MaxRounds > 0 && rounds >= params.MaxRounds {
+			return nil
+		}
+	}
+}
+
+// sSSEStreamSink writes events as server-sent events, heartbeats go
+// out as SSE comment lines so they keep the connection alive without
+// reaching the event callback
+type sSSEStreamSink struct {
+	w http.ResponseWriter
+}
+
+func (s sSSEStreamSink) flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s sSSEStreamSink) SendEvent(event monitor.MetricStreamEvent) error {
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", jsonutils.Marshal(event).String()); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+func (s sSSEStreamSink) SendHeartbeat() error {
+	if _, err := io.WriteString(s.w, ": heartbeat\n\n"); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// MetricStreamHandler serves the live metric tail endpoint: the query
+// string carries a MetricStreamSpec and the response is a server-sent
+// event stream of MetricStreamEvent payloads. Non-admin callers are
+// pinned to their own project via the tenant_id tag and each user may
+// hold a limited number of streams open.
+func MetricStreamHandler(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userCred := auth.FetchUserCredential(ctx, policy.FilterPolicyCredential)
+	query, err := jsonutils.ParseQueryString(r.URL.RawQuery)
+	if err != nil {
+		httperrors.InvalidInputError(w, "parse query: %v", err)
+		return
+	}
+	spec := monitor.MetricStreamSpec{}
+	if err := query.Unmarshal(&spec); err != nil {
+		httperrors.InvalidInputError(w, "unmarshal spec: %v", err)
+		return
+	}
+	if err := validateMetricStreamSpec(&spec); err != nil {
+		httperrors.GeneralServerError(w, err)
+		return
+	}
+	scope, err := fetchMeasurementScope(userCred, query)
+	if err != nil {
+		httperrors.GeneralServerError(w, err)
+		return
+	}
+	ds, err := DataSourceManager.GetDefaultSource()
+	if err != nil {
+		httperrors.GeneralServerError(w, err)
+		return
+	}
+	userId := userCred.GetUserId()
+	if !metricStreamLimiter.Acquire(userId, options.Options.MetricStreamMaxPerUser) {
+		httperrors.GeneralServerError(w, httperrors.NewOutOfLimitError("too many streams, at most %d per user", options.Options.MetricStreamMaxPerUser))
+		return
+	}
+	defer metricStreamLimiter.Release(userId)
+
+	interval := time.Duration(spec.IntervalSeconds) * time.Second
+	if spec.IntervalSeconds <= 0 {
+		interval = time.Duration(options.Options.MetricStreamIntervalSeconds) * time.Second
+	}
+	params := sMetricStreamParams{
+		Interval:    interval,
+		Heartbeat:   time.Duration(options.Options.MetricStreamHeartbeatSeconds) * time.Second,
+		IdleTimeout: time.Duration(options.Options.MetricStreamIdleTimeoutSeconds) * time.Second,
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	sink := sSSEStreamSink{w: w}
+	sink.flush()
+	if err := runMetricStream(r.Context(), sInfluxTailQuerier{ds: ds}, sink, &spec, scope, params); err != nil {
+		log.Errorf("metric stream for user %s: %v", userId, err)
+	}
+}
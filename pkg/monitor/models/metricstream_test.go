@@ -0,0 +1,227 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func TestValidateMetricStreamSpec(t *testing.T) {
+	spec := monitor.MetricStreamSpec{
+		Measurement: "vm_cpu",
+		Fields:      []string{"usage_active"},
+		Tags:        map[string]string{"vm_id": "vm-1"},
+		GroupBy:     []string{"vm_id"},
+	}
+	if err := validateMetricStreamSpec(&spec); err != nil {
+		t.Fatalf("valid spec rejected: %v", err)
+	}
+	if spec.Database != "telegraf" {
+		t.Errorf("database default = %q, want telegraf", spec.Database)
+	}
+
+	missing := monitor.MetricStreamSpec{}
+	if err := validateMetricStreamSpec(&missing); err == nil {
+		t.Errorf("expect missing measurement error")
+	}
+
+	injected := monitor.MetricStreamSpec{Measurement: "vm_cpu", Fields: []string{`usage"; DROP`}}
+	if err := validateMetricStreamSpec(&injected); err == nil {
+		t.Errorf("expect invalid identifier error")
+	}
+}
+
+func TestBuildTailQL(t *testing.T) {
+	cursor := time.Unix(0, 1500000000000000000)
+	cases := []struct {
+		name  string
+		spec  monitor.MetricStreamSpec
+		scope sMeasurementScope
+		want  string
+	}{
+		{
+			name:  "all fields system scope",
+			spec:  monitor.MetricStreamSpec{Measurement: "vm_cpu"},
+			scope: sMeasurementScope{},
+			want:  `SELECT * FROM "vm_cpu" WHERE time > 1500000000000000000`,
+		},
+		{
+			name: "fields tags and group by",
+			spec: monitor.MetricStreamSpec{
+				Measurement: "vm_cpu",
+				Fields:      []string{"usage_active", "usage_idle"},
+				Tags:        map[string]string{"vm_id": "vm-1", "host": "h-1"},
+				GroupBy:     []string{"vm_id"},
+			},
+			scope: sMeasurementScope{},
+			want:  `SELECT "usage_active", "usage_idle" FROM "vm_cpu" WHERE time > 1500000000000000000 AND "host" = 'h-1' AND "vm_id" = 'vm-1' GROUP BY "vm_id"`,
+		},
+		{
+			name:  "project scope adds tenant cond",
+			spec:  monitor.MetricStreamSpec{Measurement: "vm_cpu"},
+			scope: sMeasurementScope{TenantId: "proj-1"},
+			want:  `SELECT * FROM "vm_cpu" WHERE time > 1500000000000000000 AND tenant_id = 'proj-1'`,
+		},
+	}
+	for _, c := range cases {
+		if got := buildTailQL(&c.spec, cursor, c.scope); got != c.want {
+			t.Errorf("%s:\n got  %s\n want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func msPoint(ms int64, value float64) []jsonutils.JSONObject {
+	return []jsonutils.JSONObject{jsonutils.NewInt(ms), jsonutils.NewFloat(value)}
+}
+
+func TestAdvanceMetricStreamCursor(t *testing.T) {
+	cursor := time.Unix(100, 0)
+	events := []monitor.MetricStreamEvent{
+		{Points: [][]jsonutils.JSONObject{msPoint(150000, 1), msPoint(170000, 2)}},
+		{Points: [][]jsonutils.JSONObject{msPoint(160000, 3)}},
+	}
+	got := advanceMetricStreamCursor(events, cursor)
+	if want := time.Unix(170, 0); !got.Equal(want) {
+		t.Errorf("cursor = %s, want %s", got, want)
+	}
+	if got := advanceMetricStreamCursor(nil, cursor); !got.Equal(cursor) {
+		t.Errorf("empty events must keep the cursor, got %s", got)
+	}
+}
+
+// fakeTailQuerier replays scripted batches, one per round, recording
+// the rendered queries
+type fakeTailQuerier struct {
+	batches [][]monitor.MetricStreamEvent
+	calls   int
+	qls     []string
+}
+
+func (q *fakeTailQuerier) TailDatapoints(ctx context.Context, database string, ql string) ([]monitor.MetricStreamEvent, error) {
+	q.qls = append(q.qls, ql)
+	if q.calls >= len(q.batches) {
+		q.calls++
+		return nil, nil
+	}
+	batch := q.batches[q.calls]
+	q.calls++
+	return batch, nil
+}
+
+// fakeStreamSink records what the loop pushed
+type fakeStreamSink struct {
+	events     []monitor.MetricStreamEvent
+	heartbeats int
+}
+
+func (s *fakeStreamSink) SendEvent(event monitor.MetricStreamEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *fakeStreamSink) SendHeartbeat() error {
+	s.heartbeats++
+	return nil
+}
+
+func TestRunMetricStreamForwardsAndAdvancesCursor(t *testing.T) {
+	querier := &fakeTailQuerier{
+		batches: [][]monitor.MetricStreamEvent{
+			{{Measurement: "vm_cpu", Columns: []string{"time", "usage_active"}, Points: [][]jsonutils.JSONObject{msPoint(1700000000123, 42)}}},
+			nil,
+			{{Measurement: "vm_cpu", Columns: []string{"time", "usage_active"}, Points: [][]jsonutils.JSONObject{msPoint(1700000005123, 43)}}},
+		},
+	}
+	sink := &fakeStreamSink{}
+	spec := monitor.MetricStreamSpec{Measurement: "vm_cpu", Database: "telegraf"}
+	params := sMetricStreamParams{Interval: time.Millisecond, Start: time.Unix(0, 1700000000000*int64(time.Millisecond)), MaxRounds: 3}
+	if err := runMetricStream(context.Background(), querier, sink, &spec, sMeasurementScope{}, params); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(sink.events) != 2 {
+		t.Fatalf("forwarded %d events, want 2", len(sink.events))
+	}
+	if len(querier.qls) != 3 {
+		t.Fatalf("ran %d queries, want 3", len(querier.qls))
+	}
+	// after the first batch the cursor must sit on its newest
+	// timestamp, so the second query fetches only later datapoints
+	wantCursor := fmt.Sprintf("time > %d", time.Unix(0, 1700000000123*int64(time.Millisecond)).UnixNano())
+	if !strings.Contains(querier.qls[1], wantCursor) {
+		t.Errorf("second query %q does not carry advanced cursor %q", querier.qls[1], wantCursor)
+	}
+	if !strings.Contains(querier.qls[2], wantCursor) {
+		t.Errorf("third query %q must keep the cursor over an empty round", querier.qls[2])
+	}
+}
+
+func TestRunMetricStreamHeartbeatAndIdleTimeout(t *testing.T) {
+	// a stream with no datapoints heartbeats while waiting
+	querier := &fakeTailQuerier{}
+	sink := &fakeStreamSink{}
+	spec := monitor.MetricStreamSpec{Measurement: "vm_cpu", Database: "telegraf"}
+	params := sMetricStreamParams{Interval: time.Millisecond, Heartbeat: 5 * time.Millisecond, MaxRounds: 20}
+	if err := runMetricStream(context.Background(), querier, sink, &spec, sMeasurementScope{}, params); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if sink.heartbeats == 0 {
+		t.Errorf("expect heartbeats on a quiet stream")
+	}
+	if len(sink.events) != 0 {
+		t.Errorf("quiet stream pushed %d events", len(sink.events))
+	}
+
+	// the idle timeout ends the loop before MaxRounds
+	querier = &fakeTailQuerier{}
+	sink = &fakeStreamSink{}
+	params = sMetricStreamParams{Interval: time.Millisecond, IdleTimeout: 5 * time.Millisecond, MaxRounds: 1000}
+	if err := runMetricStream(context.Background(), querier, sink, &spec, sMeasurementScope{}, params); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if querier.calls >= 1000 {
+		t.Errorf("idle timeout did not end the stream")
+	}
+}
+
+func TestStreamLimiter(t *testing.T) {
+	lim := newStreamLimiter()
+	if !lim.Acquire("u1", 2) || !lim.Acquire("u1", 2) {
+		t.Fatalf("first two streams must be admitted")
+	}
+	if lim.Acquire("u1", 2) {
+		t.Errorf("third stream must be rejected")
+	}
+	if !lim.Acquire("u2", 2) {
+		t.Errorf("other users are counted separately")
+	}
+	lim.Release("u1")
+	if !lim.Acquire("u1", 2) {
+		t.Errorf("released slot must be reusable")
+	}
+	// max <= 0 means unlimited
+	for i := 0; i < 10; i++ {
+		if !lim.Acquire("u3", 0) {
+			t.Fatalf("unlimited acquire rejected")
+		}
+	}
+}
@@ -0,0 +1,743 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/tristate"
+	"yunion.io/x/pkg/util/wait"
+	"yunion.io/x/sqlchemy"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/cloudcommon/policy"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modulebase"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+	"yunion.io/x/onecloud/pkg/util/httputils"
+	"yunion.io/x/onecloud/pkg/util/rbacutils"
+	"yunion.io/x/onecloud/pkg/util/stringutils2"
+)
+
+const (
+	maxResolveRetries    = 3
+	resolveRetryInterval = 5 * time.Second
+)
+
+var (
+	SuggestSysAlertManager *SSuggestSysAlertManager
+)
+
+func init() {
+	SuggestSysAlertManager = &SSuggestSysAlertManager{
+		SVirtualResourceBaseManager: db.NewVirtualResourceBaseManager(
+			SSuggestSysAlert{},
+			"suggestsysalert_tbl",
+			"suggestsysalert",
+			"suggestsysalerts",
+		),
+	}
+	SuggestSysAlertManager.SetVirtualObject(SuggestSysAlertManager)
+}
+
+type SSuggestSysAlertManager struct {
+	db.SVirtualResourceBaseManager
+}
+
+type SSuggestSysAlert struct {
+	db.SVirtualResourceBase
+
+	Type          string               `width:"256" charset:"ascii" list:"user" create:"required"`
+	ResId         string               `width:"256" charset:"ascii" list:"user" create:"required"`
+	MonitorConfig jsonutils.JSONObject `list:"user" create:"optional" update:"user"`
+	Problem       jsonutils.JSONObject `list:"user" create:"optional" update:"user"`
+	Suggest       string               `width:"256" charset:"utf8" list:"user" create:"optional" update:"user"`
+	Action        string               `width:"256" charset:"ascii" list:"user" create:"optional" update:"user"`
+	ResMeta       jsonutils.JSONObject `list:"user" create:"optional" update:"user"`
+
+	IsIgnored      tristate.TriState `nullable:"false" default:"false" list:"user"`
+	IgnoreReason   string            `width:"256" charset:"utf8" list:"user"`
+	IgnoreExpireAt time.Time         `list:"user"`
+
+	ResolveResult string `width:"1024" charset:"utf8" list:"user"`
+
+	// 预计每月可节省的费用, 由建议规则驱动按资源估算
+	Amount float64 `nullable:"true" list:"user" create:"optional" update:"user" json:"amount"`
+
+	// 问题首次被发现的时间, 重复发现同一问题时保持不变
+	FirstDetectedAt time.Time `list:"user" create:"optional" json:"first_detected_at"`
+
+	// 关联资源名称, 创建时从归属服务冗余
+	ResName string `width:"128" charset:"utf8" list:"user" create:"optional"`
+	// 关联资源所在区域, 创建时从归属服务冗余
+	RegionId string `width:"64" charset:"ascii" list:"user" create:"optional"`
+	// 关联资源被发现不存在的时间
+	StaleAt time.Time `list:"user"`
+
+	// 建议被解决的时间
+	ResolvedAt time.Time `list:"user"`
+	// 解决方式, auto表示问题自行消失, action表示执行了建议动作
+	ResolvedReason string `width:"32" charset:"ascii" list:"user"`
+}
+
+// sSuggestAlertResource is what alert enrichment needs to know about
+// the referenced resource, nil when the resource no longer exists
+type sSuggestAlertResource struct {
+	Name      string
+	ProjectId string
+	RegionId  string
+}
+
+// suggestAlertResourceFetcher resolves a resource against its owning
+// service, replaceable in tests
+type suggestAlertResourceFetcher func(ctx context.Context, resType string, resId string) (*sSuggestAlertResource, error)
+
+var fetchSuggestAlertResource suggestAlertResourceFetcher = fetchSuggestAlertResourceByMcclient
+
+func fetchSuggestAlertResourceByMcclient(ctx context.Context, resType string, resId string) (*sSuggestAlertResource, error) {
+	s := auth.GetAdminSession(ctx, options.Options.Region, "")
+	mod, err := modulebase.GetModule(s, resType+"s")
+	if err != nil {
+		return nil, errors.Wrapf(err, "get module for resource type %s", resType)
+	}
+	ret, err := mod.Get(s, resId, nil)
+	if err != nil {
+		if httputils.ErrorCode(err) == 404 {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "get %s %s", resType, resId)
+	}
+	res := &sSuggestAlertResource{}
+	res.Name, _ = ret.GetString("name")
+	res.ProjectId = jsonutils.GetAnyString(ret, []string{"tenant_id", "project_id"})
+	res.RegionId = jsonutils.GetAnyString(ret, []string{"cloudregion_id", "region_id"})
+	return res, nil
+}
+
+// enrichSuggestAlertCreateInput validates that the referenced resource
+// still exists and denormalizes its name, project and region onto the
+// alert
+func enrichSuggestAlertCreateInput(ctx context.Context, data monitor.SuggestSysAlertCreateInput, resType string, fetch suggestAlertResourceFetcher) (monitor.SuggestSysAlertCreateInput, error) {
+	res, err := fetch(ctx, resType, data.ResId)
+	if err != nil {
+		return data, errors.Wrapf(err, "fetch %s %s", resType, data.ResId)
+	}
+	if res == nil {
+		return data, httperrors.NewResourceNotFoundError("%s %s not found", resType, data.ResId)
+	}
+	data.ResName = res.Name
+	data.RegionId = res.RegionId
+	if len(data.Project) == 0 {
+		data.Project = res.ProjectId
+	}
+	return data, nil
+}
+
+func (man *SSuggestSysAlertManager) ValidateCreateData(
+	ctx context.Context, userCred mcclient.TokenCredential,
+	ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject,
+	data monitor.SuggestSysAlertCreateInput) (monitor.SuggestSysAlertCreateInput, error) {
+	drv, ok := GetSuggestSysRuleDrivers()[data.Type]
+	if !ok {
+		return data, httperrors.NewInputParameterError("unknown suggest rule type %s", data.Type)
+	}
+	if len(data.ResId) == 0 {
+		return data, httperrors.NewMissingParameterError("res_id")
+	}
+	if drv.GetResourceType() == "" {
+		// externally evaluated rules reference resources this service
+		// cannot look up
+		return data, nil
+	}
+	return enrichSuggestAlertCreateInput(ctx, data, drv.GetResourceType(), fetchSuggestAlertResource)
+}
+
+// FilterByOwner scopes the alert list by the denormalized project and
+// domain of the target resource: project tokens see alerts of their own
+// project, domain tokens see every alert whose resource lives in their
+// domain
+func (man *SSuggestSysAlertManager) FilterByOwner(q *sqlchemy.SQuery, owner mcclient.IIdentityProvider, scope rbacutils.TRbacScope) *sqlchemy.SQuery {
+	if owner != nil {
+		switch scope {
+		case rbacutils.ScopeProject:
+			q = q.Equals("tenant_id", owner.GetProjectId())
+		case rbacutils.ScopeDomain:
+			q = q.Equals("domain_id", owner.GetProjectDomainId())
+		}
+	}
+	return q
+}
+
+// suggestAlertRequiredScope returns the minimal policy scope a caller
+// needs to see or act on an alert, derived from the denormalized
+// project and domain of the target resource
+func suggestAlertRequiredScope(credProjectId string, credDomainId string, alertProjectId string, alertDomainId string) rbacutils.TRbacScope {
+	if len(alertProjectId) > 0 && credProjectId == alertProjectId {
+		return rbacutils.ScopeProject
+	}
+	if len(alertDomainId) > 0 && credDomainId == alertDomainId {
+		return rbacutils.ScopeDomain
+	}
+	return rbacutils.ScopeSystem
+}
+
+func (alert *SSuggestSysAlert) requiredPolicyScope(userCred mcclient.TokenCredential) rbacutils.TRbacScope {
+	return suggestAlertRequiredScope(userCred.GetProjectId(), userCred.GetProjectDomainId(), alert.ProjectId, alert.DomainId)
+}
+
+func (man *SSuggestSysAlertManager) GetAlert(id string) (*SSuggestSysAlert, error) {
+	obj, err := man.FetchById(id)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*SSuggestSysAlert), nil
+}
+
+func (man *SSuggestSysAlertManager) FetchByTypeAndResId(ruleType string, resId string) (*SSuggestSysAlert, error) {
+	alert := new(SSuggestSysAlert)
+	q := man.Query().Equals("type", ruleType).Equals("res_id", resId)
+	if err := q.First(alert); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	alert.SetModelManager(man, alert)
+	return alert, nil
+}
+
+// applySuggestAlertObservation folds one observation of a (type,
+// res_id) problem into the alert: resource metadata and the estimated
+// saving refresh on every run while FirstDetectedAt keeps the time the
+// problem was first seen
+func applySuggestAlertObservation(alert *SSuggestSysAlert, resMeta jsonutils.JSONObject, amount float64, now time.Time) {
+	alert.ResMeta = resMeta
+	alert.Amount = amount
+	if alert.FirstDetectedAt.IsZero() {
+		alert.FirstDetectedAt = now
+	}
+	if alert.Status == monitor.SUGGEST_ALERT_RESOLVED && alert.ResolvedReason == monitor.SUGGEST_ALERT_RESOLVED_REASON_AUTO {
+		// the problem looked fixed but came back, reopen the alert
+		alert.Status = monitor.SUGGEST_ALERT_READY
+		alert.ResolvedAt = time.Time{}
+		alert.ResolvedReason = ""
+	}
+}
+
+// markSuggestAlertResolved stamps the resolution on the alert; the
+// reconciler deletes resolved alerts after the retention window
+func markSuggestAlertResolved(alert *SSuggestSysAlert, reason string, now time.Time) {
+	alert.Status = monitor.SUGGEST_ALERT_RESOLVED
+	alert.ResolvedAt = now
+	alert.ResolvedReason = reason
+}
+
+// suggestAlertShouldAutoResolve decides whether a rule run must mark
+// the alert resolved given the current candidate set: ignored alerts
+// were excluded from the candidates on purpose and alerts with a
+// resolution in progress settle on their own
+func suggestAlertShouldAutoResolve(alert *SSuggestSysAlert, reported map[string]bool) bool {
+	if reported[alert.ResId] {
+		return false
+	}
+	if alert.IsIgnored.IsTrue() {
+		return false
+	}
+	if alert.Status == monitor.SUGGEST_ALERT_RESOLVED || alert.Status == monitor.SUGGEST_ALERT_RESOLVING {
+		return false
+	}
+	return true
+}
+
+// suggestAlertResolvedExpired reports whether a resolved alert has
+// outlived the retention window and can be deleted
+func suggestAlertResolvedExpired(resolvedAt time.Time, now time.Time, retention time.Duration) bool {
+	if resolvedAt.IsZero() {
+		return false
+	}
+	return now.Sub(resolvedAt) >= retention
+}
+
+// ObserveAlert records one observation of a (type, res_id) problem:
+// the first observation creates the alert stamping FirstDetectedAt,
+// repeated observations refresh the resource metadata and the
+// estimated saving while preserving it
+func (man *SSuggestSysAlertManager) ObserveAlert(ctx context.Context, alert *SSuggestSysAlert) error {
+	existing, err := man.FetchByTypeAndResId(alert.Type, alert.ResId)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		_, err := db.Update(existing, func() error {
+			applySuggestAlertObservation(existing, alert.ResMeta, alert.Amount, time.Now())
+			return nil
+		})
+		return err
+	}
+	applySuggestAlertObservation(alert, alert.ResMeta, alert.Amount, time.Now())
+	alert.SetModelManager(man, alert)
+	return man.TableSpec().Insert(alert)
+}
+
+func (man *SSuggestSysAlertManager) FetchAlertsByType(ruleType string) ([]SSuggestSysAlert, error) {
+	alerts := make([]SSuggestSysAlert, 0)
+	q := man.Query().Equals("type", ruleType)
+	if err := db.FetchModelObjects(man, q, &alerts); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "db.FetchModelObjects")
+	}
+	return alerts, nil
+}
+
+// ResolveAbsentAlerts marks alerts of the given rule type whose res_id
+// is no longer among the current candidates as auto-resolved, returning
+// how many were resolved. Resolved alerts stay visible for the
+// retention window and are deleted by the reconciler afterwards.
+func (man *SSuggestSysAlertManager) ResolveAbsentAlerts(ctx context.Context, ruleType string, reported map[string]bool) (int, error) {
+	alerts, err := man.FetchAlertsByType(ruleType)
+	if err != nil {
+		return 0, err
+	}
+	resolved := 0
+	now := time.Now()
+	for i := range alerts {
+		alert := &alerts[i]
+		if !suggestAlertShouldAutoResolve(alert, reported) {
+			continue
+		}
+		if _, err := db.Update(alert, func() error {
+			markSuggestAlertResolved(alert, monitor.SUGGEST_ALERT_RESOLVED_REASON_AUTO, now)
+			return nil
+		}); err != nil {
+			log.Errorf("resolve absent alert %s: %v", alert.GetName(), err)
+			continue
+		}
+		resolved++
+	}
+	return resolved, nil
+}
+
+// sSuggestAlertStatRow is one grouped row of the statistics query:
+// alerts of one type in one project
+type sSuggestAlertStatRow struct {
+	Type     string
+	TenantId string
+	DomainId string
+	Count    int
+	Saving   float64
+}
+
+// foldSuggestAlertStatistics folds grouped per-type/per-project rows
+// into the response shape the overview dashboard consumes
+func foldSuggestAlertStatistics(rows []sSuggestAlertStatRow, projectName func(projectId string) string) monitor.SuggestSysAlertStatistics {
+	stats := monitor.SuggestSysAlertStatistics{
+		ByType:    make(map[string]monitor.SuggestAlertTypeStatistics),
+		ByProject: make([]monitor.SuggestAlertProjectStatistics, 0),
+	}
+	projIdx := make(map[string]int)
+	for _, row := range rows {
+		typeStat := stats.ByType[row.Type]
+		typeStat.Count += row.Count
+		typeStat.Saving += row.Saving
+		stats.ByType[row.Type] = typeStat
+
+		idx, ok := projIdx[row.TenantId]
+		if !ok {
+			idx = len(stats.ByProject)
+			projIdx[row.TenantId] = idx
+			stats.ByProject = append(stats.ByProject, monitor.SuggestAlertProjectStatistics{
+				ProjectId: row.TenantId,
+				Project:   projectName(row.TenantId),
+				DomainId:  row.DomainId,
+			})
+		}
+		stats.ByProject[idx].Count += row.Count
+		stats.ByProject[idx].Saving += row.Saving
+
+		stats.TotalCount += row.Count
+		stats.TotalSaving += row.Saving
+	}
+	return stats
+}
+
+func (man *SSuggestSysAlertManager) AllowGetPropertyStatistics(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return true
+}
+
+// GetPropertyStatistics returns alert counts and estimated monthly
+// savings grouped by rule type and by project, honoring the same
+// scope-based visibility the alert list uses
+func (man *SSuggestSysAlertManager) GetPropertyStatistics(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	ownerId, queryScope, err := db.FetchCheckQueryOwnerScope(ctx, userCred, query, man, policy.PolicyActionList, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "FetchCheckQueryOwnerScope")
+	}
+	alerts := man.Query().SubQuery()
+	q := alerts.Query(
+		alerts.Field("type"),
+		alerts.Field("tenant_id"),
+		alerts.Field("domain_id"),
+		sqlchemy.COUNT("count"),
+		sqlchemy.SUM("saving", alerts.Field("amount")),
+	)
+	q = man.FilterByOwner(q, ownerId, queryScope)
+	q = q.GroupBy(alerts.Field("type"), alerts.Field("tenant_id"))
+	rows := make([]sSuggestAlertStatRow, 0)
+	if err := q.All(&rows); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "query statistics")
+	}
+	stats := foldSuggestAlertStatistics(rows, func(projectId string) string {
+		tenant, err := db.TenantCacheManager.FetchTenantById(ctx, projectId)
+		if err != nil {
+			return ""
+		}
+		return tenant.GetName()
+	})
+	return jsonutils.Marshal(stats), nil
+}
+
+func (man *SSuggestSysAlertManager) ListItemFilter(
+	ctx context.Context,
+	q *sqlchemy.SQuery,
+	userCred mcclient.TokenCredential,
+	input monitor.SuggestSysAlertListInput,
+) (*sqlchemy.SQuery, error) {
+	q, err := man.SVirtualResourceBaseManager.ListItemFilter(ctx, q, userCred, input.VirtualResourceListInput)
+	if err != nil {
+		return nil, errors.Wrap(err, "SVirtualResourceBaseManager.ListItemFilter")
+	}
+	if input.Type != "" {
+		q = q.Equals("type", input.Type)
+	}
+	if input.ResId != "" {
+		q = q.Equals("res_id", input.ResId)
+	}
+	if input.ProjectId != "" {
+		q = q.Equals("tenant_id", input.ProjectId)
+	}
+	if input.RegionId != "" {
+		q = q.Equals("region_id", input.RegionId)
+	}
+	if !input.ShowIgnored {
+		q = q.IsFalse("is_ignored")
+	}
+	if !input.ShowResolved && len(input.Status) == 0 {
+		// resolved alerts linger for the retention window but only show
+		// up on request
+		q = q.NotEquals("status", monitor.SUGGEST_ALERT_RESOLVED)
+	}
+	if input.AmountGt != nil {
+		q = q.GT("amount", *input.AmountGt)
+	}
+	return q, nil
+}
+
+func (man *SSuggestSysAlertManager) OrderByExtraFields(
+	ctx context.Context,
+	q *sqlchemy.SQuery,
+	userCred mcclient.TokenCredential,
+	input monitor.SuggestSysAlertListInput,
+) (*sqlchemy.SQuery, error) {
+	q, err := man.SVirtualResourceBaseManager.OrderByExtraFields(ctx, q, userCred, input.VirtualResourceListInput)
+	if err != nil {
+		return nil, errors.Wrap(err, "SVirtualResourceBaseManager.OrderByExtraFields")
+	}
+	if db.NeedOrderQuery([]string{input.OrderByAmount}) {
+		q = db.OrderByFields(q, []string{input.OrderByAmount}, []sqlchemy.IQueryField{q.Field("amount")})
+	}
+	if db.NeedOrderQuery([]string{input.OrderByFirstDetectedAt}) {
+		q = db.OrderByFields(q, []string{input.OrderByFirstDetectedAt}, []sqlchemy.IQueryField{q.Field("first_detected_at")})
+	}
+	return q, nil
+}
+
+func (man *SSuggestSysAlertManager) FetchCustomizeColumns(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	objs []interface{},
+	fields stringutils2.SSortedStrings,
+	isList bool,
+) []monitor.SuggestSysAlertDetails {
+	rows := make([]monitor.SuggestSysAlertDetails, len(objs))
+	virtRows := man.SVirtualResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	for i := range rows {
+		alert := objs[i].(*SSuggestSysAlert)
+		rows[i] = monitor.SuggestSysAlertDetails{
+			VirtualResourceDetails: virtRows[i],
+			IsIgnored:              alert.IsIgnored.Bool(),
+			IgnoreReason:           alert.IgnoreReason,
+			IgnoreExpireAt:         alert.IgnoreExpireAt,
+			ResName:                alert.ResName,
+			RegionId:               alert.RegionId,
+			StaleAt:                alert.StaleAt,
+			ResolvedAt:             alert.ResolvedAt,
+			ResolvedReason:         alert.ResolvedReason,
+		}
+	}
+	return rows
+}
+
+func (alert *SSuggestSysAlert) GetExtraDetails(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	isList bool,
+) (monitor.SuggestSysAlertDetails, error) {
+	return monitor.SuggestSysAlertDetails{}, nil
+}
+
+func (alert *SSuggestSysAlert) AllowPerformResolve(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertResolveInput) bool {
+	// the policy check runs at the scope the alert's resource ownership
+	// implies, so a domain admin may resolve alerts of the own domain
+	// without the monitor service's system-admin policy
+	scope := alert.requiredPolicyScope(userCred)
+	if !db.IsAllowPerform(scope, userCred, alert, "resolve") {
+		return false
+	}
+	drv := GetSuggestSysRuleDrivers()[alert.Type]
+	if drv == nil {
+		return false
+	}
+	// the caller must also be allowed to perform the suggested action on
+	// the target resource, not just on the alert itself
+	return userCred.IsAllow(scope, "compute", drv.GetResourceType()+"s", policy.PolicyActionDelete)
+}
+
+// PerformResolve dispatches the suggested action to the owning service
+// and tracks the outcome on the alert
+func (alert *SSuggestSysAlert) PerformResolve(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertResolveInput) (jsonutils.JSONObject, error) {
+	if alert.Status == monitor.SUGGEST_ALERT_RESOLVING {
+		return nil, httperrors.NewInvalidStatusError("alert is already resolving")
+	}
+	drv := GetSuggestSysRuleDrivers()[alert.Type]
+	if drv == nil {
+		return nil, httperrors.NewNotSupportedError("no driver for rule type %s", alert.Type)
+	}
+	alert.SetStatus(userCred, monitor.SUGGEST_ALERT_RESOLVING, "")
+	go alert.doResolve(ctx, userCred, drv)
+	return nil, nil
+}
+
+func (alert *SSuggestSysAlert) doResolve(ctx context.Context, userCred mcclient.TokenCredential, drv ISuggestSysRuleDriver) {
+	var err error
+	for i := 0; i < maxResolveRetries; i++ {
+		if err = drv.Resolve(ctx, alert); err == nil {
+			break
+		}
+		log.Errorf("resolve alert %s attempt %d: %v", alert.GetName(), i+1, err)
+		time.Sleep(resolveRetryInterval)
+	}
+	if err != nil {
+		db.Update(alert, func() error {
+			alert.ResolveResult = err.Error()
+			return nil
+		})
+		alert.SetStatus(userCred, monitor.SUGGEST_ALERT_RESOLVE_FAIL, err.Error())
+		return
+	}
+	db.Update(alert, func() error {
+		alert.ResolveResult = "success"
+		alert.ResolvedAt = time.Now()
+		alert.ResolvedReason = monitor.SUGGEST_ALERT_RESOLVED_REASON_ACTION
+		return nil
+	})
+	alert.SetStatus(userCred, monitor.SUGGEST_ALERT_RESOLVED, "")
+}
+
+func (man *SSuggestSysAlertManager) AllowPerformResolve(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertBatchResolveInput) bool {
+	return db.IsProjectAllowClassPerform(userCred, man, "resolve")
+}
+
+// PerformResolve resolves a batch of alerts in one call
+func (man *SSuggestSysAlertManager) PerformResolve(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertBatchResolveInput) (jsonutils.JSONObject, error) {
+	if len(input.Ids) == 0 {
+		return nil, httperrors.NewInputParameterError("ids is empty")
+	}
+	alerts := make([]*SSuggestSysAlert, 0, len(input.Ids))
+	for _, id := range input.Ids {
+		alert, err := man.GetAlert(id)
+		if err != nil {
+			return nil, httperrors.NewResourceNotFoundError("alert %s not found", id)
+		}
+		if !alert.AllowPerformResolve(ctx, userCred, query, monitor.SuggestAlertResolveInput{}) {
+			return nil, httperrors.NewForbiddenError("not allow to resolve alert %s", id)
+		}
+		alerts = append(alerts, alert)
+	}
+	for _, alert := range alerts {
+		if _, err := alert.PerformResolve(ctx, userCred, query, monitor.SuggestAlertResolveInput{}); err != nil {
+			return nil, errors.Wrapf(err, "resolve alert %s", alert.GetId())
+		}
+	}
+	return nil, nil
+}
+
+func (alert *SSuggestSysAlert) AllowPerformIgnore(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertIgnoreInput) bool {
+	return db.IsAllowPerform(alert.requiredPolicyScope(userCred), userCred, alert, "ignore")
+}
+
+// PerformIgnore marks the alert ignored and records the (type, res_id)
+// pair in the ignore registry so rule runs skip the resource
+func (alert *SSuggestSysAlert) PerformIgnore(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestAlertIgnoreInput) (jsonutils.JSONObject, error) {
+	var expireAt time.Time
+	if input.Duration != "" {
+		duration, err := time.ParseDuration(input.Duration)
+		if err != nil {
+			return nil, httperrors.NewInputParameterError("invalid duration %s", input.Duration)
+		}
+		expireAt = time.Now().Add(duration)
+	}
+	if err := SuggestSysAlertIgnoreManager.CreateIgnore(ctx, userCred, alert.Type, alert.ResId, input.Reason, expireAt); err != nil {
+		return nil, errors.Wrap(err, "create ignore record")
+	}
+	if _, err := db.Update(alert, func() error {
+		alert.IsIgnored = tristate.True
+		alert.IgnoreReason = input.Reason
+		alert.IgnoreExpireAt = expireAt
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// tSuggestAlertReconcileAction is the decision of one reconcile pass
+// for one alert
+type tSuggestAlertReconcileAction string
+
+const (
+	suggestAlertKeep      = tSuggestAlertReconcileAction("keep")
+	suggestAlertMarkStale = tSuggestAlertReconcileAction("mark_stale")
+	suggestAlertUnstale   = tSuggestAlertReconcileAction("unstale")
+	suggestAlertDelete    = tSuggestAlertReconcileAction("delete")
+)
+
+// reconcileSuggestAlertAction decides what to do with an alert given
+// whether its resource still exists: a missing resource marks the
+// alert stale, a stale alert past the grace period is deleted and an
+// alert whose resource reappeared is restored
+func reconcileSuggestAlertAction(resExists bool, status string, staleAt time.Time, now time.Time, grace time.Duration) tSuggestAlertReconcileAction {
+	if resExists {
+		if status == monitor.SUGGEST_ALERT_STALE {
+			return suggestAlertUnstale
+		}
+		return suggestAlertKeep
+	}
+	if status != monitor.SUGGEST_ALERT_STALE {
+		return suggestAlertMarkStale
+	}
+	if !staleAt.IsZero() && now.Sub(staleAt) >= grace {
+		return suggestAlertDelete
+	}
+	return suggestAlertKeep
+}
+
+// startResourceReconciler periodically verifies that alert resources
+// still exist in their owning service
+func (man *SSuggestSysAlertManager) startResourceReconciler(ctx context.Context) {
+	interval := time.Duration(options.Options.SuggestAlertReconcileIntervalSeconds) * time.Second
+	wait.Forever(func() {
+		man.reconcileAlertResources(ctx)
+	}, interval)
+}
+
+func (man *SSuggestSysAlertManager) reconcileAlertResources(ctx context.Context) {
+	alerts := make([]SSuggestSysAlert, 0)
+	if err := db.FetchModelObjects(man, man.Query(), &alerts); err != nil && err != sql.ErrNoRows {
+		log.Errorf("fetch suggest alerts: %v", err)
+		return
+	}
+	grace := time.Duration(options.Options.SuggestAlertStaleGraceHours) * time.Hour
+	retention := time.Duration(options.Options.SuggestAlertResolvedRetentionHours) * time.Hour
+	now := time.Now()
+	for i := range alerts {
+		alert := &alerts[i]
+		if alert.Status == monitor.SUGGEST_ALERT_RESOLVED {
+			// resolved alerts just wait out the retention window, their
+			// resource needs no verification
+			if suggestAlertResolvedExpired(alert.ResolvedAt, now, retention) {
+				if err := alert.Delete(ctx, nil); err != nil {
+					log.Errorf("delete resolved alert %s: %v", alert.GetName(), err)
+				}
+			}
+			continue
+		}
+		drv := GetSuggestSysRuleDrivers()[alert.Type]
+		if drv == nil || drv.GetResourceType() == "" {
+			// alerts of externally evaluated rules reference resources
+			// the reconciler cannot verify
+			continue
+		}
+		res, err := fetchSuggestAlertResource(ctx, drv.GetResourceType(), alert.ResId)
+		if err != nil {
+			log.Errorf("reconcile alert %s: %v", alert.GetName(), err)
+			continue
+		}
+		action := reconcileSuggestAlertAction(res != nil, alert.Status, alert.StaleAt, now, grace)
+		alert.applyReconcileAction(ctx, action, now)
+	}
+}
+
+func (alert *SSuggestSysAlert) applyReconcileAction(ctx context.Context, action tSuggestAlertReconcileAction, now time.Time) {
+	switch action {
+	case suggestAlertMarkStale:
+		if _, err := db.Update(alert, func() error {
+			alert.Status = monitor.SUGGEST_ALERT_STALE
+			alert.StaleAt = now
+			return nil
+		}); err != nil {
+			log.Errorf("mark alert %s stale: %v", alert.GetName(), err)
+		}
+	case suggestAlertUnstale:
+		if _, err := db.Update(alert, func() error {
+			alert.Status = monitor.SUGGEST_ALERT_READY
+			alert.StaleAt = time.Time{}
+			return nil
+		}); err != nil {
+			log.Errorf("restore alert %s: %v", alert.GetName(), err)
+		}
+	case suggestAlertDelete:
+		if err := alert.Delete(ctx, nil); err != nil {
+			log.Errorf("delete stale alert %s: %v", alert.GetName(), err)
+		}
+	}
+}
+
+func (alert *SSuggestSysAlert) AllowPerformCancelIgnore(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input jsonutils.JSONObject) bool {
+	return db.IsAllowPerform(alert.requiredPolicyScope(userCred), userCred, alert, "cancel-ignore")
+}
+
+func (alert *SSuggestSysAlert) PerformCancelIgnore(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	if err := SuggestSysAlertIgnoreManager.DeleteIgnore(ctx, userCred, alert.Type, alert.ResId); err != nil {
+		return nil, errors.Wrap(err, "delete ignore record")
+	}
+	if _, err := db.Update(alert, func() error {
+		alert.IsIgnored = tristate.False
+		alert.IgnoreReason = ""
+		alert.IgnoreExpireAt = time.Time{}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,266 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/tristate"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/util/rbacutils"
+)
+
+func TestFoldSuggestAlertStatistics(t *testing.T) {
+	rows := []sSuggestAlertStatRow{
+		{Type: "EIP_UNUSED", TenantId: "proj-1", DomainId: "dom-1", Count: 2, Saving: 20},
+		{Type: "EIP_UNUSED", TenantId: "proj-2", DomainId: "dom-1", Count: 1, Saving: 8},
+		{Type: "DISK_UNUSED", TenantId: "proj-1", DomainId: "dom-1", Count: 3, Saving: 15},
+	}
+	names := map[string]string{"proj-1": "dev", "proj-2": "ops"}
+	stats := foldSuggestAlertStatistics(rows, func(id string) string { return names[id] })
+
+	if stats.TotalCount != 6 {
+		t.Errorf("TotalCount = %d, want 6", stats.TotalCount)
+	}
+	if stats.TotalSaving != 43 {
+		t.Errorf("TotalSaving = %v, want 43", stats.TotalSaving)
+	}
+	if got := stats.ByType["EIP_UNUSED"]; got.Count != 3 || got.Saving != 28 {
+		t.Errorf("ByType[EIP_UNUSED] = %+v, want count 3 saving 28", got)
+	}
+	if got := stats.ByType["DISK_UNUSED"]; got.Count != 3 || got.Saving != 15 {
+		t.Errorf("ByType[DISK_UNUSED] = %+v, want count 3 saving 15", got)
+	}
+	if len(stats.ByProject) != 2 {
+		t.Fatalf("expect 2 projects, got %d", len(stats.ByProject))
+	}
+	for _, proj := range stats.ByProject {
+		switch proj.ProjectId {
+		case "proj-1":
+			if proj.Count != 5 || proj.Saving != 35 || proj.Project != "dev" {
+				t.Errorf("proj-1 stats = %+v", proj)
+			}
+		case "proj-2":
+			if proj.Count != 1 || proj.Saving != 8 || proj.Project != "ops" {
+				t.Errorf("proj-2 stats = %+v", proj)
+			}
+		default:
+			t.Errorf("unexpected project %s", proj.ProjectId)
+		}
+	}
+}
+
+func TestEnrichSuggestAlertCreateInput(t *testing.T) {
+	ctx := context.Background()
+
+	found := func(ctx context.Context, resType string, resId string) (*sSuggestAlertResource, error) {
+		return &sSuggestAlertResource{Name: "eip-01", ProjectId: "proj-1", RegionId: "region-1"}, nil
+	}
+	data := monitor.SuggestSysAlertCreateInput{ResId: "eip-id-01"}
+	out, err := enrichSuggestAlertCreateInput(ctx, data, "eip", found)
+	if err != nil {
+		t.Fatalf("enrich: %v", err)
+	}
+	if out.ResName != "eip-01" || out.RegionId != "region-1" || out.Project != "proj-1" {
+		t.Errorf("enriched input = %+v", out)
+	}
+
+	// an explicitly requested project is not overridden
+	data.Project = "proj-override"
+	out, err = enrichSuggestAlertCreateInput(ctx, data, "eip", found)
+	if err != nil {
+		t.Fatalf("enrich: %v", err)
+	}
+	if out.Project != "proj-override" {
+		t.Errorf("Project = %s, want proj-override", out.Project)
+	}
+
+	missing := func(ctx context.Context, resType string, resId string) (*sSuggestAlertResource, error) {
+		return nil, nil
+	}
+	if _, err := enrichSuggestAlertCreateInput(ctx, data, "eip", missing); err == nil {
+		t.Errorf("expect not found error for missing resource")
+	}
+
+	failing := func(ctx context.Context, resType string, resId string) (*sSuggestAlertResource, error) {
+		return nil, errors.Error("service unavailable")
+	}
+	if _, err := enrichSuggestAlertCreateInput(ctx, data, "eip", failing); err == nil {
+		t.Errorf("expect fetch error to propagate")
+	}
+}
+
+func TestReconcileSuggestAlertAction(t *testing.T) {
+	now := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	grace := 24 * time.Hour
+	cases := []struct {
+		name    string
+		exists  bool
+		status  string
+		staleAt time.Time
+		want    tSuggestAlertReconcileAction
+	}{
+		{"healthy alert untouched", true, monitor.SUGGEST_ALERT_READY, time.Time{}, suggestAlertKeep},
+		{"resource reappeared", true, monitor.SUGGEST_ALERT_STALE, now.Add(-time.Hour), suggestAlertUnstale},
+		{"resource gone marks stale", false, monitor.SUGGEST_ALERT_READY, time.Time{}, suggestAlertMarkStale},
+		{"stale within grace kept", false, monitor.SUGGEST_ALERT_STALE, now.Add(-time.Hour), suggestAlertKeep},
+		{"stale past grace deleted", false, monitor.SUGGEST_ALERT_STALE, now.Add(-grace), suggestAlertDelete},
+	}
+	for _, c := range cases {
+		if got := reconcileSuggestAlertAction(c.exists, c.status, c.staleAt, now, grace); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSuggestAlertAutoResolve(t *testing.T) {
+	firstRun := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	secondRun := firstRun.Add(30 * time.Minute)
+	meta := jsonutils.Marshal(map[string]string{"name": "eip-1"})
+
+	// first run: eip-1 is a candidate and the alert is observed
+	alert := &SSuggestSysAlert{Type: "EIP_UNUSED", ResId: "eip-1"}
+	alert.Status = monitor.SUGGEST_ALERT_READY
+	applySuggestAlertObservation(alert, meta, 10, firstRun)
+	if suggestAlertShouldAutoResolve(alert, map[string]bool{"eip-1": true}) {
+		t.Fatalf("reported alert must not auto resolve")
+	}
+
+	// second run: the eip got attached, eip-1 left the candidate set
+	if !suggestAlertShouldAutoResolve(alert, map[string]bool{}) {
+		t.Fatalf("absent alert must auto resolve")
+	}
+	markSuggestAlertResolved(alert, monitor.SUGGEST_ALERT_RESOLVED_REASON_AUTO, secondRun)
+	if alert.Status != monitor.SUGGEST_ALERT_RESOLVED {
+		t.Errorf("status = %s, want resolved", alert.Status)
+	}
+	if !alert.ResolvedAt.Equal(secondRun) || alert.ResolvedReason != monitor.SUGGEST_ALERT_RESOLVED_REASON_AUTO {
+		t.Errorf("resolution stamp = %s/%s", alert.ResolvedAt, alert.ResolvedReason)
+	}
+	// a resolved alert must not be resolved again
+	if suggestAlertShouldAutoResolve(alert, map[string]bool{}) {
+		t.Errorf("resolved alert must not auto resolve twice")
+	}
+
+	// third run: the problem came back, re-observing reopens the alert
+	applySuggestAlertObservation(alert, meta, 10, secondRun.Add(30*time.Minute))
+	if alert.Status != monitor.SUGGEST_ALERT_READY {
+		t.Errorf("reobserved alert status = %s, want ready", alert.Status)
+	}
+	if !alert.ResolvedAt.IsZero() || alert.ResolvedReason != "" {
+		t.Errorf("reopened alert must clear the resolution stamp, got %s/%s", alert.ResolvedAt, alert.ResolvedReason)
+	}
+
+	// ignored alerts were excluded from the candidates on purpose
+	ignored := &SSuggestSysAlert{Type: "EIP_UNUSED", ResId: "eip-2", IsIgnored: tristate.True}
+	ignored.Status = monitor.SUGGEST_ALERT_READY
+	if suggestAlertShouldAutoResolve(ignored, map[string]bool{}) {
+		t.Errorf("ignored alert must not auto resolve")
+	}
+}
+
+func TestSuggestAlertResolvedExpired(t *testing.T) {
+	now := time.Date(2020, 6, 15, 10, 0, 0, 0, time.UTC)
+	retention := 72 * time.Hour
+	if suggestAlertResolvedExpired(now.Add(-time.Hour), now, retention) {
+		t.Errorf("alert within retention must be kept")
+	}
+	if !suggestAlertResolvedExpired(now.Add(-retention), now, retention) {
+		t.Errorf("alert past retention must expire")
+	}
+	// alerts resolved before the stamp existed carry no timestamp and
+	// are kept rather than deleted on the spot
+	if suggestAlertResolvedExpired(time.Time{}, now, retention) {
+		t.Errorf("alert without a resolution stamp must be kept")
+	}
+}
+
+func TestSuggestAlertRequiredScope(t *testing.T) {
+	// the alert references a resource of proj-1 in dom-1; the same scope
+	// gates both visibility (FilterByOwner) and the perform actions
+	cases := []struct {
+		name         string
+		credProject  string
+		credDomain   string
+		alertProject string
+		alertDomain  string
+		want         rbacutils.TRbacScope
+	}{
+		{"owner project acts at project scope", "proj-1", "dom-1", "proj-1", "dom-1", rbacutils.ScopeProject},
+		{"same-domain admin acts at domain scope", "proj-admin", "dom-1", "proj-1", "dom-1", rbacutils.ScopeDomain},
+		{"foreign domain requires system scope", "proj-x", "dom-2", "proj-1", "dom-1", rbacutils.ScopeSystem},
+		{"sysadmin of another domain requires system scope", "system", "default", "proj-1", "dom-1", rbacutils.ScopeSystem},
+		{"alert without project falls back to domain", "proj-1", "dom-1", "", "dom-1", rbacutils.ScopeDomain},
+		{"alert without ownership requires system scope", "proj-1", "dom-1", "", "", rbacutils.ScopeSystem},
+	}
+	for _, c := range cases {
+		got := suggestAlertRequiredScope(c.credProject, c.credDomain, c.alertProject, c.alertDomain)
+		if got != c.want {
+			t.Errorf("%s: scope = %s, want %s", c.name, got, c.want)
+		}
+	}
+
+	// authorization matrix: a token whose policy allows scope S may act
+	// exactly when the required scope is not higher than S
+	required := suggestAlertRequiredScope("proj-admin", "dom-1", "proj-1", "dom-1")
+	allowMatrix := []struct {
+		allowed rbacutils.TRbacScope
+		want    bool
+	}{
+		{rbacutils.ScopeSystem, true},
+		{rbacutils.ScopeDomain, true},
+		{rbacutils.ScopeProject, false},
+	}
+	for _, c := range allowMatrix {
+		if got := !required.HigherThan(c.allowed); got != c.want {
+			t.Errorf("domain-owned alert with %s-scope policy: allowed = %v, want %v", c.allowed, got, c.want)
+		}
+	}
+}
+
+func TestApplySuggestAlertObservation(t *testing.T) {
+	firstSeen := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := firstSeen.Add(24 * time.Hour)
+
+	// the first observation stamps FirstDetectedAt
+	alert := &SSuggestSysAlert{}
+	meta := jsonutils.Marshal(map[string]string{"name": "eip-1"})
+	applySuggestAlertObservation(alert, meta, 12.5, firstSeen)
+	if !alert.FirstDetectedAt.Equal(firstSeen) {
+		t.Errorf("first observation must stamp FirstDetectedAt, got %s", alert.FirstDetectedAt)
+	}
+	if alert.Amount != 12.5 {
+		t.Errorf("amount = %v, want 12.5", alert.Amount)
+	}
+
+	// re-observing the same problem refreshes metadata and amount but
+	// keeps FirstDetectedAt
+	newMeta := jsonutils.Marshal(map[string]string{"name": "eip-1-renamed"})
+	applySuggestAlertObservation(alert, newMeta, 15, later)
+	if !alert.FirstDetectedAt.Equal(firstSeen) {
+		t.Errorf("re-observation must keep FirstDetectedAt %s, got %s", firstSeen, alert.FirstDetectedAt)
+	}
+	if alert.Amount != 15 {
+		t.Errorf("amount = %v, want refreshed 15", alert.Amount)
+	}
+	if name, _ := alert.ResMeta.GetString("name"); name != "eip-1-renamed" {
+		t.Errorf("res meta not refreshed, name = %s", name)
+	}
+}
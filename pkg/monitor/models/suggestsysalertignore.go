@@ -0,0 +1,134 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/mcclient"
+)
+
+var (
+	SuggestSysAlertIgnoreManager *SSuggestSysAlertIgnoreManager
+)
+
+func init() {
+	SuggestSysAlertIgnoreManager = &SSuggestSysAlertIgnoreManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SSuggestSysAlertIgnore{},
+			"suggestsysalertignore_tbl",
+			"suggestsysalertignore",
+			"suggestsysalertignores",
+		),
+	}
+	SuggestSysAlertIgnoreManager.SetVirtualObject(SuggestSysAlertIgnoreManager)
+}
+
+// SSuggestSysAlertIgnoreManager persists the (rule type, res_id) pairs
+// the operator chose to ignore, so rule runs skip those resources
+type SSuggestSysAlertIgnoreManager struct {
+	db.SStandaloneResourceBaseManager
+}
+
+type SSuggestSysAlertIgnore struct {
+	db.SStandaloneResourceBase
+
+	Type     string    `width:"256" charset:"ascii" list:"user"`
+	ResId    string    `width:"256" charset:"ascii" list:"user"`
+	Reason   string    `width:"256" charset:"utf8" list:"user"`
+	ExpireAt time.Time `list:"user"`
+}
+
+func (man *SSuggestSysAlertIgnoreManager) FetchIgnore(ruleType string, resId string) (*SSuggestSysAlertIgnore, error) {
+	ignore := new(SSuggestSysAlertIgnore)
+	q := man.Query().Equals("type", ruleType).Equals("res_id", resId)
+	if err := q.First(ignore); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ignore.SetModelManager(man, ignore)
+	return ignore, nil
+}
+
+// IsIgnored reports whether the given resource is under an unexpired
+// ignore for the rule type. Expired records are cleaned up lazily.
+func (man *SSuggestSysAlertIgnoreManager) IsIgnored(ctx context.Context, ruleType string, resId string) bool {
+	ignore, err := man.FetchIgnore(ruleType, resId)
+	if err != nil {
+		log.Errorf("fetch ignore (%s, %s): %v", ruleType, resId, err)
+		return false
+	}
+	if ignore == nil {
+		return false
+	}
+	if ignore.IsExpired() {
+		if err := ignore.Delete(ctx, nil); err != nil {
+			log.Errorf("delete expired ignore (%s, %s): %v", ruleType, resId, err)
+		}
+		return false
+	}
+	return true
+}
+
+func (ignore *SSuggestSysAlertIgnore) IsExpired() bool {
+	return !ignore.ExpireAt.IsZero() && ignore.ExpireAt.Before(time.Now())
+}
+
+func (man *SSuggestSysAlertIgnoreManager) CreateIgnore(ctx context.Context, userCred mcclient.TokenCredential, ruleType string, resId string, reason string, expireAt time.Time) error {
+	ignore, err := man.FetchIgnore(ruleType, resId)
+	if err != nil {
+		return err
+	}
+	if ignore != nil {
+		_, err := db.Update(ignore, func() error {
+			ignore.Reason = reason
+			ignore.ExpireAt = expireAt
+			return nil
+		})
+		return err
+	}
+	ignore = &SSuggestSysAlertIgnore{
+		Type:     ruleType,
+		ResId:    resId,
+		Reason:   reason,
+		ExpireAt: expireAt,
+	}
+	ignore.Name = fmt.Sprintf("%s-%s", ruleType, resId)
+	ignore.SetModelManager(man, ignore)
+	if err := man.TableSpec().Insert(ignore); err != nil {
+		return errors.Wrap(err, "insert ignore record")
+	}
+	return nil
+}
+
+func (man *SSuggestSysAlertIgnoreManager) DeleteIgnore(ctx context.Context, userCred mcclient.TokenCredential, ruleType string, resId string) error {
+	ignore, err := man.FetchIgnore(ruleType, resId)
+	if err != nil {
+		return err
+	}
+	if ignore == nil {
+		return nil
+	}
+	return ignore.Delete(ctx, userCred)
+}
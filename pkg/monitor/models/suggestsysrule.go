@@ -0,0 +1,536 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/tristate"
+	"yunion.io/x/pkg/util/wait"
+	"yunion.io/x/sqlchemy"
+
+	"yunion.io/x/onecloud/pkg/apis"
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/monitor/registry"
+	"yunion.io/x/onecloud/pkg/util/cronexpr"
+	"yunion.io/x/onecloud/pkg/util/stringutils2"
+)
+
+var (
+	SuggestSysRuleManager *SSuggestSysRuleManager
+)
+
+func init() {
+	SuggestSysRuleManager = &SSuggestSysRuleManager{
+		SStandaloneResourceBaseManager: db.NewStandaloneResourceBaseManager(
+			SSuggestSysRule{},
+			"suggestsysrule_tbl",
+			"suggestsysrule",
+			"suggestsysrules",
+		),
+	}
+	SuggestSysRuleManager.SetVirtualObject(SuggestSysRuleManager)
+	registry.RegisterService(SuggestSysRuleManager)
+}
+
+type SSuggestSysRuleManager struct {
+	db.SStandaloneResourceBaseManager
+	db.SEnabledResourceBaseManager
+}
+
+type SSuggestSysRule struct {
+	db.SStandaloneResourceBase
+	db.SEnabledResourceBase
+
+	Type     string               `width:"256" charset:"ascii" list:"user" create:"required"`
+	Period   string               `width:"32" charset:"ascii" list:"user" update:"user"`
+	CronExpr string               `width:"64" charset:"ascii" list:"user" update:"user"`
+	TimeFrom time.Time            `list:"user"`
+	Setting  jsonutils.JSONObject `list:"user" update:"user"`
+	ExecTime time.Time            `list:"user"`
+
+	// 最近若干次执行记录, 最新的在最后
+	ExecHistory jsonutils.JSONObject `list:"user"`
+}
+
+// ISuggestSysRuleDriver evaluates one suggest rule type against the
+// platform resources and maintains the corresponding suggest alerts
+type ISuggestSysRuleDriver interface {
+	GetType() string
+	GetResourceType() string
+	ValidateSetting(input *monitor.SSuggestSysAlertSetting) error
+	Run(ctx context.Context, rule *SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting)
+	// DryRun evaluates the rule criteria and reports the alerts a real
+	// run would create, without writing SuggestSysAlert rows
+	DryRun(ctx context.Context, rule *SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.PreviewAlert, error)
+	// Resolve executes the suggested action against the owning service,
+	// e.g. deleting an unused EIP through the compute API
+	Resolve(ctx context.Context, alert *SSuggestSysAlert) error
+}
+
+var suggestSysRuleDrivers = make(map[string]ISuggestSysRuleDriver)
+
+func RegisterSuggestSysRuleDriver(drv ISuggestSysRuleDriver) {
+	suggestSysRuleDrivers[drv.GetType()] = drv
+}
+
+func GetSuggestSysRuleDrivers() map[string]ISuggestSysRuleDriver {
+	return suggestSysRuleDrivers
+}
+
+func (_ *SSuggestSysRuleManager) IsDisabled() bool {
+	return false
+}
+
+func (_ *SSuggestSysRuleManager) Init() error {
+	return nil
+}
+
+func (man *SSuggestSysRuleManager) Run(ctx context.Context) error {
+	go SuggestSysAlertManager.startResourceReconciler(ctx)
+	wait.Forever(func() {
+		man.runEnabledRules(ctx)
+	}, time.Minute)
+	return nil
+}
+
+func (man *SSuggestSysRuleManager) runEnabledRules(ctx context.Context) {
+	rules, err := man.FetchEnabledRules()
+	if err != nil {
+		log.Errorf("fetch enabled suggest rules: %v", err)
+		return
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.shouldExec() {
+			continue
+		}
+		go rule.exec(ctx)
+	}
+}
+
+func (man *SSuggestSysRuleManager) FetchEnabledRules() ([]SSuggestSysRule, error) {
+	rules := make([]SSuggestSysRule, 0)
+	q := man.Query().IsTrue("enabled")
+	if err := db.FetchModelObjects(man, q, &rules); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "db.FetchModelObjects")
+	}
+	return rules, nil
+}
+
+// FetchSuggestSysAlartSettings returns the parsed settings of rules of
+// the given types indexed by rule type. No type fetches all rules. A
+// rule whose stored setting fails to unmarshal makes the whole call
+// fail so misconfigured rules are visible instead of silently defaulted
+func (man *SSuggestSysRuleManager) FetchSuggestSysAlartSettings(ruleTypes ...string) (map[string]*monitor.SSuggestSysAlertSetting, error) {
+	rules := make([]SSuggestSysRule, 0)
+	q := man.Query()
+	switch len(ruleTypes) {
+	case 0:
+	case 1:
+		q = q.Equals("type", ruleTypes[0])
+	default:
+		q = q.In("type", ruleTypes)
+	}
+	if err := db.FetchModelObjects(man, q, &rules); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "db.FetchModelObjects")
+	}
+	return foldSuggestRuleSettings(rules)
+}
+
+// foldSuggestRuleSettings indexes the parsed settings of the given
+// rules by rule type
+func foldSuggestRuleSettings(rules []SSuggestSysRule) (map[string]*monitor.SSuggestSysAlertSetting, error) {
+	settings := make(map[string]*monitor.SSuggestSysAlertSetting, len(rules))
+	for i := range rules {
+		setting, err := rules[i].GetSetting()
+		if err != nil {
+			return nil, err
+		}
+		settings[rules[i].Type] = setting
+	}
+	return settings, nil
+}
+
+func (rule *SSuggestSysRule) GetSetting() (*monitor.SSuggestSysAlertSetting, error) {
+	setting := new(monitor.SSuggestSysAlertSetting)
+	if rule.Setting == nil {
+		return setting, nil
+	}
+	if err := rule.Setting.Unmarshal(setting); err != nil {
+		return nil, errors.Wrapf(err, "rule %s unmarshal setting", rule.GetId())
+	}
+	return setting, nil
+}
+
+func (rule *SSuggestSysRule) GetDriver() ISuggestSysRuleDriver {
+	return suggestSysRuleDrivers[rule.Type]
+}
+
+// suggestRuleExecHistoryMax caps how many execution records a rule
+// keeps, older entries are dropped
+const suggestRuleExecHistoryMax = 10
+
+// appendExecHistory appends one record to a rule execution history,
+// dropping the oldest entries beyond max
+func appendExecHistory(history []monitor.SuggestRuleExecResult, record monitor.SuggestRuleExecResult, max int) []monitor.SuggestRuleExecResult {
+	history = append(history, record)
+	if len(history) > max {
+		history = history[len(history)-max:]
+	}
+	return history
+}
+
+func (rule *SSuggestSysRule) GetExecHistory() []monitor.SuggestRuleExecResult {
+	history := make([]monitor.SuggestRuleExecResult, 0)
+	if rule.ExecHistory != nil {
+		if err := rule.ExecHistory.Unmarshal(&history); err != nil {
+			log.Errorf("rule %s unmarshal exec history: %v", rule.GetName(), err)
+		}
+	}
+	return history
+}
+
+// RecordExecResult appends the outcome of one run to the rule's
+// bounded execution history
+func (rule *SSuggestSysRule) RecordExecResult(success bool, message string) {
+	history := appendExecHistory(rule.GetExecHistory(), monitor.SuggestRuleExecResult{
+		Time:    time.Now(),
+		Success: success,
+		Message: message,
+	}, suggestRuleExecHistoryMax)
+	if _, err := db.Update(rule, func() error {
+		rule.ExecHistory = jsonutils.Marshal(history)
+		return nil
+	}); err != nil {
+		log.Errorf("rule %s record exec result: %v", rule.GetName(), err)
+	}
+}
+
+// suggestRuleNextRun computes the next scheduled run strictly after
+// base for either scheduling mode: the cron expression when set,
+// otherwise the duration period. A zero base makes a period rule due
+// immediately
+func suggestRuleNextRun(period string, cronSpec string, base time.Time) (time.Time, error) {
+	if len(cronSpec) > 0 {
+		expr, err := cronexpr.Parse(cronSpec)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid cron expression %s", cronSpec)
+		}
+		return expr.Next(base), nil
+	}
+	dur, err := time.ParseDuration(period)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid period %s", period)
+	}
+	return base.Add(dur), nil
+}
+
+func (rule *SSuggestSysRule) nextRunAt() (time.Time, error) {
+	base := rule.ExecTime
+	if base.IsZero() && len(rule.CronExpr) > 0 {
+		// a cron rule that never ran anchors at its creation time
+		base = rule.CreatedAt
+	}
+	return suggestRuleNextRun(rule.Period, rule.CronExpr, base)
+}
+
+func (rule *SSuggestSysRule) shouldExec() bool {
+	next, err := rule.nextRunAt()
+	if err != nil {
+		log.Errorf("rule %s: %v", rule.GetName(), err)
+		return false
+	}
+	return !next.After(time.Now())
+}
+
+func (rule *SSuggestSysRule) exec(ctx context.Context) {
+	drv := rule.GetDriver()
+	if drv == nil {
+		log.Errorf("rule %s has no driver for type %s", rule.GetName(), rule.Type)
+		return
+	}
+	setting, err := rule.GetSetting()
+	if err != nil {
+		log.Errorf("rule %s get setting: %v", rule.GetName(), err)
+		return
+	}
+	drv.Run(ctx, rule, setting)
+	if _, err := db.Update(rule, func() error {
+		rule.ExecTime = time.Now()
+		return nil
+	}); err != nil {
+		log.Errorf("rule %s update exec time: %v", rule.GetName(), err)
+	}
+}
+
+func (man *SSuggestSysRuleManager) ValidateCreateData(
+	ctx context.Context, userCred mcclient.TokenCredential,
+	ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject,
+	data monitor.SuggestSysRuleCreateInput) (monitor.SuggestSysRuleCreateInput, error) {
+	drv, ok := suggestSysRuleDrivers[data.Type]
+	if !ok {
+		return data, httperrors.NewInputParameterError("unknown suggest rule type %s", data.Type)
+	}
+	if len(data.Period) > 0 && len(data.CronExpr) > 0 {
+		return data, httperrors.NewInputParameterError("period and cron_expr are mutually exclusive")
+	}
+	if len(data.CronExpr) > 0 {
+		if _, err := cronexpr.Parse(data.CronExpr); err != nil {
+			return data, httperrors.NewInputParameterError("invalid cron_expr %s: %v", data.CronExpr, err)
+		}
+	} else {
+		if data.Period == "" {
+			// default 24 hours
+			data.Period = "24h"
+		}
+		if _, err := time.ParseDuration(data.Period); err != nil {
+			return data, httperrors.NewInputParameterError("invalid period %s", data.Period)
+		}
+	}
+	if err := drv.ValidateSetting(data.Setting); err != nil {
+		return data, errors.Wrap(err, "validate setting")
+	}
+	if data.Setting != nil {
+		if err := validateAlertScope(ctx, data.Setting.Scope); err != nil {
+			return data, err
+		}
+	}
+	cnt, err := man.Query().Equals("type", data.Type).CountWithError()
+	if err != nil {
+		return data, err
+	}
+	if cnt > 0 {
+		return data, httperrors.NewDuplicateResourceError("rule type %s already exists", data.Type)
+	}
+	if data.Name == "" {
+		data.Name = data.Type
+	}
+	return data, nil
+}
+
+func (rule *SSuggestSysRule) ValidateUpdateData(
+	ctx context.Context, userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	data monitor.SuggestSysRuleUpdateInput) (monitor.SuggestSysRuleUpdateInput, error) {
+	if data.Period != nil && data.CronExpr != nil && len(*data.Period) > 0 && len(*data.CronExpr) > 0 {
+		return data, httperrors.NewInputParameterError("period and cron_expr are mutually exclusive")
+	}
+	if data.Period != nil && len(*data.Period) > 0 {
+		if _, err := time.ParseDuration(*data.Period); err != nil {
+			return data, httperrors.NewInputParameterError("invalid period %s", *data.Period)
+		}
+		// switching back to period mode drops the cron schedule
+		if data.CronExpr == nil {
+			empty := ""
+			data.CronExpr = &empty
+		}
+	}
+	if data.CronExpr != nil && len(*data.CronExpr) > 0 {
+		if _, err := cronexpr.Parse(*data.CronExpr); err != nil {
+			return data, httperrors.NewInputParameterError("invalid cron_expr %s: %v", *data.CronExpr, err)
+		}
+		// switching to cron mode drops the interval schedule
+		if data.Period == nil {
+			empty := ""
+			data.Period = &empty
+		}
+	}
+	if data.Setting != nil {
+		if err := rule.GetDriver().ValidateSetting(data.Setting); err != nil {
+			return data, errors.Wrap(err, "validate setting")
+		}
+		if err := validateAlertScope(ctx, data.Setting.Scope); err != nil {
+			return data, err
+		}
+	}
+	return data, nil
+}
+
+// validateAlertScope checks the project and domain ids of a scope
+// restriction against the identity service
+func validateAlertScope(ctx context.Context, scope *monitor.SSuggestAlertScope) error {
+	if scope == nil {
+		return nil
+	}
+	projectIds := make([]string, 0, len(scope.ExcludeProjectIds)+len(scope.IncludeOnlyProjectIds))
+	projectIds = append(projectIds, scope.ExcludeProjectIds...)
+	projectIds = append(projectIds, scope.IncludeOnlyProjectIds...)
+	for _, projId := range projectIds {
+		if _, err := db.TenantCacheManager.FetchTenantById(ctx, projId); err != nil {
+			return httperrors.NewInputParameterError("project %s not found", projId)
+		}
+	}
+	for _, domainId := range scope.ExcludeDomainIds {
+		if _, err := db.TenantCacheManager.FetchDomainById(ctx, domainId); err != nil {
+			return httperrors.NewInputParameterError("domain %s not found", domainId)
+		}
+	}
+	return nil
+}
+
+func (rule *SSuggestSysRule) CustomizeCreate(ctx context.Context, userCred mcclient.TokenCredential, ownerId mcclient.IIdentityProvider, query jsonutils.JSONObject, data jsonutils.JSONObject) error {
+	rule.Enabled = tristate.True
+	return rule.SStandaloneResourceBase.CustomizeCreate(ctx, userCred, ownerId, query, data)
+}
+
+func (man *SSuggestSysRuleManager) ListItemFilter(
+	ctx context.Context,
+	q *sqlchemy.SQuery,
+	userCred mcclient.TokenCredential,
+	input monitor.SuggestSysRuleListInput,
+) (*sqlchemy.SQuery, error) {
+	q, err := man.SStandaloneResourceBaseManager.ListItemFilter(ctx, q, userCred, input.StandaloneResourceListInput)
+	if err != nil {
+		return nil, errors.Wrap(err, "SStandaloneResourceBaseManager.ListItemFilter")
+	}
+	q, err = man.SEnabledResourceBaseManager.ListItemFilter(ctx, q, userCred, input.EnabledResourceBaseListInput)
+	if err != nil {
+		return nil, errors.Wrap(err, "SEnabledResourceBaseManager.ListItemFilter")
+	}
+	if input.Type != "" {
+		q = q.Equals("type", input.Type)
+	}
+	return q, nil
+}
+
+func (man *SSuggestSysRuleManager) FetchCustomizeColumns(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	objs []interface{},
+	fields stringutils2.SSortedStrings,
+	isList bool,
+) []monitor.SuggestSysRuleDetails {
+	rows := make([]monitor.SuggestSysRuleDetails, len(objs))
+	stdRows := man.SStandaloneResourceBaseManager.FetchCustomizeColumns(ctx, userCred, query, objs, fields, isList)
+	for i := range rows {
+		rule := objs[i].(*SSuggestSysRule)
+		setting, _ := rule.GetSetting()
+		rows[i] = monitor.SuggestSysRuleDetails{
+			StandaloneResourceDetails: stdRows[i],
+			Type:                      rule.Type,
+			Period:                    rule.Period,
+			CronExpr:                  rule.CronExpr,
+			Setting:                   setting,
+		}
+		if nextRun, err := rule.nextRunAt(); err == nil {
+			rows[i].NextRunAt = nextRun
+		}
+		rows[i].ExecHistory = rule.GetExecHistory()
+	}
+	return rows
+}
+
+func (rule *SSuggestSysRule) GetExtraDetails(
+	ctx context.Context,
+	userCred mcclient.TokenCredential,
+	query jsonutils.JSONObject,
+	isList bool,
+) (monitor.SuggestSysRuleDetails, error) {
+	return monitor.SuggestSysRuleDetails{}, nil
+}
+
+func (rule *SSuggestSysRule) AllowPerformRun(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) bool {
+	return db.IsAdminAllowPerform(userCred, rule, "run")
+}
+
+// PerformRun triggers the rule immediately. With dry_run=true the
+// driver only evaluates its criteria and reports the candidate alerts,
+// without writing SuggestSysAlert rows or touching the cron state.
+func (rule *SSuggestSysRule) PerformRun(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, data jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	input := monitor.SuggestSysRuleRunInput{}
+	if query != nil {
+		query.Unmarshal(&input)
+	}
+	if data != nil {
+		data.Unmarshal(&input)
+	}
+	drv := rule.GetDriver()
+	if drv == nil {
+		return nil, httperrors.NewInvalidStatusError("rule type %s has no driver", rule.Type)
+	}
+	if !input.DryRun {
+		go rule.exec(context.Background())
+		return nil, nil
+	}
+	setting, err := rule.GetSetting()
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := drv.DryRun(ctx, rule, setting)
+	if err != nil {
+		return nil, errors.Wrap(err, "dry run")
+	}
+	output := summarizePreviewAlerts(candidates, input.Limit)
+	return jsonutils.Marshal(output), nil
+}
+
+// defaultDryRunCandidates caps the candidate list of a dry run when
+// the caller does not specify a limit
+const defaultDryRunCandidates = 10
+
+// summarizePreviewAlerts folds dry-run candidates into per-project
+// counts plus the first limit entries
+func summarizePreviewAlerts(candidates []monitor.PreviewAlert, limit int) monitor.SuggestSysRuleRunOutput {
+	if limit <= 0 {
+		limit = defaultDryRunCandidates
+	}
+	output := monitor.SuggestSysRuleRunOutput{
+		DryRun:        true,
+		Total:         len(candidates),
+		ProjectCounts: make(map[string]int),
+	}
+	for i := range candidates {
+		output.ProjectCounts[candidates[i].ProjectId] += 1
+	}
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	output.Candidates = candidates
+	return output
+}
+
+func (rule *SSuggestSysRule) AllowPerformEnable(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformEnableInput) bool {
+	return db.IsAdminAllowPerform(userCred, rule, "enable")
+}
+
+func (rule *SSuggestSysRule) PerformEnable(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformEnableInput) (jsonutils.JSONObject, error) {
+	err := db.EnabledPerformEnable(rule, ctx, userCred, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "EnabledPerformEnable")
+	}
+	return nil, nil
+}
+
+func (rule *SSuggestSysRule) AllowPerformDisable(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformDisableInput) bool {
+	return db.IsAdminAllowPerform(userCred, rule, "disable")
+}
+
+func (rule *SSuggestSysRule) PerformDisable(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input apis.PerformDisableInput) (jsonutils.JSONObject, error) {
+	err := db.EnabledPerformEnable(rule, ctx, userCred, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "EnabledPerformEnable")
+	}
+	return nil, nil
+}
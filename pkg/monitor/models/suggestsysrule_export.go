@@ -0,0 +1,307 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/tristate"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient"
+	"yunion.io/x/onecloud/pkg/util/cronexpr"
+)
+
+// exportSuggestRuleConfig turns a stored rule into its portable config
+func exportSuggestRuleConfig(rule *SSuggestSysRule) (monitor.SuggestSysRuleConfig, error) {
+	config := monitor.SuggestSysRuleConfig{
+		Type:     rule.Type,
+		Name:     rule.Name,
+		Period:   rule.Period,
+		CronExpr: rule.CronExpr,
+		Enabled:  rule.Enabled.Bool(),
+	}
+	if rule.Setting != nil {
+		setting, err := rule.GetSetting()
+		if err != nil {
+			return config, err
+		}
+		config.Setting = setting
+	}
+	return config, nil
+}
+
+// exportSuggestRuleConfigs folds rules into a config list sorted by
+// type, the canonical order of an export document
+func exportSuggestRuleConfigs(rules []SSuggestSysRule) ([]monitor.SuggestSysRuleConfig, error) {
+	configs := make([]monitor.SuggestSysRuleConfig, 0, len(rules))
+	for i := range rules {
+		config, err := exportSuggestRuleConfig(&rules[i])
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Type < configs[j].Type
+	})
+	return configs, nil
+}
+
+// newSuggestRuleFromConfig builds an unsaved rule from a portable
+// config; the caller is responsible for inserting it
+func newSuggestRuleFromConfig(config monitor.SuggestSysRuleConfig) *SSuggestSysRule {
+	rule := &SSuggestSysRule{
+		Type:     config.Type,
+		Period:   config.Period,
+		CronExpr: config.CronExpr,
+	}
+	rule.Enabled = tristate.NewFromBool(config.Enabled)
+	rule.Name = config.Name
+	if rule.Name == "" {
+		rule.Name = config.Type
+	}
+	if config.Setting != nil {
+		rule.Setting = jsonutils.Marshal(config.Setting)
+	}
+	return rule
+}
+
+// applySuggestRuleConfig copies a portable config onto an existing
+// rule, meant to run inside a db.Update closure
+func applySuggestRuleConfig(rule *SSuggestSysRule, config monitor.SuggestSysRuleConfig) {
+	rule.Period = config.Period
+	rule.CronExpr = config.CronExpr
+	rule.Enabled = tristate.NewFromBool(config.Enabled)
+	if config.Name != "" {
+		rule.Name = config.Name
+	}
+	if config.Setting != nil {
+		rule.Setting = jsonutils.Marshal(config.Setting)
+	}
+}
+
+// validateSuggestRuleConfig checks the schedule of a config and fills
+// in the default period, mirroring the create path
+func validateSuggestRuleConfig(config *monitor.SuggestSysRuleConfig) error {
+	if len(config.Period) > 0 && len(config.CronExpr) > 0 {
+		return httperrors.NewInputParameterError("rule %s: period and cron_expr are mutually exclusive", config.Type)
+	}
+	if len(config.CronExpr) > 0 {
+		if _, err := cronexpr.Parse(config.CronExpr); err != nil {
+			return httperrors.NewInputParameterError("rule %s: invalid cron_expr %s: %v", config.Type, config.CronExpr, err)
+		}
+		return nil
+	}
+	if config.Period == "" {
+		config.Period = "24h"
+	}
+	if _, err := time.ParseDuration(config.Period); err != nil {
+		return httperrors.NewInputParameterError("rule %s: invalid period %s", config.Type, config.Period)
+	}
+	return nil
+}
+
+// sSuggestRuleImportPlan is the set of changes an import document
+// implies against the current rules
+type sSuggestRuleImportPlan struct {
+	Creates []monitor.SuggestSysRuleConfig
+	Updates []monitor.SuggestSysRuleConfig
+	// Deletes are the types removed in replace mode
+	Deletes []string
+	// Skips are the unknown types passed over when skip_unknown is set
+	Skips []string
+}
+
+// planSuggestRuleImport computes the import plan without touching the
+// database. Merge mode updates existing rules by type and creates
+// missing ones; replace mode additionally deletes rules absent from
+// the document. Unknown types fail the whole import unless skipUnknown.
+func planSuggestRuleImport(existingTypes []string, incoming []monitor.SuggestSysRuleConfig, mode string, skipUnknown bool, isKnownType func(string) bool) (*sSuggestRuleImportPlan, error) {
+	switch mode {
+	case "", monitor.SUGGEST_RULE_IMPORT_MODE_MERGE, monitor.SUGGEST_RULE_IMPORT_MODE_REPLACE:
+	default:
+		return nil, httperrors.NewInputParameterError("unknown import mode %s", mode)
+	}
+	existing := make(map[string]bool, len(existingTypes))
+	for _, ruleType := range existingTypes {
+		existing[ruleType] = true
+	}
+	plan := &sSuggestRuleImportPlan{}
+	seen := make(map[string]bool, len(incoming))
+	for _, config := range incoming {
+		if config.Type == "" {
+			return nil, httperrors.NewInputParameterError("rule config without type")
+		}
+		if seen[config.Type] {
+			return nil, httperrors.NewInputParameterError("duplicate rule type %s", config.Type)
+		}
+		seen[config.Type] = true
+		if !isKnownType(config.Type) {
+			if skipUnknown {
+				plan.Skips = append(plan.Skips, config.Type)
+				continue
+			}
+			return nil, httperrors.NewInputParameterError("unknown suggest rule type %s", config.Type)
+		}
+		if existing[config.Type] {
+			plan.Updates = append(plan.Updates, config)
+		} else {
+			plan.Creates = append(plan.Creates, config)
+		}
+	}
+	if mode == monitor.SUGGEST_RULE_IMPORT_MODE_REPLACE {
+		for _, ruleType := range existingTypes {
+			if !seen[ruleType] {
+				plan.Deletes = append(plan.Deletes, ruleType)
+			}
+		}
+		sort.Strings(plan.Deletes)
+	}
+	return plan, nil
+}
+
+func (man *SSuggestSysRuleManager) fetchAllRules() ([]SSuggestSysRule, error) {
+	rules := make([]SSuggestSysRule, 0)
+	q := man.Query().Asc("type")
+	if err := db.FetchModelObjects(man, q, &rules); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "db.FetchModelObjects")
+	}
+	return rules, nil
+}
+
+func (man *SSuggestSysRuleManager) AllowGetPropertyExport(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) bool {
+	return userCred.HasSystemAdminPrivilege()
+}
+
+// GetPropertyExport returns all suggest rule configs as a versioned
+// document that can be imported into another region
+func (man *SSuggestSysRuleManager) GetPropertyExport(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject) (jsonutils.JSONObject, error) {
+	rules, err := man.fetchAllRules()
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch rules")
+	}
+	configs, err := exportSuggestRuleConfigs(rules)
+	if err != nil {
+		return nil, errors.Wrap(err, "export rule configs")
+	}
+	output := monitor.SuggestSysRuleExportOutput{
+		Version: monitor.SUGGEST_RULE_EXPORT_VERSION,
+		Rules:   configs,
+	}
+	return jsonutils.Marshal(output), nil
+}
+
+func (man *SSuggestSysRuleManager) AllowPerformImport(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestSysRuleImportInput) bool {
+	return db.IsAdminAllowClassPerform(userCred, man, "import")
+}
+
+// PerformImport applies an exported rule config document. Every
+// setting is validated against its driver before anything is written,
+// so a bad document changes nothing. The minutely rule scheduler picks
+// up the changed rules on its next pass, no re-registration is needed.
+func (man *SSuggestSysRuleManager) PerformImport(ctx context.Context, userCred mcclient.TokenCredential, query jsonutils.JSONObject, input monitor.SuggestSysRuleImportInput) (jsonutils.JSONObject, error) {
+	if input.Version != "" && input.Version != monitor.SUGGEST_RULE_EXPORT_VERSION {
+		return nil, httperrors.NewInputParameterError("unsupported document version %s", input.Version)
+	}
+	if len(input.Rules) == 0 {
+		return nil, httperrors.NewInputParameterError("rules is empty")
+	}
+	rules, err := man.fetchAllRules()
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch rules")
+	}
+	byType := make(map[string]*SSuggestSysRule, len(rules))
+	existingTypes := make([]string, 0, len(rules))
+	for i := range rules {
+		byType[rules[i].Type] = &rules[i]
+		existingTypes = append(existingTypes, rules[i].Type)
+	}
+	plan, err := planSuggestRuleImport(existingTypes, input.Rules, input.Mode, input.SkipUnknown, func(ruleType string) bool {
+		_, ok := suggestSysRuleDrivers[ruleType]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// validate everything before applying anything
+	validate := func(configs []monitor.SuggestSysRuleConfig) error {
+		for i := range configs {
+			if err := validateSuggestRuleConfig(&configs[i]); err != nil {
+				return err
+			}
+			drv := suggestSysRuleDrivers[configs[i].Type]
+			if err := drv.ValidateSetting(configs[i].Setting); err != nil {
+				return errors.Wrapf(err, "rule %s: validate setting", configs[i].Type)
+			}
+			if configs[i].Setting != nil {
+				if err := validateAlertScope(ctx, configs[i].Setting.Scope); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := validate(plan.Creates); err != nil {
+		return nil, err
+	}
+	if err := validate(plan.Updates); err != nil {
+		return nil, err
+	}
+
+	results := make([]monitor.SuggestSysRuleImportResult, 0, len(input.Rules))
+	for _, config := range plan.Creates {
+		rule := newSuggestRuleFromConfig(config)
+		rule.SetModelManager(man, rule)
+		if err := man.TableSpec().Insert(rule); err != nil {
+			return nil, errors.Wrapf(err, "create rule %s", config.Type)
+		}
+		db.OpsLog.LogEvent(rule, db.ACT_CREATE, nil, userCred)
+		results = append(results, monitor.SuggestSysRuleImportResult{Type: config.Type, Action: monitor.SUGGEST_RULE_IMPORT_ACTION_CREATED})
+	}
+	for _, config := range plan.Updates {
+		rule := byType[config.Type]
+		diff, err := db.Update(rule, func() error {
+			applySuggestRuleConfig(rule, config)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "update rule %s", config.Type)
+		}
+		db.OpsLog.LogEvent(rule, db.ACT_UPDATE, diff, userCred)
+		results = append(results, monitor.SuggestSysRuleImportResult{Type: config.Type, Action: monitor.SUGGEST_RULE_IMPORT_ACTION_UPDATED})
+	}
+	for _, ruleType := range plan.Deletes {
+		rule := byType[ruleType]
+		if err := rule.Delete(ctx, userCred); err != nil {
+			return nil, errors.Wrapf(err, "delete rule %s", ruleType)
+		}
+		db.OpsLog.LogEvent(rule, db.ACT_DELETE, nil, userCred)
+		results = append(results, monitor.SuggestSysRuleImportResult{Type: ruleType, Action: monitor.SUGGEST_RULE_IMPORT_ACTION_DELETED})
+	}
+	for _, ruleType := range plan.Skips {
+		results = append(results, monitor.SuggestSysRuleImportResult{Type: ruleType, Action: monitor.SUGGEST_RULE_IMPORT_ACTION_SKIPPED})
+	}
+	output := monitor.SuggestSysRuleImportOutput{Results: results}
+	return jsonutils.Marshal(output), nil
+}
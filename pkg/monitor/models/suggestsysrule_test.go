@@ -0,0 +1,262 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func TestFoldSuggestRuleSettings(t *testing.T) {
+	rules := []SSuggestSysRule{
+		{
+			Type: "EIP_UNUSED",
+			Setting: jsonutils.Marshal(&monitor.SSuggestSysAlertSetting{
+				EIPUnused: &monitor.EIPUnused{Status: "ready"},
+			}),
+		},
+		{
+			Type: "DISK_UNUSED",
+		},
+	}
+	settings, err := foldSuggestRuleSettings(rules)
+	if err != nil {
+		t.Fatalf("foldSuggestRuleSettings: %v", err)
+	}
+	if len(settings) != 2 {
+		t.Fatalf("expect settings for 2 types, got %d", len(settings))
+	}
+	if setting := settings["EIP_UNUSED"]; setting.EIPUnused == nil || setting.EIPUnused.Status != "ready" {
+		t.Errorf("EIP_UNUSED setting = %v", jsonutils.Marshal(setting))
+	}
+	if setting := settings["DISK_UNUSED"]; setting == nil || setting.EIPUnused != nil {
+		t.Errorf("DISK_UNUSED should have empty default setting, got %v", jsonutils.Marshal(setting))
+	}
+}
+
+func TestFoldSuggestRuleSettingsBroken(t *testing.T) {
+	rules := []SSuggestSysRule{
+		{
+			Type:    "EIP_UNUSED",
+			Setting: jsonutils.NewString("not a setting"),
+		},
+	}
+	if _, err := foldSuggestRuleSettings(rules); err == nil {
+		t.Errorf("expect error for a setting that fails to unmarshal")
+	}
+}
+
+func TestSuggestRuleNextRun(t *testing.T) {
+	execTime := time.Date(2020, 6, 15, 10, 7, 0, 0, time.UTC)
+
+	next, err := suggestRuleNextRun("30m", "", execTime)
+	if err != nil {
+		t.Fatalf("period mode: %v", err)
+	}
+	if want := execTime.Add(30 * time.Minute); !next.Equal(want) {
+		t.Errorf("period mode next run = %s, want %s", next, want)
+	}
+
+	next, err = suggestRuleNextRun("", "0 2 * * *", execTime)
+	if err != nil {
+		t.Fatalf("cron mode: %v", err)
+	}
+	if want := time.Date(2020, 6, 16, 2, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("cron mode next run = %s, want %s", next, want)
+	}
+
+	// the cron expression wins when both are set
+	next, err = suggestRuleNextRun("30m", "0 2 * * *", execTime)
+	if err != nil {
+		t.Fatalf("both modes: %v", err)
+	}
+	if want := time.Date(2020, 6, 16, 2, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("cron precedence next run = %s, want %s", next, want)
+	}
+
+	if _, err := suggestRuleNextRun("not-a-duration", "", execTime); err == nil {
+		t.Errorf("expect error for invalid period")
+	}
+	if _, err := suggestRuleNextRun("", "61 * * * *", execTime); err == nil {
+		t.Errorf("expect error for invalid cron expression")
+	}
+}
+
+func TestSummarizePreviewAlerts(t *testing.T) {
+	candidates := []monitor.PreviewAlert{
+		{ResId: "eip-1", ProjectId: "proj-a"},
+		{ResId: "eip-2", ProjectId: "proj-a"},
+		{ResId: "eip-3", ProjectId: "proj-b"},
+	}
+	output := summarizePreviewAlerts(candidates, 2)
+	if !output.DryRun {
+		t.Errorf("output must be flagged as dry run")
+	}
+	if output.Total != 3 {
+		t.Errorf("total = %d, want 3", output.Total)
+	}
+	if output.ProjectCounts["proj-a"] != 2 || output.ProjectCounts["proj-b"] != 1 {
+		t.Errorf("project counts = %v, want proj-a:2 proj-b:1", output.ProjectCounts)
+	}
+	if len(output.Candidates) != 2 || output.Candidates[0].ResId != "eip-1" {
+		t.Errorf("candidates must be truncated to the first 2, got %v", output.Candidates)
+	}
+
+	// default limit applies when not specified
+	output = summarizePreviewAlerts(candidates, 0)
+	if len(output.Candidates) != 3 {
+		t.Errorf("expect all 3 candidates under the default limit, got %d", len(output.Candidates))
+	}
+
+	// empty candidate list yields an empty report
+	output = summarizePreviewAlerts(nil, 0)
+	if output.Total != 0 || len(output.Candidates) != 0 {
+		t.Errorf("empty dry run must report zero candidates, got %+v", output)
+	}
+}
+
+func TestPlanSuggestRuleImport(t *testing.T) {
+	known := func(ruleType string) bool { return ruleType == "EIP_UNUSED" || ruleType == "DISK_UNUSED" }
+	existing := []string{"EIP_UNUSED", "DISK_UNUSED"}
+
+	// merge updates existing types and creates missing ones
+	plan, err := planSuggestRuleImport(
+		[]string{"EIP_UNUSED"},
+		[]monitor.SuggestSysRuleConfig{{Type: "EIP_UNUSED"}, {Type: "DISK_UNUSED"}},
+		monitor.SUGGEST_RULE_IMPORT_MODE_MERGE, false, known)
+	if err != nil {
+		t.Fatalf("merge plan: %v", err)
+	}
+	if len(plan.Updates) != 1 || plan.Updates[0].Type != "EIP_UNUSED" {
+		t.Errorf("updates = %+v, want EIP_UNUSED", plan.Updates)
+	}
+	if len(plan.Creates) != 1 || plan.Creates[0].Type != "DISK_UNUSED" {
+		t.Errorf("creates = %+v, want DISK_UNUSED", plan.Creates)
+	}
+	if len(plan.Deletes) != 0 {
+		t.Errorf("merge must not delete, got %v", plan.Deletes)
+	}
+
+	// replace deletes rules absent from the document
+	plan, err = planSuggestRuleImport(existing,
+		[]monitor.SuggestSysRuleConfig{{Type: "EIP_UNUSED"}},
+		monitor.SUGGEST_RULE_IMPORT_MODE_REPLACE, false, known)
+	if err != nil {
+		t.Fatalf("replace plan: %v", err)
+	}
+	if len(plan.Deletes) != 1 || plan.Deletes[0] != "DISK_UNUSED" {
+		t.Errorf("deletes = %v, want [DISK_UNUSED]", plan.Deletes)
+	}
+
+	// unknown types fail unless skip_unknown is set
+	if _, err := planSuggestRuleImport(existing,
+		[]monitor.SuggestSysRuleConfig{{Type: "NO_SUCH_RULE"}},
+		monitor.SUGGEST_RULE_IMPORT_MODE_MERGE, false, known); err == nil {
+		t.Errorf("expect unknown type rejected")
+	}
+	plan, err = planSuggestRuleImport(existing,
+		[]monitor.SuggestSysRuleConfig{{Type: "NO_SUCH_RULE"}},
+		monitor.SUGGEST_RULE_IMPORT_MODE_MERGE, true, known)
+	if err != nil {
+		t.Fatalf("skip unknown: %v", err)
+	}
+	if len(plan.Skips) != 1 || plan.Skips[0] != "NO_SUCH_RULE" {
+		t.Errorf("skips = %v, want [NO_SUCH_RULE]", plan.Skips)
+	}
+
+	// duplicate types and bad modes are rejected
+	if _, err := planSuggestRuleImport(existing,
+		[]monitor.SuggestSysRuleConfig{{Type: "EIP_UNUSED"}, {Type: "EIP_UNUSED"}},
+		monitor.SUGGEST_RULE_IMPORT_MODE_MERGE, false, known); err == nil {
+		t.Errorf("expect duplicate type rejected")
+	}
+	if _, err := planSuggestRuleImport(existing, nil, "overwrite", false, known); err == nil {
+		t.Errorf("expect unknown mode rejected")
+	}
+}
+
+func TestSuggestRuleConfigRoundTrip(t *testing.T) {
+	configs := []monitor.SuggestSysRuleConfig{
+		{
+			Type:    "EIP_UNUSED",
+			Name:    "EIP_UNUSED",
+			Period:  "24h",
+			Enabled: true,
+			Setting: &monitor.SSuggestSysAlertSetting{
+				EIPUnused: &monitor.EIPUnused{Status: "ready"},
+			},
+		},
+		{
+			Type:     "DISK_UNUSED",
+			Name:     "unused disks",
+			CronExpr: "0 2 * * *",
+			Enabled:  false,
+			Setting:  &monitor.SSuggestSysAlertSetting{},
+		},
+	}
+	rules := make([]SSuggestSysRule, 0, len(configs))
+	for _, config := range configs {
+		rules = append(rules, *newSuggestRuleFromConfig(config))
+	}
+	exported, err := exportSuggestRuleConfigs(rules)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	// export sorts by type; sort the source document the same way
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Type < configs[j].Type })
+	if !reflect.DeepEqual(exported, configs) {
+		t.Errorf("round trip mismatch:\n got %s\nwant %s", jsonutils.Marshal(exported), jsonutils.Marshal(configs))
+	}
+
+	// a second import of the exported document plans pure updates
+	types := make([]string, 0, len(exported))
+	for _, config := range exported {
+		types = append(types, config.Type)
+	}
+	plan, err := planSuggestRuleImport(types, exported, "", false, func(string) bool { return true })
+	if err != nil {
+		t.Fatalf("re-import plan: %v", err)
+	}
+	if len(plan.Creates) != 0 || len(plan.Deletes) != 0 || len(plan.Updates) != len(exported) {
+		t.Errorf("re-import plan = %+v, want updates only", plan)
+	}
+}
+
+func TestAppendExecHistory(t *testing.T) {
+	history := make([]monitor.SuggestRuleExecResult, 0)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < suggestRuleExecHistoryMax+3; i++ {
+		history = appendExecHistory(history, monitor.SuggestRuleExecResult{
+			Time:    base.Add(time.Duration(i) * time.Hour),
+			Success: i%2 == 0,
+		}, suggestRuleExecHistoryMax)
+	}
+	if len(history) != suggestRuleExecHistoryMax {
+		t.Fatalf("history length %d, want %d", len(history), suggestRuleExecHistoryMax)
+	}
+	// the oldest entries are dropped, the newest kept at the end
+	if !history[0].Time.Equal(base.Add(3 * time.Hour)) {
+		t.Errorf("oldest kept entry at %v, want %v", history[0].Time, base.Add(3*time.Hour))
+	}
+	if !history[len(history)-1].Time.Equal(base.Add(time.Duration(suggestRuleExecHistoryMax+2) * time.Hour)) {
+		t.Errorf("newest entry at %v", history[len(history)-1].Time)
+	}
+}
@@ -27,6 +27,36 @@ type AlerterOptions struct {
 	AlertingMaxAttempts                int   `help:"alerting engine max attempt" default:"3"`
 	AlertingEvaluationTimeoutSeconds   int64 `help:"alerting evaluation timeout" default:"5"`
 	AlertingNotificationTimeoutSeconds int64 `help:"alerting notification timeout" default:"30"`
+
+	AdhocQueryMaxPoints     int `help:"max points an ad-hoc datasource query may return" default:"10000"`
+	AdhocQueryRatePerMinute int `help:"ad-hoc datasource queries allowed per user per minute" default:"30"`
+
+	AlertTestRunRatePerMinute int `help:"alert test-run fires allowed per rule per minute" default:"6"`
+
+	QueryTimeoutSeconds         int `help:"timeout of ad-hoc and measurement discovery queries" default:"30"`
+	TagValueQueryTimeoutSeconds int `help:"timeout of tag value discovery queries" default:"10"`
+	MetaQueryConcurrency        int `help:"concurrent per-measurement count queries of the measurement listing" default:"8"`
+
+	DatasourceProbeIntervalSeconds  int  `help:"interval of the periodic datasource write probe" default:"300"`
+	DatasourceProbeHistory          int  `help:"number of recent probe results kept per datasource" default:"10"`
+	DatasourceProbeFailureThreshold int  `help:"consecutive probe failures before an event is raised" default:"3"`
+	DisableDatasourceWriteProbe     bool `help:"disable the periodic write probe, e.g. for read-only datasource configurations" default:"false"`
+
+	MeasurementCardinalityWarnThreshold int `help:"series or per-tag cardinality above which the measurement API flags a warning" default:"100000"`
+
+	SchemaCatalogConcurrency     int `help:"concurrent metadata queries when assembling the measurement schema catalog" default:"4"`
+	SchemaCatalogMaxMeasurements int `help:"max measurements returned in one schema catalog page" default:"500"`
+
+	MetricStreamMaxPerUser         int `help:"max concurrent metric tail streams per user" default:"5"`
+	MetricStreamIntervalSeconds    int `help:"default interval of the metric tail incremental queries" default:"5"`
+	MetricStreamHeartbeatSeconds   int `help:"heartbeat interval of quiet metric tail streams" default:"30"`
+	MetricStreamIdleTimeoutSeconds int `help:"close a metric tail stream after this long without new datapoints, 0 disables" default:"600"`
+
+	SuggestAlertReconcileIntervalSeconds int `help:"interval of the suggest alert resource reconciler" default:"1800"`
+	SuggestAlertStaleGraceHours          int `help:"hours a stale suggest alert is kept before auto deletion" default:"24"`
+	SuggestAlertResolvedRetentionHours   int `help:"hours a resolved suggest alert stays visible before auto deletion" default:"72"`
+
+	SuggestStorageGbMonthlyPrice float64 `help:"per GB monthly storage price used to estimate savings of reclaimable snapshots and images, 0 disables the estimate" default:"0"`
 }
 
 var (
@@ -18,12 +18,15 @@ import (
 	"yunion.io/x/onecloud/pkg/appsrv"
 	"yunion.io/x/onecloud/pkg/appsrv/dispatcher"
 	"yunion.io/x/onecloud/pkg/cloudcommon/db"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
 	"yunion.io/x/onecloud/pkg/monitor/models"
 )
 
 func InitHandlers(app *appsrv.Application) {
 	db.InitAllManagers()
 
+	app.AddHandler("GET", "/metric-streams", auth.Authenticate(models.MetricStreamHandler))
+
 	db.RegisterModelManager(db.TenantCacheManager)
 	db.RegisterModelManager(db.UserCacheManager)
 	db.RegistUserCredCacheUpdater()
@@ -31,10 +34,13 @@ func InitHandlers(app *appsrv.Application) {
 		db.OpsLog,
 		db.Metadata,
 		models.DataSourceManager,
+		models.DownsamplingRuleManager,
 		models.AlertManager,
 		models.NodeAlertManager,
 		models.MeterAlertManager,
 		models.NotificationManager,
+		models.SuggestSysRuleManager,
+		models.SuggestSysAlertManager,
 	} {
 		db.RegisterModelManager(manager)
 		handler := db.NewModelHandler(manager)
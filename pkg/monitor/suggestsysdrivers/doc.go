@@ -0,0 +1 @@
+package suggestsysdrivers // import "yunion.io/x/onecloud/pkg/monitor/suggestsysdrivers"
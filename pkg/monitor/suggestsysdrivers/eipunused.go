@@ -0,0 +1,168 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+func init() {
+	models.RegisterSuggestSysRuleDriver(&EIPUnused{})
+}
+
+// EIPUnused suggests releasing elastic IPs that are not associated
+// with any instance
+type EIPUnused struct{}
+
+func (drv *EIPUnused) GetType() string {
+	return monitor.SUGGEST_RULE_TYPE_EIP_UNUSED
+}
+
+func (drv *EIPUnused) GetResourceType() string {
+	return "eip"
+}
+
+func (drv *EIPUnused) ValidateSetting(input *monitor.SSuggestSysAlertSetting) error {
+	if input == nil || input.EIPUnused == nil {
+		return httperrors.NewInputParameterError("eip_unused setting is required")
+	}
+	return nil
+}
+
+// Resolve deletes the unused EIP through the compute API
+func (drv *EIPUnused) Resolve(ctx context.Context, alert *models.SSuggestSysAlert) error {
+	s := auth.GetAdminSession(ctx, options.Options.Region, "")
+	_, err := modules.Elasticips.Delete(s, alert.ResId, nil)
+	return err
+}
+
+func (drv *EIPUnused) Run(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) {
+	if err := drv.doSuggestSysRule(ctx, rule, setting); err != nil {
+		log.Errorf("rule %s run: %v", rule.GetName(), err)
+	}
+}
+
+func (drv *EIPUnused) doSuggestSysRule(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) error {
+	eips, err := drv.collectUnusedEips(ctx, setting)
+	if err != nil {
+		return err
+	}
+	reported := make(map[string]bool, len(eips))
+	for _, eip := range eips {
+		id, _ := eip.GetString("id")
+		if err := drv.createSuggestAlert(ctx, eip, id); err != nil {
+			log.Errorf("create suggest alert for eip %s: %v", id, err)
+			continue
+		}
+		reported[id] = true
+	}
+	// alerts whose eip got attached or deleted since the last run are
+	// no longer candidates and resolve automatically
+	if _, err := models.SuggestSysAlertManager.ResolveAbsentAlerts(ctx, drv.GetType(), reported); err != nil {
+		return errors.Wrap(err, "resolve absent alerts")
+	}
+	return nil
+}
+
+// DryRun reports the unused eips a real run would raise alerts for,
+// without persisting anything
+func (drv *EIPUnused) DryRun(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.PreviewAlert, error) {
+	eips, err := drv.collectUnusedEips(ctx, setting)
+	if err != nil {
+		return nil, err
+	}
+	return previewAlerts(eips, "release unused eip", monitor.DRIVER_ACTION), nil
+}
+
+// collectUnusedEips applies the status, scope and ignore filters,
+// yielding the final candidate list shared by Run and DryRun
+func (drv *EIPUnused) collectUnusedEips(ctx context.Context, setting *monitor.SSuggestSysAlertSetting) ([]jsonutils.JSONObject, error) {
+	eips, err := drv.fetchUnusedEips(ctx, setting)
+	if err != nil {
+		return nil, err
+	}
+	if setting != nil {
+		eips = filterByScope(eips, setting.Scope)
+	}
+	filtered := make([]jsonutils.JSONObject, 0, len(eips))
+	for _, eip := range eips {
+		id, err := eip.GetString("id")
+		if err != nil {
+			continue
+		}
+		if models.SuggestSysAlertIgnoreManager.IsIgnored(ctx, drv.GetType(), id) {
+			continue
+		}
+		filtered = append(filtered, eip)
+	}
+	return filtered, nil
+}
+
+func (drv *EIPUnused) fetchUnusedEips(ctx context.Context, setting *monitor.SSuggestSysAlertSetting) ([]jsonutils.JSONObject, error) {
+	params := jsonutils.NewDict()
+	if setting != nil && setting.EIPUnused != nil && setting.EIPUnused.Status != "" {
+		params.Add(jsonutils.NewString(setting.EIPUnused.Status), "status")
+	}
+	unused := make([]jsonutils.JSONObject, 0)
+	err := ListAllResources(ctx, &modules.Elasticips, params, 0, func(eip jsonutils.JSONObject) error {
+		associateId, _ := eip.GetString("associate_id")
+		if associateId == "" {
+			unused = append(unused, eip)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unused, nil
+}
+
+// estimateEipMonthlyAmount estimates the monthly cost of an eip from
+// the pricing info synced with the resource, zero when the platform
+// carries no price for it
+func estimateEipMonthlyAmount(eip jsonutils.JSONObject) float64 {
+	if monthly, err := eip.Float("monthly_price"); err == nil {
+		return monthly
+	}
+	if hourly, err := eip.Float("hourly_price"); err == nil {
+		return hourly * 24 * 30
+	}
+	return 0
+}
+
+func (drv *EIPUnused) createSuggestAlert(ctx context.Context, eip jsonutils.JSONObject, resId string) error {
+	alert := &models.SSuggestSysAlert{
+		Type:    drv.GetType(),
+		ResId:   resId,
+		Suggest: "release unused eip",
+		Action:  monitor.DRIVER_ACTION,
+		ResMeta: eip,
+		Amount:  estimateEipMonthlyAmount(eip),
+	}
+	alert.Name, _ = eip.GetString("name")
+	alert.Status = monitor.SUGGEST_ALERT_READY
+	return models.SuggestSysAlertManager.ObserveAlert(ctx, alert)
+}
@@ -0,0 +1,193 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+// imageUnusedDefaultDays applies when the rule setting leaves the
+// threshold empty
+const imageUnusedDefaultDays = 90
+
+func init() {
+	models.RegisterSuggestSysRuleDriver(&ImageUnused{})
+}
+
+// ImageUnused suggests deleting images no disk references and nobody
+// has used for the configured number of days
+type ImageUnused struct{}
+
+func (drv *ImageUnused) GetType() string {
+	return monitor.SUGGEST_RULE_TYPE_IMAGE_UNUSED
+}
+
+func (drv *ImageUnused) GetResourceType() string {
+	return "image"
+}
+
+func (drv *ImageUnused) ValidateSetting(input *monitor.SSuggestSysAlertSetting) error {
+	if input == nil || input.ImageUnused == nil {
+		return httperrors.NewInputParameterError("image_unused setting is required")
+	}
+	if input.ImageUnused.UnusedDays < 0 {
+		return httperrors.NewInputParameterError("unused_days must not be negative")
+	}
+	return nil
+}
+
+// Resolve deletes the unused image through the glance API
+func (drv *ImageUnused) Resolve(ctx context.Context, alert *models.SSuggestSysAlert) error {
+	s := auth.GetAdminSession(ctx, options.Options.Region, "")
+	_, err := modules.Images.Delete(s, alert.ResId, nil)
+	return err
+}
+
+func (drv *ImageUnused) Run(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) {
+	if err := drv.doSuggestSysRule(ctx, rule, setting); err != nil {
+		log.Errorf("rule %s run: %v", rule.GetName(), err)
+	}
+}
+
+func (drv *ImageUnused) doSuggestSysRule(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) error {
+	images, err := drv.collectUnusedImages(ctx, setting)
+	if err != nil {
+		return err
+	}
+	reported := make(map[string]bool, len(images))
+	for _, image := range images {
+		id, _ := image.GetString("id")
+		if err := drv.createSuggestAlert(ctx, image, id, imageUnusedThresholdDays(setting)); err != nil {
+			log.Errorf("create suggest alert for image %s: %v", id, err)
+			continue
+		}
+		reported[id] = true
+	}
+	if _, err := models.SuggestSysAlertManager.ResolveAbsentAlerts(ctx, drv.GetType(), reported); err != nil {
+		return errors.Wrap(err, "resolve absent alerts")
+	}
+	return nil
+}
+
+// DryRun reports the images a real run would raise alerts for, without
+// persisting anything
+func (drv *ImageUnused) DryRun(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.PreviewAlert, error) {
+	images, err := drv.collectUnusedImages(ctx, setting)
+	if err != nil {
+		return nil, err
+	}
+	suggest := fmt.Sprintf("delete image unused for %d days", imageUnusedThresholdDays(setting))
+	return previewAlerts(images, suggest, monitor.DRIVER_ACTION), nil
+}
+
+func imageUnusedThresholdDays(setting *monitor.SSuggestSysAlertSetting) int {
+	if setting != nil && setting.ImageUnused != nil && setting.ImageUnused.UnusedDays > 0 {
+		return setting.ImageUnused.UnusedDays
+	}
+	return imageUnusedDefaultDays
+}
+
+// imageUnusedCandidate decides whether an image is reclaimable: no
+// disk references it and it has not been touched within unusedDays
+func imageUnusedCandidate(referenced bool, updatedAt time.Time, unusedDays int, now time.Time) bool {
+	if referenced {
+		return false
+	}
+	if updatedAt.IsZero() {
+		return false
+	}
+	return now.Sub(updatedAt) >= time.Duration(unusedDays)*24*time.Hour
+}
+
+// findUnusedImages applies the unused criteria to already listed
+// images, referencedIds being the image ids disks were created from
+func findUnusedImages(images []jsonutils.JSONObject, referencedIds map[string]bool, unusedDays int, now time.Time) []jsonutils.JSONObject {
+	unused := make([]jsonutils.JSONObject, 0)
+	for _, image := range images {
+		id, _ := image.GetString("id")
+		updatedAt, _ := image.GetTime("updated_at")
+		if imageUnusedCandidate(referencedIds[id], updatedAt, unusedDays, now) {
+			unused = append(unused, image)
+		}
+	}
+	return unused
+}
+
+func (drv *ImageUnused) collectUnusedImages(ctx context.Context, setting *monitor.SSuggestSysAlertSetting) ([]jsonutils.JSONObject, error) {
+	// disks record the image they were created from in template_id
+	referencedIds := make(map[string]bool)
+	err := ListAllResources(ctx, &modules.Disks, nil, 0, func(disk jsonutils.JSONObject) error {
+		if templateId, _ := disk.GetString("template_id"); len(templateId) > 0 {
+			referencedIds[templateId] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list disks")
+	}
+	images := make([]jsonutils.JSONObject, 0)
+	err = ListAllResources(ctx, &modules.Images, nil, 0, func(image jsonutils.JSONObject) error {
+		images = append(images, image)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list images")
+	}
+	if setting != nil {
+		images = filterByScope(images, setting.Scope)
+	}
+	unused := findUnusedImages(images, referencedIds, imageUnusedThresholdDays(setting), time.Now())
+	filtered := make([]jsonutils.JSONObject, 0, len(unused))
+	for _, image := range unused {
+		id, err := image.GetString("id")
+		if err != nil {
+			continue
+		}
+		if models.SuggestSysAlertIgnoreManager.IsIgnored(ctx, drv.GetType(), id) {
+			continue
+		}
+		filtered = append(filtered, image)
+	}
+	return filtered, nil
+}
+
+func (drv *ImageUnused) createSuggestAlert(ctx context.Context, image jsonutils.JSONObject, resId string, unusedDays int) error {
+	// image sizes come in bytes
+	sizeBytes, _ := image.Int("size")
+	alert := &models.SSuggestSysAlert{
+		Type:    drv.GetType(),
+		ResId:   resId,
+		Suggest: fmt.Sprintf("delete image unused for %d days", unusedDays),
+		Action:  monitor.DRIVER_ACTION,
+		ResMeta: image,
+		Amount:  estimateStorageMonthlyAmount(float64(sizeBytes)/1024/1024/1024, options.Options.SuggestStorageGbMonthlyPrice),
+	}
+	alert.Name, _ = image.GetString("name")
+	alert.Status = monitor.SUGGEST_ALERT_READY
+	return models.SuggestSysAlertManager.ObserveAlert(ctx, alert)
+}
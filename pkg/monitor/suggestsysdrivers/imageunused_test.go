@@ -0,0 +1,84 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func imageObj(id string, updatedAt time.Time) jsonutils.JSONObject {
+	obj := jsonutils.NewDict()
+	obj.Set("id", jsonutils.NewString(id))
+	obj.Set("name", jsonutils.NewString(id))
+	obj.Set("updated_at", jsonutils.NewTimeString(updatedAt))
+	return obj
+}
+
+func TestFindUnusedImages(t *testing.T) {
+	now := time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC)
+	stale := now.Add(-120 * 24 * time.Hour)
+	images := []jsonutils.JSONObject{
+		imageObj("img-unused", stale),
+		imageObj("img-referenced", stale),
+		imageObj("img-fresh", now.Add(-24*time.Hour)),
+	}
+	referenced := map[string]bool{"img-referenced": true}
+
+	unused := findUnusedImages(images, referenced, 90, now)
+	if len(unused) != 1 {
+		t.Fatalf("unused = %d images, want 1", len(unused))
+	}
+	if id, _ := unused[0].GetString("id"); id != "img-unused" {
+		t.Errorf("unused image = %s, want img-unused", id)
+	}
+
+	// an image without a timestamp is never a candidate
+	images = append(images, jsonutils.NewDict())
+	if got := findUnusedImages(images, referenced, 90, now); len(got) != 1 {
+		t.Errorf("image without updated_at must be kept, got %d candidates", len(got))
+	}
+}
+
+func TestImageUnusedThresholdDays(t *testing.T) {
+	if got := imageUnusedThresholdDays(nil); got != imageUnusedDefaultDays {
+		t.Errorf("nil setting threshold = %d, want default %d", got, imageUnusedDefaultDays)
+	}
+	setting := &monitor.SSuggestSysAlertSetting{ImageUnused: &monitor.ImageUnused{UnusedDays: 30}}
+	if got := imageUnusedThresholdDays(setting); got != 30 {
+		t.Errorf("threshold = %d, want 30", got)
+	}
+}
+
+func TestImageUnusedValidateSetting(t *testing.T) {
+	drv := &ImageUnused{}
+	if err := drv.ValidateSetting(nil); err == nil {
+		t.Errorf("expect error for missing setting")
+	}
+	if err := drv.ValidateSetting(&monitor.SSuggestSysAlertSetting{
+		ImageUnused: &monitor.ImageUnused{UnusedDays: -3},
+	}); err == nil {
+		t.Errorf("expect error for negative unused_days")
+	}
+	if err := drv.ValidateSetting(&monitor.SSuggestSysAlertSetting{
+		ImageUnused: &monitor.ImageUnused{},
+	}); err != nil {
+		t.Errorf("empty threshold falls back to the default, got %v", err)
+	}
+}
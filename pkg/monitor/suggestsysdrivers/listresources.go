@@ -0,0 +1,146 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modulebase"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+const (
+	// defaultResourceBatchSize is the page size used when the caller
+	// does not pass one
+	defaultResourceBatchSize = 512
+	// listPageRetries is how many times a failing page is retried
+	// before the enumeration is aborted
+	listPageRetries = 3
+)
+
+// listPageRetryInterval is the pause between retries of a failing
+// page; a variable so tests do not have to wait for real backoff
+var listPageRetryInterval = 2 * time.Second
+
+// resourcePageLister fetches one page of a resource collection
+type resourcePageLister func(params jsonutils.JSONObject) (*modulebase.ListResult, error)
+
+// ListAllResources enumerates a region API collection page by page
+// with the admin session, streaming every object to fn so drivers do
+// not hold the whole collection in memory. scope=system and
+// details=false are applied unless params overrides them, and failing
+// pages are retried before the enumeration is aborted. A batchSize of
+// 0 uses the default page size.
+func ListAllResources(ctx context.Context, manager modulebase.Manager, params jsonutils.JSONObject, batchSize int, fn func(obj jsonutils.JSONObject) error) error {
+	s := auth.GetAdminSession(ctx, options.Options.Region, "")
+	return listResourcePages(ctx, func(page jsonutils.JSONObject) (*modulebase.ListResult, error) {
+		return manager.List(s, page)
+	}, params, batchSize, fn)
+}
+
+// listResourcePages drives lister by limit/offset, advancing the
+// offset by the number of entries actually received so a shrinking
+// collection does not make entries be skipped. An error from fn stops
+// the enumeration immediately.
+func listResourcePages(ctx context.Context, lister resourcePageLister, params jsonutils.JSONObject, batchSize int, fn func(obj jsonutils.JSONObject) error) error {
+	if batchSize <= 0 {
+		batchSize = defaultResourceBatchSize
+	}
+	base := jsonutils.NewDict()
+	if params != nil {
+		base.Update(params)
+	}
+	if !base.Contains("scope") {
+		base.Set("scope", jsonutils.NewString("system"))
+	}
+	if !base.Contains("details") {
+		base.Set("details", jsonutils.JSONFalse)
+	}
+	offset := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		page := jsonutils.NewDict()
+		page.Update(base)
+		page.Set("limit", jsonutils.NewInt(int64(batchSize)))
+		page.Set("offset", jsonutils.NewInt(int64(offset)))
+		result, err := listPageWithRetry(ctx, lister, page)
+		if err != nil {
+			return errors.Wrapf(err, "list page at offset %d", offset)
+		}
+		if len(result.Data) == 0 {
+			return nil
+		}
+		for _, obj := range result.Data {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		offset += len(result.Data)
+		if result.Total > 0 && offset >= result.Total {
+			return nil
+		}
+	}
+}
+
+// listPageWithRetry retries a failing page a few times before giving
+// up, so one transient API error does not abort a long enumeration
+func listPageWithRetry(ctx context.Context, lister resourcePageLister, page jsonutils.JSONObject) (*modulebase.ListResult, error) {
+	var lastErr error
+	for attempt := 0; attempt < listPageRetries; attempt++ {
+		if attempt > 0 {
+			log.Warningf("retry listing page after error: %v", lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(listPageRetryInterval):
+			}
+		}
+		result, err := lister(page)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// previewAlerts converts candidate resources into dry-run report
+// entries. It must stay free of database writes: dry run promises to
+// leave SuggestSysAlert rows untouched.
+func previewAlerts(resources []jsonutils.JSONObject, suggest string, action string) []monitor.PreviewAlert {
+	alerts := make([]monitor.PreviewAlert, 0, len(resources))
+	for _, res := range resources {
+		alert := monitor.PreviewAlert{
+			Suggest: suggest,
+			Action:  action,
+		}
+		alert.ResId, _ = res.GetString("id")
+		alert.Name, _ = res.GetString("name")
+		alert.ProjectId, _ = res.GetString("tenant_id")
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/mcclient/modulebase"
+)
+
+// fakePagedLister serves a fixed collection page by page, optionally
+// failing a number of times at a given offset
+func fakePagedLister(total int, failAtOffset int, failTimes *int) resourcePageLister {
+	return func(params jsonutils.JSONObject) (*modulebase.ListResult, error) {
+		offset, _ := params.Int("offset")
+		limit, _ := params.Int("limit")
+		if failTimes != nil && *failTimes > 0 && int(offset) == failAtOffset {
+			*failTimes--
+			return nil, errors.Error("transient list error")
+		}
+		result := &modulebase.ListResult{Total: total, Data: []jsonutils.JSONObject{}}
+		for i := int(offset); i < total && i < int(offset+limit); i++ {
+			obj := jsonutils.NewDict()
+			obj.Set("id", jsonutils.NewString(fmt.Sprintf("res-%d", i)))
+			result.Data = append(result.Data, obj)
+		}
+		return result, nil
+	}
+}
+
+func TestListResourcePagesMultiplePages(t *testing.T) {
+	oldInterval := listPageRetryInterval
+	listPageRetryInterval = 0
+	defer func() { listPageRetryInterval = oldInterval }()
+
+	ids := make([]string, 0)
+	err := listResourcePages(context.Background(), fakePagedLister(5, -1, nil), nil, 2, func(obj jsonutils.JSONObject) error {
+		id, _ := obj.GetString("id")
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("listResourcePages() error: %v", err)
+	}
+	if len(ids) != 5 {
+		t.Errorf("listResourcePages() streamed %d objects, want 5", len(ids))
+	}
+	if ids[0] != "res-0" || ids[4] != "res-4" {
+		t.Errorf("listResourcePages() unexpected order: %v", ids)
+	}
+}
+
+func TestListResourcePagesRetriesTransientError(t *testing.T) {
+	oldInterval := listPageRetryInterval
+	listPageRetryInterval = 0
+	defer func() { listPageRetryInterval = oldInterval }()
+
+	failTimes := 2
+	count := 0
+	err := listResourcePages(context.Background(), fakePagedLister(5, 2, &failTimes), nil, 2, func(obj jsonutils.JSONObject) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("listResourcePages() error: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("listResourcePages() streamed %d objects, want 5", count)
+	}
+}
+
+func TestListResourcePagesPersistentError(t *testing.T) {
+	oldInterval := listPageRetryInterval
+	listPageRetryInterval = 0
+	defer func() { listPageRetryInterval = oldInterval }()
+
+	failTimes := listPageRetries + 1
+	count := 0
+	err := listResourcePages(context.Background(), fakePagedLister(5, 2, &failTimes), nil, 2, func(obj jsonutils.JSONObject) error {
+		count++
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("listResourcePages() expected error for persistently failing page")
+	}
+	if count != 2 {
+		t.Errorf("listResourcePages() streamed %d objects before the error, want 2", count)
+	}
+}
+
+func TestListResourcePagesCallbackError(t *testing.T) {
+	stop := errors.Error("stop here")
+	count := 0
+	err := listResourcePages(context.Background(), fakePagedLister(5, -1, nil), nil, 2, func(obj jsonutils.JSONObject) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if errors.Cause(err) != stop {
+		t.Errorf("listResourcePages() error = %v, want callback error", err)
+	}
+	if count != 3 {
+		t.Errorf("listResourcePages() streamed %d objects, want 3", count)
+	}
+}
+
+func TestListResourcePagesDefaultParams(t *testing.T) {
+	var seenScope, seenDetails string
+	lister := func(params jsonutils.JSONObject) (*modulebase.ListResult, error) {
+		seenScope, _ = params.GetString("scope")
+		seenDetails, _ = params.GetString("details")
+		return &modulebase.ListResult{}, nil
+	}
+	if err := listResourcePages(context.Background(), lister, nil, 0, func(obj jsonutils.JSONObject) error { return nil }); err != nil {
+		t.Fatalf("listResourcePages() error: %v", err)
+	}
+	if seenScope != "system" {
+		t.Errorf("listResourcePages() scope = %q, want system", seenScope)
+	}
+	if seenDetails != "false" {
+		t.Errorf("listResourcePages() details = %q, want false", seenDetails)
+	}
+}
+
+func TestPreviewAlerts(t *testing.T) {
+	resources := []jsonutils.JSONObject{
+		jsonutils.Marshal(map[string]string{"id": "eip-1", "name": "eip-one", "tenant_id": "proj-a"}),
+		jsonutils.Marshal(map[string]string{"id": "eip-2", "name": "eip-two", "tenant_id": "proj-b"}),
+	}
+	alerts := previewAlerts(resources, "release unused eip", monitor.DRIVER_ACTION)
+	if len(alerts) != 2 {
+		t.Fatalf("expect 2 preview alerts, got %d", len(alerts))
+	}
+	if alerts[0].ResId != "eip-1" || alerts[0].Name != "eip-one" || alerts[0].ProjectId != "proj-a" {
+		t.Errorf("alerts[0] = %+v, want eip-1/eip-one/proj-a", alerts[0])
+	}
+	if alerts[1].Suggest != "release unused eip" || alerts[1].Action != monitor.DRIVER_ACTION {
+		t.Errorf("alerts[1] = %+v, want suggest and action populated", alerts[1])
+	}
+	if len(previewAlerts(nil, "s", "a")) != 0 {
+		t.Errorf("expect no alerts for no resources")
+	}
+}
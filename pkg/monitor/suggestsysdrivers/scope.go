@@ -0,0 +1,39 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+// filterByScope drops resources whose project or domain is excluded by
+// the rule scope, so excluded projects never get alerts. Resources are
+// compute API list results carrying tenant_id/domain_id fields.
+func filterByScope(objs []jsonutils.JSONObject, scope *monitor.SSuggestAlertScope) []jsonutils.JSONObject {
+	if scope == nil {
+		return objs
+	}
+	filtered := make([]jsonutils.JSONObject, 0, len(objs))
+	for _, obj := range objs {
+		projectId, _ := obj.GetString("tenant_id")
+		domainId, _ := obj.GetString("domain_id")
+		if scope.ShouldInclude(projectId, domainId) {
+			filtered = append(filtered, obj)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"testing"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func fakeResource(id string, projectId string, domainId string) jsonutils.JSONObject {
+	obj := jsonutils.NewDict()
+	obj.Add(jsonutils.NewString(id), "id")
+	obj.Add(jsonutils.NewString(projectId), "tenant_id")
+	obj.Add(jsonutils.NewString(domainId), "domain_id")
+	return obj
+}
+
+func resourceIds(objs []jsonutils.JSONObject) []string {
+	ids := make([]string, len(objs))
+	for i := range objs {
+		ids[i], _ = objs[i].GetString("id")
+	}
+	return ids
+}
+
+func TestFilterByScope(t *testing.T) {
+	// a fake lister result spanning two domains and three projects
+	resources := []jsonutils.JSONObject{
+		fakeResource("eip1", "proj1", "domain1"),
+		fakeResource("eip2", "proj2", "domain1"),
+		fakeResource("eip3", "proj3", "domain2"),
+	}
+
+	cases := []struct {
+		name  string
+		scope *monitor.SSuggestAlertScope
+		want  []string
+	}{
+		{"nil scope includes all", nil, []string{"eip1", "eip2", "eip3"}},
+		{"empty scope includes all", &monitor.SSuggestAlertScope{}, []string{"eip1", "eip2", "eip3"}},
+		{
+			"exclude project",
+			&monitor.SSuggestAlertScope{ExcludeProjectIds: []string{"proj2"}},
+			[]string{"eip1", "eip3"},
+		},
+		{
+			"exclude domain",
+			&monitor.SSuggestAlertScope{ExcludeDomainIds: []string{"domain1"}},
+			[]string{"eip3"},
+		},
+		{
+			"include only",
+			&monitor.SSuggestAlertScope{IncludeOnlyProjectIds: []string{"proj1"}},
+			[]string{"eip1"},
+		},
+		{
+			"include only minus exclusion",
+			&monitor.SSuggestAlertScope{
+				IncludeOnlyProjectIds: []string{"proj1", "proj2"},
+				ExcludeProjectIds:     []string{"proj2"},
+			},
+			[]string{"eip1"},
+		},
+	}
+	for _, c := range cases {
+		got := resourceIds(filterByScope(resources, c.scope))
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v want %v", c.name, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: got %v want %v", c.name, got, c.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/mcclient/auth"
+	"yunion.io/x/onecloud/pkg/mcclient/modules"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+	"yunion.io/x/onecloud/pkg/monitor/options"
+)
+
+func init() {
+	models.RegisterSuggestSysRuleDriver(&SnapshotOrphaned{})
+}
+
+// SnapshotOrphaned suggests deleting snapshots whose disk is gone, or
+// manual snapshots that outlived the configured retention
+type SnapshotOrphaned struct{}
+
+func (drv *SnapshotOrphaned) GetType() string {
+	return monitor.SUGGEST_RULE_TYPE_SNAPSHOT_ORPHANED
+}
+
+func (drv *SnapshotOrphaned) GetResourceType() string {
+	return "snapshot"
+}
+
+func (drv *SnapshotOrphaned) ValidateSetting(input *monitor.SSuggestSysAlertSetting) error {
+	if input == nil || input.SnapshotOrphaned == nil {
+		return httperrors.NewInputParameterError("snapshot_orphaned setting is required")
+	}
+	if input.SnapshotOrphaned.KeepDays < 0 {
+		return httperrors.NewInputParameterError("keep_days must not be negative")
+	}
+	return nil
+}
+
+// Resolve deletes the orphaned snapshot through the compute API
+func (drv *SnapshotOrphaned) Resolve(ctx context.Context, alert *models.SSuggestSysAlert) error {
+	s := auth.GetAdminSession(ctx, options.Options.Region, "")
+	_, err := modules.Snapshots.Delete(s, alert.ResId, nil)
+	return err
+}
+
+func (drv *SnapshotOrphaned) Run(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) {
+	if err := drv.doSuggestSysRule(ctx, rule, setting); err != nil {
+		log.Errorf("rule %s run: %v", rule.GetName(), err)
+	}
+}
+
+func (drv *SnapshotOrphaned) doSuggestSysRule(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) error {
+	candidates, err := drv.collectOrphanedSnapshots(ctx, setting)
+	if err != nil {
+		return err
+	}
+	reported := make(map[string]bool, len(candidates))
+	for _, candidate := range candidates {
+		id, _ := candidate.res.GetString("id")
+		if err := drv.createSuggestAlert(ctx, candidate); err != nil {
+			log.Errorf("create suggest alert for snapshot %s: %v", id, err)
+			continue
+		}
+		reported[id] = true
+	}
+	if _, err := models.SuggestSysAlertManager.ResolveAbsentAlerts(ctx, drv.GetType(), reported); err != nil {
+		return errors.Wrap(err, "resolve absent alerts")
+	}
+	return nil
+}
+
+// DryRun reports the snapshots a real run would raise alerts for,
+// without persisting anything
+func (drv *SnapshotOrphaned) DryRun(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.PreviewAlert, error) {
+	candidates, err := drv.collectOrphanedSnapshots(ctx, setting)
+	if err != nil {
+		return nil, err
+	}
+	previews := make([]monitor.PreviewAlert, 0, len(candidates))
+	for _, candidate := range candidates {
+		preview := monitor.PreviewAlert{
+			Suggest: candidate.reason,
+			Action:  monitor.DRIVER_ACTION,
+		}
+		preview.ResId, _ = candidate.res.GetString("id")
+		preview.Name, _ = candidate.res.GetString("name")
+		preview.ProjectId, _ = candidate.res.GetString("tenant_id")
+		previews = append(previews, preview)
+	}
+	return previews, nil
+}
+
+// estimateStorageMonthlyAmount estimates the monthly storage cost of
+// sizeGb at the configured per-GB price, zero when either is unknown
+func estimateStorageMonthlyAmount(sizeGb float64, gbPrice float64) float64 {
+	if sizeGb <= 0 || gbPrice <= 0 {
+		return 0
+	}
+	return sizeGb * gbPrice
+}
+
+// sOrphanCandidate is one reclaimable snapshot with the reason it was
+// picked, carried into the alert suggestion
+type sOrphanCandidate struct {
+	res    jsonutils.JSONObject
+	reason string
+}
+
+// snapshotOrphanReason decides whether a snapshot is reclaimable:
+// its disk is gone, or a non-policy snapshot outlived keepDays. The
+// returned reason is empty for snapshots to keep.
+func snapshotOrphanReason(diskExists bool, createdBy string, createdAt time.Time, keepDays int, now time.Time) string {
+	if !diskExists {
+		return "delete snapshot of deleted disk"
+	}
+	if keepDays <= 0 {
+		return ""
+	}
+	if createdBy == "auto" {
+		// policy-created snapshots rotate on their own schedule
+		return ""
+	}
+	if createdAt.IsZero() || now.Sub(createdAt) < time.Duration(keepDays)*24*time.Hour {
+		return ""
+	}
+	return fmt.Sprintf("delete manual snapshot older than %d days", keepDays)
+}
+
+// findOrphanedSnapshots applies the orphan criteria to already listed
+// snapshots, diskIds being the ids of all existing disks
+func findOrphanedSnapshots(snapshots []jsonutils.JSONObject, diskIds map[string]bool, keepDays int, now time.Time) []sOrphanCandidate {
+	candidates := make([]sOrphanCandidate, 0)
+	for _, snapshot := range snapshots {
+		diskId, _ := snapshot.GetString("disk_id")
+		createdBy, _ := snapshot.GetString("created_by")
+		createdAt, _ := snapshot.GetTime("created_at")
+		reason := snapshotOrphanReason(diskIds[diskId], createdBy, createdAt, keepDays, now)
+		if len(reason) == 0 {
+			continue
+		}
+		candidates = append(candidates, sOrphanCandidate{res: snapshot, reason: reason})
+	}
+	return candidates
+}
+
+func (drv *SnapshotOrphaned) collectOrphanedSnapshots(ctx context.Context, setting *monitor.SSuggestSysAlertSetting) ([]sOrphanCandidate, error) {
+	diskIds := make(map[string]bool)
+	err := ListAllResources(ctx, &modules.Disks, nil, 0, func(disk jsonutils.JSONObject) error {
+		if id, _ := disk.GetString("id"); len(id) > 0 {
+			diskIds[id] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list disks")
+	}
+	snapshots := make([]jsonutils.JSONObject, 0)
+	err = ListAllResources(ctx, &modules.Snapshots, nil, 0, func(snapshot jsonutils.JSONObject) error {
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "list snapshots")
+	}
+	if setting != nil {
+		snapshots = filterByScope(snapshots, setting.Scope)
+	}
+	keepDays := 0
+	if setting != nil && setting.SnapshotOrphaned != nil {
+		keepDays = setting.SnapshotOrphaned.KeepDays
+	}
+	candidates := findOrphanedSnapshots(snapshots, diskIds, keepDays, time.Now())
+	filtered := make([]sOrphanCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		id, err := candidate.res.GetString("id")
+		if err != nil {
+			continue
+		}
+		if models.SuggestSysAlertIgnoreManager.IsIgnored(ctx, drv.GetType(), id) {
+			continue
+		}
+		filtered = append(filtered, candidate)
+	}
+	return filtered, nil
+}
+
+func (drv *SnapshotOrphaned) createSuggestAlert(ctx context.Context, candidate sOrphanCandidate) error {
+	// snapshot sizes come in MB
+	sizeMb, _ := candidate.res.Int("size")
+	alert := &models.SSuggestSysAlert{
+		Type:    drv.GetType(),
+		Suggest: candidate.reason,
+		Action:  monitor.DRIVER_ACTION,
+		ResMeta: candidate.res,
+		Amount:  estimateStorageMonthlyAmount(float64(sizeMb)/1024, options.Options.SuggestStorageGbMonthlyPrice),
+	}
+	alert.ResId, _ = candidate.res.GetString("id")
+	alert.Name, _ = candidate.res.GetString("name")
+	alert.Status = monitor.SUGGEST_ALERT_READY
+	return models.SuggestSysAlertManager.ObserveAlert(ctx, alert)
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+func snapshotObj(id, diskId, createdBy string, createdAt time.Time) jsonutils.JSONObject {
+	obj := jsonutils.NewDict()
+	obj.Set("id", jsonutils.NewString(id))
+	obj.Set("disk_id", jsonutils.NewString(diskId))
+	obj.Set("created_by", jsonutils.NewString(createdBy))
+	obj.Set("created_at", jsonutils.NewTimeString(createdAt))
+	return obj
+}
+
+func TestFindOrphanedSnapshots(t *testing.T) {
+	now := time.Date(2020, 6, 15, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-40 * 24 * time.Hour)
+	recent := now.Add(-time.Hour)
+	snapshots := []jsonutils.JSONObject{
+		snapshotObj("snap-orphan", "disk-gone", "manual", recent),
+		snapshotObj("snap-live", "disk-1", "manual", recent),
+		snapshotObj("snap-old-manual", "disk-1", "manual", old),
+		snapshotObj("snap-old-auto", "disk-1", "auto", old),
+	}
+	diskIds := map[string]bool{"disk-1": true}
+
+	candidates := findOrphanedSnapshots(snapshots, diskIds, 30, now)
+	byId := make(map[string]string, len(candidates))
+	for _, candidate := range candidates {
+		id, _ := candidate.res.GetString("id")
+		byId[id] = candidate.reason
+	}
+	if len(byId) != 2 {
+		t.Fatalf("candidates = %v, want snap-orphan and snap-old-manual", byId)
+	}
+	if !strings.Contains(byId["snap-orphan"], "deleted disk") {
+		t.Errorf("orphan reason = %q", byId["snap-orphan"])
+	}
+	if !strings.Contains(byId["snap-old-manual"], "30 days") {
+		t.Errorf("retention reason = %q", byId["snap-old-manual"])
+	}
+
+	// keep_days 0 only checks for deleted disks
+	candidates = findOrphanedSnapshots(snapshots, diskIds, 0, now)
+	if len(candidates) != 1 {
+		t.Errorf("with keep_days 0 only the orphan qualifies, got %d", len(candidates))
+	}
+}
+
+func TestSnapshotOrphanedValidateSetting(t *testing.T) {
+	drv := &SnapshotOrphaned{}
+	if err := drv.ValidateSetting(nil); err == nil {
+		t.Errorf("expect error for missing setting")
+	}
+	if err := drv.ValidateSetting(&monitor.SSuggestSysAlertSetting{
+		SnapshotOrphaned: &monitor.SnapshotOrphaned{KeepDays: -1},
+	}); err == nil {
+		t.Errorf("expect error for negative keep_days")
+	}
+	if err := drv.ValidateSetting(&monitor.SSuggestSysAlertSetting{
+		SnapshotOrphaned: &monitor.SnapshotOrphaned{KeepDays: 30},
+	}); err != nil {
+		t.Errorf("valid setting rejected: %v", err)
+	}
+}
+
+func TestEstimateStorageMonthlyAmount(t *testing.T) {
+	if got := estimateStorageMonthlyAmount(100, 0.5); got != 50 {
+		t.Errorf("100 GB at 0.5 = %v, want 50", got)
+	}
+	// unknown size or unconfigured price estimate nothing
+	if got := estimateStorageMonthlyAmount(0, 0.5); got != 0 {
+		t.Errorf("zero size = %v, want 0", got)
+	}
+	if got := estimateStorageMonthlyAmount(100, 0); got != 0 {
+		t.Errorf("zero price = %v, want 0", got)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/httperrors"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+	"yunion.io/x/onecloud/pkg/util/httputils"
+)
+
+func init() {
+	models.RegisterSuggestSysRuleDriver(&WebhookSuggest{})
+}
+
+const (
+	// maxWebhookRespBytes caps the webhook response body so a
+	// misbehaving endpoint cannot exhaust memory
+	maxWebhookRespBytes = 1 << 20
+	// maxWebhookEntries caps how many suggestions one webhook response
+	// may carry
+	maxWebhookEntries = 1000
+	// webhookMaxAttempts is how many times a failing webhook call is
+	// tried before the run is recorded as failed
+	webhookMaxAttempts = 3
+)
+
+// webhookRetryInterval is the base backoff between webhook attempts,
+// doubled on every retry; a variable so tests can shrink it
+var webhookRetryInterval = 5 * time.Second
+
+// WebhookSuggest delegates rule evaluation to an external service: it
+// POSTs to the configured URL and upserts the suggestions the service
+// returns
+type WebhookSuggest struct{}
+
+func (drv *WebhookSuggest) GetType() string {
+	return monitor.SUGGEST_RULE_TYPE_WEBHOOK
+}
+
+// GetResourceType is empty: every reported entry carries its own
+// resource type
+func (drv *WebhookSuggest) GetResourceType() string {
+	return ""
+}
+
+func (drv *WebhookSuggest) ValidateSetting(input *monitor.SSuggestSysAlertSetting) error {
+	if input == nil || input.Webhook == nil {
+		return httperrors.NewInputParameterError("webhook setting is required")
+	}
+	parsed, err := url.Parse(input.Webhook.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return httperrors.NewInputParameterError("invalid webhook url %s", input.Webhook.Url)
+	}
+	return nil
+}
+
+// Resolve is not supported: the suggestions come from an external
+// service this one cannot act for
+func (drv *WebhookSuggest) Resolve(ctx context.Context, alert *models.SSuggestSysAlert) error {
+	return httperrors.NewNotSupportedError("webhook suggestions carry no automatic action")
+}
+
+func (drv *WebhookSuggest) Run(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) {
+	entries, err := drv.callWebhook(ctx, rule, setting)
+	if err != nil {
+		rule.RecordExecResult(false, err.Error())
+		log.Errorf("rule %s run: %v", rule.GetName(), err)
+		return
+	}
+	reported := make(map[string]bool, len(entries))
+	for i := range entries {
+		if err := drv.createSuggestAlert(ctx, &entries[i]); err != nil {
+			log.Errorf("create suggest alert for %s: %v", entries[i].ResId, err)
+			continue
+		}
+		reported[entries[i].ResId] = true
+	}
+	resolved, err := models.SuggestSysAlertManager.ResolveAbsentAlerts(ctx, drv.GetType(), reported)
+	if err != nil {
+		rule.RecordExecResult(false, err.Error())
+		log.Errorf("rule %s resolve absent alerts: %v", rule.GetName(), err)
+		return
+	}
+	rule.RecordExecResult(true, fmt.Sprintf("%d suggestions, %d resolved", len(entries), resolved))
+}
+
+// DryRun reports the suggestions the webhook currently returns,
+// without persisting anything
+func (drv *WebhookSuggest) DryRun(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.PreviewAlert, error) {
+	entries, err := drv.callWebhook(ctx, rule, setting)
+	if err != nil {
+		return nil, err
+	}
+	previews := make([]monitor.PreviewAlert, 0, len(entries))
+	for i := range entries {
+		previews = append(previews, monitor.PreviewAlert{
+			ResId:   entries[i].ResId,
+			Name:    entries[i].ResId,
+			Suggest: entries[i].Suggest,
+			Action:  entries[i].Action,
+		})
+	}
+	return previews, nil
+}
+
+// callWebhook fetches the suggestion list, retrying failed calls with
+// exponential backoff
+func (drv *WebhookSuggest) callWebhook(ctx context.Context, rule *models.SSuggestSysRule, setting *monitor.SSuggestSysAlertSetting) ([]monitor.SuggestWebhookEntry, error) {
+	if setting == nil || setting.Webhook == nil {
+		return nil, httperrors.NewInputParameterError("webhook setting is required")
+	}
+	var lastErr error
+	interval := webhookRetryInterval
+	for attempt := 0; attempt < webhookMaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(interval):
+			}
+			interval *= 2
+		}
+		entries, err := drv.fetchOnce(ctx, rule, setting.Webhook)
+		if err == nil {
+			return entries, nil
+		}
+		lastErr = err
+		log.Warningf("rule %s webhook attempt %d: %v", rule.GetName(), attempt+1, err)
+	}
+	return nil, lastErr
+}
+
+func (drv *WebhookSuggest) fetchOnce(ctx context.Context, rule *models.SSuggestSysRule, webhook *monitor.SuggestRuleWebhook) ([]monitor.SuggestWebhookEntry, error) {
+	body := jsonutils.NewDict()
+	body.Add(jsonutils.NewString(rule.GetId()), "rule_id")
+	body.Add(jsonutils.NewString(rule.GetName()), "rule_name")
+	body.Add(jsonutils.NewString(rule.Type), "rule_type")
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "new webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhook.AuthHeader != "" {
+		req.Header.Set("Authorization", webhook.AuthHeader)
+	}
+	resp, err := httputils.GetDefaultClient().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "call webhook")
+	}
+	defer httputils.CloseResponse(resp)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("webhook status: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebhookRespBytes+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read webhook response")
+	}
+	return parseWebhookEntries(data)
+}
+
+// parseWebhookEntries validates a webhook response body: a JSON array
+// of suggestion entries within the size and count caps, each carrying
+// at least a res_id and a suggest text
+func parseWebhookEntries(data []byte) ([]monitor.SuggestWebhookEntry, error) {
+	if len(data) > maxWebhookRespBytes {
+		return nil, fmt.Errorf("webhook response exceeds %d bytes", maxWebhookRespBytes)
+	}
+	obj, err := jsonutils.Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse webhook response")
+	}
+	items, err := obj.GetArray()
+	if err != nil {
+		return nil, errors.Wrap(err, "webhook response is not a JSON array")
+	}
+	if len(items) > maxWebhookEntries {
+		return nil, fmt.Errorf("webhook response carries %d entries, more than %d", len(items), maxWebhookEntries)
+	}
+	entries := make([]monitor.SuggestWebhookEntry, 0, len(items))
+	for i, item := range items {
+		entry := monitor.SuggestWebhookEntry{}
+		if err := item.Unmarshal(&entry); err != nil {
+			return nil, errors.Wrapf(err, "unmarshal webhook entry %d", i)
+		}
+		if entry.ResId == "" || entry.Suggest == "" {
+			return nil, fmt.Errorf("webhook entry %d misses res_id or suggest", i)
+		}
+		if entry.Amount < 0 {
+			return nil, fmt.Errorf("webhook entry %d carries a negative amount", i)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (drv *WebhookSuggest) createSuggestAlert(ctx context.Context, entry *monitor.SuggestWebhookEntry) error {
+	alert := &models.SSuggestSysAlert{
+		Type:    drv.GetType(),
+		ResId:   entry.ResId,
+		Suggest: entry.Suggest,
+		Action:  entry.Action,
+		ResMeta: jsonutils.Marshal(entry),
+		Amount:  entry.Amount,
+	}
+	alert.Name = entry.ResId
+	alert.Status = monitor.SUGGEST_ALERT_READY
+	return models.SuggestSysAlertManager.ObserveAlert(ctx, alert)
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestsysdrivers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/monitor/models"
+)
+
+func TestWebhookValidateSetting(t *testing.T) {
+	drv := &WebhookSuggest{}
+	if err := drv.ValidateSetting(nil); err == nil {
+		t.Errorf("nil setting accepted")
+	}
+	if err := drv.ValidateSetting(&monitor.SSuggestSysAlertSetting{}); err == nil {
+		t.Errorf("missing webhook config accepted")
+	}
+	for _, bad := range []string{"", "ftp://example.com/hook", "example.com/hook", "http://"} {
+		setting := &monitor.SSuggestSysAlertSetting{Webhook: &monitor.SuggestRuleWebhook{Url: bad}}
+		if err := drv.ValidateSetting(setting); err == nil {
+			t.Errorf("url %q accepted", bad)
+		}
+	}
+	setting := &monitor.SSuggestSysAlertSetting{Webhook: &monitor.SuggestRuleWebhook{Url: "https://example.com/hook"}}
+	if err := drv.ValidateSetting(setting); err != nil {
+		t.Errorf("valid url rejected: %v", err)
+	}
+}
+
+func TestParseWebhookEntries(t *testing.T) {
+	entries, err := parseWebhookEntries([]byte(`[
+		{"res_id": "srv1", "res_type": "server", "suggest": "downsize", "action": "RESIZE", "amount": 12.5},
+		{"res_id": "disk2", "suggest": "delete orphan disk"}
+	]`))
+	if err != nil {
+		t.Fatalf("valid payload rejected: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 entries, got %d", len(entries))
+	}
+	if entries[0].ResType != "server" || entries[0].Amount != 12.5 || entries[1].Action != "" {
+		t.Errorf("entry fields wrong: %+v", entries)
+	}
+
+	if _, err := parseWebhookEntries([]byte(`{"res_id": "srv1"}`)); err == nil {
+		t.Errorf("non-array payload accepted")
+	}
+	if _, err := parseWebhookEntries([]byte(`[{"res_id": "srv1"`)); err == nil {
+		t.Errorf("malformed JSON accepted")
+	}
+	if _, err := parseWebhookEntries([]byte(`[{"suggest": "no res id"}]`)); err == nil {
+		t.Errorf("entry without res_id accepted")
+	}
+	if _, err := parseWebhookEntries([]byte(`[{"res_id": "srv1", "suggest": "x", "amount": -1}]`)); err == nil {
+		t.Errorf("negative amount accepted")
+	}
+}
+
+func TestParseWebhookEntriesCaps(t *testing.T) {
+	oversized := make([]byte, maxWebhookRespBytes+1)
+	for i := range oversized {
+		oversized[i] = ' '
+	}
+	if _, err := parseWebhookEntries(oversized); err == nil {
+		t.Errorf("oversized payload accepted")
+	}
+
+	items := make([]string, maxWebhookEntries+1)
+	for i := range items {
+		items[i] = fmt.Sprintf(`{"res_id": "r%d", "suggest": "s"}`, i)
+	}
+	if _, err := parseWebhookEntries([]byte("[" + strings.Join(items, ",") + "]")); err == nil {
+		t.Errorf("payload with too many entries accepted")
+	}
+}
+
+func webhookTestRule() *models.SSuggestSysRule {
+	rule := &models.SSuggestSysRule{Type: monitor.SUGGEST_RULE_TYPE_WEBHOOK}
+	rule.Id = "rule1"
+	rule.Name = "webhook-rule"
+	return rule
+}
+
+func TestWebhookFetchOnce(t *testing.T) {
+	var gotMethod, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotAuth = r.Header.Get("Authorization")
+		fmt.Fprintf(w, `[{"res_id": "eip1", "res_type": "eip", "suggest": "release", "amount": 3}]`)
+	}))
+	defer srv.Close()
+
+	drv := &WebhookSuggest{}
+	entries, err := drv.fetchOnce(context.Background(), webhookTestRule(), &monitor.SuggestRuleWebhook{
+		Url:        srv.URL,
+		AuthHeader: "Bearer token1",
+	})
+	if err != nil {
+		t.Fatalf("fetchOnce: %v", err)
+	}
+	if gotMethod != http.MethodPost || gotAuth != "Bearer token1" {
+		t.Errorf("webhook called with method %q auth %q", gotMethod, gotAuth)
+	}
+	if len(entries) != 1 || entries[0].ResId != "eip1" || entries[0].Amount != 3 {
+		t.Errorf("entries wrong: %+v", entries)
+	}
+}
+
+func TestWebhookCallRetriesWithBackoff(t *testing.T) {
+	savedInterval := webhookRetryInterval
+	webhookRetryInterval = time.Millisecond
+	defer func() { webhookRetryInterval = savedInterval }()
+
+	var lock sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls++
+		failing := calls < 3
+		lock.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `[{"res_id": "srv1", "suggest": "downsize"}]`)
+	}))
+	defer srv.Close()
+
+	drv := &WebhookSuggest{}
+	setting := &monitor.SSuggestSysAlertSetting{Webhook: &monitor.SuggestRuleWebhook{Url: srv.URL}}
+	entries, err := drv.callWebhook(context.Background(), webhookTestRule(), setting)
+	if err != nil {
+		t.Fatalf("expect third attempt to succeed: %v", err)
+	}
+	if len(entries) != 1 || calls != 3 {
+		t.Errorf("got %d entries after %d calls, want 1 after 3", len(entries), calls)
+	}
+}
+
+func TestWebhookCallGivesUpAfterMaxAttempts(t *testing.T) {
+	savedInterval := webhookRetryInterval
+	webhookRetryInterval = time.Millisecond
+	defer func() { webhookRetryInterval = savedInterval }()
+
+	var lock sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		calls++
+		lock.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	drv := &WebhookSuggest{}
+	setting := &monitor.SSuggestSysAlertSetting{Webhook: &monitor.SuggestRuleWebhook{Url: srv.URL}}
+	if _, err := drv.callWebhook(context.Background(), webhookTestRule(), setting); err == nil {
+		t.Fatalf("expect error after exhausted retries")
+	}
+	if calls != webhookMaxAttempts {
+		t.Errorf("server saw %d calls, want %d", calls, webhookMaxAttempts)
+	}
+}
+
+func TestWebhookFetchOnceRejectsBadPayloads(t *testing.T) {
+	cases := map[string]http.HandlerFunc{
+		"malformed": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `[{"res_id": "srv1"`)
+		},
+		"oversized": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("["))
+			filler := strings.Repeat(" ", 32*1024)
+			for written := 1; written <= maxWebhookRespBytes; written += len(filler) {
+				w.Write([]byte(filler))
+			}
+			w.Write([]byte("]"))
+		},
+	}
+	drv := &WebhookSuggest{}
+	for name, handler := range cases {
+		srv := httptest.NewServer(handler)
+		_, err := drv.fetchOnce(context.Background(), webhookTestRule(), &monitor.SuggestRuleWebhook{Url: srv.URL})
+		srv.Close()
+		if err == nil {
+			t.Errorf("%s payload accepted", name)
+		}
+	}
+}
@@ -0,0 +1 @@
+package prometheus // import "yunion.io/x/onecloud/pkg/monitor/tsdb/driver/prometheus"
@@ -0,0 +1,51 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"time"
+
+	api "yunion.io/x/onecloud/pkg/apis/monitor"
+)
+
+type Query struct {
+	// Metric is the prometheus metric name translated from
+	// the influx-style measurement and field
+	Metric   string
+	Tags     []api.MetricQueryTag
+	GroupBy  []string
+	Function string
+	Alias    string
+	Interval time.Duration
+}
+
+// Response is the prometheus HTTP API response envelope
+type Response struct {
+	Status    string       `json:"status"`
+	Data      ResponseData `json:"data"`
+	ErrorType string       `json:"errorType,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+type ResponseData struct {
+	ResultType string         `json:"resultType"`
+	Result     []SeriesResult `json:"result"`
+}
+
+type SeriesResult struct {
+	Metric map[string]string `json:"metric"`
+	// Values item is a [timestamp, "value"] pair
+	Values [][]interface{} `json:"values"`
+}
@@ -0,0 +1,153 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context/ctxhttp"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+)
+
+const (
+	ErrPrometheusInvalidResponse = errors.Error("Prometheus invalid status")
+	ErrPrometheusQueryFailed     = errors.Error("Prometheus query failed")
+)
+
+func init() {
+	tsdb.RegisterTsdbQueryEndpoint(api.DataSourceTypePrometheus, NewPrometheusExecutor)
+	tsdb.RegisterQueryModelValidator(api.DataSourceTypePrometheus, ValidateQuery)
+}
+
+type PrometheusExecutor struct {
+	QueryParser    *PrometheusQueryParser
+	ResponseParser *ResponseParser
+}
+
+func NewPrometheusExecutor(datasource *tsdb.DataSource) (tsdb.TsdbQueryEndpoint, error) {
+	return &PrometheusExecutor{
+		QueryParser:    &PrometheusQueryParser{},
+		ResponseParser: &ResponseParser{},
+	}, nil
+}
+
+func (e *PrometheusExecutor) Query(ctx context.Context, dsInfo *tsdb.DataSource, tsdbQuery *tsdb.TsdbQuery) (*tsdb.Response, error) {
+	result := &tsdb.Response{}
+
+	query, err := e.getQuery(dsInfo, tsdbQuery.Queries)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := query.Build(tsdbQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := e.createRequest(dsInfo, query, expr, tsdbQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := dsInfo.GetHttpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ctxhttp.Do(ctx, httpClient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, errors.Wrapf(ErrPrometheusInvalidResponse, "status code: %v", resp.Status)
+	}
+
+	var response Response
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&response); err != nil {
+		return nil, err
+	}
+
+	if response.Status != "success" {
+		return nil, errors.Wrapf(ErrPrometheusQueryFailed, "%s: %s", response.ErrorType, response.Error)
+	}
+
+	result.Results = make(map[string]*tsdb.QueryResult)
+	ret := e.ResponseParser.Parse(&response, query)
+	ret.Meta = tsdb.QueryResultMeta{
+		RawQuery: expr,
+	}
+	result.Results["A"] = ret
+
+	return result, nil
+}
+
+func (e *PrometheusExecutor) getQuery(dsInfo *tsdb.DataSource, queries []*tsdb.Query) (*Query, error) {
+	// The model supports multiple queries, but right now this is only used from
+	// alerting so we only need to support batch executing 1 query at a time.
+	if len(queries) > 0 {
+		query, err := e.QueryParser.Parse(queries[0], dsInfo)
+		if err != nil {
+			return nil, err
+		}
+		return query, nil
+	}
+	return nil, errors.Error("query request contains no queries")
+}
+
+func (e *PrometheusExecutor) createRequest(dsInfo *tsdb.DataSource, query *Query, expr string, queryCtx *tsdb.TsdbQuery) (*http.Request, error) {
+	u, _ := url.Parse(dsInfo.Url)
+	u.Path = path.Join(u.Path, "api/v1/query_range")
+
+	step := query.Step(queryCtx)
+	if step < time.Second {
+		step = time.Second
+	}
+
+	params := url.Values{}
+	params.Set("query", expr)
+	params.Set("start", strconv.FormatInt(queryCtx.TimeRange.GetFromAsSecondsEpoch(), 10))
+	params.Set("end", strconv.FormatInt(queryCtx.TimeRange.GetToAsSecondsEpoch(), 10))
+	params.Set("step", fmt.Sprintf("%ds", int64(step.Seconds())))
+	u.RawQuery = params.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "OneCloud Monitor")
+	if dsInfo.User != "" {
+		req.SetBasicAuth(dsInfo.User, dsInfo.Password)
+	}
+
+	log.Debugf("Prometheus range query: %q, url: %s", expr, u.String())
+	return req, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+)
+
+// Build renders the query as a PromQL expression suitable for a
+// range query over the prometheus HTTP API
+func (query *Query) Build(queryCtx *tsdb.TsdbQuery) (string, error) {
+	selector := query.renderSelector()
+	if query.Function == "" {
+		return selector, nil
+	}
+
+	by := ""
+	if len(query.GroupBy) > 0 {
+		by = fmt.Sprintf(" by (%s) ", strings.Join(query.GroupBy, ", "))
+	}
+	return fmt.Sprintf("%s%s(%s)", query.Function, by, selector), nil
+}
+
+func (query *Query) renderSelector() string {
+	matchers := query.renderMatchers()
+	if len(matchers) == 0 {
+		return query.Metric
+	}
+	return fmt.Sprintf("%s{%s}", query.Metric, strings.Join(matchers, ","))
+}
+
+func (query *Query) renderMatchers() []string {
+	var res []string
+	for _, tag := range query.Tags {
+		value := tag.Value
+		// influx regex values come wrapped in slashes
+		if (tag.Operator == "=~" || tag.Operator == "!~") &&
+			strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+			value = value[1 : len(value)-1]
+		}
+		res = append(res, fmt.Sprintf(`%s%s"%s"`, tag.Key, tag.Operator, strings.Replace(value, `"`, `\"`, -1)))
+	}
+	return res
+}
+
+// Step returns the range query resolution step derived from the
+// query interval and time range
+func (query *Query) Step(queryCtx *tsdb.TsdbQuery) time.Duration {
+	calculator := tsdb.NewIntervalCalculator(&tsdb.IntervalOptions{})
+	interval := calculator.Calculate(queryCtx.TimeRange, query.Interval)
+	return interval.Value
+}
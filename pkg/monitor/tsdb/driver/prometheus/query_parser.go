@@ -0,0 +1,176 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"time"
+
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+
+	api "yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+)
+
+const (
+	ErrUnsupportedQueryPart = errors.Error("Query part not supported by prometheus data source")
+	ErrUnsupportedOperator  = errors.Error("Tag operator not supported by prometheus data source")
+	ErrMissingField         = errors.Error("Query select must start with a field part")
+)
+
+// aggregationFuncs maps influx-style aggregation functions to
+// the equivalent PromQL aggregation operators
+var aggregationFuncs = map[string]string{
+	"mean": "avg",
+	"max":  "max",
+	"min":  "min",
+	"sum":  "sum",
+}
+
+var supportedOperators = []string{"=", "!=", "=~", "!~"}
+
+type PrometheusQueryParser struct{}
+
+func (qp *PrometheusQueryParser) Parse(model *tsdb.Query, dsInfo *tsdb.DataSource) (*Query, error) {
+	if len(model.Selects) == 0 {
+		return nil, errors.Wrap(ErrMissingField, "empty select")
+	}
+	if len(model.Selects) > 1 {
+		return nil, errors.Wrap(ErrUnsupportedQueryPart, "multiple selects")
+	}
+
+	metric, function, alias, err := qp.parseSelect(model.Selects[0], model.Measurement)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := qp.parseTags(model.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	groupBy, err := qp.parseGroupBy(model.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(groupBy) > 0 && function == "" {
+		// grouping requires an aggregation operator in PromQL
+		function = "avg"
+	}
+
+	parsedInterval, err := tsdb.GetIntervalFrom(dsInfo, model, time.Millisecond*1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{
+		Metric:   metric,
+		Tags:     tags,
+		GroupBy:  groupBy,
+		Function: function,
+		Alias:    alias,
+		Interval: parsedInterval,
+	}, nil
+}
+
+func (qp *PrometheusQueryParser) parseSelect(sel api.MetricQuerySelect, measurement string) (string, string, string, error) {
+	var (
+		metric   string
+		function string
+		alias    string
+	)
+	for i, part := range sel {
+		switch {
+		case part.Type == "field":
+			if i != 0 {
+				return "", "", "", errors.Wrap(ErrUnsupportedQueryPart, "field part must come first")
+			}
+			metric = formatMetricName(measurement, firstParam(part))
+		case part.Type == "alias":
+			alias = firstParam(part)
+		default:
+			fn, ok := aggregationFuncs[part.Type]
+			if !ok {
+				return "", "", "", errors.Wrapf(ErrUnsupportedQueryPart, "function: %s", part.Type)
+			}
+			if function != "" {
+				return "", "", "", errors.Wrapf(ErrUnsupportedQueryPart, "multiple aggregations: %s and %s", function, fn)
+			}
+			function = fn
+		}
+	}
+	if metric == "" {
+		return "", "", "", ErrMissingField
+	}
+	return metric, function, alias, nil
+}
+
+func (qp *PrometheusQueryParser) parseTags(tags []api.MetricQueryTag) ([]api.MetricQueryTag, error) {
+	result := make([]api.MetricQueryTag, 0)
+	for _, tag := range tags {
+		if tag.Operator == "" {
+			tag.Operator = "="
+		}
+		if !utils.IsInStringArray(tag.Operator, supportedOperators) {
+			return nil, errors.Wrapf(ErrUnsupportedOperator, "operator: %s", tag.Operator)
+		}
+		result = append(result, tag)
+	}
+	return result, nil
+}
+
+func (qp *PrometheusQueryParser) parseGroupBy(groupBy []api.MetricQueryPart) ([]string, error) {
+	var result []string
+	for _, gb := range groupBy {
+		switch gb.Type {
+		case "time", "fill":
+			// time interval is derived from the query context and
+			// fill has no prometheus counterpart
+			continue
+		case "tag":
+			result = append(result, firstParam(gb))
+		default:
+			return nil, errors.Wrapf(ErrUnsupportedQueryPart, "group by: %s", gb.Type)
+		}
+	}
+	return result, nil
+}
+
+// formatMetricName translates an influx-style (measurement, field)
+// pair into a prometheus metric name
+func formatMetricName(measurement string, field string) string {
+	if field == "" || field == "value" || field == "*" {
+		return measurement
+	}
+	return fmt.Sprintf("%s_%s", measurement, field)
+}
+
+func firstParam(part api.MetricQueryPart) string {
+	if len(part.Params) == 0 {
+		return ""
+	}
+	return part.Params[0]
+}
+
+// ValidateQuery checks that a query model only uses features the
+// prometheus driver can translate into PromQL. It is called at alert
+// rule creation time so unsupported queries fail early.
+func ValidateQuery(model api.MetricQuery) error {
+	parser := &PrometheusQueryParser{}
+	_, err := parser.Parse(&tsdb.Query{MetricQuery: model}, &tsdb.DataSource{})
+	return err
+}
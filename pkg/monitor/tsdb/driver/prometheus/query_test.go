@@ -0,0 +1,145 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	api "yunion.io/x/onecloud/pkg/apis/monitor"
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+)
+
+func TestPrometheusQueryBuilder(t *testing.T) {
+	Convey("Prometheus query builder", t, func() {
+		parser := &PrometheusQueryParser{}
+		dsInfo := &tsdb.DataSource{}
+		queryContext := &tsdb.TsdbQuery{
+			TimeRange: tsdb.NewTimeRange("5m", "now"),
+		}
+
+		buildModel := func(model api.MetricQuery) (string, error) {
+			query, err := parser.Parse(&tsdb.Query{MetricQuery: model}, dsInfo)
+			if err != nil {
+				return "", err
+			}
+			return query.Build(queryContext)
+		}
+
+		Convey("can build simple selector query", func() {
+			expr, err := buildModel(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(api.MetricQueryPart{Type: "field", Params: []string{"usage_active"}}),
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(expr, ShouldEqual, "vm_cpu_usage_active")
+		})
+
+		Convey("value field maps to bare measurement name", func() {
+			expr, err := buildModel(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(api.MetricQueryPart{Type: "field", Params: []string{"value"}}),
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(expr, ShouldEqual, "vm_cpu")
+		})
+
+		Convey("can build query with tag matchers", func() {
+			expr, err := buildModel(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(api.MetricQueryPart{Type: "field", Params: []string{"usage_active"}}),
+				},
+				Tags: []api.MetricQueryTag{
+					{Key: "vm_id", Value: "123", Operator: "="},
+					{Key: "host", Value: "/node.*/", Operator: "=~"},
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(expr, ShouldEqual, `vm_cpu_usage_active{vm_id="123",host=~"node.*"}`)
+		})
+
+		Convey("can build aggregated query with group by", func() {
+			expr, err := buildModel(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(
+						api.MetricQueryPart{Type: "field", Params: []string{"usage_active"}},
+						api.MetricQueryPart{Type: "mean", Params: []string{}},
+					),
+				},
+				GroupBy: []api.MetricQueryPart{
+					{Type: "time", Params: []string{"$interval"}},
+					{Type: "tag", Params: []string{"vm_id"}},
+					{Type: "fill", Params: []string{"none"}},
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(expr, ShouldEqual, "avg by (vm_id) (vm_cpu_usage_active)")
+		})
+
+		Convey("sum aggregation maps to sum operator", func() {
+			expr, err := buildModel(api.MetricQuery{
+				Measurement: "net",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(
+						api.MetricQueryPart{Type: "field", Params: []string{"bps_recv"}},
+						api.MetricQueryPart{Type: "sum", Params: []string{}},
+					),
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(expr, ShouldEqual, "sum(net_bps_recv)")
+		})
+
+		Convey("influx-specific function produces validation error", func() {
+			err := ValidateQuery(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(
+						api.MetricQueryPart{Type: "field", Params: []string{"usage_active"}},
+						api.MetricQueryPart{Type: "non_negative_derivative", Params: []string{"10s"}},
+					),
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("unsupported tag operator produces validation error", func() {
+			err := ValidateQuery(api.MetricQuery{
+				Measurement: "vm_cpu",
+				Selects: []api.MetricQuerySelect{
+					api.NewMetricQuerySelect(api.MetricQueryPart{Type: "field", Params: []string{"usage_active"}}),
+				},
+				Tags: []api.MetricQueryTag{
+					{Key: "vm_id", Value: "10", Operator: ">"},
+				},
+			})
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
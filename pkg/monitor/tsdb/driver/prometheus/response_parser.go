@@ -0,0 +1,113 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"yunion.io/x/onecloud/pkg/monitor/tsdb"
+)
+
+type ResponseParser struct{}
+
+func (rp *ResponseParser) Parse(response *Response, query *Query) *tsdb.QueryResult {
+	queryRes := tsdb.NewQueryResult()
+
+	for _, series := range response.Data.Result {
+		points := make(tsdb.TimeSeriesPoints, 0)
+		for _, valuePair := range series.Values {
+			point, err := rp.parseTimepoint(valuePair)
+			if err == nil {
+				points = append(points, point)
+			}
+		}
+		tags := rp.parseTags(series.Metric)
+		queryRes.Series = append(queryRes.Series, &tsdb.TimeSeries{
+			Name:   rp.formatSerieName(series, query),
+			Points: points,
+			Tags:   tags,
+		})
+	}
+
+	return queryRes
+}
+
+// parseTags strips the reserved __name__ label so tags match the
+// influx row tags format
+func (rp *ResponseParser) parseTags(metric map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for k, v := range metric {
+		if k == "__name__" {
+			continue
+		}
+		tags[k] = v
+	}
+	return tags
+}
+
+func (rp *ResponseParser) formatSerieName(series SeriesResult, query *Query) string {
+	if query.Alias != "" {
+		return query.Alias
+	}
+	var labels []string
+	for k, v := range series.Metric {
+		if k == "__name__" {
+			continue
+		}
+		labels = append(labels, fmt.Sprintf("%s: %s", k, v))
+	}
+	sort.Strings(labels)
+	if len(labels) == 0 {
+		return query.Metric
+	}
+	return fmt.Sprintf("%s { %s }", query.Metric, strings.Join(labels, " "))
+}
+
+func (rp *ResponseParser) parseTimepoint(valuePair []interface{}) (tsdb.TimePoint, error) {
+	if len(valuePair) != 2 {
+		return tsdb.TimePoint{}, fmt.Errorf("invalid value pair length: %d", len(valuePair))
+	}
+
+	timestamp, err := rp.parseTimestamp(valuePair[0])
+	if err != nil {
+		return tsdb.TimePoint{}, err
+	}
+
+	// prometheus sample values are encoded as strings
+	valueStr, ok := valuePair[1].(string)
+	if !ok {
+		return tsdb.TimePoint{}, fmt.Errorf("invalid sample value: %v", valuePair[1])
+	}
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return tsdb.NewTimePoint(nil, timestamp), nil
+	}
+
+	return tsdb.NewTimePoint(&value, timestamp), nil
+}
+
+func (rp *ResponseParser) parseTimestamp(val interface{}) (float64, error) {
+	switch ts := val.(type) {
+	case json.Number:
+		return ts.Float64()
+	case float64:
+		return ts, nil
+	}
+	return 0, fmt.Errorf("invalid timestamp: %v", val)
+}
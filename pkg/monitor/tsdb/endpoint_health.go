@@ -0,0 +1,171 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tsdb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// SplitUrls splits a datasource url column holding one or more
+// comma-separated endpoints
+func SplitUrls(url string) []string {
+	urls := make([]string, 0)
+	for _, u := range strings.Split(url, ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// EndpointProber checks whether a single tsdb endpoint is alive. The
+// default implementation hits the influxdb /ping handler.
+type EndpointProber func(ctx context.Context, url string) error
+
+func DefaultEndpointProber(ctx context.Context, url string) error {
+	pingUrl := fmt.Sprintf("%s/ping", strings.TrimRight(url, "/"))
+	req, err := http.NewRequest(http.MethodGet, pingUrl, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Error(resp.Status)
+	}
+	return nil
+}
+
+// SEndpointStatus is the recorded health of one endpoint
+type SEndpointStatus struct {
+	Url       string
+	Healthy   bool
+	LastCheck time.Time
+	LastError string
+}
+
+// SEndpointHealthTracker keeps per-endpoint health state refreshed by a
+// periodic checker and answers which endpoint a query should use
+type SEndpointHealthTracker struct {
+	lock   sync.Mutex
+	prober EndpointProber
+	status map[string]*SEndpointStatus
+}
+
+func NewEndpointHealthTracker(prober EndpointProber) *SEndpointHealthTracker {
+	if prober == nil {
+		prober = DefaultEndpointProber
+	}
+	return &SEndpointHealthTracker{
+		prober: prober,
+		status: make(map[string]*SEndpointStatus),
+	}
+}
+
+// CheckEndpoints probes every url and records the result, logging
+// health transitions
+func (t *SEndpointHealthTracker) CheckEndpoints(ctx context.Context, urls []string) {
+	for _, url := range urls {
+		err := t.prober(ctx, url)
+		t.recordResult(url, err)
+	}
+}
+
+func (t *SEndpointHealthTracker) recordResult(url string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st, ok := t.status[url]
+	if !ok {
+		st = &SEndpointStatus{Url: url, Healthy: true}
+		t.status[url] = st
+	}
+	healthy := err == nil
+	if st.Healthy != healthy {
+		if healthy {
+			log.Infof("tsdb endpoint %s is back to healthy", url)
+		} else {
+			log.Errorf("tsdb endpoint %s became unhealthy: %v", url, err)
+		}
+	}
+	st.Healthy = healthy
+	st.LastCheck = time.Now()
+	if err != nil {
+		st.LastError = err.Error()
+	} else {
+		st.LastError = ""
+	}
+}
+
+// IsHealthy reports the recorded health of url. Endpoints never probed
+// yet are considered healthy so a fresh service does not refuse to
+// query.
+func (t *SEndpointHealthTracker) IsHealthy(url string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	st, ok := t.status[url]
+	if !ok {
+		return true
+	}
+	return st.Healthy
+}
+
+// PickHealthyUrl returns the first healthy endpoint, failing over from
+// the preferred (first) one when it is down. With no healthy endpoint
+// it falls back to the first url so callers surface the real query
+// error.
+func (t *SEndpointHealthTracker) PickHealthyUrl(urls []string) string {
+	if len(urls) == 0 {
+		return ""
+	}
+	for i, url := range urls {
+		if t.IsHealthy(url) {
+			if i > 0 {
+				log.Warningf("tsdb endpoint %s is down, failover to %s", urls[0], url)
+			}
+			return url
+		}
+	}
+	log.Errorf("no healthy tsdb endpoint among %s, falling back to %s", strings.Join(urls, ","), urls[0])
+	return urls[0]
+}
+
+// GetStatus returns a copy of the recorded status of the given urls in
+// input order
+func (t *SEndpointHealthTracker) GetStatus(urls []string) []SEndpointStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := make([]SEndpointStatus, 0, len(urls))
+	for _, url := range urls {
+		if st, ok := t.status[url]; ok {
+			ret = append(ret, *st)
+		} else {
+			ret = append(ret, SEndpointStatus{Url: url, Healthy: true})
+		}
+	}
+	return ret
+}
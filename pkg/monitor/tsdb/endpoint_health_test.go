@@ -0,0 +1,81 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tsdb
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+)
+
+func TestEndpointHealthTracker(t *testing.T) {
+	urls := []string{"http://influx1:8086", "http://influx2:8086"}
+	deadUrls := []string{}
+	prober := func(ctx context.Context, url string) error {
+		if utils.IsInStringArray(url, deadUrls) {
+			return errors.Error("connection refused")
+		}
+		return nil
+	}
+	tracker := NewEndpointHealthTracker(prober)
+	ctx := context.Background()
+
+	Convey("Endpoint failover", t, func() {
+		Convey("all endpoints healthy picks the first", func() {
+			deadUrls = []string{}
+			tracker.CheckEndpoints(ctx, urls)
+			So(tracker.PickHealthyUrl(urls), ShouldEqual, urls[0])
+		})
+
+		Convey("primary down fails over to the second", func() {
+			deadUrls = []string{urls[0]}
+			tracker.CheckEndpoints(ctx, urls)
+			So(tracker.IsHealthy(urls[0]), ShouldBeFalse)
+			So(tracker.PickHealthyUrl(urls), ShouldEqual, urls[1])
+			status := tracker.GetStatus(urls)
+			So(status[0].Healthy, ShouldBeFalse)
+			So(status[0].LastError, ShouldEqual, "connection refused")
+			So(status[1].Healthy, ShouldBeTrue)
+		})
+
+		Convey("primary recovery flips back", func() {
+			deadUrls = []string{}
+			tracker.CheckEndpoints(ctx, urls)
+			So(tracker.PickHealthyUrl(urls), ShouldEqual, urls[0])
+		})
+
+		Convey("all endpoints down falls back to the first", func() {
+			deadUrls = urls
+			tracker.CheckEndpoints(ctx, urls)
+			So(tracker.PickHealthyUrl(urls), ShouldEqual, urls[0])
+		})
+
+		Convey("unknown endpoints are optimistically healthy", func() {
+			So(tracker.IsHealthy("http://influx3:8086"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestSplitUrls(t *testing.T) {
+	Convey("SplitUrls", t, func() {
+		So(SplitUrls("http://a:8086"), ShouldResemble, []string{"http://a:8086"})
+		So(SplitUrls("http://a:8086, http://b:8086"), ShouldResemble, []string{"http://a:8086", "http://b:8086"})
+		So(SplitUrls(""), ShouldResemble, []string{})
+	})
+}
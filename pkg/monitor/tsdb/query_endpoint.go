@@ -18,6 +18,8 @@ import (
 	"context"
 
 	"yunion.io/x/pkg/errors"
+
+	api "yunion.io/x/onecloud/pkg/apis/monitor"
 )
 
 type TsdbQueryEndpoint interface {
@@ -28,8 +30,15 @@ var registry map[string]GetTsdbQueryEndpointFn
 
 type GetTsdbQueryEndpointFn func(dsInfo *DataSource) (TsdbQueryEndpoint, error)
 
+// QueryModelValidateFn checks a query model against the features a
+// data source type supports
+type QueryModelValidateFn func(model api.MetricQuery) error
+
+var queryModelValidators map[string]QueryModelValidateFn
+
 func init() {
 	registry = make(map[string]GetTsdbQueryEndpointFn)
+	queryModelValidators = make(map[string]QueryModelValidateFn)
 }
 
 const (
@@ -50,3 +59,17 @@ func getTsdbQueryEndpointFor(dsInfo *DataSource) (TsdbQueryEndpoint, error) {
 func RegisterTsdbQueryEndpoint(dataSourceType string, fn GetTsdbQueryEndpointFn) {
 	registry[dataSourceType] = fn
 }
+
+func RegisterQueryModelValidator(dataSourceType string, fn QueryModelValidateFn) {
+	queryModelValidators[dataSourceType] = fn
+}
+
+// ValidateQueryModel validates a query model against the registered
+// validator of the data source type. Types without a validator accept
+// any query.
+func ValidateQueryModel(dataSourceType string, model api.MetricQuery) error {
+	if fn, exists := queryModelValidators[dataSourceType]; exists {
+		return fn(model)
+	}
+	return nil
+}
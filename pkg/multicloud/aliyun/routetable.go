@@ -40,6 +40,7 @@ type SRouteEntry struct {
 	NextHopType          string
 	InstanceId           string
 	NextHops             SNextHops
+	Status               string
 }
 
 // Custom：自定义路由。 System：系统路由。
@@ -59,6 +60,16 @@ func (route *SRouteEntry) GetNextHop() string {
 	return route.InstanceId
 }
 
+func (route *SRouteEntry) GetStatus() string {
+	// Available: 可用, Pending: 创建中, Modifying: 修改中
+	return route.Status
+}
+
+func (route *SRouteEntry) GetPriority() int {
+	// aliyun route entries have no priority
+	return 0
+}
+
 type SRouteEntrys struct {
 	RouteEntry []*SRouteEntry
 }
@@ -68,6 +68,19 @@ func (self *SRoute) GetNextHopType() string {
 	}
 }
 
+func (self *SRoute) GetStatus() string {
+	// aws reports State active or blackhole
+	if strings.EqualFold(self.State, "blackhole") {
+		return api.ROUTE_STATUS_BLACKHOLE
+	}
+	return api.ROUTE_STATUS_AVAILABLE
+}
+
+func (self *SRoute) GetPriority() int {
+	// aws routes have no priority
+	return 0
+}
+
 func (self *SRoute) GetNextHop() string {
 	if self.GatewayID == nil {
 		return ""
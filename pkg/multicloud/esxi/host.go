@@ -1382,6 +1382,22 @@ func (host *SHost) GetCluster() (*mo.ComputeResource, error) {
 	return host.getResourcePool()
 }
 
+// GetClusterName returns the name of the cluster or standalone compute
+// resource the host belongs to, empty when it cannot be determined
+func (host *SHost) GetClusterName() string {
+	moHost := host.getHostSystem()
+	if moHost.Parent == nil {
+		return ""
+	}
+	var me mo.ManagedEntity
+	err := host.manager.reference2Object(*moHost.Parent, []string{"name"}, &me)
+	if err != nil {
+		log.Errorf("fetch parent name of host %s fail %s", host.GetName(), err)
+		return ""
+	}
+	return me.Name
+}
+
 func (host *SHost) GetSiblingHosts() ([]*SHost, error) {
 	rp, err := host.GetCluster()
 	if err != nil {
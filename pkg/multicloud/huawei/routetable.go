@@ -42,6 +42,7 @@ type SRouteEntry struct {
 	Type        string // route type
 	Destination string // route destination
 	NextHop     string // route next hop (ip or id)
+	Priority    int    // route priority
 }
 
 func (route *SRouteEntry) GetType() string {
@@ -61,6 +62,15 @@ func (route *SRouteEntry) GetNextHop() string {
 	return route.NextHop
 }
 
+func (route *SRouteEntry) GetStatus() string {
+	// huawei route entries carry no status
+	return ""
+}
+
+func (route *SRouteEntry) GetPriority() int {
+	return route.Priority
+}
+
 // SRouteTable has no ID and Name because there is no id or name of route table in huawei cloud.
 // And some method such as GetId and GetName of ICloudRouteTable has no practical meaning
 type SRouteTable struct {
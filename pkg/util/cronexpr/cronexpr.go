@@ -0,0 +1,162 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cronexpr parses standard 5-field cron expressions
+// (minute hour day-of-month month day-of-week) and computes the next
+// matching time. Supported field syntax: "*", numbers, ranges "a-b",
+// lists "a,b,c" and steps "*/n" or "a-b/n"
+package cronexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type sField struct {
+	min, max int
+	set      map[int]bool
+}
+
+// CronExpr is a parsed cron expression
+type CronExpr struct {
+	spec       string
+	minute     sField
+	hour       sField
+	dayOfMonth sField
+	month      sField
+	dayOfWeek  sField
+}
+
+var fieldRanges = []struct {
+	name string
+	min  int
+	max  int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Parse parses a 5-field cron expression
+func Parse(spec string) (*CronExpr, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expect 5 fields in cron expression %q, got %d", spec, len(fields))
+	}
+	expr := &CronExpr{spec: spec}
+	targets := []*sField{&expr.minute, &expr.hour, &expr.dayOfMonth, &expr.month, &expr.dayOfWeek}
+	for i := range fields {
+		field, err := parseField(fields[i], fieldRanges[i].min, fieldRanges[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s field %q: %s", fieldRanges[i].name, fields[i], err)
+		}
+		*targets[i] = field
+	}
+	return expr, nil
+}
+
+func parseField(s string, min, max int) (sField, error) {
+	field := sField{min: min, max: max, set: make(map[int]bool)}
+	for _, part := range strings.Split(s, ",") {
+		rangePart, step := part, 1
+		if slashPos := strings.IndexByte(part, '/'); slashPos >= 0 {
+			stepVal, err := strconv.Atoi(part[slashPos+1:])
+			if err != nil || stepVal <= 0 {
+				return field, fmt.Errorf("invalid step %q", part[slashPos+1:])
+			}
+			rangePart, step = part[:slashPos], stepVal
+		}
+		start, end := min, max
+		if rangePart != "*" {
+			if dashPos := strings.IndexByte(rangePart, '-'); dashPos >= 0 {
+				var err error
+				start, err = strconv.Atoi(rangePart[:dashPos])
+				if err != nil {
+					return field, fmt.Errorf("invalid number %q", rangePart[:dashPos])
+				}
+				end, err = strconv.Atoi(rangePart[dashPos+1:])
+				if err != nil {
+					return field, fmt.Errorf("invalid number %q", rangePart[dashPos+1:])
+				}
+			} else {
+				val, err := strconv.Atoi(rangePart)
+				if err != nil {
+					return field, fmt.Errorf("invalid number %q", rangePart)
+				}
+				start, end = val, val
+			}
+		}
+		if start < min || end > max || start > end {
+			return field, fmt.Errorf("value out of range [%d, %d]", min, max)
+		}
+		for v := start; v <= end; v += step {
+			field.set[v] = true
+		}
+	}
+	return field, nil
+}
+
+func (field *sField) match(v int) bool {
+	return field.set[v]
+}
+
+func (expr *CronExpr) matchDate(t time.Time) bool {
+	if !expr.month.match(int(t.Month())) {
+		return false
+	}
+	// standard cron: when both dom and dow are restricted either may
+	// match, otherwise both must
+	domAll := len(expr.dayOfMonth.set) == expr.dayOfMonth.max-expr.dayOfMonth.min+1
+	dowAll := len(expr.dayOfWeek.set) == expr.dayOfWeek.max-expr.dayOfWeek.min+1
+	domMatch := expr.dayOfMonth.match(t.Day())
+	dowMatch := expr.dayOfWeek.match(int(t.Weekday()))
+	if !domAll && !dowAll {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// String returns the original expression
+func (expr *CronExpr) String() string {
+	return expr.spec
+}
+
+// Next returns the first time strictly after t matching the
+// expression, or the zero time if none is found within four years
+func (expr *CronExpr) Next(t time.Time) time.Time {
+	// start at the next whole minute
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(4, 0, 0)
+	for next.Before(limit) {
+		if !expr.matchDate(next) {
+			// skip to the next midnight
+			next = time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, next.Location()).AddDate(0, 0, 1)
+			continue
+		}
+		if !expr.hour.match(next.Hour()) {
+			next = next.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !expr.minute.match(next.Minute()) {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
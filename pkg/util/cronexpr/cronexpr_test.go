@@ -0,0 +1,76 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cronexpr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	cases := []string{
+		"0 2 * *",      // too few fields
+		"0 2 * * * *",  // too many fields
+		"60 * * * *",   // minute out of range
+		"* 24 * * *",   // hour out of range
+		"* * 0 * *",    // day of month out of range
+		"* * * 13 *",   // month out of range
+		"* * * * 7",    // day of week out of range
+		"*/0 * * * *",  // zero step
+		"a * * * *",    // not a number
+		"10-5 * * * *", // reversed range
+	}
+	for _, c := range cases {
+		if _, err := Parse(c); err == nil {
+			t.Errorf("Parse(%q): expect error, got nil", c)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	base := time.Date(2020, 6, 15, 10, 7, 30, 0, time.UTC) // a Monday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2020, 6, 16, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 6, 15, 10, 15, 0, 0, time.UTC)},
+		{"30 4 1 * *", time.Date(2020, 7, 1, 4, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1", time.Date(2020, 6, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * 12 *", time.Date(2020, 12, 1, 12, 0, 0, 0, time.UTC)},
+		{"10 10 * * *", time.Date(2020, 6, 15, 10, 10, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		expr, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.spec, err)
+		}
+		if got := expr.Next(base); !got.Equal(c.want) {
+			t.Errorf("Next(%q) = %s, want %s", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestNextIsStrictlyAfter(t *testing.T) {
+	expr, err := Parse("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2020, 6, 15, 2, 0, 0, 0, time.UTC)
+	want := time.Date(2020, 6, 16, 2, 0, 0, 0, time.UTC)
+	if got := expr.Next(at); !got.Equal(want) {
+		t.Errorf("Next at the matching instant = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+
+	// CircuitOpenErrorClass is the JSONClientError class of requests
+	// rejected by an open circuit
+	CircuitOpenErrorClass = "CircuitOpen"
+)
+
+// CircuitBreakerConfig tunes the per-host circuit breaker
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the consecutive failure count that opens the
+	// circuit for a host
+	FailureThreshold int
+	// CoolDown is how long an open circuit rejects requests before a
+	// half-open probe is allowed
+	CoolDown time.Duration
+}
+
+type sHostCircuit struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// probing marks that the single half-open probe is in flight
+	probing bool
+}
+
+// CircuitBreaker tracks consecutive request failures per destination
+// host and fails fast while a host's circuit is open, so a downed
+// service does not make every caller burn the full dial timeout
+type CircuitBreaker struct {
+	lock   sync.Mutex
+	config CircuitBreakerConfig
+	hosts  map[string]*sHostCircuit
+}
+
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	return &CircuitBreaker{
+		config: config,
+		hosts:  make(map[string]*sHostCircuit),
+	}
+}
+
+func (cb *CircuitBreaker) getHost(host string) *sHostCircuit {
+	circuit, ok := cb.hosts[host]
+	if !ok {
+		circuit = &sHostCircuit{state: CircuitClosed}
+		cb.hosts[host] = circuit
+	}
+	return circuit
+}
+
+// Allow reports whether a request to host may proceed. An open circuit
+// rejects requests until the cool-down elapses, then turns half-open
+// and lets a single probe through.
+func (cb *CircuitBreaker) Allow(host string) bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	circuit := cb.getHost(host)
+	switch circuit.state {
+	case CircuitOpen:
+		if time.Since(circuit.openedAt) < cb.config.CoolDown {
+			return false
+		}
+		circuit.state = CircuitHalfOpen
+		circuit.probing = true
+		return true
+	case CircuitHalfOpen:
+		if circuit.probing {
+			return false
+		}
+		circuit.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record feeds the outcome of a request into the breaker
+func (cb *CircuitBreaker) Record(host string, success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	circuit := cb.getHost(host)
+	if success {
+		circuit.state = CircuitClosed
+		circuit.failures = 0
+		circuit.probing = false
+		return
+	}
+	circuit.probing = false
+	circuit.failures++
+	if circuit.state == CircuitHalfOpen || circuit.failures >= cb.config.FailureThreshold {
+		circuit.state = CircuitOpen
+		circuit.openedAt = time.Now()
+	}
+}
+
+// CircuitState is a diagnostic snapshot of one host's circuit
+type CircuitState struct {
+	Host                string    `json:"host"`
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+}
+
+// Snapshot returns the state of every tracked host, sorted by host
+func (cb *CircuitBreaker) Snapshot() []CircuitState {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	states := make([]CircuitState, 0, len(cb.hosts))
+	for host, circuit := range cb.hosts {
+		states = append(states, CircuitState{
+			Host:                host,
+			State:               circuit.state,
+			ConsecutiveFailures: circuit.failures,
+			OpenedAt:            circuit.openedAt,
+		})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Host < states[j].Host
+	})
+	return states
+}
+
+var (
+	circuitBreakerLock sync.Mutex
+	// circuitBreaker is nil unless enabled, preserving the historical
+	// fail-through behavior by default
+	circuitBreaker *CircuitBreaker
+)
+
+// EnableCircuitBreaker turns the package-level breaker on for all
+// requests issued through Request/JSONRequest and JsonClient
+func EnableCircuitBreaker(config CircuitBreakerConfig) {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	circuitBreaker = NewCircuitBreaker(config)
+}
+
+// DisableCircuitBreaker turns the package-level breaker off, dropping
+// all tracked state
+func DisableCircuitBreaker() {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	circuitBreaker = nil
+}
+
+func getCircuitBreaker() *CircuitBreaker {
+	circuitBreakerLock.Lock()
+	defer circuitBreakerLock.Unlock()
+	return circuitBreaker
+}
+
+// CircuitBreakerSnapshot exposes the per-host circuit states of the
+// package-level breaker for diagnostics, nil when disabled
+func CircuitBreakerSnapshot() []CircuitState {
+	cb := getCircuitBreaker()
+	if cb == nil {
+		return nil
+	}
+	return cb.Snapshot()
+}
+
+func circuitOpenError(host string) *JSONClientError {
+	ce := JSONClientError{}
+	ce.Code = 503
+	ce.Class = CircuitOpenErrorClass
+	ce.Details = fmt.Sprintf("circuit open for host %s", host)
+	return &ce
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStateMachine(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, CoolDown: 50 * time.Millisecond})
+
+	if !cb.Allow("host1") {
+		t.Fatalf("closed circuit should allow requests")
+	}
+	cb.Record("host1", false)
+	if !cb.Allow("host1") {
+		t.Fatalf("one failure below threshold should not open the circuit")
+	}
+	cb.Record("host1", false)
+	if cb.Allow("host1") {
+		t.Fatalf("circuit should be open after reaching the failure threshold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.Allow("host1") {
+		t.Fatalf("cool-down elapsed, expect a half-open probe")
+	}
+	if cb.Allow("host1") {
+		t.Fatalf("only one probe may be in flight while half-open")
+	}
+	cb.Record("host1", false)
+	if cb.Allow("host1") {
+		t.Fatalf("failed probe should reopen the circuit")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.Allow("host1") {
+		t.Fatalf("cool-down elapsed again, expect another probe")
+	}
+	cb.Record("host1", true)
+	if !cb.Allow("host1") {
+		t.Fatalf("successful probe should close the circuit")
+	}
+
+	// other hosts are tracked independently
+	if !cb.Allow("host2") {
+		t.Fatalf("host2 should not be affected by host1 failures")
+	}
+}
+
+func findCircuitState(states []CircuitState, host string) *CircuitState {
+	for i := range states {
+		if states[i].Host == host {
+			return &states[i]
+		}
+	}
+	return nil
+}
+
+func TestCircuitBreakerWithFlakyServer(t *testing.T) {
+	var healthy int32
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"class": "InternalServerError", "details": "boom"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer ts.Close()
+	host := func() string {
+		u, _ := url.Parse(ts.URL)
+		return u.Host
+	}()
+
+	EnableCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 2, CoolDown: 100 * time.Millisecond})
+	defer DisableCircuitBreaker()
+
+	client := GetDefaultClient()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		_, _, err := JSONRequest(client, ctx, GET, ts.URL, nil, nil, false)
+		if err == nil {
+			t.Fatalf("expect error from unhealthy server")
+		}
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Fatalf("server hits = %d, want 2", got)
+	}
+
+	// circuit is now open, requests fail fast without reaching the server
+	_, _, err := JSONRequest(client, ctx, GET, ts.URL, nil, nil, false)
+	jce, ok := err.(*JSONClientError)
+	if !ok || jce.Class != CircuitOpenErrorClass || jce.Code != 503 {
+		t.Fatalf("expect CircuitOpen 503 error, got %v", err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Fatalf("open circuit should not touch the network, hits = %d", got)
+	}
+	state := findCircuitState(CircuitBreakerSnapshot(), host)
+	if state == nil || state.State != CircuitOpen {
+		t.Fatalf("snapshot should report the circuit open, got %+v", state)
+	}
+
+	// after the cool-down a half-open probe reaches the recovered server
+	// and re-closes the circuit
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(120 * time.Millisecond)
+	if _, _, err := JSONRequest(client, ctx, GET, ts.URL, nil, nil, false); err != nil {
+		t.Fatalf("half-open probe against healthy server: %v", err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 3 {
+		t.Fatalf("probe should reach the server, hits = %d", got)
+	}
+	state = findCircuitState(CircuitBreakerSnapshot(), host)
+	if state == nil || state.State != CircuitClosed || state.ConsecutiveFailures != 0 {
+		t.Fatalf("snapshot should report the circuit closed, got %+v", state)
+	}
+}
+
+func TestCircuitBreakerDisabledByDefault(t *testing.T) {
+	if CircuitBreakerSnapshot() != nil {
+		t.Fatalf("breaker should be off unless explicitly enabled")
+	}
+}
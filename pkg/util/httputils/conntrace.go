@@ -0,0 +1,109 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"time"
+)
+
+// sConnTraceInfo collects what the httptrace hooks observe while one
+// request is in flight: whether the transport reused a kept-alive
+// connection, and how long name resolution and the TLS handshake took
+type sConnTraceInfo struct {
+	gotConn bool
+	// reused is true when the request ran on a kept-alive connection
+	reused bool
+	// idleTime is how long the reused connection sat idle in the pool
+	idleTime    time.Duration
+	dnsDuration time.Duration
+	tlsDuration time.Duration
+	tlsVersion  string
+	tlsCipher   string
+}
+
+// withConnTrace installs httptrace hooks on the request filling info as
+// the transport works. Callers only pay for the hooks when debug output
+// or metrics actually consume them.
+func withConnTrace(req *http.Request) (*http.Request, *sConnTraceInfo) {
+	info := &sConnTraceInfo{}
+	var dnsStart, tlsStart time.Time
+	connTrace := &httptrace.ClientTrace{
+		GotConn: func(ci httptrace.GotConnInfo) {
+			info.gotConn = true
+			info.reused = ci.Reused
+			info.idleTime = ci.IdleTime
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				info.dnsDuration = time.Since(dnsStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if !tlsStart.IsZero() {
+				info.tlsDuration = time.Since(tlsStart)
+			}
+			if err == nil {
+				info.tlsVersion = tlsVersionName(state.Version)
+				info.tlsCipher = tls.CipherSuiteName(state.CipherSuite)
+			}
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), connTrace)), info
+}
+
+// String renders the trace for the debug sink, e.g.
+// "Connection: reused=true idle=1.2s tls=TLS1.3 cipher=TLS_AES_128_GCM_SHA256"
+func (info *sConnTraceInfo) String() string {
+	parts := []string{fmt.Sprintf("reused=%v", info.reused)}
+	if info.reused && info.idleTime > 0 {
+		parts = append(parts, fmt.Sprintf("idle=%s", info.idleTime.Round(time.Millisecond)))
+	}
+	if info.dnsDuration > 0 {
+		parts = append(parts, fmt.Sprintf("dns=%s", info.dnsDuration.Round(time.Millisecond)))
+	}
+	if info.tlsDuration > 0 {
+		parts = append(parts, fmt.Sprintf("tls_handshake=%s", info.tlsDuration.Round(time.Millisecond)))
+	}
+	if len(info.tlsVersion) > 0 {
+		parts = append(parts, fmt.Sprintf("tls=%s", info.tlsVersion), fmt.Sprintf("cipher=%s", info.tlsCipher))
+	}
+	return "Connection: " + strings.Join(parts, " ")
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	default:
+		return fmt.Sprintf("0x%04x", version)
+	}
+}
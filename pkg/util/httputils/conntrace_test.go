@@ -0,0 +1,102 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnTraceReuse(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	var metrics []RequestMetric
+	RegisterRequestMetricsCallback(func(m RequestMetric) {
+		metrics = append(metrics, m)
+	})
+	defer RegisterRequestMetricsCallback(nil)
+
+	// the server's client trusts its self-signed certificate and keeps
+	// connections alive between the two requests
+	client := server.Client()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		resp, err := Request(client, ctx, GET, server.URL, nil, nil, false)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	if len(metrics) != 2 {
+		t.Fatalf("expect 2 metrics, got %d", len(metrics))
+	}
+	first, second := metrics[0], metrics[1]
+	if first.ConnReused {
+		t.Errorf("first request must dial a fresh connection")
+	}
+	if first.TLSDuration <= 0 {
+		t.Errorf("first request must report a TLS handshake duration")
+	}
+	if !strings.HasPrefix(first.TLSVersion, "TLS") {
+		t.Errorf("TLS version = %q", first.TLSVersion)
+	}
+	if len(first.TLSCipher) == 0 {
+		t.Errorf("first request must report the negotiated cipher")
+	}
+	if !second.ConnReused {
+		t.Errorf("second request must reuse the kept-alive connection")
+	}
+	if second.TLSDuration != 0 {
+		t.Errorf("reused connection must not handshake again, got %s", second.TLSDuration)
+	}
+}
+
+func TestConnTraceInfoString(t *testing.T) {
+	fresh := sConnTraceInfo{
+		gotConn:     true,
+		dnsDuration: 3 * time.Millisecond,
+		tlsDuration: 12 * time.Millisecond,
+		tlsVersion:  "TLS1.3",
+		tlsCipher:   "TLS_AES_128_GCM_SHA256",
+	}
+	s := fresh.String()
+	for _, want := range []string{"reused=false", "dns=3ms", "tls_handshake=12ms", "tls=TLS1.3", "cipher=TLS_AES_128_GCM_SHA256"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("%q misses %q", s, want)
+		}
+	}
+	reused := sConnTraceInfo{
+		gotConn:  true,
+		reused:   true,
+		idleTime: 1500 * time.Millisecond,
+	}
+	s = reused.String()
+	if !strings.Contains(s, "reused=true") || !strings.Contains(s, "idle=1.5s") {
+		t.Errorf("reused string = %q", s)
+	}
+	if strings.Contains(s, "tls=") {
+		t.Errorf("reused connection must not report a handshake, got %q", s)
+	}
+}
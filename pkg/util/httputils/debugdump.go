@@ -0,0 +1,72 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"net/http"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/gotypes"
+)
+
+const (
+	// maxDebugBodySize caps the request body captured for debug dumps
+	// and curl commands, larger bodies are not replayed
+	maxDebugBodySize = 64 * 1024
+	// maxErrorRequestBody caps the request body head attached to a
+	// JSONClientError on 4xx/5xx responses
+	maxErrorRequestBody = 4 * 1024
+
+	redactedValue = "*REDACTED*"
+)
+
+// sensitiveHeaders are masked in debug dumps so credentials never leak
+// into logs
+var sensitiveHeaders = []string{
+	"Authorization",
+	"X-Auth-Token",
+}
+
+// redactHeaders returns a copy of header with sensitive values masked,
+// leaving the original untouched
+func redactHeaders(header http.Header) http.Header {
+	redacted := http.Header{}
+	for k, vs := range header {
+		for _, v := range vs {
+			redacted.Add(k, v)
+		}
+	}
+	for _, k := range sensitiveHeaders {
+		if len(redacted.Get(k)) > 0 {
+			redacted.Set(k, redactedValue)
+		}
+	}
+	return redacted
+}
+
+// attachRequestBody saves the head of the request body on a 4xx/5xx
+// JSONClientError so error reports carry enough context to reproduce
+// the request. Only called on the debug path
+func attachRequestBody(err error, body jsonutils.JSONObject) {
+	ce, ok := err.(*JSONClientError)
+	if !ok || ce.Code < 400 || gotypes.IsNil(body) {
+		return
+	}
+	bodystr := body.String()
+	if len(bodystr) > maxErrorRequestBody {
+		bodystr = bodystr[:maxErrorRequestBody]
+	}
+	ce.RequestBody = []byte(bodystr)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Authorization", "Bearer secret")
+	header.Set("X-Auth-Token", "gAAAAA")
+	header.Set("Content-Type", "application/json")
+	redacted := redactHeaders(header)
+	if redacted.Get("Authorization") != redactedValue {
+		t.Errorf("expect Authorization redacted, got %q", redacted.Get("Authorization"))
+	}
+	if redacted.Get("X-Auth-Token") != redactedValue {
+		t.Errorf("expect X-Auth-Token redacted, got %q", redacted.Get("X-Auth-Token"))
+	}
+	if redacted.Get("Content-Type") != "application/json" {
+		t.Errorf("expect other headers untouched, got %q", redacted.Get("Content-Type"))
+	}
+	// original must not be modified
+	if header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expect original header untouched, got %q", header.Get("Authorization"))
+	}
+}
+
+func TestAttachRequestBody(t *testing.T) {
+	body := jsonutils.NewDict()
+	body.Set("name", jsonutils.NewString("test"))
+
+	ce := &JSONClientError{Code: 400}
+	attachRequestBody(ce, body)
+	if !strings.Contains(string(ce.RequestBody), "test") {
+		t.Errorf("expect request body attached, got %q", string(ce.RequestBody))
+	}
+
+	ce = &JSONClientError{Code: 302}
+	attachRequestBody(ce, body)
+	if len(ce.RequestBody) > 0 {
+		t.Errorf("expect no request body for non 4xx/5xx, got %q", string(ce.RequestBody))
+	}
+
+	big := jsonutils.NewDict()
+	big.Set("blob", jsonutils.NewString(strings.Repeat("x", 2*maxErrorRequestBody)))
+	ce = &JSONClientError{Code: 500}
+	attachRequestBody(ce, big)
+	if len(ce.RequestBody) != maxErrorRequestBody {
+		t.Errorf("expect request body truncated to %d, got %d", maxErrorRequestBody, len(ce.RequestBody))
+	}
+}
+
+func TestJSONRequestErrorCarriesRequestBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": {"code": 400, "class": "InputParameterError", "details": "bad input"}}`))
+	}))
+	defer ts.Close()
+
+	body := jsonutils.NewDict()
+	body.Set("name", jsonutils.NewString("srv01"))
+
+	_, _, err := JSONRequest(ts.Client(), context.Background(), POST, ts.URL, nil, body, true)
+	if err == nil {
+		t.Fatalf("expect error on 400 response")
+	}
+	ce, ok := err.(*JSONClientError)
+	if !ok {
+		t.Fatalf("expect *JSONClientError, got %T", err)
+	}
+	if !strings.Contains(string(ce.RequestBody), "srv01") {
+		t.Errorf("expect request body on error, got %q", string(ce.RequestBody))
+	}
+
+	// without debug the request body must not be attached
+	_, _, err = JSONRequest(ts.Client(), context.Background(), POST, ts.URL, nil, body, false)
+	ce, ok = err.(*JSONClientError)
+	if !ok {
+		t.Fatalf("expect *JSONClientError, got %T", err)
+	}
+	if len(ce.RequestBody) > 0 {
+		t.Errorf("expect no request body without debug, got %q", string(ce.RequestBody))
+	}
+}
+
+func TestDebugRequestBodyReplayed(t *testing.T) {
+	var received string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, r.ContentLength)
+		r.Body.Read(buf)
+		received = string(buf)
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer ts.Close()
+
+	body := jsonutils.NewDict()
+	body.Set("name", jsonutils.NewString("srv01"))
+
+	// capturing the body for debug output must not consume it
+	_, _, err := JSONRequest(ts.Client(), context.Background(), POST, ts.URL, nil, body, true)
+	if err != nil {
+		t.Fatalf("JSONRequest: %v", err)
+	}
+	if !strings.Contains(received, "srv01") {
+		t.Errorf("expect server to receive the body, got %q", received)
+	}
+}
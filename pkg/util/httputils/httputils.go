@@ -15,6 +15,7 @@
 package httputils
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
@@ -27,17 +28,22 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
 	"github.com/moul/http2curl"
+	"golang.org/x/net/http2"
 
 	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
 	"yunion.io/x/pkg/errors"
 	"yunion.io/x/pkg/gotypes"
 	"yunion.io/x/pkg/trace"
+	"yunion.io/x/pkg/util/version"
 
 	"yunion.io/x/onecloud/pkg/appctx"
+	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
 )
 
 type THttpMethod string
@@ -45,13 +51,15 @@ type THttpMethod string
 const (
 	USER_AGENT = "yunioncloud-go/201708"
 
-	GET    = THttpMethod("GET")
-	HEAD   = THttpMethod("HEAD")
-	POST   = THttpMethod("POST")
-	PUT    = THttpMethod("PUT")
-	PATCH  = THttpMethod("PATCH")
-	DELETE = THttpMethod("DELETE")
-	OPTION = THttpMethod("OPTION")
+	GET     = THttpMethod("GET")
+	HEAD    = THttpMethod("HEAD")
+	POST    = THttpMethod("POST")
+	PUT     = THttpMethod("PUT")
+	PATCH   = THttpMethod("PATCH")
+	DELETE  = THttpMethod("DELETE")
+	OPTIONS = THttpMethod("OPTIONS")
+	// Deprecated: misspelled, use OPTIONS
+	OPTION = OPTIONS
 
 	IdleConnTimeout       = 60
 	TLSHandshakeTimeout   = 10
@@ -63,8 +71,40 @@ var (
 	green  = color.New(color.FgGreen, color.Bold).PrintlnFunc()
 	yellow = color.New(color.FgYellow, color.Bold).PrintlnFunc()
 	cyan   = color.New(color.FgHiCyan, color.Bold).PrintlnFunc()
+
+	customMethodsLock sync.Mutex
+	customMethods     []THttpMethod
 )
 
+// RegisterHttpMethod allows methods beyond the standard set, some
+// providers need nonstandard ones like PROPFIND
+func RegisterHttpMethod(method THttpMethod) {
+	customMethodsLock.Lock()
+	defer customMethodsLock.Unlock()
+	method = THttpMethod(strings.ToUpper(string(method)))
+	for _, m := range customMethods {
+		if m == method {
+			return
+		}
+	}
+	customMethods = append(customMethods, method)
+}
+
+func isValidMethod(method THttpMethod) bool {
+	switch THttpMethod(strings.ToUpper(string(method))) {
+	case GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS:
+		return true
+	}
+	customMethodsLock.Lock()
+	defer customMethodsLock.Unlock()
+	for _, m := range customMethods {
+		if strings.EqualFold(string(m), string(method)) {
+			return true
+		}
+	}
+	return false
+}
+
 type Error struct {
 	Id     string
 	Fields []string
@@ -75,6 +115,18 @@ type JSONClientError struct {
 	Class   string
 	Details string
 	Data    Error
+
+	// RawBody holds the offending response body for debugging when the
+	// error was raised by strict response validation
+	RawBody []byte `json:"-"`
+
+	// RequestBody holds the head of the request body that triggered a
+	// 4xx/5xx response, populated only when debug is enabled
+	RequestBody []byte `json:"-"`
+
+	// RetryAfter is the wait the server asked for before retrying,
+	// parsed from a throttled response, zero when the server gave none
+	RetryAfter time.Duration `json:"-"`
 }
 
 type JSONClientErrorMsg struct {
@@ -160,7 +212,9 @@ func adptiveDial(network, addr string) (net.Conn, error) {
 
 func getTransport(insecure bool, adaptive bool) *http.Transport {
 	tr := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		// indirect so SetClientProxyFunc also reaches clients built
+		// before the call; defaults to ProxyFromEnvironment
+		Proxy: transportProxy,
 		// 一个空闲连接保持连接的时间
 		// IdleConnTimeout is the maximum amount of time an idle
 		// (keep-alive) connection will remain idle before closing
@@ -220,6 +274,65 @@ func getTransport(insecure bool, adaptive bool) *http.Transport {
 	return tr
 }
 
+// TClientProtocol selects how client connections negotiate the HTTP
+// protocol version
+type TClientProtocol string
+
+const (
+	// ProtocolAuto negotiates HTTP/2 over TLS via ALPN and falls back
+	// to HTTP/1.1
+	ProtocolAuto = TClientProtocol("auto")
+	// ProtocolH1 sticks to HTTP/1.1, the historical default
+	ProtocolH1 = TClientProtocol("h1")
+	// ProtocolH2 speaks HTTP/2 with prior knowledge over cleartext
+	// connections (h2c), for internal services that skip TLS
+	ProtocolH2 = TClientProtocol("h2")
+)
+
+// GetTransportWithProtocol returns a transport for the given protocol
+// selection, see GetClientWithProtocol
+func GetTransportWithProtocol(insecure bool, protocol TClientProtocol) http.RoundTripper {
+	return getTransportWithProtocol(insecure, false, protocol)
+}
+
+func getTransportWithProtocol(insecure bool, adaptive bool, protocol TClientProtocol) http.RoundTripper {
+	switch protocol {
+	case ProtocolAuto:
+		// the adaptive dial path uses the deprecated Dial hook which
+		// defeats HTTP/2 negotiation, so it is bypassed here
+		tr := getTransport(insecure, false)
+		tr.ForceAttemptHTTP2 = true
+		return tr
+	case ProtocolH2:
+		return &http2.Transport{
+			AllowHTTP: true,
+			// prior-knowledge h2c: dial plain TCP regardless of the
+			// TLS config the transport hands us
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.DialTimeout(network, addr, 10*time.Second)
+			},
+		}
+	default:
+		return getTransport(insecure, adaptive)
+	}
+}
+
+// GetClientWithProtocol is GetClient with an explicit protocol
+// selection: ProtocolH1 preserves the historical HTTP/1.1 behavior
+// including the adaptive dial path, ProtocolAuto negotiates HTTP/2
+// over TLS and ProtocolH2 speaks h2c against cleartext services
+func GetClientWithProtocol(insecure bool, timeout time.Duration, protocol TClientProtocol) *http.Client {
+	adaptive := false
+	if timeout == 0 && (protocol == ProtocolH1 || protocol == "") {
+		adaptive = true
+	}
+	tr := getTransportWithProtocol(insecure, adaptive, protocol)
+	return &http.Client{
+		Transport: tr,
+		Timeout:   timeout,
+	}
+}
+
 func GetClient(insecure bool, timeout time.Duration) *http.Client {
 	adaptive := false
 	if timeout == 0 {
@@ -262,39 +375,158 @@ func init() {
 	defaultHttpClient = GetClient(true, time.Second*15)
 }
 
+var (
+	userAgentLock    sync.RWMutex
+	defaultUserAgent string
+)
+
+// SetDefaultUserAgent replaces the package-wide User-Agent base; the
+// service identification suffix is still appended when available
+func SetDefaultUserAgent(ua string) {
+	userAgentLock.Lock()
+	defer userAgentLock.Unlock()
+	defaultUserAgent = ua
+}
+
+// composeUserAgent appends the service identification to the base
+// User-Agent, producing e.g. "yunioncloud-go/3.x region/abc123"
+func composeUserAgent(base string, service string, ver string) string {
+	if service == "" {
+		return base
+	}
+	ident := service
+	if ver != "" {
+		ident = fmt.Sprintf("%s/%s", service, ver)
+	}
+	if base == "" {
+		return ident
+	}
+	return fmt.Sprintf("%s %s", base, ident)
+}
+
+// getDefaultUserAgent is the package default User-Agent: the base set
+// via SetDefaultUserAgent (or the legacy USER_AGENT) plus the name and
+// version of the running service when known
+func getDefaultUserAgent() string {
+	userAgentLock.RLock()
+	base := defaultUserAgent
+	userAgentLock.RUnlock()
+	if base == "" {
+		base = USER_AGENT
+	}
+	return composeUserAgent(base, consts.GetServiceType(), version.GetShortString())
+}
+
 func GetDefaultClient() *http.Client {
 	return defaultHttpClient
 }
 
-func Request(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body io.Reader, debug bool) (*http.Response, error) {
-	if client == nil {
-		client = defaultHttpClient
+// nilCtxWarnOnce keeps the nil-context warning from flooding the logs
+// when a buggy caller sits on a hot path
+var nilCtxWarnOnce sync.Once
+
+// ensureContext guards against callers passing a nil context, which
+// used to panic deep inside appctx or net/http
+func ensureContext(ctx context.Context) context.Context {
+	if ctx == nil {
+		nilCtxWarnOnce.Do(func() {
+			log.Warningf("httputils: nil context, falling back to context.Background()")
+		})
+		ctx = context.Background()
 	}
-	if header == nil {
-		header = http.Header{}
+	return ctx
+}
+
+// WithDefaults prepares a context for use at a service entry point: a
+// nil context becomes context.Background(), a request id is generated
+// when the context carries none, and the service name is filled in
+// from the process-wide service type
+func WithDefaults(ctx context.Context) context.Context {
+	ctx = ensureContext(ctx)
+	ctx = appctx.EnsureRequestId(ctx)
+	if len(appctx.AppContextServiceName(ctx)) == 0 {
+		if srvType := consts.GetServiceType(); len(srvType) > 0 {
+			ctx = context.WithValue(ctx, appctx.APP_CONTEXT_KEY_APPNAME, srvType)
+		}
 	}
+	return ctx
+}
+
+// injectRequestHeaders stamps the X-Request-Id and distributed trace
+// headers on an outgoing request, mutating header in place. The
+// returned client trace, when not nil, must be closed with the
+// response headers once they arrive.
+func injectRequestHeaders(ctx context.Context, urlStr string, header http.Header) (*trace.STrace, string, error) {
 	ctxData := appctx.FetchAppContextData(ctx)
 	var clientTrace *trace.STrace
 	if !ctxData.Trace.IsZero() {
 		addr, port, err := GetAddrPort(urlStr)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		clientTrace = trace.StartClientTrace(&ctxData.Trace, addr, port, ctxData.ServiceName)
 		clientTrace.AddClientRequestHeader(header)
 	}
-	if len(ctxData.RequestId) > 0 {
-		header.Set("X-Request-Id", ctxData.RequestId)
+	reqId := ctxData.RequestId
+	if len(reqId) > 0 {
+		// the id came in with a request this service received; append
+		// a hop counter so the call chain is readable in logs
+		reqId = appctx.ForwardRequestId(reqId)
+	} else if reqId = header.Get("X-Request-Id"); len(reqId) == 0 {
+		reqId = appctx.GenerateRequestId()
+	}
+	header.Set("X-Request-Id", reqId)
+	return clientTrace, reqId, nil
+}
+
+func Request(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body io.Reader, debug bool) (*http.Response, error) {
+	if !isValidMethod(method) {
+		return nil, &JSONClientError{
+			Code:    400,
+			Class:   "InvalidMethod",
+			Details: fmt.Sprintf("unsupported http method %q", string(method)),
+		}
+	}
+	if client == nil {
+		client = defaultHttpClient
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	ctx = ensureContext(ctx)
+	clientTrace, reqId, err := injectRequestHeaders(ctx, urlStr, header)
+	if err != nil {
+		return nil, err
+	}
+	var debugBody []byte
+	if debug && body != nil && strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+		// capture small JSON bodies so debug dumps can replay the request
+		if clen, _ := strconv.Atoi(header.Get("Content-Length")); clen > 0 && clen <= maxDebugBodySize {
+			debugBody, _ = ioutil.ReadAll(io.LimitReader(body, maxDebugBodySize))
+			body = bytes.NewReader(debugBody)
+		}
 	}
 	req, err := http.NewRequest(string(method), urlStr, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", USER_AGENT)
+	// bind the caller context so cancellation aborts the in-flight request
+	req = req.WithContext(ctx)
+	var connInfo *sConnTraceInfo
+	if debug || requestMetricsCallback != nil {
+		// the hooks only cost anything when someone consumes the result
+		req, connInfo = withConnTrace(req)
+	}
+	// the default User-Agent never overrides one the caller set
+	if header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", getDefaultUserAgent())
+	}
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Encoding", "*")
 	if body == nil {
-		if method != GET && method != HEAD {
+		// case-insensitive so a lowercase "get" does not pick up a
+		// spurious Content-Length header
+		if !strings.EqualFold(string(method), string(GET)) && !strings.EqualFold(string(method), string(HEAD)) {
 			req.ContentLength = 0
 			req.Header.Set("Content-Length", "0")
 		}
@@ -316,25 +548,79 @@ func Request(client *http.Client, ctx context.Context, method THttpMethod, urlSt
 		}
 	}
 	if debug {
-		dump, _ := httputil.DumpRequestOut(req, false)
+		dumpReq := req.Clone(req.Context())
+		dumpReq.Header = redactHeaders(req.Header)
+		dumpReq.Body = nil
+		dumpReq.GetBody = nil
+		dump, _ := httputil.DumpRequestOut(dumpReq, false)
 		yellow(string(dump))
 		// 忽略掉上传文件的请求,避免大量日志输出
 		if header.Get("Content-Type") != "application/octet-stream" {
-			curlCmd, _ := http2curl.GetCurlCommand(req)
+			if len(debugBody) > 0 {
+				dumpReq.Body = ioutil.NopCloser(bytes.NewReader(debugBody))
+			}
+			curlCmd, _ := http2curl.GetCurlCommand(dumpReq)
 			cyan("CURL:", curlCmd, "\n")
 		}
 	}
+	breaker := getCircuitBreaker()
+	if breaker != nil && !breaker.Allow(req.URL.Host) {
+		return nil, circuitOpenError(req.URL.Host)
+	}
+	startTime := time.Now()
 	resp, err := client.Do(req)
+	if breaker != nil {
+		breaker.Record(req.URL.Host, err == nil && resp.StatusCode < 500)
+	}
 	if err != nil {
 		red(err.Error())
 	}
+	if debug && err == nil {
+		cyan("Negotiated protocol:", resp.Proto, "\n")
+		if connInfo != nil && connInfo.gotConn {
+			cyan(connInfo.String(), "\n")
+		}
+	}
+	if requestMetricsCallback != nil {
+		metric := RequestMetric{
+			Method:      string(method),
+			Host:        req.URL.Host,
+			Path:        NormalizeMetricPath(req.URL.Path),
+			Duration:    time.Since(startTime),
+			RequestSize: req.ContentLength,
+		}
+		if connInfo != nil && connInfo.gotConn {
+			metric.ConnReused = connInfo.reused
+			metric.ConnIdleTime = connInfo.idleTime
+			metric.DNSDuration = connInfo.dnsDuration
+			metric.TLSDuration = connInfo.tlsDuration
+			metric.TLSVersion = connInfo.tlsVersion
+			metric.TLSCipher = connInfo.tlsCipher
+		}
+		if err != nil {
+			metric.ErrorClass = "network"
+		} else {
+			metric.StatusCode = resp.StatusCode
+			metric.ResponseSize = resp.ContentLength
+			metric.Proto = resp.Proto
+			if resp.StatusCode >= 400 {
+				metric.ErrorClass = http.StatusText(resp.StatusCode)
+			}
+		}
+		reportRequestMetric(metric)
+	}
 	if err == nil && clientTrace != nil {
 		clientTrace.EndClientTraceHeader(resp.Header)
 	}
+	if err == nil && len(resp.Header.Get("X-Request-Id")) == 0 {
+		// echo the id so callers can log it even when the server does
+		// not reflect the header
+		resp.Header.Set("X-Request-Id", reqId)
+	}
 	return resp, err
 }
 
-func JSONRequest(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body jsonutils.JSONObject, debug bool) (http.Header, jsonutils.JSONObject, error) {
+func jsonRequest(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body jsonutils.JSONObject, debug bool) (*http.Response, error) {
 	var bodystr string
 	if !gotypes.IsNil(body) {
 		bodystr = body.String()
@@ -345,8 +631,16 @@ func JSONRequest(client *http.Client, ctx context.Context, method THttpMethod, u
 	}
 	header.Set("Content-Length", strconv.FormatInt(int64(len(bodystr)), 10))
 	header.Set("Content-Type", "application/json")
-	resp, err := Request(client, ctx, method, urlStr, header, jbody, debug)
-	return ParseJSONResponse(resp, err, debug)
+	return Request(client, ctx, method, urlStr, header, jbody, debug)
+}
+
+func JSONRequest(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body jsonutils.JSONObject, debug bool) (http.Header, jsonutils.JSONObject, error) {
+	resp, err := jsonRequest(client, ctx, method, urlStr, header, body, debug)
+	hdr, jbody, err := ParseJSONResponse(resp, err, debug)
+	if err != nil && debug {
+		attachRequestBody(err, body)
+	}
+	return hdr, jbody, err
 }
 
 // closeResponse close non nil response with any response Body.
@@ -371,6 +665,10 @@ func CloseResponse(resp *http.Response) {
 
 func ParseResponse(resp *http.Response, err error, debug bool) (http.Header, []byte, error) {
 	if err != nil {
+		// preserve structured errors such as an open circuit
+		if jce, ok := err.(*JSONClientError); ok {
+			return nil, nil, jce
+		}
 		ce := JSONClientError{}
 		ce.Code = 499
 		ce.Details = err.Error()
@@ -415,6 +713,10 @@ func ParseResponse(resp *http.Response, err error, debug bool) (http.Header, []b
 
 func ParseJSONResponse(resp *http.Response, err error, debug bool) (http.Header, jsonutils.JSONObject, error) {
 	if err != nil {
+		// preserve structured errors such as an open circuit
+		if jce, ok := err.(*JSONClientError); ok {
+			return nil, nil, jce
+		}
 		ce := JSONClientError{}
 		ce.Code = 499
 		ce.Details = err.Error()
@@ -465,11 +767,13 @@ func ParseJSONResponse(resp *http.Response, err error, debug bool) (http.Header,
 			if len(rbody) > 0 {
 				ce.Details = string(rbody)
 			}
+			markThrottled(&ce, resp.Header)
 			return nil, nil, &ce
 		}
 
 		err = jrbody.Unmarshal(&ce)
 		if len(ce.Class) > 0 && ce.Code >= 400 && len(ce.Details) > 0 {
+			markThrottled(&ce, resp.Header)
 			return nil, nil, &ce
 		}
 
@@ -479,6 +783,7 @@ func ParseJSONResponse(resp *http.Response, err error, debug bool) (http.Header,
 			if err != nil {
 				ce.Details = err.Error()
 			}
+			markThrottled(&ce, resp.Header)
 			return nil, nil, &ce
 		}
 		var jrbody2 jsonutils.JSONObject
@@ -506,6 +811,7 @@ func ParseJSONResponse(resp *http.Response, err error, debug bool) (http.Header,
 		if eclass := jsonutils.GetAnyString(jrbody2, []string{"title", "type", "error_code"}); len(eclass) > 0 {
 			ce.Class = eclass
 		}
+		markThrottled(&ce, resp.Header)
 		return nil, nil, &ce
 	}
 }
@@ -0,0 +1,203 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+	"yunion.io/x/pkg/utils"
+)
+
+// JsonClient wraps an http.Client with optional per-session state such
+// as a cookie jar, so callers talking to appliances that require login
+// session cookies can reuse one client across requests
+type JsonClient struct {
+	client *http.Client
+
+	visitedLock sync.Mutex
+	visitedUrls []string
+
+	middlewares []Middleware
+
+	userAgent string
+}
+
+const (
+	ErrTooManyRedirects = errors.Error("Too many redirects")
+	ErrRedirectLoop     = errors.Error("Redirect loop")
+)
+
+type JsonClientOption func(client *JsonClient)
+
+// WithCookieJar attaches a cookie jar to the client. Cookies set by the
+// server (e.g. on a login call) are carried on subsequent requests.
+// The standard http.Client keeps cookies across redirects and strips
+// them together with other sensitive headers when a redirect leaves
+// the original host.
+func WithCookieJar(jar http.CookieJar) JsonClientOption {
+	return func(client *JsonClient) {
+		client.client.Jar = jar
+	}
+}
+
+// WithRedirectPolicy controls how the client treats 3xx responses.
+// With follow disabled the response is returned as is, matching the
+// behavior callers get from ParseJSONResponse today. With follow
+// enabled redirects are chased up to maxHops hops, the Authorization
+// header is stripped when a redirect crosses hosts, and the chain of
+// visited URLs is recorded for debug output.
+func WithRedirectPolicy(follow bool, maxHops int) JsonClientOption {
+	return func(client *JsonClient) {
+		if !follow {
+			client.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+			return
+		}
+		client.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxHops {
+				return errors.Wrapf(ErrTooManyRedirects, "stopped after %d redirect hops", maxHops)
+			}
+			visited := make([]string, 0, len(via)+1)
+			for _, r := range via {
+				visited = append(visited, r.URL.String())
+			}
+			if utils.IsInStringArray(req.URL.String(), visited) {
+				return errors.Wrapf(ErrRedirectLoop, "redirect loop detected at %s", req.URL.String())
+			}
+			if req.URL.Host != via[0].URL.Host {
+				// never leak credentials to a different host
+				req.Header.Del("Authorization")
+			}
+			client.recordVisited(append(visited, req.URL.String()))
+			log.Debugf("redirect chain: %v", visited)
+			return nil
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent used for every request of this
+// client unless the request itself carries one
+func WithUserAgent(ua string) JsonClientOption {
+	return func(client *JsonClient) {
+		client.userAgent = ua
+	}
+}
+
+func (client *JsonClient) recordVisited(urls []string) {
+	client.visitedLock.Lock()
+	defer client.visitedLock.Unlock()
+	client.visitedUrls = urls
+}
+
+// VisitedUrls returns the redirect chain of the most recent request
+// that was redirected
+func (client *JsonClient) VisitedUrls() []string {
+	client.visitedLock.Lock()
+	defer client.visitedLock.Unlock()
+	return client.visitedUrls
+}
+
+func NewJsonClient(client *http.Client, opts ...JsonClientOption) *JsonClient {
+	if client == nil {
+		client = GetDefaultClient()
+	}
+	// shallow copy so options do not mutate a shared client
+	clientCopy := *client
+	jc := &JsonClient{client: &clientCopy}
+	for _, opt := range opts {
+		opt(jc)
+	}
+	return jc
+}
+
+// NewJsonClientWithJar returns a JsonClient backed by a fresh in-memory
+// cookie jar
+func NewJsonClientWithJar(client *http.Client) (*JsonClient, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewJsonClient(client, WithCookieJar(jar)), nil
+}
+
+func (client *JsonClient) HttpClient() *http.Client {
+	return client.client
+}
+
+func (client *JsonClient) Request(ctx context.Context, method THttpMethod, urlStr string, header http.Header, body io.Reader, debug bool) (*http.Response, error) {
+	if client.userAgent != "" {
+		if header == nil {
+			header = http.Header{}
+		}
+		if header.Get("User-Agent") == "" {
+			header.Set("User-Agent", client.userAgent)
+		}
+	}
+	return Request(client.client, ctx, method, urlStr, header, body, debug)
+}
+
+func (client *JsonClient) JSONRequest(ctx context.Context, method THttpMethod, urlStr string, header http.Header, body jsonutils.JSONObject, debug bool) (http.Header, jsonutils.JSONObject, error) {
+	return client.Send(ctx, &JsonRequest{
+		Method: method,
+		Url:    urlStr,
+		Header: header,
+		Body:   body,
+	}, debug)
+}
+
+// Cookies returns the cookies the jar would send to the given URL
+func (client *JsonClient) Cookies(urlStr string) ([]*http.Cookie, error) {
+	if client.client.Jar == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	return client.client.Jar.Cookies(u), nil
+}
+
+// ClearCookies expires all cookies stored for the given URL
+func (client *JsonClient) ClearCookies(urlStr string) error {
+	if client.client.Jar == nil {
+		return nil
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return err
+	}
+	cookies := client.client.Jar.Cookies(u)
+	expired := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		expired = append(expired, &http.Cookie{
+			Name:    c.Name,
+			Value:   "",
+			MaxAge:  -1,
+			Expires: time.Unix(1, 0),
+		})
+	}
+	client.client.Jar.SetCookies(u, expired)
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/jsonutils"
+)
+
+// sCacheEntry is one cached GET response together with the metadata
+// needed for expiry and conditional revalidation
+type sCacheEntry struct {
+	key       string
+	header    http.Header
+	body      jsonutils.JSONObject
+	etag      string
+	expiresAt time.Time
+}
+
+// ResponseCache is a bounded LRU of parsed JSON responses of idempotent
+// GETs, keyed by URL plus a configured header subset. It is safe for
+// concurrent use by multiple clients.
+type ResponseCache struct {
+	lock       sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	headerKeys []string
+	entries    map[string]*list.Element
+	lru        *list.List
+
+	hits   int64
+	misses int64
+}
+
+// NewResponseCache returns a cache holding at most maxEntries responses
+// for ttl each unless the server dictates otherwise via Cache-Control.
+// headerKeys name the request headers that partition the cache, e.g.
+// X-Auth-Token so users never see each other's cached responses.
+func NewResponseCache(maxEntries int, ttl time.Duration, headerKeys ...string) *ResponseCache {
+	return &ResponseCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		headerKeys: headerKeys,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Stats returns how many lookups were served from the cache and how
+// many went to the server
+func (cache *ResponseCache) Stats() (hits int64, misses int64) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.hits, cache.misses
+}
+
+func (cache *ResponseCache) cacheKey(urlStr string, header http.Header) string {
+	parts := []string{urlStr}
+	for _, key := range cache.headerKeys {
+		value := ""
+		if header != nil {
+			value = header.Get(key)
+		}
+		parts = append(parts, key+"="+value)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// get returns the entry for key if any, promoting it in the LRU. fresh
+// reports whether the entry may be served without revalidation.
+func (cache *ResponseCache) get(key string) (*sCacheEntry, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		cache.misses++
+		return nil, false
+	}
+	cache.lru.MoveToFront(element)
+	entry := element.Value.(*sCacheEntry)
+	if time.Now().Before(entry.expiresAt) {
+		cache.hits++
+		return entry, true
+	}
+	cache.misses++
+	return entry, false
+}
+
+func (cache *ResponseCache) put(entry *sCacheEntry) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if element, ok := cache.entries[entry.key]; ok {
+		element.Value = entry
+		cache.lru.MoveToFront(element)
+		return
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+	for cache.maxEntries > 0 && cache.lru.Len() > cache.maxEntries {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*sCacheEntry).key)
+	}
+}
+
+// refresh extends the lifetime of a revalidated entry
+func (cache *ResponseCache) refresh(entry *sCacheEntry, ttl time.Duration) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	entry.expiresAt = time.Now().Add(ttl)
+}
+
+// responseTTL derives an entry lifetime from the response Cache-Control
+// max-age, falling back to the cache default
+func (cache *ResponseCache) responseTTL(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if seconds, err := strconv.Atoi(directive[len("max-age="):]); err == nil && seconds >= 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return cache.ttl
+}
+
+// cacheable reports whether a successful response may be stored: never
+// cache session state (Set-Cookie) or responses the server marked as
+// not cacheable
+func cacheable(header http.Header) bool {
+	if len(header.Get("Set-Cookie")) > 0 {
+		return false
+	}
+	cc := strings.ToLower(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if strings.Contains(cc, directive) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewResponseCacheMiddleware serves idempotent GETs from cache. Fresh
+// entries short-circuit the request entirely; expired entries with an
+// ETag are revalidated with If-None-Match, turning a 304 into the
+// cached body. Error responses are never cached and requests with
+// BypassCache set go straight to the server.
+func NewResponseCacheMiddleware(cache *ResponseCache) Middleware {
+	return func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			if req.Method != GET || req.BypassCache {
+				return next(ctx, req)
+			}
+			key := cache.cacheKey(req.Url, req.Header)
+			entry, fresh := cache.get(key)
+			if fresh {
+				return entry.header, entry.body, nil
+			}
+			if entry != nil && len(entry.etag) > 0 {
+				if req.Header == nil {
+					req.Header = http.Header{}
+				}
+				req.Header.Set("If-None-Match", entry.etag)
+			}
+			header, body, err := next(ctx, req)
+			if err != nil {
+				if entry != nil && ErrorCode(err) == http.StatusNotModified {
+					cache.refresh(entry, cache.ttl)
+					return entry.header, entry.body, nil
+				}
+				return header, body, err
+			}
+			if cacheable(header) {
+				cache.put(&sCacheEntry{
+					key:       key,
+					header:    header,
+					body:      body,
+					etag:      header.Get("ETag"),
+					expiresAt: time.Now().Add(cache.responseTTL(header)),
+				})
+			}
+			return header, body, err
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingCatalogServer serves a small JSON document, counting requests
+// and optionally honoring If-None-Match with a 304
+type countingCatalogServer struct {
+	lock     sync.Mutex
+	requests int
+	etag     string
+	header   http.Header
+}
+
+func (s *countingCatalogServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.requests++
+	s.lock.Unlock()
+	if len(s.etag) > 0 {
+		if r.Header.Get("If-None-Match") == s.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", s.etag)
+	}
+	for key, values := range s.header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"catalog": "v1"}`)
+}
+
+func (s *countingCatalogServer) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func newCachingClient(cache *ResponseCache) *JsonClient {
+	client := NewJsonClient(&http.Client{})
+	client.Use(NewResponseCacheMiddleware(cache))
+	return client
+}
+
+func mustGet(t *testing.T, client *JsonClient, urlStr string, header http.Header, bypass bool) string {
+	t.Helper()
+	_, body, err := client.Send(context.Background(), &JsonRequest{
+		Method:      GET,
+		Url:         urlStr,
+		Header:      header,
+		BypassCache: bypass,
+	}, false)
+	if err != nil {
+		t.Fatalf("GET %s: %v", urlStr, err)
+	}
+	result, _ := body.GetString("catalog")
+	return result
+}
+
+func TestResponseCacheHitAndTTLExpiry(t *testing.T) {
+	backend := &countingCatalogServer{}
+	srv := httptest.NewServer(http.HandlerFunc(backend.handle))
+	defer srv.Close()
+
+	cache := NewResponseCache(16, 100*time.Millisecond)
+	client := newCachingClient(cache)
+
+	mustGet(t, client, srv.URL, nil, false)
+	mustGet(t, client, srv.URL, nil, false)
+	if backend.count() != 1 {
+		t.Errorf("expect second GET served from cache, server saw %d requests", backend.count())
+	}
+	if hits, misses := cache.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("stats = %d hits %d misses, want 1/1", hits, misses)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	mustGet(t, client, srv.URL, nil, false)
+	if backend.count() != 2 {
+		t.Errorf("expect expired entry refetched, server saw %d requests", backend.count())
+	}
+}
+
+func TestResponseCacheEtagRevalidation(t *testing.T) {
+	backend := &countingCatalogServer{etag: `"v1"`}
+	srv := httptest.NewServer(http.HandlerFunc(backend.handle))
+	defer srv.Close()
+
+	cache := NewResponseCache(16, 50*time.Millisecond)
+	client := newCachingClient(cache)
+
+	mustGet(t, client, srv.URL, nil, false)
+	time.Sleep(80 * time.Millisecond)
+	if got := mustGet(t, client, srv.URL, nil, false); got != "v1" {
+		t.Errorf("revalidated body = %q, want v1", got)
+	}
+	if backend.count() != 2 {
+		t.Errorf("expect a conditional refetch, server saw %d requests", backend.count())
+	}
+	// the 304 refreshed the entry, the next GET is a plain hit
+	mustGet(t, client, srv.URL, nil, false)
+	if backend.count() != 2 {
+		t.Errorf("expect refreshed entry served from cache, server saw %d requests", backend.count())
+	}
+}
+
+func TestResponseCacheBypassFlag(t *testing.T) {
+	backend := &countingCatalogServer{}
+	srv := httptest.NewServer(http.HandlerFunc(backend.handle))
+	defer srv.Close()
+
+	cache := NewResponseCache(16, time.Minute)
+	client := newCachingClient(cache)
+
+	mustGet(t, client, srv.URL, nil, false)
+	mustGet(t, client, srv.URL, nil, true)
+	if backend.count() != 2 {
+		t.Errorf("expect bypass to reach the server, saw %d requests", backend.count())
+	}
+}
+
+func TestResponseCacheSkipsSetCookieAndErrors(t *testing.T) {
+	backend := &countingCatalogServer{header: http.Header{"Set-Cookie": []string{"session=abc"}}}
+	srv := httptest.NewServer(http.HandlerFunc(backend.handle))
+	defer srv.Close()
+
+	cache := NewResponseCache(16, time.Minute)
+	client := newCachingClient(cache)
+	mustGet(t, client, srv.URL, nil, false)
+	mustGet(t, client, srv.URL, nil, false)
+	if backend.count() != 2 {
+		t.Errorf("Set-Cookie response must not be cached, server saw %d requests", backend.count())
+	}
+
+	failures := 0
+	failSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures++
+		httpError(w, http.StatusInternalServerError)
+	}))
+	defer failSrv.Close()
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.Send(context.Background(), &JsonRequest{Method: GET, Url: failSrv.URL}, false); err == nil {
+			t.Fatalf("expect error response")
+		}
+	}
+	if failures != 2 {
+		t.Errorf("error responses must not be cached, server saw %d requests", failures)
+	}
+}
+
+func TestResponseCacheLRUBoundAndHeaderPartition(t *testing.T) {
+	backend := &countingCatalogServer{}
+	srv := httptest.NewServer(http.HandlerFunc(backend.handle))
+	defer srv.Close()
+
+	cache := NewResponseCache(2, time.Minute, "X-Auth-Token")
+	client := newCachingClient(cache)
+
+	// three distinct URLs through a 2-entry cache evict the oldest
+	for _, path := range []string{"/a", "/b", "/c", "/a"} {
+		mustGet(t, client, srv.URL+path, nil, false)
+	}
+	if backend.count() != 4 {
+		t.Errorf("expect /a evicted and refetched, server saw %d requests", backend.count())
+	}
+
+	// different tokens never share an entry
+	before := backend.count()
+	mustGet(t, client, srv.URL+"/t", http.Header{"X-Auth-Token": []string{"user1"}}, false)
+	mustGet(t, client, srv.URL+"/t", http.Header{"X-Auth-Token": []string{"user2"}}, false)
+	if backend.count() != before+2 {
+		t.Errorf("expect per-token entries, server saw %d extra requests", backend.count()-before)
+	}
+}
+
+func httpError(w http.ResponseWriter, code int) {
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"code": %d, "class": "InternalServerError", "details": "boom"}`, code)
+}
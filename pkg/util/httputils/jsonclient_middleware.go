@@ -0,0 +1,141 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/log"
+)
+
+// JsonRequest describes one JSON API call passing through the
+// middleware chain. Middlewares may mutate it before it is sent; later
+// layers see the mutated request.
+type JsonRequest struct {
+	Method THttpMethod
+	Url    string
+	Header http.Header
+	Body   jsonutils.JSONObject
+
+	// UserAgent overrides the client User-Agent for this request; an
+	// explicit User-Agent header still takes precedence
+	UserAgent string
+
+	// BypassCache skips the response cache for this request when a
+	// cache middleware is installed
+	BypassCache bool
+}
+
+// RoundFunc performs one JSON request and returns the response
+type RoundFunc func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error)
+
+// Middleware wraps a RoundFunc with extra behavior, e.g. signing or
+// logging. It may short-circuit by not calling next.
+type Middleware func(next RoundFunc) RoundFunc
+
+// Use appends middlewares to the chain. They run in registration
+// order: the first registered middleware is the outermost layer around
+// Send.
+func (client *JsonClient) Use(middlewares ...Middleware) {
+	client.middlewares = append(client.middlewares, middlewares...)
+}
+
+// applyUserAgent resolves the User-Agent of one request: an explicit
+// header wins over the request override, which wins over the client
+// default; the package default is applied later in Request
+func (client *JsonClient) applyUserAgent(req *JsonRequest) {
+	ua := req.UserAgent
+	if ua == "" {
+		ua = client.userAgent
+	}
+	if ua == "" {
+		return
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", ua)
+	}
+}
+
+// Send performs a JSON request through the middleware chain
+func (client *JsonClient) Send(ctx context.Context, req *JsonRequest, debug bool) (http.Header, jsonutils.JSONObject, error) {
+	// middlewares consume the context before Request gets to guard it
+	ctx = ensureContext(ctx)
+	client.applyUserAgent(req)
+	round := func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+		return JSONRequest(client.client, ctx, req.Method, req.Url, req.Header, req.Body, debug)
+	}
+	for i := len(client.middlewares) - 1; i >= 0; i-- {
+		round = client.middlewares[i](round)
+	}
+	return round(ctx, req)
+}
+
+// NewHmacSignMiddleware signs every request with HMAC-SHA256 over
+// method, path and date into the Authorization header, setting the
+// Date header when absent
+func NewHmacSignMiddleware(keyId string, secret string) Middleware {
+	return func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			if req.Header == nil {
+				req.Header = http.Header{}
+			}
+			date := req.Header.Get("Date")
+			if date == "" {
+				date = time.Now().UTC().Format(http.TimeFormat)
+				req.Header.Set("Date", date)
+			}
+			u, err := url.Parse(req.Url)
+			if err != nil {
+				return nil, nil, err
+			}
+			mac := hmac.New(sha256.New, []byte(secret))
+			fmt.Fprintf(mac, "%s\n%s\n%s", req.Method, u.Path, date)
+			sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			req.Header.Set("Authorization", fmt.Sprintf("HMAC %s:%s", keyId, sig))
+			return next(ctx, req)
+		}
+	}
+}
+
+// NewRequestIdLogMiddleware logs the X-Request-Id of every request
+// together with its outcome
+func NewRequestIdLogMiddleware() Middleware {
+	return func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			reqId := ""
+			if req.Header != nil {
+				reqId = req.Header.Get("X-Request-Id")
+			}
+			hdr, body, err := next(ctx, req)
+			if err != nil {
+				log.Errorf("request %s %s [%s] failed: %v", req.Method, req.Url, reqId, err)
+			} else {
+				log.Debugf("request %s %s [%s] ok", req.Method, req.Url, reqId)
+			}
+			return hdr, body, err
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestMiddlewareOrderingAndMutation(t *testing.T) {
+	var serverHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverHeader = r.Header.Clone()
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next RoundFunc) RoundFunc {
+			return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+				order = append(order, name+"-pre")
+				if req.Header == nil {
+					req.Header = http.Header{}
+				}
+				// later layers must see this mutation
+				req.Header.Add("X-Chain", name)
+				hdr, body, err := next(ctx, req)
+				order = append(order, name+"-post")
+				return hdr, body, err
+			}
+		}
+	}
+
+	client := NewJsonClient(nil)
+	client.Use(tag("first"), tag("second"))
+
+	_, _, err := client.JSONRequest(context.Background(), GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	want := []string{"first-pre", "second-pre", "second-post", "first-post"}
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected middleware order %v, want %v", order, want)
+	}
+	if got := serverHeader.Values("X-Chain"); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Fatalf("mutations must accumulate through the chain, got %v", got)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	serverCalled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverCalled = true
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	cached := jsonutils.NewDict()
+	cached.Add(jsonutils.JSONTrue, "cached")
+	client := NewJsonClient(nil)
+	client.Use(func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			return nil, cached, nil
+		}
+	})
+
+	_, body, err := client.JSONRequest(context.Background(), GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if ok, _ := body.Bool("cached"); !ok {
+		t.Fatalf("expect cached response, got %s", body)
+	}
+	if serverCalled {
+		t.Fatalf("short-circuit middleware must not reach the server")
+	}
+}
+
+func TestHmacSignMiddleware(t *testing.T) {
+	var gotAuth, gotDate string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotDate = r.Header.Get("Date")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client := NewJsonClient(nil)
+	client.Use(NewHmacSignMiddleware("ak", "secret"))
+	if _, _, err := client.JSONRequest(context.Background(), GET, server.URL+"/servers", nil, nil, false); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if !strings.HasPrefix(gotAuth, "HMAC ak:") {
+		t.Fatalf("unexpected Authorization %q", gotAuth)
+	}
+	if gotDate == "" {
+		t.Fatalf("Date header must be set for the signature")
+	}
+}
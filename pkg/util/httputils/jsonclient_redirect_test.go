@@ -0,0 +1,112 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJsonClientRedirectSameHost(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data": "value"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewJsonClient(GetDefaultClient(), WithRedirectPolicy(true, 5))
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	_, body, err := client.JSONRequest(context.Background(), GET, server.URL+"/old", header, nil, false)
+	if err != nil {
+		t.Fatalf("same host redirect should keep Authorization: %v", err)
+	}
+	if data, _ := body.GetString("data"); data != "value" {
+		t.Fatalf("unexpected body %s", body)
+	}
+	visited := client.VisitedUrls()
+	if len(visited) != 2 {
+		t.Fatalf("expect 2 visited urls, got %v", visited)
+	}
+}
+
+func TestJsonClientRedirectCrossHost(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": 400, "class": "BadRequest", "details": "leaked authorization"}}`))
+			return
+		}
+		w.Write([]byte(`{"data": "value"}`))
+	}))
+	defer target.Close()
+
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer source.Close()
+
+	client := NewJsonClient(GetDefaultClient(), WithRedirectPolicy(true, 5))
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	if _, _, err := client.JSONRequest(context.Background(), GET, source.URL, header, nil, false); err != nil {
+		t.Fatalf("cross host redirect should strip Authorization: %v", err)
+	}
+}
+
+func TestJsonClientRedirectLoop(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/a", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewJsonClient(GetDefaultClient(), WithRedirectPolicy(true, 10))
+	if _, _, err := client.JSONRequest(context.Background(), GET, server.URL+"/a", nil, nil, false); err == nil {
+		t.Fatalf("redirect loop should be detected")
+	}
+}
+
+func TestJsonClientRedirectDisabled(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewJsonClient(GetDefaultClient(), WithRedirectPolicy(false, 0))
+	_, _, err := client.JSONRequest(context.Background(), GET, server.URL+"/old", nil, nil, false)
+	if err == nil {
+		t.Fatalf("disabled redirects should surface the 3xx as error")
+	}
+	if jerr, ok := err.(*JSONClientError); !ok || jerr.Class != "redirect" {
+		t.Fatalf("expect redirect error class, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJsonClientCookieJar(t *testing.T) {
+	const sessionCookie = "session_id"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "secret", Path: "/"})
+		w.Write([]byte(`{"status": "ok"}`))
+	})
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(sessionCookie)
+		if err != nil || c.Value != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"code": 401, "class": "Unauthorized", "details": "no session"}}`))
+			return
+		}
+		w.Write([]byte(`{"data": "value"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewJsonClientWithJar(GetDefaultClient())
+	if err != nil {
+		t.Fatalf("NewJsonClientWithJar: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, _, err := client.JSONRequest(ctx, GET, server.URL+"/login", nil, nil, false); err != nil {
+		t.Fatalf("login request: %v", err)
+	}
+
+	cookies, err := client.Cookies(server.URL)
+	if err != nil {
+		t.Fatalf("Cookies: %v", err)
+	}
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie {
+		t.Fatalf("expect session cookie in jar, got %v", cookies)
+	}
+
+	if _, _, err := client.JSONRequest(ctx, GET, server.URL+"/data", nil, nil, false); err != nil {
+		t.Fatalf("follow-up request should carry session cookie: %v", err)
+	}
+
+	if err := client.ClearCookies(server.URL); err != nil {
+		t.Fatalf("ClearCookies: %v", err)
+	}
+	cookies, _ = client.Cookies(server.URL)
+	if len(cookies) != 0 {
+		t.Fatalf("expect empty jar after clear, got %v", cookies)
+	}
+
+	if _, _, err := client.JSONRequest(ctx, GET, server.URL+"/data", nil, nil, false); err == nil {
+		t.Fatalf("request without session cookie should fail")
+	}
+}
+
+func TestJsonClientWithoutJar(t *testing.T) {
+	client := NewJsonClient(nil)
+	cookies, err := client.Cookies("http://example.com")
+	if err != nil {
+		t.Fatalf("Cookies: %v", err)
+	}
+	if cookies != nil {
+		t.Fatalf("expect nil cookies without jar, got %v", cookies)
+	}
+	if err := client.ClearCookies("http://example.com"); err != nil {
+		t.Fatalf("ClearCookies: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+)
+
+// ErrStopIteration is the sentinel a ForEachListItem callback returns
+// to end the walk early without reporting an error
+const ErrStopIteration = errors.Error("stop iteration")
+
+const (
+	// defaultListBatchSize is the page size used when the caller does
+	// not specify one
+	defaultListBatchSize = 100
+
+	// listPageMaxRetries is how often a transiently failing page fetch
+	// is retried before the walk gives up
+	listPageMaxRetries = 3
+
+	// listPageRetryInterval is the base backoff between page fetch
+	// retries, doubled on every attempt
+	listPageRetryInterval = 100 * time.Millisecond
+)
+
+// sListPage is one page of a list response shaped as
+// {"data": [...], "total": N, ...}, optionally carrying a marker for
+// the next page
+type sListPage struct {
+	Data       []jsonutils.JSONObject
+	Total      int
+	NextMarker string
+}
+
+func parseListPage(body jsonutils.JSONObject) (sListPage, error) {
+	page := sListPage{}
+	if body == nil {
+		return page, errors.Error("empty list response")
+	}
+	data, err := body.GetArray("data")
+	if err != nil {
+		return page, errors.Wrap(err, "list response carries no data array")
+	}
+	page.Data = data
+	if total, err := body.Int("total"); err == nil {
+		page.Total = int(total)
+	}
+	page.NextMarker, _ = body.GetString("next_marker")
+	return page, nil
+}
+
+// fetchListPage fetches one page, retrying throttled and server-side
+// failures with exponential backoff
+func fetchListPage(client *http.Client, ctx context.Context, urlStr string, header http.Header) (sListPage, error) {
+	for attempt := 0; ; attempt++ {
+		_, body, err := JSONRequest(client, ctx, "GET", urlStr, header, nil, false)
+		if err == nil {
+			return parseListPage(body)
+		}
+		if attempt >= listPageMaxRetries || !isRetriableError(err) {
+			return sListPage{}, err
+		}
+		wait := listPageRetryInterval << uint(attempt)
+		if after := GetRetryAfter(err); after > 0 {
+			wait = after
+		}
+		select {
+		case <-ctx.Done():
+			return sListPage{}, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// ForEachListItem walks a paginated list endpoint shaped as
+// {"data": [...], "total": N, "limit": L, "offset": O} and invokes fn
+// for every item. Pages are fetched batchSize items at a time by
+// advancing the offset; when a response carries a non-empty
+// next_marker the walk switches to marker-based pagination instead.
+// Transient page fetch failures are retried. fn may return
+// ErrStopIteration to end the walk early without error; any other
+// error aborts the walk and is returned. The total reported by the
+// first page bounds the walk, so a list that grows while being paged
+// cannot loop forever.
+func ForEachListItem(client *http.Client, ctx context.Context, baseUrl string, query url.Values, header http.Header, batchSize int, fn func(item jsonutils.JSONObject) error) error {
+	if batchSize <= 0 {
+		batchSize = defaultListBatchSize
+	}
+	offset := 0
+	marker := ""
+	bound := -1
+	for {
+		params := url.Values{}
+		for key, values := range query {
+			params[key] = values
+		}
+		params.Set("limit", strconv.Itoa(batchSize))
+		if marker != "" {
+			params.Set("marker", marker)
+		} else {
+			params.Set("offset", strconv.Itoa(offset))
+		}
+		page, err := fetchListPage(client, ctx, baseUrl+"?"+params.Encode(), header)
+		if err != nil {
+			return errors.Wrapf(err, "fetch page at offset %d", offset)
+		}
+		for _, item := range page.Data {
+			if err := fn(item); err != nil {
+				if errors.Cause(err) == ErrStopIteration {
+					return nil
+				}
+				return err
+			}
+		}
+		offset += len(page.Data)
+		if len(page.Data) == 0 {
+			return nil
+		}
+		if page.NextMarker != "" {
+			marker = page.NextMarker
+			continue
+		}
+		if marker != "" {
+			// the marker chain ended
+			return nil
+		}
+		if page.Total > 0 && (bound < 0 || page.Total < bound) {
+			bound = page.Total
+		}
+		if bound >= 0 && offset >= bound {
+			return nil
+		}
+		if page.Total <= 0 && len(page.Data) < batchSize {
+			// no total reported, a short page means the end
+			return nil
+		}
+	}
+}
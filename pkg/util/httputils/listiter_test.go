@@ -0,0 +1,237 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+)
+
+// newOffsetListServer serves total items named item-<n> through
+// offset/limit pagination, reporting reportedTotal in every response
+func newOffsetListServer(t *testing.T, total int, reportedTotal func(offset int) int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		if limit <= 0 {
+			t.Errorf("request without limit: %s", r.URL.RawQuery)
+			limit = 10
+		}
+		data := jsonutils.NewArray()
+		for i := offset; i < offset+limit && i < total; i++ {
+			item := jsonutils.NewDict()
+			item.Add(jsonutils.NewString(fmt.Sprintf("item-%d", i)), "name")
+			data.Add(item)
+		}
+		body := jsonutils.NewDict()
+		body.Add(data, "data")
+		body.Add(jsonutils.NewInt(int64(reportedTotal(offset))), "total")
+		body.Add(jsonutils.NewInt(int64(limit)), "limit")
+		body.Add(jsonutils.NewInt(int64(offset)), "offset")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.String()))
+	}))
+}
+
+func TestForEachListItemOffsetPagination(t *testing.T) {
+	requests := 0
+	srv := newOffsetListServer(t, 25, func(offset int) int { return 25 }, &requests)
+	defer srv.Close()
+
+	names := make([]string, 0)
+	err := ForEachListItem(srv.Client(), context.Background(), srv.URL, nil, nil, 10, func(item jsonutils.JSONObject) error {
+		name, _ := item.GetString("name")
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if len(names) != 25 {
+		t.Fatalf("visited %d items, want 25", len(names))
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("item-%d", i); name != want {
+			t.Fatalf("item %d = %s, want %s", i, name, want)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("fetched %d pages, want 3", requests)
+	}
+}
+
+func TestForEachListItemStopSentinel(t *testing.T) {
+	requests := 0
+	srv := newOffsetListServer(t, 25, func(offset int) int { return 25 }, &requests)
+	defer srv.Close()
+
+	visited := 0
+	err := ForEachListItem(srv.Client(), context.Background(), srv.URL, nil, nil, 10, func(item jsonutils.JSONObject) error {
+		visited++
+		if visited >= 5 {
+			return ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("sentinel must not surface as error: %v", err)
+	}
+	if visited != 5 {
+		t.Errorf("visited %d items, want 5", visited)
+	}
+	if requests != 1 {
+		t.Errorf("fetched %d pages, want 1", requests)
+	}
+
+	// a real callback error aborts the walk and propagates
+	wantErr := errors.Error("broken callback")
+	err = ForEachListItem(srv.Client(), context.Background(), srv.URL, nil, nil, 10, func(item jsonutils.JSONObject) error {
+		return wantErr
+	})
+	if errors.Cause(err) != wantErr {
+		t.Errorf("callback error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestForEachListItemGrowingTotal(t *testing.T) {
+	// the reported total grows faster than the walk advances; the
+	// total seen on the first page bounds the iteration
+	requests := 0
+	srv := newOffsetListServer(t, 1000000, func(offset int) int { return offset + 12 }, &requests)
+	defer srv.Close()
+
+	visited := 0
+	err := ForEachListItem(srv.Client(), context.Background(), srv.URL, nil, nil, 5, func(item jsonutils.JSONObject) error {
+		visited++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	// bound is 12, pages of 5 stop once the offset passes it
+	if visited != 15 {
+		t.Errorf("visited %d items, want 15", visited)
+	}
+	if requests != 3 {
+		t.Errorf("fetched %d pages, want 3", requests)
+	}
+}
+
+func TestForEachListItemMarkerPagination(t *testing.T) {
+	pages := map[string][]string{
+		"":    {"a", "b"},
+		"m-1": {"c", "d"},
+		"m-2": {"e"},
+	}
+	next := map[string]string{"": "m-1", "m-1": "m-2"}
+	markers := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		marker := r.URL.Query().Get("marker")
+		markers = append(markers, marker)
+		data := jsonutils.NewArray()
+		for _, name := range pages[marker] {
+			item := jsonutils.NewDict()
+			item.Add(jsonutils.NewString(name), "name")
+			data.Add(item)
+		}
+		body := jsonutils.NewDict()
+		body.Add(data, "data")
+		if nextMarker, ok := next[marker]; ok {
+			body.Add(jsonutils.NewString(nextMarker), "next_marker")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.String()))
+	}))
+	defer srv.Close()
+
+	names := make([]string, 0)
+	err := ForEachListItem(srv.Client(), context.Background(), srv.URL, url.Values{"status": []string{"ready"}}, nil, 2, func(item jsonutils.JSONObject) error {
+		name, _ := item.GetString("name")
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if got := fmt.Sprintf("%v", names); got != "[a b c d e]" {
+		t.Errorf("visited %s, want [a b c d e]", got)
+	}
+	if got := fmt.Sprintf("%v", markers); got != "[ m-1 m-2]" {
+		t.Errorf("marker sequence %s, want [ m-1 m-2]", got)
+	}
+}
+
+func TestForEachListItemRetriesTransientFailures(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error": "transient"}`))
+			return
+		}
+		item := jsonutils.NewDict()
+		item.Add(jsonutils.NewString("item-0"), "name")
+		data := jsonutils.NewArray(item)
+		body := jsonutils.NewDict()
+		body.Add(data, "data")
+		body.Add(jsonutils.NewInt(1), "total")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body.String()))
+	}))
+	defer srv.Close()
+
+	visited := 0
+	err := ForEachListItem(srv.Client(), context.Background(), srv.URL, nil, nil, 10, func(item jsonutils.JSONObject) error {
+		visited++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk must survive a transient failure: %v", err)
+	}
+	if visited != 1 {
+		t.Errorf("visited %d items, want 1", visited)
+	}
+	if requests != 2 {
+		t.Errorf("made %d requests, want 2", requests)
+	}
+
+	// a client error is not retried
+	requests = 0
+	badSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error": "forbidden"}`))
+	}))
+	defer badSrv.Close()
+	err = ForEachListItem(badSrv.Client(), context.Background(), badSrv.URL, nil, nil, 10, func(item jsonutils.JSONObject) error {
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expect forbidden error")
+	}
+	if requests != 1 {
+		t.Errorf("made %d requests, want 1 (no retry on 403)", requests)
+	}
+}
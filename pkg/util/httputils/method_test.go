@@ -0,0 +1,72 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestMethodValidation(t *testing.T) {
+	var gotMethod string
+	var gotContentLength string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotContentLength = r.Header.Get("Content-Length")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	if _, err := Request(nil, ctx, "BOGUS METHOD", server.URL, nil, nil, false); err == nil {
+		t.Fatalf("invalid method must be rejected")
+	} else if jce, ok := err.(*JSONClientError); !ok || jce.Class != "InvalidMethod" {
+		t.Fatalf("expect InvalidMethod client error, got %v", err)
+	}
+	if gotMethod != "" {
+		t.Fatalf("invalid method must not reach the server, got %q", gotMethod)
+	}
+
+	if _, err := Request(nil, ctx, "PROPFIND", server.URL, nil, nil, false); err == nil {
+		t.Fatalf("unregistered custom method must be rejected")
+	}
+	RegisterHttpMethod("PROPFIND")
+	if resp, err := Request(nil, ctx, "PROPFIND", server.URL, nil, nil, false); err != nil {
+		t.Fatalf("registered custom method: %v", err)
+	} else {
+		CloseResponse(resp)
+	}
+	if gotMethod != "PROPFIND" {
+		t.Fatalf("expect PROPFIND to reach the server, got %q", gotMethod)
+	}
+
+	// a lowercase get must be treated as GET, without a spurious
+	// Content-Length: 0 header
+	if resp, err := Request(nil, ctx, "get", server.URL, nil, nil, false); err != nil {
+		t.Fatalf("lowercase get: %v", err)
+	} else {
+		CloseResponse(resp)
+	}
+	if gotContentLength != "" {
+		t.Fatalf("lowercase get must not carry Content-Length, got %q", gotContentLength)
+	}
+
+	if string(OPTION) != "OPTIONS" {
+		t.Fatalf("deprecated OPTION alias must send OPTIONS, got %q", string(OPTION))
+	}
+}
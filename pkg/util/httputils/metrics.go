@@ -0,0 +1,202 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+)
+
+// RequestMetric describes one outgoing HTTP request for metrics export
+type RequestMetric struct {
+	Method string
+	Host   string
+	// Path is the request path with resource IDs collapsed so the
+	// metric cardinality stays bounded
+	Path         string
+	StatusCode   int
+	Duration     time.Duration
+	RequestSize  int64
+	ResponseSize int64
+	// Proto is the negotiated protocol of the response, e.g. HTTP/1.1
+	// or HTTP/2.0
+	Proto string
+	// ConnReused is true when the request ran on a kept-alive
+	// connection instead of dialing a new one
+	ConnReused bool
+	// ConnIdleTime is how long the reused connection sat idle
+	ConnIdleTime time.Duration
+	// DNSDuration is the time spent resolving the host, zero when no
+	// lookup was needed
+	DNSDuration time.Duration
+	// TLSDuration is the time spent in the TLS handshake, zero on a
+	// reused or plaintext connection
+	TLSDuration time.Duration
+	// TLSVersion is the negotiated TLS version, e.g. TLS1.3
+	TLSVersion string
+	// TLSCipher is the negotiated cipher suite
+	TLSCipher string
+	// ErrorClass is empty on success, "network" for transport errors
+	// and the status text for HTTP level errors
+	ErrorClass string
+}
+
+type RequestMetricsCallback func(m RequestMetric)
+
+var requestMetricsCallback RequestMetricsCallback
+
+// RegisterRequestMetricsCallback installs a callback invoked after
+// every request issued through this package. Only one callback is
+// supported; use the built-in SMetricsAggregator to fan metrics into a
+// /metrics handler.
+func RegisterRequestMetricsCallback(cb RequestMetricsCallback) {
+	requestMetricsCallback = cb
+}
+
+func reportRequestMetric(m RequestMetric) {
+	cb := requestMetricsCallback
+	if cb == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// metrics must never break the request path
+			log.Errorf("request metrics callback panic: %v", r)
+		}
+	}()
+	cb(m)
+}
+
+var (
+	uuidSegmentPattern    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	numericSegmentPattern = regexp.MustCompile(`^[0-9]+$`)
+	hexSegmentPattern     = regexp.MustCompile(`^[0-9a-fA-F]{24,}$`)
+)
+
+// NormalizeMetricPath collapses path segments that look like resource
+// IDs into a placeholder
+func NormalizeMetricPath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if uuidSegmentPattern.MatchString(seg) || numericSegmentPattern.MatchString(seg) || hexSegmentPattern.MatchString(seg) {
+			segs[i] = "<id>"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+var defaultLatencyBuckets = []time.Duration{
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	30 * time.Second,
+}
+
+// SMetricsBucket aggregates requests of one (host, method, status)
+type SMetricsBucket struct {
+	Host   string
+	Method string
+	Status int
+
+	Count         int64
+	ErrorCount    int64
+	TotalDuration time.Duration
+	// LatencyCounts[i] counts requests faster than defaultLatencyBuckets[i],
+	// the last element counts the rest
+	LatencyCounts []int64
+}
+
+// SMetricsAggregator is a ready-made RequestMetricsCallback target
+// keeping counters and latency histograms per (host, method, status)
+type SMetricsAggregator struct {
+	lock    sync.Mutex
+	buckets map[string]*SMetricsBucket
+}
+
+func NewMetricsAggregator() *SMetricsAggregator {
+	return &SMetricsAggregator{
+		buckets: make(map[string]*SMetricsBucket),
+	}
+}
+
+func (a *SMetricsAggregator) Callback(m RequestMetric) {
+	key := fmt.Sprintf("%s|%s|%d", m.Host, m.Method, m.StatusCode)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	bucket, ok := a.buckets[key]
+	if !ok {
+		bucket = &SMetricsBucket{
+			Host:          m.Host,
+			Method:        m.Method,
+			Status:        m.StatusCode,
+			LatencyCounts: make([]int64, len(defaultLatencyBuckets)+1),
+		}
+		a.buckets[key] = bucket
+	}
+	bucket.Count++
+	if m.ErrorClass != "" {
+		bucket.ErrorCount++
+	}
+	bucket.TotalDuration += m.Duration
+	idx := len(defaultLatencyBuckets)
+	for i, bound := range defaultLatencyBuckets {
+		if m.Duration < bound {
+			idx = i
+			break
+		}
+	}
+	bucket.LatencyCounts[idx]++
+}
+
+// LatencyBuckets returns the histogram bucket upper bounds matching
+// SMetricsBucket.LatencyCounts
+func LatencyBuckets() []time.Duration {
+	buckets := make([]time.Duration, len(defaultLatencyBuckets))
+	copy(buckets, defaultLatencyBuckets)
+	return buckets
+}
+
+// GetMetricsSnapshot returns a stable-ordered copy of the aggregated
+// buckets for serialization by a /metrics handler
+func (a *SMetricsAggregator) GetMetricsSnapshot() []SMetricsBucket {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	snapshot := make([]SMetricsBucket, 0, len(a.buckets))
+	for _, bucket := range a.buckets {
+		copied := *bucket
+		copied.LatencyCounts = make([]int64, len(bucket.LatencyCounts))
+		copy(copied.LatencyCounts, bucket.LatencyCounts)
+		snapshot = append(snapshot, copied)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		if snapshot[i].Host != snapshot[j].Host {
+			return snapshot[i].Host < snapshot[j].Host
+		}
+		if snapshot[i].Method != snapshot[j].Method {
+			return snapshot[i].Method < snapshot[j].Method
+		}
+		return snapshot[i].Status < snapshot[j].Status
+	})
+	return snapshot
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNormalizeMetricPath(t *testing.T) {
+	cases := map[string]string{
+		"/servers":     "/servers",
+		"/servers/123": "/servers/<id>",
+		"/servers/3d1f5cbe-6f25-44f1-8b21-0a1c97f44b0d/disks": "/servers/<id>/disks",
+		"/v2/servers/abc-name":                                "/v2/servers/abc-name",
+	}
+	for input, want := range cases {
+		if got := NormalizeMetricPath(input); got != want {
+			t.Errorf("NormalizeMetricPath(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestRequestMetricsCallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+
+	aggregator := NewMetricsAggregator()
+	RegisterRequestMetricsCallback(aggregator.Callback)
+	defer RegisterRequestMetricsCallback(nil)
+
+	ctx := context.Background()
+	JSONRequest(nil, ctx, GET, server.URL+"/ok", nil, nil, false)
+	JSONRequest(nil, ctx, GET, server.URL+"/ok", nil, nil, false)
+	JSONRequest(nil, ctx, GET, server.URL+"/fail", nil, nil, false)
+
+	snapshot := aggregator.GetMetricsSnapshot()
+	if len(snapshot) != 2 {
+		t.Fatalf("expect 2 buckets, got %d", len(snapshot))
+	}
+	var okBucket, failBucket *SMetricsBucket
+	for i := range snapshot {
+		switch snapshot[i].Status {
+		case http.StatusOK:
+			okBucket = &snapshot[i]
+		case http.StatusInternalServerError:
+			failBucket = &snapshot[i]
+		}
+	}
+	if okBucket == nil || okBucket.Count != 2 || okBucket.ErrorCount != 0 {
+		t.Fatalf("unexpected ok bucket %+v", okBucket)
+	}
+	if failBucket == nil || failBucket.Count != 1 || failBucket.ErrorCount != 1 {
+		t.Fatalf("unexpected fail bucket %+v", failBucket)
+	}
+	var latencyTotal int64
+	for _, c := range okBucket.LatencyCounts {
+		latencyTotal += c
+	}
+	if latencyTotal != okBucket.Count {
+		t.Fatalf("latency histogram does not cover all requests: %+v", okBucket)
+	}
+}
+
+func TestRequestMetricsCallbackPanic(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	RegisterRequestMetricsCallback(func(m RequestMetric) {
+		panic("boom")
+	})
+	defer RegisterRequestMetricsCallback(nil)
+
+	if _, _, err := JSONRequest(nil, context.Background(), GET, server.URL, nil, nil, false); err != nil {
+		t.Fatalf("panicking callback must not break requests: %v", err)
+	}
+	// give the deferred recover a moment in case of async logging
+	time.Sleep(10 * time.Millisecond)
+}
@@ -0,0 +1,105 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/appctx"
+	"yunion.io/x/onecloud/pkg/cloudcommon/consts"
+)
+
+func newJsonEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"ok": true}`)
+	}))
+}
+
+func TestRequestNilContext(t *testing.T) {
+	srv := newJsonEchoServer()
+	defer srv.Close()
+
+	resp, err := Request(GetDefaultClient(), nil, GET, srv.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Request with nil ctx: %v", err)
+	}
+	defer CloseResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestJSONRequestNilContext(t *testing.T) {
+	srv := newJsonEchoServer()
+	defer srv.Close()
+
+	_, body, err := JSONRequest(GetDefaultClient(), nil, GET, srv.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("JSONRequest with nil ctx: %v", err)
+	}
+	if ok, _ := body.Bool("ok"); !ok {
+		t.Errorf("body = %s, want ok=true", body)
+	}
+}
+
+func TestJsonClientSendNilContext(t *testing.T) {
+	srv := newJsonEchoServer()
+	defer srv.Close()
+
+	client := NewJsonClient(&http.Client{})
+	client.Use(NewRequestIdLogMiddleware())
+	_, body, err := client.Send(nil, &JsonRequest{Method: GET, Url: srv.URL}, false)
+	if err != nil {
+		t.Fatalf("Send with nil ctx: %v", err)
+	}
+	if ok, _ := body.Bool("ok"); !ok {
+		t.Errorf("body = %s, want ok=true", body)
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	ctx := WithDefaults(nil)
+	if ctx == nil {
+		t.Fatalf("WithDefaults(nil) returned nil")
+	}
+	if appctx.AppContextRequestId(ctx) == "" {
+		t.Errorf("request id not populated")
+	}
+
+	// an existing request id is preserved
+	base := context.WithValue(context.Background(), appctx.APP_CONTEXT_KEY_REQUEST_ID, "req-1")
+	if got := appctx.AppContextRequestId(WithDefaults(base)); got != "req-1" {
+		t.Errorf("request id = %q, want req-1", got)
+	}
+
+	// the service name comes from the process-wide service type
+	saved := consts.GetServiceType()
+	consts.SetServiceType("testsvc")
+	defer consts.SetServiceType(saved)
+	if got := appctx.AppContextServiceName(WithDefaults(context.Background())); got != "testsvc" {
+		t.Errorf("service name = %q, want testsvc", got)
+	}
+
+	// an explicit service name wins over the default
+	named := context.WithValue(context.Background(), appctx.APP_CONTEXT_KEY_APPNAME, "other")
+	if got := appctx.AppContextServiceName(WithDefaults(named)); got != "other" {
+		t.Errorf("service name = %q, want other", got)
+	}
+}
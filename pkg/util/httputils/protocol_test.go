@@ -0,0 +1,119 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func newHTTP2TestServer(handler http.HandlerFunc) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts
+}
+
+func TestProtocolAutoNegotiatesHTTP2(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	ts := newHTTP2TestServer(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		// hold the request open so concurrent requests overlap
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte(`{"ok": true}`))
+	})
+	defer ts.Close()
+
+	client := GetClientWithProtocol(true, 10*time.Second, ProtocolAuto)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := Request(client, context.Background(), GET, ts.URL, nil, nil, false)
+			if err != nil {
+				t.Errorf("request: %v", err)
+				return
+			}
+			if resp.Proto != "HTTP/2.0" {
+				t.Errorf("expect HTTP/2.0, got %s", resp.Proto)
+			}
+			CloseResponse(resp)
+		}()
+	}
+	wg.Wait()
+	// multiplexed requests share a single connection
+	if len(remotes) != 1 {
+		t.Errorf("expect one connection for concurrent requests, got %d", len(remotes))
+	}
+}
+
+func TestProtocolH1PreservesHTTP1(t *testing.T) {
+	ts := newHTTP2TestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	})
+	defer ts.Close()
+
+	client := GetClientWithProtocol(true, 10*time.Second, ProtocolH1)
+	resp, err := Request(client, context.Background(), GET, ts.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer CloseResponse(resp)
+	if resp.Proto != "HTTP/1.1" {
+		t.Errorf("expect HTTP/1.1, got %s", resp.Proto)
+	}
+}
+
+func TestProtocolH2Cleartext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	h2srv := &http2.Server{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": true}`))
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go h2srv.ServeConn(conn, &http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+
+	client := GetClientWithProtocol(true, 10*time.Second, ProtocolH2)
+	resp, err := Request(client, context.Background(), GET, "http://"+ln.Addr().String(), nil, nil, false)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer CloseResponse(resp)
+	if resp.Proto != "HTTP/2.0" {
+		t.Errorf("expect HTTP/2.0 over cleartext, got %s", resp.Proto)
+	}
+}
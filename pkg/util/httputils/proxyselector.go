@@ -0,0 +1,167 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// TransportProxyFunc selects the proxy for one outgoing request, nil
+// URL meaning a direct connection
+type TransportProxyFunc func(req *http.Request) (*url.URL, error)
+
+var clientProxyFunc atomic.Value // of TransportProxyFunc
+
+// SetClientProxyFunc overrides proxy selection for every transport this
+// package creates, taking effect immediately for in-flight clients.
+// A nil func restores http.ProxyFromEnvironment.
+func SetClientProxyFunc(f TransportProxyFunc) {
+	if f == nil {
+		f = http.ProxyFromEnvironment
+	}
+	clientProxyFunc.Store(f)
+}
+
+// transportProxy is installed as the Proxy of every transport so a
+// later SetClientProxyFunc applies to clients built before the call
+func transportProxy(req *http.Request) (*url.URL, error) {
+	if f, ok := clientProxyFunc.Load().(TransportProxyFunc); ok {
+		return f(req)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// ProxyDirect as the ProxyURL of a rule selects a direct connection
+const ProxyDirect = "DIRECT"
+
+// ProxyRule maps destinations to a proxy. A destination matches when
+// the host ends with HostSuffix or resolves inside CIDR; a rule with
+// neither matches everything and serves as the fallback.
+type ProxyRule struct {
+	// HostSuffix matches the destination host or any subdomain of it,
+	// e.g. aliyuncs.com matches oss.aliyuncs.com
+	HostSuffix string
+	// CIDR matches destinations whose host is an IP inside the range
+	CIDR string
+	// ProxyURL is the proxy to use, e.g. http://proxy.corp:3128, or
+	// DIRECT for no proxy
+	ProxyURL string
+}
+
+// sCompiledProxyRule is a ProxyRule with its matchers parsed once
+type sCompiledProxyRule struct {
+	suffix string
+	ipnet  *net.IPNet
+	// proxy is nil for DIRECT
+	proxy *url.URL
+}
+
+func compileProxyRule(rule ProxyRule) (sCompiledProxyRule, error) {
+	compiled := sCompiledProxyRule{
+		suffix: strings.ToLower(strings.TrimPrefix(rule.HostSuffix, ".")),
+	}
+	if len(rule.CIDR) > 0 {
+		_, ipnet, err := net.ParseCIDR(rule.CIDR)
+		if err != nil {
+			return compiled, errors.Wrapf(err, "invalid cidr %q", rule.CIDR)
+		}
+		compiled.ipnet = ipnet
+	}
+	if len(rule.ProxyURL) > 0 && !strings.EqualFold(rule.ProxyURL, ProxyDirect) {
+		proxy, err := url.Parse(rule.ProxyURL)
+		if err != nil {
+			return compiled, errors.Wrapf(err, "invalid proxy url %q", rule.ProxyURL)
+		}
+		if len(proxy.Scheme) == 0 || len(proxy.Host) == 0 {
+			return compiled, errors.Error("invalid proxy url " + rule.ProxyURL)
+		}
+		compiled.proxy = proxy
+	}
+	return compiled, nil
+}
+
+// match reports whether the rule covers the destination host, given
+// also as an IP when the host is one
+func (rule sCompiledProxyRule) match(host string, ip net.IP) bool {
+	if len(rule.suffix) > 0 {
+		if host != rule.suffix && !strings.HasSuffix(host, "."+rule.suffix) {
+			return false
+		}
+		return true
+	}
+	if rule.ipnet != nil {
+		return ip != nil && rule.ipnet.Contains(ip)
+	}
+	// no matcher: the rule is the catch-all fallback
+	return true
+}
+
+// SProxySelector picks a proxy per destination from an ordered rule
+// list, first match wins. The rules swap atomically so the cloud
+// account proxy settings can change without rebuilding clients.
+type SProxySelector struct {
+	rules atomic.Value // of []sCompiledProxyRule
+}
+
+// NewProxySelector builds a selector from ordered rules; destinations
+// no rule covers connect directly
+func NewProxySelector(rules []ProxyRule) (*SProxySelector, error) {
+	selector := &SProxySelector{}
+	if err := selector.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return selector, nil
+}
+
+// SetRules atomically replaces the rule list; requests already
+// selecting keep the old rules, new requests see the new ones
+func (selector *SProxySelector) SetRules(rules []ProxyRule) error {
+	compiled := make([]sCompiledProxyRule, len(rules))
+	for i := range rules {
+		rule, err := compileProxyRule(rules[i])
+		if err != nil {
+			return errors.Wrapf(err, "rule %d", i)
+		}
+		compiled[i] = rule
+	}
+	selector.rules.Store(compiled)
+	return nil
+}
+
+func (selector *SProxySelector) selectProxy(host string) *url.URL {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	rules, _ := selector.rules.Load().([]sCompiledProxyRule)
+	for i := range rules {
+		if rules[i].match(host, ip) {
+			return rules[i].proxy
+		}
+	}
+	return nil
+}
+
+// ProxyFunc returns the selector as a TransportProxyFunc ready to pass
+// to SetClientProxyFunc or a http.Transport
+func (selector *SProxySelector) ProxyFunc() TransportProxyFunc {
+	return func(req *http.Request) (*url.URL, error) {
+		return selector.selectProxy(req.URL.Hostname()), nil
+	}
+}
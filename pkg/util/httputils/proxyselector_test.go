@@ -0,0 +1,113 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func proxyFor(t *testing.T, f TransportProxyFunc, urlStr string) *url.URL {
+	t.Helper()
+	req, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		t.Fatalf("new request %s: %v", urlStr, err)
+	}
+	proxy, err := f(req)
+	if err != nil {
+		t.Fatalf("select proxy for %s: %v", urlStr, err)
+	}
+	return proxy
+}
+
+func TestProxySelector(t *testing.T) {
+	selector, err := NewProxySelector([]ProxyRule{
+		// internal services connect directly
+		{HostSuffix: "yunion.lan", ProxyURL: ProxyDirect},
+		{CIDR: "10.0.0.0/8", ProxyURL: "DIRECT"},
+		// public cloud APIs go through the corporate proxy
+		{HostSuffix: "aliyuncs.com", ProxyURL: "http://proxy.corp:3128"},
+		// everything else falls back to another proxy
+		{ProxyURL: "http://fallback.corp:3128"},
+	})
+	if err != nil {
+		t.Fatalf("new selector: %v", err)
+	}
+	f := selector.ProxyFunc()
+
+	// suffix match covers the host itself and its subdomains
+	for _, urlStr := range []string{"https://aliyuncs.com/api", "https://oss.cn-beijing.aliyuncs.com/bucket"} {
+		if proxy := proxyFor(t, f, urlStr); proxy == nil || proxy.Host != "proxy.corp:3128" {
+			t.Errorf("%s: proxy = %v, want proxy.corp:3128", urlStr, proxy)
+		}
+	}
+	// ...but not hosts merely containing the suffix
+	if proxy := proxyFor(t, f, "https://notaliyuncs.com/"); proxy == nil || proxy.Host != "fallback.corp:3128" {
+		t.Errorf("suffix must match on label boundary, got %v", proxy)
+	}
+
+	// DIRECT rules select no proxy, by suffix or by CIDR
+	if proxy := proxyFor(t, f, "https://keystone.yunion.lan:5000/v3"); proxy != nil {
+		t.Errorf("internal host must connect directly, got %v", proxy)
+	}
+	if proxy := proxyFor(t, f, "http://10.168.22.1/status"); proxy != nil {
+		t.Errorf("internal ip must connect directly, got %v", proxy)
+	}
+	// an ip outside the range falls through to the fallback
+	if proxy := proxyFor(t, f, "http://192.168.1.1/"); proxy == nil || proxy.Host != "fallback.corp:3128" {
+		t.Errorf("external ip proxy = %v, want fallback.corp:3128", proxy)
+	}
+}
+
+func TestProxySelectorNoFallback(t *testing.T) {
+	selector, err := NewProxySelector([]ProxyRule{
+		{HostSuffix: "example.com", ProxyURL: "http://proxy.corp:3128"},
+	})
+	if err != nil {
+		t.Fatalf("new selector: %v", err)
+	}
+	// destinations no rule covers connect directly
+	if proxy := proxyFor(t, selector.ProxyFunc(), "https://other.org/"); proxy != nil {
+		t.Errorf("uncovered destination must connect directly, got %v", proxy)
+	}
+}
+
+func TestProxySelectorSetRules(t *testing.T) {
+	selector, err := NewProxySelector(nil)
+	if err != nil {
+		t.Fatalf("new selector: %v", err)
+	}
+	f := selector.ProxyFunc()
+	if proxy := proxyFor(t, f, "https://example.com/"); proxy != nil {
+		t.Errorf("empty rules must connect directly, got %v", proxy)
+	}
+	// swapping the rules takes effect on the already handed-out func
+	if err := selector.SetRules([]ProxyRule{{ProxyURL: "http://proxy.corp:3128"}}); err != nil {
+		t.Fatalf("set rules: %v", err)
+	}
+	if proxy := proxyFor(t, f, "https://example.com/"); proxy == nil || proxy.Host != "proxy.corp:3128" {
+		t.Errorf("swapped rules not applied, got %v", proxy)
+	}
+}
+
+func TestProxySelectorInvalidRules(t *testing.T) {
+	if _, err := NewProxySelector([]ProxyRule{{CIDR: "10.0.0.0/40"}}); err == nil {
+		t.Errorf("expect error for invalid cidr")
+	}
+	if _, err := NewProxySelector([]ProxyRule{{ProxyURL: "://bad"}}); err == nil {
+		t.Errorf("expect error for invalid proxy url")
+	}
+}
@@ -0,0 +1,394 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/errors"
+)
+
+// sRedactionRules holds the secrets scrubbed from recorded fixtures:
+// header names, dot-separated JSON body paths and URL query keys.
+// Rules are extensible via the Register functions so provider drivers
+// can add their own credential fields.
+type sRedactionRules struct {
+	lock      sync.Mutex
+	headers   map[string]bool
+	bodyPaths [][]string
+	queryKeys map[string]bool
+}
+
+const redactedPlaceholder = "*REDACTED*"
+
+const ErrNoRecordedExchange = errors.Error("No recorded exchange")
+
+var redactionRules = &sRedactionRules{
+	headers: map[string]bool{
+		"Authorization":   true,
+		"Cookie":          true,
+		"Set-Cookie":      true,
+		"X-Auth-Token":    true,
+		"X-Subject-Token": true,
+	},
+	bodyPaths: [][]string{
+		{"password"},
+		{"secret"},
+		{"access_key_secret"},
+	},
+	queryKeys: map[string]bool{
+		"password":  true,
+		"secret":    true,
+		"signature": true,
+		"token":     true,
+	},
+}
+
+// RegisterRedactedHeader adds a header name scrubbed from recorded
+// fixtures
+func RegisterRedactedHeader(name string) {
+	redactionRules.lock.Lock()
+	defer redactionRules.lock.Unlock()
+	redactionRules.headers[http.CanonicalHeaderKey(name)] = true
+}
+
+// RegisterRedactedBodyPath adds a dot-separated JSON path, e.g.
+// "auth.secret", whose value is scrubbed from recorded request bodies
+func RegisterRedactedBodyPath(path string) {
+	redactionRules.lock.Lock()
+	defer redactionRules.lock.Unlock()
+	redactionRules.bodyPaths = append(redactionRules.bodyPaths, strings.Split(path, "."))
+}
+
+// RegisterRedactedQueryKey adds a URL query key whose value is scrubbed
+// from recorded URLs
+func RegisterRedactedQueryKey(key string) {
+	redactionRules.lock.Lock()
+	defer redactionRules.lock.Unlock()
+	redactionRules.queryKeys[strings.ToLower(key)] = true
+}
+
+// redactHeader returns a copy of header with the registered sensitive
+// headers removed
+func (rules *sRedactionRules) redactHeader(header http.Header) http.Header {
+	redacted := http.Header{}
+	rules.lock.Lock()
+	defer rules.lock.Unlock()
+	for key, values := range header {
+		if rules.headers[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
+// redactUrl strips userinfo and replaces the values of registered
+// query keys; an unparseable url is recorded as is
+func (rules *sRedactionRules) redactUrl(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+	u.User = nil
+	query := u.Query()
+	rules.lock.Lock()
+	for key := range query {
+		if rules.queryKeys[strings.ToLower(key)] {
+			query.Set(key, redactedPlaceholder)
+		}
+	}
+	rules.lock.Unlock()
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactBody returns a deep copy of body with the registered JSON
+// paths overwritten, leaving the caller's body untouched
+func (rules *sRedactionRules) redactBody(body jsonutils.JSONObject) jsonutils.JSONObject {
+	if body == nil {
+		return nil
+	}
+	copied, err := jsonutils.ParseString(body.String())
+	if err != nil {
+		return body
+	}
+	rules.lock.Lock()
+	paths := make([][]string, len(rules.bodyPaths))
+	copy(paths, rules.bodyPaths)
+	rules.lock.Unlock()
+	for _, path := range paths {
+		scrubJSONPath(copied, path)
+	}
+	return copied
+}
+
+// scrubJSONPath overwrites the value at path inside obj when present
+func scrubJSONPath(obj jsonutils.JSONObject, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	parent := obj
+	if len(path) > 1 {
+		var err error
+		parent, err = obj.Get(path[:len(path)-1]...)
+		if err != nil {
+			return
+		}
+	}
+	dict, ok := parent.(*jsonutils.JSONDict)
+	if !ok || !dict.Contains(path[len(path)-1]) {
+		return
+	}
+	dict.Set(path[len(path)-1], jsonutils.NewString(redactedPlaceholder))
+}
+
+// requestBodyHash returns a short stable hash of the canonical JSON
+// body, the empty string for requests without a body
+func requestBodyHash(body jsonutils.JSONObject) string {
+	if body == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(body.String()))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// exchangeKey is the fixture lookup key: method, URL path and request
+// body hash
+func exchangeKey(method string, path string, bodyHash string) string {
+	return fmt.Sprintf("%s %s %s", strings.ToUpper(method), path, bodyHash)
+}
+
+// RecordedExchange is one request/response pair persisted as a fixture
+// for offline replay
+type RecordedExchange struct {
+	Method   string
+	Url      string
+	Path     string
+	BodyHash string
+	ReqBody  jsonutils.JSONObject
+	Status   int
+	RespHdr  http.Header
+	RespBody jsonutils.JSONObject
+}
+
+func (ex *RecordedExchange) toJSON() jsonutils.JSONObject {
+	obj := jsonutils.NewDict()
+	obj.Set("method", jsonutils.NewString(ex.Method))
+	obj.Set("url", jsonutils.NewString(ex.Url))
+	obj.Set("path", jsonutils.NewString(ex.Path))
+	obj.Set("body_hash", jsonutils.NewString(ex.BodyHash))
+	if ex.ReqBody != nil {
+		obj.Set("request_body", ex.ReqBody)
+	}
+	obj.Set("status", jsonutils.NewInt(int64(ex.Status)))
+	obj.Set("response_header", jsonutils.Marshal(map[string][]string(ex.RespHdr)))
+	if ex.RespBody != nil {
+		obj.Set("response_body", ex.RespBody)
+	}
+	return obj
+}
+
+func parseRecordedExchange(data jsonutils.JSONObject) (*RecordedExchange, error) {
+	ex := &RecordedExchange{RespHdr: http.Header{}}
+	var err error
+	if ex.Method, err = data.GetString("method"); err != nil {
+		return nil, errors.Wrap(err, "fixture without method")
+	}
+	if ex.Path, err = data.GetString("path"); err != nil {
+		return nil, errors.Wrap(err, "fixture without path")
+	}
+	ex.Url, _ = data.GetString("url")
+	ex.BodyHash, _ = data.GetString("body_hash")
+	ex.ReqBody, _ = data.Get("request_body")
+	status, err := data.Int("status")
+	if err != nil {
+		return nil, errors.Wrap(err, "fixture without status")
+	}
+	ex.Status = int(status)
+	if hdrObj, err := data.Get("response_header"); err == nil {
+		hdrMap := map[string][]string{}
+		if err := hdrObj.Unmarshal(&hdrMap); err == nil {
+			ex.RespHdr = http.Header(hdrMap)
+		}
+	}
+	ex.RespBody, _ = data.Get("response_body")
+	return ex, nil
+}
+
+// SRecorder writes the exchanges of a JsonClient into numbered fixture
+// files inside a directory, for later replay via SReplayTransport
+type SRecorder struct {
+	dir string
+
+	lock sync.Mutex
+	seq  int
+}
+
+func NewRecorder(dir string) (*SRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "mkdir %s", dir)
+	}
+	return &SRecorder{dir: dir}, nil
+}
+
+func (rec *SRecorder) record(ex *RecordedExchange) error {
+	rec.lock.Lock()
+	rec.seq += 1
+	seq := rec.seq
+	rec.lock.Unlock()
+	path := filepath.Join(rec.dir, fmt.Sprintf("%04d.json", seq))
+	return ioutil.WriteFile(path, []byte(ex.toJSON().PrettyString()), 0644)
+}
+
+// Middleware returns the middleware persisting every exchange of the
+// chain. Successful responses are recorded with status 200; error
+// responses with the status of the JSONClientError.
+func (rec *SRecorder) Middleware() Middleware {
+	return func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			u, urlErr := url.Parse(req.Url)
+			hdr, body, err := next(ctx, req)
+			if urlErr != nil {
+				return hdr, body, err
+			}
+			ex := &RecordedExchange{
+				Method:   string(req.Method),
+				Url:      redactionRules.redactUrl(req.Url),
+				Path:     u.Path,
+				BodyHash: requestBodyHash(req.Body),
+				ReqBody:  redactionRules.redactBody(req.Body),
+				Status:   200,
+				RespHdr:  redactionRules.redactHeader(hdr),
+				RespBody: body,
+			}
+			if err != nil {
+				if jsonErr, ok := err.(*JSONClientError); ok {
+					ex.Status = jsonErr.Code
+					ex.RespBody = jsonutils.Marshal(jsonErr)
+				} else {
+					// transport errors cannot be replayed
+					return hdr, body, err
+				}
+			}
+			if recErr := rec.record(ex); recErr != nil {
+				return hdr, body, errors.Wrap(recErr, "record exchange")
+			}
+			return hdr, body, err
+		}
+	}
+}
+
+// EnableRecording turns on fixture recording for this client: every
+// exchange is written into dir as a numbered JSON file
+func (client *JsonClient) EnableRecording(dir string) error {
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		return err
+	}
+	client.Use(rec.Middleware())
+	return nil
+}
+
+// SReplayTransport is an http.RoundTripper serving recorded fixtures,
+// matching requests on method, URL path and request body hash. Plug it
+// into an http.Client to run provider driver tests offline.
+type SReplayTransport struct {
+	exchanges map[string]*RecordedExchange
+}
+
+func NewReplayTransport(dir string) (*SReplayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "glob %s", dir)
+	}
+	sort.Strings(files)
+	transport := &SReplayTransport{exchanges: make(map[string]*RecordedExchange)}
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", file)
+		}
+		data, err := jsonutils.Parse(content)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", file)
+		}
+		ex, err := parseRecordedExchange(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fixture %s", file)
+		}
+		transport.exchanges[exchangeKey(ex.Method, ex.Path, ex.BodyHash)] = ex
+	}
+	return transport, nil
+}
+
+func (t *SReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bodyHash := ""
+	if req.Body != nil {
+		content, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "read request body")
+		}
+		if len(content) > 0 {
+			// hash the canonical JSON form, matching how the recorder
+			// hashed it
+			body, err := jsonutils.Parse(content)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse request body")
+			}
+			bodyHash = requestBodyHash(body)
+		}
+	}
+	ex, ok := t.exchanges[exchangeKey(req.Method, req.URL.Path, bodyHash)]
+	if !ok {
+		return nil, errors.Wrapf(ErrNoRecordedExchange, "%s %s (body hash %q)", req.Method, req.URL.Path, bodyHash)
+	}
+	respBody := []byte{}
+	if ex.RespBody != nil {
+		respBody = []byte(ex.RespBody.String())
+	}
+	header := http.Header{}
+	for key, values := range ex.RespHdr {
+		header[key] = values
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		StatusCode:    ex.Status,
+		Status:        fmt.Sprintf("%d %s", ex.Status, http.StatusText(ex.Status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
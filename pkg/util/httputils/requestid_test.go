@@ -0,0 +1,136 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"yunion.io/x/onecloud/pkg/appctx"
+)
+
+func newRequestIdEchoServer(seen *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = append(*seen, r.Header.Get("X-Request-Id"))
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+}
+
+func TestRequestIdGeneratedWhenAbsent(t *testing.T) {
+	seen := make([]string, 0)
+	server := newRequestIdEchoServer(&seen)
+	defer server.Close()
+
+	resp, err := Request(nil, context.Background(), GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	defer CloseResponse(resp)
+	if len(seen) != 1 || seen[0] == "" {
+		t.Fatalf("expect generated X-Request-Id on outgoing request, got %v", seen)
+	}
+	if got := resp.Header.Get("X-Request-Id"); got != seen[0] {
+		t.Errorf("response header X-Request-Id = %q, want %q", got, seen[0])
+	}
+}
+
+func TestRequestIdForwardedWithHopCounter(t *testing.T) {
+	seen := make([]string, 0)
+	server := newRequestIdEchoServer(&seen)
+	defer server.Close()
+
+	ctx := context.WithValue(context.Background(), appctx.APP_CONTEXT_KEY_REQUEST_ID, "req-abc")
+	resp, err := Request(nil, ctx, GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	CloseResponse(resp)
+	if len(seen) != 1 || seen[0] != "req-abc#1" {
+		t.Errorf("first hop X-Request-Id = %v, want [req-abc#1]", seen)
+	}
+
+	// the next service in the chain sees req-abc#1 and forwards req-abc#2
+	ctx = context.WithValue(context.Background(), appctx.APP_CONTEXT_KEY_REQUEST_ID, seen[0])
+	resp, err = Request(nil, ctx, GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	CloseResponse(resp)
+	if len(seen) != 2 || seen[1] != "req-abc#2" {
+		t.Errorf("second hop X-Request-Id = %v, want req-abc#2", seen)
+	}
+
+	// a UUID whose final segment is all digits must gain a hop marker,
+	// not have that segment incremented
+	uuid := "550e8400-e29b-41d4-a716-446655440000"
+	ctx = context.WithValue(context.Background(), appctx.APP_CONTEXT_KEY_REQUEST_ID, uuid)
+	resp, err = Request(nil, ctx, GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	CloseResponse(resp)
+	if len(seen) != 3 || seen[2] != uuid+"#1" {
+		t.Errorf("digit-tailed base id X-Request-Id = %v, want %s#1", seen, uuid)
+	}
+}
+
+func TestRequestIdHeaderPreserved(t *testing.T) {
+	seen := make([]string, 0)
+	server := newRequestIdEchoServer(&seen)
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("X-Request-Id", "caller-set-id")
+	resp, err := Request(nil, context.Background(), GET, server.URL, header, nil, false)
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	CloseResponse(resp)
+	if len(seen) != 1 || seen[0] != "caller-set-id" {
+		t.Errorf("X-Request-Id = %v, want [caller-set-id]", seen)
+	}
+}
+
+func TestRequestIdStableAcrossJsonPaths(t *testing.T) {
+	seen := make([]string, 0)
+	server := newRequestIdEchoServer(&seen)
+	defer server.Close()
+
+	ctx := appctx.EnsureRequestId(context.Background())
+	wantId := appctx.ForwardRequestId(appctx.AppContextRequestId(ctx))
+
+	hdr, _, err := JSONRequest(nil, ctx, GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("JSONRequest: %v", err)
+	}
+	if got := hdr.Get("X-Request-Id"); got != wantId {
+		t.Errorf("JSONRequest response X-Request-Id = %q, want %q", got, wantId)
+	}
+
+	client := NewJsonClient(nil)
+	hdr, _, err = client.JSONRequest(ctx, GET, server.URL, nil, nil, false)
+	if err != nil {
+		t.Fatalf("JsonClient.JSONRequest: %v", err)
+	}
+	if got := hdr.Get("X-Request-Id"); got != wantId {
+		t.Errorf("JsonClient response X-Request-Id = %q, want %q", got, wantId)
+	}
+
+	if len(seen) != 2 || seen[0] != wantId || seen[1] != wantId {
+		t.Errorf("outgoing X-Request-Id = %v, want %q on both paths", seen, wantId)
+	}
+}
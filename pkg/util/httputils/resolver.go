@@ -0,0 +1,132 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Resolver maps a hostname to one or more IP addresses. It is
+// consulted before the system resolver when dialing, so split-horizon
+// deployments can redirect service endpoints without /etc/hosts edits.
+// An empty result falls through to the system resolver.
+type Resolver interface {
+	Resolve(host string) []string
+}
+
+type sResolverEntry struct {
+	ips      []string
+	expireAt time.Time // zero means never expires
+}
+
+// SOverrideResolver is a thread-safe Resolver backed by explicit
+// host to IP overrides with an optional per-entry TTL, so the auth
+// module can refresh entries when the service catalog changes
+type SOverrideResolver struct {
+	lock    sync.RWMutex
+	entries map[string]sResolverEntry
+}
+
+func NewOverrideResolver() *SOverrideResolver {
+	return &SOverrideResolver{
+		entries: make(map[string]sResolverEntry),
+	}
+}
+
+// SetOverride installs or replaces the override for host. A ttl of
+// zero or less keeps the entry until it is removed.
+func (r *SOverrideResolver) SetOverride(host string, ips []string, ttl time.Duration) {
+	entry := sResolverEntry{ips: ips}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.entries[host] = entry
+}
+
+func (r *SOverrideResolver) RemoveOverride(host string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, host)
+}
+
+func (r *SOverrideResolver) Resolve(host string) []string {
+	r.lock.RLock()
+	entry, ok := r.entries[host]
+	r.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		r.lock.Lock()
+		// re-check under the write lock, the entry may have been
+		// replaced meanwhile
+		if cur, ok := r.entries[host]; ok && !cur.expireAt.IsZero() && time.Now().After(cur.expireAt) {
+			delete(r.entries, host)
+		}
+		r.lock.Unlock()
+		return nil
+	}
+	return entry.ips
+}
+
+// resolverDialContext wraps a dial function so the connection goes to
+// an IP supplied by the resolver when one matches the target host. Only
+// the dial address is rewritten; the request URL and thus the TLS
+// ServerName keep the original hostname, so certificate validation
+// still works.
+func resolverDialContext(resolver Resolver, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+		ips := resolver.Resolve(host)
+		if len(ips) == 0 {
+			return dial(ctx, network, addr)
+		}
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// GetTransportWithResolver is GetTransport with host resolution
+// overrides consulted before the system resolver
+func GetTransportWithResolver(insecure bool, resolver Resolver) *http.Transport {
+	tr := getTransport(insecure, false)
+	tr.DialContext = resolverDialContext(resolver, tr.DialContext)
+	return tr
+}
+
+// GetClientWithResolver is GetClient with host resolution overrides
+// consulted before the system resolver, see Resolver
+func GetClientWithResolver(insecure bool, timeout time.Duration, resolver Resolver) *http.Client {
+	return &http.Client{
+		Transport: GetTransportWithResolver(insecure, resolver),
+		Timeout:   timeout,
+	}
+}
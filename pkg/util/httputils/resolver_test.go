@@ -0,0 +1,124 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestOverrideResolver(t *testing.T) {
+	r := NewOverrideResolver()
+	if ips := r.Resolve("svc.internal"); ips != nil {
+		t.Errorf("expect miss for unknown host, got %v", ips)
+	}
+
+	r.SetOverride("svc.internal", []string{"10.0.0.1", "10.0.0.2"}, 0)
+	if ips := r.Resolve("svc.internal"); len(ips) != 2 || ips[0] != "10.0.0.1" {
+		t.Errorf("resolve = %v, want the two overridden ips", ips)
+	}
+
+	r.RemoveOverride("svc.internal")
+	if ips := r.Resolve("svc.internal"); ips != nil {
+		t.Errorf("expect miss after removal, got %v", ips)
+	}
+
+	r.SetOverride("svc.internal", []string{"10.0.0.3"}, 10*time.Millisecond)
+	if ips := r.Resolve("svc.internal"); len(ips) != 1 {
+		t.Fatalf("fresh ttl entry must resolve, got %v", ips)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ips := r.Resolve("svc.internal"); ips != nil {
+		t.Errorf("expect ttl expiry, got %v", ips)
+	}
+}
+
+func TestGetClientWithResolver(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "split-horizon")
+	}))
+	defer ts.Close()
+
+	tsUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %v", err)
+	}
+	_, port, err := net.SplitHostPort(tsUrl.Host)
+	if err != nil {
+		t.Fatalf("split test server host: %v", err)
+	}
+
+	resolver := NewOverrideResolver()
+	resolver.SetOverride("svc.internal", []string{"127.0.0.1"}, 0)
+	client := GetClientWithResolver(false, 5*time.Second, resolver)
+
+	resp, err := client.Get(fmt.Sprintf("http://svc.internal:%s/", port))
+	if err != nil {
+		t.Fatalf("get via overridden hostname: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "split-horizon" {
+		t.Errorf("body = %q, want the test server response", body)
+	}
+
+	// without an override the fake hostname must not resolve
+	if _, err := client.Get(fmt.Sprintf("http://no-such-host.internal:%s/", port)); err == nil {
+		t.Errorf("expect dial failure without an override")
+	}
+}
+
+func TestGetClientWithResolverTLSServerName(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer ts.Close()
+
+	tsUrl, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("parse test server url: %v", err)
+	}
+	_, port, err := net.SplitHostPort(tsUrl.Host)
+	if err != nil {
+		t.Fatalf("split test server host: %v", err)
+	}
+
+	// the httptest certificate is valid for example.com; dialing the
+	// overridden 127.0.0.1 address must still validate against that
+	// hostname
+	resolver := NewOverrideResolver()
+	resolver.SetOverride("example.com", []string{"127.0.0.1"}, 0)
+	client := GetClientWithResolver(false, 5*time.Second, resolver)
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+
+	resp, err := client.Get(fmt.Sprintf("https://example.com:%s/", port))
+	if err != nil {
+		t.Fatalf("tls get via overridden hostname: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "secure" {
+		t.Errorf("body = %q, want the test server response", body)
+	}
+}
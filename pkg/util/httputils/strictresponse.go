@@ -0,0 +1,109 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"yunion.io/x/jsonutils"
+)
+
+// validateStrictJSONBody checks that a successful response body really
+// is JSON: the Content-Type must be application/json, an empty body is
+// only acceptable when the caller does not expect one, and the body
+// must parse to a JSON object or array. A violation yields a
+// JSONClientError of class InvalidResponse carrying the raw body.
+func validateStrictJSONBody(contentType string, rbody []byte, expectBody bool) *JSONClientError {
+	newErr := func(details string) *JSONClientError {
+		return &JSONClientError{
+			Class:   "InvalidResponse",
+			Details: details,
+			RawBody: rbody,
+		}
+	}
+	if len(rbody) == 0 {
+		if expectBody {
+			return newErr("empty response body")
+		}
+		return nil
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	if !strings.EqualFold(mediaType, "application/json") {
+		return newErr(fmt.Sprintf("unexpected Content-Type %q", contentType))
+	}
+	jrbody, err := jsonutils.Parse(rbody)
+	if err != nil {
+		return newErr(fmt.Sprintf("invalid json: %v", err))
+	}
+	switch jrbody.(type) {
+	case *jsonutils.JSONDict, *jsonutils.JSONArray:
+		return nil
+	default:
+		return newErr("response is not a JSON object or array")
+	}
+}
+
+// ParseJSONResponseStrict is the strict variant of ParseJSONResponse:
+// a 2xx response that is not well-formed JSON fails with class
+// InvalidResponse instead of being silently treated as an empty body.
+// Error responses are handled exactly as ParseJSONResponse does.
+func ParseJSONResponseStrict(resp *http.Response, err error, debug bool, expectBody bool) (http.Header, jsonutils.JSONObject, error) {
+	if err != nil || resp == nil || resp.StatusCode >= 300 {
+		return ParseJSONResponse(resp, err, debug)
+	}
+	defer CloseResponse(resp)
+	if debug {
+		dump, _ := httputil.DumpResponse(resp, false)
+		green(string(dump))
+	}
+	rbody, rerr := ioutil.ReadAll(resp.Body)
+	if rerr != nil {
+		return nil, nil, fmt.Errorf("Fail to read body: %s", rerr)
+	}
+	if ce := validateStrictJSONBody(resp.Header.Get("Content-Type"), rbody, expectBody); ce != nil {
+		ce.Code = resp.StatusCode
+		return resp.Header, nil, ce
+	}
+	if len(rbody) == 0 {
+		return resp.Header, nil, nil
+	}
+	jrbody, perr := jsonutils.Parse(rbody)
+	if perr != nil {
+		// cannot happen after validation, keep the guard anyway
+		ce := validateStrictJSONBody("application/json", nil, true)
+		ce.Code = resp.StatusCode
+		ce.RawBody = rbody
+		return resp.Header, nil, ce
+	}
+	return resp.Header, jrbody, nil
+}
+
+// JSONRequestStrict behaves like JSONRequest but validates that a
+// successful response really carries JSON, so an upstream answering
+// 200 with an HTML error page surfaces as an error instead of an empty
+// result. Set expectBody when the caller requires a non-empty body.
+func JSONRequestStrict(client *http.Client, ctx context.Context, method THttpMethod, urlStr string, header http.Header, body jsonutils.JSONObject, debug bool, expectBody bool) (http.Header, jsonutils.JSONObject, error) {
+	resp, err := jsonRequest(client, ctx, method, urlStr, header, body, debug)
+	hdr, jbody, err := ParseJSONResponseStrict(resp, err, debug, expectBody)
+	if err != nil && debug {
+		attachRequestBody(err, body)
+	}
+	return hdr, jbody, err
+}
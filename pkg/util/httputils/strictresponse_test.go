@@ -0,0 +1,110 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func strictTestServer(contentType string, body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(contentType) > 0 {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.WriteHeader(200)
+		w.Write([]byte(body))
+	}))
+}
+
+func TestJSONRequestStrictHtmlBody(t *testing.T) {
+	htmlBody := "<html><body>Bad Gateway</body></html>"
+	svr := strictTestServer("text/html", htmlBody)
+	defer svr.Close()
+
+	_, _, err := JSONRequestStrict(nil, context.Background(), "GET", svr.URL, nil, nil, false, true)
+	if err == nil {
+		t.Fatalf("expect error for html body")
+	}
+	ce, ok := err.(*JSONClientError)
+	if !ok {
+		t.Fatalf("expect JSONClientError, got %T", err)
+	}
+	if ce.Class != "InvalidResponse" {
+		t.Errorf("expect class InvalidResponse, got %q", ce.Class)
+	}
+	if string(ce.RawBody) != htmlBody {
+		t.Errorf("expect raw body on error, got %q", string(ce.RawBody))
+	}
+
+	// default JSONRequest stays lenient
+	_, body, err := JSONRequest(nil, context.Background(), "GET", svr.URL, nil, nil, false)
+	if err != nil {
+		t.Errorf("lenient request should succeed: %v", err)
+	}
+	if body != nil {
+		t.Errorf("lenient request should yield empty body, got %v", body)
+	}
+}
+
+func TestJSONRequestStrictEmptyBody(t *testing.T) {
+	svr := strictTestServer("application/json", "")
+	defer svr.Close()
+
+	if _, _, err := JSONRequestStrict(nil, context.Background(), "GET", svr.URL, nil, nil, false, true); err == nil {
+		t.Errorf("expect error for empty body when a body is expected")
+	}
+	if _, _, err := JSONRequestStrict(nil, context.Background(), "GET", svr.URL, nil, nil, false, false); err != nil {
+		t.Errorf("empty body without expectation should pass: %v", err)
+	}
+}
+
+func TestJSONRequestStrictJsonArray(t *testing.T) {
+	svr := strictTestServer("application/json", `[{"id": "a"}, {"id": "b"}]`)
+	defer svr.Close()
+
+	_, body, err := JSONRequestStrict(nil, context.Background(), "GET", svr.URL, nil, nil, false, true)
+	if err != nil {
+		t.Fatalf("json array body should pass: %v", err)
+	}
+	arr, err := body.GetArray()
+	if err != nil || len(arr) != 2 {
+		t.Errorf("expect parsed array of 2, got %v (%v)", body, err)
+	}
+}
+
+func TestJSONRequestStrictJsonObject(t *testing.T) {
+	svr := strictTestServer("application/json; charset=utf-8", `{"status": "ok"}`)
+	defer svr.Close()
+
+	_, body, err := JSONRequestStrict(nil, context.Background(), "GET", svr.URL, nil, nil, false, true)
+	if err != nil {
+		t.Fatalf("json object body should pass: %v", err)
+	}
+	if status, _ := body.GetString("status"); status != "ok" {
+		t.Errorf("expect status ok, got %v", body)
+	}
+}
+
+func TestValidateStrictJSONBodyScalar(t *testing.T) {
+	if ce := validateStrictJSONBody("application/json", []byte(`"just a string"`), true); ce == nil {
+		t.Errorf("expect error for scalar json body")
+	} else if !strings.Contains(ce.Details, "not a JSON object or array") {
+		t.Errorf("unexpected details %q", ce.Details)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"yunion.io/x/jsonutils"
+)
+
+// ThrottledErrorClass is the JSONClientError class of responses that
+// signal server-side backpressure, either by a 429 status or by a
+// provider-specific throttle error code in the body
+const ThrottledErrorClass = "TooManyRequests"
+
+// throttleErrorClasses are body error codes providers use to signal
+// throttling even when the HTTP status is not 429, e.g. aliyun's
+// "Throttling.User"
+var throttleErrorClasses = []string{
+	"Throttling",
+	"RequestLimitExceeded",
+	"TooManyRequests",
+	"QuotaExceeded.Request",
+}
+
+func isThrottleClass(class string) bool {
+	for _, throttle := range throttleErrorClasses {
+		if class == throttle || strings.HasPrefix(class, throttle+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter interprets a Retry-After header value, either a
+// delay in seconds or an HTTP date, returning zero when absent or
+// unparseable
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// markThrottled normalizes a throttled error into the TooManyRequests
+// class with the server-requested wait, so callers can tell
+// backpressure from real failure
+func markThrottled(ce *JSONClientError, header http.Header) {
+	if ce.Code != 429 && !isThrottleClass(ce.Class) {
+		return
+	}
+	ce.Code = 429
+	ce.Class = ThrottledErrorClass
+	if wait := parseRetryAfter(header.Get("Retry-After"), time.Now()); wait > 0 {
+		ce.RetryAfter = wait
+	}
+}
+
+// IsThrottled reports whether err is a backpressure signal from the
+// server rather than a real failure
+func IsThrottled(err error) bool {
+	if jce, ok := err.(*JSONClientError); ok {
+		return jce.Class == ThrottledErrorClass || jce.Code == 429
+	}
+	return false
+}
+
+// GetRetryAfter returns the wait the server asked for before retrying,
+// zero when err carries none
+func GetRetryAfter(err error) time.Duration {
+	if jce, ok := err.(*JSONClientError); ok {
+		return jce.RetryAfter
+	}
+	return 0
+}
+
+// isRetriableError reports whether a request may be retried verbatim:
+// throttled responses and server-side failures qualify, other client
+// errors do not
+func isRetriableError(err error) bool {
+	if IsThrottled(err) {
+		return true
+	}
+	code := ErrorCode(err)
+	return code >= 500 && code < 600
+}
+
+// RetryMiddleware retries throttled and server-side failures up to
+// maxRetries times with exponential backoff starting at baseInterval.
+// When the server supplies a Retry-After wait, that wait is honored
+// instead of the computed backoff.
+func RetryMiddleware(maxRetries int, baseInterval time.Duration) Middleware {
+	return func(next RoundFunc) RoundFunc {
+		return func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+			for attempt := 0; ; attempt++ {
+				hdr, body, err := next(ctx, req)
+				if err == nil || attempt >= maxRetries || !isRetriableError(err) {
+					return hdr, body, err
+				}
+				wait := baseInterval << uint(attempt)
+				if after := GetRetryAfter(err); after > 0 {
+					wait = after
+				}
+				select {
+				case <-ctx.Done():
+					return hdr, body, err
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"yunion.io/x/jsonutils"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"absent", "", 0},
+		{"seconds", "120", 120 * time.Second},
+		{"zero seconds", "0", 0},
+		{"negative seconds", "-5", 0},
+		{"http date", now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{"past http date", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"garbage", "later", 0},
+	}
+	for _, c := range cases {
+		if got := parseRetryAfter(c.value, now); got != c.want {
+			t.Errorf("%s: parseRetryAfter(%q) = %v, want %v", c.name, c.value, got, c.want)
+		}
+	}
+}
+
+func TestMarkThrottled(t *testing.T) {
+	// a plain 429 is normalized and picks up Retry-After
+	ce := JSONClientError{Code: 429, Details: "too many requests"}
+	header := http.Header{}
+	header.Set("Retry-After", "7")
+	markThrottled(&ce, header)
+	if ce.Class != ThrottledErrorClass {
+		t.Errorf("class = %q, want %q", ce.Class, ThrottledErrorClass)
+	}
+	if ce.RetryAfter != 7*time.Second {
+		t.Errorf("retry after = %v, want 7s", ce.RetryAfter)
+	}
+
+	// a provider throttle class is recognized without a 429 status
+	ce = JSONClientError{Code: 400, Class: "Throttling.User", Details: "Request was denied due to user flow control"}
+	markThrottled(&ce, http.Header{})
+	if ce.Code != 429 || ce.Class != ThrottledErrorClass {
+		t.Errorf("aliyun throttle not normalized: %+v", ce)
+	}
+
+	// ordinary client errors are untouched
+	ce = JSONClientError{Code: 404, Class: "NotFoundError", Details: "no such resource"}
+	markThrottled(&ce, http.Header{})
+	if ce.Code != 404 || ce.Class != "NotFoundError" {
+		t.Errorf("non-throttle error modified: %+v", ce)
+	}
+}
+
+func TestParseJSONResponseThrottled(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "3")
+	resp := &http.Response{
+		StatusCode: 429,
+		Status:     "429 Too Many Requests",
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	_, _, err := ParseJSONResponse(resp, nil, false)
+	if err == nil {
+		t.Fatalf("expect error from 429 response")
+	}
+	if !IsThrottled(err) {
+		t.Errorf("expect IsThrottled, got %v", err)
+	}
+	if got := GetRetryAfter(err); got != 3*time.Second {
+		t.Errorf("GetRetryAfter = %v, want 3s", got)
+	}
+	if IsThrottled(NewJsonClientError(404, "NotFoundError", "missing")) {
+		t.Errorf("404 must not be throttled")
+	}
+}
+
+func TestRetryMiddlewareHonorsRetryAfter(t *testing.T) {
+	attempts := 0
+	waits := make([]time.Time, 0)
+	round := func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+		attempts++
+		waits = append(waits, time.Now())
+		if attempts < 3 {
+			return nil, nil, &JSONClientError{
+				Code:       429,
+				Class:      ThrottledErrorClass,
+				RetryAfter: 20 * time.Millisecond,
+			}
+		}
+		return nil, jsonutils.NewDict(), nil
+	}
+	wrapped := RetryMiddleware(5, time.Millisecond)(round)
+	_, _, err := wrapped(context.Background(), &JsonRequest{})
+	if err != nil {
+		t.Fatalf("expect success after retries, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	for i := 1; i < len(waits); i++ {
+		if gap := waits[i].Sub(waits[i-1]); gap < 20*time.Millisecond {
+			t.Errorf("retry %d waited %v, want at least the server-requested 20ms", i, gap)
+		}
+	}
+
+	// client errors are not retried
+	attempts = 0
+	round = func(ctx context.Context, req *JsonRequest) (http.Header, jsonutils.JSONObject, error) {
+		attempts++
+		return nil, nil, NewJsonClientError(400, "BadRequestError", "bad input")
+	}
+	if _, _, err := RetryMiddleware(5, time.Millisecond)(round)(context.Background(), &JsonRequest{}); err == nil {
+		t.Fatalf("expect error passthrough")
+	}
+	if attempts != 1 {
+		t.Errorf("client error retried %d times, want 1 attempt", attempts)
+	}
+}
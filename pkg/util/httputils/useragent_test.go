@@ -0,0 +1,117 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestComposeUserAgent(t *testing.T) {
+	cases := []struct {
+		base    string
+		service string
+		ver     string
+		want    string
+	}{
+		{"yunioncloud-go/3.x", "region", "abc123", "yunioncloud-go/3.x region/abc123"},
+		{"yunioncloud-go/3.x", "region", "", "yunioncloud-go/3.x region"},
+		{"yunioncloud-go/3.x", "", "abc123", "yunioncloud-go/3.x"},
+		{"", "region", "abc123", "region/abc123"},
+	}
+	for _, c := range cases {
+		if got := composeUserAgent(c.base, c.service, c.ver); got != c.want {
+			t.Errorf("composeUserAgent(%q, %q, %q) = %q, want %q", c.base, c.service, c.ver, got, c.want)
+		}
+	}
+}
+
+// newUAEchoServer returns a test server recording the User-Agent of
+// the last request it served
+func newUAEchoServer(lastUA *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*lastUA = r.Header.Get("User-Agent")
+		appendJsonBody(w, `{"ok": true}`)
+	}))
+}
+
+func appendJsonBody(w http.ResponseWriter, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(body))
+}
+
+func TestUserAgentPrecedence(t *testing.T) {
+	var lastUA string
+	ts := newUAEchoServer(&lastUA)
+	defer ts.Close()
+
+	ctx := context.Background()
+
+	// package default applies when nothing else is set
+	if _, err := Request(nil, ctx, GET, ts.URL, nil, nil, false); err != nil {
+		t.Fatalf("plain request: %v", err)
+	}
+	if lastUA != getDefaultUserAgent() {
+		t.Errorf("package default UA = %q, want %q", lastUA, getDefaultUserAgent())
+	}
+
+	// SetDefaultUserAgent replaces the package default base
+	SetDefaultUserAgent("custom-base/1.0")
+	defer SetDefaultUserAgent("")
+	if _, err := Request(nil, ctx, GET, ts.URL, nil, nil, false); err != nil {
+		t.Fatalf("request with custom default: %v", err)
+	}
+	if lastUA != getDefaultUserAgent() || lastUA == USER_AGENT {
+		t.Errorf("custom default UA = %q, want %q", lastUA, getDefaultUserAgent())
+	}
+
+	// client default wins over the package default
+	client := NewJsonClient(nil, WithUserAgent("client-ua/1"))
+	if _, _, err := client.JSONRequest(ctx, GET, ts.URL, nil, nil, false); err != nil {
+		t.Fatalf("client request: %v", err)
+	}
+	if lastUA != "client-ua/1" {
+		t.Errorf("client UA = %q, want client-ua/1", lastUA)
+	}
+
+	// per-request override wins over the client default
+	if _, _, err := client.Send(ctx, &JsonRequest{
+		Method:    GET,
+		Url:       ts.URL,
+		UserAgent: "request-ua/2",
+	}, false); err != nil {
+		t.Fatalf("request with override: %v", err)
+	}
+	if lastUA != "request-ua/2" {
+		t.Errorf("request override UA = %q, want request-ua/2", lastUA)
+	}
+
+	// an explicit header beats everything
+	hdr := http.Header{}
+	hdr.Set("User-Agent", "explicit-ua/3")
+	if _, _, err := client.Send(ctx, &JsonRequest{
+		Method:    GET,
+		Url:       ts.URL,
+		Header:    hdr,
+		UserAgent: "request-ua/2",
+	}, false); err != nil {
+		t.Fatalf("request with explicit header: %v", err)
+	}
+	if lastUA != "explicit-ua/3" {
+		t.Errorf("explicit header UA = %q, want explicit-ua/3", lastUA)
+	}
+}
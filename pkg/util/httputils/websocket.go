@@ -0,0 +1,130 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// SWebsocketConn is a minimal frame reader/writer over an upgraded
+// websocket connection: pings from the peer are answered automatically
+// and every read or write refreshes the idle deadline
+type SWebsocketConn struct {
+	conn        *websocket.Conn
+	idleTimeout time.Duration
+}
+
+func wsScheme(urlStr string) string {
+	if strings.HasPrefix(urlStr, "https://") {
+		return "wss://" + urlStr[len("https://"):]
+	}
+	if strings.HasPrefix(urlStr, "http://") {
+		return "ws://" + urlStr[len("http://"):]
+	}
+	return urlStr
+}
+
+// DialWebsocket performs the websocket upgrade handshake against
+// urlStr (http(s) or ws(s) scheme), using the same TLS, proxy and dial
+// timeout settings as the clients this package builds and stamping the
+// X-Request-Id and trace headers the way Request does. A zero
+// idleTimeout disables the idle deadline.
+func DialWebsocket(ctx context.Context, urlStr string, header http.Header, insecure bool, idleTimeout time.Duration) (*SWebsocketConn, error) {
+	ctx = ensureContext(ctx)
+	if header == nil {
+		header = http.Header{}
+	}
+	urlStr = wsScheme(urlStr)
+	clientTrace, _, err := injectRequestHeaders(ctx, urlStr, header)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &websocket.Dialer{
+		Proxy:            transportProxy,
+		HandshakeTimeout: TLSHandshakeTimeout * time.Second,
+		TLSClientConfig:  &tls.Config{InsecureSkipVerify: insecure},
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, network, addr)
+		},
+	}
+	conn, resp, err := dialer.DialContext(ctx, urlStr, header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "dial websocket %s", urlStr)
+	}
+	if clientTrace != nil {
+		clientTrace.EndClientTraceHeader(resp.Header)
+	}
+	wsconn := &SWebsocketConn{
+		conn:        conn,
+		idleTimeout: idleTimeout,
+	}
+	conn.SetPongHandler(func(string) error {
+		wsconn.refreshReadDeadline()
+		return nil
+	})
+	return wsconn, nil
+}
+
+func (conn *SWebsocketConn) refreshReadDeadline() {
+	if conn.idleTimeout > 0 {
+		conn.conn.SetReadDeadline(time.Now().Add(conn.idleTimeout))
+	}
+}
+
+// ReadMessage returns the next data frame from the peer, answering
+// control frames along the way
+func (conn *SWebsocketConn) ReadMessage() (int, []byte, error) {
+	conn.refreshReadDeadline()
+	return conn.conn.ReadMessage()
+}
+
+func (conn *SWebsocketConn) WriteMessage(messageType int, data []byte) error {
+	if conn.idleTimeout > 0 {
+		conn.conn.SetWriteDeadline(time.Now().Add(conn.idleTimeout))
+	}
+	return conn.conn.WriteMessage(messageType, data)
+}
+
+// Ping probes the peer; a pong in response refreshes the idle deadline
+func (conn *SWebsocketConn) Ping() error {
+	return conn.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+}
+
+// Close sends a close frame so the peer sees a clean shutdown, then
+// tears the connection down
+func (conn *SWebsocketConn) Close() error {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	conn.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	return conn.conn.Close()
+}
+
+// LocalAddr reports the local endpoint of the underlying connection
+func (conn *SWebsocketConn) LocalAddr() net.Addr {
+	return conn.conn.LocalAddr()
+}
+
+// RemoteAddr reports the peer endpoint of the underlying connection
+func (conn *SWebsocketConn) RemoteAddr() net.Addr {
+	return conn.conn.RemoteAddr()
+}
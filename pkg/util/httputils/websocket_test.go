@@ -0,0 +1,110 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoWebsocketServer upgrades every request and echoes data frames,
+// recording the headers of the upgrade request
+func echoWebsocketServer(headers chan<- http.Header) http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func TestDialWebsocket(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(echoWebsocketServer(headers))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("X-Auth-Token", "test-token")
+	conn, err := DialWebsocket(context.Background(), server.URL, header, false, 10*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// the upgrade request carries the stamped and the caller's headers
+	upgradeHeader := <-headers
+	if len(upgradeHeader.Get("X-Request-Id")) == 0 {
+		t.Errorf("upgrade request must carry X-Request-Id")
+	}
+	if upgradeHeader.Get("X-Auth-Token") != "test-token" {
+		t.Errorf("caller header not propagated, got %q", upgradeHeader.Get("X-Auth-Token"))
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	messageType, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if messageType != websocket.TextMessage || string(data) != "hello" {
+		t.Errorf("echo = %d %q", messageType, data)
+	}
+	if err := conn.Ping(); err != nil {
+		t.Errorf("ping: %v", err)
+	}
+}
+
+func TestDialWebsocketTLSInsecure(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewTLSServer(echoWebsocketServer(headers))
+	defer server.Close()
+
+	// the self-signed certificate only passes in insecure mode
+	if _, err := DialWebsocket(context.Background(), server.URL, nil, false, 0); err == nil {
+		t.Errorf("expect certificate error without insecure")
+	}
+	conn, err := DialWebsocket(context.Background(), server.URL, nil, true, 0)
+	if err != nil {
+		t.Fatalf("dial insecure: %v", err)
+	}
+	defer conn.Close()
+	// the failed dial never reached the handler, only the insecure one
+	<-headers
+
+	if err := conn.WriteMessage(websocket.BinaryMessage, []byte{1, 2, 3}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || len(data) != 3 {
+		t.Errorf("echo over tls = %v %v", data, err)
+	}
+}
@@ -81,8 +81,28 @@ func (db *SInfluxdb) Write(data string, precision string) error {
 }
 
 func (db *SInfluxdb) Query(sql string) ([][]dbResult, error) {
+	return db.QueryContext(context.Background(), sql)
+}
+
+// QueryContext is the context-aware variant of Query. Cancelling ctx
+// aborts the in-flight HTTP request to influxdb instead of leaving it
+// running after the caller has given up.
+func (db *SInfluxdb) QueryContext(ctx context.Context, sql string) ([][]dbResult, error) {
+	return db.QueryContextOn(ctx, "", "", sql)
+}
+
+// QueryContextOn runs sql against the named database; epoch selects the
+// timestamp precision of returned points (e.g. "s", "ms"). Both may be
+// empty for statements that name the database themselves.
+func (db *SInfluxdb) QueryContextOn(ctx context.Context, dbName string, epoch string, sql string) ([][]dbResult, error) {
 	nurl := fmt.Sprintf("%s/query?q=%s", db.accessUrl, url.QueryEscape(sql))
-	_, body, err := httputils.JSONRequest(db.client, context.Background(), "POST", nurl, nil, nil, db.debug)
+	if len(dbName) > 0 {
+		nurl = fmt.Sprintf("%s&db=%s", nurl, url.QueryEscape(dbName))
+	}
+	if len(epoch) > 0 {
+		nurl = fmt.Sprintf("%s&epoch=%s", nurl, url.QueryEscape(epoch))
+	}
+	_, body, err := httputils.JSONRequest(db.client, ctx, "POST", nurl, nil, nil, db.debug)
 	if err != nil {
 		return nil, err
 	}
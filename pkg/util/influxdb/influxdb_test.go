@@ -14,6 +14,47 @@
 
 package influxdb
 
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueryContextCancellation(t *testing.T) {
+	started := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(30 * time.Second):
+		}
+	}))
+	defer svr.Close()
+
+	db := NewInfluxdb(svr.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.QueryContext(ctx, "SHOW DATABASES")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expect an error from a cancelled query")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cancelled query did not return promptly")
+	}
+}
+
 // TODO: rewrite this test
 /*
 import (
@@ -0,0 +1,209 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"yunion.io/x/pkg/errors"
+)
+
+// qlToken is a bare word of an influxql statement found outside quoted
+// strings, recorded with its byte offset so clauses can be rewritten in
+// place.
+type qlToken struct {
+	start int
+	word  string
+}
+
+// tokenizeTopLevel extracts word tokens from ql, skipping the contents
+// of single and double quoted strings so quoted values can never be
+// mistaken for keywords. Inside single-quoted strings a backslash
+// escapes the following character, matching influxql's \' escape, so
+// an escaped quote does not terminate the literal early.
+func tokenizeTopLevel(ql string) []qlToken {
+	tokens := make([]qlToken, 0)
+	var quote rune
+	escaped := false
+	wordStart := -1
+	for i, c := range ql {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+				continue
+			}
+			if quote == '\'' && c == '\\' {
+				escaped = true
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		if c == '\'' || c == '"' {
+			quote = c
+			if wordStart >= 0 {
+				tokens = append(tokens, qlToken{wordStart, strings.ToLower(ql[wordStart:i])})
+				wordStart = -1
+			}
+			continue
+		}
+		if unicode.IsLetter(c) || c == '_' || (wordStart >= 0 && unicode.IsDigit(c)) {
+			if wordStart < 0 {
+				wordStart = i
+			}
+			continue
+		}
+		if wordStart >= 0 {
+			tokens = append(tokens, qlToken{wordStart, strings.ToLower(ql[wordStart:i])})
+			wordStart = -1
+		}
+	}
+	if wordStart >= 0 {
+		tokens = append(tokens, qlToken{wordStart, strings.ToLower(ql[wordStart:])})
+	}
+	return tokens
+}
+
+// splitStatements splits ql on semicolons that are not inside quoted
+// strings, honoring the same \' escape inside single-quoted strings as
+// tokenizeTopLevel.
+func splitStatements(ql string) []string {
+	stmts := make([]string, 0)
+	var quote rune
+	escaped := false
+	start := 0
+	for i, c := range ql {
+		if quote != 0 {
+			if escaped {
+				escaped = false
+				continue
+			}
+			if quote == '\'' && c == '\\' {
+				escaped = true
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case ';':
+			stmts = append(stmts, ql[start:i])
+			start = i + 1
+		}
+	}
+	stmts = append(stmts, ql[start:])
+	ret := make([]string, 0, len(stmts))
+	for _, stmt := range stmts {
+		if len(strings.TrimSpace(stmt)) > 0 {
+			ret = append(ret, stmt)
+		}
+	}
+	return ret
+}
+
+// ValidateReadOnlyQL rejects ql unless it is a single SELECT or SHOW
+// statement, so write and admin statements (DROP, DELETE, CREATE, ...)
+// can never reach influxdb through ad-hoc query endpoints.
+func ValidateReadOnlyQL(ql string) error {
+	stmts := splitStatements(ql)
+	if len(stmts) == 0 {
+		return errors.Error("empty query")
+	}
+	if len(stmts) > 1 {
+		return errors.Error("multiple statements are not allowed")
+	}
+	tokens := tokenizeTopLevel(stmts[0])
+	if len(tokens) == 0 {
+		return errors.Error("empty query")
+	}
+	switch tokens[0].word {
+	case "select", "show":
+		return nil
+	default:
+		return errors.Error(fmt.Sprintf("statement %q is not allowed, only SELECT and SHOW are", strings.ToUpper(tokens[0].word)))
+	}
+}
+
+// tailClauseKeywords are the clauses that may follow a WHERE clause;
+// the first of them marks where the WHERE clause ends.
+var tailClauseKeywords = map[string]bool{
+	"group":   true,
+	"order":   true,
+	"limit":   true,
+	"slimit":  true,
+	"offset":  true,
+	"soffset": true,
+	"tz":      true,
+}
+
+// EnsureLimit appends a LIMIT clause when ql does not already carry one,
+// capping the points an ad-hoc query may return.
+func EnsureLimit(ql string, limit int) string {
+	for _, tok := range tokenizeTopLevel(ql) {
+		if tok.word == "limit" {
+			return ql
+		}
+	}
+	return fmt.Sprintf("%s LIMIT %d", strings.TrimRight(strings.TrimSpace(ql), ";"), limit)
+}
+
+// InjectTagCondition rewrites ql so the result set is restricted to
+// rows whose tag equals value. An existing WHERE clause is wrapped in
+// parentheses before the condition is ANDed on, so OR expressions
+// cannot escape the restriction.
+func InjectTagCondition(ql string, tag string, value string) string {
+	// backslashes are escaped first so a trailing '\' cannot escape the
+	// closing quote of the rendered string literal
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "'", `\'`)
+	cond := fmt.Sprintf("%s = '%s'", tag, value)
+	tokens := tokenizeTopLevel(ql)
+	whereIdx := -1
+	for i, tok := range tokens {
+		if tok.word == "where" {
+			whereIdx = i
+			break
+		}
+	}
+	if whereIdx < 0 {
+		// no WHERE clause: insert one before the first tail clause,
+		// or append when there is none
+		for _, tok := range tokens {
+			if tailClauseKeywords[tok.word] {
+				return fmt.Sprintf("%sWHERE %s %s", ql[:tok.start], cond, ql[tok.start:])
+			}
+		}
+		return fmt.Sprintf("%s WHERE %s", strings.TrimSpace(ql), cond)
+	}
+	whereTok := tokens[whereIdx]
+	exprStart := whereTok.start + len("where")
+	exprEnd := len(ql)
+	for _, tok := range tokens[whereIdx+1:] {
+		if tailClauseKeywords[tok.word] {
+			exprEnd = tok.start
+			break
+		}
+	}
+	expr := strings.TrimSpace(ql[exprStart:exprEnd])
+	return fmt.Sprintf("%sWHERE (%s) AND %s %s", ql[:whereTok.start], expr, cond, ql[exprEnd:])
+}
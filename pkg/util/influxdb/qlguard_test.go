@@ -0,0 +1,113 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package influxdb
+
+import "testing"
+
+func TestValidateReadOnlyQL(t *testing.T) {
+	cases := []struct {
+		name    string
+		ql      string
+		wantErr bool
+	}{
+		{"plain select", "SELECT * FROM cpu", false},
+		{"show measurements", "  show measurements ", false},
+		{"drop rejected", "DROP DATABASE telegraf", true},
+		{"delete rejected", "DELETE FROM cpu", true},
+		{"create rejected", "CREATE DATABASE x", true},
+		{"piggybacked drop rejected", "SELECT * FROM cpu; DROP DATABASE telegraf", true},
+		{"piggybacked with trailing semicolon", "SELECT 1;; DELETE FROM cpu;", true},
+		{"semicolon inside quotes is fine", "SELECT * FROM cpu WHERE host = 'a;b'", false},
+		{"escaped quote keeps semicolon inside literal", `SELECT * FROM cpu WHERE host = 'a\';b'`, false},
+		{"drop behind escaped quote still rejected", `SELECT * FROM cpu WHERE host = 'a\''; DROP DATABASE telegraf`, true},
+		{"quoted keyword is no statement", "'DROP' FROM cpu", true},
+		{"empty", "   ", true},
+		{"only semicolons", " ; ; ", true},
+	}
+	for _, c := range cases {
+		err := ValidateReadOnlyQL(c.ql)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: ValidateReadOnlyQL(%q) error = %v, wantErr %v", c.name, c.ql, err, c.wantErr)
+		}
+	}
+}
+
+func TestEnsureLimit(t *testing.T) {
+	cases := []struct {
+		ql   string
+		want string
+	}{
+		{"SELECT * FROM cpu", "SELECT * FROM cpu LIMIT 100"},
+		{"SELECT * FROM cpu LIMIT 5", "SELECT * FROM cpu LIMIT 5"},
+		{"SELECT * FROM cpu WHERE host = 'limit box' ", "SELECT * FROM cpu WHERE host = 'limit box' LIMIT 100"},
+		// an escaped quote must not terminate the literal, so the
+		// embedded "limit" stays inside the string and the cap is
+		// still appended
+		{`SELECT * FROM cpu WHERE host = 'a\' limit b'`, `SELECT * FROM cpu WHERE host = 'a\' limit b' LIMIT 100`},
+	}
+	for _, c := range cases {
+		if got := EnsureLimit(c.ql, 100); got != c.want {
+			t.Errorf("EnsureLimit(%q) = %q, want %q", c.ql, got, c.want)
+		}
+	}
+}
+
+func TestInjectTagCondition(t *testing.T) {
+	cases := []struct {
+		name string
+		ql   string
+		want string
+	}{
+		{
+			"no where clause",
+			"SELECT * FROM cpu",
+			"SELECT * FROM cpu WHERE tenant_id = 'p1'",
+		},
+		{
+			"no where with group by",
+			"SELECT mean(usage) FROM cpu GROUP BY host",
+			"SELECT mean(usage) FROM cpu WHERE tenant_id = 'p1' GROUP BY host",
+		},
+		{
+			"existing where gets wrapped",
+			"SELECT * FROM cpu WHERE host = 'a' OR host = 'b' LIMIT 10",
+			"SELECT * FROM cpu WHERE (host = 'a' OR host = 'b') AND tenant_id = 'p1' LIMIT 10",
+		},
+		{
+			"where at end of statement",
+			"SELECT * FROM cpu WHERE usage > 1",
+			"SELECT * FROM cpu WHERE (usage > 1) AND tenant_id = 'p1' ",
+		},
+	}
+	for _, c := range cases {
+		if got := InjectTagCondition(c.ql, "tenant_id", "p1"); got != c.want {
+			t.Errorf("%s: InjectTagCondition(%q) = %q, want %q", c.name, c.ql, got, c.want)
+		}
+	}
+
+	// quotes and backslashes in the value must not break out of the
+	// rendered string literal; a trailing backslash in particular must
+	// not escape the closing quote
+	got := InjectTagCondition("SELECT * FROM cpu", "tenant_id", `o'brien\`)
+	want := `SELECT * FROM cpu WHERE tenant_id = 'o\'brien\\'`
+	if got != want {
+		t.Errorf("escaped value: InjectTagCondition = %q, want %q", got, want)
+	}
+	// the guard's own escaped output must still be parseable by
+	// EnsureLimit
+	if capped := EnsureLimit(got, 100); capped != want+" LIMIT 100" {
+		t.Errorf("EnsureLimit(%q) = %q, want the cap appended", got, capped)
+	}
+}